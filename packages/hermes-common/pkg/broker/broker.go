@@ -0,0 +1,231 @@
+// Package broker carries events between the hermes services over whichever
+// message broker a deployment already runs: NATS JetStream, Kafka, RabbitMQ,
+// Redis Streams or AWS SQS. hermes-hooks and hermes-core publish, hermes-worker
+// consumes.
+//
+// Kafka has no redelivery, so a retried event is published again at the tail
+// of its partition. Events published for the same relay after it run before
+// the retry does, even for relays with max_concurrency 1. Use another backend,
+// or a FIFO queue on SQS, where a relay's events must run in order through retries
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+// Backends BROKER selects
+const (
+	KindNATS     = "nats"
+	KindKafka    = "kafka"
+	KindRabbitMQ = "rabbitmq"
+	KindRedis    = "redis"
+	KindSQS      = "sqs"
+)
+
+var Kinds = []string{KindNATS, KindKafka, KindRabbitMQ, KindRedis, KindSQS}
+
+// Names shared by every backend: the event stream (a JetStream stream, Kafka
+// topic, RabbitMQ queue or Redis stream; SQS uses the queue URL names) and the
+// group of workers reading it
+const (
+	eventStream = "hermes.events"
+	workerGroup = "hermes-workers"
+)
+
+type Config struct {
+	Kind string
+	// nats:// URL, comma separated Kafka host:port brokers, amqp:// URL,
+	// redis:// URL or SQS queue URL, depending on Kind
+	URL string
+	// SQS only: the credentials requests are signed with
+	AWS sigv4.Credentials
+	// SQS only, optional: the queue events are moved to once received
+	// MaxDeliver times without being settled, as by workers that died
+	DeadLetterURL string
+}
+
+// Reports a URL that doesn't fit the backend without connecting
+func (c Config) Validate() error {
+	switch c.Kind {
+	case KindNATS, KindRabbitMQ, KindRedis:
+		if c.URL == "" {
+			return fmt.Errorf("%s needs a URL", c.Kind)
+		}
+	case KindKafka:
+		// Valid, though retries lose per-relay order. See the package doc
+		for _, broker := range strings.Split(c.URL, ",") {
+			if !strings.Contains(broker, ":") {
+				return fmt.Errorf("kafka brokers must be comma separated host:port pairs, got %q", broker)
+			}
+		}
+	case KindSQS:
+		if _, err := parseSQSQueue(c.URL); err != nil {
+			return err
+		}
+		if c.DeadLetterURL != "" {
+			if _, err := parseSQSQueue(c.DeadLetterURL); err != nil {
+				return fmt.Errorf("dead letter queue: %w", err)
+			}
+		}
+		if c.AWS.AccessKeyID == "" || c.AWS.SecretAccessKey == "" {
+			return fmt.Errorf("sqs needs AWS credentials")
+		}
+	default:
+		return fmt.Errorf("unknown broker %q, want one of %s", c.Kind, strings.Join(Kinds, ", "))
+	}
+	return nil
+}
+
+// One event read from the stream. The consumer settles it with exactly one
+// of Ack, Nak or Term, possibly from another goroutine
+type Delivery interface {
+	// The relay the event was published for
+	RelayID() string
+	Data() []byte
+	// Traceparent the event was published with, "" when none
+	Traceparent() string
+	// 1 on the first delivery of the event
+	Attempt() int
+	Ack()
+	// Hands the event back to be delivered again after delay. Past
+	// ConsumeOptions.MaxDeliver deliveries it is dropped instead
+	Nak(delay time.Duration)
+	// Keeps an event still being worked on from being redelivered
+	InProgress()
+	// Drops the event for good
+	Term()
+}
+
+type ConsumeOptions struct {
+	// Deliveries of one event before it stops being retried
+	MaxDeliver int
+	// How long a delivery may go unsettled, without InProgress, before it is
+	// handed to another consumer
+	AckWait time.Duration
+}
+
+// Started by Consume or Subscribe
+type Subscription interface {
+	// Stops new deliveries, waiting for the handler to return. Deliveries
+	// already handed out can still be settled until the broker is closed
+	Stop() error
+}
+
+type Broker interface {
+	// Appends an event for relayID to the stream. id, when set, lets brokers
+	// that deduplicate drop a second publish of the same event
+	Publish(ctx context.Context, relayID, id, traceparent string, data []byte) error
+	// Calls handle for each event in the stream. Every consumer shares the
+	// stream, so each event goes to one of them. handle is called one event
+	// at a time, and the next isn't fetched until it returns
+	Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error)
+	// Events in the stream not yet delivered to a consumer
+	Pending(ctx context.Context) (int, error)
+	// Best effort fan-out outside the event stream, for signals that only
+	// matter to the instances running right now
+	Broadcast(ctx context.Context, subject string, data []byte) error
+	// Calls handle for messages broadcast on subject. Subscribers sharing a
+	// group split the messages between them, without one each gets them all
+	Subscribe(subject, group string, handle func(data []byte)) (Subscription, error)
+	// Largest event Publish takes
+	MaxPayload() int64
+	// Flushes buffered publishes and disconnects
+	Close() error
+}
+
+// Connects to the broker cfg names. logger reports reconnects
+func Open(cfg Config, logger *slog.Logger) (Broker, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Kind {
+	case KindKafka:
+		return openKafka(cfg.URL, logger)
+	case KindRabbitMQ:
+		return openRabbitMQ(cfg.URL, logger)
+	case KindRedis:
+		return openRedis(cfg.URL, logger)
+	case KindSQS:
+		return openSQS(cfg, logger)
+	}
+	return openNATS(cfg.URL, logger)
+}
+
+// Delivery count past which a Nak drops the event, like JetStream's MaxDeliver
+func exhausted(attempt, maxDeliver int) bool {
+	return maxDeliver > 0 && attempt >= maxDeliver
+}
+
+// Ends a goroutine reading deliveries, waiting for it to return
+type loopSubscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// Deferred deliveries still waiting to go back, dropped on Stop
+	retries *retryTimers
+}
+
+func newLoopSubscription() (*loopSubscription, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &loopSubscription{cancel: cancel, done: make(chan struct{}), retries: &retryTimers{}}, ctx
+}
+
+func (s *loopSubscription) Stop() error {
+	s.cancel()
+	<-s.done
+	s.retries.stop()
+	return nil
+}
+
+// Timers handing deferred deliveries back. A delivery still waiting when the
+// subscription stops is left unsettled, and the broker redelivers it
+type retryTimers struct {
+	mu      sync.Mutex
+	timers  map[*time.Timer]struct{}
+	stopped bool
+}
+
+func (r *retryTimers) after(delay time.Duration, f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	if r.timers == nil {
+		r.timers = map[*time.Timer]struct{}{}
+	}
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		delete(r.timers, t)
+		r.mu.Unlock()
+		f()
+	})
+	r.timers[t] = struct{}{}
+}
+
+func (r *retryTimers) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	for t := range r.timers {
+		t.Stop()
+	}
+	r.timers = nil
+}
+
+// Waits out a failed fetch, false once ctx is done
+func pause(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
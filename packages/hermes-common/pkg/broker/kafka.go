@@ -0,0 +1,379 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// Deliveries an event had before this copy of it was published. Kafka
+	// has no redelivery count, so a Nak republishes the event with this set
+	kafkaAttemptHeader = "hermes-attempt"
+	// The broker's default message.max.bytes
+	kafkaMaxMessage = 1 << 20
+	kafkaTimeout    = 10 * time.Second
+)
+
+// Events are keyed by relay, so a relay's events share a partition and keep
+// their order until one is retried: the retry goes to the tail of the
+// partition, behind the relay's later events. Kafka doesn't deduplicate, so
+// Publish ignores id
+type kafkaBroker struct {
+	brokers []string
+	logger  *slog.Logger
+	client  *kafka.Client
+	events  *kafka.Writer
+	// Broadcasts, all on partition 0 so a reader outside a group sees them all
+	signals *kafka.Writer
+
+	mu      sync.Mutex
+	readers []*kafka.Reader
+}
+
+func openKafka(url string, logger *slog.Logger) (*kafkaBroker, error) {
+	brokers := strings.Split(url, ",")
+	addr := kafka.TCP(brokers...)
+	return &kafkaBroker{
+		brokers: brokers,
+		logger:  logger,
+		client:  &kafka.Client{Addr: addr, Timeout: kafkaTimeout},
+		events: &kafka.Writer{
+			Addr:                   addr,
+			Topic:                  eventStream,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           5 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+		signals: &kafka.Writer{
+			Addr:                   addr,
+			Balancer:               firstPartition{},
+			RequiredAcks:           kafka.RequireOne,
+			BatchTimeout:           5 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+	}, nil
+}
+
+func (b *kafkaBroker) Publish(ctx context.Context, relayID, _, traceparent string, data []byte) error {
+	return b.write(ctx, relayID, traceparent, 0, data)
+}
+
+// Publishes an event with the deliveries it has had so far
+func (b *kafkaBroker) write(ctx context.Context, relayID, traceparent string, attempts int, data []byte) error {
+	if err := b.events.WriteMessages(ctx, kafkaMessage(relayID, traceparent, attempts, data)); err != nil {
+		return fmt.Errorf("kafka publish error: %w", err)
+	}
+	return nil
+}
+
+func kafkaMessage(relayID, traceparent string, attempts int, data []byte) kafka.Message {
+	msg := kafka.Message{Key: []byte(relayID), Value: data}
+	if traceparent != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: tracing.Header, Value: []byte(traceparent)})
+	}
+	if attempts > 0 {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: kafkaAttemptHeader, Value: []byte(strconv.Itoa(attempts))})
+	}
+	return msg
+}
+
+func (b *kafkaBroker) reader(cfg kafka.ReaderConfig) *kafka.Reader {
+	cfg.Brokers = b.brokers
+	cfg.MaxWait = time.Second
+	cfg.ErrorLogger = kafka.LoggerFunc(func(msg string, args ...any) {
+		b.logger.Warn("kafka reader error", slog.String("error", fmt.Sprintf(msg, args...)))
+	})
+	r := kafka.NewReader(cfg)
+	b.mu.Lock()
+	b.readers = append(b.readers, r)
+	b.mu.Unlock()
+	return r
+}
+
+// Offsets are committed only up to the oldest event still unsettled, so one
+// event running long holds back commits on its partition; a worker that
+// stops has anything past that redelivered to the next. AckWait doesn't
+// apply, the group session is what hands a dead worker's partitions on
+func (b *kafkaBroker) Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error) {
+	r := b.reader(kafka.ReaderConfig{GroupID: workerGroup, Topic: eventStream, StartOffset: kafka.FirstOffset})
+	sub, ctx := newLoopSubscription()
+	c := &kafkaConsumer{
+		logger:  b.logger,
+		publish: b.write,
+		commit: func(ctx context.Context, msg kafka.Message) error {
+			return r.CommitMessages(ctx, msg)
+		},
+		opts:    opts,
+		retries: sub.retries,
+		offsets: map[int]*partitionOffsets{},
+	}
+	go func() {
+		defer close(sub.done)
+		for {
+			msg, err := r.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.logger.Warn("kafka fetch failed", slog.String("error", err.Error()))
+				if !pause(ctx, time.Second) {
+					return
+				}
+				continue
+			}
+			c.fetched(msg)
+			handle(&kafkaDelivery{c: c, msg: msg})
+		}
+	}()
+	return sub, nil
+}
+
+// The worker group's lag summed over the event topic's partitions
+func (b *kafkaBroker) Pending(ctx context.Context) (int, error) {
+	meta, err := b.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{eventStream}})
+	if err != nil {
+		return 0, fmt.Errorf("kafka metadata: %w", err)
+	}
+	var partitions []int
+	for _, topic := range meta.Topics {
+		if topic.Name != eventStream {
+			continue
+		}
+		if topic.Error != nil {
+			return 0, fmt.Errorf("kafka metadata: %w", topic.Error)
+		}
+		for _, p := range topic.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return 0, nil
+	}
+	committed, err := b.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: workerGroup,
+		Topics:  map[string][]int{eventStream: partitions},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("kafka offset fetch: %w", err)
+	}
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+	}
+	ends, err := b.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{eventStream: requests}})
+	if err != nil {
+		return 0, fmt.Errorf("kafka list offsets: %w", err)
+	}
+	done := map[int]int64{}
+	for _, p := range committed.Topics[eventStream] {
+		done[p.Partition] = p.CommittedOffset
+	}
+	pending := int64(0)
+	for _, p := range ends.Topics[eventStream] {
+		from, ok := done[p.Partition]
+		// Nothing committed yet, the group starts from the oldest event
+		if !ok || from < p.FirstOffset {
+			from = p.FirstOffset
+		}
+		if p.LastOffset > from {
+			pending += p.LastOffset - from
+		}
+	}
+	return int(pending), nil
+}
+
+func (b *kafkaBroker) Broadcast(ctx context.Context, subject string, data []byte) error {
+	if err := b.signals.WriteMessages(ctx, kafka.Message{Topic: subject, Value: data}); err != nil {
+		return fmt.Errorf("kafka publish error: %w", err)
+	}
+	return nil
+}
+
+// Only broadcasts sent after the subscription starts are seen
+func (b *kafkaBroker) Subscribe(subject, group string, handle func([]byte)) (Subscription, error) {
+	cfg := kafka.ReaderConfig{Topic: subject, StartOffset: kafka.LastOffset}
+	if group != "" {
+		cfg.GroupID = group
+	}
+	r := b.reader(cfg)
+	if group == "" {
+		if err := r.SetOffset(kafka.LastOffset); err != nil {
+			return nil, fmt.Errorf("%s subscription failed: %w", subject, err)
+		}
+	}
+	sub, ctx := newLoopSubscription()
+	go func() {
+		defer close(sub.done)
+		for {
+			msg, err := r.ReadMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !pause(ctx, time.Second) {
+					return
+				}
+				continue
+			}
+			handle(msg.Value)
+		}
+	}()
+	return sub, nil
+}
+
+func (b *kafkaBroker) MaxPayload() int64 {
+	return kafkaMaxMessage
+}
+
+func (b *kafkaBroker) Close() error {
+	errs := []error{b.events.Close(), b.signals.Close()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.readers {
+		errs = append(errs, r.Close())
+	}
+	b.readers = nil
+	return errors.Join(errs...)
+}
+
+type firstPartition struct{}
+
+func (firstPartition) Balance(_ kafka.Message, partitions ...int) int {
+	return partitions[0]
+}
+
+type kafkaConsumer struct {
+	logger *slog.Logger
+	// Writes a retried event back to the topic
+	publish func(ctx context.Context, relayID, traceparent string, attempts int, data []byte) error
+	// Commits the group's offset on a partition
+	commit  func(ctx context.Context, msg kafka.Message) error
+	opts    ConsumeOptions
+	retries *retryTimers
+
+	mu      sync.Mutex
+	offsets map[int]*partitionOffsets
+}
+
+// Offsets fetched from one partition and whether each is settled
+type partitionOffsets struct {
+	settled map[int64]bool
+}
+
+func (c *kafkaConsumer) fetched(msg kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.offsets[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{settled: map[int64]bool{}}
+		c.offsets[msg.Partition] = p
+	}
+	p.settled[msg.Offset] = false
+}
+
+// Marks msg settled and commits the partition up to its oldest unsettled event
+func (c *kafkaConsumer) settle(msg kafka.Message) {
+	c.mu.Lock()
+	p, ok := c.offsets[msg.Partition]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	p.settled[msg.Offset] = true
+	commit := p.committable()
+	c.mu.Unlock()
+	if commit < 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTimeout)
+	defer cancel()
+	err := c.commit(ctx, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: commit})
+	if err != nil {
+		c.logger.Warn("kafka commit failed, events past it may be redelivered",
+			slog.Int("partition", msg.Partition), slog.String("error", err.Error()))
+	}
+}
+
+// Forgets the settled run of oldest offsets, returning the newest of them or
+// -1 when the oldest is still unsettled
+func (p *partitionOffsets) committable() int64 {
+	offsets := make([]int64, 0, len(p.settled))
+	for offset := range p.settled {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+	commit := int64(-1)
+	for _, offset := range offsets {
+		if !p.settled[offset] {
+			break
+		}
+		commit = offset
+		delete(p.settled, offset)
+	}
+	return commit
+}
+
+type kafkaDelivery struct {
+	c   *kafkaConsumer
+	msg kafka.Message
+}
+
+func (d *kafkaDelivery) RelayID() string     { return string(d.msg.Key) }
+func (d *kafkaDelivery) Data() []byte        { return d.msg.Value }
+func (d *kafkaDelivery) Traceparent() string { return d.header(tracing.Header) }
+
+// Counts from the attempt header. An event redelivered after a worker died
+// without settling it can't be told apart and counts the same as before
+func (d *kafkaDelivery) Attempt() int {
+	prior, _ := strconv.Atoi(d.header(kafkaAttemptHeader))
+	return prior + 1
+}
+
+func (d *kafkaDelivery) header(key string) string {
+	for _, h := range d.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (d *kafkaDelivery) Ack()        { d.c.settle(d.msg) }
+func (d *kafkaDelivery) Term()       { d.c.settle(d.msg) }
+func (d *kafkaDelivery) InProgress() {}
+
+// Republishes a copy after delay, settling this one once the copy is written
+func (d *kafkaDelivery) Nak(delay time.Duration) {
+	attempt := d.Attempt()
+	if exhausted(attempt, d.c.opts.MaxDeliver) {
+		d.c.settle(d.msg)
+		return
+	}
+	var retry func()
+	retry = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), kafkaTimeout)
+		defer cancel()
+		if err := d.c.publish(ctx, d.RelayID(), d.Traceparent(), attempt, d.msg.Value); err != nil {
+			d.c.logger.Warn("kafka retry publish failed, trying again", slog.String("error", err.Error()))
+			d.c.retries.after(5*time.Second, retry)
+			return
+		}
+		d.c.settle(d.msg)
+	}
+	if delay <= 0 {
+		retry()
+		return
+	}
+	d.c.retries.after(delay, retry)
+}
@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// A consumer whose publishes and commits are recorded instead of sent
+type fakeKafka struct {
+	mu        sync.Mutex
+	published []kafka.Message
+	committed []int64
+	failing   bool
+}
+
+func newFakeKafkaConsumer(maxDeliver int) (*fakeKafka, *kafkaConsumer) {
+	f := &fakeKafka{}
+	c := &kafkaConsumer{
+		logger: testLogger(),
+		publish: func(_ context.Context, relayID, traceparent string, attempts int, data []byte) error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.failing {
+				return errors.New("broker down")
+			}
+			f.published = append(f.published, kafkaMessage(relayID, traceparent, attempts, data))
+			return nil
+		},
+		commit: func(_ context.Context, msg kafka.Message) error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.committed = append(f.committed, msg.Offset)
+			return nil
+		},
+		opts:    ConsumeOptions{MaxDeliver: maxDeliver},
+		retries: &retryTimers{},
+		offsets: map[int]*partitionOffsets{},
+	}
+	return f, c
+}
+
+func (f *fakeKafka) state() ([]kafka.Message, []int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]kafka.Message(nil), f.published...), append([]int64(nil), f.committed...)
+}
+
+// Fetches msg at offset as the reader would and wraps it
+func fetch(c *kafkaConsumer, offset int64, msg kafka.Message) *kafkaDelivery {
+	msg.Topic, msg.Offset = eventStream, offset
+	c.fetched(msg)
+	return &kafkaDelivery{c: c, msg: msg}
+}
+
+func TestCommitsOnlyPastSettledOffsets(t *testing.T) {
+	p := &partitionOffsets{settled: map[int64]bool{10: false, 11: false, 12: false}}
+	p.settled[11] = true
+	if got := p.committable(); got != -1 {
+		t.Fatalf("Expected nothing committable while 10 runs, got %d", got)
+	}
+	p.settled[10] = true
+	if got := p.committable(); got != 11 {
+		t.Fatalf("Expected 11 committable, got %d", got)
+	}
+	p.settled[12] = true
+	if got := p.committable(); got != 12 || len(p.settled) != 0 {
+		t.Errorf("Expected 12 committable and nothing left, got %d and %v", got, p.settled)
+	}
+}
+
+func TestKafkaAttemptHeaderRoundTrips(t *testing.T) {
+	d := &kafkaDelivery{msg: kafkaMessage("relay-1", "00-trace", 0, []byte(`{}`))}
+	if d.Attempt() != 1 || d.header(kafkaAttemptHeader) != "" {
+		t.Errorf("Expected a fresh event on attempt 1 without the header, got %d", d.Attempt())
+	}
+	d = &kafkaDelivery{msg: kafkaMessage("relay-1", "00-trace", 2, []byte(`{}`))}
+	if d.Attempt() != 3 || d.RelayID() != "relay-1" || d.Traceparent() != "00-trace" {
+		t.Errorf("Expected relay-1 with its trace on attempt 3, got %s %s %d", d.RelayID(), d.Traceparent(), d.Attempt())
+	}
+}
+
+func TestKafkaNakRepublishesBeforeCommitting(t *testing.T) {
+	f, c := newFakeKafkaConsumer(3)
+	first := fetch(c, 5, kafkaMessage("relay-1", "00-trace", 0, []byte(`{"a":1}`)))
+	second := fetch(c, 6, kafkaMessage("relay-1", "", 0, []byte(`{"a":2}`)))
+
+	second.Ack()
+	if _, committed := f.state(); len(committed) != 0 {
+		t.Fatalf("Expected no commit while offset 5 is unsettled, got %v", committed)
+	}
+	first.Nak(0)
+	published, committed := f.state()
+	if len(published) != 1 || string(published[0].Value) != `{"a":1}` {
+		t.Fatalf("Expected the event republished, got %v", published)
+	}
+	retry := &kafkaDelivery{msg: published[0]}
+	if retry.Attempt() != 2 || retry.RelayID() != "relay-1" || retry.Traceparent() != "00-trace" {
+		t.Errorf("Expected the copy on attempt 2 with its relay and trace, got %s %s %d", retry.RelayID(), retry.Traceparent(), retry.Attempt())
+	}
+	if len(committed) != 1 || committed[0] != 6 {
+		t.Errorf("Expected both offsets committed at once, got %v", committed)
+	}
+}
+
+func TestKafkaNakPastMaxDeliverCommitsWithoutRepublishing(t *testing.T) {
+	f, c := newFakeKafkaConsumer(3)
+	fetch(c, 0, kafkaMessage("relay-1", "", 2, []byte(`{}`))).Nak(0)
+	published, committed := f.state()
+	if len(published) != 0 || len(committed) != 1 {
+		t.Errorf("Expected the last attempt dropped and committed, got %v and %v", published, committed)
+	}
+}
+
+func TestKafkaDelayedNakHoldsCommitsUntilWritten(t *testing.T) {
+	f, c := newFakeKafkaConsumer(3)
+	fetch(c, 0, kafkaMessage("relay-1", "", 0, []byte(`{}`))).Nak(20 * time.Millisecond)
+	fetch(c, 1, kafkaMessage("relay-2", "", 0, []byte(`{}`))).Ack()
+	if published, committed := f.state(); len(published) != 0 || len(committed) != 0 {
+		t.Fatalf("Expected nothing written or committed before the delay, got %v and %v", published, committed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		published, committed := f.state()
+		if len(published) == 1 && len(committed) == 1 && committed[0] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the retry written and both offsets committed, got %v and %v", published, committed)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKafkaStopLeavesDelayedNakUncommitted(t *testing.T) {
+	f, c := newFakeKafkaConsumer(3)
+	fetch(c, 0, kafkaMessage("relay-1", "", 0, []byte(`{}`))).Nak(20 * time.Millisecond)
+	c.retries.stop()
+	time.Sleep(50 * time.Millisecond)
+	if published, committed := f.state(); len(published) != 0 || len(committed) != 0 {
+		t.Errorf("Expected the event left for the group to redeliver, got %v and %v", published, committed)
+	}
+}
+
+func TestKafkaFailedRetryIsNotCommitted(t *testing.T) {
+	f, c := newFakeKafkaConsumer(3)
+	f.failing = true
+	fetch(c, 0, kafkaMessage("relay-1", "", 0, []byte(`{}`))).Nak(0)
+	c.retries.stop()
+	if _, committed := f.state(); len(committed) != 0 {
+		t.Errorf("Expected an event whose retry wasn't written left uncommitted, got %v", committed)
+	}
+}
@@ -0,0 +1,176 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// The JetStream stream and durable consumer, named as they were before other
+// brokers were supported so existing deployments keep their consumer state
+const (
+	natsStream   = "EVENTS"
+	natsConsumer = "WORKER_CONSUMER"
+	natsSubjects = "events.*"
+	// Longest Stop waits for callbacks already queued to finish
+	natsDrainTimeout = 30 * time.Second
+)
+
+type natsBroker struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+func openNATS(url string, logger *slog.Logger) (*natsBroker, error) {
+	nc, err := nats.Connect(
+		url,
+		nats.MaxReconnects(10),
+		nats.ReconnectWait(2*time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("NATS disconnected", slog.String("error", err.Error()))
+			}
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			logger.Info("NATS reconnected")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("nats connect error: %w", err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("jetstream init error: %w", err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: natsStream, Subjects: []string{natsSubjects}}); err != nil {
+		logger.Debug("stream might already exist", slog.String("stream", natsStream), slog.String("error", err.Error()))
+	}
+	return &natsBroker{nc: nc, js: js}, nil
+}
+
+func (b *natsBroker) Publish(_ context.Context, relayID, id, traceparent string, data []byte) error {
+	msg := nats.NewMsg("events." + relayID)
+	msg.Data = data
+	// Also in the event body, the header lets broker tooling follow the trace
+	if traceparent != "" {
+		msg.Header.Set(tracing.Header, traceparent)
+	}
+	var opts []nats.PubOpt
+	// JetStream drops a second publish with the same ID inside its duplicate window
+	if id != "" {
+		opts = append(opts, nats.MsgId(id))
+	}
+	if _, err := b.js.PublishMsg(msg, opts...); err != nil {
+		return fmt.Errorf("nats publish error: %w", err)
+	}
+	return nil
+}
+
+func (b *natsBroker) Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error) {
+	subOpts := []nats.SubOpt{nats.Durable(natsConsumer), nats.ManualAck(), nats.MaxDeliver(opts.MaxDeliver)}
+	if opts.AckWait > 0 {
+		subOpts = append(subOpts, nats.AckWait(opts.AckWait))
+	}
+	sub, err := b.js.Subscribe("events.>", func(msg *nats.Msg) {
+		handle(natsDelivery{msg})
+	}, subOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+	return natsSubscription{sub}, nil
+}
+
+func (b *natsBroker) Pending(context.Context) (int, error) {
+	info, err := b.js.ConsumerInfo(natsStream, natsConsumer)
+	if err != nil {
+		return 0, fmt.Errorf("consumer info: %w", err)
+	}
+	return int(info.NumPending), nil
+}
+
+// Plain NATS, so nothing is kept for subscribers that aren't connected
+func (b *natsBroker) Broadcast(_ context.Context, subject string, data []byte) error {
+	if err := b.nc.Publish(subject, data); err != nil {
+		return fmt.Errorf("nats publish error: %w", err)
+	}
+	return nil
+}
+
+func (b *natsBroker) Subscribe(subject, group string, handle func([]byte)) (Subscription, error) {
+	cb := func(msg *nats.Msg) { handle(msg.Data) }
+	var sub *nats.Subscription
+	var err error
+	if group == "" {
+		sub, err = b.nc.Subscribe(subject, cb)
+	} else {
+		sub, err = b.nc.QueueSubscribe(subject, group, cb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s subscription failed: %w", subject, err)
+	}
+	return natsSubscription{sub}, nil
+}
+
+func (b *natsBroker) MaxPayload() int64 {
+	return b.nc.MaxPayload()
+}
+
+func (b *natsBroker) Close() error {
+	err := b.nc.Flush()
+	b.nc.Close()
+	return err
+}
+
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+// Lets the callbacks already queued in the client finish first. The client
+// only stops draining once the last callback has returned
+func (s natsSubscription) Stop() error {
+	if err := s.sub.Drain(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(natsDrainTimeout)
+	for s.sub.IsDraining() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("nats drain timed out after %s", natsDrainTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+type natsDelivery struct {
+	msg *nats.Msg
+}
+
+func (d natsDelivery) RelayID() string     { return strings.TrimPrefix(d.msg.Subject, "events.") }
+func (d natsDelivery) Data() []byte        { return d.msg.Data }
+func (d natsDelivery) Traceparent() string { return d.msg.Header.Get(tracing.Header) }
+
+func (d natsDelivery) Attempt() int {
+	if meta, err := d.msg.Metadata(); err == nil {
+		return int(meta.NumDelivered)
+	}
+	return 1
+}
+
+func (d natsDelivery) Ack()        { _ = d.msg.Ack() }
+func (d natsDelivery) InProgress() { _ = d.msg.InProgress() }
+func (d natsDelivery) Term()       { _ = d.msg.Term() }
+
+// JetStream itself stops redelivering past MaxDeliver
+func (d natsDelivery) Nak(delay time.Duration) {
+	if delay > 0 {
+		_ = d.msg.NakWithDelay(delay)
+		return
+	}
+	_ = d.msg.Nak()
+}
@@ -0,0 +1,377 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Unsettled events a worker holds at once, JetStream's default MaxAckPending
+	rabbitPrefetch = 1000
+	// The broker's default max_message_size
+	rabbitMaxMessage     = 128 << 20
+	rabbitConfirmTimeout = 10 * time.Second
+	rabbitReconnectWait  = 2 * time.Second
+	// The relay an event was published for. Events all go through the
+	// default exchange, so the routing key is the queue name
+	rabbitRelayHeader = "hermes-relay-id"
+	// Set by quorum queues to the times an event was handed back
+	rabbitDeliveryCount = "x-delivery-count"
+)
+
+// A quorum queue, so handed back events are counted towards MaxDeliver
+var rabbitQueueArgs = amqp.Table{"x-queue-type": "quorum"}
+
+// RabbitMQ has no per-message delay without a plugin, so a Nak with a delay
+// holds the event unacknowledged until the delay passes. A worker that dies
+// meanwhile has the broker redeliver it straight away
+type rabbitBroker struct {
+	url    string
+	logger *slog.Logger
+	// Consumer tags are unique per connection, this keeps them unique across
+	// the reconnects of one process as well
+	tags atomic.Int64
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	// Publishes events in confirm mode
+	events *amqp.Channel
+	// Publishes broadcasts, unconfirmed
+	signals *amqp.Channel
+	// Fanout exchanges declared on the current connection
+	exchanges map[string]bool
+	closed    bool
+}
+
+func openRabbitMQ(url string, logger *slog.Logger) (*rabbitBroker, error) {
+	b := &rabbitBroker{url: url, logger: logger}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.connection(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// The open connection, redialled when it has dropped. Declares the event
+// queue on each new connection. Call with mu held
+func (b *rabbitBroker) connection() (*amqp.Connection, error) {
+	if b.closed {
+		return nil, errors.New("rabbitmq broker is closed")
+	}
+	if b.conn != nil && !b.conn.IsClosed() {
+		return b.conn, nil
+	}
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return nil, fmt.Errorf("rabbitmq connect error: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rabbitmq channel error: %w", err)
+	}
+	defer ch.Close()
+	if _, err := ch.QueueDeclare(eventStream, true, false, false, false, rabbitQueueArgs); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rabbitmq queue declare error: %w", err)
+	}
+	if b.conn != nil {
+		b.logger.Info("RabbitMQ reconnected")
+	}
+	b.conn, b.events, b.signals, b.exchanges = conn, nil, nil, map[string]bool{}
+	return conn, nil
+}
+
+// A channel on the current connection, reopened when it has closed. Call with mu held
+func (b *rabbitBroker) channel(current **amqp.Channel, confirm bool) (*amqp.Channel, error) {
+	conn, err := b.connection()
+	if err != nil {
+		return nil, err
+	}
+	if *current != nil && !(*current).IsClosed() {
+		return *current, nil
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("rabbitmq channel error: %w", err)
+	}
+	if confirm {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("rabbitmq confirm mode error: %w", err)
+		}
+	}
+	*current = ch
+	return ch, nil
+}
+
+// Waits for the broker to confirm the event is stored. id becomes the
+// message ID; RabbitMQ doesn't deduplicate on it
+func (b *rabbitBroker) Publish(ctx context.Context, relayID, id, traceparent string, data []byte) error {
+	headers := amqp.Table{rabbitRelayHeader: relayID}
+	if traceparent != "" {
+		headers[tracing.Header] = traceparent
+	}
+	b.mu.Lock()
+	ch, err := b.channel(&b.events, true)
+	var confirm *amqp.DeferredConfirmation
+	if err == nil {
+		confirm, err = ch.PublishWithDeferredConfirmWithContext(ctx, "", eventStream, false, false, amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    id,
+			Timestamp:    time.Now(),
+			Headers:      headers,
+			Body:         data,
+		})
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("rabbitmq publish error: %w", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, rabbitConfirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		return fmt.Errorf("waiting for publisher confirm: %w", err)
+	}
+	if !acked {
+		return errors.New("rabbitmq nacked the event")
+	}
+	return nil
+}
+
+// AckWait doesn't apply, the broker redelivers when the consumer's channel closes
+func (b *rabbitBroker) Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error) {
+	open := func(tag string) (*amqp.Channel, <-chan amqp.Delivery, error) {
+		b.mu.Lock()
+		conn, err := b.connection()
+		b.mu.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := ch.Qos(rabbitPrefetch, 0, false); err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+		deliveries, err := ch.Consume(eventStream, tag, false, false, false, false, nil)
+		if err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+		return ch, deliveries, nil
+	}
+	sub, ctx := newLoopSubscription()
+	if err := b.consumeLoop(ctx, sub, open, func(d amqp.Delivery) {
+		handle(&rabbitDelivery{d: d, opts: opts, retries: sub.retries})
+	}); err != nil {
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+	return sub, nil
+}
+
+// Reads what open returns until ctx is done, reopening it when the
+// connection drops. Fails only when the first open does
+func (b *rabbitBroker) consumeLoop(ctx context.Context, sub *loopSubscription, open func(tag string) (*amqp.Channel, <-chan amqp.Delivery, error), handle func(amqp.Delivery)) error {
+	host, _ := os.Hostname()
+	tag := func() string { return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), b.tags.Add(1)) }
+	current := tag()
+	ch, deliveries, err := open(current)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer close(sub.done)
+		for {
+			for alive := true; alive; {
+				select {
+				case <-ctx.Done():
+					// Leaves the channel open so handed out events can still be settled
+					_ = ch.Cancel(current, false)
+					return
+				case d, ok := <-deliveries:
+					if alive = ok; ok {
+						handle(d)
+					}
+				}
+			}
+			b.logger.Warn("RabbitMQ consumer closed, reconnecting")
+			for {
+				if !pause(ctx, rabbitReconnectWait) {
+					return
+				}
+				current = tag()
+				if ch, deliveries, err = open(current); err == nil {
+					break
+				}
+				b.logger.Warn("RabbitMQ reconnect failed", slog.String("error", err.Error()))
+			}
+		}
+	}()
+	return nil
+}
+
+// The event queue's ready count, leaving out events already handed out
+func (b *rabbitBroker) Pending(context.Context) (int, error) {
+	b.mu.Lock()
+	conn, err := b.connection()
+	b.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	// A failed passive declare closes the channel, so it gets its own
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, fmt.Errorf("rabbitmq channel error: %w", err)
+	}
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(eventStream, true, false, false, false, rabbitQueueArgs)
+	if err != nil {
+		return 0, fmt.Errorf("rabbitmq queue inspect: %w", err)
+	}
+	return q.Messages, nil
+}
+
+// Declares the fanout exchange for subject on ch. Call with mu held
+func (b *rabbitBroker) exchange(ch *amqp.Channel, subject string) error {
+	if b.exchanges[subject] {
+		return nil
+	}
+	if err := ch.ExchangeDeclare(subject, amqp.ExchangeFanout, false, false, false, false, nil); err != nil {
+		return fmt.Errorf("rabbitmq exchange declare error: %w", err)
+	}
+	b.exchanges[subject] = true
+	return nil
+}
+
+func (b *rabbitBroker) Broadcast(ctx context.Context, subject string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, err := b.channel(&b.signals, false)
+	if err != nil {
+		return err
+	}
+	if err := b.exchange(ch, subject); err != nil {
+		return err
+	}
+	if err := ch.PublishWithContext(ctx, subject, "", false, false, amqp.Publishing{Body: data}); err != nil {
+		return fmt.Errorf("rabbitmq publish error: %w", err)
+	}
+	return nil
+}
+
+// Each subscriber without a group gets a queue of its own, subscribers in a
+// group share one. Both go away with their last consumer
+func (b *rabbitBroker) Subscribe(subject, group string, handle func([]byte)) (Subscription, error) {
+	open := func(tag string) (*amqp.Channel, <-chan amqp.Delivery, error) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		signals, err := b.channel(&b.signals, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := b.exchange(signals, subject); err != nil {
+			return nil, nil, err
+		}
+		ch, err := b.conn.Channel()
+		if err != nil {
+			return nil, nil, err
+		}
+		name, exclusive := "", true
+		if group != "" {
+			name, exclusive = subject+"."+group, false
+		}
+		q, err := ch.QueueDeclare(name, false, true, exclusive, false, nil)
+		if err == nil {
+			err = ch.QueueBind(q.Name, "", subject, false, nil)
+		}
+		var deliveries <-chan amqp.Delivery
+		if err == nil {
+			deliveries, err = ch.Consume(q.Name, tag, true, exclusive, false, false, nil)
+		}
+		if err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+		return ch, deliveries, nil
+	}
+	sub, ctx := newLoopSubscription()
+	if err := b.consumeLoop(ctx, sub, open, func(d amqp.Delivery) { handle(d.Body) }); err != nil {
+		return nil, fmt.Errorf("%s subscription failed: %w", subject, err)
+	}
+	return sub, nil
+}
+
+func (b *rabbitBroker) MaxPayload() int64 {
+	return rabbitMaxMessage
+}
+
+func (b *rabbitBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.conn == nil || b.conn.IsClosed() {
+		return nil
+	}
+	return b.conn.Close()
+}
+
+type rabbitDelivery struct {
+	d       amqp.Delivery
+	opts    ConsumeOptions
+	retries *retryTimers
+}
+
+func (r *rabbitDelivery) RelayID() string     { return r.header(rabbitRelayHeader) }
+func (r *rabbitDelivery) Data() []byte        { return r.d.Body }
+func (r *rabbitDelivery) Traceparent() string { return r.header(tracing.Header) }
+
+func (r *rabbitDelivery) header(key string) string {
+	value, _ := r.d.Headers[key].(string)
+	return value
+}
+
+func (r *rabbitDelivery) Attempt() int {
+	switch count := r.d.Headers[rabbitDeliveryCount].(type) {
+	case int64:
+		return int(count) + 1
+	case int32:
+		return int(count) + 1
+	case int:
+		return count + 1
+	}
+	if r.d.Redelivered {
+		return 2
+	}
+	return 1
+}
+
+func (r *rabbitDelivery) Ack()        { _ = r.d.Ack(false) }
+func (r *rabbitDelivery) Term()       { _ = r.d.Reject(false) }
+func (r *rabbitDelivery) InProgress() {}
+
+func (r *rabbitDelivery) Nak(delay time.Duration) {
+	if exhausted(r.Attempt(), r.opts.MaxDeliver) {
+		_ = r.d.Reject(false)
+		return
+	}
+	if delay <= 0 {
+		_ = r.d.Nack(false, true)
+		return
+	}
+	r.retries.after(delay, func() { _ = r.d.Nack(false, true) })
+}
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Records how a delivery was settled
+type fakeAcker struct {
+	mu      sync.Mutex
+	settled []string
+}
+
+func (a *fakeAcker) record(how string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settled = append(a.settled, how)
+	return nil
+}
+
+func (a *fakeAcker) Ack(uint64, bool) error { return a.record("ack") }
+func (a *fakeAcker) Reject(_ uint64, requeue bool) error {
+	if requeue {
+		return a.record("requeue")
+	}
+	return a.record("reject")
+}
+func (a *fakeAcker) Nack(_ uint64, _ bool, requeue bool) error {
+	if requeue {
+		return a.record("requeue")
+	}
+	return a.record("reject")
+}
+
+func (a *fakeAcker) state() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.settled...)
+}
+
+func newRabbitDelivery(headers amqp.Table, maxDeliver int) (*fakeAcker, *rabbitDelivery) {
+	acker := &fakeAcker{}
+	d := amqp.Delivery{Acknowledger: acker, Headers: headers, Body: []byte(`{}`)}
+	return acker, &rabbitDelivery{d: d, opts: ConsumeOptions{MaxDeliver: maxDeliver}, retries: &retryTimers{}}
+}
+
+func TestRabbitAttemptCountsDeliveries(t *testing.T) {
+	_, d := newRabbitDelivery(amqp.Table{rabbitRelayHeader: "relay-1", tracing.Header: "00-trace"}, 3)
+	if d.Attempt() != 1 || d.RelayID() != "relay-1" || d.Traceparent() != "00-trace" {
+		t.Errorf("Expected relay-1 with its trace on attempt 1, got %s %s %d", d.RelayID(), d.Traceparent(), d.Attempt())
+	}
+	_, d = newRabbitDelivery(amqp.Table{rabbitDeliveryCount: int64(2)}, 3)
+	if d.Attempt() != 3 {
+		t.Errorf("Expected attempt 3 after two handed back, got %d", d.Attempt())
+	}
+	_, d = newRabbitDelivery(nil, 3)
+	d.d.Redelivered = true
+	if d.Attempt() != 2 {
+		t.Errorf("Expected a redelivery without a count on attempt 2, got %d", d.Attempt())
+	}
+}
+
+func TestRabbitDelayedNakWaitsOutTheDelay(t *testing.T) {
+	acker, d := newRabbitDelivery(nil, 3)
+	d.Nak(30 * time.Millisecond)
+	if got := acker.state(); len(got) != 0 {
+		t.Fatalf("Expected the event held before the delay, got %v", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(acker.state()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event handed back after the delay")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := acker.state(); len(got) != 1 || got[0] != "requeue" {
+		t.Errorf("Expected one requeue, got %v", got)
+	}
+}
+
+func TestRabbitNakPastMaxDeliverRejects(t *testing.T) {
+	acker, d := newRabbitDelivery(amqp.Table{rabbitDeliveryCount: int64(2)}, 3)
+	d.Nak(time.Hour)
+	if got := acker.state(); len(got) != 1 || got[0] != "reject" {
+		t.Errorf("Expected the last attempt rejected straight away, got %v", got)
+	}
+}
+
+func TestRabbitStopLeavesDelayedNakUnsettled(t *testing.T) {
+	acker, d := newRabbitDelivery(nil, 3)
+	d.Nak(20 * time.Millisecond)
+	d.retries.stop()
+	time.Sleep(50 * time.Millisecond)
+	if got := acker.state(); len(got) != 0 {
+		t.Errorf("Expected the event left for the broker to redeliver, got %v", got)
+	}
+}
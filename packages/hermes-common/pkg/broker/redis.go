@@ -0,0 +1,341 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisEvents = "hermes:events"
+	// Events handed back with a delay, scored by when they are due
+	redisDelayed = "hermes:events:delayed"
+	// How long a read blocks, and so how often due and abandoned events are looked for
+	redisBlock = time.Second
+	// Broadcast streams are trimmed to about this many messages
+	redisSignalLen = 1000
+	// The server's default proto-max-bulk-len
+	redisMaxMessage = 512 << 20
+	redisTimeout    = 5 * time.Second
+)
+
+// Moves due delayed events back onto the stream in one step, so two workers
+// doing it at once can't both requeue an event
+var promoteDue = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, event in ipairs(due) do
+	redis.call('XADD', KEYS[2], '*', 'event', event)
+	redis.call('ZREM', KEYS[1], event)
+end
+return #due`)
+
+// An event as stored in the stream
+type redisEntry struct {
+	RelayID     string `json:"relay_id"`
+	Traceparent string `json:"traceparent,omitempty"`
+	// Deliveries the event had before this copy of it was added
+	Attempts int    `json:"attempts,omitempty"`
+	Data     []byte `json:"data"`
+	// Stream ID of the delivery a delayed copy was handed back from, which
+	// keeps identical events apart in the delayed set
+	From string `json:"from,omitempty"`
+}
+
+// Settled events are deleted from the stream, so it only holds ones still
+// to run. Redis doesn't deduplicate, so Publish ignores id
+type redisBroker struct {
+	client *redis.Client
+	logger *slog.Logger
+	// This process's name in consumer groups
+	consumer string
+}
+
+func openRedis(url string, logger *slog.Logger) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis connect error: %w", err)
+	}
+	host, _ := os.Hostname()
+	return &redisBroker{client: client, logger: logger, consumer: fmt.Sprintf("%s-%d", host, os.Getpid())}, nil
+}
+
+func (b *redisBroker) Publish(ctx context.Context, relayID, _, traceparent string, data []byte) error {
+	return b.add(ctx, b.client, redisEntry{RelayID: relayID, Traceparent: traceparent, Data: data})
+}
+
+func (b *redisBroker) add(ctx context.Context, cmd redis.Cmdable, entry redisEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	if err := cmd.XAdd(ctx, &redis.XAddArgs{Stream: redisEvents, Values: []any{"event", encoded}}).Err(); err != nil {
+		return fmt.Errorf("redis publish error: %w", err)
+	}
+	return nil
+}
+
+// Creates group on stream unless it exists. start is where a new group begins reading
+func (b *redisBroker) group(ctx context.Context, stream, group, start string) error {
+	err := b.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("redis group create error: %w", err)
+	}
+	return nil
+}
+
+// Events a worker took and left unsettled for AckWait are claimed by another
+func (b *redisBroker) Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error) {
+	setup, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	// From the start, so events published before the first worker ran are read
+	if err := b.group(setup, redisEvents, workerGroup, "0"); err != nil {
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+	if opts.AckWait <= 0 {
+		opts.AckWait = 30 * time.Second
+	}
+	sub, ctx := newLoopSubscription()
+	go func() {
+		defer close(sub.done)
+		for ctx.Err() == nil {
+			if err := b.promote(ctx); err != nil && ctx.Err() == nil {
+				b.logger.Warn("failed to requeue delayed events", slog.String("error", err.Error()))
+			}
+			abandoned, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream: redisEvents, Group: workerGroup, Consumer: b.consumer,
+				MinIdle: opts.AckWait, Start: "0-0", Count: 10,
+			}).Result()
+			if err != nil && ctx.Err() == nil {
+				b.logger.Warn("failed to claim abandoned events", slog.String("error", err.Error()))
+			}
+			for _, msg := range abandoned {
+				if d := b.delivery(ctx, msg, opts, true); d != nil {
+					handle(d)
+				}
+			}
+			streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group: workerGroup, Consumer: b.consumer,
+				Streams: []string{redisEvents, ">"}, Count: 1, Block: redisBlock,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.logger.Warn("redis read failed", slog.String("error", err.Error()))
+				pause(ctx, redisBlock)
+				continue
+			}
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					if d := b.delivery(ctx, msg, opts, false); d != nil {
+						handle(d)
+					}
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (b *redisBroker) promote(ctx context.Context) error {
+	due := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return promoteDue.Run(ctx, b.client, []string{redisDelayed, redisEvents}, due).Err()
+}
+
+// Wraps a stream message, or drops it and returns nil when it has been
+// delivered MaxDeliver times already
+func (b *redisBroker) delivery(ctx context.Context, msg redis.XMessage, opts ConsumeOptions, reclaimed bool) Delivery {
+	d := &redisDelivery{b: b, id: msg.ID, opts: opts}
+	raw, _ := msg.Values["event"].(string)
+	if err := json.Unmarshal([]byte(raw), &d.entry); err != nil {
+		// Left for the consumer to reject as it would any unparseable event
+		d.entry = redisEntry{Data: []byte(raw)}
+	}
+	d.attempt = d.entry.Attempts + 1
+	if reclaimed {
+		pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: redisEvents, Group: workerGroup, Start: msg.ID, End: msg.ID, Count: 1,
+		}).Result()
+		if err == nil && len(pending) == 1 {
+			d.attempt = d.entry.Attempts + int(pending[0].RetryCount)
+		}
+		if opts.MaxDeliver > 0 && d.attempt > opts.MaxDeliver {
+			d.settle(nil)
+			return nil
+		}
+	}
+	return d
+}
+
+// Events added and not yet read by the worker group
+func (b *redisBroker) Pending(ctx context.Context) (int, error) {
+	groups, err := b.client.XInfoGroups(ctx, redisEvents).Result()
+	if err != nil {
+		// No stream yet, so nothing was ever published
+		if strings.Contains(err.Error(), "no such key") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("redis group info: %w", err)
+	}
+	for _, g := range groups {
+		if g.Name == workerGroup {
+			return int(g.Lag), nil
+		}
+	}
+	n, err := b.client.XLen(ctx, redisEvents).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis stream length: %w", err)
+	}
+	return int(n), nil
+}
+
+// Broadcasts go on a capped stream per subject
+func (b *redisBroker) Broadcast(ctx context.Context, subject string, data []byte) error {
+	err := b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: subject, MaxLen: redisSignalLen, Approx: true, Values: []any{"data", data},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis publish error: %w", err)
+	}
+	return nil
+}
+
+// Only broadcasts sent after the subscription starts are seen
+func (b *redisBroker) Subscribe(subject, group string, handle func([]byte)) (Subscription, error) {
+	if group != "" {
+		setup, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if err := b.group(setup, subject, group, "$"); err != nil {
+			return nil, fmt.Errorf("%s subscription failed: %w", subject, err)
+		}
+	}
+	sub, ctx := newLoopSubscription()
+	go func() {
+		defer close(sub.done)
+		last := "$"
+		for ctx.Err() == nil {
+			var streams []redis.XStream
+			var err error
+			if group == "" {
+				streams, err = b.client.XRead(ctx, &redis.XReadArgs{
+					Streams: []string{subject, last}, Count: 100, Block: redisBlock,
+				}).Result()
+			} else {
+				streams, err = b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+					Group: group, Consumer: b.consumer, Streams: []string{subject, ">"},
+					Count: 100, Block: redisBlock, NoAck: true,
+				}).Result()
+			}
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					pause(ctx, redisBlock)
+				}
+				continue
+			}
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					last = msg.ID
+					data, _ := msg.Values["data"].(string)
+					handle([]byte(data))
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (b *redisBroker) MaxPayload() int64 {
+	return redisMaxMessage
+}
+
+func (b *redisBroker) Close() error {
+	return b.client.Close()
+}
+
+type redisDelivery struct {
+	b       *redisBroker
+	id      string
+	entry   redisEntry
+	attempt int
+	opts    ConsumeOptions
+}
+
+func (d *redisDelivery) RelayID() string     { return d.entry.RelayID }
+func (d *redisDelivery) Data() []byte        { return d.entry.Data }
+func (d *redisDelivery) Traceparent() string { return d.entry.Traceparent }
+func (d *redisDelivery) Attempt() int        { return d.attempt }
+func (d *redisDelivery) Ack()                { d.settle(nil) }
+func (d *redisDelivery) Term()               { d.settle(nil) }
+
+// Resets the idle time that would let another worker claim the event
+func (d *redisDelivery) InProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_ = d.b.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream: redisEvents, Group: workerGroup, Consumer: d.b.consumer, Messages: []string{d.id},
+	}).Err()
+}
+
+// Adds a copy of the event, straight to the stream or to the delayed set,
+// and settles this one in the same transaction
+func (d *redisDelivery) Nak(delay time.Duration) {
+	if exhausted(d.attempt, d.opts.MaxDeliver) {
+		d.settle(nil)
+		return
+	}
+	retry := d.entry
+	retry.Attempts = d.attempt
+	retry.From = d.id
+	d.settle(func(ctx context.Context, tx redis.Pipeliner) error {
+		if delay <= 0 {
+			return d.b.add(ctx, tx, retry)
+		}
+		encoded, err := json.Marshal(retry)
+		if err != nil {
+			return err
+		}
+		due := float64(time.Now().Add(delay).UnixMilli())
+		return tx.ZAdd(ctx, redisDelayed, redis.Z{Score: due, Member: encoded}).Err()
+	})
+}
+
+// Acknowledges and deletes the event, after queueing what also runs in the
+// transaction. A failure leaves it pending, to be claimed again after AckWait
+func (d *redisDelivery) settle(also func(context.Context, redis.Pipeliner) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err := d.b.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		if also != nil {
+			if err := also(ctx, tx); err != nil {
+				return err
+			}
+		}
+		tx.XAck(ctx, redisEvents, workerGroup, d.id)
+		tx.XDel(ctx, redisEvents, d.id)
+		return nil
+	})
+	if err != nil {
+		d.b.logger.Warn("failed to settle redis event", slog.String("id", d.id), slog.String("error", err.Error()))
+	}
+}
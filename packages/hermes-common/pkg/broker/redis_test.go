@@ -0,0 +1,169 @@
+package broker
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func newRedisBroker(t *testing.T) (*miniredis.Miniredis, Broker) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	b, err := Open(Config{Kind: KindRedis, URL: "redis://" + server.Addr()}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return server, b
+}
+
+func testLogger() *slog.Logger {
+	return logger.New("hermes-broker-test", "test", "error")
+}
+
+func next(t *testing.T, deliveries chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a delivery")
+		return nil
+	}
+}
+
+func TestRedisDeliversAndRetries(t *testing.T) {
+	server, b := newRedisBroker(t)
+	ctx := context.Background()
+	if err := b.Publish(ctx, "relay-1", "relay-1/evt-1", "00-trace", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Pending(ctx); err != nil || n != 1 {
+		t.Errorf("Expected 1 pending before any worker ran, got %d (%v)", n, err)
+	}
+
+	deliveries := make(chan Delivery, 4)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 3, AckWait: time.Minute}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+
+	d := next(t, deliveries)
+	if d.RelayID() != "relay-1" || string(d.Data()) != `{"a":1}` || d.Traceparent() != "00-trace" || d.Attempt() != 1 {
+		t.Fatalf("Unexpected delivery %s %s %s attempt %d", d.RelayID(), d.Data(), d.Traceparent(), d.Attempt())
+	}
+	d.Nak(0)
+	if d = next(t, deliveries); d.Attempt() != 2 || string(d.Data()) != `{"a":1}` {
+		t.Fatalf("Expected the event again on attempt 2, got attempt %d", d.Attempt())
+	}
+	d.Nak(time.Hour)
+	if n, _ := server.ZMembers(redisDelayed); len(n) != 1 {
+		t.Fatalf("Expected the event to wait in the delayed set, got %v", n)
+	}
+	// Made due now, so the next pass moves it back onto the stream
+	members, _ := server.ZMembers(redisDelayed)
+	if _, err := server.ZAdd(redisDelayed, 0, members[0]); err != nil {
+		t.Fatal(err)
+	}
+	if d = next(t, deliveries); d.Attempt() != 3 {
+		t.Fatalf("Expected the delayed event on attempt 3, got %d", d.Attempt())
+	}
+	// The third delivery was the last one allowed
+	d.Nak(0)
+	select {
+	case d := <-deliveries:
+		t.Fatalf("Expected the event dropped after MaxDeliver, got attempt %d", d.Attempt())
+	case <-time.After(1500 * time.Millisecond):
+	}
+	if left, _ := server.Stream(redisEvents); len(left) != 0 {
+		t.Errorf("Expected settled events deleted from the stream, got %v", left)
+	}
+}
+
+func TestRedisAckSettles(t *testing.T) {
+	server, b := newRedisBroker(t)
+	deliveries := make(chan Delivery, 1)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 3}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+	if err := b.Publish(context.Background(), "relay-1", "", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	next(t, deliveries).Ack()
+	if left, _ := server.Stream(redisEvents); len(left) != 0 {
+		t.Errorf("Expected the acked event deleted, got %v", left)
+	}
+}
+
+func TestRedisBroadcast(t *testing.T) {
+	_, b := newRedisBroker(t)
+	all := make(chan string, 4)
+	grouped := make(chan string, 4)
+	for range 2 {
+		sub, err := b.Subscribe("hermes.cancel", "", func(data []byte) { all <- string(data) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Stop()
+		sub, err = b.Subscribe("hermes.cancel", "core", func(data []byte) { grouped <- string(data) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Stop()
+	}
+	// Lets the ungrouped readers start blocking before anything is sent
+	time.Sleep(100 * time.Millisecond)
+	if err := b.Broadcast(context.Background(), "hermes.cancel", []byte("stop")); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case got := <-all:
+			if got != "stop" {
+				t.Errorf("Expected stop, got %s", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected every subscriber without a group to get the broadcast")
+		}
+	}
+	select {
+	case <-grouped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the group to get the broadcast")
+	}
+	select {
+	case <-grouped:
+		t.Error("Expected the group to get the broadcast once")
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func TestRedisReclaimsAbandonedEvents(t *testing.T) {
+	_, b := newRedisBroker(t)
+	deliveries := make(chan Delivery, 4)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 2, AckWait: 100 * time.Millisecond}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+	if err := b.Publish(context.Background(), "relay-1", "", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Never settled, as by a worker that died
+	next(t, deliveries)
+	if d := next(t, deliveries); d.Attempt() != 2 {
+		t.Fatalf("Expected the abandoned event claimed on attempt 2, got %d", d.Attempt())
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("Expected the event dropped past MaxDeliver, got attempt %d", d.Attempt())
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,560 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+)
+
+const (
+	// How long a receive waits for messages, the most SQS allows
+	sqsWait = 20 * time.Second
+	// Messages a receive takes at once, the most SQS allows
+	sqsBatch = 10
+	// Longest a message can be kept from being received again
+	sqsMaxVisibility = 12 * time.Hour
+	sqsTimeout       = 10 * time.Second
+	// Message attributes carrying what other brokers keep in headers
+	sqsRelayAttribute    = "hermes-relay-id"
+	sqsEncodingAttribute = "hermes-encoding"
+	// Subscriber queues are listed again after this long, so a subscriber
+	// that started since then gets broadcasts from then on
+	sqsListEvery = 30 * time.Second
+	// Broadcast queues keep messages for the shortest time SQS allows, since
+	// nothing reads the queues of subscribers that died without stopping
+	sqsSignalRetention = "60"
+	sqsMaxQueueName    = 80
+	// Used when the queue URL doesn't name a region, as for LocalStack
+	sqsDefaultRegion = "us-east-1"
+)
+
+// Characters SQS doesn't take in a message body
+var sqsInvalidBody = regexp.MustCompile(`[^\t\n\r\x{20}-\x{D7FF}\x{E000}-\x{FFFD}\x{10000}-\x{10FFFF}]`)
+
+// Characters SQS doesn't take in a queue name
+var sqsInvalidName = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// A queue named by its URL, https://sqs.<region>.amazonaws.com/<account>/<name>
+type sqsQueue struct {
+	url string
+	// Where the API is called, the URL's scheme and host
+	endpoint string
+	region   string
+	name     string
+	// FIFO queues keep each message group in order and deduplicate
+	fifo bool
+}
+
+func parseSQSQueue(raw string) (sqsQueue, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return sqsQueue{}, fmt.Errorf("sqs needs a queue URL, got %q", raw)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return sqsQueue{}, fmt.Errorf("sqs queue URL must end in /<account>/<queue name>, got %q", raw)
+	}
+	q := sqsQueue{url: raw, endpoint: u.Scheme + "://" + u.Host, region: sqsDefaultRegion, name: parts[1]}
+	q.fifo = strings.HasSuffix(q.name, ".fifo")
+	for _, label := range strings.Split(u.Hostname(), ".") {
+		if sigv4.ValidRegion.MatchString(label) {
+			q.region = label
+			break
+		}
+	}
+	return q, nil
+}
+
+// Events go to the queue BROKER_URL names. On a standard queue a retried event
+// runs behind the relay's later events, and Publish ignores id. A FIFO queue
+// keeps each relay's events in order, the relay being the message group, and
+// drops a second publish with the same id inside the five minute window.
+// Broadcasts go to a queue per subscriber, or per group, found by name prefix
+type sqsBroker struct {
+	events sqsQueue
+	// Optional queue that unsettled events are redriven to past MaxDeliver
+	deadLetter string
+	creds      sigv4.Credentials
+	client     *http.Client
+	logger     *slog.Logger
+	maxMessage int64
+
+	mu sync.Mutex
+	// Subscriber queue URLs by subject, and when they were listed
+	targets map[string]sqsTargets
+}
+
+type sqsTargets struct {
+	urls   []string
+	listed time.Time
+}
+
+func openSQS(cfg Config, logger *slog.Logger) (*sqsBroker, error) {
+	events, err := parseSQSQueue(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	b := &sqsBroker{
+		events:     events,
+		deadLetter: cfg.DeadLetterURL,
+		creds:      cfg.AWS,
+		// Long enough for a receive to wait out sqsWait
+		client:  &http.Client{Timeout: sqsWait + sqsTimeout},
+		logger:  logger,
+		targets: map[string]sqsTargets{},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	attrs, err := b.attributes(ctx, events.url, "MaximumMessageSize")
+	if err != nil {
+		return nil, fmt.Errorf("sqs connect error: %w", err)
+	}
+	if b.maxMessage, err = strconv.ParseInt(attrs["MaximumMessageSize"], 10, 64); err != nil {
+		return nil, fmt.Errorf("sqs queue has no MaximumMessageSize: %w", err)
+	}
+	return b, nil
+}
+
+type sqsAttribute struct {
+	DataType    string
+	StringValue string
+}
+
+type sqsMessage struct {
+	MessageId         string
+	ReceiptHandle     string
+	Body              string
+	Attributes        map[string]string
+	MessageAttributes map[string]sqsAttribute
+}
+
+// Calls an SQS action over its JSON protocol
+func (b *sqsBroker) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encode sqs %s: %w", action, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.events.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build sqs %s: %w", action, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	sigv4.Sign(req, body, b.creds, b.events.region, "sqs", time.Now())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sqs %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if resp.StatusCode/100 != 2 {
+		var sqsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &sqsErr)
+		return &sqsError{action: action, status: resp.Status, kind: sqsErr.Type, message: sqsErr.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode sqs %s: %w", action, err)
+	}
+	return nil
+}
+
+type sqsError struct {
+	action, status, kind, message string
+}
+
+func (e *sqsError) Error() string {
+	return fmt.Sprintf("sqs %s: %s %s %s", e.action, e.status, e.kind, e.message)
+}
+
+// Whether err says the queue is gone, as a stopped subscriber's is
+func queueGone(err error) bool {
+	var sqsErr *sqsError
+	return errors.As(err, &sqsErr) && strings.HasSuffix(sqsErr.kind, "QueueDoesNotExist")
+}
+
+func (b *sqsBroker) attributes(ctx context.Context, queueURL string, names ...string) (map[string]string, error) {
+	var out struct {
+		Attributes map[string]string
+	}
+	err := b.call(ctx, "GetQueueAttributes", map[string]any{"QueueUrl": queueURL, "AttributeNames": names}, &out)
+	return out.Attributes, err
+}
+
+func (b *sqsBroker) send(ctx context.Context, queueURL string, data []byte, attrs map[string]sqsAttribute, extra map[string]any) error {
+	body, encoding := sqsBody(data)
+	if encoding != "" {
+		attrs[sqsEncodingAttribute] = sqsAttribute{DataType: "String", StringValue: encoding}
+	}
+	in := map[string]any{"QueueUrl": queueURL, "MessageBody": body}
+	if len(attrs) > 0 {
+		in["MessageAttributes"] = attrs
+	}
+	for k, v := range extra {
+		in[k] = v
+	}
+	return b.call(ctx, "SendMessage", in, nil)
+}
+
+// Bodies are text, so events that aren't valid for SQS go base64 encoded
+func sqsBody(data []byte) (string, string) {
+	if utf8.Valid(data) && !sqsInvalidBody.Match(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+func sqsData(msg sqsMessage) []byte {
+	if msg.MessageAttributes[sqsEncodingAttribute].StringValue == "base64" {
+		if data, err := base64.StdEncoding.DecodeString(msg.Body); err == nil {
+			return data
+		}
+	}
+	return []byte(msg.Body)
+}
+
+func (b *sqsBroker) Publish(ctx context.Context, relayID, id, traceparent string, data []byte) error {
+	attrs := map[string]sqsAttribute{sqsRelayAttribute: {DataType: "String", StringValue: relayID}}
+	if traceparent != "" {
+		attrs[tracing.Header] = sqsAttribute{DataType: "String", StringValue: traceparent}
+	}
+	var extra map[string]any
+	if b.events.fifo {
+		// Without an id every publish is its own, as on the other brokers
+		if id == "" {
+			id = randomID()
+		}
+		extra = map[string]any{"MessageGroupId": relayID, "MessageDeduplicationId": id}
+	}
+	if err := b.send(ctx, b.events.url, data, attrs, extra); err != nil {
+		return fmt.Errorf("sqs publish error: %w", err)
+	}
+	return nil
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Seconds SQS takes for a visibility timeout, rounded up and capped
+func visibility(d time.Duration) int {
+	d = min(d, sqsMaxVisibility)
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (b *sqsBroker) receive(ctx context.Context, queueURL string, visibleAfter time.Duration) ([]sqsMessage, error) {
+	in := map[string]any{
+		"QueueUrl":              queueURL,
+		"MaxNumberOfMessages":   sqsBatch,
+		"WaitTimeSeconds":       int(sqsWait.Seconds()),
+		"AttributeNames":        []string{"ApproximateReceiveCount"},
+		"MessageAttributeNames": []string{"All"},
+	}
+	if visibleAfter > 0 {
+		in["VisibilityTimeout"] = visibility(visibleAfter)
+	}
+	var out struct {
+		Messages []sqsMessage
+	}
+	err := b.call(ctx, "ReceiveMessage", in, &out)
+	return out.Messages, err
+}
+
+// Each receive hides the events it takes for AckWait. Ones not settled or
+// touched by then are received again, by this worker or another. With
+// DeadLetterURL set the queue's redrive policy is set to MaxDeliver, so
+// SQS moves an event there rather than deliver it more often than that
+func (b *sqsBroker) Consume(opts ConsumeOptions, handle func(Delivery)) (Subscription, error) {
+	if opts.AckWait <= 0 {
+		opts.AckWait = 30 * time.Second
+	}
+	if b.deadLetter != "" && opts.MaxDeliver > 0 {
+		setup, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+		defer cancel()
+		if err := b.redrive(setup, opts.MaxDeliver); err != nil {
+			return nil, fmt.Errorf("subscription failed: %w", err)
+		}
+	}
+	sub, ctx := newLoopSubscription()
+	go func() {
+		defer close(sub.done)
+		for ctx.Err() == nil {
+			msgs, err := b.receive(ctx, b.events.url, opts.AckWait)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.logger.Warn("sqs receive failed", slog.String("error", err.Error()))
+				pause(ctx, time.Second)
+				continue
+			}
+			for _, msg := range msgs {
+				handle(&sqsDelivery{b: b, msg: msg, opts: opts})
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (b *sqsBroker) redrive(ctx context.Context, maxDeliver int) error {
+	attrs, err := b.attributes(ctx, b.deadLetter, "QueueArn")
+	if err != nil {
+		return fmt.Errorf("dead letter queue: %w", err)
+	}
+	policy, _ := json.Marshal(map[string]string{
+		"deadLetterTargetArn": attrs["QueueArn"],
+		"maxReceiveCount":     strconv.Itoa(maxDeliver),
+	})
+	return b.call(ctx, "SetQueueAttributes", map[string]any{
+		"QueueUrl":   b.events.url,
+		"Attributes": map[string]string{"RedrivePolicy": string(policy)},
+	}, nil)
+}
+
+// Events waiting to be received, leaving out ones in flight or delayed
+func (b *sqsBroker) Pending(ctx context.Context) (int, error) {
+	attrs, err := b.attributes(ctx, b.events.url, "ApproximateNumberOfMessages")
+	if err != nil {
+		return 0, fmt.Errorf("sqs queue attributes: %w", err)
+	}
+	n, _ := strconv.Atoi(attrs["ApproximateNumberOfMessages"])
+	return n, nil
+}
+
+// Subscriber queues for subject start with this. Named after the event queue,
+// so deployments sharing an account keep their broadcasts apart
+func (b *sqsBroker) signalPrefix(subject string) string {
+	name := strings.TrimSuffix(b.events.name, ".fifo") + "-" + subject + "-"
+	name = sqsInvalidName.ReplaceAllString(name, "-")
+	// Leaves room for the longest suffix signalQueue adds
+	return name[:min(len(name), sqsMaxQueueName-26)]
+}
+
+func (b *sqsBroker) signalQueue(subject, group string) string {
+	suffix := "s-" + randomID()
+	if group != "" {
+		suffix = "g-" + sqsInvalidName.ReplaceAllString(group, "-")
+	}
+	name := b.signalPrefix(subject) + suffix
+	return name[:min(len(name), sqsMaxQueueName)]
+}
+
+// Sends to every subscriber queue for subject, listed at most every sqsListEvery
+func (b *sqsBroker) Broadcast(ctx context.Context, subject string, data []byte) error {
+	urls, err := b.signalTargets(ctx, subject)
+	if err != nil {
+		return fmt.Errorf("sqs publish error: %w", err)
+	}
+	var errs []error
+	for _, u := range urls {
+		err := b.send(ctx, u, data, map[string]sqsAttribute{}, nil)
+		if queueGone(err) {
+			b.forget(subject)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("sqs publish error: %w", err)
+	}
+	return nil
+}
+
+func (b *sqsBroker) signalTargets(ctx context.Context, subject string) ([]string, error) {
+	b.mu.Lock()
+	cached, ok := b.targets[subject]
+	b.mu.Unlock()
+	if ok && time.Since(cached.listed) < sqsListEvery {
+		return cached.urls, nil
+	}
+	var urls []string
+	token := ""
+	for {
+		in := map[string]any{"QueueNamePrefix": b.signalPrefix(subject), "MaxResults": 1000}
+		if token != "" {
+			in["NextToken"] = token
+		}
+		var out struct {
+			QueueUrls []string
+			NextToken string
+		}
+		if err := b.call(ctx, "ListQueues", in, &out); err != nil {
+			return nil, err
+		}
+		urls = append(urls, out.QueueUrls...)
+		if token = out.NextToken; token == "" {
+			break
+		}
+	}
+	b.mu.Lock()
+	b.targets[subject] = sqsTargets{urls: urls, listed: time.Now()}
+	b.mu.Unlock()
+	return urls, nil
+}
+
+// Has the next broadcast on subject list its subscriber queues again
+func (b *sqsBroker) forget(subject string) {
+	b.mu.Lock()
+	delete(b.targets, subject)
+	b.mu.Unlock()
+}
+
+// Each subscriber without a group gets a queue of its own, deleted on Stop.
+// Subscribers in a group share one, which is kept. Broadcasts senders listed
+// the queues before the subscription started aren't seen until they list again
+func (b *sqsBroker) Subscribe(subject, group string, handle func([]byte)) (Subscription, error) {
+	setup, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	var out struct {
+		QueueUrl string
+	}
+	err := b.call(setup, "CreateQueue", map[string]any{
+		"QueueName":  b.signalQueue(subject, group),
+		"Attributes": map[string]string{"MessageRetentionPeriod": sqsSignalRetention},
+	}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("%s subscription failed: %w", subject, err)
+	}
+	queueURL := out.QueueUrl
+	sub, ctx := newLoopSubscription()
+	go func() {
+		defer close(sub.done)
+		for ctx.Err() == nil {
+			msgs, err := b.receive(ctx, queueURL, 0)
+			if err != nil {
+				if ctx.Err() == nil {
+					pause(ctx, time.Second)
+				}
+				continue
+			}
+			for _, msg := range msgs {
+				_ = b.delete(queueURL, msg.ReceiptHandle)
+				handle(sqsData(msg))
+			}
+		}
+	}()
+	if group != "" {
+		return sub, nil
+	}
+	return &sqsSubscription{loopSubscription: sub, b: b, queueURL: queueURL}, nil
+}
+
+func (b *sqsBroker) delete(queueURL, receipt string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	return b.call(ctx, "DeleteMessage", map[string]any{"QueueUrl": queueURL, "ReceiptHandle": receipt}, nil)
+}
+
+func (b *sqsBroker) MaxPayload() int64 {
+	return b.maxMessage
+}
+
+// Publishes are sent as they're made, so there is nothing to flush
+func (b *sqsBroker) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+// Deletes the subscriber's own queue once it has stopped reading it
+type sqsSubscription struct {
+	*loopSubscription
+	b        *sqsBroker
+	queueURL string
+}
+
+func (s *sqsSubscription) Stop() error {
+	if err := s.loopSubscription.Stop(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	if err := s.b.call(ctx, "DeleteQueue", map[string]any{"QueueUrl": s.queueURL}, nil); err != nil && !queueGone(err) {
+		return err
+	}
+	return nil
+}
+
+type sqsDelivery struct {
+	b    *sqsBroker
+	msg  sqsMessage
+	opts ConsumeOptions
+}
+
+func (d *sqsDelivery) RelayID() string     { return d.attribute(sqsRelayAttribute) }
+func (d *sqsDelivery) Data() []byte        { return sqsData(d.msg) }
+func (d *sqsDelivery) Traceparent() string { return d.attribute(tracing.Header) }
+
+func (d *sqsDelivery) attribute(name string) string {
+	return d.msg.MessageAttributes[name].StringValue
+}
+
+func (d *sqsDelivery) Attempt() int {
+	if n, err := strconv.Atoi(d.msg.Attributes["ApproximateReceiveCount"]); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (d *sqsDelivery) Ack()  { d.settle() }
+func (d *sqsDelivery) Term() { d.settle() }
+
+// Hides the event for another AckWait
+func (d *sqsDelivery) InProgress() { d.hide(d.opts.AckWait) }
+
+// Hides the event for delay, after which it is received again. An event still
+// hidden when the worker stops is received again once the delay has passed
+func (d *sqsDelivery) Nak(delay time.Duration) {
+	if exhausted(d.Attempt(), d.opts.MaxDeliver) {
+		d.settle()
+		return
+	}
+	d.hide(max(delay, 0))
+}
+
+func (d *sqsDelivery) hide(delay time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	err := d.b.call(ctx, "ChangeMessageVisibility", map[string]any{
+		"QueueUrl":          d.b.events.url,
+		"ReceiptHandle":     d.msg.ReceiptHandle,
+		"VisibilityTimeout": visibility(delay),
+	}, nil)
+	if err != nil {
+		d.b.logger.Warn("failed to change sqs event visibility", slog.String("id", d.msg.MessageId), slog.String("error", err.Error()))
+	}
+}
+
+// A failure leaves the event to be received again once it is visible
+func (d *sqsDelivery) settle() {
+	if err := d.b.delete(d.b.events.url, d.msg.ReceiptHandle); err != nil {
+		d.b.logger.Warn("failed to settle sqs event", slog.String("id", d.msg.MessageId), slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,487 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+// Enough of the SQS JSON API, in memory, for the broker's calls
+type fakeSQS struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	queues map[string]*fakeQueue
+	seq    int
+}
+
+type fakeQueue struct {
+	name  string
+	attrs map[string]string
+	msgs  []*fakeMessage
+}
+
+type fakeMessage struct {
+	id, body, receipt string
+	attrs             map[string]sqsAttribute
+	group, dedupe     string
+	received          int
+	visibleAt         time.Time
+}
+
+func newFakeSQS(t *testing.T, queues ...string) *fakeSQS {
+	t.Helper()
+	f := &fakeSQS{queues: map[string]*fakeQueue{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	for _, name := range queues {
+		f.create(name)
+	}
+	return f
+}
+
+func (f *fakeSQS) url(name string) string {
+	return f.server.URL + "/000000000000/" + name
+}
+
+// Call with mu held, or before the server is used
+func (f *fakeSQS) create(name string) string {
+	u := f.url(name)
+	if _, ok := f.queues[u]; !ok {
+		f.queues[u] = &fakeQueue{name: name, attrs: map[string]string{
+			"MaximumMessageSize": "1048576",
+			"QueueArn":           "arn:aws:sqs:us-east-1:000000000000:" + name,
+		}}
+	}
+	return u
+}
+
+func (f *fakeSQS) queue(name string) *fakeQueue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queues[f.url(name)]
+}
+
+// Messages in the queue, visible or not
+func (f *fakeSQS) messages(name string) []fakeMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeMessage
+	if q := f.queues[f.url(name)]; q != nil {
+		for _, m := range q.msgs {
+			out = append(out, *m)
+		}
+	}
+	return out
+}
+
+// Makes every hidden message in the queue visible again
+func (f *fakeSQS) reveal(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.queues[f.url(name)].msgs {
+		m.visibleAt = time.Time{}
+	}
+}
+
+func (f *fakeSQS) fail(w http.ResponseWriter, kind, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.sqs#" + kind, "message": message})
+}
+
+func (f *fakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sqs/aws4_request") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var in struct {
+		QueueUrl, QueueName, QueueNamePrefix, MessageBody, ReceiptHandle string
+		MessageGroupId, MessageDeduplicationId                           string
+		MaxNumberOfMessages, WaitTimeSeconds                             int
+		VisibilityTimeout                                                *int
+		AttributeNames                                                   []string
+		Attributes                                                       map[string]string
+		MessageAttributes                                                map[string]sqsAttribute
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		f.fail(w, "InvalidParameterValue", err.Error())
+		return
+	}
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	if action == "ReceiveMessage" {
+		// Long polls, though for less time than asked to keep tests quick
+		deadline := time.Now().Add(min(time.Duration(in.WaitTimeSeconds)*time.Second, 200*time.Millisecond))
+		for {
+			if msgs, ok := f.receive(in.QueueUrl, in.MaxNumberOfMessages, in.VisibilityTimeout); !ok || len(msgs) > 0 || time.Now().After(deadline) {
+				if !ok {
+					f.fail(w, "QueueDoesNotExist", in.QueueUrl)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]any{"Messages": msgs})
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := f.queues[in.QueueUrl]
+	switch action {
+	case "CreateQueue":
+		u := f.create(in.QueueName)
+		for k, v := range in.Attributes {
+			f.queues[u].attrs[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"QueueUrl": u})
+		return
+	case "ListQueues":
+		var urls []string
+		for u, q := range f.queues {
+			if strings.HasPrefix(q.name, in.QueueNamePrefix) {
+				urls = append(urls, u)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"QueueUrls": urls})
+		return
+	}
+	if q == nil {
+		f.fail(w, "QueueDoesNotExist", in.QueueUrl)
+		return
+	}
+	switch action {
+	case "SendMessage":
+		f.seq++
+		q.msgs = append(q.msgs, &fakeMessage{
+			id: fmt.Sprint(f.seq), body: in.MessageBody, attrs: in.MessageAttributes,
+			group: in.MessageGroupId, dedupe: in.MessageDeduplicationId,
+		})
+		json.NewEncoder(w).Encode(map[string]string{"MessageId": fmt.Sprint(f.seq)})
+	case "DeleteMessage":
+		for i, m := range q.msgs {
+			if m.receipt == in.ReceiptHandle {
+				q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+				break
+			}
+		}
+		w.Write([]byte(`{}`))
+	case "ChangeMessageVisibility":
+		for _, m := range q.msgs {
+			if m.receipt == in.ReceiptHandle {
+				m.visibleAt = time.Now().Add(time.Duration(*in.VisibilityTimeout) * time.Second)
+			}
+		}
+		w.Write([]byte(`{}`))
+	case "GetQueueAttributes":
+		out := map[string]string{}
+		for _, name := range in.AttributeNames {
+			out[name] = q.attrs[name]
+		}
+		if _, ok := out["ApproximateNumberOfMessages"]; ok {
+			visible := 0
+			for _, m := range q.msgs {
+				if !m.visibleAt.After(time.Now()) {
+					visible++
+				}
+			}
+			out["ApproximateNumberOfMessages"] = fmt.Sprint(visible)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Attributes": out})
+	case "SetQueueAttributes":
+		for k, v := range in.Attributes {
+			q.attrs[k] = v
+		}
+		w.Write([]byte(`{}`))
+	case "DeleteQueue":
+		delete(f.queues, in.QueueUrl)
+		w.Write([]byte(`{}`))
+	default:
+		f.fail(w, "InvalidAction", action)
+	}
+}
+
+// Takes up to max visible messages, moving ones received too often to the
+// queue's redrive target first
+func (f *fakeSQS) receive(queueURL string, max int, hideFor *int) ([]sqsMessage, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := f.queues[queueURL]
+	if q == nil {
+		return nil, false
+	}
+	hide := 30 * time.Second
+	if hideFor != nil {
+		hide = time.Duration(*hideFor) * time.Second
+	}
+	var redrive struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+		MaxReceiveCount     string `json:"maxReceiveCount"`
+	}
+	_ = json.Unmarshal([]byte(q.attrs["RedrivePolicy"]), &redrive)
+	var out []sqsMessage
+	kept := q.msgs[:0]
+	for _, m := range q.msgs {
+		if len(out) == max || m.visibleAt.After(time.Now()) {
+			kept = append(kept, m)
+			continue
+		}
+		if limit, err := strconv.Atoi(redrive.MaxReceiveCount); err == nil && m.received >= limit {
+			for _, dlq := range f.queues {
+				if dlq.attrs["QueueArn"] == redrive.DeadLetterTargetArn {
+					dlq.msgs = append(dlq.msgs, m)
+				}
+			}
+			continue
+		}
+		f.seq++
+		m.received++
+		m.receipt = fmt.Sprintf("receipt-%d", f.seq)
+		m.visibleAt = time.Now().Add(hide)
+		kept = append(kept, m)
+		out = append(out, sqsMessage{
+			MessageId: m.id, ReceiptHandle: m.receipt, Body: m.body, MessageAttributes: m.attrs,
+			Attributes: map[string]string{"ApproximateReceiveCount": fmt.Sprint(m.received)},
+		})
+	}
+	q.msgs = kept
+	return out, true
+}
+
+func openFakeSQS(t *testing.T, f *fakeSQS, cfg Config) Broker {
+	t.Helper()
+	cfg.Kind = KindSQS
+	cfg.AWS = sigv4.Credentials{AccessKeyID: "test", SecretAccessKey: "secret"}
+	b, err := Open(cfg, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestParseSQSQueue(t *testing.T) {
+	q, err := parseSQSQueue("https://sqs.eu-west-2.amazonaws.com/123456789012/hermes-events.fifo")
+	if err != nil || q.region != "eu-west-2" || q.name != "hermes-events.fifo" || !q.fifo ||
+		q.endpoint != "https://sqs.eu-west-2.amazonaws.com" {
+		t.Errorf("Unexpected queue %+v (%v)", q, err)
+	}
+	if q, _ := parseSQSQueue("http://localhost:4566/000000000000/events"); q.region != sqsDefaultRegion || q.fifo {
+		t.Errorf("Expected a local standard queue in %s, got %+v", sqsDefaultRegion, q)
+	}
+	if _, err := parseSQSQueue("https://sqs.eu-west-2.amazonaws.com/events"); err == nil {
+		t.Error("Expected a URL without the account to be rejected")
+	}
+}
+
+func TestSQSDeliversAndRetries(t *testing.T) {
+	f := newFakeSQS(t, "events")
+	b := openFakeSQS(t, f, Config{URL: f.url("events")})
+	if b.MaxPayload() != 1048576 {
+		t.Errorf("Expected the queue's MaximumMessageSize, got %d", b.MaxPayload())
+	}
+	ctx := context.Background()
+	if err := b.Publish(ctx, "relay-1", "relay-1/evt-1", "00-trace", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Pending(ctx); err != nil || n != 1 {
+		t.Errorf("Expected 1 pending before any worker ran, got %d (%v)", n, err)
+	}
+
+	deliveries := make(chan Delivery, 4)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 3, AckWait: time.Minute}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+
+	d := next(t, deliveries)
+	if d.RelayID() != "relay-1" || string(d.Data()) != `{"a":1}` || d.Traceparent() != "00-trace" || d.Attempt() != 1 {
+		t.Fatalf("Unexpected delivery %s %s %s attempt %d", d.RelayID(), d.Data(), d.Traceparent(), d.Attempt())
+	}
+	d.Nak(0)
+	if d = next(t, deliveries); d.Attempt() != 2 {
+		t.Fatalf("Expected the event again on attempt 2, got %d", d.Attempt())
+	}
+	d.Nak(time.Hour)
+	if msgs := f.messages("events"); len(msgs) != 1 || time.Until(msgs[0].visibleAt) < 59*time.Minute {
+		t.Fatalf("Expected the event hidden for the delay, got %+v", msgs)
+	}
+	f.reveal("events")
+	if d = next(t, deliveries); d.Attempt() != 3 {
+		t.Fatalf("Expected the delayed event on attempt 3, got %d", d.Attempt())
+	}
+	// The third delivery was the last one allowed
+	d.Nak(0)
+	if msgs := f.messages("events"); len(msgs) != 0 {
+		t.Errorf("Expected the event deleted past MaxDeliver, got %+v", msgs)
+	}
+}
+
+func TestSQSAckDeletesAndInProgressHides(t *testing.T) {
+	f := newFakeSQS(t, "events")
+	b := openFakeSQS(t, f, Config{URL: f.url("events")})
+	deliveries := make(chan Delivery, 1)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 3, AckWait: 2 * time.Second}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+	if err := b.Publish(context.Background(), "relay-1", "", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	d := next(t, deliveries)
+	f.reveal("events")
+	d.InProgress()
+	if msgs := f.messages("events"); len(msgs) != 1 || time.Until(msgs[0].visibleAt) < time.Second {
+		t.Fatalf("Expected InProgress to hide the event for AckWait again, got %+v", msgs)
+	}
+	d.Ack()
+	if msgs := f.messages("events"); len(msgs) != 0 {
+		t.Errorf("Expected the acked event deleted, got %+v", msgs)
+	}
+}
+
+func TestSQSKeepsBodiesItCantSendAsText(t *testing.T) {
+	f := newFakeSQS(t, "events")
+	b := openFakeSQS(t, f, Config{URL: f.url("events")})
+	data := []byte{'{', 0x00, 0xff, '}'}
+	if err := b.Publish(context.Background(), "relay-1", "", "", data); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := make(chan Delivery, 1)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 3}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+	if got := next(t, deliveries).Data(); string(got) != string(data) {
+		t.Errorf("Expected the bytes back as published, got %q", got)
+	}
+}
+
+func TestSQSGroupsFIFOEventsByRelay(t *testing.T) {
+	f := newFakeSQS(t, "events.fifo")
+	b := openFakeSQS(t, f, Config{URL: f.url("events.fifo")})
+	ctx := context.Background()
+	if err := b.Publish(ctx, "relay-1", "relay-1/evt-1", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(ctx, "relay-2", "", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	msgs := f.messages("events.fifo")
+	if len(msgs) != 2 || msgs[0].group != "relay-1" || msgs[0].dedupe != "relay-1/evt-1" {
+		t.Fatalf("Expected relay-1's event grouped and deduplicated by its id, got %+v", msgs)
+	}
+	if msgs[1].group != "relay-2" || msgs[1].dedupe == "" {
+		t.Errorf("Expected an event without an id to get a deduplication id of its own, got %+v", msgs[1])
+	}
+}
+
+func TestSQSRedrivesPastMaxDeliver(t *testing.T) {
+	f := newFakeSQS(t, "events", "events-dlq")
+	b := openFakeSQS(t, f, Config{URL: f.url("events"), DeadLetterURL: f.url("events-dlq")})
+	deliveries := make(chan Delivery, 4)
+	sub, err := b.Consume(ConsumeOptions{MaxDeliver: 2, AckWait: time.Minute}, func(d Delivery) { deliveries <- d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+	var policy map[string]string
+	if err := json.Unmarshal([]byte(f.queue("events").attrs["RedrivePolicy"]), &policy); err != nil ||
+		policy["maxReceiveCount"] != "2" || policy["deadLetterTargetArn"] != f.queue("events-dlq").attrs["QueueArn"] {
+		t.Fatalf("Expected a redrive policy to the dead letter queue at 2, got %v (%v)", policy, err)
+	}
+	if err := b.Publish(context.Background(), "relay-1", "", "", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Never settled, as by workers that died
+	next(t, deliveries)
+	f.reveal("events")
+	next(t, deliveries)
+	f.reveal("events")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.messages("events-dlq")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event moved to the dead letter queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("Expected no delivery past MaxDeliver, got attempt %d", d.Attempt())
+	default:
+	}
+}
+
+func TestSQSBroadcast(t *testing.T) {
+	f := newFakeSQS(t, "events")
+	b := openFakeSQS(t, f, Config{URL: f.url("events")})
+	all := make(chan string, 4)
+	grouped := make(chan string, 4)
+	var ungrouped []Subscription
+	for range 2 {
+		sub, err := b.Subscribe("hermes.cancel", "", func(data []byte) { all <- string(data) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		ungrouped = append(ungrouped, sub)
+		sub, err = b.Subscribe("hermes.cancel", "core", func(data []byte) { grouped <- string(data) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Stop()
+	}
+	if err := b.Broadcast(context.Background(), "hermes.cancel", []byte("stop")); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case got := <-all:
+			if got != "stop" {
+				t.Errorf("Expected stop, got %s", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected every subscriber without a group to get the broadcast")
+		}
+	}
+	select {
+	case <-grouped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the group to get the broadcast")
+	}
+	select {
+	case <-grouped:
+		t.Error("Expected the group to get the broadcast once")
+	case <-time.After(500 * time.Millisecond):
+	}
+	for _, sub := range ungrouped {
+		if err := sub.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.mu.Lock()
+	left := len(f.queues)
+	f.mu.Unlock()
+	// The event queue and the group's
+	if left != 2 {
+		t.Errorf("Expected stopped subscribers' queues deleted, %d queues left", left)
+	}
+	// A sender still holding the deleted queues skips them
+	if err := b.Broadcast(context.Background(), "hermes.cancel", []byte("again")); err != nil {
+		t.Errorf("Expected deleted subscriber queues skipped, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+// Package dbpool holds the Postgres pool limits core and worker share, read
+// from DB_* settings, and routes their reads to a replica when one is set
+package dbpool
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/settings"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Options struct {
+	// Most connections the pool opens. Acquires wait once they're all in use
+	MaxConns int32
+	// Connections kept open even when idle
+	MinConns int32
+	// Connections are closed and replaced once this old
+	MaxConnLifetime time.Duration
+	// How often idle connections are checked and MinConns restored
+	HealthCheckPeriod time.Duration
+}
+
+// Reads DB_MAX_CONNS, DB_MIN_CONNS, DB_MAX_CONN_LIFETIME and
+// DB_HEALTH_CHECK_PERIOD, recording invalid values on src
+func Load(src *settings.Source) Options {
+	maxConns := src.Int("DB_MAX_CONNS", 25)
+	minConns := src.Int("DB_MIN_CONNS", 2)
+	o := Options{
+		MaxConns:          int32(min(maxConns, 10000)),
+		MinConns:          int32(min(minConns, 10000)),
+		MaxConnLifetime:   src.Duration("DB_MAX_CONN_LIFETIME", time.Hour),
+		HealthCheckPeriod: src.Duration("DB_HEALTH_CHECK_PERIOD", time.Minute),
+	}
+	src.Check(maxConns >= 1 && maxConns <= 10000, "DB_MAX_CONNS must be between 1 and 10000")
+	src.Check(minConns >= 0 && minConns <= maxConns, "DB_MIN_CONNS must be between 0 and DB_MAX_CONNS")
+	src.Check(o.MaxConnLifetime > 0, "DB_MAX_CONN_LIFETIME must be positive")
+	src.Check(o.HealthCheckPeriod > 0, "DB_HEALTH_CHECK_PERIOD must be positive")
+	return o
+}
+
+// Sets the limits on a parsed pool config
+func (o Options) Apply(cfg *pgxpool.Config) {
+	cfg.MaxConns = o.MaxConns
+	cfg.MinConns = o.MinConns
+	cfg.MaxConnLifetime = o.MaxConnLifetime
+	cfg.HealthCheckPeriod = o.HealthCheckPeriod
+}
+
+// Creates a pool for url with these limits. Connections are made on first
+// use, so a database that is down doesn't fail startup
+func Open(url string, o Options) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse db config: %w", err)
+	}
+	o.Apply(cfg)
+	return pgxpool.NewWithConfig(context.Background(), cfg)
+}
@@ -0,0 +1,99 @@
+package dbpool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long reads stay on the primary after the replica failed one
+const replicaCooldown = 30 * time.Second
+
+// SQLSTATE classes a healthy primary wouldn't return: connection exceptions,
+// recovery conflicts, resource limits and shutdowns
+var replicaFault = map[string]bool{"08": true, "40": true, "53": true, "57": true}
+
+// Sends read-only queries to a replica when one is configured. A query the
+// replica fails is run again on the primary, and reads stay there for a
+// while before the replica is tried again. Replicas lag, so reads that must
+// see a write just made belong on the primary
+type ReadRouter struct {
+	primary *pgxpool.Pool
+	replica *pgxpool.Pool
+	logger  *slog.Logger
+	// Unix nanoseconds until which the replica is skipped
+	downUntil atomic.Int64
+}
+
+// replica may be nil, which sends every read to the primary
+func NewReadRouter(primary, replica *pgxpool.Pool, logger *slog.Logger) *ReadRouter {
+	return &ReadRouter{primary: primary, replica: replica, logger: logger}
+}
+
+// Whether reads may be served by a replica
+func (r *ReadRouter) Replicated() bool {
+	return r.replica != nil
+}
+
+func (r *ReadRouter) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if !r.useReplica() {
+		return r.primary.Query(ctx, sql, args...)
+	}
+	rows, err := r.replica.Query(ctx, sql, args...)
+	if err != nil && r.failedOver(ctx, err) {
+		return r.primary.Query(ctx, sql, args...)
+	}
+	return rows, err
+}
+
+func (r *ReadRouter) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if !r.useReplica() {
+		return r.primary.QueryRow(ctx, sql, args...)
+	}
+	return &fallbackRow{ctx: ctx, router: r, sql: sql, args: args, row: r.replica.QueryRow(ctx, sql, args...)}
+}
+
+func (r *ReadRouter) useReplica() bool {
+	return r.replica != nil && time.Now().UnixNano() >= r.downUntil.Load()
+}
+
+// Takes the replica out of rotation for replicaCooldown when err is its
+// fault, reporting whether the query should go to the primary instead.
+// Missing rows, the caller giving up and errors in the query itself aren't
+func (r *ReadRouter) failedOver(ctx context.Context, err error) bool {
+	if errors.Is(err, pgx.ErrNoRows) || ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (len(pgErr.Code) < 2 || !replicaFault[pgErr.Code[:2]]) {
+		return false
+	}
+	if r.downUntil.Swap(time.Now().Add(replicaCooldown).UnixNano()) < time.Now().UnixNano() {
+		r.logger.Warn("read replica failed, reading from the primary",
+			slog.String("error", err.Error()), slog.Duration("for", replicaCooldown))
+	}
+	return true
+}
+
+// Errors only surface on Scan, so that's where the primary takes over
+type fallbackRow struct {
+	ctx    context.Context
+	router *ReadRouter
+	sql    string
+	args   []any
+	row    pgx.Row
+}
+
+func (f *fallbackRow) Scan(dest ...any) error {
+	err := f.row.Scan(dest...)
+	if err != nil && f.router.failedOver(f.ctx, err) {
+		return f.router.primary.QueryRow(f.ctx, f.sql, f.args...).Scan(dest...)
+	}
+	return err
+}
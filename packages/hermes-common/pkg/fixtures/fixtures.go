@@ -0,0 +1,228 @@
+// Package fixtures records provider webhooks as sanitized JSON files and plays
+// them back. The file format is plain JSON so any language can read the corpus
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+type Fixture struct {
+	Provider   string            `json:"provider"`
+	Name       string            `json:"name"`
+	RecordedAt time.Time         `json:"recorded_at"`
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers"`
+	// JSON bodies are kept as JSON so fixtures diff nicely, anything else goes in BodyText
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+}
+
+// Headers that identify the sending provider, checked in order
+var providerHeaders = []struct {
+	header   string
+	provider string
+}{
+	{"X-GitHub-Event", "github"},
+	{"X-Gitlab-Event", "gitlab"},
+	{"Stripe-Signature", "stripe"},
+	{"X-Slack-Signature", "slack"},
+	{"X-Shopify-Topic", "shopify"},
+	{"X-Event-Key", "bitbucket"},
+	{"X-Twilio-Signature", "twilio"},
+	{"Linear-Delivery", "linear"},
+}
+
+// Headers worth keeping in a fixture; everything else is transport noise
+var keptHeaders = map[string]bool{
+	"Content-Type": true,
+	"User-Agent":   true,
+	"X-Event-Id":   true,
+}
+
+var sensitiveHeader = regexp.MustCompile(`(?i)(authorization|cookie|signature|token|secret|api-key|hmac)`)
+
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|authorization|email|phone|card|ssn|iban)`)
+
+// Guesses the sending provider from its signature headers
+func DetectProvider(h http.Header) string {
+	for _, ph := range providerHeaders {
+		if h.Get(ph.header) != "" {
+			return ph.provider
+		}
+	}
+	return "unknown"
+}
+
+// Builds a sanitized fixture from an incoming webhook
+func Capture(r *http.Request, body []byte) Fixture {
+	provider := DetectProvider(r.Header)
+	f := Fixture{
+		Provider:   provider,
+		Name:       fixtureName(provider, r.Header),
+		RecordedAt: time.Now().UTC(),
+		Method:     r.Method,
+		Headers:    map[string]string{},
+	}
+	for key, values := range r.Header {
+		canonical := http.CanonicalHeaderKey(key)
+		keep := keptHeaders[canonical]
+		for _, ph := range providerHeaders {
+			if strings.EqualFold(ph.header, canonical) || strings.HasPrefix(strings.ToLower(canonical), "x-"+ph.provider) {
+				keep = true
+			}
+		}
+		if !keep || len(values) == 0 {
+			continue
+		}
+		if sensitiveHeader.MatchString(canonical) {
+			f.Headers[canonical] = redacted
+		} else {
+			f.Headers[canonical] = values[0]
+		}
+	}
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		// Providers without an event header (e.g. Stripe) name the event in the body
+		if obj, ok := decoded.(map[string]any); ok && f.Name == provider+"-event" {
+			for _, key := range []string{"type", "event", "event_type"} {
+				if v, ok := obj[key].(string); ok && v != "" {
+					f.Name = slug(v)
+					break
+				}
+			}
+		}
+		f.Body, _ = json.Marshal(sanitize(decoded))
+	} else {
+		f.BodyText = string(body)
+	}
+	return f
+}
+
+func fixtureName(provider string, h http.Header) string {
+	for _, key := range []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Shopify-Topic", "X-Event-Key"} {
+		if v := h.Get(key); v != "" {
+			return slug(v)
+		}
+	}
+	return provider + "-event"
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slug(s string) string {
+	return strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// Replaces values under sensitive-looking keys at any depth
+func sanitize(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, inner := range val {
+			if sensitiveKey.MatchString(k) {
+				if _, nested := inner.(map[string]any); !nested {
+					val[k] = redacted
+					continue
+				}
+			}
+			val[k] = sanitize(inner)
+		}
+		return val
+	case []any:
+		for i := range val {
+			val[i] = sanitize(val[i])
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// Writes the fixture to dir/<provider>/<name>-<timestamp>.json and returns the path
+func (f Fixture) Save(dir string) (string, error) {
+	providerDir := filepath.Join(dir, slug(f.Provider))
+	if err := os.MkdirAll(providerDir, 0o755); err != nil {
+		return "", fmt.Errorf("create fixture dir: %w", err)
+	}
+	path := filepath.Join(providerDir, fmt.Sprintf("%s-%s.json", slug(f.Name), f.RecordedAt.Format("20060102T150405.000000000")))
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal fixture: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("write fixture: %w", err)
+	}
+	return path, nil
+}
+
+func Load(path string) (Fixture, error) {
+	var f Fixture
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("read fixture: %w", err)
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("parse fixture %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Loads every *.json fixture under root, sorted by path
+func LoadDir(root string) ([]Fixture, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".json") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk fixtures: %w", err)
+	}
+	sort.Strings(paths)
+	out := make([]Fixture, 0, len(paths))
+	for _, p := range paths {
+		f, err := Load(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func (f Fixture) BodyBytes() []byte {
+	if len(f.Body) > 0 {
+		return f.Body
+	}
+	return []byte(f.BodyText)
+}
+
+// Rebuilds the recorded request aimed at url
+func (f Fixture) Request(url string) (*http.Request, error) {
+	method := f.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(f.BodyBytes()))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
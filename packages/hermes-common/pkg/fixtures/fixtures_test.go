@@ -0,0 +1,38 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCaptureSanitizes(t *testing.T) {
+	body := []byte(`{"action":"opened","sender":{"login":"octo","email":"octo@example.com"},"token":"abc"}`)
+	req, _ := http.NewRequest("POST", "/hooks/r1", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set("X-Hub-Signature-256", "sha256=deadbeef")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+
+	f := Capture(req, body)
+	if f.Provider != "github" || f.Name != "pull-request" {
+		t.Fatalf("Expected github/pull-request, got %s/%s", f.Provider, f.Name)
+	}
+	if _, ok := f.Headers["Authorization"]; ok {
+		t.Error("Authorization header should not be recorded")
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(f.Body, &decoded); err != nil {
+		t.Fatalf("fixture body should stay JSON: %v", err)
+	}
+	if decoded["token"] != redacted {
+		t.Errorf("token should be redacted, got %v", decoded["token"])
+	}
+	if decoded["sender"].(map[string]any)["email"] != redacted {
+		t.Error("nested email should be redacted")
+	}
+	if decoded["action"] != "opened" {
+		t.Error("non-sensitive fields should be kept")
+	}
+}
@@ -0,0 +1,156 @@
+// Package flags decides whether a feature that is being rolled out
+// gradually is on for a given user
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// Flag names are lowercase snake case, such as dag_engine
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// A feature's rollout. Enabled is the kill switch, RolloutPercent the share
+// of users who get it while enabled. Overrides pin it on or off per user
+type Flag struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description,omitempty"`
+	Enabled        bool            `json:"enabled"`
+	RolloutPercent int             `json:"rollout_percent"`
+	Overrides      map[string]bool `json:"overrides,omitempty"`
+}
+
+// Whether the flag is on for userID. Users land in a stable bucket per flag,
+// so raising the percentage only ever adds users
+func (f Flag) EnabledFor(userID string) bool {
+	if on, ok := f.Overrides[userID]; ok {
+		return on
+	}
+	if !f.Enabled || f.RolloutPercent <= 0 {
+		return false
+	}
+	return f.RolloutPercent >= 100 || bucket(f.Name, userID) < f.RolloutPercent
+}
+
+func bucket(name, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(userID))
+	return int(h.Sum32() % 100)
+}
+
+// Flags by name
+type Set map[string]Flag
+
+// Unknown flags are off
+func (s Set) Enabled(name, userID string) bool {
+	flag, ok := s[name]
+	return ok && flag.EnabledFor(userID)
+}
+
+// Parses FEATURE_FLAGS style specs: comma separated name=on, name=off or
+// name=<percent>%, e.g. "dag_engine=10%,sync_execution=off"
+func Parse(spec string) (Set, error) {
+	set := Set{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !ValidName(name) {
+			return nil, fmt.Errorf("flag %q must look like name=on, name=off or name=25%%", entry)
+		}
+		flag := Flag{Name: name}
+		switch value {
+		case "on":
+			flag.Enabled, flag.RolloutPercent = true, 100
+		case "off":
+		default:
+			percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if !strings.HasSuffix(value, "%") || err != nil || percent < 0 || percent > 100 {
+				return nil, fmt.Errorf("flag %s must be on, off or a percentage from 0%% to 100%%", name)
+			}
+			flag.Enabled, flag.RolloutPercent = true, percent
+		}
+		set[name] = flag
+	}
+	return set, nil
+}
+
+// Evaluates flags from config defaults overlaid by flags loaded from
+// storage, which win by name. Loaded flags are cached for ttl, and the last
+// good load is kept when storage fails
+type Evaluator struct {
+	load   func(ctx context.Context) (Set, error)
+	ttl    time.Duration
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	defaults Set
+	loaded   Set
+	loadedAt time.Time
+}
+
+// load may be nil to evaluate the defaults alone
+func NewEvaluator(defaults Set, load func(ctx context.Context) (Set, error), ttl time.Duration, logger *slog.Logger) *Evaluator {
+	return &Evaluator{load: load, ttl: ttl, logger: logger, defaults: defaults}
+}
+
+// Replaces the config defaults, e.g. after a config reload
+func (e *Evaluator) SetDefaults(defaults Set) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults = defaults
+}
+
+// Makes the next evaluation load from storage, after flags were changed
+func (e *Evaluator) Invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadedAt = time.Time{}
+}
+
+// The flags in effect. The result is shared, don't modify it
+func (e *Evaluator) Flags(ctx context.Context) Set {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.load != nil && time.Since(e.loadedAt) >= e.ttl {
+		loaded, err := e.load(ctx)
+		if err != nil {
+			e.logger.Warn("failed to load feature flags, using the last known ones",
+				slog.String("error", err.Error()))
+		} else {
+			e.loaded = loaded
+		}
+		// A failing store is retried after ttl rather than on every call
+		e.loadedAt = time.Now()
+	}
+	if len(e.loaded) == 0 {
+		return e.defaults
+	}
+	merged := maps.Clone(e.defaults)
+	if merged == nil {
+		merged = Set{}
+	}
+	maps.Copy(merged, e.loaded)
+	return merged
+}
+
+func (e *Evaluator) Enabled(ctx context.Context, name, userID string) bool {
+	return e.Flags(ctx).Enabled(name, userID)
+}
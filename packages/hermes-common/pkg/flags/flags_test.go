@@ -0,0 +1,86 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func TestParse(t *testing.T) {
+	set, err := Parse("dag_engine=25%, sync_execution=on,legacy_mapper=off")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if f := set["dag_engine"]; !f.Enabled || f.RolloutPercent != 25 {
+		t.Errorf("Expected dag_engine at 25%%, got %+v", f)
+	}
+	if !set.Enabled("sync_execution", "any-user") || set.Enabled("legacy_mapper", "any-user") {
+		t.Error("Expected on to be on for everyone and off for no one")
+	}
+	for _, bad := range []string{"dag_engine", "DAG=on", "dag_engine=maybe", "dag_engine=101%", "dag_engine=25"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRolloutIsStableAndGrows(t *testing.T) {
+	flag := Flag{Name: "dag_engine", Enabled: true, RolloutPercent: 20}
+	var at20 []string
+	for i := range 1000 {
+		user := fmt.Sprintf("user-%d", i)
+		if flag.EnabledFor(user) {
+			at20 = append(at20, user)
+		}
+	}
+	if len(at20) < 150 || len(at20) > 250 {
+		t.Errorf("Expected about 200 of 1000 users at 20%%, got %d", len(at20))
+	}
+	flag.RolloutPercent = 50
+	for _, user := range at20 {
+		if !flag.EnabledFor(user) {
+			t.Fatalf("Expected %s to keep the flag as the rollout grows", user)
+		}
+	}
+	flag.Enabled = false
+	flag.Overrides = map[string]bool{"beta-user": true}
+	if flag.EnabledFor(at20[0]) || !flag.EnabledFor("beta-user") {
+		t.Error("Expected the kill switch to win over the rollout, and overrides over both")
+	}
+}
+
+func TestEvaluatorOverlaysLoadedFlags(t *testing.T) {
+	defaults, _ := Parse("dag_engine=off,sync_execution=on")
+	loads := 0
+	var loadErr error
+	e := NewEvaluator(defaults, func(context.Context) (Set, error) {
+		loads++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return Set{"dag_engine": {Name: "dag_engine", Enabled: true, RolloutPercent: 100}}, nil
+	}, time.Hour, logger.New("hermes-test", "test", "error"))
+	ctx := context.Background()
+
+	if !e.Enabled(ctx, "dag_engine", "u1") || !e.Enabled(ctx, "sync_execution", "u1") {
+		t.Error("Expected the stored flag to win and the default to remain")
+	}
+	e.Enabled(ctx, "dag_engine", "u2")
+	if loads != 1 {
+		t.Errorf("Expected one load within the ttl, got %d", loads)
+	}
+
+	loadErr = errors.New("connection refused")
+	e.Invalidate()
+	if !e.Enabled(ctx, "dag_engine", "u1") || loads != 2 {
+		t.Errorf("Expected the last good flags after a failed load, got %d loads", loads)
+	}
+	e.SetDefaults(Set{})
+	if e.Enabled(ctx, "sync_execution", "u1") {
+		t.Error("Expected new defaults to apply")
+	}
+}
@@ -0,0 +1,43 @@
+// Package idempotency derives the keys actions hand to destinations so a
+// retried action isn't applied twice downstream
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HTTP header destinations like Stripe dedupe requests on
+const Header = "Idempotency-Key"
+
+// gRPC metadata key for external executors
+const MetadataKey = "idempotency-key"
+
+// Stable key for one action of one event. The attempt is left out on purpose:
+// every retry of the action must present the same key for the destination to
+// recognise it. Empty when the event has no ID to derive it from
+func Key(relayID, eventID, actionID string) string {
+	if eventID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(relayID + "\n" + eventID + "\n" + actionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+type ctxKey struct{}
+
+// Carries the key for the action being executed, along with which attempt this is
+type Value struct {
+	Key     string
+	Attempt int
+}
+
+func WithContext(ctx context.Context, v Value) context.Context {
+	return context.WithValue(ctx, ctxKey{}, v)
+}
+
+func FromContext(ctx context.Context) (Value, bool) {
+	v, ok := ctx.Value(ctxKey{}).(Value)
+	return v, ok && v.Key != ""
+}
@@ -0,0 +1,35 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKeyIsStablePerAction(t *testing.T) {
+	a := Key("relay", "evt-1", "action-1")
+	if a == "" || a != Key("relay", "evt-1", "action-1") {
+		t.Fatalf("Expected the same key for the same action, got %q", a)
+	}
+	for _, other := range []string{
+		Key("relay", "evt-1", "action-2"),
+		Key("relay", "evt-2", "action-1"),
+		Key("other", "evt-1", "action-1"),
+	} {
+		if other == a {
+			t.Errorf("Expected a different key, got %q twice", a)
+		}
+	}
+	if Key("relay", "", "action-1") != "" {
+		t.Error("Expected no key without an event ID")
+	}
+}
+
+func TestContextRoundTrip(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("Expected no key on an empty context")
+	}
+	ctx := WithContext(context.Background(), Value{Key: "k", Attempt: 2})
+	if v, ok := FromContext(ctx); !ok || v.Key != "k" || v.Attempt != 2 {
+		t.Errorf("Expected the key back, got %+v, %v", v, ok)
+	}
+}
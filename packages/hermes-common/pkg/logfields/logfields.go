@@ -0,0 +1,40 @@
+// Package logfields names the IDs hooks, core and the worker put on their log
+// lines, so one query in the log aggregator follows an event end to end
+package logfields
+
+import "log/slog"
+
+const (
+	EventKey = "event_id"
+	RelayKey = "relay_id"
+	TraceKey = "trace_id"
+	UserKey  = "user_id"
+)
+
+func EventID(id string) slog.Attr {
+	return slog.String(EventKey, id)
+}
+
+func RelayID(id string) slog.Attr {
+	return slog.String(RelayKey, id)
+}
+
+func TraceID(id string) slog.Attr {
+	return slog.String(TraceKey, id)
+}
+
+func UserID(id string) slog.Attr {
+	return slog.String(UserKey, id)
+}
+
+// The IDs tying log lines to one event, for slog.Logger.With. Empty IDs are
+// left out so lines don't carry blank fields
+func Event(relayID, eventID, traceID string) []any {
+	var attrs []any
+	for _, attr := range []slog.Attr{RelayID(relayID), EventID(eventID), TraceID(traceID)} {
+		if attr.Value.String() != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
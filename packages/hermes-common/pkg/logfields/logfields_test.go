@@ -0,0 +1,23 @@
+package logfields
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestEventLeavesOutEmptyIDs(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil)).With(Event("r1", "", "abc")...)
+	log.Info("queued", UserID("u1"))
+	line := buf.String()
+	for _, want := range []string{"relay_id=r1", "trace_id=abc", "user_id=u1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %s in %q", want, line)
+		}
+	}
+	if strings.Contains(line, EventKey) {
+		t.Errorf("Expected no empty event_id in %q", line)
+	}
+}
@@ -8,17 +8,14 @@ import (
 
 // Creates a configured logger for a service
 func New(serviceName, environment, level string) *slog.Logger {
-	var logLevel slog.Level
-	switch level {
-	case "DEBUG":
-		logLevel = slog.LevelDebug
-	case "WARN":
-		logLevel = slog.LevelWarn
-	case "ERROR":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
-	}
+	logger, _ := NewLeveled(serviceName, environment, level)
+	return logger
+}
+
+// Like New, but the returned level can be changed while the logger is in use
+func NewLeveled(serviceName, environment, level string) (*slog.Logger, *slog.LevelVar) {
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(ParseLevel(level))
 
 	var handler slog.Handler
 	opts := &slog.HandlerOptions{Level: logLevel}
@@ -32,7 +29,21 @@ func New(serviceName, environment, level string) *slog.Logger {
 	return slog.New(handler).With(
 		slog.String("service", serviceName),
 		slog.String("environment", environment),
-	)
+	), logLevel
+}
+
+// Maps DEBUG, WARN and ERROR to their slog level, anything else is INFO
+func ParseLevel(level string) slog.Level {
+	switch level {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }
 
 func LogDuration(logger *slog.Logger, operation string, start time.Time) {
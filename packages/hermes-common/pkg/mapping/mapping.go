@@ -0,0 +1,267 @@
+// Package mapping builds a new JSON payload from an input one using declarative
+// source -> destination path rules. Core validates map action configs with it,
+// the worker applies them
+package mapping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// One field of the output. The value comes from the From path in the input,
+// or is the constant Value. Default stands in when the source is missing or null
+type Rule struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Value    any    `json:"value"`
+	Default  any    `json:"default"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+// Config of a map action:
+//
+//	{"mappings": [{"from": "pull_request.title", "to": "text"},
+//	              {"from": "number", "to": "meta.id", "type": "string"}],
+//	 "keep_unmapped": false}
+//
+// Paths are dotted keys with optional [n] indexes, e.g. commits[0].author.name;
+// a leading "$." is allowed. Types are string, number, integer and boolean
+type Spec struct {
+	Mappings []Rule `json:"mappings"`
+	// Start from a copy of the input instead of an empty object
+	KeepUnmapped bool `json:"keep_unmapped"`
+}
+
+var validTypes = map[string]bool{"": true, "string": true, "number": true, "integer": true, "boolean": true}
+
+var errNotFound = errors.New("not found")
+
+// Reads and checks a map action's config
+func ParseSpec(config map[string]any) (*Spec, error) {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("encode mapping config: %w", err)
+	}
+	var spec Spec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, fmt.Errorf("invalid mapping config: %w", err)
+	}
+	if len(spec.Mappings) == 0 {
+		return nil, errors.New("mappings must list at least one field")
+	}
+	for i, rule := range spec.Mappings {
+		if _, err := parsePath(rule.To); err != nil {
+			return nil, fmt.Errorf("mapping %d: to: %w", i, err)
+		}
+		if rule.Value == nil {
+			if _, err := parsePath(rule.From); err != nil {
+				return nil, fmt.Errorf("mapping %d: from: %w", i, err)
+			}
+		}
+		if !validTypes[rule.Type] {
+			return nil, fmt.Errorf("mapping %d: unknown type %q", i, rule.Type)
+		}
+	}
+	return &spec, nil
+}
+
+// Builds the output payload from body. body must be JSON
+func Apply(spec *Spec, body []byte) ([]byte, error) {
+	var input any
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			return nil, fmt.Errorf("payload is not JSON: %w", err)
+		}
+	}
+	out := map[string]any{}
+	if _, ok := input.(map[string]any); ok && spec.KeepUnmapped {
+		// Round trip for a deep copy, the input is not modified
+		var copied map[string]any
+		_ = json.Unmarshal(body, &copied)
+		out = copied
+	}
+	for i, rule := range spec.Mappings {
+		value := rule.Value
+		if value == nil {
+			path, _ := parsePath(rule.From)
+			found, err := lookup(input, path)
+			if err == nil {
+				value = found
+			}
+		}
+		if value == nil {
+			value = rule.Default
+		}
+		if value == nil {
+			if rule.Required {
+				return nil, fmt.Errorf("mapping %d: %s is missing and has no default", i, rule.From)
+			}
+			continue
+		}
+		coerced, err := coerce(value, rule.Type)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d (%s): %w", i, rule.To, err)
+		}
+		path, _ := parsePath(rule.To)
+		if err := set(out, path, coerced); err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i, err)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// A path segment is a key or, when index >= 0, an array index
+type segment struct {
+	key   string
+	index int
+}
+
+func parsePath(path string) ([]segment, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, errors.New("path is empty")
+	}
+	var segs []segment
+	for _, part := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("empty segment in %q", path)
+		}
+		if key != "" {
+			segs = append(segs, segment{key: key, index: -1})
+		}
+		// rest is what follows the first "[", e.g. `0][1]`
+		for rest != "" {
+			idx, after, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(idx)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("bad index in %q", path)
+			}
+			segs = append(segs, segment{index: n})
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("bad index in %q", path)
+			}
+			rest = after[1:]
+		}
+	}
+	return segs, nil
+}
+
+func lookup(v any, path []segment) (any, error) {
+	for _, seg := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			if seg.index >= 0 {
+				return nil, errNotFound
+			}
+			next, ok := node[seg.key]
+			if !ok {
+				return nil, errNotFound
+			}
+			v = next
+		case []any:
+			i := seg.index
+			if i < 0 {
+				// Also accept numeric keys, e.g. items.0.name
+				n, err := strconv.Atoi(seg.key)
+				if err != nil {
+					return nil, errNotFound
+				}
+				i = n
+			}
+			if i >= len(node) {
+				return nil, errNotFound
+			}
+			v = node[i]
+		default:
+			return nil, errNotFound
+		}
+	}
+	return v, nil
+}
+
+// Writes value at path, creating objects on the way. Output paths are keys only
+func set(out map[string]any, path []segment, value any) error {
+	node := out
+	for i, seg := range path {
+		if seg.index >= 0 {
+			return errors.New("output paths can't contain array indexes")
+		}
+		if i == len(path)-1 {
+			node[seg.key] = value
+			return nil
+		}
+		next, ok := node[seg.key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			node[seg.key] = next
+		}
+		node = next
+	}
+	return nil
+}
+
+func coerce(v any, typ string) (any, error) {
+	switch typ {
+	case "":
+		return v, nil
+	case "string":
+		switch t := v.(type) {
+		case string:
+			return t, nil
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(t), nil
+		default:
+			b, err := json.Marshal(t)
+			return string(b), err
+		}
+	case "number", "integer":
+		var f float64
+		switch t := v.(type) {
+		case float64:
+			f = t
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+			if err != nil {
+				return nil, fmt.Errorf("can't convert %q to a %s", t, typ)
+			}
+			f = parsed
+		case bool:
+			if t {
+				f = 1
+			}
+		default:
+			return nil, fmt.Errorf("can't convert %T to a %s", v, typ)
+		}
+		if typ == "integer" {
+			return int64(math.Trunc(f)), nil
+		}
+		return f, nil
+	case "boolean":
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(t))
+			if err != nil {
+				return nil, fmt.Errorf("can't convert %q to a boolean", t)
+			}
+			return parsed, nil
+		case float64:
+			return t != 0, nil
+		default:
+			return nil, fmt.Errorf("can't convert %T to a boolean", v)
+		}
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
@@ -0,0 +1,91 @@
+package mapping
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustSpec(t *testing.T, config string) *Spec {
+	t.Helper()
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(config), &raw); err != nil {
+		t.Fatalf("bad test config: %v", err)
+	}
+	spec, err := ParseSpec(raw)
+	if err != nil {
+		t.Fatalf("ParseSpec failed: %v", err)
+	}
+	return spec
+}
+
+func TestApplyBuildsNewShape(t *testing.T) {
+	spec := mustSpec(t, `{"mappings": [
+		{"from": "$.pull_request.title", "to": "text"},
+		{"from": "commits[1].author.name", "to": "meta.author"},
+		{"from": "number", "to": "meta.id", "type": "string"},
+		{"from": "missing", "to": "meta.channel", "default": "#ops"},
+		{"value": "github", "to": "source"}
+	]}`)
+	body := []byte(`{"pull_request": {"title": "Fix it"}, "number": 42,
+		"commits": [{"author": {"name": "a"}}, {"author": {"name": "b"}}]}`)
+
+	out, err := Apply(spec, body)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	expected := `{"meta":{"author":"b","channel":"#ops","id":"42"},"source":"github","text":"Fix it"}`
+	if string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
+
+func TestApplyCoercesTypes(t *testing.T) {
+	spec := mustSpec(t, `{"mappings": [
+		{"from": "amount", "to": "amount", "type": "number"},
+		{"from": "count", "to": "count", "type": "integer"},
+		{"from": "live", "to": "live", "type": "boolean"},
+		{"from": "flag", "to": "flag", "type": "boolean"}
+	]}`)
+	out, err := Apply(spec, []byte(`{"amount": "12.50", "count": 3.9, "live": "true", "flag": 0}`))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	expected := `{"amount":12.5,"count":3,"flag":false,"live":true}`
+	if string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+
+	bad := mustSpec(t, `{"mappings": [{"from": "amount", "to": "amount", "type": "number"}]}`)
+	if _, err := Apply(bad, []byte(`{"amount": "lots"}`)); err == nil {
+		t.Error("Expected an error for a value that can't be coerced")
+	}
+}
+
+func TestApplyRequiredAndKeepUnmapped(t *testing.T) {
+	required := mustSpec(t, `{"mappings": [{"from": "id", "to": "id", "required": true}]}`)
+	if _, err := Apply(required, []byte(`{"other": 1}`)); err == nil {
+		t.Error("Expected an error for a missing required field")
+	}
+
+	keep := mustSpec(t, `{"keep_unmapped": true, "mappings": [{"from": "a", "to": "b"}]}`)
+	out, err := Apply(keep, []byte(`{"a": 1, "c": {"d": 2}}`))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if string(out) != `{"a":1,"b":1,"c":{"d":2}}` {
+		t.Errorf("Expected the input kept alongside the mapping, got %s", out)
+	}
+}
+
+func TestParseSpecRejectsBadConfig(t *testing.T) {
+	for _, config := range []map[string]any{
+		{},
+		{"mappings": []any{map[string]any{"from": "a"}}},
+		{"mappings": []any{map[string]any{"from": "a[x]", "to": "b"}}},
+		{"mappings": []any{map[string]any{"from": "a", "to": "b", "type": "date"}}},
+	} {
+		if _, err := ParseSpec(config); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package mapping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Placeholders are {{path}} with a path as in Rule.From, e.g.
+// https://api.example.com/users/{{sender.login}}
+const (
+	openDelim  = "{{"
+	closeDelim = "}}"
+)
+
+// Checks that every placeholder in tmpl is closed and holds a valid path
+func CheckTemplate(tmpl string) error {
+	_, err := expand(tmpl, func(path []segment) (string, error) { return "", nil })
+	return err
+}
+
+// Fills tmpl's placeholders from the JSON body. Strings are used as they are,
+// other values as JSON. escape, when set, is applied to each value, e.g.
+// url.PathEscape for URLs. A path missing from body is an error
+func Render(tmpl string, body []byte, escape func(string) string) (string, error) {
+	if !strings.Contains(tmpl, openDelim) {
+		return tmpl, nil
+	}
+	var input any
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			return "", fmt.Errorf("payload is not JSON: %w", err)
+		}
+	}
+	return expand(tmpl, func(path []segment) (string, error) {
+		value, err := lookup(input, path)
+		if err != nil || value == nil {
+			return "", errNotFound
+		}
+		s, err := coerce(value, "string")
+		if err != nil {
+			return "", err
+		}
+		if escape != nil {
+			return escape(s.(string)), nil
+		}
+		return s.(string), nil
+	})
+}
+
+func expand(tmpl string, value func(path []segment) (string, error)) (string, error) {
+	var out strings.Builder
+	rest := tmpl
+	for {
+		before, after, found := strings.Cut(rest, openDelim)
+		out.WriteString(before)
+		if !found {
+			return out.String(), nil
+		}
+		raw, remaining, closed := strings.Cut(after, closeDelim)
+		if !closed {
+			return "", errors.New("unclosed {{ in template")
+		}
+		name := strings.TrimSpace(raw)
+		path, err := parsePath(name)
+		if err != nil {
+			return "", fmt.Errorf("template placeholder: %w", err)
+		}
+		s, err := value(path)
+		if errors.Is(err, errNotFound) {
+			return "", fmt.Errorf("template placeholder %s is missing from the payload", name)
+		}
+		if err != nil {
+			return "", fmt.Errorf("template placeholder %s: %w", name, err)
+		}
+		out.WriteString(s)
+		rest = remaining
+	}
+}
@@ -0,0 +1,39 @@
+package mapping
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRenderFillsPlaceholders(t *testing.T) {
+	body := []byte(`{"sender": {"login": "a b/c"}, "number": 42, "labels": ["bug"]}`)
+	got, err := Render("https://api.example.com/users/{{ sender.login }}?n={{number}}", body, url.PathEscape)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if expected := "https://api.example.com/users/a%20b%2Fc?n=42"; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got, _ := Render("Bearer {{labels}}", body, nil); got != `Bearer ["bug"]` {
+		t.Errorf("Expected non-strings rendered as JSON, got %s", got)
+	}
+	if got, _ := Render("no placeholders", []byte("not json"), nil); got != "no placeholders" {
+		t.Errorf("Expected a plain template unchanged, got %s", got)
+	}
+	if _, err := Render("{{missing}}", body, nil); err == nil {
+		t.Error("Expected a missing path to be an error")
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	for _, tmpl := range []string{"https://x/{{a.b[0]}}", "plain"} {
+		if err := CheckTemplate(tmpl); err != nil {
+			t.Errorf("%q: expected valid, got %v", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"https://x/{{a", "{{}}", "{{a[x]}}"} {
+		if err := CheckTemplate(tmpl); err == nil {
+			t.Errorf("%q: expected an error", tmpl)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Package migrate applies the Hermes schema migrations embedded in the
+// service binaries. State is kept in golang-migrate's schema_migrations
+// table, so databases migrated with the migrate CLI carry on where they left off
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+// Held while migrating so replicas starting together apply each migration once
+const lockKey = 7421930056
+
+var ErrDirty = errors.New("database schema is dirty")
+
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// Where a database stands. Version 0 means no migration has been applied
+type Status struct {
+	Version uint `json:"version"`
+	Latest  uint `json:"latest"`
+	Dirty   bool `json:"dirty"`
+}
+
+func (s Status) Pending() bool {
+	return s.Version < s.Latest
+}
+
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// A migrator for the migrations built into this binary
+func New(db *pgxpool.Pool, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// Reads NNNNNN_name.up.sql and NNNNNN_name.down.sql files from migrations/,
+// ordered by version. Down files are optional
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[uint]*Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		stem, direction, ok := cutDirection(base)
+		prefix, label, found := strings.Cut(stem, "_")
+		version, err := strconv.ParseUint(prefix, 10, 32)
+		if !ok || !found || err != nil || version == 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNNNN_name.up.sql or NNNNNN_name.down.sql", base)
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", base, err)
+		}
+		m := byVersion[uint(version)]
+		if m == nil {
+			m = &Migration{Version: uint(version), Name: label}
+			byVersion[uint(version)] = m
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %06d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func cutDirection(name string) (stem, direction string, ok bool) {
+	if stem, ok := strings.CutSuffix(name, ".up.sql"); ok {
+		return stem, "up", true
+	}
+	if stem, ok := strings.CutSuffix(name, ".down.sql"); ok {
+		return stem, "down", true
+	}
+	return "", "", false
+}
+
+func (m *Migrator) latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// The applied version next to the newest one this binary knows
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return Status{}, fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+	return m.status(ctx, conn.Conn())
+}
+
+func (m *Migrator) status(ctx context.Context, conn *pgx.Conn) (Status, error) {
+	s := Status{Latest: m.latest()}
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return s, fmt.Errorf("check schema_migrations: %w", err)
+	}
+	if !exists {
+		return s, nil
+	}
+	var version int64
+	err := conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &s.Dirty)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return s, fmt.Errorf("read schema version: %w", err)
+	}
+	if version > 0 {
+		s.Version = uint(version)
+	}
+	return s, nil
+}
+
+// Applies every pending migration, returning how many ran
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *pgx.Conn, s Status) error {
+		for _, mig := range m.migrations {
+			if mig.Version <= s.Version {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig.Version, mig.up, fmt.Sprintf("%06d_%s.up.sql", mig.Version, mig.Name)); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Reverts the last steps migrations, returning how many ran
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps < 1 {
+		return 0, fmt.Errorf("steps must be at least 1")
+	}
+	reverted := 0
+	err := m.locked(ctx, func(conn *pgx.Conn, s Status) error {
+		if s.Version > s.Latest {
+			return fmt.Errorf("database is at version %d, newer than this binary's %d", s.Version, s.Latest)
+		}
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := m.migrations[i]
+			if mig.Version > s.Version {
+				continue
+			}
+			if mig.down == "" {
+				return fmt.Errorf("migration %06d_%s has no down file", mig.Version, mig.Name)
+			}
+			var target uint
+			if i > 0 {
+				target = m.migrations[i-1].Version
+			}
+			if err := m.apply(ctx, conn, target, mig.down, fmt.Sprintf("%06d_%s.down.sql", mig.Version, mig.Name)); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Records version as applied and clean without running anything, after a
+// failed migration was repaired by hand
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	return setVersion(ctx, conn.Conn(), version, false)
+}
+
+// Runs fn holding the migration lock on one connection, refusing to touch a
+// dirty schema
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgx.Conn, s Status) error) error {
+	pooled, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer pooled.Release()
+	conn := pooled.Conn()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(lockKey)); err != nil {
+		return fmt.Errorf("take migration lock: %w", err)
+	}
+	defer func() {
+		// Released with a fresh context so a cancelled run doesn't keep the lock
+		if _, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, int64(lockKey)); err != nil {
+			m.logger.Warn("failed to release migration lock", slog.String("error", err.Error()))
+		}
+	}()
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	s, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if s.Dirty {
+		return fmt.Errorf("%w at version %d: fix it by hand, then force a version", ErrDirty, s.Version)
+	}
+	return fn(conn, s)
+}
+
+// Marks the target version dirty while the SQL runs, so a failure leaves a
+// record of where it stopped
+func (m *Migrator) apply(ctx context.Context, conn *pgx.Conn, target uint, sql, file string) error {
+	if err := setVersion(ctx, conn, target, true); err != nil {
+		return err
+	}
+	// No arguments, so pgx sends the file as one multi-statement query
+	if _, err := conn.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("run %s: %w", file, err)
+	}
+	if err := setVersion(ctx, conn, target, false); err != nil {
+		return err
+	}
+	m.logger.Info("migration applied", slog.String("file", file))
+	return nil
+}
+
+func ensureTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// Version 0 leaves the table empty, as the migrate CLI does once everything is reverted
+func setVersion(ctx context.Context, conn *pgx.Conn, version uint, dirty bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `TRUNCATE schema_migrations`); err != nil {
+		return fmt.Errorf("clear schema version: %w", err)
+	}
+	if version > 0 || dirty {
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, int64(version), dirty); err != nil {
+			return fmt.Errorf("record schema version: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Runs the migrate subcommand: up, down [steps], version or force <version>
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		args = []string{"up"}
+	}
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migrations\n", applied)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("steps must be a number, got %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %d migrations\n", reverted)
+	case args[0] == "force" && len(args) == 2:
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("version must be a number, got %q", args[1])
+		}
+		if err := m.Force(ctx, uint(version)); err != nil {
+			return err
+		}
+	case args[0] != "version" || len(args) != 1:
+		return fmt.Errorf("usage: migrate [up | down [steps] | version | force <version>]")
+	}
+	s, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "version %d of %d", s.Version, s.Latest)
+	if s.Dirty {
+		fmt.Fprint(out, " (dirty)")
+	}
+	fmt.Fprintln(out)
+	return nil
+}
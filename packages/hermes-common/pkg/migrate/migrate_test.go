@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != uint(i+1) {
+			t.Fatalf("Expected migration %d to be version %d, got %d", i, i+1, m.Version)
+		}
+	}
+	if len(migrations) < 31 {
+		t.Errorf("Expected at least 31 migrations, got %d", len(migrations))
+	}
+}
+
+func TestLoadOrdersAndValidates(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"migrations/000010_users.up.sql":   {Data: []byte("CREATE TABLE users ();")},
+		"migrations/000002_relays.up.sql":  {Data: []byte("CREATE TABLE relays ();")},
+		"migrations/000010_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 2 || migrations[1].Name != "users" || migrations[1].down == "" {
+		t.Errorf("Expected relays then users with its down file, got %+v", migrations)
+	}
+
+	for _, bad := range []string{"migrations/init.up.sql", "migrations/000003_x.sql", "migrations/000004_only.down.sql"} {
+		if _, err := Load(fstest.MapFS{bad: {Data: []byte("SELECT 1;")}}); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestCommandRejectsBadArguments(t *testing.T) {
+	m := &Migrator{}
+	for _, args := range [][]string{{"sideways"}, {"down", "two"}, {"force"}, {"version", "3"}} {
+		err := Command(context.Background(), m, args, io.Discard)
+		if err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+	err := Command(context.Background(), m, []string{"sideways"}, io.Discard)
+	if err == nil || !strings.HasPrefix(err.Error(), "usage:") {
+		t.Errorf("Expected a usage message, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+-- Events that exhausted their delivery attempts
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    payload JSONB,
+    reason TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'dead',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    requeued_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_relay_id ON dead_letters(relay_id);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_created_at ON dead_letters(created_at DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_jobs;
+ALTER TABLE relay_actions DROP COLUMN IF EXISTS agent_group;
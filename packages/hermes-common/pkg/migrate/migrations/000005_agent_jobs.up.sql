@@ -0,0 +1,23 @@
+-- Actions with an agent_group run on self-hosted agents instead of the worker
+ALTER TABLE relay_actions ADD COLUMN IF NOT EXISTS agent_group TEXT;
+
+-- Work handed to agents, claimed through the long-poll API
+CREATE TABLE IF NOT EXISTS agent_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    event_id TEXT,
+    agent_group TEXT NOT NULL,
+    action_type TEXT NOT NULL,
+    config JSONB NOT NULL,
+    payload JSONB,
+    status TEXT NOT NULL DEFAULT 'pending',
+    agent_id TEXT,
+    output TEXT,
+    error_message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    claimed_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_jobs_pending ON agent_jobs(agent_group, created_at) WHERE status = 'pending';
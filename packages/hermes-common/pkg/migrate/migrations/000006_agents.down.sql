@@ -0,0 +1,6 @@
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS min_agent_version;
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS agent_labels;
+ALTER TABLE relay_actions DROP COLUMN IF EXISTS min_agent_version;
+ALTER TABLE relay_actions DROP COLUMN IF EXISTS agent_labels;
+DROP TABLE IF EXISTS agent_enrollment_tokens;
+DROP TABLE IF EXISTS agents;
@@ -0,0 +1,31 @@
+-- Registered agents. Only a hash of the agent credential is stored
+CREATE TABLE IF NOT EXISTS agents (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    agent_group TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    version TEXT NOT NULL DEFAULT '',
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_agents_group ON agents(agent_group);
+
+-- Single-use tokens an operator hands to a new agent
+CREATE TABLE IF NOT EXISTS agent_enrollment_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash TEXT NOT NULL UNIQUE,
+    agent_group TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Targeting rules: the agent must carry every label, and be at least this version
+ALTER TABLE relay_actions ADD COLUMN IF NOT EXISTS agent_labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE relay_actions ADD COLUMN IF NOT EXISTS min_agent_version TEXT;
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS agent_labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS min_agent_version TEXT;
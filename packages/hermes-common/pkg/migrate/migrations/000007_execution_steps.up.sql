@@ -0,0 +1,15 @@
+-- One row per action run, so a failed execution points at the step that broke
+CREATE TABLE IF NOT EXISTS execution_steps (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    execution_log_id UUID NOT NULL REFERENCES execution_logs(id) ON DELETE CASCADE,
+    action_id UUID REFERENCES relay_actions(id) ON DELETE SET NULL,
+    action_type TEXT NOT NULL,
+    order_index INT NOT NULL,
+    status TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    error_message TEXT,
+    response TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_execution_steps_log_id ON execution_steps(execution_log_id, order_index);
@@ -0,0 +1,3 @@
+-- Scheduling priority, so alerting relays aren't starved by bulk traffic
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal'
+    CHECK (priority IN ('high', 'normal', 'low'));
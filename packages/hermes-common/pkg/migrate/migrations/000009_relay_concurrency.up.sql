@@ -0,0 +1,3 @@
+-- Max executions of a relay running at once. 0 means unlimited, 1 runs events strictly in order
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS max_concurrency INT NOT NULL DEFAULT 0
+    CHECK (max_concurrency >= 0);
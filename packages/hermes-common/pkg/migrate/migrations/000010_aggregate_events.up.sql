@@ -0,0 +1,15 @@
+-- Events held by an aggregate action until its batch is flushed
+CREATE TABLE IF NOT EXISTS aggregate_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    action_id UUID NOT NULL REFERENCES relay_actions(id) ON DELETE CASCADE,
+    order_index INT NOT NULL,
+    event_id TEXT NOT NULL,
+    payload JSONB,
+    max_events INT NOT NULL,
+    window_ms BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (action_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_aggregate_events_action ON aggregate_events(action_id, created_at);
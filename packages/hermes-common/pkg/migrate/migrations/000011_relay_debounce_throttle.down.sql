@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS held_events;
+DROP TABLE IF EXISTS relay_throttle;
+ALTER TABLE relays DROP COLUMN IF EXISTS throttle_mode;
+ALTER TABLE relays DROP COLUMN IF EXISTS throttle_seconds;
+ALTER TABLE relays DROP COLUMN IF EXISTS debounce_seconds;
@@ -0,0 +1,27 @@
+-- Debounce: run only after this many seconds without a new event, with the latest payload
+-- Throttle: run at most once per interval, dropping or queueing the rest
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS debounce_seconds INT NOT NULL DEFAULT 0 CHECK (debounce_seconds >= 0);
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS throttle_seconds INT NOT NULL DEFAULT 0 CHECK (throttle_seconds >= 0);
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS throttle_mode TEXT NOT NULL DEFAULT 'drop'
+    CHECK (throttle_mode IN ('drop', 'queue'));
+
+-- Next time a throttled relay may run
+CREATE TABLE IF NOT EXISTS relay_throttle (
+    relay_id UUID PRIMARY KEY REFERENCES relays(id) ON DELETE CASCADE,
+    next_at TIMESTAMP NOT NULL
+);
+
+-- Events waiting out a debounce or their throttle slot
+CREATE TABLE IF NOT EXISTS held_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    payload JSONB,
+    reason TEXT NOT NULL,
+    release_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A relay has at most one pending debounced event, newer events replace it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_held_events_debounce ON held_events(relay_id) WHERE reason = 'debounce';
+CREATE INDEX IF NOT EXISTS idx_held_events_release_at ON held_events(release_at);
@@ -0,0 +1,12 @@
+-- User supplied WASM modules, run by the worker as "plugin:<name>" actions
+CREATE TABLE IF NOT EXISTS plugins (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    module BYTEA NOT NULL,
+    sha256 TEXT NOT NULL,
+    size_bytes INT NOT NULL,
+    memory_limit_mb INT NOT NULL DEFAULT 16,
+    timeout_ms INT NOT NULL DEFAULT 5000,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE held_events DROP COLUMN IF EXISTS traceparent;
+DROP INDEX IF EXISTS idx_execution_logs_trace_id;
+ALTER TABLE execution_logs DROP COLUMN IF EXISTS trace_id;
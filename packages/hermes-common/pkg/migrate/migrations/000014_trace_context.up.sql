@@ -0,0 +1,6 @@
+-- Trace of the event that produced each run, from its traceparent
+ALTER TABLE execution_logs ADD COLUMN IF NOT EXISTS trace_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_execution_logs_trace_id ON execution_logs(trace_id);
+
+-- Held events keep their trace so the released run joins it
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS traceparent TEXT;
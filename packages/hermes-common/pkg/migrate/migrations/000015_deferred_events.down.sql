@@ -0,0 +1,2 @@
+ALTER TABLE held_events DROP COLUMN IF EXISTS resume_after;
+ALTER TABLE held_events DROP COLUMN IF EXISTS attempts;
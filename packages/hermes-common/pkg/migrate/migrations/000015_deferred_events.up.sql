@@ -0,0 +1,4 @@
+-- Jobs deferred by an open circuit wait here too, keeping the attempts they
+-- had already used and where an aggregate batch resumes
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS resume_after INT;
@@ -0,0 +1,2 @@
+ALTER TABLE relay_throttle DROP COLUMN IF EXISTS last_event_id;
+DROP INDEX IF EXISTS idx_held_events_event;
@@ -0,0 +1,9 @@
+-- A redelivered event must not be held twice. Debounced events keep their own
+-- one-per-relay index
+DELETE FROM held_events a USING held_events b
+WHERE a.reason <> 'debounce' AND b.reason <> 'debounce'
+  AND a.relay_id = b.relay_id AND a.event_id = b.event_id AND a.id > b.id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_held_events_event ON held_events(relay_id, event_id) WHERE reason <> 'debounce';
+
+-- Event that reserved the latest throttle slot, so its redelivery gets the same slot
+ALTER TABLE relay_throttle ADD COLUMN IF NOT EXISTS last_event_id TEXT;
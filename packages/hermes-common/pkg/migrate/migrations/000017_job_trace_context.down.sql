@@ -0,0 +1,2 @@
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS traceparent;
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS traceparent;
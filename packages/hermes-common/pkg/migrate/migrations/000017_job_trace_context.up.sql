@@ -0,0 +1,4 @@
+-- Agent jobs and dead letters keep the event's trace so agent calls and
+-- requeued runs join it
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS traceparent TEXT;
+ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS traceparent TEXT;
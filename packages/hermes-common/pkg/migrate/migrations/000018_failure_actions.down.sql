@@ -0,0 +1 @@
+ALTER TABLE relay_actions DROP COLUMN IF EXISTS on_failure;
@@ -0,0 +1,2 @@
+-- Actions in a relay's failure branch, run once the main sequence has failed for good
+ALTER TABLE relay_actions ADD COLUMN IF NOT EXISTS on_failure BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE held_events DROP COLUMN IF EXISTS call_chain;
@@ -0,0 +1,3 @@
+-- Relays that called a held event's relay through call_relay, so cycle
+-- detection survives debounce, throttle and deferral
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS call_chain TEXT[];
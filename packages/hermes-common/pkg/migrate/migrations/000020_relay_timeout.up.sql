@@ -0,0 +1,3 @@
+-- Wall clock budget for a whole execution across all its actions. 0 means no limit
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS timeout_seconds INT NOT NULL DEFAULT 0
+    CHECK (timeout_seconds >= 0);
@@ -0,0 +1,20 @@
+-- One row per worker instance, refreshed by its heartbeat
+CREATE TABLE IF NOT EXISTS worker_instances (
+    id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL DEFAULT '',
+    version TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- Lets readers tell a missed heartbeat from a slow one
+    heartbeat_interval_seconds INT NOT NULL DEFAULT 15,
+    workers INT NOT NULL DEFAULT 0,
+    active_jobs INT NOT NULL DEFAULT 0,
+    queued_jobs INT NOT NULL DEFAULT 0,
+    parked_jobs INT NOT NULL DEFAULT 0,
+    -- Messages waiting in the broker for the shared consumer
+    queue_lag INT NOT NULL DEFAULT 0,
+    draining BOOLEAN NOT NULL DEFAULT FALSE,
+    stopped_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_worker_instances_last_seen ON worker_instances(last_seen_at DESC);
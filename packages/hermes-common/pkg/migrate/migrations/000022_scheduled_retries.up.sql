@@ -0,0 +1,3 @@
+-- Failed jobs wait in held_events for their next attempt (reason 'retry'),
+-- keeping the error that sent them there
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS last_error TEXT;
@@ -0,0 +1 @@
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS idempotency_key;
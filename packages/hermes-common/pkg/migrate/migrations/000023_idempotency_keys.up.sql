@@ -0,0 +1,2 @@
+-- Agents send this with the calls they make, so a retried action is applied once
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS idempotency_key TEXT;
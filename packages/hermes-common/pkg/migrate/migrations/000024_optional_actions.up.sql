@@ -0,0 +1,2 @@
+-- Optional actions may fail without stopping the ones after them
+ALTER TABLE relay_actions ADD COLUMN IF NOT EXISTS optional BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+-- Executions an operator cancelled. Workers skip a cancelled event that is
+-- still queued and stop one that is running
+CREATE TABLE IF NOT EXISTS execution_cancellations (
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (relay_id, event_id)
+);
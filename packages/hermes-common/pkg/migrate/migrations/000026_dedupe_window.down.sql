@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_processed_events_expires_at;
+ALTER TABLE processed_events DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE relays DROP COLUMN IF EXISTS dedupe_window_seconds;
@@ -0,0 +1,9 @@
+-- How long an event ID is remembered for deduplication. 0 uses the worker default
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS dedupe_window_seconds INT NOT NULL DEFAULT 0
+    CHECK (dedupe_window_seconds >= 0);
+
+-- Rows already stored get the default 24h window from now
+ALTER TABLE processed_events ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP NOT NULL
+    DEFAULT (NOW() + INTERVAL '24 hours');
+
+CREATE INDEX IF NOT EXISTS idx_processed_events_expires_at ON processed_events(expires_at);
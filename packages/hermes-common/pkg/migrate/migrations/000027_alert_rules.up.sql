@@ -0,0 +1,17 @@
+-- Per-relay alert rules, evaluated by hermes-core against execution_logs.
+-- Alerts are published as events to the channel relays
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    condition TEXT NOT NULL CHECK (condition IN ('failure_rate', 'no_executions')),
+    threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+    window_seconds INT NOT NULL CHECK (window_seconds > 0),
+    min_executions INT NOT NULL DEFAULT 1 CHECK (min_executions >= 0),
+    channels TEXT[] NOT NULL,
+    state TEXT NOT NULL DEFAULT 'ok' CHECK (state IN ('ok', 'firing')),
+    changed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_relay_id ON alert_rules(relay_id);
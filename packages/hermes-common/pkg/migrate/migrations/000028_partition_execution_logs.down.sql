@@ -0,0 +1,42 @@
+-- Back to plain tables, copying what the partitions hold
+CREATE TABLE execution_logs_flat (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    payload JSONB,
+    error_message TEXT,
+    executed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    event_id TEXT,
+    trace_id TEXT
+);
+INSERT INTO execution_logs_flat (id, relay_id, status, payload, error_message, executed_at, event_id, trace_id)
+SELECT id, relay_id, status, payload, error_message, executed_at, event_id, trace_id FROM execution_logs;
+
+CREATE TABLE execution_steps_flat (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    execution_log_id UUID NOT NULL REFERENCES execution_logs_flat(id) ON DELETE CASCADE,
+    action_id UUID REFERENCES relay_actions(id) ON DELETE SET NULL,
+    action_type TEXT NOT NULL,
+    order_index INT NOT NULL,
+    status TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    error_message TEXT,
+    response TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+INSERT INTO execution_steps_flat (id, execution_log_id, action_id, action_type, order_index, status,
+    duration_ms, error_message, response, started_at)
+SELECT s.id, s.execution_log_id, s.action_id, s.action_type, s.order_index, s.status,
+    s.duration_ms, s.error_message, s.response, s.started_at
+FROM execution_steps s WHERE EXISTS (SELECT 1 FROM execution_logs_flat l WHERE l.id = s.execution_log_id);
+
+DROP TABLE execution_steps;
+DROP TABLE execution_logs;
+ALTER TABLE execution_logs_flat RENAME TO execution_logs;
+ALTER TABLE execution_steps_flat RENAME TO execution_steps;
+
+CREATE INDEX IF NOT EXISTS idx_execution_logs_relay_id ON execution_logs(relay_id);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_executed_at ON execution_logs(executed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_events_id ON execution_logs(event_id);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_trace_id ON execution_logs(trace_id);
+CREATE INDEX IF NOT EXISTS idx_execution_steps_log_id ON execution_steps(execution_log_id, order_index);
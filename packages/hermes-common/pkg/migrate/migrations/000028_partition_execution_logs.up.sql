@@ -0,0 +1,75 @@
+-- execution_logs and execution_steps become partitioned by day on executed_at,
+-- so retention drops whole days instead of deleting rows. The existing tables
+-- are kept as one legacy partition each, holding everything before the cutover,
+-- and are dropped whole once all of it has expired. hermes-core creates the
+-- days ahead and drops expired ones (PARTITION_MAINTENANCE_INTERVAL)
+ALTER TABLE execution_logs RENAME TO execution_logs_legacy;
+ALTER TABLE execution_steps RENAME TO execution_steps_legacy;
+ALTER TABLE execution_steps_legacy DROP CONSTRAINT IF EXISTS execution_steps_execution_log_id_fkey;
+ALTER INDEX IF EXISTS idx_execution_logs_relay_id RENAME TO idx_execution_logs_legacy_relay_id;
+ALTER INDEX IF EXISTS idx_execution_logs_executed_at RENAME TO idx_execution_logs_legacy_executed_at;
+ALTER INDEX IF EXISTS idx_execution_logs_events_id RENAME TO idx_execution_logs_legacy_event_id;
+ALTER INDEX IF EXISTS idx_execution_logs_trace_id RENAME TO idx_execution_logs_legacy_trace_id;
+ALTER INDEX IF EXISTS idx_execution_steps_log_id RENAME TO idx_execution_steps_legacy_log_id;
+
+-- Steps written before partitioning don't know their log's time. They stay
+-- with the legacy logs, which is all the partition key is used for
+ALTER TABLE execution_steps_legacy ADD COLUMN IF NOT EXISTS executed_at TIMESTAMP NOT NULL DEFAULT '-infinity';
+
+CREATE TABLE execution_logs (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    relay_id UUID NOT NULL REFERENCES relays(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    payload JSONB,
+    error_message TEXT,
+    executed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    event_id TEXT,
+    trace_id TEXT,
+    PRIMARY KEY (id, executed_at)
+) PARTITION BY RANGE (executed_at);
+
+CREATE INDEX IF NOT EXISTS idx_execution_logs_relay_id ON execution_logs(relay_id);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_executed_at ON execution_logs(executed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_events_id ON execution_logs(event_id);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_trace_id ON execution_logs(trace_id);
+
+-- executed_at is the log's, so a step is kept and dropped with its log
+CREATE TABLE execution_steps (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    execution_log_id UUID NOT NULL,
+    action_id UUID REFERENCES relay_actions(id) ON DELETE SET NULL,
+    action_type TEXT NOT NULL,
+    order_index INT NOT NULL,
+    status TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    error_message TEXT,
+    response TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    executed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, executed_at)
+) PARTITION BY RANGE (executed_at);
+
+CREATE INDEX IF NOT EXISTS idx_execution_steps_log_id ON execution_steps(execution_log_id, order_index);
+
+DO $$
+DECLARE
+    cutover TIMESTAMP;
+    day TIMESTAMP;
+BEGIN
+    SELECT date_trunc('day', GREATEST(NOW()::timestamp, COALESCE(max(executed_at), NOW()::timestamp))) + INTERVAL '1 day'
+    INTO cutover FROM execution_logs_legacy;
+    EXECUTE format('ALTER TABLE execution_logs ATTACH PARTITION execution_logs_legacy FOR VALUES FROM (MINVALUE) TO (%L)', cutover);
+    EXECUTE format('ALTER TABLE execution_steps ATTACH PARTITION execution_steps_legacy FOR VALUES FROM (MINVALUE) TO (%L)', cutover);
+    FOR i IN 0..6 LOOP
+        day := cutover + make_interval(days => i);
+        EXECUTE format('CREATE TABLE %I PARTITION OF execution_logs FOR VALUES FROM (%L) TO (%L)',
+            'execution_logs_p' || to_char(day, 'YYYYMMDD'), day, day + INTERVAL '1 day');
+        EXECUTE format('CREATE TABLE %I PARTITION OF execution_steps FOR VALUES FROM (%L) TO (%L)',
+            'execution_steps_p' || to_char(day, 'YYYYMMDD'), day, day + INTERVAL '1 day');
+    END LOOP;
+END $$;
+
+-- Catches rows for days that weren't created in time. Maintenance moves them
+-- into the day's partition when it creates it
+CREATE TABLE execution_logs_default PARTITION OF execution_logs DEFAULT;
+CREATE TABLE execution_steps_default PARTITION OF execution_steps DEFAULT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_execution_logs_error_search;
+DROP INDEX IF EXISTS idx_execution_logs_payload;
@@ -0,0 +1,6 @@
+-- Indexes for log search: jsonpath matches on the payload, and full-text
+-- search on error messages. Both are created on each partition, including the
+-- days maintenance adds later
+CREATE INDEX IF NOT EXISTS idx_execution_logs_payload ON execution_logs USING GIN (payload jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_execution_logs_error_search ON execution_logs
+    USING GIN (to_tsvector('simple', COALESCE(error_message, '')));
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_execution_logs_executed_at_id;
+DROP TABLE IF EXISTS log_exports;
@@ -0,0 +1,20 @@
+-- Ships a user's execution logs to S3, BigQuery or a webhook. hermes-core
+-- pages through the logs in (executed_at, id) order and records how far it got
+CREATE TABLE IF NOT EXISTS log_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    sink TEXT NOT NULL CHECK (sink IN ('s3', 'bigquery', 'webhook')),
+    config JSONB NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    cursor_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    cursor_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+    last_error TEXT,
+    last_exported_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_log_exports_user_id ON log_exports(user_id);
+
+-- Export pages read a user's logs past the cursor in order
+CREATE INDEX IF NOT EXISTS idx_execution_logs_executed_at_id ON execution_logs(executed_at, id);
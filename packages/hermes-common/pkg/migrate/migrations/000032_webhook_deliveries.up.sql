@@ -0,0 +1,17 @@
+-- Every request hermes-hooks received, accepted or not. Separate from
+-- execution_logs, which only hold events that reached a worker. relay_id is
+-- whatever the URL carried, so it isn't a foreign key
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    relay_id TEXT NOT NULL,
+    event_id TEXT NOT NULL DEFAULT '',
+    source_ip TEXT NOT NULL DEFAULT '',
+    status INT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    received_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_relay_received ON webhook_deliveries(relay_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_received ON webhook_deliveries(received_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS usage_closed_days;
+DROP TABLE IF EXISTS usage_daily;
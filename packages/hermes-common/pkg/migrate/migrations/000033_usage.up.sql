@@ -0,0 +1,22 @@
+-- Billable usage per user and day, rolled up by hermes-core from the delivery
+-- log and execution logs so it outlives their retention. Not tied to users by
+-- a foreign key, so usage already incurred stays billable after a delete
+CREATE TABLE IF NOT EXISTS usage_daily (
+    user_id UUID NOT NULL,
+    day DATE NOT NULL,
+    events_ingested BIGINT NOT NULL DEFAULT 0,
+    bytes_ingested BIGINT NOT NULL DEFAULT 0,
+    executions BIGINT NOT NULL DEFAULT 0,
+    action_invocations BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_daily_day ON usage_daily(day);
+
+-- Days whose usage is final, and when each was handed to the usage export
+CREATE TABLE IF NOT EXISTS usage_closed_days (
+    day DATE PRIMARY KEY,
+    closed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    exported_at TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tenant_rate;
+DROP TABLE IF EXISTS tenant_slots;
+DROP TABLE IF EXISTS tenant_quotas;
@@ -0,0 +1,28 @@
+-- Per-user overrides of the worker's tenant limits. NULL falls back to the
+-- worker default, 0 means unlimited
+CREATE TABLE IF NOT EXISTS tenant_quotas (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_concurrency INT CHECK (max_concurrency >= 0),
+    max_executions_per_minute INT CHECK (max_executions_per_minute >= 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Executions running for a user across every worker instance. A slot left by
+-- a worker that died is freed once it expires
+CREATE TABLE IF NOT EXISTS tenant_slots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    relay_id UUID NOT NULL,
+    event_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_slots_user_id ON tenant_slots(user_id, expires_at);
+
+-- Executions started per user in the current minute
+CREATE TABLE IF NOT EXISTS tenant_rate (
+    user_id UUID NOT NULL,
+    minute TIMESTAMP NOT NULL,
+    executions INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, minute)
+);
@@ -0,0 +1,3 @@
+-- The data key each secret is sealed with, wrapped by SECRETS_KEY or KMS.
+-- NULL for secrets sealed directly with SECRETS_KEY before envelopes
+ALTER TABLE secrets ADD COLUMN IF NOT EXISTS data_key BYTEA;
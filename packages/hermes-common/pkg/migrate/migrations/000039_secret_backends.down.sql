@@ -0,0 +1,4 @@
+DELETE FROM secrets WHERE backend <> 'hermes';
+ALTER TABLE secrets ALTER COLUMN ciphertext SET NOT NULL;
+ALTER TABLE secrets DROP COLUMN IF EXISTS reference;
+ALTER TABLE secrets DROP COLUMN IF EXISTS backend;
@@ -0,0 +1,5 @@
+-- Secrets kept in Vault or AWS Secrets Manager are stored as a reference into
+-- that backend, without a value. 'hermes' secrets hold the sealed value
+ALTER TABLE secrets ADD COLUMN IF NOT EXISTS backend TEXT NOT NULL DEFAULT 'hermes';
+ALTER TABLE secrets ADD COLUMN IF NOT EXISTS reference TEXT;
+ALTER TABLE secrets ALTER COLUMN ciphertext DROP NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS oauth_connection_states;
+DROP TABLE IF EXISTS oauth_connections;
@@ -0,0 +1,34 @@
+-- Accounts users connected through OAuth (Slack, Google, GitHub), referenced
+-- from action configs as {{connection:ID}}. Tokens are sealed like secrets,
+-- each with its own wrapped data key
+CREATE TABLE IF NOT EXISTS oauth_connections (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    name TEXT NOT NULL,
+    -- The connected account as the provider names it, e.g. the Slack workspace
+    account TEXT NOT NULL DEFAULT '',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    access_token BYTEA NOT NULL,
+    access_key BYTEA,
+    refresh_token BYTEA,
+    refresh_key BYTEA,
+    -- NULL when the access token doesn't expire
+    expires_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_connections_user_id ON oauth_connections(user_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_connections_expires_at ON oauth_connections(expires_at)
+    WHERE refresh_token IS NOT NULL;
+
+-- Authorizations waiting for the provider to redirect back, consumed by the callback
+CREATE TABLE IF NOT EXISTS oauth_connection_states (
+    state TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
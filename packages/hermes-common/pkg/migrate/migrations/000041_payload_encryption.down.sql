@@ -0,0 +1,7 @@
+ALTER TABLE aggregate_events DROP COLUMN IF EXISTS payload_key;
+ALTER TABLE aggregate_events DROP COLUMN IF EXISTS payload_sealed;
+ALTER TABLE held_events DROP COLUMN IF EXISTS payload_key;
+ALTER TABLE held_events DROP COLUMN IF EXISTS payload_sealed;
+ALTER TABLE execution_logs DROP COLUMN IF EXISTS payload_key;
+ALTER TABLE execution_logs DROP COLUMN IF EXISTS payload_sealed;
+DROP TABLE IF EXISTS workspace_keys;
@@ -0,0 +1,17 @@
+-- A data key per workspace sealing its webhook payloads at rest, wrapped by
+-- SECRETS_KEY or KMS. Deleting a workspace's key leaves its payloads unreadable
+CREATE TABLE IF NOT EXISTS workspace_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    data_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A sealed payload leaves payload NULL, payload_key naming the workspace key
+-- it was sealed with
+ALTER TABLE execution_logs ADD COLUMN IF NOT EXISTS payload_sealed BYTEA;
+ALTER TABLE execution_logs ADD COLUMN IF NOT EXISTS payload_key UUID;
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS payload_sealed BYTEA;
+ALTER TABLE held_events ADD COLUMN IF NOT EXISTS payload_key UUID;
+ALTER TABLE aggregate_events ADD COLUMN IF NOT EXISTS payload_sealed BYTEA;
+ALTER TABLE aggregate_events ADD COLUMN IF NOT EXISTS payload_key UUID;
@@ -0,0 +1,18 @@
+-- Rules masking personal data in payloads before they're logged or handed to
+-- actions. A rule without a relay covers every relay of its workspace, one
+-- without a workspace either covers every relay there is
+CREATE TABLE IF NOT EXISTS redaction_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    relay_id UUID REFERENCES relays(id) ON DELETE CASCADE,
+    -- Exactly one of path, pattern and preset is set
+    path TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL DEFAULT '',
+    preset TEXT NOT NULL DEFAULT '',
+    replacement TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (relay_id IS NULL OR user_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_redaction_rules_user ON redaction_rules(user_id);
+CREATE INDEX IF NOT EXISTS idx_redaction_rules_relay ON redaction_rules(relay_id);
@@ -0,0 +1,25 @@
+-- Requests to export or delete everything stored for a user or workspace, run
+-- in the background by hermes-core. user_id isn't a foreign key, so a
+-- deletion's report outlives the user it deleted
+CREATE TABLE IF NOT EXISTS data_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('export', 'delete')),
+    status TEXT NOT NULL DEFAULT 'pending',
+    -- Rows exported or deleted, by kind of data
+    report JSONB,
+    error TEXT,
+    -- The export as gzipped JSON, sealed with export_key when a secrets key is
+    -- set. Dropped once it expires
+    export BYTEA,
+    export_key BYTEA,
+    export_expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+-- One open request of each kind per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_requests_open ON data_requests(user_id, kind)
+    WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_data_requests_user ON data_requests(user_id, created_at DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE relays DROP COLUMN IF EXISTS signing_data_key;
+ALTER TABLE relays DROP COLUMN IF EXISTS signing_key;
@@ -0,0 +1,4 @@
+-- A relay's key for signing the requests its actions send, sealed with
+-- SECRETS_KEY or KMS like secrets. NULL leaves them unsigned
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS signing_key BYTEA;
+ALTER TABLE relays ADD COLUMN IF NOT EXISTS signing_data_key BYTEA;
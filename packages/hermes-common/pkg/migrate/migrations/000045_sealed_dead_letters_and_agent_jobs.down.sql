@@ -0,0 +1,4 @@
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS payload_key;
+ALTER TABLE agent_jobs DROP COLUMN IF EXISTS payload_sealed;
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS payload_key;
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS payload_sealed;
@@ -0,0 +1,6 @@
+-- Dead letters and agent jobs carry the same webhook payloads as execution
+-- logs, so they are sealed with the workspace key the same way
+ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS payload_sealed BYTEA;
+ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS payload_key UUID;
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS payload_sealed BYTEA;
+ALTER TABLE agent_jobs ADD COLUMN IF NOT EXISTS payload_key UUID;
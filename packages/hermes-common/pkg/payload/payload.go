@@ -0,0 +1,182 @@
+package payload
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Truncation and rejection counters shared by every service, exposed via expvar
+// and, in hooks, on /metrics
+var Metrics = expvar.NewMap("hermes_payload")
+
+var (
+	ErrTooLarge     = errors.New("payload exceeds size limit")
+	ErrLimitReached = errors.New("output size limit reached")
+)
+
+// Cuts b down to at most max bytes without splitting a UTF-8 sequence
+func Truncate(b []byte, max int) ([]byte, bool) {
+	if max <= 0 || len(b) <= max {
+		return b, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(b[cut]) {
+		cut--
+	}
+	return b[:cut], true
+}
+
+// Same as Truncate but for strings, appending a marker so readers know text was dropped
+func TruncateString(s string, max int) string {
+	b, truncated := Truncate([]byte(s), max)
+	if !truncated {
+		return s
+	}
+	return string(b) + fmt.Sprintf("... [truncated %d bytes]", len(s)-len(b))
+}
+
+// A writer that keeps at most max bytes and fails once the cap is hit,
+// so template rendering stops early instead of building huge strings
+type CappedBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func NewCappedBuffer(max int) *CappedBuffer {
+	return &CappedBuffer{max: max}
+}
+
+func (c *CappedBuffer) Write(p []byte) (int, error) {
+	room := c.max - len(c.buf)
+	if len(p) <= room {
+		c.buf = append(c.buf, p...)
+		return len(p), nil
+	}
+	if room > 0 {
+		c.buf = append(c.buf, p[:room]...)
+	}
+	c.truncated = true
+	return room, ErrLimitReached
+}
+
+func (c *CappedBuffer) String() string {
+	b := c.buf
+	// Drop a partial rune left behind by the cut
+	for len(b) > 0 {
+		r, size := utf8.DecodeLastRune(b)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+func (c *CappedBuffer) Truncated() bool {
+	return c.truncated
+}
+
+// Weighted semaphore over bytes, capping how much payload data a process
+// holds in memory at once
+type Budget struct {
+	mu      sync.Mutex
+	size    int64
+	used    int64
+	waiters []budgetWaiter
+}
+
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func NewBudget(size int64) *Budget {
+	return &Budget{size: size}
+}
+
+// Reserves n bytes without waiting, reporting whether the reservation succeeded
+func (b *Budget) TryAcquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.size {
+		return false
+	}
+	if b.used+n <= b.size && len(b.waiters) == 0 {
+		b.used += n
+		return true
+	}
+	return false
+}
+
+// Blocks until n bytes are available or ctx is done
+func (b *Budget) Acquire(ctx context.Context, n int64) error {
+	b.mu.Lock()
+	if n > b.size {
+		b.mu.Unlock()
+		return ErrTooLarge
+	}
+	if b.used+n <= b.size && len(b.waiters) == 0 {
+		b.used += n
+		b.mu.Unlock()
+		return nil
+	}
+	w := budgetWaiter{n: n, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		select {
+		case <-w.ready:
+			// Granted while we were cancelling, hand it back
+			b.used -= n
+			b.notify()
+		default:
+			for i, other := range b.waiters {
+				if other.ready == w.ready {
+					b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (b *Budget) Release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	if b.used < 0 {
+		b.used = 0
+	}
+	b.notify()
+}
+
+func (b *Budget) InUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Wakes waiters in FIFO order while they fit. Caller holds the lock
+func (b *Budget) notify() {
+	for len(b.waiters) > 0 {
+		next := b.waiters[0]
+		if b.used+next.n > b.size {
+			return
+		}
+		b.used += next.n
+		b.waiters = b.waiters[1:]
+		close(next.ready)
+	}
+}
@@ -0,0 +1,100 @@
+package payload
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTruncateKeepsWholeRunes(t *testing.T) {
+	// "é" is two bytes and "€" three, so most cuts land inside a rune
+	input := []byte("aé€b")
+	for max := 1; max < len(input); max++ {
+		out, truncated := Truncate(input, max)
+		if !truncated {
+			t.Errorf("max %d: expected truncation", max)
+		}
+		if len(out) > max {
+			t.Errorf("max %d: got %d bytes", max, len(out))
+		}
+		if !utf8.Valid(out) {
+			t.Errorf("max %d: split a rune, got %q", max, out)
+		}
+	}
+	if out, truncated := Truncate(input, len(input)); truncated || string(out) != string(input) {
+		t.Errorf("Expected input at the limit to be kept, got %q", out)
+	}
+	if out, truncated := Truncate(input, 0); truncated || len(out) != len(input) {
+		t.Error("Expected a zero limit to mean no limit")
+	}
+	if out, _ := Truncate([]byte("€"), 2); len(out) != 0 {
+		t.Errorf("Expected nothing left when the first rune doesn't fit, got %q", out)
+	}
+}
+
+func TestTruncateStringAddsMarker(t *testing.T) {
+	if got := TruncateString("short", 10); got != "short" {
+		t.Errorf("Expected short strings unchanged, got %q", got)
+	}
+	if got := TruncateString("aé€b", 4); got != "aé... [truncated 4 bytes]" {
+		t.Errorf("Unexpected result %q", got)
+	}
+}
+
+func TestCappedBufferStopsAtLimit(t *testing.T) {
+	buf := NewCappedBuffer(5)
+	if n, err := buf.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Expected a write under the cap to succeed, got %d, %v", n, err)
+	}
+	n, err := buf.Write([]byte("d€"))
+	if !errors.Is(err, ErrLimitReached) || n != 2 {
+		t.Fatalf("Expected ErrLimitReached after 2 bytes, got %d, %v", n, err)
+	}
+	if !buf.Truncated() {
+		t.Error("Expected Truncated to be set")
+	}
+	// The cut left the first byte of "€" behind
+	if got := buf.String(); got != "abcd" {
+		t.Errorf("Expected the partial rune dropped, got %q", got)
+	}
+	if n, err := buf.Write([]byte("x")); n != 0 || err == nil {
+		t.Errorf("Expected writes to a full buffer to fail, got %d, %v", n, err)
+	}
+}
+
+func TestBudgetAcquireRelease(t *testing.T) {
+	b := NewBudget(10)
+	if !b.TryAcquire(6) {
+		t.Fatal("Expected 6 of 10 bytes to be available")
+	}
+	if b.TryAcquire(5) {
+		t.Error("Expected 5 more bytes to be refused")
+	}
+	if err := b.Acquire(context.Background(), 11); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge for more than the budget, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Acquire(ctx, 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+
+	got := make(chan error, 1)
+	go func() { got <- b.Acquire(context.Background(), 5) }()
+	time.Sleep(10 * time.Millisecond)
+	b.Release(6)
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Expected the waiter to be granted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Release to wake the waiter")
+	}
+	if b.InUse() != 5 {
+		t.Errorf("Expected 5 bytes in use, got %d", b.InUse())
+	}
+}
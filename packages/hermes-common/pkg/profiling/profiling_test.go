@@ -0,0 +1,42 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(h http.Handler, path, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	h := Handler("s3cret")
+	if rec := get(h, "/debug/pprof/", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+	if rec := get(h, "/debug/vars", "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", rec.Code)
+	}
+	rec := get(h, "/debug/pprof/", "Bearer s3cret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("Expected the pprof index, got %d", rec.Code)
+	}
+	rec = get(h, "/debug/vars", "Bearer s3cret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "memstats") {
+		t.Errorf("Expected expvar output, got %d", rec.Code)
+	}
+}
+
+func TestHandlerWithoutTokenIsClosed(t *testing.T) {
+	if rec := get(Handler(""), "/debug/pprof/", "Bearer "); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when no token is configured, got %d", rec.Code)
+	}
+}
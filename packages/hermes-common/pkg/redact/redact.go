@@ -0,0 +1,211 @@
+// Package redact masks personal data in webhook payloads before the worker
+// logs them or hands them to actions. A rule either names a JSON path whose
+// value is replaced, or a pattern replaced wherever it turns up in a value.
+// Core validates rules with it, the worker applies them
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Stands in for what a rule matched unless it sets its own replacement
+const DefaultReplacement = "[REDACTED]"
+
+// Longest pattern a rule may set
+const maxPatternLength = 512
+
+// Built-in patterns rules can name instead of writing their own
+var Presets = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// 13 to 19 digits, optionally grouped with spaces or dashes
+	"card":   `\b(?:\d[ -]?){12,18}\d\b`,
+	"bearer": `(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`,
+	"jwt":    `\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+}
+
+// One redaction. Exactly one of Path, Pattern and Preset is set
+type Rule struct {
+	// Dotted keys, where * matches every key or array item and a number an
+	// array index, e.g. customer.email or items.*.card; a leading "$." is allowed
+	Path string `json:"path,omitempty"`
+	// A regular expression (RE2 syntax) matched against every string and number
+	Pattern string `json:"pattern,omitempty"`
+	// One of Presets
+	Preset      string `json:"preset,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+func (r Rule) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+// Applies a set of rules. A nil *Redactor leaves payloads as they are
+type Redactor struct {
+	paths    []pathRule
+	patterns []patternRule
+}
+
+type pathRule struct {
+	path        []string
+	replacement string
+}
+
+type patternRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// Compiles rules, nil when there are none
+func Compile(rules []Rule) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for i, rule := range rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		switch c := compiled.(type) {
+		case pathRule:
+			r.paths = append(r.paths, c)
+		case patternRule:
+			r.patterns = append(r.patterns, c)
+		}
+	}
+	return r, nil
+}
+
+func compile(rule Rule) (any, error) {
+	set := 0
+	for _, field := range []string{rule.Path, rule.Pattern, rule.Preset} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("set exactly one of path, pattern and preset")
+	}
+	replacement := rule.Replacement
+	if replacement == "" {
+		replacement = DefaultReplacement
+	}
+	if rule.Path != "" {
+		path := strings.TrimPrefix(strings.TrimPrefix(rule.Path, "$"), ".")
+		segments := strings.Split(path, ".")
+		for _, s := range segments {
+			if s == "" {
+				return nil, fmt.Errorf("path %q has an empty segment", rule.Path)
+			}
+		}
+		return pathRule{path: segments, replacement: replacement}, nil
+	}
+	pattern := rule.Pattern
+	if rule.Preset != "" {
+		var ok bool
+		if pattern, ok = Presets[rule.Preset]; !ok {
+			return nil, fmt.Errorf("unknown preset %q, expected email, card, bearer or jwt", rule.Preset)
+		}
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern can't be longer than %d characters", maxPatternLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return patternRule{re: re, replacement: replacement}, nil
+}
+
+// Returns body with every rule applied. A body that isn't JSON only has the
+// patterns applied to its text. body itself is never modified
+func (r *Redactor) Apply(body []byte) []byte {
+	if r == nil || len(body) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		text := string(body)
+		for _, p := range r.patterns {
+			text = p.re.ReplaceAllLiteralString(text, p.replacement)
+		}
+		return []byte(text)
+	}
+	changed := false
+	for _, p := range r.paths {
+		doc = replacePath(doc, p.path, p.replacement, &changed)
+	}
+	if len(r.patterns) > 0 {
+		doc = r.replacePatterns(doc, &changed)
+	}
+	if !changed {
+		return body
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func replacePath(v any, path []string, replacement string, changed *bool) any {
+	if len(path) == 0 {
+		*changed = true
+		return replacement
+	}
+	seg, rest := path[0], path[1:]
+	switch node := v.(type) {
+	case map[string]any:
+		for key, child := range node {
+			if seg == "*" || seg == key {
+				node[key] = replacePath(child, rest, replacement, changed)
+			}
+		}
+	case []any:
+		for i, child := range node {
+			if seg == "*" || seg == fmt.Sprint(i) {
+				node[i] = replacePath(child, rest, replacement, changed)
+			}
+		}
+	}
+	return v
+}
+
+func (r *Redactor) replacePatterns(v any, changed *bool) any {
+	switch node := v.(type) {
+	case map[string]any:
+		for key, child := range node {
+			node[key] = r.replacePatterns(child, changed)
+		}
+	case []any:
+		for i, child := range node {
+			node[i] = r.replacePatterns(child, changed)
+		}
+	case string:
+		return r.replaceText(node, node, changed)
+	case json.Number:
+		// A card number sent as a number is replaced by a string
+		return r.replaceText(node.String(), node, changed)
+	}
+	return v
+}
+
+func (r *Redactor) replaceText(text string, original any, changed *bool) any {
+	out := text
+	for _, p := range r.patterns {
+		out = p.re.ReplaceAllLiteralString(out, p.replacement)
+	}
+	if out == text {
+		return original
+	}
+	*changed = true
+	return out
+}
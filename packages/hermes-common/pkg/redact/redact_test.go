@@ -0,0 +1,68 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApplyRedactsPathsAndPatterns(t *testing.T) {
+	r, err := Compile([]Rule{
+		{Path: "customer.ssn"},
+		{Path: "$.items.*.card", Replacement: "****"},
+		{Preset: "email"},
+		{Pattern: `tok_[a-z0-9]+`, Replacement: "[token]"},
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	body := []byte(`{"customer":{"ssn":"123-45-6789","note":"mail ada@example.com, key tok_abc123"},
+		"items":[{"card":4111111111111111,"sku":"A1"},{"card":"5500 0000 0000 0004"}],"total":42}`)
+	var got map[string]any
+	if err := json.Unmarshal(r.Apply(body), &got); err != nil {
+		t.Fatalf("Expected JSON back: %v", err)
+	}
+	customer := got["customer"].(map[string]any)
+	if customer["ssn"] != DefaultReplacement || customer["note"] != "mail [REDACTED], key [token]" {
+		t.Errorf("Expected the customer redacted, got %v", customer)
+	}
+	items := got["items"].([]any)
+	if items[0].(map[string]any)["card"] != "****" || items[1].(map[string]any)["card"] != "****" ||
+		items[0].(map[string]any)["sku"] != "A1" {
+		t.Errorf("Expected every item's card redacted, got %v", items)
+	}
+	if got["total"] != float64(42) {
+		t.Errorf("Expected other values kept, got %v", got["total"])
+	}
+}
+
+func TestApplyLeavesCleanPayloadsUntouched(t *testing.T) {
+	r, _ := Compile([]Rule{{Preset: "card"}, {Path: "missing.field"}})
+	body := []byte(`{"b": 1, "a": "no secrets here"}`)
+	if got := r.Apply(body); string(got) != string(body) {
+		t.Errorf("Expected the payload as it was, got %s", got)
+	}
+	if got := r.Apply([]byte("card 4111-1111-1111-1111 declined")); string(got) != "card [REDACTED] declined" {
+		t.Errorf("Expected patterns applied to a text body, got %s", got)
+	}
+	var none *Redactor
+	if got := none.Apply(body); string(got) != string(body) {
+		t.Errorf("Expected a nil redactor to change nothing, got %s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, rule := range map[string]Rule{
+		"empty":          {},
+		"two kinds":      {Path: "a", Preset: "email"},
+		"unknown preset": {Preset: "phone"},
+		"bad pattern":    {Pattern: "(unclosed"},
+		"empty segment":  {Path: "a..b"},
+	} {
+		if rule.Validate() == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := (Rule{Preset: "jwt"}).Validate(); err != nil {
+		t.Errorf("Expected a preset accepted, got %v", err)
+	}
+}
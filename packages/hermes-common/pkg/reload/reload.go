@@ -0,0 +1,109 @@
+// Package reload re-applies a service's reloadable settings while it runs,
+// on SIGHUP or when an operator asks for it over HTTP
+package reload
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// Runs a service's apply function one call at a time
+type Reloader struct {
+	mu     sync.Mutex
+	apply  func() error
+	logger *slog.Logger
+}
+
+// apply reloads the config and hands the reloadable parts to the running
+// service. It should leave everything as it was when it returns an error
+func New(logger *slog.Logger, apply func() error) *Reloader {
+	return &Reloader{apply: apply, logger: logger}
+}
+
+// Applies the current config, logging how it went
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.apply(); err != nil {
+		r.logger.Error("configuration reload failed", slog.String("error", err.Error()))
+		return err
+	}
+	r.logger.Info("configuration reloaded")
+	return nil
+}
+
+// Reloads on every SIGHUP until ctx is done
+func (r *Reloader) OnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info("SIGHUP received, reloading configuration")
+			_ = r.Reload()
+		}
+	}
+}
+
+// Reloads on POST requests carrying token as a bearer credential. An empty
+// token refuses every request
+func (r *Reloader) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token == "" {
+			http.Error(w, "Reload endpoint is disabled", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Token required", http.StatusUnauthorized)
+			return
+		}
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			http.Error(w, "Reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Copies keys from an env file into the process environment, so the next
+// config load sees them. The file wins over values set at startup, keys it
+// doesn't set are left alone and a missing file changes nothing
+func Env(file string, keys ...string) error {
+	values, err := godotenv.Read(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", file, err)
+	}
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			if err := os.Setenv(key, value); err != nil {
+				return fmt.Errorf("set %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
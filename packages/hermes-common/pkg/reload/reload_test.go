@@ -0,0 +1,81 @@
+package reload
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func post(h http.Handler, method, auth string) int {
+	req := httptest.NewRequest(method, "/debug/reload", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestHandlerRequiresTokenAndPost(t *testing.T) {
+	calls := 0
+	r := New(logger.New("hermes-test", "test", "error"), func() error {
+		calls++
+		return nil
+	})
+	h := r.Handler("s3cret")
+	if code := post(h, http.MethodPost, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", code)
+	}
+	if code := post(h, http.MethodGet, "Bearer s3cret"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", code)
+	}
+	if code := post(h, http.MethodPost, "Bearer s3cret"); code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", code)
+	}
+	if code := post(r.Handler(""), http.MethodPost, "Bearer "); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with no token configured, got %d", code)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 reload, got %d", calls)
+	}
+}
+
+func TestHandlerReportsFailedReload(t *testing.T) {
+	r := New(logger.New("hermes-test", "test", "error"), func() error {
+		return errors.New("MAX_WORKERS must be at least 1")
+	})
+	if code := post(r.Handler("s3cret"), http.MethodPost, "Bearer s3cret"); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", code)
+	}
+}
+
+func TestEnvCopiesOnlyListedKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(file, []byte("LOG_LEVEL=DEBUG\nDATABASE_URL=postgres://elsewhere\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOG_LEVEL", "INFO")
+	t.Setenv("DATABASE_URL", "postgres://original")
+	t.Setenv("MAX_WORKERS", "10")
+
+	if err := Env(file, "LOG_LEVEL", "MAX_WORKERS"); err != nil {
+		t.Fatalf("Env failed: %v", err)
+	}
+	if got := os.Getenv("LOG_LEVEL"); got != "DEBUG" {
+		t.Errorf("Expected LOG_LEVEL from the file, got %q", got)
+	}
+	if got := os.Getenv("DATABASE_URL"); got != "postgres://original" {
+		t.Errorf("Expected unlisted keys to be left alone, got %q", got)
+	}
+	if got := os.Getenv("MAX_WORKERS"); got != "10" {
+		t.Errorf("Expected keys missing from the file to be left alone, got %q", got)
+	}
+	if err := Env(filepath.Join(t.TempDir(), "missing.env"), "LOG_LEVEL"); err != nil {
+		t.Errorf("Expected a missing file to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+// Where a secret's value lives
+const (
+	// Sealed in the database
+	BackendHermes = "hermes"
+	BackendVault  = "vault"
+	BackendAWS    = "aws-secrets-manager"
+)
+
+// How long values fetched from a backend are reused
+const backendCacheTTL = time.Minute
+
+var ErrBackendNotConfigured = errors.New("secret backend is not configured")
+
+// An external secret store hermes keeps references into instead of values
+type Backend interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// A secret as stored: a sealed value, or a reference into a backend
+type Stored struct {
+	Backend   string
+	Reference string
+	Sealed    Sealed
+}
+
+// The value of a stored secret, opened with envelope or fetched from its backend
+func Open(ctx context.Context, s Stored, envelope *Envelope, backends map[string]Backend) (string, error) {
+	if s.Backend == "" || s.Backend == BackendHermes {
+		if envelope == nil {
+			return "", ErrNoKey
+		}
+		return envelope.Open(ctx, s.Sealed)
+	}
+	backend, ok := backends[s.Backend]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrBackendNotConfigured, s.Backend)
+	}
+	return backend.Fetch(ctx, s.Reference)
+}
+
+type BackendConfig struct {
+	VaultAddr      string
+	VaultToken     string
+	VaultNamespace string
+	// Region of AWS Secrets Manager, off when empty
+	AWSRegion string
+	AWS       sigv4.Credentials
+}
+
+// The backends cfg sets up, keyed by name
+func LoadBackends(cfg BackendConfig) (map[string]Backend, error) {
+	backends := make(map[string]Backend)
+	if cfg.VaultAddr != "" {
+		if cfg.VaultToken == "" {
+			return nil, fmt.Errorf("vault needs a token")
+		}
+		backends[BackendVault] = cached(&Vault{
+			addr:      strings.TrimSuffix(cfg.VaultAddr, "/"),
+			token:     cfg.VaultToken,
+			namespace: cfg.VaultNamespace,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	if cfg.AWSRegion != "" {
+		if !sigv4.ValidRegion.MatchString(cfg.AWSRegion) {
+			return nil, fmt.Errorf("invalid AWS Secrets Manager region %q", cfg.AWSRegion)
+		}
+		if cfg.AWS.AccessKeyID == "" || cfg.AWS.SecretAccessKey == "" {
+			return nil, fmt.Errorf("AWS Secrets Manager needs AWS credentials")
+		}
+		backends[BackendAWS] = cached(&SecretsManager{
+			endpoint: "https://secretsmanager." + cfg.AWSRegion + ".amazonaws.com",
+			region:   cfg.AWSRegion,
+			creds:    cfg.AWS,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	return backends, nil
+}
+
+// Splits "path#field" references. field is empty when there's no #
+func splitRef(ref string) (string, string) {
+	path, field, _ := strings.Cut(ref, "#")
+	return path, field
+}
+
+// Reads HashiCorp Vault KV secrets. References are the API path and the
+// field, "secret/data/slack#bot_token" for KV v2 or "kv/slack#bot_token" for v1
+type Vault struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func (v *Vault) Fetch(ctx context.Context, ref string) (string, error) {
+	path, field := splitRef(ref)
+	if path == "" || field == "" || strings.Contains(path, "..") {
+		return "", fmt.Errorf("vault reference must be <path>#<field>, got %q", ref)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("build vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("vault %s: %w", path, ErrNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("vault %s: %s", path, resp.Status)
+	}
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault response: %w", err)
+	}
+	data := body.Data
+	// KV v2 nests the secret under data.data, next to its metadata
+	if nested, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s has no field %s: %w", path, field, ErrNotFound)
+	}
+	return value, nil
+}
+
+// Reads AWS Secrets Manager secrets. References are the secret's name or ARN,
+// with "#key" picking one key out of a JSON secret
+type SecretsManager struct {
+	endpoint string
+	region   string
+	creds    sigv4.Credentials
+	client   *http.Client
+}
+
+func (m *SecretsManager) Fetch(ctx context.Context, ref string) (string, error) {
+	id, key := splitRef(ref)
+	if id == "" {
+		return "", fmt.Errorf("secrets manager reference must be <name or arn>[#key], got %q", ref)
+	}
+	body, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("build secrets manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, body, m.creds, m.region, "secretsmanager", time.Now())
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secrets manager: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var out struct {
+		SecretString string
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &out)
+	if strings.HasSuffix(out.Type, "ResourceNotFoundException") {
+		return "", fmt.Errorf("secrets manager %s: %w", id, ErrNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("secrets manager %s: %s %s %s", id, resp.Status, out.Type, out.Message)
+	}
+	if key == "" {
+		return out.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secrets manager %s is not JSON, can't pick %s", id, key)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secrets manager %s has no key %s: %w", id, key, ErrNotFound)
+	}
+	return value, nil
+}
+
+// Reuses fetched values for backendCacheTTL, so every action run doesn't ask the backend
+type cachedBackend struct {
+	backend Backend
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+func cached(b Backend) *cachedBackend {
+	return &cachedBackend{backend: b, now: time.Now, entries: make(map[string]cacheEntry)}
+}
+
+func (c *cachedBackend) Fetch(ctx context.Context, ref string) (string, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[ref]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+	value, err := c.backend.Fetch(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedKeys {
+		clear(c.entries)
+	}
+	c.entries[ref] = cacheEntry{value: value, expires: now.Add(backendCacheTTL)}
+	c.mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+func TestVaultReadsKVSecrets(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/slack":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data": map[string]any{"bot_token": "xoxb-1"}, "metadata": map[string]any{"version": 3}}})
+		case "/v1/kv/github":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"token": "ghp_1"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	backends, err := LoadBackends(BackendConfig{VaultAddr: srv.URL, VaultToken: "s.token"})
+	if err != nil {
+		t.Fatalf("LoadBackends failed: %v", err)
+	}
+	ctx := context.Background()
+	for ref, want := range map[string]string{"secret/data/slack#bot_token": "xoxb-1", "kv/github#token": "ghp_1"} {
+		got, err := Open(ctx, Stored{Backend: BackendVault, Reference: ref}, nil, backends)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", ref, want, got, err)
+		}
+	}
+	Open(ctx, Stored{Backend: BackendVault, Reference: "kv/github#token"}, nil, backends)
+	if calls != 2 {
+		t.Errorf("Expected fetched values reused, got %d calls", calls)
+	}
+	if _, err := Open(ctx, Stored{Backend: BackendVault, Reference: "secret/data/missing#x"}, nil, backends); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := Open(ctx, Stored{Backend: BackendAWS, Reference: "prod/stripe"}, nil, backends); !errors.Is(err, ErrBackendNotConfigured) {
+		t.Errorf("Expected ErrBackendNotConfigured, got %v", err)
+	}
+}
+
+func TestSecretsManagerPicksJSONKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&in)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || in.SecretId != "prod/stripe" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "ResourceNotFoundException"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"api_key":"rk_live"}`})
+	}))
+	defer srv.Close()
+	m := &SecretsManager{endpoint: srv.URL, region: "us-east-1", creds: sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "s"},
+		client: srv.Client()}
+	if got, err := m.Fetch(context.Background(), "prod/stripe#api_key"); err != nil || got != "rk_live" {
+		t.Errorf("Expected rk_live, got %q (%v)", got, err)
+	}
+	if got, _ := m.Fetch(context.Background(), "prod/stripe"); got != `{"api_key":"rk_live"}` {
+		t.Errorf("Expected the whole secret without a key, got %q", got)
+	}
+	if _, err := m.Fetch(context.Background(), "prod/other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package secrets
+
+import "github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+
+// Where the key encryption key comes from, shared by core and the worker
+type KeyConfig struct {
+	// Base64 AES-256 key. Wraps data keys unless KMS is set, and always opens
+	// values sealed before envelopes
+	Key string
+	// Wraps data keys with this AWS KMS key when set
+	KMSKeyID    string
+	KMSRegion   string
+	KMSEndpoint string
+	AWS         sigv4.Credentials
+}
+
+// The envelope for cfg, ErrNoKey when neither a key nor KMS is configured
+func Load(cfg KeyConfig) (*Envelope, error) {
+	var legacy *Cipher
+	if cfg.Key != "" {
+		var err error
+		if legacy, err = NewCipher(cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.KMSKeyID == "" {
+		if legacy == nil {
+			return nil, ErrNoKey
+		}
+		return NewEnvelope(legacy, legacy), nil
+	}
+	kms, err := NewKMS(KMSConfig{KeyID: cfg.KMSKeyID, Region: cfg.KMSRegion, Credentials: cfg.AWS, Endpoint: cfg.KMSEndpoint})
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvelope(kms, legacy), nil
+}
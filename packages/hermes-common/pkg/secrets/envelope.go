@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// Unwrapped data keys kept in memory, so KMS isn't asked on every open
+const maxCachedKeys = 1024
+
+// Holds the key encryption key, wrapping and unwrapping data keys with it.
+// Implemented by *Cipher for SECRETS_KEY and *KMS
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// A sealed value and the wrapped data key it was sealed with. DataKey is nil
+// for values sealed directly with SECRETS_KEY before envelopes
+type Sealed struct {
+	Ciphertext []byte
+	DataKey    []byte
+}
+
+// Envelope encryption: every value is sealed with a fresh AES-256-GCM data
+// key, stored wrapped by the key encryption key next to it
+type Envelope struct {
+	kek KeyWrapper
+	// Opens values sealed before envelopes. May be nil
+	legacy *Cipher
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD
+}
+
+func NewEnvelope(kek KeyWrapper, legacy *Cipher) *Envelope {
+	return &Envelope{kek: kek, legacy: legacy, keys: make(map[string]cipher.AEAD)}
+}
+
+func (e *Envelope) Seal(ctx context.Context, plaintext string) (Sealed, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Sealed{}, fmt.Errorf("generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := e.kek.WrapKey(ctx, dataKey)
+	if err != nil {
+		return Sealed{}, fmt.Errorf("wrap data key: %w", err)
+	}
+	ciphertext, err := seal(aead, []byte(plaintext))
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{Ciphertext: ciphertext, DataKey: wrapped}, nil
+}
+
+func (e *Envelope) Open(ctx context.Context, s Sealed) (string, error) {
+	if s.DataKey == nil {
+		if e.legacy == nil {
+			return "", fmt.Errorf("%w: the value was sealed with SECRETS_KEY", ErrNoKey)
+		}
+		return e.legacy.Open(s.Ciphertext)
+	}
+	aead, err := e.dataKey(ctx, s.DataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, s.Ciphertext)
+	return string(plaintext), err
+}
+
+// A fresh data key wrapped by the key encryption key, for sealing many values
+// with SealWith. Each workspace keeps one for its payloads
+func (e *Envelope) NewDataKey(ctx context.Context) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	wrapped, err := e.kek.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// Seals plaintext with a wrapped data key from NewDataKey
+func (e *Envelope) SealWith(ctx context.Context, dataKey, plaintext []byte) ([]byte, error) {
+	aead, err := e.dataKey(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plaintext)
+}
+
+// Opens a value sealed with SealWith
+func (e *Envelope) OpenWith(ctx context.Context, dataKey, ciphertext []byte) ([]byte, error) {
+	aead, err := e.dataKey(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext)
+}
+
+func (e *Envelope) dataKey(ctx context.Context, wrapped []byte) (cipher.AEAD, error) {
+	e.mu.Lock()
+	aead, ok := e.keys[string(wrapped)]
+	e.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	dataKey, err := e.kek.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	if aead, err = newAEAD(dataKey); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	if len(e.keys) >= maxCachedKeys {
+		clear(e.keys)
+	}
+	e.keys[string(wrapped)] = aead
+	e.mu.Unlock()
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrCiphertext
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("data key cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
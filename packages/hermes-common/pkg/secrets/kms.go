@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+// Bound into every wrapped key, so KMS refuses to unwrap keys wrapped for other uses
+var kmsEncryptionContext = map[string]string{"purpose": "hermes-secrets"}
+
+type KMSConfig struct {
+	// Key ID, ARN or alias of the symmetric KMS key
+	KeyID       string
+	Region      string
+	Credentials sigv4.Credentials
+	// https://kms.<region>.amazonaws.com when empty
+	Endpoint string
+}
+
+// Wraps data keys with an AWS KMS key, which never leaves KMS
+type KMS struct {
+	cfg    KMSConfig
+	client *http.Client
+}
+
+func NewKMS(cfg KMSConfig) (*KMS, error) {
+	if !sigv4.ValidRegion.MatchString(cfg.Region) {
+		return nil, fmt.Errorf("invalid KMS region %q", cfg.Region)
+	}
+	if cfg.KeyID == "" || cfg.Credentials.AccessKeyID == "" || cfg.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("KMS needs a key ID and AWS credentials")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+	return &KMS{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (k *KMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+	}
+	err := k.call(ctx, "Encrypt", map[string]any{
+		"KeyId":             k.cfg.KeyID,
+		"Plaintext":         dataKey,
+		"EncryptionContext": kmsEncryptionContext,
+	}, &out)
+	return out.CiphertextBlob, err
+}
+
+func (k *KMS) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte
+	}
+	err := k.call(ctx, "Decrypt", map[string]any{
+		"KeyId":             k.cfg.KeyID,
+		"CiphertextBlob":    wrapped,
+		"EncryptionContext": kmsEncryptionContext,
+	}, &out)
+	return out.Plaintext, err
+}
+
+// KMS speaks JSON where blobs are base64, which is how encoding/json treats []byte
+func (k *KMS) call(ctx context.Context, operation string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encode kms %s: %w", operation, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.cfg.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build kms %s: %w", operation, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+	sigv4.Sign(req, body, k.cfg.Credentials, k.cfg.Region, "kms", time.Now())
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		var kmsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &kmsErr)
+		return fmt.Errorf("kms %s: %s %s %s", operation, resp.Status, kmsErr.Type, kmsErr.Message)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode kms %s: %w", operation, err)
+	}
+	return nil
+}
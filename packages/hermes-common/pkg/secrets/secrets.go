@@ -0,0 +1,175 @@
+// Package secrets encrypts secret values at rest and resolves {{secret:NAME}}
+// references in action configs. Every value is sealed with its own data key,
+// which is wrapped by the shared SECRETS_KEY or by AWS KMS. Core seals
+// values, the worker opens them when an action runs
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrNoKey      = errors.New("secrets key is not configured")
+	ErrNotFound   = errors.New("secret not found")
+	ErrCiphertext = errors.New("secret ciphertext is invalid")
+)
+
+// Names are upper snake case, e.g. SLACK_WEBHOOK_URL
+var (
+	NamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,127}$`)
+	refPattern  = regexp.MustCompile(`\{\{\s*secret:([A-Z][A-Z0-9_]{0,127})\s*\}\}`)
+	// {{connection:ID}} stands for the access token of an OAuth connection
+	connectionPattern = regexp.MustCompile(`\{\{\s*connection:([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\s*\}\}`)
+)
+
+// AES-256-GCM with a random nonce prepended to each ciphertext
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// Builds a cipher from a base64 encoded 32 byte key
+func NewCipher(key string) (*Cipher, error) {
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decode secrets key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("secrets cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("secrets cipher: %w", err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) Seal(plaintext string) ([]byte, error) {
+	return seal(c.aead, []byte(plaintext))
+}
+
+func (c *Cipher) Open(ciphertext []byte) (string, error) {
+	plaintext, err := open(c.aead, ciphertext)
+	return string(plaintext), err
+}
+
+// Wraps data keys with SECRETS_KEY
+func (c *Cipher) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	return seal(c.aead, dataKey)
+}
+
+func (c *Cipher) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return open(c.aead, wrapped)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	size := aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrCiphertext
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return nil, ErrCiphertext
+	}
+	return plaintext, nil
+}
+
+// Returns each secret name referenced anywhere in config once
+func References(config map[string]any) []string {
+	return references(refPattern, config)
+}
+
+// Returns each OAuth connection ID referenced anywhere in config once
+func ConnectionReferences(config map[string]any) []string {
+	return references(connectionPattern, config)
+}
+
+func references(pattern *regexp.Regexp, config map[string]any) []string {
+	var names []string
+	seen := make(map[string]bool)
+	walk(config, func(s string) string {
+		for _, m := range pattern.FindAllStringSubmatch(s, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+		return s
+	})
+	return names
+}
+
+// Returns a copy of config with every {{secret:NAME}} replaced by lookup(NAME).
+// config itself is left untouched so resolved values never leak into caches
+func Resolve(config map[string]any, lookup func(name string) (string, error)) (map[string]any, error) {
+	return resolve(refPattern, "secret", config, lookup)
+}
+
+// Like Resolve, for {{connection:ID}} references
+func ResolveConnections(config map[string]any, lookup func(id string) (string, error)) (map[string]any, error) {
+	return resolve(connectionPattern, "connection", config, lookup)
+}
+
+func resolve(pattern *regexp.Regexp, kind string, config map[string]any, lookup func(string) (string, error)) (map[string]any, error) {
+	var firstErr error
+	resolved := walk(config, func(s string) string {
+		return pattern.ReplaceAllStringFunc(s, func(ref string) string {
+			name := pattern.FindStringSubmatch(ref)[1]
+			value, err := lookup(name)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s %s: %w", kind, name, err)
+				}
+				return ref
+			}
+			return value
+		})
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return resolved.(map[string]any), nil
+}
+
+// Copies v, passing every string through fn
+func walk(v any, fn func(string) string) any {
+	switch t := v.(type) {
+	case string:
+		return fn(t)
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, item := range t {
+			out[k] = walk(item, fn)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			out[i] = walk(item, fn)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,204 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+func testCipher(t *testing.T) *Cipher {
+	t.Helper()
+	c, err := NewCipher(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+	return c
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	c := testCipher(t)
+	sealed, err := c.Seal("https://hooks.slack.com/services/T/B/X")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if strings.Contains(string(sealed), "hooks.slack.com") {
+		t.Error("Expected the sealed value not to contain the plaintext")
+	}
+	opened, err := c.Open(sealed)
+	if err != nil || opened != "https://hooks.slack.com/services/T/B/X" {
+		t.Errorf("Expected the original value back, got %q (%v)", opened, err)
+	}
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := c.Open(sealed); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("Expected ErrCiphertext for tampered data, got %v", err)
+	}
+}
+
+func TestNewCipherRejectsBadKeys(t *testing.T) {
+	if _, err := NewCipher(""); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+	if _, err := NewCipher(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
+
+func TestResolveReplacesNestedReferences(t *testing.T) {
+	config := map[string]any{
+		"webhook_url": "{{secret:SLACK_URL}}",
+		"headers":     map[string]any{"Authorization": "Bearer {{ secret:API_TOKEN }}"},
+		"targets":     []any{"{{secret:SLACK_URL}}", float64(3)},
+	}
+	values := map[string]string{"SLACK_URL": "https://hooks.example", "API_TOKEN": "tok"}
+	resolved, err := Resolve(config, func(name string) (string, error) {
+		v, ok := values[name]
+		if !ok {
+			return "", ErrNotFound
+		}
+		return v, nil
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if resolved["webhook_url"] != "https://hooks.example" {
+		t.Errorf("Expected webhook_url resolved, got %v", resolved["webhook_url"])
+	}
+	if got := resolved["headers"].(map[string]any)["Authorization"]; got != "Bearer tok" {
+		t.Errorf("Expected nested header resolved, got %v", got)
+	}
+	if got := resolved["targets"].([]any); got[0] != "https://hooks.example" || got[1] != float64(3) {
+		t.Errorf("Expected list resolved, got %v", got)
+	}
+	if config["webhook_url"] != "{{secret:SLACK_URL}}" {
+		t.Error("Expected the original config to be left untouched")
+	}
+	if refs := References(config); len(refs) != 2 || refs[0] != "API_TOKEN" && refs[0] != "SLACK_URL" {
+		t.Errorf("Expected 2 references, got %v", refs)
+	}
+}
+
+func TestResolveFailsOnMissingSecret(t *testing.T) {
+	_, err := Resolve(map[string]any{"url": "{{secret:MISSING}}"}, func(string) (string, error) {
+		return "", ErrNotFound
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEnvelopeSealsWithFreshDataKeys(t *testing.T) {
+	legacy := testCipher(t)
+	env := NewEnvelope(legacy, legacy)
+	a, err := env.Seal(context.Background(), "xoxb-token")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	b, _ := env.Seal(context.Background(), "xoxb-token")
+	if bytes.Equal(a.DataKey, b.DataKey) || a.DataKey == nil {
+		t.Error("Expected each value sealed with its own data key")
+	}
+	if got, err := env.Open(context.Background(), a); err != nil || got != "xoxb-token" {
+		t.Errorf("Expected the value back, got %q (%v)", got, err)
+	}
+	// Values sealed before envelopes have no data key
+	old, _ := legacy.Seal("https://hooks.example")
+	if got, err := env.Open(context.Background(), Sealed{Ciphertext: old}); err != nil || got != "https://hooks.example" {
+		t.Errorf("Expected a value sealed with SECRETS_KEY to open, got %q (%v)", got, err)
+	}
+	a.DataKey[len(a.DataKey)-1] ^= 0xff
+	if _, err := NewEnvelope(legacy, nil).Open(context.Background(), a); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("Expected ErrCiphertext for a tampered data key, got %v", err)
+	}
+}
+
+func TestEnvelopeSealsWithSharedDataKey(t *testing.T) {
+	env := NewEnvelope(testCipher(t), nil)
+	key, err := env.NewDataKey(context.Background())
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	sealed, err := env.SealWith(context.Background(), key, []byte(`{"email":"ada@example.com"}`))
+	if err != nil {
+		t.Fatalf("SealWith failed: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("ada@example.com")) {
+		t.Error("Expected the payload sealed")
+	}
+	got, err := env.OpenWith(context.Background(), key, sealed)
+	if err != nil || string(got) != `{"email":"ada@example.com"}` {
+		t.Errorf("Expected the payload back, got %q (%v)", got, err)
+	}
+	other, _ := env.NewDataKey(context.Background())
+	if _, err := env.OpenWith(context.Background(), other, sealed); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("Expected another workspace's key refused, got %v", err)
+	}
+}
+
+func TestKMSWrapsDataKeys(t *testing.T) {
+	wrapped := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var in struct {
+			KeyId             string
+			Plaintext         []byte
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in.KeyId != "alias/hermes" || in.EncryptionContext["purpose"] != "hermes-secrets" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			blob := []byte(fmt.Sprintf("blob-%d", len(wrapped)))
+			wrapped[string(blob)] = in.Plaintext
+			json.NewEncoder(w).Encode(map[string][]byte{"CiphertextBlob": blob})
+		case "TrentService.Decrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": wrapped[string(in.CiphertextBlob)]})
+		}
+	}))
+	defer srv.Close()
+	env, err := Load(KeyConfig{KMSKeyID: "alias/hermes", KMSRegion: "eu-west-1", KMSEndpoint: srv.URL,
+		AWS: sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	sealed, err := env.Seal(context.Background(), "ghp_token")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if string(sealed.DataKey) != "blob-0" {
+		t.Errorf("Expected the data key wrapped by KMS, got %q", sealed.DataKey)
+	}
+	if got, err := env.Open(context.Background(), sealed); err != nil || got != "ghp_token" {
+		t.Errorf("Expected the value back, got %q (%v)", got, err)
+	}
+	if _, err := Load(KeyConfig{}); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without any key, got %v", err)
+	}
+}
+
+func TestResolveConnections(t *testing.T) {
+	const id = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	config := map[string]any{"token": "{{connection:" + id + "}}", "channel": "{{secret:CHANNEL}}"}
+	if refs := ConnectionReferences(config); len(refs) != 1 || refs[0] != id {
+		t.Errorf("Expected the connection referenced, got %v", refs)
+	}
+	resolved, err := ResolveConnections(config, func(string) (string, error) { return "xoxb-1", nil })
+	if err != nil || resolved["token"] != "xoxb-1" || resolved["channel"] != "{{secret:CHANNEL}}" {
+		t.Errorf("Expected only the connection resolved, got %v (%v)", resolved, err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package settings reads a service's configuration from the environment and
+// an optional YAML file. Every missing or invalid key is collected instead of
+// stopping at the first, so a bad deploy is fixed in one go
+package settings
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names the YAML file services read settings from, when set
+const FileEnv = "CONFIG_FILE"
+
+// Values every service accepts for LOG_LEVEL and ENV
+var (
+	LogLevels    = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+	Environments = []string{"development", "staging", "production"}
+)
+
+// A YAML file's settings flattened to env style keys, plus the problems
+// found while reading values from it
+type Source struct {
+	file     map[string]string
+	problems []string
+}
+
+// Every problem found in a configuration, one per line
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d problems:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Opens the YAML file at path, which may be empty to read the environment
+// only. Nested keys are joined with _ and upper-cased, so database.url is
+// read as DATABASE_URL. The environment wins over the file
+func Open(path string) (*Source, error) {
+	s := &Source{file: map[string]string{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := flatten("", doc, s.file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Opens the file named by CONFIG_FILE
+func FromEnv() (*Source, error) {
+	return Open(os.Getenv(FileEnv))
+}
+
+func flatten(prefix string, doc map[string]any, out map[string]string) error {
+	for key, value := range doc {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		switch v := value.(type) {
+		case nil:
+		case map[string]any:
+			if err := flatten(name, v, out); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s must be a list of plain values", name)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			out[name] = strings.Join(items, ",")
+		default:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// The key's value from the environment, else the file. Set but empty counts as set
+func (s *Source) Lookup(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true
+	}
+	value, ok := s.file[key]
+	return value, ok
+}
+
+// The key's value, or def when it's unset or empty. An empty environment
+// variable doesn't hide the file's value
+func (s *Source) String(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if value := s.file[key]; value != "" {
+		return value
+	}
+	return def
+}
+
+// The key's value, recording a problem when it's unset or empty
+func (s *Source) Required(key string) string {
+	value := s.String(key, "")
+	if value == "" {
+		s.Failf("%s is required", key)
+	}
+	return value
+}
+
+// The key's value when it's one of allowed, def when unset
+func (s *Source) OneOf(key, def string, allowed ...string) string {
+	value := s.String(key, def)
+	if !slices.Contains(allowed, value) {
+		s.Failf("%s must be one of: %s", key, strings.Join(allowed, ", "))
+	}
+	return value
+}
+
+func (s *Source) Int(key string, def int) int {
+	value := s.String(key, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		s.Failf("%s must be a whole number, got %q", key, value)
+		return def
+	}
+	return n
+}
+
+func (s *Source) Int64(key string, def int64) int64 {
+	value := s.String(key, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		s.Failf("%s must be a whole number, got %q", key, value)
+		return def
+	}
+	return n
+}
+
+func (s *Source) Bool(key string, def bool) bool {
+	value := s.String(key, "")
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		s.Failf("%s must be true or false, got %q", key, value)
+		return def
+	}
+	return b
+}
+
+// Reads Go durations such as 30s or 1h30m
+func (s *Source) Duration(key string, def time.Duration) time.Duration {
+	value := s.String(key, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		s.Failf("%s must be a duration such as 30s or 5m, got %q", key, value)
+		return def
+	}
+	return d
+}
+
+// Splits a comma separated value, dropping empty entries
+func (s *Source) List(key, def string) []string {
+	return SplitList(s.String(key, def))
+}
+
+// Records a problem for the config being read
+func (s *Source) Failf(format string, args ...any) {
+	s.problems = append(s.problems, fmt.Sprintf(format, args...))
+}
+
+// Records a problem when ok is false
+func (s *Source) Check(ok bool, format string, args ...any) {
+	if !ok {
+		s.Failf(format, args...)
+	}
+}
+
+// Every problem recorded so far as an *Error, nil when there were none
+func (s *Source) Err() error {
+	if len(s.problems) == 0 {
+		return nil
+	}
+	return &Error{Problems: slices.Clone(s.problems)}
+}
+
+// Splits a comma separated list, trimming entries and dropping empty ones
+func SplitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
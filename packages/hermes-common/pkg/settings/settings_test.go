@@ -0,0 +1,109 @@
+package settings
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hermes.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvWinsOverFileOverDefault(t *testing.T) {
+	src, err := Open(writeFile(t, `
+log_level: DEBUG
+max_workers: 20
+database:
+  url: postgres://from-file
+outbound_allowed_hosts: [a.internal, b.internal]
+`))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Setenv("MAX_WORKERS", "30")
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("OUTBOUND_ALLOWED_HOSTS", "")
+	t.Setenv("RELAY_CACHE_TTL", "")
+
+	if got := src.String("LOG_LEVEL", "INFO"); got != "DEBUG" {
+		t.Errorf("Expected LOG_LEVEL from the file, got %s", got)
+	}
+	if got := src.Int("MAX_WORKERS", 10); got != 30 {
+		t.Errorf("Expected the environment to win, got %d", got)
+	}
+	if got := src.String("DATABASE_URL", ""); got != "postgres://from-file" {
+		t.Errorf("Expected nested keys to flatten, got %q", got)
+	}
+	if got := src.List("OUTBOUND_ALLOWED_HOSTS", ""); len(got) != 2 || got[1] != "b.internal" {
+		t.Errorf("Expected the file's list, got %v", got)
+	}
+	if got := src.Duration("RELAY_CACHE_TTL", 30*time.Second); got != 30*time.Second {
+		t.Errorf("Expected the default, got %v", got)
+	}
+	if err := src.Err(); err != nil {
+		t.Errorf("Expected no problems, got %v", err)
+	}
+}
+
+func TestErrCollectsEveryProblem(t *testing.T) {
+	t.Setenv("MAX_WORKERS", "ten")
+	t.Setenv("SHUTDOWN_GRACE_PERIOD", "25")
+	t.Setenv("LOG_LEVEL", "LOUD")
+	t.Setenv("DATABASE_URL", "")
+	src, _ := Open("")
+
+	if got := src.Int("MAX_WORKERS", 10); got != 10 {
+		t.Errorf("Expected the default for a bad value, got %d", got)
+	}
+	src.Duration("SHUTDOWN_GRACE_PERIOD", time.Second)
+	src.OneOf("LOG_LEVEL", "INFO", "DEBUG", "INFO", "WARN", "ERROR")
+	src.Required("DATABASE_URL")
+	src.Check(false, "MIN_WORKERS must be between 1 and MAX_WORKERS")
+
+	var cfgErr *Error
+	if err := src.Err(); !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected an *Error, got %v", err)
+	}
+	if len(cfgErr.Problems) != 5 {
+		t.Fatalf("Expected 5 problems, got %v", cfgErr.Problems)
+	}
+	msg := cfgErr.Error()
+	for _, want := range []string{`MAX_WORKERS must be a whole number, got "ten"`, "SHUTDOWN_GRACE_PERIOD", "LOG_LEVEL must be one of", "DATABASE_URL is required"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected %q in %q", want, msg)
+		}
+	}
+}
+
+func TestOpenRejectsBadFiles(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+	if _, err := Open(writeFile(t, "log_level: [DEBUG")); err == nil {
+		t.Error("Expected invalid YAML to fail")
+	}
+	if _, err := Open(writeFile(t, "hosts:\n  - {name: a}\n")); err == nil {
+		t.Error("Expected a list of objects to fail")
+	}
+}
+
+func TestLookupKeepsSetButEmpty(t *testing.T) {
+	t.Setenv("METRICS_ADDR", "")
+	src, _ := Open("")
+	if value, ok := src.Lookup("METRICS_ADDR"); !ok || value != "" {
+		t.Errorf("Expected METRICS_ADDR to be set and empty, got %q %v", value, ok)
+	}
+	if got := src.String("METRICS_ADDR", ":9091"); got != ":9091" {
+		t.Errorf("Expected String to fall back on empty, got %q", got)
+	}
+}
@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	key, err := NewKey()
+	if err != nil || !strings.HasPrefix(key, KeyPrefix) {
+		t.Fatalf("Expected a prefixed key, got %q (%v)", key, err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"event":"deploy"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign(key, now.Unix(), body)
+	if err := Verify(key, ts, signature, body, DefaultTolerance, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected the signature accepted, got %v", err)
+	}
+
+	other, _ := NewKey()
+	for name, check := range map[string]func() error{
+		"tampered body": func() error { return Verify(key, ts, signature, []byte(`{"event":"x"}`), DefaultTolerance, now) },
+		"other key":     func() error { return Verify(other, ts, signature, body, DefaultTolerance, now) },
+		"replayed":      func() error { return Verify(key, ts, signature, body, DefaultTolerance, now.Add(time.Hour)) },
+		"moved time":    func() error { return Verify(key, ts+"0", signature, body, DefaultTolerance, now) },
+		"no prefix": func() error {
+			return Verify(key, ts, strings.TrimPrefix(signature, "sha256="), body, DefaultTolerance, now)
+		},
+	} {
+		if err := check(); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestTransportSignsRequestsCarryingAKey(t *testing.T) {
+	key, _ := NewKey()
+	var got []error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, Verify(key, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body,
+			DefaultTolerance, time.Now()))
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	req, _ := http.NewRequestWithContext(WithKey(context.Background(), key), http.MethodPost, srv.URL,
+		strings.NewReader(`{"text":"hello"}`))
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, strings.NewReader("plain"))
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(got))
+	}
+	if got[0] != nil {
+		t.Errorf("Expected the keyed request signed, got %v", got[0])
+	}
+	if !errors.Is(got[1], ErrInvalidSignature) {
+		t.Errorf("Expected the other request unsigned, got %v", got[1])
+	}
+}
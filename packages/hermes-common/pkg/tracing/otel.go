@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/eulerbutcooler/hermes"
+
+// Exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard OTEL_*
+// variables (headers, sampler, resource attributes). Without either, spans
+// aren't recorded and traceparents are passed along as before. The returned
+// func flushes what is buffered and should run on shutdown
+func Setup(ctx context.Context, service, version string) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", service),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return noop, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Starts a span under the trace ctx carries, either an OTel span or a Context
+// set with WithContext, and stores the span as ctx's Context so calls made
+// under it continue from it. When the span isn't recorded ctx gets a new
+// child Context instead, the same hop-per-span IDs hermes used before OTel
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		if c, ok := FromContext(ctx); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, c.spanContext())
+		}
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, opts...)
+	if span.IsRecording() {
+		return WithContext(ctx, fromSpanContext(span.SpanContext())), span
+	}
+	if c, ok := FromContext(ctx); ok {
+		ctx = WithContext(ctx, c.Child())
+	}
+	return ctx, span
+}
+
+// The traceparent for a call made under ctx that doesn't get a span of its
+// own: the current span when it is recorded, so the callee's spans hang off
+// it, or else a new child span ID
+func Outgoing(ctx context.Context) (string, bool) {
+	c, ok := FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if trace.SpanFromContext(ctx).IsRecording() {
+		return c.String(), true
+	}
+	return c.Child().String(), true
+}
+
+func (c Context) spanContext() trace.SpanContext {
+	traceID, _ := trace.TraceIDFromHex(c.TraceID)
+	spanID, _ := trace.SpanIDFromHex(c.SpanID)
+	var flags trace.TraceFlags
+	if c.Sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	})
+}
+
+func fromSpanContext(sc trace.SpanContext) Context {
+	return Context{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String(), Sampled: sc.IsSampled()}
+}
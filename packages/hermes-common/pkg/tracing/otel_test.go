@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestStartContinuesRemoteParent(t *testing.T) {
+	recorder := recordSpans(t)
+	parent, _ := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, span := Start(WithContext(context.Background(), parent), "execute relay")
+	_, step := Start(ctx, "action slack_send")
+	step.End()
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	outer, inner := spans[1], spans[0]
+	if outer.Parent().SpanID().String() != parent.SpanID || outer.SpanContext().TraceID().String() != parent.TraceID {
+		t.Errorf("Expected the span to continue the remote parent, got parent %s", outer.Parent().SpanID())
+	}
+	if inner.Parent().SpanID() != outer.SpanContext().SpanID() {
+		t.Error("Expected the step span to be a child of the execution span")
+	}
+	if c, _ := FromContext(ctx); c.SpanID != outer.SpanContext().SpanID().String() {
+		t.Errorf("Expected ctx to carry the new span, got %s", c.SpanID)
+	}
+}
+
+func TestStartWithoutExporterMakesChild(t *testing.T) {
+	parent := New()
+	ctx, span := Start(WithContext(context.Background(), parent), "execute relay")
+	defer span.End()
+	c, ok := FromContext(ctx)
+	if !ok || c.TraceID != parent.TraceID || c.SpanID == parent.SpanID {
+		t.Errorf("Expected a child of %v, got %v", parent, c)
+	}
+}
+
+func TestTransportSendsClientSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(Header)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx := WithContext(context.Background(), New())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected a client span, got %d spans", len(spans))
+	}
+	sent, _ := Parse(got)
+	if sent.SpanID != spans[0].SpanContext().SpanID().String() {
+		t.Errorf("Expected the client span in the traceparent, got %q", got)
+	}
+	if spans[0].Status().Description != "502 Bad Gateway" {
+		t.Errorf("Expected a 502 to mark the span failed, got %+v", spans[0].Status())
+	}
+}
@@ -0,0 +1,144 @@
+// Package tracing carries a W3C trace context (traceparent) for an event from
+// hermes-hooks through the broker into the worker and its outgoing requests
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const Header = "traceparent"
+
+// One span of a trace: 16 byte trace ID, 8 byte span ID, hex encoded
+type Context struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Starts a new sampled trace
+func New() Context {
+	return Context{TraceID: randomHex(16), SpanID: randomHex(8), Sampled: true}
+}
+
+// A new span in the same trace, used for each hop
+func (c Context) Child() Context {
+	return Context{TraceID: c.TraceID, SpanID: randomHex(8), Sampled: c.Sampled}
+}
+
+func (c Context) Valid() bool {
+	return isHex(c.TraceID, 32) && isHex(c.SpanID, 16) &&
+		c.TraceID != strings.Repeat("0", 32) && c.SpanID != strings.Repeat("0", 16)
+}
+
+// Formats as a version 00 traceparent header, "" when invalid
+func (c Context) String() string {
+	if !c.Valid() {
+		return ""
+	}
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.TraceID + "-" + c.SpanID + "-" + flags
+}
+
+// Parses a traceparent header. ok is false for anything malformed
+func Parse(header string) (Context, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || !isHex(parts[3], 2) {
+		return Context{}, false
+	}
+	// Version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return Context{}, false
+	}
+	c := Context{
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+		Sampled: parts[3][1]&1 == 1,
+	}
+	if !c.Valid() {
+		return Context{}, false
+	}
+	return c, true
+}
+
+// Continues the trace in header with a new span, or starts a new trace
+func FromHeader(header string) Context {
+	if c, ok := Parse(header); ok {
+		return c.Child()
+	}
+	return New()
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+type ctxKey struct{}
+
+func WithContext(ctx context.Context, c Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+func FromContext(ctx context.Context) (Context, bool) {
+	c, ok := ctx.Value(ctxKey{}).(Context)
+	return c, ok && c.Valid()
+}
+
+// Adds a traceparent for a child span to outgoing requests whose context
+// carries a trace, recording the request as a client span when spans are
+// exported. Requests that already set the header are left alone
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := FromContext(req.Context()); !ok || req.Header.Get(Header) != "" {
+		return base.RoundTrip(req)
+	}
+	ctx, span := Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Hostname()),
+			attribute.String("url.path", req.URL.Path),
+		))
+	defer span.End()
+	c, _ := FromContext(ctx)
+	req = req.Clone(ctx)
+	req.Header.Set(Header, c.String())
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
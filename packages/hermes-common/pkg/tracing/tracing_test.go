@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, ok := Parse(header)
+	if !ok || c.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || !c.Sampled {
+		t.Fatalf("Expected a valid sampled context, got %+v (%v)", c, ok)
+	}
+	if c.String() != header {
+		t.Errorf("Expected %s, got %s", header, c.String())
+	}
+}
+
+func TestParseRejectsMalformed(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"garbage",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz-00f067aa0ba902b7-01",
+	} {
+		if _, ok := Parse(header); ok {
+			t.Errorf("Expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestFromHeaderContinuesTrace(t *testing.T) {
+	parent := New()
+	child := FromHeader(parent.String())
+	if child.TraceID != parent.TraceID || child.SpanID == parent.SpanID {
+		t.Errorf("Expected same trace with a new span, got %+v from %+v", child, parent)
+	}
+	if fresh := FromHeader("bogus"); !fresh.Valid() || fresh.TraceID == parent.TraceID {
+		t.Errorf("Expected a new trace for a bad header, got %+v", fresh)
+	}
+}
+
+func TestTransportInjectsTraceparent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(Header)
+	}))
+	defer srv.Close()
+
+	trace := New()
+	req, _ := http.NewRequestWithContext(WithContext(context.Background(), trace), http.MethodPost, srv.URL, nil)
+	client := &http.Client{Transport: &Transport{}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	sent, ok := Parse(got)
+	if !ok || sent.TraceID != trace.TraceID {
+		t.Errorf("Expected a traceparent in trace %s, got %q", trace.TraceID, got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-agent/internal/client"
+	"github.com/eulerbutcooler/hermes/services/hermes-agent/internal/config"
+	"github.com/eulerbutcooler/hermes/services/hermes-agent/internal/executors"
+	"github.com/joho/godotenv"
+)
+
+// Reported to hermes-core for minimum-version checks. Stamped at release time with -ldflags "-X main.version=..."
+var version = "1.0.0"
+
+const heartbeatEvery = 15 * time.Second
+
+func main() {
+	_ = godotenv.Load()
+	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	appLogger := logger.New("hermes-agent", cfg.Environment, cfg.LogLevel).With(
+		slog.String("agent_name", cfg.AgentName),
+	)
+	appLogger.Info("starting Hermes Agent",
+		slog.String("version", version),
+		slog.String("core_url", cfg.CoreURL),
+	)
+
+	reg := executors.NewRegistry()
+	reg.Register("http_request", executors.NewHTTPRequest(cfg.AllowedHosts))
+	reg.Register("script", executors.NewScript(cfg.ScriptsDir))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, "hermes-agent", version)
+	if err != nil {
+		appLogger.Error("tracing setup failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	core := client.New(cfg.CoreURL, version)
+	token, err := credential(ctx, cfg, core)
+	if err != nil {
+		appLogger.Error("agent has no credential", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	core.SetToken(token)
+	go heartbeat(ctx, core, appLogger)
+
+	for ctx.Err() == nil {
+		job, err := core.Poll(ctx, cfg.WaitSeconds)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			appLogger.Warn("poll failed, backing off", slog.String("error", err.Error()))
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		run(ctx, core, reg, job, appLogger)
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		appLogger.Warn("failed to flush traces", slog.String("error", err.Error()))
+	}
+	appLogger.Info("Agent stopped")
+}
+
+// Uses the stored agent credential, enrolling with the one-time token on first start
+func credential(ctx context.Context, cfg *config.Config, core *client.Client) (string, error) {
+	if cfg.AgentToken != "" {
+		return cfg.AgentToken, nil
+	}
+	if saved, err := os.ReadFile(cfg.AgentTokenFile); err == nil && len(strings.TrimSpace(string(saved))) > 0 {
+		return strings.TrimSpace(string(saved)), nil
+	}
+	if cfg.EnrollmentToken == "" {
+		return "", fmt.Errorf("set AGENT_TOKEN, or AGENT_ENROLLMENT_TOKEN to enroll")
+	}
+	token, err := core.Enroll(ctx, cfg.EnrollmentToken, cfg.AgentName)
+	if err != nil {
+		return "", fmt.Errorf("enrollment failed: %w", err)
+	}
+	if err := os.WriteFile(cfg.AgentTokenFile, []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("save agent token: %w", err)
+	}
+	return token, nil
+}
+
+// Keeps the agent marked online while it is busy running long jobs
+func heartbeat(ctx context.Context, core *client.Client, logger *slog.Logger) {
+	ticker := time.NewTicker(heartbeatEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := core.Heartbeat(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("heartbeat failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func run(ctx context.Context, core *client.Client, reg *executors.Registry, job *client.Job, logger *slog.Logger) {
+	jobLogger := logger.With(
+		slog.String("job_id", job.ID),
+		logfields.RelayID(job.RelayID),
+		slog.String("action_type", job.ActionType),
+	)
+	if trace, ok := tracing.Parse(job.Traceparent); ok {
+		ctx = tracing.WithContext(ctx, trace)
+	}
+	ctx, span := tracing.Start(ctx, "agent "+job.ActionType)
+	defer span.End()
+	if job.IdempotencyKey != "" {
+		ctx = idempotency.WithContext(ctx, idempotency.Value{Key: job.IdempotencyKey})
+	}
+	start := time.Now()
+	res := client.Result{Success: true}
+	executor, err := reg.Get(job.ActionType)
+	if err == nil {
+		res.Output, err = executor.Execute(ctx, job.Config, job.Payload)
+	}
+	if err != nil {
+		res.Success = false
+		res.Error = err.Error()
+		span.RecordError(err)
+		jobLogger.Error("job failed", slog.Duration("duration", time.Since(start)), slog.String("error", err.Error()))
+	} else {
+		jobLogger.Info("job succeeded", slog.Duration("duration", time.Since(start)))
+	}
+
+	// Report even when shutting down so the worker isn't left waiting for a timeout
+	reportCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := core.Report(reportCtx, job.ID, res); err != nil {
+		jobLogger.Error("failed to report result", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/eulerbutcooler/hermes/services/hermes-agent
+
+go 1.25.6
+
+require (
+	github.com/eulerbutcooler/hermes/packages/hermes-common v0.0.0-20260121205147-6aed8b07d740
+	github.com/joho/godotenv v1.5.1
+)
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Job as returned by hermes-core's agent poll endpoint
+type Job struct {
+	ID         string          `json:"id"`
+	RelayID    string          `json:"relay_id"`
+	EventID    string          `json:"event_id"`
+	ActionType string          `json:"action_type"`
+	Config     map[string]any  `json:"config"`
+	Payload    json.RawMessage `json:"payload"`
+	// Trace of the event that produced the job, "" when it had none
+	Traceparent string `json:"traceparent,omitempty"`
+	// Sent as Idempotency-Key by executors whose destinations support it
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type Result struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+}
+
+type Client struct {
+	baseURL string
+	version string
+	token   string
+	http    *http.Client
+}
+
+func New(baseURL, version string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		version: version,
+		// Must outlive the server-side long-poll wait
+		http: &http.Client{Timeout: 90 * time.Second},
+	}
+}
+
+// Sets the agent credential used on every call after enrollment
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Trades a single-use enrollment token for a long-lived agent credential
+func (c *Client) Enroll(ctx context.Context, enrollmentToken, name string) (string, error) {
+	body, _ := json.Marshal(map[string]any{
+		"token":   enrollmentToken,
+		"name":    name,
+		"version": c.version,
+	})
+	var envelope struct {
+		Data struct {
+			AgentToken string `json:"agent_token"`
+		} `json:"data"`
+	}
+	if err := c.call(ctx, "/api/v1/agents/enroll", body, http.StatusCreated, &envelope); err != nil {
+		return "", err
+	}
+	return envelope.Data.AgentToken, nil
+}
+
+func (c *Client) Heartbeat(ctx context.Context) error {
+	body, _ := json.Marshal(map[string]any{"version": c.version})
+	return c.call(ctx, "/api/v1/agents/heartbeat", body, http.StatusOK, nil)
+}
+
+// Waits up to waitSeconds for a job. Returns nil, nil when none arrived
+func (c *Client) Poll(ctx context.Context, waitSeconds int) (*Job, error) {
+	body, _ := json.Marshal(map[string]any{
+		"version":      c.version,
+		"wait_seconds": waitSeconds,
+	})
+	resp, err := c.post(ctx, "/api/v1/agents/poll", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("poll returned %d", resp.StatusCode)
+	}
+	var envelope struct {
+		Data Job `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("decode job: %w", err)
+	}
+	return &envelope.Data, nil
+}
+
+func (c *Client) Report(ctx context.Context, jobID string, res Result) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("marshal result: %w", err)
+	}
+	return c.call(ctx, "/api/v1/agents/jobs/"+jobID+"/result", body, http.StatusOK, nil)
+}
+
+func (c *Client) call(ctx context.Context, path string, body []byte, wantStatus int, out any) error {
+	resp, err := c.post(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		return fmt.Errorf("%s returned %d", path, resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode %s response: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s: %w", path, err)
+	}
+	return resp, nil
+}
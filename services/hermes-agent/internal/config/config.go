@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	Environment string
+	LogLevel    string
+	CoreURL     string
+	AgentName   string
+	// Credential issued at enrollment. Read from AgentTokenFile when not set directly
+	AgentToken      string
+	AgentTokenFile  string
+	EnrollmentToken string
+	WaitSeconds     int
+	// Directory holding the only scripts the agent is allowed to run
+	ScriptsDir string
+	// Hosts the http_request action may call. Empty allows any host
+	AllowedHosts []string
+}
+
+func getEnv(key, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if val := os.Getenv(key); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			return intVal
+		}
+	}
+	return defaultValue
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func LoadConfig() *Config {
+	hostname, _ := os.Hostname()
+	return &Config{
+		Environment:     getEnv("ENV", "development"),
+		LogLevel:        getEnv("LOG_LEVEL", "INFO"),
+		CoreURL:         strings.TrimRight(getEnv("CORE_URL", "http://localhost:3000"), "/"),
+		AgentName:       getEnv("AGENT_NAME", hostname),
+		AgentToken:      getEnv("AGENT_TOKEN", ""),
+		AgentTokenFile:  getEnv("AGENT_TOKEN_FILE", ".hermes-agent-token"),
+		EnrollmentToken: getEnv("AGENT_ENROLLMENT_TOKEN", ""),
+		WaitSeconds:     getEnvInt("AGENT_WAIT_SECONDS", 30),
+		ScriptsDir:      getEnv("AGENT_SCRIPTS_DIR", ""),
+		AllowedHosts:    splitList(getEnv("AGENT_ALLOWED_HOSTS", "")),
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.CoreURL == "" {
+		return fmt.Errorf("CORE_URL is required")
+	}
+	if c.AgentName == "" {
+		return fmt.Errorf("AGENT_NAME is required")
+	}
+	return nil
+}
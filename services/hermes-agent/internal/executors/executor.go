@@ -0,0 +1,34 @@
+package executors
+
+import (
+	"context"
+	"fmt"
+)
+
+// Local-only action run by the agent. Output is sent back to hermes-core
+type Executor interface {
+	Execute(ctx context.Context, config map[string]any, payload []byte) (string, error)
+}
+
+// Largest output an action may report back
+const maxOutputBytes = 4096
+
+type Registry struct {
+	executors map[string]Executor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{executors: make(map[string]Executor)}
+}
+
+func (r *Registry) Register(name string, executor Executor) {
+	r.executors[name] = executor
+}
+
+func (r *Registry) Get(name string) (Executor, error) {
+	exec, exists := r.executors[name]
+	if !exists {
+		return nil, fmt.Errorf("action type %s is not supported by this agent", name)
+	}
+	return exec, nil
+}
@@ -0,0 +1,75 @@
+package executors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+)
+
+// Calls an HTTP endpoint reachable only from the agent's network
+type HTTPRequest struct {
+	client       *http.Client
+	allowedHosts []string
+}
+
+func NewHTTPRequest(allowedHosts []string) *HTTPRequest {
+	return &HTTPRequest{
+		client:       &http.Client{Timeout: 30 * time.Second, Transport: &tracing.Transport{}},
+		allowedHosts: allowedHosts,
+	}
+}
+
+func (h *HTTPRequest) Execute(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	rawURL, _ := config["url"].(string)
+	if rawURL == "" {
+		return "", fmt.Errorf("missing url in http_request config")
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	if len(h.allowedHosts) > 0 && !slices.Contains(h.allowedHosts, target.Hostname()) {
+		return "", fmt.Errorf("host %s is not in the agent allowlist", target.Hostname())
+	}
+	method, _ := config["method"].(string)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Headers from the action config may override it
+	if key, ok := idempotency.FromContext(ctx); ok {
+		req.Header.Set(idempotency.Header, key.Key)
+	}
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for k, v := range headers {
+			if s, ok := v.(string); ok {
+				req.Header.Set(k, s)
+			}
+		}
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutputBytes))
+	output := fmt.Sprintf("%d %s", resp.StatusCode, payload.TruncateString(string(respBody), maxOutputBytes))
+	if resp.StatusCode >= 400 {
+		return output, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return output, nil
+}
@@ -0,0 +1,102 @@
+package executors
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+)
+
+func TestHTTPRequestSendsPayload(t *testing.T) {
+	var gotBody, gotHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody, gotHeader = string(b), r.Header.Get("X-Token")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	out, err := NewHTTPRequest(nil).Execute(context.Background(), map[string]any{
+		"url":     srv.URL,
+		"headers": map[string]any{"X-Token": "abc"},
+	}, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out != "200 ok" || gotBody != `{"a":1}` || gotHeader != "abc" {
+		t.Errorf("Unexpected exchange: out=%q body=%q header=%q", out, gotBody, gotHeader)
+	}
+}
+
+func TestHTTPRequestFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, strings.Repeat("x", 2*maxOutputBytes), http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	out, err := NewHTTPRequest(nil).Execute(context.Background(), map[string]any{"url": srv.URL}, nil)
+	if err == nil {
+		t.Fatal("Expected an error for a 502")
+	}
+	if !strings.HasPrefix(out, "502 ") || len(out) > maxOutputBytes+64 {
+		t.Errorf("Expected a bounded 502 summary, got %d bytes", len(out))
+	}
+}
+
+func TestHTTPRequestEnforcesAllowlist(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+
+	if _, err := NewHTTPRequest([]string{"internal.example"}).Execute(context.Background(), map[string]any{"url": srv.URL}, nil); err == nil {
+		t.Error("Expected a host outside the allowlist to be rejected")
+	}
+	if _, err := NewHTTPRequest([]string{host.Hostname()}).Execute(context.Background(), map[string]any{"url": srv.URL}, nil); err != nil {
+		t.Errorf("Expected an allowlisted host to be called, got %v", err)
+	}
+}
+
+func TestHTTPRequestContinuesTrace(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(tracing.Header)
+	}))
+	defer srv.Close()
+
+	trace, _ := tracing.Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.WithContext(context.Background(), trace)
+	if _, err := NewHTTPRequest(nil).Execute(ctx, map[string]any{"url": srv.URL}, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	sent, ok := tracing.Parse(got)
+	if !ok || sent.TraceID != trace.TraceID {
+		t.Errorf("Expected a traceparent in trace %s, got %q", trace.TraceID, got)
+	}
+}
+
+func TestHTTPRequestSendsIdempotencyKey(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(idempotency.Header))
+	}))
+	defer srv.Close()
+
+	ctx := idempotency.WithContext(context.Background(), idempotency.Value{Key: "abc123"})
+	h := NewHTTPRequest(nil)
+	if _, err := h.Execute(ctx, map[string]any{"url": srv.URL}, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	override := map[string]any{"url": srv.URL, "headers": map[string]any{idempotency.Header: "custom"}}
+	if _, err := h.Execute(ctx, override, nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(got) != 2 || got[0] != "abc123" || got[1] != "custom" {
+		t.Errorf("Expected the job's key, then the configured header, got %v", got)
+	}
+}
@@ -0,0 +1,79 @@
+package executors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+const scriptTimeout = 60 * time.Second
+
+// Runs a script from the agent's scripts directory with the payload on stdin.
+// Only file names are accepted so configs can't reach outside that directory
+type Script struct {
+	dir string
+}
+
+func NewScript(dir string) *Script {
+	return &Script{dir: dir}
+}
+
+func (s *Script) Execute(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	if s.dir == "" {
+		return "", fmt.Errorf("scripts are disabled on this agent (AGENT_SCRIPTS_DIR not set)")
+	}
+	name, _ := config["script"].(string)
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("script must be a plain file name inside the scripts directory")
+	}
+	var args []string
+	if rawArgs, ok := config["args"].([]any); ok {
+		for _, a := range rawArgs {
+			args = append(args, fmt.Sprint(a))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, filepath.Join(s.dir, name), args...)
+	cmd.Stdin = bytes.NewReader(body)
+	out := &outputBuffer{limit: maxOutputBytes}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("script %s: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// Keeps the first limit bytes of a script's output and discards the rest.
+// Writes always succeed, a failing writer would break the script's pipe.
+// The buffer isn't embedded so io.Copy can't bypass Write through ReadFrom
+type outputBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// Drops a partial rune left behind by the cut
+func (b *outputBuffer) String() string {
+	out := b.buf.Bytes()
+	for len(out) > 0 {
+		r, size := utf8.DecodeLastRune(out)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		out = out[:len(out)-1]
+	}
+	return string(out)
+}
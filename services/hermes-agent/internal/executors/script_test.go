@@ -0,0 +1,71 @@
+package executors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+}
+
+func TestScriptKeepsFirstBytesOfLongOutput(t *testing.T) {
+	dir := t.TempDir()
+	// Prints far more than the output cap on both streams
+	writeScript(t, dir, "noisy.sh", "i=0\nwhile [ $i -lt 2000 ]; do echo 'line of output'; echo 'err' >&2; i=$((i+1)); done\necho done\n")
+
+	out, err := NewScript(dir).Execute(context.Background(), map[string]any{"script": "noisy.sh"}, nil)
+	if err != nil {
+		t.Fatalf("Expected a noisy script to succeed, got %v", err)
+	}
+	if len(out) != maxOutputBytes {
+		t.Errorf("Expected output capped at %d bytes, got %d", maxOutputBytes, len(out))
+	}
+	if !strings.HasPrefix(out, "line of output") {
+		t.Errorf("Expected the start of the output kept, got %q", out[:32])
+	}
+}
+
+func TestScriptReadsPayloadAndReportsFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "echo.sh", "cat\n")
+	writeScript(t, dir, "fail.sh", "echo broken >&2\nexit 3\n")
+	s := NewScript(dir)
+
+	out, err := s.Execute(context.Background(), map[string]any{"script": "echo.sh"}, []byte(`{"a":1}`))
+	if err != nil || out != `{"a":1}` {
+		t.Errorf("Expected the payload echoed back, got %q (%v)", out, err)
+	}
+	out, err = s.Execute(context.Background(), map[string]any{"script": "fail.sh"}, nil)
+	if err == nil || !strings.Contains(out, "broken") {
+		t.Errorf("Expected a failure with stderr captured, got %q (%v)", out, err)
+	}
+}
+
+func TestScriptRejectsPathsOutsideDir(t *testing.T) {
+	s := NewScript(t.TempDir())
+	for _, name := range []string{"", "../evil.sh", "sub/run.sh", "/bin/sh"} {
+		if _, err := s.Execute(context.Background(), map[string]any{"script": name}, nil); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+	if _, err := NewScript("").Execute(context.Background(), map[string]any{"script": "x.sh"}, nil); err == nil {
+		t.Error("Expected scripts to be disabled without a directory")
+	}
+}
+
+func TestOutputBufferDropsPartialRune(t *testing.T) {
+	b := &outputBuffer{limit: 4}
+	if n, err := b.Write([]byte("ab€")); n != 5 || err != nil {
+		t.Fatalf("Expected the write to report success, got %d, %v", n, err)
+	}
+	if b.String() != "ab" {
+		t.Errorf("Expected the cut rune dropped, got %q", b.String())
+	}
+}
@@ -1,33 +1,59 @@
 package main
 
 import (
+	"context"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/dbpool"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/flags"
 	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/migrate"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/reload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/alerts"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/api"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/canary"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/config"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/connections"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/db"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/deliveries"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/export"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/metrics"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/partitions"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/privacy"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/queue"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/usage"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
+// Stamped at release time with -ldflags "-X main.version=..."
+var version = "1.0.0"
+
+// How long an instance keeps stored feature flags before reading them again
+const flagCacheTTL = 30 * time.Second
+
 func main() {
 	_ = godotenv.Load()
-	cfg := config.LoadConfig()
-	if err := cfg.Validate(); err != nil {
+	cfg, err := config.Load()
+	if err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
-	appLogger := logger.New("hermes-core", cfg.Environment, cfg.LogLevel)
+	appLogger, logLevel := logger.NewLeveled("hermes-core", cfg.Environment, cfg.LogLevel)
 
 	appLogger.Info("starting Hermes Core API",
-		slog.String("version", "1.0.0"),
+		slog.String("version", version),
 		slog.String("port", cfg.Port),
 	)
 
-	pool, err := db.New(cfg.DatabaseURL)
+	pool, err := db.New(cfg.DatabaseURL, cfg.DBPool)
 	if err != nil {
 		appLogger.Error("database connection failed", slog.String("error", err.Error()))
 		os.Exit(1)
@@ -35,8 +61,199 @@ func main() {
 	defer pool.Close()
 	appLogger.Info("database connected")
 
-	relayStore := store.NewRelayStore(pool)
-	handler := api.NewHandler(relayStore, appLogger)
+	migrator, err := migrate.New(pool, appLogger)
+	if err != nil {
+		appLogger.Error("invalid migrations", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// hermes-core migrate [up | down [steps] | version | force <version>]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate.Command(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+			appLogger.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.MigrateOnStart {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			appLogger.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		appLogger.Info("schema up to date", slog.Int("applied", applied))
+	}
+
+	events, err := broker.Open(cfg.BrokerConfig(), appLogger)
+	if err != nil {
+		appLogger.Error("broker connection failed", slog.String("broker", cfg.Broker), slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	publisher := queue.NewPublisher(events)
+	defer publisher.Close()
+	appLogger.Info("connected to broker", slog.String("broker", cfg.Broker))
+
+	// Nil when no key is set, which turns the secrets API off
+	envelope, err := secrets.Load(cfg.SecretKeys())
+	if err != nil {
+		appLogger.Warn("SECRETS_KEY and SECRETS_KMS_KEY_ID not set, secret references are disabled")
+	}
+
+	if cfg.AdminToken == "" {
+		appLogger.Warn("ADMIN_API_TOKEN not set, operator routes are disabled")
+	}
+
+	if cfg.PartitionMaintenanceInterval > 0 {
+		maintainer := partitions.New(store.NewPartitionStore(pool), appLogger,
+			cfg.PartitionMaintenanceInterval, cfg.ExecutionLogRetention)
+		go maintainer.Run(context.Background())
+	}
+
+	var replica *pgxpool.Pool
+	if cfg.ReplicaURL != "" {
+		if replica, err = dbpool.Open(cfg.ReplicaURL, cfg.DBPool); err != nil {
+			appLogger.Error("read replica setup failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer replica.Close()
+		appLogger.Info("reading relays and logs from a replica")
+	}
+	relays := store.NewRelayStore(pool, dbpool.NewReadRouter(pool, replica, appLogger), envelope)
+	// Validated with the config, so this can't fail
+	secretBackends, _ := secrets.LoadBackends(cfg.SecretBackends())
+	secretStore := store.NewSecretStore(pool, envelope, secretBackends)
+	logExports := store.NewExportStore(pool, envelope)
+	if cfg.LogExportInterval > 0 {
+		go export.New(logExports, secretStore, appLogger, cfg.LogExportInterval).Run(context.Background())
+	}
+	deliveryStore := store.NewDeliveryStore(pool)
+	deliveryLog := deliveries.New(deliveryStore, appLogger, cfg.DeliveryLogRetention)
+	if err := publisher.SubscribeDeliveries(deliveryLog.Add); err != nil {
+		appLogger.Error("delivery subscription failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	go deliveryLog.Run(context.Background())
+	var canaryStatus func() canary.Status
+	if cfg.CanaryInterval > 0 {
+		prober := canary.New(store.NewCanaryStore(pool), cfg.CanaryHooksURL, appLogger, cfg.CanaryInterval, cfg.CanaryTimeout)
+		canaryStatus = prober.Status
+		go prober.Run(context.Background())
+		appLogger.Info("canary enabled", slog.String("hooks_url", cfg.CanaryHooksURL),
+			slog.Duration("interval", cfg.CanaryInterval))
+	}
+	usageStore := store.NewUsageStore(pool)
+	if cfg.UsageRollupInterval > 0 {
+		var exporter usage.Exporter
+		if cfg.UsageExportURL != "" {
+			exporter = usage.NewWebhookExporter(cfg.UsageExportURL, cfg.UsageExportSecret)
+		}
+		go usage.New(usageStore, exporter, appLogger, cfg.UsageRollupInterval).Run(context.Background())
+	}
+	alertRules := store.NewAlertStore(pool)
+	if cfg.AlertEvalInterval > 0 {
+		go alerts.New(alertRules, publisher, appLogger, cfg.AlertEvalInterval).Run(context.Background())
+	}
+	dataRequests := store.NewDataRequestStore(pool, envelope)
+	if cfg.DataRequestInterval > 0 {
+		go privacy.New(dataRequests, appLogger, cfg.DataRequestInterval).Run(context.Background())
+	}
+	flagStore := store.NewFlagStore(pool)
+	featureFlags := flags.NewEvaluator(cfg.FeatureFlags, flagStore.FeatureFlags, flagCacheTTL, appLogger)
+	reloader := reload.New(appLogger, func() error {
+		if err := reload.Env(".env", config.Reloadable...); err != nil {
+			return err
+		}
+		next, err := config.Load()
+		if err != nil {
+			return err
+		}
+		logLevel.Set(logger.ParseLevel(next.LogLevel))
+		featureFlags.SetDefaults(next.FeatureFlags)
+		return nil
+	})
+	go reloader.OnSignal(context.Background())
+	apiMetrics := metrics.New(pool, relays)
+	if canaryStatus != nil {
+		apiMetrics.RegisterCanary(canaryStatus)
+	}
+	var verifier *auth.Verifier
+	if cfg.JWTJWKSURL != "" {
+		verifier = auth.NewVerifier(auth.Config{
+			Issuer:    cfg.JWTIssuer,
+			JWKSURL:   cfg.JWTJWKSURL,
+			Audience:  cfg.JWTAudience,
+			UserClaim: cfg.JWTUserClaim,
+		})
+		appLogger.Info("jwt auth enabled", slog.String("issuer", cfg.JWTIssuer))
+	}
+	if cfg.AuthDisabled {
+		appLogger.Warn("AUTH_DISABLED is set, /api/v1 user routes trust the user_id clients send")
+	}
+	var oidcLogin *api.OIDCLogin
+	if cfg.OIDCIssuer != "" {
+		oidcLogin = &api.OIDCLogin{
+			Provider: auth.NewProvider(auth.OIDCConfig{
+				Issuer:       cfg.OIDCIssuer,
+				ClientID:     cfg.OIDCClientID,
+				ClientSecret: cfg.OIDCClientSecret,
+				RedirectURL:  cfg.OIDCRedirectURL,
+				Scopes:       cfg.OIDCScopes,
+			}),
+			SessionTTL:   cfg.SessionTTL,
+			DashboardURL: cfg.OIDCDashboardURL,
+		}
+		appLogger.Info("single sign-on enabled", slog.String("issuer", cfg.OIDCIssuer))
+	}
+	connectionStore := store.NewConnectionStore(pool, envelope)
+	var oauth *api.OAuthConnections
+	if clients := cfg.OAuthClients(); len(clients) > 0 {
+		var providers []connections.Provider
+		for name, client := range clients {
+			provider := connections.Builtin[name]
+			provider.ClientID, provider.ClientSecret = client.ID, client.Secret
+			providers = append(providers, provider)
+		}
+		manager := connections.New(connectionStore, providers, cfg.OAuthCallbackURL, appLogger, cfg.ConnectionRefreshInterval)
+		go manager.Run(context.Background())
+		oauth = &api.OAuthConnections{Manager: manager, DashboardURL: cfg.OAuthDashboardURL}
+		appLogger.Info("oauth connections enabled", slog.Any("providers", manager.Providers()))
+	}
+	handler := api.NewHandler(api.Deps{
+		Relays:      relays,
+		DeadLetters: store.NewDeadLetterStore(pool, envelope),
+		Agents:      store.NewAgentStore(pool, envelope),
+		Workers:     store.NewWorkerStore(pool),
+		Overview:    relays,
+		Alerts:      alertRules,
+		LogExports:  logExports,
+		Deliveries:  deliveryStore,
+		Usage:       usageStore,
+		Plugins:     store.NewPluginStore(pool),
+		Secrets:     secretStore,
+		Publisher:   publisher,
+		AgentPolicy: api.AgentPolicy{
+			SensitiveActions:    cfg.AgentSensitiveActions,
+			MinSensitiveVersion: cfg.AgentMinSensitiveVersion,
+		},
+		AdminToken:   cfg.AdminToken,
+		Metrics:      apiMetrics,
+		DebugToken:   cfg.DebugToken,
+		Reload:       reloader.Reload,
+		FlagStore:    flagStore,
+		Flags:        featureFlags,
+		Schema:       migrator.Status,
+		Canary:       canaryStatus,
+		Auth:         verifier,
+		AuthDisabled: cfg.AuthDisabled,
+		OIDC:         oidcLogin,
+		Sessions:     store.NewSessionStore(pool),
+		Workspaces:   store.NewWorkspaceStore(pool),
+		APIKeys:      store.NewAPIKeyStore(pool),
+		OAuth:        oauth,
+		Connections:  connectionStore,
+		Redaction:    store.NewRedactionStore(pool),
+		DataRequests: dataRequests,
+		Logger:       appLogger,
+	})
 	router := api.NewRouter(handler)
 
 	appLogger.Info("server listening", slog.String("port", cfg.Port))
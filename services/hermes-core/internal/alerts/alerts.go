@@ -0,0 +1,171 @@
+// Package alerts evaluates per-relay alert rules against the execution logs.
+// When a rule starts or stops firing, an alert event is published to each of
+// its channel relays, which deliver it wherever their actions point
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// A no_executions rule compares its window against this many windows before
+// it, to tell a quiet relay from one that stopped getting traffic
+const baselineWindows = 7
+
+// How long one evaluation pass may take
+const evaluateTimeout = 30 * time.Second
+
+// Rule storage, implemented by *store.AlertStore
+type Store interface {
+	ActiveRules(ctx context.Context) ([]models.AlertRule, error)
+	Stats(ctx context.Context, relayID string, window, lookback time.Duration) (store.AlertStats, error)
+	Transition(ctx context.Context, id, from, to string) (bool, error)
+}
+
+type Publisher interface {
+	PublishEvent(relayID, eventID, traceparent string, payload json.RawMessage) error
+}
+
+// The payload channel relays receive
+type Alert struct {
+	RuleID        string    `json:"rule_id"`
+	RuleName      string    `json:"rule_name"`
+	RelayID       string    `json:"relay_id"`
+	Condition     string    `json:"condition"`
+	State         string    `json:"state"`
+	Threshold     float64   `json:"threshold,omitempty"`
+	FailureRate   float64   `json:"failure_rate"`
+	Executions    int       `json:"executions"`
+	Failures      int       `json:"failures"`
+	WindowSeconds int       `json:"window_seconds"`
+	At            time.Time `json:"at"`
+}
+
+type Evaluator struct {
+	store     Store
+	publisher Publisher
+	logger    *slog.Logger
+	interval  time.Duration
+}
+
+func New(s Store, p Publisher, logger *slog.Logger, interval time.Duration) *Evaluator {
+	return &Evaluator{store: s, publisher: p, logger: logger, interval: interval}
+}
+
+// Evaluates every rule each interval until ctx is done
+func (e *Evaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.Evaluate(ctx)
+	}
+}
+
+// One pass over the rules of active relays
+func (e *Evaluator) Evaluate(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, evaluateTimeout)
+	defer cancel()
+	rules, err := e.store.ActiveRules(ctx)
+	if err != nil {
+		e.logger.Error("failed to load alert rules", slog.String("error", err.Error()))
+		return
+	}
+	for _, rule := range rules {
+		if err := e.evaluate(ctx, rule); err != nil {
+			e.logger.Error("failed to evaluate alert rule", logfields.RelayID(rule.RelayID),
+				slog.String("rule_id", rule.ID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, rule models.AlertRule) error {
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	var lookback time.Duration
+	if rule.Condition == store.AlertConditionNoExecutions {
+		lookback = baselineWindows * window
+	}
+	stats, err := e.store.Stats(ctx, rule.RelayID, window, lookback)
+	if err != nil {
+		return err
+	}
+	state := store.AlertStateOK
+	if firing(rule, stats) {
+		state = store.AlertStateFiring
+	}
+	if state == rule.State {
+		return nil
+	}
+	won, err := e.store.Transition(ctx, rule.ID, rule.State, state)
+	if err != nil || !won {
+		return err
+	}
+	alert := Alert{
+		RuleID:        rule.ID,
+		RuleName:      rule.Name,
+		RelayID:       rule.RelayID,
+		Condition:     rule.Condition,
+		State:         state,
+		Threshold:     rule.Threshold,
+		Executions:    stats.Executions,
+		Failures:      stats.Failures,
+		WindowSeconds: rule.WindowSeconds,
+		At:            time.Now().UTC(),
+	}
+	if stats.Executions > 0 {
+		alert.FailureRate = float64(stats.Failures) / float64(stats.Executions)
+	}
+	e.logger.Warn("alert "+state, logfields.RelayID(rule.RelayID),
+		slog.String("rule_id", rule.ID),
+		slog.String("rule", rule.Name),
+		slog.Int("executions", stats.Executions),
+		slog.Int("failures", stats.Failures))
+	e.notify(rule, alert)
+	return nil
+}
+
+// Whether the rule's condition holds for the window's counts
+func firing(rule models.AlertRule, stats store.AlertStats) bool {
+	switch rule.Condition {
+	case store.AlertConditionFailureRate:
+		if stats.Executions == 0 || stats.Executions < rule.MinExecutions {
+			return false
+		}
+		return float64(stats.Failures)/float64(stats.Executions) > rule.Threshold
+	case store.AlertConditionNoExecutions:
+		// Keeps firing while the relay stays silent, even once the baseline
+		// has aged out of the lookback
+		return stats.Executions == 0 && (stats.Baseline >= rule.MinExecutions || rule.State == store.AlertStateFiring)
+	}
+	return false
+}
+
+// Publishes the alert to each channel under one event ID, so the worker drops
+// a redelivered alert as a duplicate
+func (e *Evaluator) notify(rule models.AlertRule, alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		e.logger.Error("failed to encode alert", slog.String("rule_id", rule.ID), slog.String("error", err.Error()))
+		return
+	}
+	eventID := fmt.Sprintf("alert-%s-%s-%d", rule.ID, alert.State, alert.At.Unix())
+	for _, channel := range rule.Channels {
+		if err := e.publisher.PublishEvent(channel, eventID, "", body); err != nil {
+			e.logger.Error("failed to publish alert", logfields.RelayID(channel),
+				slog.String("rule_id", rule.ID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
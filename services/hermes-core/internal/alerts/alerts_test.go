@@ -0,0 +1,109 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// In-memory rules whose transitions behave like the conditional UPDATE
+type fakeStore struct {
+	mu    sync.Mutex
+	rules []models.AlertRule
+	stats store.AlertStats
+}
+
+func (f *fakeStore) ActiveRules(context.Context) ([]models.AlertRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.AlertRule(nil), f.rules...), nil
+}
+
+func (f *fakeStore) Stats(context.Context, string, time.Duration, time.Duration) (store.AlertStats, error) {
+	return f.stats, nil
+}
+
+func (f *fakeStore) Transition(_ context.Context, id, from, to string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rules {
+		if f.rules[i].ID == id && f.rules[i].State == from {
+			f.rules[i].State = to
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type fakePublisher struct {
+	relays []string
+	alerts []Alert
+}
+
+func (p *fakePublisher) PublishEvent(relayID, eventID, traceparent string, payload json.RawMessage) error {
+	var alert Alert
+	if err := json.Unmarshal(payload, &alert); err != nil {
+		return err
+	}
+	p.relays = append(p.relays, relayID)
+	p.alerts = append(p.alerts, alert)
+	return nil
+}
+
+func TestFiring(t *testing.T) {
+	failureRate := models.AlertRule{Condition: store.AlertConditionFailureRate, Threshold: 0.2, MinExecutions: 5}
+	noExecutions := models.AlertRule{Condition: store.AlertConditionNoExecutions, MinExecutions: 10}
+	cases := []struct {
+		name  string
+		rule  models.AlertRule
+		stats store.AlertStats
+		want  bool
+	}{
+		{"rate over threshold", failureRate, store.AlertStats{Executions: 10, Failures: 3}, true},
+		{"rate at threshold", failureRate, store.AlertStats{Executions: 10, Failures: 2}, false},
+		{"too few executions", failureRate, store.AlertStats{Executions: 4, Failures: 4}, false},
+		{"silent busy relay", noExecutions, store.AlertStats{Baseline: 24}, true},
+		{"silent quiet relay", noExecutions, store.AlertStats{Baseline: 3}, false},
+		{"traffic", noExecutions, store.AlertStats{Executions: 1, Baseline: 24}, false},
+	}
+	for _, c := range cases {
+		if got := firing(c.rule, c.stats); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+	noExecutions.State = store.AlertStateFiring
+	if !firing(noExecutions, store.AlertStats{}) {
+		t.Error("Expected a firing no_executions rule to keep firing once its baseline ages out")
+	}
+}
+
+func TestEvaluateNotifiesOnStateChanges(t *testing.T) {
+	s := &fakeStore{
+		rules: []models.AlertRule{{ID: "rule-1", RelayID: "relay-a", Name: "errors", Condition: store.AlertConditionFailureRate,
+			Threshold: 0.2, MinExecutions: 1, WindowSeconds: 900, Channels: []string{"relay-x", "relay-y"}, State: store.AlertStateOK}},
+		stats: store.AlertStats{Executions: 4, Failures: 2},
+	}
+	pub := &fakePublisher{}
+	e := New(s, pub, logger.New("hermes-core-test", "test", "error"), time.Minute)
+
+	e.Evaluate(context.Background())
+	e.Evaluate(context.Background())
+	if len(pub.alerts) != 2 || pub.relays[0] != "relay-x" || pub.relays[1] != "relay-y" {
+		t.Fatalf("Expected one firing alert per channel, got %v", pub.relays)
+	}
+	if a := pub.alerts[0]; a.State != store.AlertStateFiring || a.FailureRate != 0.5 || a.RelayID != "relay-a" {
+		t.Errorf("Unexpected alert: %+v", a)
+	}
+
+	s.stats = store.AlertStats{Executions: 4}
+	e.Evaluate(context.Background())
+	if len(pub.alerts) != 4 || pub.alerts[3].State != store.AlertStateOK {
+		t.Errorf("Expected a resolved alert per channel, got %+v", pub.alerts)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Guards operator routes with the ADMIN_API_TOKEN bearer credential.
+// Without a configured token every request is refused
+func (h *Handler) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			h.respondError(w, http.StatusForbidden, "Admin API is disabled, set ADMIN_API_TOKEN", "FORBIDDEN")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			h.respondError(w, http.StatusUnauthorized, "Admin token required", "UNAUTHORIZED")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
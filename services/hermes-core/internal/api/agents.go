@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/version"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultAgentWait      = 30 * time.Second
+	maxAgentWait          = 60 * time.Second
+	agentPollInterval     = time.Second
+	defaultEnrollmentTTL  = 24 * time.Hour
+	maxEnrollmentTTLHours = 24 * 30
+)
+
+// Version requirements for agents. Sensitive action types (e.g. scripts) may
+// only run on agents at or above MinSensitiveVersion, on top of any
+// min_agent_version set on the action itself
+type AgentPolicy struct {
+	SensitiveActions    []string
+	MinSensitiveVersion string
+}
+
+func (p AgentPolicy) allows(actionType, minVersion, agentVersion string) bool {
+	if !version.AtLeast(agentVersion, minVersion) {
+		return false
+	}
+	if slices.Contains(p.SensitiveActions, actionType) {
+		return version.AtLeast(agentVersion, p.MinSensitiveVersion)
+	}
+	return true
+}
+
+// Agent storage used by the handlers, implemented by *store.AgentStore
+type AgentStore interface {
+	CreateEnrollmentToken(ctx context.Context, group string, labels map[string]string, ttl time.Duration) (*models.EnrollmentToken, error)
+	Enroll(ctx context.Context, req models.EnrollAgentRequest) (*models.EnrollAgentResponse, error)
+	Authenticate(ctx context.Context, token string) (*models.Agent, error)
+	Heartbeat(ctx context.Context, agentID, version string) error
+	ListAgents(ctx context.Context, group string) ([]models.Agent, error)
+	RevokeAgent(ctx context.Context, agentID string) error
+	ClaimJob(ctx context.Context, agent *models.Agent, allowed store.VersionPolicy) (*models.AgentJob, error)
+	CompleteJob(ctx context.Context, id, agentID string, res models.AgentJobResultRequest) error
+}
+
+type agentCtxKey struct{}
+
+func agentFromContext(ctx context.Context) *models.Agent {
+	agent, _ := ctx.Value(agentCtxKey{}).(*models.Agent)
+	return agent
+}
+
+// Authenticates agent requests by their bearer credential
+func (h *Handler) AgentAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			h.respondError(w, http.StatusUnauthorized, "Agent token required", "UNAUTHORIZED")
+			return
+		}
+		agent, err := h.agents.Authenticate(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, store.ErrInvalidAgentToken) {
+				h.respondError(w, http.StatusUnauthorized, "Invalid agent token", "UNAUTHORIZED")
+				return
+			}
+			h.logger.Error("failed to authenticate agent", slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to authenticate agent", "DB_ERROR")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), agentCtxKey{}, agent)))
+	})
+}
+
+func (h *Handler) CreateEnrollmentToken(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateEnrollmentTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if strings.TrimSpace(req.AgentGroup) == "" {
+		h.respondError(w, http.StatusBadRequest, "agent_group is required", "VALIDATION_ERROR")
+		return
+	}
+	ttl := defaultEnrollmentTTL
+	if req.TTLHours > 0 {
+		ttl = time.Duration(min(req.TTLHours, maxEnrollmentTTLHours)) * time.Hour
+	}
+	token, err := h.agents.CreateEnrollmentToken(r.Context(), req.AgentGroup, req.Labels, ttl)
+	if err != nil {
+		h.logger.Error("failed to create enrollment token", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to create enrollment token", "DB_ERROR")
+		return
+	}
+	h.logger.Info("enrollment token created", slog.String("agent_group", req.AgentGroup),
+		slog.Time("expires_at", token.ExpiresAt))
+	h.respondSuccess(w, http.StatusCreated, "Enrollment token created, it is only shown once", token)
+}
+
+func (h *Handler) EnrollAgent(w http.ResponseWriter, r *http.Request) {
+	var req models.EnrollAgentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if req.Token == "" || strings.TrimSpace(req.Name) == "" {
+		h.respondError(w, http.StatusBadRequest, "token and name are required", "VALIDATION_ERROR")
+		return
+	}
+	res, err := h.agents.Enroll(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidEnrollment) {
+			h.logger.Warn("agent enrollment rejected", slog.String("name", req.Name))
+			h.respondError(w, http.StatusUnauthorized, "Invalid or expired enrollment token", "UNAUTHORIZED")
+			return
+		}
+		h.logger.Error("failed to enroll agent", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to enroll agent", "DB_ERROR")
+		return
+	}
+	h.logger.Info("agent enrolled", slog.String("agent_id", res.Agent.ID),
+		slog.String("name", res.Agent.Name),
+		slog.String("agent_group", res.Agent.AgentGroup),
+		slog.String("version", res.Agent.Version))
+	h.respondSuccess(w, http.StatusCreated, "Agent enrolled", res)
+}
+
+func (h *Handler) ListAgents(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	agents, err := h.agents.ListAgents(r.Context(), group)
+	if err != nil {
+		h.logger.Error("failed to fetch agents", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch agents", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", agents)
+}
+
+func (h *Handler) RevokeAgent(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	if err := h.agents.RevokeAgent(r.Context(), agentID); err != nil {
+		if errors.Is(err, store.ErrAgentNotFound) {
+			h.respondError(w, http.StatusNotFound, "Agent not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to revoke agent", slog.String("agent_id", agentID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to revoke agent", "DB_ERROR")
+		return
+	}
+	h.logger.Info("agent revoked", slog.String("agent_id", agentID))
+	h.respondSuccess(w, http.StatusOK, "Agent revoked", map[string]string{"revoked_id": agentID})
+}
+
+func (h *Handler) AgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	agent := agentFromContext(r.Context())
+	var req models.AgentHeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if err := h.agents.Heartbeat(r.Context(), agent.ID, req.Version); err != nil {
+		h.logger.Error("failed to record heartbeat", slog.String("agent_id", agent.ID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to record heartbeat", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", nil)
+}
+
+// Long-poll for the next job the calling agent may run. Holds the request open
+// until a job is claimed or the wait elapses, in which case it answers 204
+func (h *Handler) PollAgentJob(w http.ResponseWriter, r *http.Request) {
+	agent := agentFromContext(r.Context())
+	var req models.AgentPollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	wait := defaultAgentWait
+	if req.WaitSeconds > 0 {
+		wait = min(time.Duration(req.WaitSeconds)*time.Second, maxAgentWait)
+	}
+
+	ctx := r.Context()
+	// Polling doubles as a heartbeat and keeps the reported version current
+	if err := h.agents.Heartbeat(ctx, agent.ID, req.Version); err != nil {
+		h.logger.Warn("failed to record heartbeat", slog.String("agent_id", agent.ID),
+			slog.String("error", err.Error()))
+	}
+	if req.Version != "" {
+		agent.Version = req.Version
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(agentPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := h.agents.ClaimJob(ctx, agent, h.agentPolicy.allows)
+		if errors.Is(err, store.ErrPayloadUnavailable) {
+			// The store already failed that job, the next one may be runnable
+			h.logger.Warn("failed agent job with an unreadable payload", slog.String("agent_id", agent.ID),
+				slog.String("error", err.Error()))
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			h.logger.Error("failed to claim agent job", slog.String("agent_id", agent.ID),
+				slog.String("agent_group", agent.AgentGroup),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to claim job", "DB_ERROR")
+			return
+		}
+		if job != nil {
+			h.logger.Info("agent job claimed", slog.String("job_id", job.ID),
+				slog.String("agent_id", agent.ID),
+				slog.String("agent_group", agent.AgentGroup),
+				logfields.RelayID(job.RelayID))
+			h.respondSuccess(w, http.StatusOK, "", job)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) ReportAgentJobResult(w http.ResponseWriter, r *http.Request) {
+	agent := agentFromContext(r.Context())
+	jobID := chi.URLParam(r, "id")
+	var req models.AgentJobResultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if err := h.agents.CompleteJob(r.Context(), jobID, agent.ID, req); err != nil {
+		if errors.Is(err, store.ErrAgentJobNotFound) {
+			h.logger.Warn("agent reported result for unknown job", slog.String("job_id", jobID),
+				slog.String("agent_id", agent.ID))
+			h.respondError(w, http.StatusNotFound, "Job not found or not claimed by this agent", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to record agent job result", slog.String("job_id", jobID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to record result", "DB_ERROR")
+		return
+	}
+	h.logger.Info("agent job completed", slog.String("job_id", jobID),
+		slog.String("agent_id", agent.ID),
+		slog.Bool("success", req.Success))
+	h.respondSuccess(w, http.StatusOK, "Result recorded", nil)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// In-memory AgentStore: one agent per token, jobs claimed in order
+type fakeAgents struct {
+	mu        sync.Mutex
+	byToken   map[string]*models.Agent
+	jobs      []*models.AgentJob
+	minVer    map[string]string
+	completed map[string]models.AgentJobResultRequest
+}
+
+func newFakeAgents() *fakeAgents {
+	return &fakeAgents{
+		byToken: map[string]*models.Agent{
+			"hat_good": {ID: "agent-1", AgentGroup: "dc1", Version: "1.0.0"},
+		},
+		minVer:    map[string]string{},
+		completed: map[string]models.AgentJobResultRequest{},
+	}
+}
+
+func (f *fakeAgents) CreateEnrollmentToken(_ context.Context, group string, labels map[string]string, ttl time.Duration) (*models.EnrollmentToken, error) {
+	return &models.EnrollmentToken{Token: "het_x", AgentGroup: group, Labels: labels, ExpiresAt: time.Now().Add(ttl)}, nil
+}
+
+func (f *fakeAgents) Enroll(_ context.Context, req models.EnrollAgentRequest) (*models.EnrollAgentResponse, error) {
+	if req.Token != "het_x" {
+		return nil, store.ErrInvalidEnrollment
+	}
+	return &models.EnrollAgentResponse{Agent: models.Agent{ID: "agent-2", Name: req.Name}, AgentToken: "hat_new"}, nil
+}
+
+func (f *fakeAgents) Authenticate(_ context.Context, token string) (*models.Agent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	agent, ok := f.byToken[token]
+	if !ok {
+		return nil, store.ErrInvalidAgentToken
+	}
+	copied := *agent
+	return &copied, nil
+}
+
+func (f *fakeAgents) Heartbeat(context.Context, string, string) error { return nil }
+
+func (f *fakeAgents) ListAgents(context.Context, string) ([]models.Agent, error) {
+	return []models.Agent{}, nil
+}
+
+func (f *fakeAgents) RevokeAgent(_ context.Context, agentID string) error {
+	if agentID != "agent-1" {
+		return store.ErrAgentNotFound
+	}
+	return nil
+}
+
+func (f *fakeAgents) ClaimJob(_ context.Context, agent *models.Agent, allowed store.VersionPolicy) (*models.AgentJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, job := range f.jobs {
+		if job.Status == store.AgentJobPending && job.AgentGroup == agent.AgentGroup &&
+			allowed(job.ActionType, f.minVer[job.ID], agent.Version) {
+			job.Status = store.AgentJobClaimed
+			job.AgentID = agent.ID
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAgents) CompleteJob(_ context.Context, id, agentID string, res models.AgentJobResultRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, job := range f.jobs {
+		if job.ID == id && job.AgentID == agentID && job.Status == store.AgentJobClaimed {
+			f.completed[id] = res
+			return nil
+		}
+	}
+	return store.ErrAgentJobNotFound
+}
+
+func newAgentRouter(agents *fakeAgents, policy AgentPolicy) http.Handler {
+	return NewRouter(NewHandler(Deps{
+		Agents:      agents,
+		AgentPolicy: policy,
+		Logger:      logger.New("hermes-core-test", "test", "error"),
+	}))
+}
+
+func agentRequest(method, path, token, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAgentRoutesRequireCredential(t *testing.T) {
+	r := newAgentRouter(newFakeAgents(), AgentPolicy{})
+	for _, token := range []string{"", "hat_wrong"} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/poll", token, `{}`))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected 401, got %d", token, rr.Code)
+		}
+	}
+}
+
+func TestPollAgentJobClaimsEligibleJob(t *testing.T) {
+	agents := newFakeAgents()
+	agents.jobs = []*models.AgentJob{
+		{ID: "job-other", AgentGroup: "dc2", ActionType: "http_request", Status: store.AgentJobPending},
+		{ID: "job-new", AgentGroup: "dc1", ActionType: "http_request", Status: store.AgentJobPending},
+		{ID: "job-script", AgentGroup: "dc1", ActionType: "script", Status: store.AgentJobPending},
+	}
+	agents.minVer["job-new"] = "2.0.0"
+	r := newAgentRouter(agents, AgentPolicy{SensitiveActions: []string{"script"}, MinSensitiveVersion: "1.0.0"})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/poll", "hat_good", `{"wait_seconds": 1}`))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"id":"job-script"`) {
+		t.Fatalf("Expected job-script (job-new needs 2.0.0), got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Upgrading via the poll makes the gated job eligible
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/poll", "hat_good", `{"version": "2.1.0", "wait_seconds": 1}`))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"id":"job-new"`) {
+		t.Fatalf("Expected job-new after upgrading, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/poll", "hat_good", `{"wait_seconds": 1}`))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 once nothing is left, got %d", rr.Code)
+	}
+}
+
+func TestReportAgentJobResult(t *testing.T) {
+	agents := newFakeAgents()
+	agents.jobs = []*models.AgentJob{{ID: "job-1", AgentGroup: "dc1", Status: store.AgentJobClaimed, AgentID: "agent-1"}}
+	r := newAgentRouter(agents, AgentPolicy{})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/jobs/job-1/result", "hat_good", `{"success": true, "output": "200 ok"}`))
+	if rr.Code != http.StatusOK || agents.completed["job-1"].Output != "200 ok" {
+		t.Errorf("Expected the result recorded, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/jobs/job-2/result", "hat_good", `{"success": true}`))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a job the agent doesn't hold, got %d", rr.Code)
+	}
+}
+
+func TestAgentPolicyAllows(t *testing.T) {
+	p := AgentPolicy{SensitiveActions: []string{"script"}, MinSensitiveVersion: "1.2.0"}
+	for _, tc := range []struct {
+		actionType, minVersion, agentVersion string
+		want                                 bool
+	}{
+		{"http_request", "", "0.1.0", true},
+		{"http_request", "1.0.0", "0.9.0", false},
+		{"script", "", "1.1.9", false},
+		{"script", "", "1.2.0", true},
+		{"script", "2.0.0", "1.5.0", false},
+	} {
+		if got := p.allows(tc.actionType, tc.minVersion, tc.agentVersion); got != tc.want {
+			t.Errorf("allows(%q, %q, %q) = %v, want %v", tc.actionType, tc.minVersion, tc.agentVersion, got, tc.want)
+		}
+	}
+}
+
+func TestAgentManagementNeedsAdminToken(t *testing.T) {
+	closed := newAgentRouter(newFakeAgents(), AgentPolicy{})
+	open := NewRouter(NewHandler(Deps{
+		Agents:     newFakeAgents(),
+		AdminToken: "s3cret",
+		Logger:     logger.New("hermes-core-test", "test", "error"),
+	}))
+	for _, tc := range []struct {
+		router http.Handler
+		token  string
+		want   int
+	}{
+		{closed, "s3cret", http.StatusForbidden},
+		{open, "", http.StatusUnauthorized},
+		{open, "wrong", http.StatusUnauthorized},
+		{open, "s3cret", http.StatusCreated},
+	} {
+		rr := httptest.NewRecorder()
+		tc.router.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/enrollment-tokens", tc.token, `{"agent_group": "dc1"}`))
+		if rr.Code != tc.want {
+			t.Errorf("token %q: expected %d, got %d", tc.token, tc.want, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	open.ServeHTTP(rr, agentRequest(http.MethodPost, "/api/v1/agents/enroll", "", `{"token": "het_x", "name": "edge"}`))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected enrollment to stay open to agents, got %d", rr.Code)
+	}
+}
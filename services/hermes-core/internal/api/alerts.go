@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Bounds on an alert rule's window, the evaluator runs about once a minute
+const (
+	minAlertWindowSeconds = 60
+	maxAlertWindowSeconds = 7 * 24 * 60 * 60
+	maxAlertChannels      = 10
+)
+
+// Alert rule storage used by the handlers, implemented by *store.AlertStore
+type AlertStore interface {
+	CreateAlertRule(ctx context.Context, relayID string, req models.CreateAlertRuleRequest) (*models.AlertRule, error)
+	ListAlertRules(ctx context.Context, relayID string) ([]models.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, relayID, id string) error
+}
+
+func validateAlertRule(relayID string, req *models.CreateAlertRuleRequest) error {
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	switch req.Condition {
+	case store.AlertConditionFailureRate:
+		if req.Threshold <= 0 || req.Threshold >= 1 {
+			return errors.New("threshold must be a failure rate between 0 and 1")
+		}
+	case store.AlertConditionNoExecutions:
+		req.Threshold = 0
+	default:
+		return errors.New("condition must be failure_rate or no_executions")
+	}
+	if req.WindowSeconds < minAlertWindowSeconds || req.WindowSeconds > maxAlertWindowSeconds {
+		return errors.New("window_seconds must be between 60 and 604800")
+	}
+	if req.MinExecutions < 0 {
+		return errors.New("min_executions can't be negative")
+	}
+	if req.MinExecutions == 0 {
+		req.MinExecutions = 1
+	}
+	slices.Sort(req.Channels)
+	req.Channels = slices.Compact(req.Channels)
+	if len(req.Channels) == 0 || len(req.Channels) > maxAlertChannels {
+		return errors.New("channels must list 1 to 10 relay IDs")
+	}
+	for _, channel := range req.Channels {
+		if uuid.Validate(channel) != nil {
+			return errors.New("channels must be relay IDs")
+		}
+		// A failing relay would alert itself, and fail again
+		if channel == relayID {
+			return errors.New("a relay can't be its own alert channel")
+		}
+	}
+	return nil
+}
+
+func (h *Handler) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		return
+	}
+	var req models.CreateAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if err := validateAlertRule(relayID, &req); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	rule, err := h.alerts.CreateAlertRule(r.Context(), relayID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRelayNotFound):
+			h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		case errors.Is(err, store.ErrAlertChannelNotFound):
+			h.respondError(w, http.StatusBadRequest, "Every channel must be an existing relay", "VALIDATION_ERROR")
+		default:
+			h.logger.Error("failed to create alert rule", logfields.RelayID(relayID),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to create alert rule", "DB_ERROR")
+		}
+		return
+	}
+	h.logger.Info("alert rule created", logfields.RelayID(relayID),
+		slog.String("rule_id", rule.ID),
+		slog.String("condition", rule.Condition))
+	h.respondSuccess(w, http.StatusCreated, "Alert rule created", rule)
+}
+
+func (h *Handler) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		return
+	}
+	rules, err := h.alerts.ListAlertRules(r.Context(), relayID)
+	if err != nil {
+		h.logger.Error("failed to fetch alert rules", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch alert rules", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", rules)
+}
+
+func (h *Handler) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	ruleID := chi.URLParam(r, "ruleID")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Alert rule not found", "NOT_FOUND")
+		return
+	}
+	if err := h.alerts.DeleteAlertRule(r.Context(), relayID, ruleID); err != nil {
+		if errors.Is(err, store.ErrAlertRuleNotFound) {
+			h.respondError(w, http.StatusNotFound, "Alert rule not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete alert rule", logfields.RelayID(relayID),
+			slog.String("rule_id", ruleID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete alert rule", "DB_ERROR")
+		return
+	}
+	h.logger.Info("alert rule deleted", logfields.RelayID(relayID), slog.String("rule_id", ruleID))
+	h.respondSuccess(w, http.StatusOK, "Alert rule deleted", nil)
+}
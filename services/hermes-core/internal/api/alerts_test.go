@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeAlerts struct {
+	created []models.CreateAlertRuleRequest
+}
+
+func (f *fakeAlerts) CreateAlertRule(_ context.Context, relayID string, req models.CreateAlertRuleRequest) (*models.AlertRule, error) {
+	f.created = append(f.created, req)
+	return &models.AlertRule{ID: "rule-1", RelayID: relayID, Condition: req.Condition, State: store.AlertStateOK}, nil
+}
+
+func (f *fakeAlerts) ListAlertRules(context.Context, string) ([]models.AlertRule, error) {
+	return nil, nil
+}
+
+func (f *fakeAlerts) DeleteAlertRule(context.Context, string, string) error {
+	return store.ErrAlertRuleNotFound
+}
+
+const (
+	alertRelay   = "6f1c2a3e-8d4b-4c1a-9e2f-0a1b2c3d4e5f"
+	alertChannel = "0b7e9d1c-2f3a-4b5c-8d6e-7f8091a2b3c4"
+)
+
+func TestCreateAlertRuleValidates(t *testing.T) {
+	alerts := &fakeAlerts{}
+	h := NewHandler(Deps{Alerts: alerts, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Post("/relays/{id}/alerts", h.CreateAlertRule)
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"failure rate", `{"name":"errors","condition":"failure_rate","threshold":0.2,"window_seconds":900,"channels":["` + alertChannel + `","` + alertChannel + `"]}`, http.StatusCreated},
+		{"silence", `{"name":"quiet","condition":"no_executions","window_seconds":86400,"min_executions":24,"channels":["` + alertChannel + `"]}`, http.StatusCreated},
+		{"percent threshold", `{"name":"errors","condition":"failure_rate","threshold":20,"window_seconds":900,"channels":["` + alertChannel + `"]}`, http.StatusBadRequest},
+		{"unknown condition", `{"name":"x","condition":"latency","window_seconds":900,"channels":["` + alertChannel + `"]}`, http.StatusBadRequest},
+		{"short window", `{"name":"x","condition":"no_executions","window_seconds":5,"channels":["` + alertChannel + `"]}`, http.StatusBadRequest},
+		{"no channels", `{"name":"x","condition":"no_executions","window_seconds":900}`, http.StatusBadRequest},
+		{"own channel", `{"name":"x","condition":"no_executions","window_seconds":900,"channels":["` + alertRelay + `"]}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/relays/"+alertRelay+"/alerts", bytes.NewBufferString(c.body)))
+		if rr.Code != c.want {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.want, rr.Code, rr.Body.String())
+		}
+	}
+	if len(alerts.created) != 2 {
+		t.Fatalf("Expected 2 rules stored, got %d", len(alerts.created))
+	}
+	if got := alerts.created[0]; len(got.Channels) != 1 || got.MinExecutions != 1 {
+		t.Errorf("Expected deduplicated channels and a default min_executions, got %+v", got)
+	}
+}
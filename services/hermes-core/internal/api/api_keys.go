@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Largest payload POST /relays/{id}/test sends through
+const maxTestPayloadBytes = 1 << 20
+
+// API key storage, implemented by *store.APIKeyStore
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, req models.CreateAPIKeyRequest) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	KeyGrant(ctx context.Context, token string) (string, *auth.KeyGrant, error)
+}
+
+// Creates a key for the workspace. The key is only shown in this response
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if uuid.Validate(req.UserID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		h.respondError(w, http.StatusBadRequest, "name is required", "VALIDATION_ERROR")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		h.respondError(w, http.StatusBadRequest, "At least one scope is required", "VALIDATION_ERROR")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(auth.Scopes, auth.Scope(scope)) {
+			h.respondError(w, http.StatusBadRequest,
+				"Unknown scope "+scope+", expected relays:read, relays:write, relays:trigger or logs:read", "VALIDATION_ERROR")
+			return
+		}
+	}
+	for _, relayID := range req.RelayIDs {
+		relay, err := h.store.GetRelay(r.Context(), relayID)
+		if errors.Is(err, store.ErrRelayNotFound) || (err == nil && relay.UserID != req.UserID) {
+			h.respondError(w, http.StatusBadRequest, "Relay "+relayID+" not found", "VALIDATION_ERROR")
+			return
+		}
+		if err != nil {
+			h.logger.Error("failed to fetch relay", logfields.RelayID(relayID), slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to fetch relay", "DB_ERROR")
+			return
+		}
+	}
+	if req.TTLHours < 0 {
+		h.respondError(w, http.StatusBadRequest, "ttl_hours must not be negative", "VALIDATION_ERROR")
+		return
+	}
+	key, err := h.apiKeys.CreateAPIKey(r.Context(), req)
+	if err != nil {
+		h.logger.Error("failed to create api key", logfields.UserID(req.UserID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to create API key", "DB_ERROR")
+		return
+	}
+	h.logger.Info("api key created", logfields.UserID(req.UserID),
+		slog.String("api_key_id", key.ID),
+		slog.Any("scopes", key.Scopes))
+	h.respondSuccess(w, http.StatusCreated, "API key created, store it now as it won't be shown again", key)
+}
+
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	keys, err := h.apiKeys.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch api keys", logfields.UserID(userID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch API keys", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", keys)
+}
+
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.apiKeys.DeleteAPIKey(r.Context(), userID, id); err != nil {
+		if errors.Is(err, store.ErrAPIKeyNotFound) {
+			h.respondError(w, http.StatusNotFound, "API key not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete api key", logfields.UserID(userID),
+			slog.String("api_key_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete API key", "DB_ERROR")
+		return
+	}
+	h.logger.Info("api key deleted", logfields.UserID(userID), slog.String("api_key_id", id))
+	h.respondSuccess(w, http.StatusOK, "API key deleted", map[string]string{"deleted_id": id})
+}
+
+// Sends the request body through the relay as a new event, as if its webhook
+// had been called. Lets CI check a relay end to end
+func (h *Handler) TestRelay(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTestPayloadBytes+1))
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Failed to read body", "INVALID_BODY")
+		return
+	}
+	if len(body) > maxTestPayloadBytes {
+		h.respondError(w, http.StatusRequestEntityTooLarge, "Payload must be at most 1MB", "VALIDATION_ERROR")
+		return
+	}
+	if len(body) == 0 {
+		body = []byte(`{}`)
+	}
+	if !json.Valid(body) {
+		h.respondError(w, http.StatusBadRequest, "Payload must be JSON", "INVALID_JSON")
+		return
+	}
+	eventID := uuid.NewString()
+	if err := h.publisher.PublishEvent(relayID, eventID, "", body); err != nil {
+		h.logger.Error("failed to publish test event", logfields.RelayID(relayID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusBadGateway, "Failed to publish event", "QUEUE_ERROR")
+		return
+	}
+	h.logger.Info("test event sent", logfields.RelayID(relayID), logfields.EventID(eventID))
+	h.respondSuccess(w, http.StatusAccepted, "Test event sent", map[string]string{
+		"relay_id": relayID,
+		"event_id": eventID,
+	})
+}
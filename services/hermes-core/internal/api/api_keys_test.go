@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeAPIKeyStore struct {
+	userID string
+	grants map[string]*auth.KeyGrant
+}
+
+func (f *fakeAPIKeyStore) CreateAPIKey(context.Context, models.CreateAPIKeyRequest) (*models.APIKey, error) {
+	return nil, nil
+}
+
+func (f *fakeAPIKeyStore) ListAPIKeys(context.Context, string) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (f *fakeAPIKeyStore) DeleteAPIKey(context.Context, string, string) error { return nil }
+
+func (f *fakeAPIKeyStore) KeyGrant(_ context.Context, token string) (string, *auth.KeyGrant, error) {
+	if grant, ok := f.grants[token]; ok {
+		return f.userID, grant, nil
+	}
+	return "", nil, store.ErrInvalidAPIKey
+}
+
+func TestScopedAPIKeys(t *testing.T) {
+	const owner = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	keys := &fakeAPIKeyStore{userID: owner, grants: map[string]*auth.KeyGrant{
+		"hak_ci": {Scopes: []auth.Scope{auth.ScopeRelaysTrigger, auth.ScopeLogsRead}, RelayIDs: []string{"r1"}},
+	}}
+	pub := &fakePublisher{}
+	h := NewHandler(Deps{APIKeys: keys, Publisher: pub, Logger: logger.New("hermes-core-test", "test", "error")})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r := chi.NewRouter()
+	r.Use(h.UserAuth, h.Workspace)
+	r.With(h.Require(auth.PermWrite, auth.ScopeRelaysTrigger)).Post("/relays/{id}/test", h.TestRelay)
+	r.With(h.Require(auth.PermRead, auth.ScopeLogsRead)).Get("/relays/{id}/logs", ok)
+	r.With(h.Require(auth.PermWrite, auth.ScopeRelaysWrite)).Put("/relays/{id}", ok)
+	r.With(h.Require(auth.PermRead, "")).Get("/secrets", ok)
+
+	call := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := call(http.MethodPost, "/relays/r1/test", "hak_ci", `{"order":1}`); code != http.StatusAccepted {
+		t.Errorf("Expected the key to trigger a test run, got %d", code)
+	}
+	if len(pub.eventIDs) != 1 {
+		t.Errorf("Expected one test event published, got %d", len(pub.eventIDs))
+	}
+	if code := call(http.MethodPost, "/relays/r1/test", "hak_ci", `not json`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a payload that isn't JSON, got %d", code)
+	}
+	if code := call(http.MethodGet, "/relays/r1/logs", "hak_ci", ""); code != http.StatusOK {
+		t.Errorf("Expected the key to read logs, got %d", code)
+	}
+	if code := call(http.MethodPut, "/relays/r1", "hak_ci", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 updating a relay without relays:write, got %d", code)
+	}
+	if code := call(http.MethodGet, "/secrets", "hak_ci", ""); code != http.StatusForbidden {
+		t.Errorf("Expected keys kept off routes without a scope, got %d", code)
+	}
+	if code := call(http.MethodGet, "/relays/r1/logs", "hak_revoked", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown key, got %d", code)
+	}
+	// Without JWTs or sign-on set up, requests without a key are refused
+	if rr := serve(r, http.MethodGet, "/secrets"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key, got %d", rr.Code)
+	}
+}
+
+func TestRelayOwnerLimitsKeysToTheirRelays(t *testing.T) {
+	h := NewHandler(Deps{Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx := auth.WithKeyGrant(auth.WithUser(req.Context(), "u1"),
+				&auth.KeyGrant{Scopes: []auth.Scope{auth.ScopeRelaysRead}, RelayIDs: []string{"r1"}})
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	})
+	r.With(h.RelayOwner).Get("/relays/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	if rr := serve(r, http.MethodGet, "/relays/r2"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a relay outside the key's list, got %d", rr.Code)
+	}
+}
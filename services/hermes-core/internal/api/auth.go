@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Requires a valid bearer token on user routes, a JWT, a session from
+// signing in or an API key, and records who sent it. Whatever isn't set up
+// is refused, so with neither JWTs nor sign-on only API keys get in. Only
+// AuthDisabled lets requests without a key through, with user_id taken as sent
+func (h *Handler) UserAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		isKey := strings.HasPrefix(token, store.APIKeyPrefix) && h.apiKeys != nil
+		if h.authDisabled && !isKey {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok || token == "" {
+			h.respondError(w, http.StatusUnauthorized, "Bearer token required", "UNAUTHORIZED")
+			return
+		}
+		ctx := r.Context()
+		var userID string
+		var err error
+		switch {
+		case isKey:
+			var grant *auth.KeyGrant
+			userID, grant, err = h.apiKeys.KeyGrant(ctx, token)
+			if err != nil && !errors.Is(err, store.ErrInvalidAPIKey) {
+				h.logger.Error("failed to look up api key", slog.String("error", err.Error()))
+				h.respondError(w, http.StatusInternalServerError, "Failed to authenticate", "DB_ERROR")
+				return
+			}
+			ctx = auth.WithKeyGrant(ctx, grant)
+		case strings.HasPrefix(token, store.SessionTokenPrefix) && h.sessions != nil:
+			userID, err = h.sessions.SessionUser(r.Context(), token)
+			if err != nil && !errors.Is(err, store.ErrInvalidSession) {
+				h.logger.Error("failed to look up session", slog.String("error", err.Error()))
+				h.respondError(w, http.StatusInternalServerError, "Failed to authenticate", "DB_ERROR")
+				return
+			}
+		case h.auth != nil:
+			userID, err = h.auth.Verify(r.Context(), token)
+			if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+				h.logger.Error("failed to verify bearer token", slog.String("error", err.Error()))
+				h.respondError(w, http.StatusServiceUnavailable, "Token verification unavailable", "AUTH_UNAVAILABLE")
+				return
+			}
+		default:
+			err = auth.ErrInvalidToken
+		}
+		if err != nil {
+			h.logger.Debug("rejected bearer token", slog.String("error", err.Error()))
+			h.respondError(w, http.StatusUnauthorized, "Invalid or expired token", "UNAUTHORIZED")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(ctx, userID)))
+	})
+}
+
+// The user a request acts for: the workspace the caller authenticated into,
+// their own unless they picked another, else the user_id the client sent. A
+// sent user_id must match. ok is false once an error response was written
+func (h *Handler) callerID(w http.ResponseWriter, r *http.Request, sent string) (string, bool) {
+	userID, authenticated := auth.UserFrom(r.Context())
+	if !authenticated {
+		return sent, true
+	}
+	if workspaceID, _, ok := auth.WorkspaceFrom(r.Context()); ok {
+		userID = workspaceID
+	}
+	if sent != "" && sent != userID {
+		h.respondError(w, http.StatusForbidden, "user_id does not match the authenticated user", "FORBIDDEN")
+		return "", false
+	}
+	return userID, true
+}
+
+// Hides relays outside the caller's workspace, as if they didn't exist
+func (h *Handler) RelayOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, authenticated := auth.UserFrom(r.Context())
+		if !authenticated {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if workspaceID, _, ok := auth.WorkspaceFrom(r.Context()); ok {
+			userID = workspaceID
+		}
+		relayID := chi.URLParam(r, "id")
+		if grant, ok := auth.KeyGrantFrom(r.Context()); ok && !grant.AllowsRelay(relayID) {
+			h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
+			return
+		}
+		relay, err := h.store.GetRelay(r.Context(), relayID)
+		if errors.Is(err, store.ErrRelayNotFound) || (err == nil && relay.UserID != userID) {
+			h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
+			return
+		}
+		if err != nil {
+			h.logger.Error("failed to fetch relay", logfields.RelayID(relayID), slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to fetch relay", "DB_ERROR")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// A verifier trusting one RSA key, and a func issuing tokens for a user
+func testVerifier(t *testing.T) (*auth.Verifier, func(userID string) string) {
+	t.Helper()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	b64 := base64.RawURLEncoding.EncodeToString
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())},
+		}})
+	}))
+	t.Cleanup(srv.Close)
+	issue := func(userID string) string {
+		claims, _ := json.Marshal(map[string]any{"iss": "https://id.example", "sub": userID,
+			"exp": time.Now().Add(time.Hour).Unix()})
+		input := b64([]byte(`{"alg":"RS256","kid":"k1"}`)) + "." + b64(claims)
+		digest := sha256.Sum256([]byte(input))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return input + "." + b64(signature)
+	}
+	return auth.NewVerifier(auth.Config{Issuer: "https://id.example", JWKSURL: srv.URL}), issue
+}
+
+func TestUserAuthSetsCaller(t *testing.T) {
+	const userID = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	verifier, issue := testVerifier(t)
+	usage := &fakeUsageStore{}
+	h := NewHandler(Deps{Usage: usage, Auth: verifier, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.With(h.UserAuth).Get("/usage", h.GetUsage)
+
+	call := func(query, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/usage"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := call("?user_id="+userID, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", code)
+	}
+	if code := call("", "not.a.token"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad token, got %d", code)
+	}
+	// The token says who the caller is, user_id can be left out
+	if code := call("", issue(userID)); code != http.StatusOK {
+		t.Errorf("Expected 200 for the token's user, got %d", code)
+	}
+	if code := call("?user_id=0b1c2d3e-1b2d-4c3e-8f9a-5f0c6a4e4f5a", issue(userID)); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's usage, got %d", code)
+	}
+}
+
+func TestUserAuthFailsClosedWithoutAuthSetUp(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	for _, tc := range []struct {
+		disabled bool
+		want     int
+	}{{false, http.StatusUnauthorized}, {true, http.StatusOK}} {
+		h := NewHandler(Deps{AuthDisabled: tc.disabled, Logger: logger.New("hermes-core-test", "test", "error")})
+		r := chi.NewRouter()
+		r.With(h.UserAuth).Get("/relays", ok)
+		for _, token := range []string{"", "anything"} {
+			req := httptest.NewRequest(http.MethodGet, "/relays?user_id=u1", nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Errorf("AuthDisabled %v, token %q: expected %d, got %d", tc.disabled, token, tc.want, rr.Code)
+			}
+		}
+	}
+}
+
+func TestCallerIDTrustsSentUserWithoutAuth(t *testing.T) {
+	h := NewHandler(Deps{Logger: logger.New("hermes-core-test", "test", "error")})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if userID, ok := h.callerID(httptest.NewRecorder(), req, "u1"); !ok || userID != "u1" {
+		t.Errorf("Expected the sent user, got %q", userID)
+	}
+	req = req.WithContext(auth.WithUser(context.Background(), "u2"))
+	if userID, ok := h.callerID(httptest.NewRecorder(), req, ""); !ok || userID != "u2" {
+		t.Errorf("Expected the authenticated user, got %q", userID)
+	}
+}
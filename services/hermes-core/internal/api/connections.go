@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/connections"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Connection storage, implemented by *store.ConnectionStore
+type ConnectionStore interface {
+	ListConnections(ctx context.Context, userID string) ([]models.Connection, error)
+	DeleteConnection(ctx context.Context, userID, id string) error
+}
+
+// Runs the OAuth flows, implemented by *connections.Manager
+type ConnectionManager interface {
+	AuthURL(ctx context.Context, userID, provider, name string) (string, error)
+	Complete(ctx context.Context, state, code string) (*models.Connection, error)
+}
+
+// Connecting accounts at OAuth providers
+type OAuthConnections struct {
+	Manager ConnectionManager
+	// The callback redirects here as ?connection=<id>, or ?error=<code> when
+	// connecting failed. Empty answers with JSON
+	DashboardURL string
+}
+
+// Starts connecting an account, answering with the URL to send the user's
+// browser to
+func (h *Handler) AuthorizeConnection(w http.ResponseWriter, r *http.Request) {
+	if h.oauth == nil {
+		h.respondError(w, http.StatusNotFound, "OAuth connections are not configured", "NOT_FOUND")
+		return
+	}
+	provider := chi.URLParam(r, "provider")
+	var req models.AuthorizeConnectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if uuid.Validate(req.UserID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		req.Name = provider
+	}
+	target, err := h.oauth.Manager.AuthURL(r.Context(), req.UserID, provider, req.Name)
+	if errors.Is(err, connections.ErrUnknownProvider) {
+		h.respondError(w, http.StatusNotFound, "Unknown provider "+provider, "NOT_FOUND")
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to start connecting", logfields.UserID(req.UserID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to start connecting", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", map[string]string{"authorize_url": target})
+}
+
+// Where providers send the browser back. Trades the code for tokens and
+// saves the connection
+func (h *Handler) ConnectionCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oauth == nil {
+		h.respondError(w, http.StatusNotFound, "OAuth connections are not configured", "NOT_FOUND")
+		return
+	}
+	q := r.URL.Query()
+	if providerErr := q.Get("error"); providerErr != "" {
+		h.logger.Warn("provider refused connection", slog.String("error", providerErr),
+			slog.String("description", q.Get("error_description")))
+		h.connectionFailed(w, r, http.StatusUnauthorized, "Connection refused by the provider: "+providerErr, "CONNECTION_FAILED")
+		return
+	}
+	c, err := h.oauth.Manager.Complete(r.Context(), q.Get("state"), q.Get("code"))
+	switch {
+	case errors.Is(err, connections.ErrAuthorizationFailed):
+		h.logger.Warn("connection failed", slog.String("error", err.Error()))
+		h.connectionFailed(w, r, http.StatusUnauthorized, "Connecting failed, start again", "CONNECTION_FAILED")
+		return
+	case errors.Is(err, secrets.ErrNoKey):
+		h.connectionFailed(w, r, http.StatusServiceUnavailable,
+			"Connections are disabled, set SECRETS_KEY or SECRETS_KMS_KEY_ID", "SECRETS_DISABLED")
+		return
+	case err != nil:
+		h.logger.Error("failed to finish connecting", slog.String("error", err.Error()))
+		h.connectionFailed(w, r, http.StatusBadGateway, "Failed to finish connecting", "PROVIDER_UNAVAILABLE")
+		return
+	}
+	h.logger.Info("account connected", logfields.UserID(c.UserID),
+		slog.String("connection_id", c.ID),
+		slog.String("provider", c.Provider))
+	if h.oauth.DashboardURL == "" {
+		h.respondSuccess(w, http.StatusCreated, "Connected", c)
+		return
+	}
+	http.Redirect(w, r, h.oauth.DashboardURL+"?"+url.Values{"connection": {c.ID}}.Encode(), http.StatusFound)
+}
+
+func (h *Handler) connectionFailed(w http.ResponseWriter, r *http.Request, status int, message, code string) {
+	if h.oauth.DashboardURL == "" {
+		h.respondError(w, status, message, code)
+		return
+	}
+	http.Redirect(w, r, h.oauth.DashboardURL+"?"+url.Values{"error": {code}}.Encode(), http.StatusFound)
+}
+
+func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	list, err := h.connections.ListConnections(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch connections", logfields.UserID(userID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch connections", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", list)
+}
+
+func (h *Handler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.connections.DeleteConnection(r.Context(), userID, id); err != nil {
+		if errors.Is(err, store.ErrConnectionNotFound) {
+			h.respondError(w, http.StatusNotFound, "Connection not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete connection", logfields.UserID(userID),
+			slog.String("connection_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete connection", "DB_ERROR")
+		return
+	}
+	h.logger.Info("connection deleted", logfields.UserID(userID), slog.String("connection_id", id))
+	h.respondSuccess(w, http.StatusOK, "Connection deleted", map[string]string{"deleted_id": id})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/connections"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeConnectionManager struct{}
+
+func (fakeConnectionManager) AuthURL(context.Context, string, string, string) (string, error) {
+	return "https://provider.example/authorize", nil
+}
+
+func (fakeConnectionManager) Complete(_ context.Context, state, _ string) (*models.Connection, error) {
+	if state != "good" {
+		return nil, fmt.Errorf("%w: unknown state", connections.ErrAuthorizationFailed)
+	}
+	return &models.Connection{ID: "c1", UserID: "u1", Provider: "slack"}, nil
+}
+
+func TestConnectionCallback(t *testing.T) {
+	oauth := &OAuthConnections{Manager: fakeConnectionManager{}, DashboardURL: "https://app.example/connections"}
+	h := NewHandler(Deps{OAuth: oauth, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Get("/callback", h.ConnectionCallback)
+
+	rr := serve(r, http.MethodGet, "/callback?state=good&code=x")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://app.example/connections?connection=c1" {
+		t.Errorf("Expected a redirect to the dashboard with the connection, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	rr = serve(r, http.MethodGet, "/callback?state=forged&code=x")
+	if rr.Header().Get("Location") != "https://app.example/connections?error=CONNECTION_FAILED" {
+		t.Errorf("Expected the dashboard told connecting failed, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	oauth.DashboardURL = ""
+	if rr := serve(r, http.MethodGet, "/callback?error=access_denied"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when the provider refused, got %d", rr.Code)
+	}
+	if rr := serve(r, http.MethodGet, "/callback?state=good&code=x"); rr.Code != http.StatusCreated {
+		t.Errorf("Expected 201 without a dashboard, got %d", rr.Code)
+	}
+}
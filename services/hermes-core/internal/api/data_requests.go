@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Data export and deletion requests, implemented by *store.DataRequestStore.
+// An empty userID reaches everyone's requests
+type DataRequestStore interface {
+	CreateDataRequest(ctx context.Context, userID, kind string) (*models.DataRequest, error)
+	ListDataRequests(ctx context.Context, userID string) ([]models.DataRequest, error)
+	GetDataRequest(ctx context.Context, userID, id string) (*models.DataRequest, error)
+	DataRequestExport(ctx context.Context, userID, id string) ([]byte, error)
+}
+
+// Queues an export or deletion of everything stored for the workspace. Only
+// its owner may delete it
+func (h *Handler) CreateDataRequest(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateDataRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if req.Kind == store.DataRequestDelete {
+		if _, role, ok := auth.WorkspaceFrom(r.Context()); ok && role != auth.RoleOwner {
+			h.respondError(w, http.StatusForbidden, "Only the workspace owner can delete it", "FORBIDDEN")
+			return
+		}
+	}
+	h.createDataRequest(w, r, req)
+}
+
+func (h *Handler) ListDataRequests(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	h.listDataRequests(w, r, userID)
+}
+
+// A request with its report once completed
+func (h *Handler) GetDataRequest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	h.getDataRequest(w, r, userID)
+}
+
+func (h *Handler) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	h.downloadDataExport(w, r, userID)
+}
+
+// Queues a request for any user, for operators handling one that reached
+// them some other way
+func (h *Handler) CreateAdminDataRequest(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateDataRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	h.createDataRequest(w, r, req)
+}
+
+// Every user's requests, or one user's with ?user_id=
+func (h *Handler) ListAdminDataRequests(w http.ResponseWriter, r *http.Request) {
+	h.listDataRequests(w, r, r.URL.Query().Get("user_id"))
+}
+
+func (h *Handler) GetAdminDataRequest(w http.ResponseWriter, r *http.Request) {
+	h.getDataRequest(w, r, "")
+}
+
+func (h *Handler) DownloadAdminDataExport(w http.ResponseWriter, r *http.Request) {
+	h.downloadDataExport(w, r, "")
+}
+
+func (h *Handler) createDataRequest(w http.ResponseWriter, r *http.Request, req models.CreateDataRequestRequest) {
+	if uuid.Validate(req.UserID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	if req.Kind != store.DataRequestExport && req.Kind != store.DataRequestDelete {
+		h.respondError(w, http.StatusBadRequest, "kind must be export or delete", "VALIDATION_ERROR")
+		return
+	}
+	dr, err := h.dataRequests.CreateDataRequest(r.Context(), req.UserID, req.Kind)
+	if err != nil {
+		if errors.Is(err, store.ErrDataRequestOpen) {
+			h.respondError(w, http.StatusConflict, "A "+req.Kind+" request is already open", "REQUEST_OPEN")
+			return
+		}
+		h.logger.Error("failed to create data request", logfields.UserID(req.UserID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to create data request", "DB_ERROR")
+		return
+	}
+	h.logger.Info("data request created", logfields.UserID(req.UserID),
+		slog.String("request_id", dr.ID),
+		slog.String("kind", dr.Kind))
+	h.respondSuccess(w, http.StatusAccepted, "Data request queued", dr)
+}
+
+func (h *Handler) listDataRequests(w http.ResponseWriter, r *http.Request, userID string) {
+	list, err := h.dataRequests.ListDataRequests(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch data requests", logfields.UserID(userID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch data requests", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", list)
+}
+
+func (h *Handler) getDataRequest(w http.ResponseWriter, r *http.Request, userID string) {
+	id := chi.URLParam(r, "id")
+	dr, err := h.dataRequests.GetDataRequest(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, store.ErrDataRequestNotFound) {
+			h.respondError(w, http.StatusNotFound, "Data request not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to fetch data request", slog.String("request_id", id), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch data request", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", dr)
+}
+
+// Serves a completed export as gzipped JSON
+func (h *Handler) downloadDataExport(w http.ResponseWriter, r *http.Request, userID string) {
+	id := chi.URLParam(r, "id")
+	export, err := h.dataRequests.DataRequestExport(r.Context(), userID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrDataRequestNotFound):
+			h.respondError(w, http.StatusNotFound, "Data request not found", "NOT_FOUND")
+		case errors.Is(err, store.ErrExportUnavailable):
+			h.respondError(w, http.StatusNotFound, "Export isn't ready or has expired", "NOT_FOUND")
+		default:
+			h.logger.Error("failed to fetch export", slog.String("request_id", id), slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to fetch export", "DB_ERROR")
+		}
+		return
+	}
+	h.logger.Info("data export downloaded", logfields.UserID(userID), slog.String("request_id", id))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="hermes-export-`+id+`.json.gz"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(export)
+}
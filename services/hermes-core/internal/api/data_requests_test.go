@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const dataOwner = "7b0d5f0e-8c1a-4c9e-9f3e-2f6d1b9a0c11"
+
+type fakeDataRequests struct {
+	open map[string]bool
+}
+
+func (f *fakeDataRequests) CreateDataRequest(_ context.Context, userID, kind string) (*models.DataRequest, error) {
+	if f.open[kind] {
+		return nil, store.ErrDataRequestOpen
+	}
+	f.open[kind] = true
+	return &models.DataRequest{ID: "dr-" + kind, UserID: userID, Kind: kind, Status: store.DataRequestPending}, nil
+}
+
+func (f *fakeDataRequests) ListDataRequests(context.Context, string) ([]models.DataRequest, error) {
+	return nil, nil
+}
+
+func (f *fakeDataRequests) GetDataRequest(_ context.Context, _, id string) (*models.DataRequest, error) {
+	return nil, store.ErrDataRequestNotFound
+}
+
+func (f *fakeDataRequests) DataRequestExport(_ context.Context, _, id string) ([]byte, error) {
+	if id != "dr-export" {
+		return nil, store.ErrExportUnavailable
+	}
+	return []byte("gzipped"), nil
+}
+
+func TestDataRequests(t *testing.T) {
+	h := NewHandler(Deps{DataRequests: &fakeDataRequests{open: map[string]bool{}},
+		Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx := auth.WithUser(req.Context(), dataOwner)
+			role := auth.Role(req.Header.Get("X-Test-Role"))
+			next.ServeHTTP(w, req.WithContext(auth.WithWorkspace(ctx, dataOwner, role)))
+		})
+	})
+	r.Post("/data-requests", h.CreateDataRequest)
+	r.Get("/data-requests/{id}/export", h.DownloadDataExport)
+
+	cases := []struct {
+		name string
+		role auth.Role
+		body string
+		want int
+	}{
+		{"admin exports", auth.RoleAdmin, `{"kind":"export"}`, http.StatusAccepted},
+		{"export already open", auth.RoleOwner, `{"kind":"export"}`, http.StatusConflict},
+		{"admin deletes", auth.RoleAdmin, `{"kind":"delete"}`, http.StatusForbidden},
+		{"owner deletes", auth.RoleOwner, `{"kind":"delete"}`, http.StatusAccepted},
+		{"unknown kind", auth.RoleOwner, `{"kind":"anonymize"}`, http.StatusBadRequest},
+		{"other user", auth.RoleOwner, `{"user_id":"someone-else","kind":"export"}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/data-requests", bytes.NewBufferString(c.body))
+		req.Header.Set("X-Test-Role", string(c.role))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != c.want {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := serve(r, http.MethodGet, "/data-requests/dr-export/export")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/gzip" || rr.Body.String() != "gzipped" {
+		t.Errorf("Expected the export downloaded, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr := serve(r, http.MethodGet, "/data-requests/dr-delete/export"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an export, got %d", rr.Code)
+	}
+}
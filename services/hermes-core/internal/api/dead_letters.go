@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Dead letter storage used by the handlers, implemented by *store.DeadLetterStore.
+// Every call but ReleaseClaim is limited to the relays userID owns
+type DeadLetterStore interface {
+	List(ctx context.Context, userID, relayID, status string, limit int) ([]models.DeadLetter, error)
+	Get(ctx context.Context, userID, id string) (*models.DeadLetter, error)
+	ClaimForRequeue(ctx context.Context, userID, id string) (*models.DeadLetter, error)
+	ReleaseClaim(ctx context.Context, id string) error
+	Delete(ctx context.Context, userID, id string) error
+	Purge(ctx context.Context, userID, relayID string) (int64, error)
+}
+
+// The workspace whose dead letters the request may see. ok is false once an
+// error response was written
+func (h *Handler) deadLetterOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return "", false
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return "", false
+	}
+	return userID, true
+}
+
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.deadLetterOwner(w, r)
+	if !ok {
+		return
+	}
+	relayID := r.URL.Query().Get("relay_id")
+	status := r.URL.Query().Get("status")
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, 200)
+		}
+	}
+	letters, err := h.deadLetters.List(r.Context(), userID, relayID, status, limit)
+	if err != nil {
+		h.logger.Error("failed to fetch dead letters", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch dead letters", "DB_ERROR")
+		return
+	}
+	h.logger.Info("fetched dead letters", logfields.RelayID(relayID), slog.Int("count", len(letters)))
+	h.respondSuccess(w, http.StatusOK, "", letters)
+}
+
+func (h *Handler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.deadLetterOwner(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	dl, err := h.deadLetters.Get(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, store.ErrDeadLetterNotFound) {
+			h.respondError(w, http.StatusNotFound, "Dead letter not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to fetch dead letter", slog.String("dead_letter_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch dead letter", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", dl)
+}
+
+func (h *Handler) RequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.deadLetterOwner(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	// Claim first so two concurrent requeues can't both publish the event
+	dl, err := h.deadLetters.ClaimForRequeue(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, store.ErrDeadLetterNotFound) {
+			h.respondError(w, http.StatusNotFound, "Dead letter not found", "NOT_FOUND")
+			return
+		}
+		if errors.Is(err, store.ErrAlreadyRequeued) {
+			h.respondError(w, http.StatusConflict, "Dead letter was already requeued", "ALREADY_REQUEUED")
+			return
+		}
+		h.logger.Error("failed to claim dead letter", slog.String("dead_letter_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch dead letter", "DB_ERROR")
+		return
+	}
+	if dl.PayloadUnavailable {
+		if err := h.deadLetters.ReleaseClaim(r.Context(), id); err != nil {
+			h.logger.Error("failed to release dead letter claim", slog.String("dead_letter_id", id),
+				slog.String("error", err.Error()))
+		}
+		h.respondError(w, http.StatusConflict, "Dead letter payload is sealed and can't be opened", "PAYLOAD_UNAVAILABLE")
+		return
+	}
+	if err := h.publisher.PublishEvent(dl.RelayID, dl.EventID, dl.Traceparent, dl.Payload); err != nil {
+		h.logger.Error("failed to requeue dead letter", slog.String("dead_letter_id", id),
+			logfields.RelayID(dl.RelayID),
+			slog.String("error", err.Error()))
+		if releaseErr := h.deadLetters.ReleaseClaim(r.Context(), id); releaseErr != nil {
+			h.logger.Error("failed to release dead letter claim", slog.String("dead_letter_id", id),
+				slog.String("error", releaseErr.Error()))
+		}
+		h.respondError(w, http.StatusBadGateway, "Failed to publish event", "QUEUE_ERROR")
+		return
+	}
+	h.logger.Info("dead letter requeued", slog.String("dead_letter_id", id),
+		logfields.RelayID(dl.RelayID),
+		logfields.EventID(dl.EventID))
+	h.respondSuccess(w, http.StatusAccepted, "Event requeued", map[string]string{
+		"dead_letter_id": id,
+		"event_id":       dl.EventID,
+	})
+}
+
+func (h *Handler) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.deadLetterOwner(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := h.deadLetters.Delete(r.Context(), userID, id); err != nil {
+		if errors.Is(err, store.ErrDeadLetterNotFound) {
+			h.respondError(w, http.StatusNotFound, "Dead letter not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete dead letter", slog.String("dead_letter_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete dead letter", "DB_ERROR")
+		return
+	}
+	h.logger.Info("dead letter deleted", slog.String("dead_letter_id", id))
+	h.respondSuccess(w, http.StatusOK, "Dead letter deleted", map[string]string{"deleted_id": id})
+}
+
+func (h *Handler) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.deadLetterOwner(w, r)
+	if !ok {
+		return
+	}
+	relayID := r.URL.Query().Get("relay_id")
+	purged, err := h.deadLetters.Purge(r.Context(), userID, relayID)
+	if err != nil {
+		h.logger.Error("failed to purge dead letters", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to purge dead letters", "DB_ERROR")
+		return
+	}
+	h.logger.Info("dead letters purged", logfields.RelayID(relayID), slog.Int64("count", purged))
+	h.respondSuccess(w, http.StatusOK, "Dead letters purged", map[string]int64{"purged": purged})
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Owners of the relays seedDeadLetters fills
+const (
+	deadLetterOwnerA = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	deadLetterOwnerB = "6a1d7b5f-2c3e-4d4f-9a0b-1c2d3e4f5a6b"
+)
+
+// In-memory DeadLetterStore with the same claim and scoping semantics as Postgres
+type fakeDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]*models.DeadLetter
+	// Relay ID to the workspace owning it
+	owners map[string]string
+}
+
+// The dead letter when userID's workspace owns its relay
+func (f *fakeDeadLetters) owned(userID, id string) (*models.DeadLetter, bool) {
+	dl, ok := f.letters[id]
+	if !ok || f.owners[dl.RelayID] != userID {
+		return nil, false
+	}
+	return dl, true
+}
+
+func (f *fakeDeadLetters) List(_ context.Context, userID, relayID, status string, _ int) ([]models.DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []models.DeadLetter{}
+	for _, dl := range f.letters {
+		if f.owners[dl.RelayID] == userID && (relayID == "" || dl.RelayID == relayID) && (status == "" || dl.Status == status) {
+			out = append(out, *dl)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDeadLetters) Get(_ context.Context, userID, id string) (*models.DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dl, ok := f.owned(userID, id)
+	if !ok {
+		return nil, store.ErrDeadLetterNotFound
+	}
+	copied := *dl
+	return &copied, nil
+}
+
+func (f *fakeDeadLetters) ClaimForRequeue(_ context.Context, userID, id string) (*models.DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dl, ok := f.owned(userID, id)
+	if !ok {
+		return nil, store.ErrDeadLetterNotFound
+	}
+	if dl.Status != store.DeadLetterStatusDead {
+		return nil, store.ErrAlreadyRequeued
+	}
+	dl.Status = store.DeadLetterStatusRequeued
+	copied := *dl
+	return &copied, nil
+}
+
+func (f *fakeDeadLetters) ReleaseClaim(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if dl, ok := f.letters[id]; ok {
+		dl.Status = store.DeadLetterStatusDead
+	}
+	return nil
+}
+
+func (f *fakeDeadLetters) Delete(_ context.Context, userID, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.owned(userID, id); !ok {
+		return store.ErrDeadLetterNotFound
+	}
+	delete(f.letters, id)
+	return nil
+}
+
+func (f *fakeDeadLetters) Purge(_ context.Context, userID, relayID string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for id, dl := range f.letters {
+		if f.owners[dl.RelayID] == userID && (relayID == "" || dl.RelayID == relayID) {
+			delete(f.letters, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type fakePublisher struct {
+	mu           sync.Mutex
+	fail         bool
+	eventIDs     []string
+	traceparents []string
+}
+
+func (p *fakePublisher) PublishEvent(relayID, eventID, traceparent string, payload json.RawMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail {
+		return errors.New("broker down")
+	}
+	p.eventIDs = append(p.eventIDs, eventID)
+	p.traceparents = append(p.traceparents, traceparent)
+	return nil
+}
+
+// Routes as hermes-core mounts them. Without auth set up callers name their
+// workspace with user_id
+func newDeadLetterRouter(letters *fakeDeadLetters, pub *fakePublisher) http.Handler {
+	return deadLetterRoutes(NewHandler(Deps{
+		DeadLetters:  letters,
+		Publisher:    pub,
+		AuthDisabled: true,
+		Logger:       logger.New("hermes-core-test", "test", "error"),
+	}))
+}
+
+func deadLetterRoutes(h *Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(h.UserAuth, h.Workspace)
+	r.Get("/dead-letters", h.ListDeadLetters)
+	r.Delete("/dead-letters", h.PurgeDeadLetters)
+	r.Get("/dead-letters/{id}", h.GetDeadLetter)
+	r.Post("/dead-letters/{id}/requeue", h.RequeueDeadLetter)
+	r.Delete("/dead-letters/{id}", h.DeleteDeadLetter)
+	return r
+}
+
+func seedDeadLetters() *fakeDeadLetters {
+	return &fakeDeadLetters{
+		letters: map[string]*models.DeadLetter{
+			"dl-1": {ID: "dl-1", RelayID: "relay-a", EventID: "evt-1", Status: store.DeadLetterStatusDead},
+			"dl-2": {ID: "dl-2", RelayID: "relay-b", EventID: "evt-2", Status: store.DeadLetterStatusDead,
+				Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			"dl-3": {ID: "dl-3", RelayID: "relay-c", EventID: "evt-3", Status: store.DeadLetterStatusDead},
+		},
+		owners: map[string]string{"relay-a": deadLetterOwnerA, "relay-b": deadLetterOwnerA, "relay-c": deadLetterOwnerB},
+	}
+}
+
+func serve(r http.Handler, method, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	return rr
+}
+
+func TestRequeueDeadLetterPublishesOnce(t *testing.T) {
+	pub := &fakePublisher{}
+	r := newDeadLetterRouter(seedDeadLetters(), pub)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(r, http.MethodPost, "/dead-letters/dl-1/requeue?user_id="+deadLetterOwnerA).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	accepted, conflicts := 0, 0
+	for code := range codes {
+		switch code {
+		case http.StatusAccepted:
+			accepted++
+		case http.StatusConflict:
+			conflicts++
+		default:
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+	if accepted != 1 || conflicts != 4 {
+		t.Errorf("Expected 1 accepted and 4 conflicts, got %d and %d", accepted, conflicts)
+	}
+	if len(pub.eventIDs) != 1 || pub.eventIDs[0] != "evt-1" {
+		t.Errorf("Expected evt-1 published once, got %v", pub.eventIDs)
+	}
+}
+
+func TestRequeueDeadLetterContinuesTrace(t *testing.T) {
+	pub := &fakePublisher{}
+	r := newDeadLetterRouter(seedDeadLetters(), pub)
+	if rr := serve(r, http.MethodPost, "/dead-letters/dl-2/requeue?user_id="+deadLetterOwnerA); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", rr.Code)
+	}
+	if len(pub.traceparents) != 1 || pub.traceparents[0] != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Expected the original traceparent to be republished, got %v", pub.traceparents)
+	}
+}
+
+func TestRequeueDeadLetterReleasesClaimOnPublishFailure(t *testing.T) {
+	letters := seedDeadLetters()
+	pub := &fakePublisher{fail: true}
+	r := newDeadLetterRouter(letters, pub)
+
+	if rr := serve(r, http.MethodPost, "/dead-letters/dl-1/requeue?user_id="+deadLetterOwnerA); rr.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d", rr.Code)
+	}
+	if letters.letters["dl-1"].Status != store.DeadLetterStatusDead {
+		t.Error("Expected the dead letter to be requeueable again after a failed publish")
+	}
+	pub.fail = false
+	if rr := serve(r, http.MethodPost, "/dead-letters/dl-1/requeue?user_id="+deadLetterOwnerA); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected the retry to succeed, got %d", rr.Code)
+	}
+}
+
+func TestRequeueDeadLetterRefusesUnavailablePayload(t *testing.T) {
+	letters := seedDeadLetters()
+	letters.letters["dl-1"].PayloadUnavailable = true
+	pub := &fakePublisher{}
+	r := newDeadLetterRouter(letters, pub)
+
+	if rr := serve(r, http.MethodPost, "/dead-letters/dl-1/requeue?user_id="+deadLetterOwnerA); rr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409, got %d", rr.Code)
+	}
+	if len(pub.eventIDs) != 0 {
+		t.Errorf("Expected nothing published, got %v", pub.eventIDs)
+	}
+	if letters.letters["dl-1"].Status != store.DeadLetterStatusDead {
+		t.Error("Expected the claim to be released")
+	}
+}
+
+func TestDeadLetterNotFound(t *testing.T) {
+	r := newDeadLetterRouter(seedDeadLetters(), &fakePublisher{})
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/dead-letters/missing?user_id=" + deadLetterOwnerA},
+		{http.MethodPost, "/dead-letters/missing/requeue?user_id=" + deadLetterOwnerA},
+		{http.MethodDelete, "/dead-letters/missing?user_id=" + deadLetterOwnerA},
+	} {
+		if rr := serve(r, tc.method, tc.path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d", tc.method, tc.path, rr.Code)
+		}
+	}
+}
+
+func TestListAndPurgeDeadLetters(t *testing.T) {
+	letters := seedDeadLetters()
+	r := newDeadLetterRouter(letters, &fakePublisher{})
+
+	rr := serve(r, http.MethodGet, "/dead-letters?relay_id=relay-a&user_id="+deadLetterOwnerA)
+	var resp struct {
+		Data []models.DeadLetter `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad response %s: %v", rr.Body.String(), err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != "dl-1" {
+		t.Errorf("Expected only relay-a's dead letter, got %+v", resp.Data)
+	}
+
+	if rr := serve(r, http.MethodDelete, "/dead-letters?relay_id=relay-b&user_id="+deadLetterOwnerA); rr.Code != http.StatusOK {
+		t.Fatalf("Expected purge to succeed, got %d", rr.Code)
+	}
+	if _, ok := letters.letters["dl-2"]; ok || len(letters.letters) != 2 {
+		t.Errorf("Expected only relay-b's dead letter purged, left %v", letters.letters)
+	}
+}
+
+func TestDeadLettersStayInTheirWorkspace(t *testing.T) {
+	verifier, issue := testVerifier(t)
+	letters := seedDeadLetters()
+	pub := &fakePublisher{}
+	r := deadLetterRoutes(NewHandler(Deps{DeadLetters: letters, Publisher: pub, Auth: verifier,
+		Logger: logger.New("hermes-core-test", "test", "error")}))
+	call := func(method, path, caller string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+issue(caller))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodGet, "/dead-letters", deadLetterOwnerB)
+	var resp struct {
+		Data []models.DeadLetter `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad response %s: %v", rr.Body.String(), err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != "dl-3" {
+		t.Errorf("Expected only the workspace's own dead letter, got %+v", resp.Data)
+	}
+	if rr := call(http.MethodGet, "/dead-letters?relay_id=relay-a", deadLetterOwnerB); !strings.Contains(rr.Body.String(), `"data":[]`) {
+		t.Errorf("Expected nothing listed for another workspace's relay, got %s", rr.Body.String())
+	}
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/dead-letters/dl-1"},
+		{http.MethodPost, "/dead-letters/dl-1/requeue"},
+		{http.MethodDelete, "/dead-letters/dl-1"},
+	} {
+		if rr := call(tc.method, tc.path, deadLetterOwnerB); rr.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404 for another workspace's dead letter, got %d", tc.method, tc.path, rr.Code)
+		}
+	}
+	if len(pub.eventIDs) != 0 || letters.letters["dl-1"].Status != store.DeadLetterStatusDead {
+		t.Error("Expected another workspace's dead letter left unrequeued")
+	}
+
+	if rr := call(http.MethodDelete, "/dead-letters", deadLetterOwnerB); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the purge to succeed, got %d", rr.Code)
+	}
+	if _, ok := letters.letters["dl-3"]; ok || len(letters.letters) != 2 {
+		t.Errorf("Expected only the workspace's own dead letter purged, left %v", letters.letters)
+	}
+	// A user_id naming another workspace is refused, not honoured
+	if rr := call(http.MethodGet, "/dead-letters?user_id="+deadLetterOwnerA, deadLetterOwnerB); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 naming another workspace, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Webhook delivery log reads, implemented by *store.DeliveryStore
+type DeliveryStore interface {
+	ListDeliveries(ctx context.Context, relayID string, failedOnly bool, limit int) ([]models.WebhookDelivery, error)
+}
+
+// Lists the webhook requests hermes-hooks received for the relay, newest
+// first, including the ones it turned away. failed=true keeps only those
+func (h *Handler) ListRelayDeliveries(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		return
+	}
+	failedOnly := false
+	if failedStr := r.URL.Query().Get("failed"); failedStr != "" {
+		var err error
+		if failedOnly, err = strconv.ParseBool(failedStr); err != nil {
+			h.respondError(w, http.StatusBadRequest, "failed must be true or false", "VALIDATION_ERROR")
+			return
+		}
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, 200)
+		}
+	}
+	deliveries, err := h.deliveries.ListDeliveries(r.Context(), relayID, failedOnly, limit)
+	if err != nil {
+		h.logger.Error("failed to fetch deliveries", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch deliveries", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", deliveries)
+}
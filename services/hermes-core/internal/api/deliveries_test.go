@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeDeliveryStore struct {
+	deliveries []models.WebhookDelivery
+	failedOnly bool
+	limit      int
+}
+
+func (f *fakeDeliveryStore) ListDeliveries(_ context.Context, relayID string, failedOnly bool, limit int) ([]models.WebhookDelivery, error) {
+	f.failedOnly, f.limit = failedOnly, limit
+	list := make([]models.WebhookDelivery, 0)
+	for _, d := range f.deliveries {
+		if d.RelayID == relayID && (!failedOnly || d.Status >= 400) {
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+func TestListRelayDeliveries(t *testing.T) {
+	const relayID = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	deliveries := &fakeDeliveryStore{deliveries: []models.WebhookDelivery{
+		{RelayID: relayID, Status: 200, SourceIP: "203.0.113.7"},
+		{RelayID: relayID, Status: 413, Reason: "too_large", SourceIP: "203.0.113.7"},
+	}}
+	h := NewHandler(Deps{Deliveries: deliveries, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Get("/relays/{id}/deliveries", h.ListRelayDeliveries)
+
+	rr := serve(r, http.MethodGet, "/relays/"+relayID+"/deliveries?failed=true&limit=1000")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data []models.WebhookDelivery `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].Reason != "too_large" {
+		t.Errorf("Expected only the rejected delivery, got %+v", resp.Data)
+	}
+	if deliveries.limit != 200 {
+		t.Errorf("Expected the limit capped at 200, got %d", deliveries.limit)
+	}
+
+	if rr := serve(r, http.MethodGet, "/relays/not-a-uuid/deliveries"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a malformed relay ID, got %d", rr.Code)
+	}
+	if rr := serve(r, http.MethodGet, "/relays/"+relayID+"/deliveries?failed=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad failed filter, got %d", rr.Code)
+	}
+}
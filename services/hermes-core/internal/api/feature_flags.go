@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/flags"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Feature flag storage used by the handlers, implemented by *store.FlagStore
+type FeatureFlagStore interface {
+	ListFeatureFlags(ctx context.Context) ([]flags.Flag, error)
+	PutFeatureFlag(ctx context.Context, flag flags.Flag) error
+	DeleteFeatureFlag(ctx context.Context, name string) error
+	SetFlagOverride(ctx context.Context, name, userID string, enabled bool) error
+	DeleteFlagOverride(ctx context.Context, name, userID string) error
+}
+
+// Which flags are on for a user, for clients that hide unreleased features
+func (h *Handler) EvaluateFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	result := map[string]bool{}
+	if h.flags != nil {
+		for name, flag := range h.flags.Flags(r.Context()) {
+			result[name] = flag.EnabledFor(userID)
+		}
+	}
+	h.respondSuccess(w, http.StatusOK, "", result)
+}
+
+// Stored flags with their overrides. Defaults from FEATURE_FLAGS aren't listed
+func (h *Handler) ListFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	list, err := h.flagStore.ListFeatureFlags(r.Context())
+	if err != nil {
+		h.logger.Error("failed to fetch feature flags", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch feature flags", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", list)
+}
+
+func (h *Handler) PutFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !flags.ValidName(name) {
+		h.respondError(w, http.StatusBadRequest, "Flag names are lowercase letters, digits and _", "VALIDATION_ERROR")
+		return
+	}
+	var req models.PutFeatureFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	flag := flags.Flag{Name: name, Description: req.Description, Enabled: req.Enabled, RolloutPercent: 100}
+	if req.RolloutPercent != nil {
+		flag.RolloutPercent = *req.RolloutPercent
+	}
+	if flag.RolloutPercent < 0 || flag.RolloutPercent > 100 {
+		h.respondError(w, http.StatusBadRequest, "rollout_percent must be between 0 and 100", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.flagStore.PutFeatureFlag(r.Context(), flag); err != nil {
+		h.logger.Error("failed to save feature flag", slog.String("flag", name), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to save feature flag", "DB_ERROR")
+		return
+	}
+	h.invalidateFlags()
+	h.logger.Info("feature flag saved", slog.String("flag", name),
+		slog.Bool("enabled", flag.Enabled),
+		slog.Int("rollout_percent", flag.RolloutPercent))
+	h.respondSuccess(w, http.StatusOK, "Feature flag saved", flag)
+}
+
+func (h *Handler) DeleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := h.flagStore.DeleteFeatureFlag(r.Context(), name); err != nil {
+		if errors.Is(err, store.ErrFeatureFlagNotFound) {
+			h.respondError(w, http.StatusNotFound, "Feature flag not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete feature flag", slog.String("flag", name), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete feature flag", "DB_ERROR")
+		return
+	}
+	h.invalidateFlags()
+	h.logger.Info("feature flag deleted", slog.String("flag", name))
+	h.respondSuccess(w, http.StatusOK, "Feature flag deleted", nil)
+}
+
+func (h *Handler) PutFlagOverride(w http.ResponseWriter, r *http.Request) {
+	name, userID := chi.URLParam(r, "name"), chi.URLParam(r, "userID")
+	if uuid.Validate(userID) != nil {
+		h.respondError(w, http.StatusNotFound, "User not found", "NOT_FOUND")
+		return
+	}
+	var req models.FlagOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if err := h.flagStore.SetFlagOverride(r.Context(), name, userID, req.Enabled); err != nil {
+		switch {
+		case errors.Is(err, store.ErrFeatureFlagNotFound):
+			h.respondError(w, http.StatusNotFound, "Feature flag not found", "NOT_FOUND")
+		case errors.Is(err, store.ErrUserNotFound):
+			h.respondError(w, http.StatusNotFound, "User not found", "NOT_FOUND")
+		default:
+			h.logger.Error("failed to save flag override", slog.String("flag", name), logfields.UserID(userID),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to save flag override", "DB_ERROR")
+		}
+		return
+	}
+	h.invalidateFlags()
+	h.logger.Info("flag override saved", slog.String("flag", name), logfields.UserID(userID),
+		slog.Bool("enabled", req.Enabled))
+	h.respondSuccess(w, http.StatusOK, "Flag override saved", nil)
+}
+
+func (h *Handler) DeleteFlagOverride(w http.ResponseWriter, r *http.Request) {
+	name, userID := chi.URLParam(r, "name"), chi.URLParam(r, "userID")
+	if uuid.Validate(userID) != nil {
+		h.respondError(w, http.StatusNotFound, "Flag override not found", "NOT_FOUND")
+		return
+	}
+	if err := h.flagStore.DeleteFlagOverride(r.Context(), name, userID); err != nil {
+		if errors.Is(err, store.ErrFeatureFlagNotFound) {
+			h.respondError(w, http.StatusNotFound, "Flag override not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete flag override", slog.String("flag", name), logfields.UserID(userID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete flag override", "DB_ERROR")
+		return
+	}
+	h.invalidateFlags()
+	h.respondSuccess(w, http.StatusOK, "Flag override deleted", nil)
+}
+
+// Other instances see flag changes once their cache expires
+func (h *Handler) invalidateFlags() {
+	if h.flags != nil {
+		h.flags.Invalidate()
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/flags"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeFlagStore struct {
+	flags flags.Set
+}
+
+func (f *fakeFlagStore) ListFeatureFlags(context.Context) ([]flags.Flag, error) {
+	list := make([]flags.Flag, 0, len(f.flags))
+	for _, flag := range f.flags {
+		list = append(list, flag)
+	}
+	return list, nil
+}
+
+func (f *fakeFlagStore) FeatureFlags(context.Context) (flags.Set, error) {
+	return f.flags, nil
+}
+
+func (f *fakeFlagStore) PutFeatureFlag(_ context.Context, flag flags.Flag) error {
+	f.flags[flag.Name] = flag
+	return nil
+}
+
+func (f *fakeFlagStore) DeleteFeatureFlag(_ context.Context, name string) error {
+	if _, ok := f.flags[name]; !ok {
+		return store.ErrFeatureFlagNotFound
+	}
+	delete(f.flags, name)
+	return nil
+}
+
+func (f *fakeFlagStore) SetFlagOverride(_ context.Context, name, userID string, enabled bool) error {
+	flag, ok := f.flags[name]
+	if !ok {
+		return store.ErrFeatureFlagNotFound
+	}
+	if flag.Overrides == nil {
+		flag.Overrides = map[string]bool{}
+	}
+	flag.Overrides[userID] = enabled
+	f.flags[name] = flag
+	return nil
+}
+
+func (f *fakeFlagStore) DeleteFlagOverride(context.Context, string, string) error {
+	return store.ErrFeatureFlagNotFound
+}
+
+func newFlagRouter(flagStore *fakeFlagStore, defaults flags.Set) chi.Router {
+	testLogger := logger.New("hermes-core-test", "test", "error")
+	h := NewHandler(Deps{
+		FlagStore: flagStore,
+		Flags:     flags.NewEvaluator(defaults, flagStore.FeatureFlags, time.Hour, testLogger),
+		Logger:    testLogger,
+	})
+	r := chi.NewRouter()
+	r.Get("/flags", h.EvaluateFeatureFlags)
+	r.Put("/admin/flags/{name}", h.PutFeatureFlag)
+	r.Delete("/admin/flags/{name}", h.DeleteFeatureFlag)
+	r.Put("/admin/flags/{name}/users/{userID}", h.PutFlagOverride)
+	return r
+}
+
+func send(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return rr
+}
+
+func evaluate(t *testing.T, r http.Handler, userID string) map[string]bool {
+	t.Helper()
+	rr := send(r, http.MethodGet, "/flags?user_id="+userID, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data map[string]bool `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.Data
+}
+
+func TestFeatureFlagsChangeTakesEffect(t *testing.T) {
+	const userID = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	defaults, _ := flags.Parse("sync_execution=on")
+	r := newFlagRouter(&fakeFlagStore{flags: flags.Set{}}, defaults)
+
+	if got := evaluate(t, r, userID); !got["sync_execution"] || got["dag_engine"] {
+		t.Errorf("Expected only the config default on, got %v", got)
+	}
+	if rr := send(r, http.MethodPut, "/admin/flags/dag_engine", `{"enabled":true,"rollout_percent":0}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send(r, http.MethodPut, "/admin/flags/dag_engine/users/"+userID, `{"enabled":true}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := evaluate(t, r, userID); !got["dag_engine"] {
+		t.Errorf("Expected the override to turn dag_engine on right away, got %v", got)
+	}
+	if got := evaluate(t, r, "someone-else"); got["dag_engine"] {
+		t.Errorf("Expected a 0%% rollout to leave other users out, got %v", got)
+	}
+}
+
+func TestFeatureFlagHandlersValidate(t *testing.T) {
+	r := newFlagRouter(&fakeFlagStore{flags: flags.Set{}}, nil)
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/admin/flags/DagEngine", `{"enabled":true}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/flags/dag_engine", `{"enabled":true,"rollout_percent":150}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/flags/dag_engine", `{"enabled":`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/flags/missing", "", http.StatusNotFound},
+		{http.MethodPut, "/admin/flags/missing/users/not-a-uuid", `{"enabled":true}`, http.StatusNotFound},
+		{http.MethodPut, "/admin/flags/missing/users/5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a", `{"enabled":true}`, http.StatusNotFound},
+		{http.MethodGet, "/flags", "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rr := send(r, c.method, c.path, c.body); rr.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.status, rr.Code)
+		}
+	}
+}
@@ -1,26 +1,168 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/flags"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/migrate"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/canary"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/metrics"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+// Pushes events back onto the broker for the worker to pick up
+type EventPublisher interface {
+	PublishEvent(relayID, eventID, traceparent string, payload json.RawMessage) error
+}
+
+// Optionally implemented by the publisher: tells workers to stop a running execution
+type CancelPublisher interface {
+	PublishCancel(relayID, eventID string) error
+}
+
 type Handler struct {
-	store   *store.RelayStore
-	logger  *slog.Logger
-	baseURL string
+	store        *store.RelayStore
+	deadLetters  DeadLetterStore
+	agents       AgentStore
+	workers      WorkerStore
+	overview     OverviewStore
+	alerts       AlertStore
+	logExports   LogExportStore
+	deliveries   DeliveryStore
+	usage        UsageStore
+	plugins      *store.PluginStore
+	secrets      *store.SecretStore
+	publisher    EventPublisher
+	agentPolicy  AgentPolicy
+	adminToken   string
+	metrics      *metrics.Metrics
+	debugToken   string
+	reload       func() error
+	flagStore    FeatureFlagStore
+	flags        *flags.Evaluator
+	schema       func(ctx context.Context) (migrate.Status, error)
+	canary       func() canary.Status
+	auth         *auth.Verifier
+	authDisabled bool
+	oidc         *OIDCLogin
+	sessions     SessionStore
+	workspaces   WorkspaceStore
+	apiKeys      APIKeyStore
+	oauth        *OAuthConnections
+	connections  ConnectionStore
+	redaction    RedactionStore
+	dataRequests DataRequestStore
+	logger       *slog.Logger
+	baseURL      string
 }
 
-func NewHandler(s *store.RelayStore, logger *slog.Logger) *Handler {
-	return &Handler{store: s, logger: logger, baseURL: "http://localhost:8080"}
+// Everything the handlers need, wired up in main
+type Deps struct {
+	Relays      *store.RelayStore
+	DeadLetters DeadLetterStore
+	Agents      AgentStore
+	Workers     WorkerStore
+	Overview    OverviewStore
+	Alerts      AlertStore
+	LogExports  LogExportStore
+	Deliveries  DeliveryStore
+	Usage       UsageStore
+	Plugins     *store.PluginStore
+	Secrets     *store.SecretStore
+	Publisher   EventPublisher
+	AgentPolicy AgentPolicy
+	// Required on operator routes. Empty closes them
+	AdminToken string
+	// Served at /metrics when set
+	Metrics *metrics.Metrics
+	// Serves pprof and expvar under /debug to this bearer token when set
+	DebugToken string
+	// Re-applies reloadable settings for POST /admin/reload. Nil turns it off
+	Reload    func() error
+	FlagStore FeatureFlagStore
+	// Reports the schema version on GET /health when set
+	Schema func(ctx context.Context) (migrate.Status, error)
+	// Reports the canary on GET /health when set, degraded while it fails
+	Canary func() canary.Status
+	// Evaluates flags for GET /flags. Nil reports every flag as off
+	Flags *flags.Evaluator
+	// Verifies bearer tokens on user routes. With neither this nor OIDC set
+	// only API keys get in
+	Auth *auth.Verifier
+	// Lets user routes through without a token, trusting the user_id clients
+	// send. Development only
+	AuthDisabled bool
+	// Single sign-on issuing sessions from Sessions. Nil turns login off
+	OIDC     *OIDCLogin
+	Sessions SessionStore
+	// Lets users into each other's workspaces with a role
+	Workspaces WorkspaceStore
+	// Authenticates API keys on user routes. Nil turns them off
+	APIKeys APIKeyStore
+	// Connects accounts at OAuth providers. Nil turns connecting off, the
+	// connections already made still list from Connections
+	OAuth       *OAuthConnections
+	Connections ConnectionStore
+	// Rules masking personal data in payloads, applied by the worker
+	Redaction RedactionStore
+	// Exports and deletions of a user's data, carried out by privacy.Processor
+	DataRequests DataRequestStore
+	Logger       *slog.Logger
+}
+
+func NewHandler(d Deps) *Handler {
+	return &Handler{
+		store:        d.Relays,
+		deadLetters:  d.DeadLetters,
+		agents:       d.Agents,
+		workers:      d.Workers,
+		overview:     d.Overview,
+		alerts:       d.Alerts,
+		logExports:   d.LogExports,
+		deliveries:   d.Deliveries,
+		usage:        d.Usage,
+		plugins:      d.Plugins,
+		secrets:      d.Secrets,
+		publisher:    d.Publisher,
+		agentPolicy:  d.AgentPolicy,
+		adminToken:   d.AdminToken,
+		metrics:      d.Metrics,
+		debugToken:   d.DebugToken,
+		reload:       d.Reload,
+		flagStore:    d.FlagStore,
+		flags:        d.Flags,
+		schema:       d.Schema,
+		canary:       d.Canary,
+		auth:         d.Auth,
+		authDisabled: d.AuthDisabled,
+		oidc:         d.OIDC,
+		sessions:     d.Sessions,
+		workspaces:   d.Workspaces,
+		apiKeys:      d.APIKeys,
+		oauth:        d.OAuth,
+		connections:  d.Connections,
+		redaction:    d.Redaction,
+		dataRequests: d.DataRequests,
+		logger:       d.Logger,
+		baseURL:      "http://localhost:8080",
+	}
 }
 
 func (h *Handler) respondJSON(w http.ResponseWriter, status int, data any) {
@@ -61,16 +203,51 @@ func (h *Handler) CreateRelay(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, http.StatusBadRequest, "Name is required", "VALIDATION_ERROR")
 		return
 	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
 	if strings.TrimSpace(req.UserID) == "" {
 		h.respondError(w, http.StatusBadRequest, "UserID is required", "VALIDATION_ERROR")
 		return
 	}
+	if grant, ok := auth.KeyGrantFrom(r.Context()); ok && len(grant.RelayIDs) > 0 {
+		h.respondError(w, http.StatusForbidden, "API key is limited to existing relays", "FORBIDDEN")
+		return
+	}
 	if len(req.Actions) == 0 {
 		h.respondError(w, http.StatusBadRequest, "At least one action is required", "VALIDATION_ERROR")
 		return
 	}
+	if msg := validateScheduling(relaySchedule{
+		Priority:        &req.Priority,
+		MaxConcurrency:  &req.MaxConcurrency,
+		DebounceSeconds: &req.DebounceSeconds,
+		ThrottleSeconds: &req.ThrottleSeconds,
+		ThrottleMode:    &req.ThrottleMode,
+		TimeoutSeconds:  &req.TimeoutSeconds,
+		DedupeWindow:    &req.DedupeWindowSeconds,
+		creating:        true,
+	}); msg != "" {
+		h.respondError(w, http.StatusBadRequest, msg, "VALIDATION_ERROR")
+		return
+	}
 
+	var secretRefs []string
+	mainActions := 0
 	for i, action := range req.Actions {
+		if !action.OnFailure {
+			mainActions++
+		} else if action.ActionType == models.ActionAggregate {
+			h.respondError(w, http.StatusBadRequest,
+				"aggregate actions can't be part of the failure branch, at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+			return
+		}
+		if action.Optional && (action.OnFailure || action.ActionType == models.ActionAggregate) {
+			h.respondError(w, http.StatusBadRequest,
+				"optional isn't supported on aggregate or failure branch actions, at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+			return
+		}
 		if action.ActionType == "" {
 			h.respondError(w, http.StatusBadRequest,
 				"Action type is required for action at index "+strconv.Itoa(i),
@@ -83,13 +260,93 @@ func (h *Handler) CreateRelay(w http.ResponseWriter, r *http.Request) {
 				"VALIDATION_ERROR")
 			return
 		}
+		if action.AgentGroup == "" && (len(action.AgentLabels) > 0 || action.MinAgentVersion != "") {
+			h.respondError(w, http.StatusBadRequest,
+				"agent_labels and min_agent_version need an agent_group for action at index "+strconv.Itoa(i),
+				"VALIDATION_ERROR")
+			return
+		}
+		if action.ActionType == models.ActionAggregate {
+			if msg := validateAggregate(action); msg != "" {
+				h.respondError(w, http.StatusBadRequest, msg+" for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+		}
+		if action.ActionType == models.ActionMap {
+			if msg := validateMap(action); msg != "" {
+				h.respondError(w, http.StatusBadRequest, msg+" for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+		}
+		if action.ActionType == models.ActionEnrich {
+			if msg := validateEnrich(action); msg != "" {
+				h.respondError(w, http.StatusBadRequest, msg+" for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+		}
+		if action.ActionType == models.ActionCallRelay {
+			if msg := validateCallRelay(action); msg != "" {
+				h.respondError(w, http.StatusBadRequest, msg+" for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+			target, err := h.store.GetRelay(r.Context(), action.Config["relay_id"].(string))
+			if errors.Is(err, store.ErrRelayNotFound) || (err == nil && target.UserID != req.UserID) {
+				h.respondError(w, http.StatusBadRequest,
+					"Unknown relay_id for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+			if err != nil {
+				h.logger.Error("failed to fetch called relay", slog.String("error", err.Error()))
+				h.respondError(w, http.StatusInternalServerError, "Failed to fetch relay", "DB_ERROR")
+				return
+			}
+		}
+		if name, isPlugin := strings.CutPrefix(action.ActionType, models.PluginActionPrefix); isPlugin {
+			if _, err := h.plugins.Get(r.Context(), name); err != nil {
+				if errors.Is(err, store.ErrPluginNotFound) {
+					h.respondError(w, http.StatusBadRequest,
+						"Unknown plugin "+name+" for action at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+					return
+				}
+				h.logger.Error("failed to fetch plugin", slog.String("plugin", name),
+					slog.String("error", err.Error()))
+				h.respondError(w, http.StatusInternalServerError, "Failed to fetch plugin", "DB_ERROR")
+				return
+			}
+		}
+		if refs := secrets.References(action.Config); len(refs) > 0 {
+			if action.AgentGroup != "" {
+				h.respondError(w, http.StatusBadRequest,
+					"Secret references aren't supported on agent actions, at index "+strconv.Itoa(i), "VALIDATION_ERROR")
+				return
+			}
+			secretRefs = append(secretRefs, refs...)
+		}
+	}
+	if mainActions == 0 {
+		h.respondError(w, http.StatusBadRequest, "At least one action must run outside the failure branch", "VALIDATION_ERROR")
+		return
+	}
+	if len(secretRefs) > 0 {
+		missing, err := h.secrets.Missing(r.Context(), req.UserID, secretRefs)
+		if err != nil {
+			h.logger.Error("failed to check secrets", logfields.UserID(req.UserID),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to check secrets", "DB_ERROR")
+			return
+		}
+		if len(missing) > 0 {
+			h.respondError(w, http.StatusBadRequest,
+				"Unknown secrets referenced: "+strings.Join(missing, ", "), "VALIDATION_ERROR")
+			return
+		}
 	}
 
 	relay, err := h.store.CreateRelay(r.Context(), req)
 	if err != nil {
 		h.logger.Error("failed to create relay",
 			slog.String("error", err.Error()),
-			slog.String("user_id", req.UserID),
+			logfields.UserID(req.UserID),
 		)
 		h.respondError(w, http.StatusInternalServerError, "Failed to create relay", "DB_ERROR")
 		return
@@ -97,8 +354,8 @@ func (h *Handler) CreateRelay(w http.ResponseWriter, r *http.Request) {
 	relay.Relay.WebhookURL = h.baseURL + relay.Relay.WebhookPath
 
 	h.logger.Info("relay created",
-		slog.String("relay_id", relay.ID),
-		slog.String("user_id", req.UserID),
+		logfields.RelayID(relay.ID),
+		logfields.UserID(req.UserID),
 		slog.Int("action_count", len(relay.Actions)),
 	)
 
@@ -106,11 +363,174 @@ func (h *Handler) CreateRelay(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Scheduling settings shared by create and update. Nil fields are left alone
+type relaySchedule struct {
+	Priority        *string
+	MaxConcurrency  *int
+	DebounceSeconds *int
+	ThrottleSeconds *int
+	ThrottleMode    *string
+	TimeoutSeconds  *int
+	DedupeWindow    *int
+	// On create an empty priority or mode means the default, on update it's invalid
+	creating bool
+}
+
+func (rs relaySchedule) empty() bool {
+	return rs.Priority == nil && rs.MaxConcurrency == nil && rs.DebounceSeconds == nil &&
+		rs.ThrottleSeconds == nil && rs.ThrottleMode == nil && rs.TimeoutSeconds == nil &&
+		rs.DedupeWindow == nil
+}
+
+// Returns an error message or ""
+func validateScheduling(rs relaySchedule) string {
+	if rs.Priority != nil && !(rs.creating && *rs.Priority == "") && !models.ValidPriority(*rs.Priority) {
+		return "Priority must be high, normal or low"
+	}
+	if rs.MaxConcurrency != nil && *rs.MaxConcurrency < 0 {
+		return "max_concurrency cannot be negative"
+	}
+	if rs.DebounceSeconds != nil && *rs.DebounceSeconds < 0 {
+		return "debounce_seconds cannot be negative"
+	}
+	if rs.ThrottleSeconds != nil && *rs.ThrottleSeconds < 0 {
+		return "throttle_seconds cannot be negative"
+	}
+	if rs.ThrottleMode != nil && !(rs.creating && *rs.ThrottleMode == "") && !models.ValidThrottleMode(*rs.ThrottleMode) {
+		return "throttle_mode must be drop or queue"
+	}
+	if rs.TimeoutSeconds != nil && *rs.TimeoutSeconds < 0 {
+		return "timeout_seconds cannot be negative"
+	}
+	if rs.DedupeWindow != nil && (*rs.DedupeWindow < 0 || *rs.DedupeWindow > maxDedupeWindowSeconds) {
+		return fmt.Sprintf("dedupe_window_seconds must be between 0 and %d", maxDedupeWindowSeconds)
+	}
+	return ""
+}
+
+// Longest dedupe window a relay may ask for, 30 days
+const maxDedupeWindowSeconds = 30 * 24 * 60 * 60
+
+// Largest batch an aggregate action may collect, so a flush stays a reasonable size
+const maxAggregateEvents = 1000
+
+// Checks an aggregate action's window/max_events config. Returns an error message or ""
+func validateAggregate(action models.CreateRelayActionInput) string {
+	if action.AgentGroup != "" {
+		return "aggregate actions run in the worker and can't target an agent_group"
+	}
+	if raw, ok := action.Config["window"]; ok {
+		window, isString := raw.(string)
+		d, err := time.ParseDuration(window)
+		if !isString || err != nil || d <= 0 {
+			return "window must be a positive duration such as \"5m\""
+		}
+	}
+	if raw, ok := action.Config["max_events"]; ok {
+		if n, isNumber := raw.(float64); !isNumber || n < 1 || n > maxAggregateEvents || n != math.Trunc(n) {
+			return fmt.Sprintf("max_events must be a whole number between 1 and %d", maxAggregateEvents)
+		}
+	}
+	return ""
+}
+
+// Checks a map action's mappings. Returns an error message or ""
+func validateMap(action models.CreateRelayActionInput) string {
+	if action.AgentGroup != "" {
+		return "map actions run in the worker and can't target an agent_group"
+	}
+	if _, err := mapping.ParseSpec(action.Config); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Longest an enrich lookup may take, matching the worker's ceiling
+const maxEnrichTimeout = time.Minute
+
+// Checks an enrich action's lookup and merge key. Returns an error message or ""
+func validateEnrich(action models.CreateRelayActionInput) string {
+	if action.AgentGroup != "" {
+		return "enrich actions run in the worker and can't target an agent_group"
+	}
+	target, _ := action.Config["url"].(string)
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return "url must be an http or https URL"
+	}
+	if err := mapping.CheckTemplate(target); err != nil {
+		return "url: " + err.Error()
+	}
+	key, _ := action.Config["key"].(string)
+	if _, err := mapping.ParseSpec(map[string]any{"mappings": []any{map[string]any{"to": key, "value": true}}}); err != nil {
+		return "key must be a payload path such as \"enrichment.user\""
+	}
+	if raw, ok := action.Config["method"]; ok {
+		if method, _ := raw.(string); !strings.EqualFold(method, http.MethodGet) && !strings.EqualFold(method, http.MethodPost) {
+			return "method must be GET or POST"
+		}
+	}
+	if raw, ok := action.Config["headers"]; ok {
+		headers, isMap := raw.(map[string]any)
+		if !isMap {
+			return "headers must be an object of strings"
+		}
+		for name, value := range headers {
+			tmpl, isString := value.(string)
+			if !isString {
+				return "headers must be an object of strings"
+			}
+			if err := mapping.CheckTemplate(tmpl); err != nil {
+				return "header " + name + ": " + err.Error()
+			}
+		}
+	}
+	if raw, ok := action.Config["timeout"]; ok {
+		timeout, isString := raw.(string)
+		d, err := time.ParseDuration(timeout)
+		if !isString || err != nil || d <= 0 || d > maxEnrichTimeout {
+			return "timeout must be a positive duration of at most 1m"
+		}
+	}
+	return ""
+}
+
+// Checks a call_relay action's config apart from whether the relay exists.
+// Returns an error message or ""
+func validateCallRelay(action models.CreateRelayActionInput) string {
+	if action.AgentGroup != "" {
+		return "call_relay actions run in the worker and can't target an agent_group"
+	}
+	if id, _ := action.Config["relay_id"].(string); uuid.Validate(id) != nil {
+		return "relay_id must be a relay ID"
+	}
+	if raw, ok := action.Config["wait"]; ok {
+		if _, isBool := raw.(bool); !isBool {
+			return "wait must be true or false"
+		}
+	}
+	if raw, ok := action.Config["timeout"]; ok {
+		timeout, isString := raw.(string)
+		d, err := time.ParseDuration(timeout)
+		if !isString || err != nil || d <= 0 {
+			return "timeout must be a positive duration such as \"30s\""
+		}
+	}
+	if _, ok := action.Config["mappings"]; ok {
+		if _, err := mapping.ParseSpec(action.Config); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
 func (h *Handler) GetAllRelays(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
 
 	h.logger.Debug("fetching all relays",
-		slog.String("user_id", userID),
+		logfields.UserID(userID),
 	)
 
 	relays, err := h.store.GetAllRelays(r.Context(), userID)
@@ -122,13 +542,16 @@ func (h *Handler) GetAllRelays(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if grant, ok := auth.KeyGrantFrom(r.Context()); ok {
+		relays = slices.DeleteFunc(relays, func(relay models.Relay) bool { return !grant.AllowsRelay(relay.ID) })
+	}
 	for i := range relays {
 		relays[i].WebhookURL = h.baseURL + relays[i].WebhookPath
 	}
 
 	h.logger.Info("fetched relays",
 		slog.Int("count", len(relays)),
-		slog.String("user_id", userID),
+		logfields.UserID(userID),
 	)
 
 	h.respondSuccess(w, http.StatusOK, "", relays)
@@ -142,31 +565,109 @@ func (h *Handler) GetRelayLogs(w http.ResponseWriter, r *http.Request) {
 			limit = min(parsedLimit, 200)
 		}
 	}
-	h.logger.Debug("fetching relay logs", slog.String("relay_id", relayID),
+	traceID := r.URL.Query().Get("trace_id")
+	h.logger.Debug("fetching relay logs", logfields.RelayID(relayID),
+		logfields.TraceID(traceID),
 		slog.Int("limit", limit))
-	logs, err := h.store.GetLogs(r.Context(), relayID, limit)
+	logs, err := h.store.GetLogs(r.Context(), relayID, traceID, limit)
 	if err != nil {
-		h.logger.Error("failed to fetch logs", slog.String("relay_id", relayID),
+		h.logger.Error("failed to fetch logs", logfields.RelayID(relayID),
 			slog.String("error", err.Error()))
 		h.respondError(w, http.StatusInternalServerError, "Failed to fetch logs", "DB_ERROR")
 		return
 	}
-	h.logger.Info("fetched logs", slog.String("relay_id", relayID), slog.Int("count", len(logs)))
+	h.logger.Info("fetched logs", logfields.RelayID(relayID), slog.Int("count", len(logs)))
+	h.respondSuccess(w, http.StatusOK, "", logs)
+}
+
+// Finds the relay's runs by payload fields and error text, as parsed by
+// store.ParseLogSearch. since narrows it to runs at or after an RFC 3339 time
+func (h *Handler) SearchRelayLogs(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		return
+	}
+	search, err := store.ParseLogSearch(r.URL.Query().Get("q"))
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		if since, err = time.Parse(time.RFC3339, sinceStr); err != nil {
+			h.respondError(w, http.StatusBadRequest, "since must be an RFC 3339 time", "VALIDATION_ERROR")
+			return
+		}
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, 200)
+		}
+	}
+	logs, err := h.store.SearchLogs(r.Context(), relayID, search, since, limit)
+	if err != nil {
+		h.logger.Error("failed to search logs", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to search logs", "DB_ERROR")
+		return
+	}
+	h.logger.Info("searched logs", logfields.RelayID(relayID), slog.Int("count", len(logs)))
 	h.respondSuccess(w, http.StatusOK, "", logs)
 }
 
+// Cancels the execution of one event, identified by its event ID. A queued
+// execution is skipped when a worker picks it up, a running one is stopped
+func (h *Handler) CancelExecution(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	eventID := chi.URLParam(r, "executionID")
+	if uuid.Validate(relayID) != nil {
+		h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+		return
+	}
+	dropped, err := h.store.CancelExecution(r.Context(), relayID, eventID)
+	if err != nil {
+		if errors.Is(err, store.ErrRelayNotFound) {
+			h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to cancel execution", logfields.RelayID(relayID),
+			logfields.EventID(eventID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to cancel execution", "DB_ERROR")
+		return
+	}
+	// Workers also check the cancellation before each run, so a lost signal
+	// only means a running execution finishes
+	if c, ok := h.publisher.(CancelPublisher); ok {
+		if err := c.PublishCancel(relayID, eventID); err != nil {
+			h.logger.Warn("failed to signal cancellation to workers", logfields.RelayID(relayID),
+				logfields.EventID(eventID),
+				slog.String("error", err.Error()))
+		}
+	}
+	h.logger.Info("execution cancelled", logfields.RelayID(relayID),
+		logfields.EventID(eventID),
+		slog.Int64("held_dropped", dropped))
+	h.respondSuccess(w, http.StatusOK, "Execution cancelled", map[string]any{
+		"relay_id":     relayID,
+		"execution_id": eventID,
+	})
+}
+
 func (h *Handler) GetRelay(w http.ResponseWriter, r *http.Request) {
 	relayID := chi.URLParam(r, "id")
-	h.logger.Debug("fetching relay", slog.String("relay_id", relayID))
+	h.logger.Debug("fetching relay", logfields.RelayID(relayID))
 	relay, err := h.store.GetRelay(r.Context(), relayID)
 	if err != nil {
 		if errors.Is(err, store.ErrRelayNotFound) {
-			h.logger.Warn("relay not found", slog.String("relay_id", relayID))
+			h.logger.Warn("relay not found", logfields.RelayID(relayID))
 			h.respondError(w, http.StatusNotFound, "Relay Not found", "NOT_FOUND")
 			return
 		}
 		h.logger.Error("failed to fetch relay",
-			slog.String("relay_id", relayID),
+			logfields.RelayID(relayID),
 			slog.String("error", err.Error()),
 		)
 		h.respondError(w, http.StatusInternalServerError, "Failed to fetch relay", "DB_ERROR")
@@ -174,7 +675,7 @@ func (h *Handler) GetRelay(w http.ResponseWriter, r *http.Request) {
 	}
 	relay.Relay.WebhookURL = h.baseURL + relay.Relay.WebhookPath
 	h.logger.Info("fetched relay",
-		slog.String("relay_id", relayID),
+		logfields.RelayID(relayID),
 		slog.Int("action_count", len(relay.Actions)),
 	)
 
@@ -189,24 +690,37 @@ func (h *Handler) UpdateRelay(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
 		return
 	}
-	if req.Name == nil && req.Description == nil && req.IsActive == nil {
+	schedule := relaySchedule{
+		Priority:        req.Priority,
+		MaxConcurrency:  req.MaxConcurrency,
+		DebounceSeconds: req.DebounceSeconds,
+		ThrottleSeconds: req.ThrottleSeconds,
+		ThrottleMode:    req.ThrottleMode,
+		TimeoutSeconds:  req.TimeoutSeconds,
+		DedupeWindow:    req.DedupeWindowSeconds,
+	}
+	if req.Name == nil && req.Description == nil && req.IsActive == nil && schedule.empty() {
 		h.respondError(w, http.StatusBadRequest, "No fields to update", "VALIDATION_ERROR")
 		return
 	}
+	if msg := validateScheduling(schedule); msg != "" {
+		h.respondError(w, http.StatusBadRequest, msg, "VALIDATION_ERROR")
+		return
+	}
 	relay, err := h.store.UpdateRelay(r.Context(), relayID, req)
 	if err != nil {
 		if errors.Is(err, store.ErrRelayNotFound) {
-			h.logger.Warn("relay not found", slog.String("relay_id", relayID))
+			h.logger.Warn("relay not found", logfields.RelayID(relayID))
 			h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
 			return
 		}
-		h.logger.Error("failed to update relay", slog.String("relay_id", relayID),
+		h.logger.Error("failed to update relay", logfields.RelayID(relayID),
 			slog.String("error", err.Error()))
 		h.respondError(w, http.StatusInternalServerError, "Failed to update relay", "DB_ERROR")
 		return
 	}
 	relay.WebhookURL = h.baseURL + relay.WebhookPath
-	h.logger.Info("relay updated", slog.String("relay_id", relayID))
+	h.logger.Info("relay updated", logfields.RelayID(relayID))
 	h.respondSuccess(w, http.StatusOK, "Relay updated successfully", relay)
 }
 
@@ -215,16 +729,16 @@ func (h *Handler) DeleteRelay(w http.ResponseWriter, r *http.Request) {
 	err := h.store.DeleteRelay(r.Context(), relayID)
 	if err != nil {
 		if errors.Is(err, store.ErrRelayNotFound) {
-			h.logger.Warn("relay not found for deletion", slog.String("relay_id", relayID))
+			h.logger.Warn("relay not found for deletion", logfields.RelayID(relayID))
 			h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
 			return
 		}
-		h.logger.Error("failed to delete relay", slog.String("relay_id", relayID),
+		h.logger.Error("failed to delete relay", logfields.RelayID(relayID),
 			slog.String("err", err.Error()))
 		h.respondError(w, http.StatusInternalServerError, "Failed to delete relay", "DB_ERROR")
 		return
 	}
-	h.logger.Info("relay deleted", slog.String("relay_id", relayID))
+	h.logger.Info("relay deleted", logfields.RelayID(relayID))
 	h.respondSuccess(w, http.StatusOK, "Relay deleted successfully",
 		map[string]string{
 			"deleted_id": relayID,
@@ -232,8 +746,25 @@ func (h *Handler) DeleteRelay(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	h.respondJSON(w, http.StatusOK, map[string]string{
+	health := map[string]any{
 		"status":  "healthy",
 		"service": "hermes-core",
-	})
+	}
+	if h.schema != nil {
+		if status, err := h.schema(r.Context()); err != nil {
+			h.logger.Warn("failed to read schema version", slog.String("error", err.Error()))
+			health["schema"] = "unknown"
+		} else {
+			health["schema"] = status
+		}
+	}
+	// Still 200: the API itself is fine and shouldn't be taken out of rotation
+	if h.canary != nil {
+		status := h.canary()
+		if status.Runs > 0 && !status.OK {
+			health["status"] = "degraded"
+		}
+		health["canary"] = status
+	}
+	h.respondJSON(w, http.StatusOK, health)
 }
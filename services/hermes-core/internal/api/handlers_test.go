@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+func TestValidateAggregateMaxEvents(t *testing.T) {
+	cases := []struct {
+		maxEvents any
+		ok        bool
+	}{
+		{float64(1), true},
+		{float64(maxAggregateEvents), true},
+		{float64(0), false},
+		{float64(maxAggregateEvents + 1), false},
+		{float64(2.5), false},
+		{"10", false},
+	}
+	for _, c := range cases {
+		action := models.CreateRelayActionInput{Config: map[string]any{"max_events": c.maxEvents}}
+		if msg := validateAggregate(action); (msg == "") != c.ok {
+			t.Errorf("max_events %v: expected ok=%v, got %q", c.maxEvents, c.ok, msg)
+		}
+	}
+}
+
+func TestValidateCallRelay(t *testing.T) {
+	valid := map[string]any{"relay_id": "5b1c1f4e-0d5c-4f43-9f1e-1b8a2c3d4e5f", "wait": true, "timeout": "30s"}
+	if msg := validateCallRelay(models.CreateRelayActionInput{Config: valid}); msg != "" {
+		t.Errorf("Expected a valid config, got %q", msg)
+	}
+	for _, config := range []map[string]any{
+		{},
+		{"relay_id": "not-a-uuid"},
+		{"relay_id": valid["relay_id"], "wait": "yes"},
+		{"relay_id": valid["relay_id"], "timeout": "soon"},
+		{"relay_id": valid["relay_id"], "mappings": []any{}},
+	} {
+		if msg := validateCallRelay(models.CreateRelayActionInput{Config: config}); msg == "" {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestValidateEnrich(t *testing.T) {
+	valid := map[string]any{
+		"url":     "https://api.example.com/users/{{sender.login}}",
+		"key":     "enrichment.user",
+		"headers": map[string]any{"Authorization": "Bearer {{secret:TOKEN}}"},
+		"timeout": "5s",
+	}
+	if msg := validateEnrich(models.CreateRelayActionInput{Config: valid}); msg != "" {
+		t.Errorf("Expected a valid config, got %q", msg)
+	}
+	for _, config := range []map[string]any{
+		{"key": "user"},
+		{"url": "ftp://example.com", "key": "user"},
+		{"url": "https://example.com/{{user", "key": "user"},
+		{"url": "https://example.com"},
+		{"url": "https://example.com", "key": "user..name"},
+		{"url": "https://example.com", "key": "user", "method": "DELETE"},
+		{"url": "https://example.com", "key": "user", "headers": map[string]any{"X": 1}},
+		{"url": "https://example.com", "key": "user", "timeout": "5m"},
+	} {
+		if msg := validateEnrich(models.CreateRelayActionInput{Config: config}); msg == "" {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestCreateRelayRejectsOptionalWhereUnsupported(t *testing.T) {
+	h := NewHandler(Deps{Logger: logger.New("hermes-core-test", "test", "error")})
+	for _, action := range []string{
+		`{"action_type": "aggregate", "optional": true, "config": {"max_events": 10, "window": "1m"}}`,
+		`{"action_type": "debug_log", "optional": true, "on_failure": true, "config": {}}`,
+	} {
+		body := `{"name": "r", "user_id": "u", "actions": [{"action_type": "debug_log", "config": {}}, ` + action + `]}`
+		rec := httptest.NewRecorder()
+		h.CreateRelay(rec, httptest.NewRequest(http.MethodPost, "/api/v1/relays", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "optional") {
+			t.Errorf("Expected %s to be rejected, got %d %s", action, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSearchRelayLogsRejectsBadQueries(t *testing.T) {
+	h := NewHandler(Deps{AuthDisabled: true, Logger: logger.New("hermes-core-test", "test", "error")})
+	router := NewRouter(h)
+	for _, query := range []string{"", "?q=", "?q=a..b%3D1", "?q=order_id%3D1&since=yesterday"} {
+		rec := httptest.NewRecorder()
+		url := "/api/v1/relays/5b1c1f4e-0d5c-4f43-9f1e-1b8a2c3d4e5f/logs/search" + query
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", query, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/export"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Log export storage used by the handlers, implemented by *store.ExportStore
+type LogExportStore interface {
+	CreateLogExport(ctx context.Context, req models.CreateLogExportRequest) (*models.LogExport, error)
+	ListLogExports(ctx context.Context, userID string) ([]models.LogExport, error)
+	DeleteLogExport(ctx context.Context, userID, id string) error
+}
+
+// Creates an export that ships the user's execution logs from now on
+func (h *Handler) CreateLogExport(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateLogExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if strings.TrimSpace(req.UserID) == "" || strings.TrimSpace(req.Name) == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id and name are required", "VALIDATION_ERROR")
+		return
+	}
+	if err := export.Validate(req.Sink, req.Config); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	if refs := secrets.References(req.Config); len(refs) > 0 {
+		missing, err := h.secrets.Missing(r.Context(), req.UserID, refs)
+		if err != nil {
+			h.logger.Error("failed to check secrets", logfields.UserID(req.UserID),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to check secrets", "DB_ERROR")
+			return
+		}
+		if len(missing) > 0 {
+			h.respondError(w, http.StatusBadRequest,
+				"Unknown secrets referenced: "+strings.Join(missing, ", "), "VALIDATION_ERROR")
+			return
+		}
+	}
+	created, err := h.logExports.CreateLogExport(r.Context(), req)
+	if err != nil {
+		h.logger.Error("failed to create log export", logfields.UserID(req.UserID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to create log export", "DB_ERROR")
+		return
+	}
+	h.logger.Info("log export created", logfields.UserID(req.UserID),
+		slog.String("export_id", created.ID),
+		slog.String("sink", created.Sink))
+	h.respondSuccess(w, http.StatusCreated, "Log export created", created)
+}
+
+func (h *Handler) ListLogExports(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	list, err := h.logExports.ListLogExports(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch log exports", logfields.UserID(userID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch log exports", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", list)
+}
+
+func (h *Handler) DeleteLogExport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.logExports.DeleteLogExport(r.Context(), userID, id); err != nil {
+		if errors.Is(err, store.ErrLogExportNotFound) {
+			h.respondError(w, http.StatusNotFound, "Log export not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete log export", logfields.UserID(userID),
+			slog.String("export_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete log export", "DB_ERROR")
+		return
+	}
+	h.logger.Info("log export deleted", logfields.UserID(userID), slog.String("export_id", id))
+	h.respondSuccess(w, http.StatusOK, "Log export deleted", nil)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeLogExports struct {
+	created []models.CreateLogExportRequest
+}
+
+func (f *fakeLogExports) CreateLogExport(_ context.Context, req models.CreateLogExportRequest) (*models.LogExport, error) {
+	f.created = append(f.created, req)
+	return &models.LogExport{ID: "export-1", UserID: req.UserID, Sink: req.Sink, Config: req.Config, IsActive: true}, nil
+}
+
+func (f *fakeLogExports) ListLogExports(context.Context, string) ([]models.LogExport, error) {
+	return nil, nil
+}
+
+func (f *fakeLogExports) DeleteLogExport(context.Context, string, string) error {
+	return store.ErrLogExportNotFound
+}
+
+func TestCreateLogExportValidates(t *testing.T) {
+	exports := &fakeLogExports{}
+	h := NewHandler(Deps{LogExports: exports, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Post("/log-exports", h.CreateLogExport)
+	r.Delete("/log-exports/{id}", h.DeleteLogExport)
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"webhook", `{"user_id":"u","name":"archive","sink":"webhook","config":{"url":"https://logs.example.com"}}`, http.StatusCreated},
+		{"no name", `{"user_id":"u","sink":"webhook","config":{"url":"https://logs.example.com"}}`, http.StatusBadRequest},
+		{"unknown sink", `{"user_id":"u","name":"x","sink":"kafka","config":{}}`, http.StatusBadRequest},
+		{"plaintext key", `{"user_id":"u","name":"x","sink":"s3","config":{"bucket":"logs","region":"us-east-1","access_key_id":"AKID","secret_access_key":"abc"}}`, http.StatusBadRequest},
+		{"bad json", `{`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/log-exports", bytes.NewBufferString(c.body)))
+		if rr.Code != c.want {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.want, rr.Code, rr.Body.String())
+		}
+	}
+	if len(exports.created) != 1 {
+		t.Errorf("Expected 1 export stored, got %d", len(exports.created))
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/log-exports/nope?user_id=u", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown export, got %d", rr.Code)
+	}
+}
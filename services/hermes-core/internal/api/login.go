@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// Login and session storage, implemented by *store.SessionStore
+type SessionStore interface {
+	SaveLoginState(ctx context.Context, state, verifier, nonce string) error
+	TakeLoginState(ctx context.Context, state string) (verifier, nonce string, err error)
+	UserForIdentity(ctx context.Context, id auth.Identity) (string, error)
+	CreateSession(ctx context.Context, userID string, ttl time.Duration) (string, time.Time, error)
+	SessionUser(ctx context.Context, token string) (string, error)
+	DeleteSession(ctx context.Context, token string) error
+}
+
+// Single sign-on through the identity provider. Sessions last SessionTTL and
+// are handed to the dashboard at DashboardURL
+type OIDCLogin struct {
+	Provider   *auth.Provider
+	SessionTTL time.Duration
+	// The callback redirects here with the session in the URL fragment, as
+	// #session=<token>&expires_at=<unix seconds>. Empty answers with JSON
+	DashboardURL string
+}
+
+// Starts a login, sending the browser to the identity provider
+func (h *Handler) StartLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		h.respondError(w, http.StatusNotFound, "Single sign-on is not configured", "NOT_FOUND")
+		return
+	}
+	state, verifier, nonce, err := auth.NewLogin()
+	if err == nil {
+		err = h.sessions.SaveLoginState(r.Context(), state, verifier, nonce)
+	}
+	if err != nil {
+		h.logger.Error("failed to start login", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to start login", "DB_ERROR")
+		return
+	}
+	target, err := h.oidc.Provider.AuthURL(r.Context(), state, verifier, nonce)
+	if err != nil {
+		h.logger.Error("failed to reach identity provider", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusBadGateway, "Identity provider unavailable", "IDP_UNAVAILABLE")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Where the identity provider sends the browser back. Exchanges the code,
+// signs the user in, creating them on first login, and issues a session
+func (h *Handler) LoginCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		h.respondError(w, http.StatusNotFound, "Single sign-on is not configured", "NOT_FOUND")
+		return
+	}
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		h.logger.Warn("identity provider refused login", slog.String("error", idpErr),
+			slog.String("description", q.Get("error_description")))
+		h.respondError(w, http.StatusUnauthorized, "Login refused by the identity provider: "+idpErr, "LOGIN_FAILED")
+		return
+	}
+	verifier, nonce, err := h.sessions.TakeLoginState(r.Context(), q.Get("state"))
+	if errors.Is(err, store.ErrLoginStateNotFound) {
+		h.respondError(w, http.StatusBadRequest, "Unknown or expired login, start again", "LOGIN_FAILED")
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to read login state", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to finish login", "DB_ERROR")
+		return
+	}
+	id, err := h.oidc.Provider.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, auth.ErrLoginFailed) {
+			h.logger.Warn("login failed", slog.String("error", err.Error()))
+			h.respondError(w, http.StatusUnauthorized, "Login failed", "LOGIN_FAILED")
+			return
+		}
+		h.logger.Error("failed to reach identity provider", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusBadGateway, "Identity provider unavailable", "IDP_UNAVAILABLE")
+		return
+	}
+	userID, err := h.sessions.UserForIdentity(r.Context(), *id)
+	if errors.Is(err, store.ErrAccountConflict) {
+		h.logger.Warn("login conflicts with an existing account", slog.String("subject", id.Subject),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusConflict, err.Error(), "ACCOUNT_CONFLICT")
+		return
+	}
+	var token string
+	var expiresAt time.Time
+	if err == nil {
+		token, expiresAt, err = h.sessions.CreateSession(r.Context(), userID, h.oidc.SessionTTL)
+	}
+	if err != nil {
+		h.logger.Error("failed to sign user in", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to finish login", "DB_ERROR")
+		return
+	}
+	h.logger.Info("user signed in", logfields.UserID(userID))
+	if h.oidc.DashboardURL == "" {
+		h.respondSuccess(w, http.StatusOK, "Signed in", models.Session{Token: token, UserID: userID, ExpiresAt: expiresAt})
+		return
+	}
+	// A fragment isn't sent to servers, so the token stays out of access logs
+	fragment := url.Values{"session": {token}, "expires_at": {strconv.FormatInt(expiresAt.Unix(), 10)}}
+	http.Redirect(w, r, h.oidc.DashboardURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// Ends the session the request was made with
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.sessions == nil || !strings.HasPrefix(token, store.SessionTokenPrefix) {
+		h.respondError(w, http.StatusBadRequest, "Session token required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.sessions.DeleteSession(r.Context(), token); err != nil {
+		h.logger.Error("failed to end session", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to sign out", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "Signed out", nil)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeSessionStore struct {
+	states   map[string]string
+	sessions map[string]string
+}
+
+func (f *fakeSessionStore) SaveLoginState(_ context.Context, state, verifier, _ string) error {
+	f.states[state] = verifier
+	return nil
+}
+
+func (f *fakeSessionStore) TakeLoginState(_ context.Context, state string) (string, string, error) {
+	verifier, ok := f.states[state]
+	if !ok {
+		return "", "", store.ErrLoginStateNotFound
+	}
+	delete(f.states, state)
+	return verifier, "", nil
+}
+
+func (f *fakeSessionStore) UserForIdentity(context.Context, auth.Identity) (string, error) {
+	return "u1", nil
+}
+
+func (f *fakeSessionStore) CreateSession(_ context.Context, userID string, ttl time.Duration) (string, time.Time, error) {
+	f.sessions["hss_new"] = userID
+	return "hss_new", time.Now().Add(ttl), nil
+}
+
+func (f *fakeSessionStore) SessionUser(_ context.Context, token string) (string, error) {
+	if userID, ok := f.sessions[token]; ok {
+		return userID, nil
+	}
+	return "", store.ErrInvalidSession
+}
+
+func (f *fakeSessionStore) DeleteSession(_ context.Context, token string) error {
+	delete(f.sessions, token)
+	return nil
+}
+
+func TestStartLoginRedirectsToProvider(t *testing.T) {
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint": idp.URL + "/token", "jwks_uri": idp.URL + "/jwks"})
+	}))
+	defer idp.Close()
+	sessions := &fakeSessionStore{states: map[string]string{}, sessions: map[string]string{}}
+	h := NewHandler(Deps{
+		OIDC: &OIDCLogin{Provider: auth.NewProvider(auth.OIDCConfig{Issuer: idp.URL, ClientID: "hermes",
+			RedirectURL: "https://hermes.example/callback"}), SessionTTL: time.Hour},
+		Sessions: sessions,
+		Logger:   logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.Get("/login", h.StartLogin)
+	r.Get("/callback", h.LoginCallback)
+
+	rr := serve(r, http.MethodGet, "/login")
+	if rr.Code != http.StatusFound || !strings.HasPrefix(rr.Header().Get("Location"), idp.URL+"/authorize?") {
+		t.Fatalf("Expected a redirect to the provider, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if len(sessions.states) != 1 {
+		t.Errorf("Expected the login remembered, got %v", sessions.states)
+	}
+	if rr := serve(r, http.MethodGet, "/callback?state=forged&code=x"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown state, got %d", rr.Code)
+	}
+	if rr := serve(r, http.MethodGet, "/callback?error=access_denied"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when the provider refused, got %d", rr.Code)
+	}
+}
+
+func TestUserAuthAcceptsSessions(t *testing.T) {
+	sessions := &fakeSessionStore{sessions: map[string]string{"hss_live": "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"}}
+	h := NewHandler(Deps{
+		Usage:    &fakeUsageStore{},
+		OIDC:     &OIDCLogin{SessionTTL: time.Hour},
+		Sessions: sessions,
+		Logger:   logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.With(h.UserAuth).Get("/usage", h.GetUsage)
+	r.Post("/logout", h.Logout)
+
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := call(http.MethodGet, "/usage", "hss_live"); code != http.StatusOK {
+		t.Errorf("Expected 200 with a live session, got %d", code)
+	}
+	if code := call(http.MethodGet, "/usage", "hss_forged"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown session, got %d", code)
+	}
+	if rr := serve(r, http.MethodGet, "/usage?user_id=5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected user_id alone no longer trusted once sign-on is set up, got %d", rr.Code)
+	}
+	if code := call(http.MethodPost, "/logout", "hss_live"); code != http.StatusOK {
+		t.Fatalf("Expected 200 signing out, got %d", code)
+	}
+	if code := call(http.MethodGet, "/usage", "hss_live"); code != http.StatusUnauthorized {
+		t.Errorf("Expected the session gone after signing out, got %d", code)
+	}
+}
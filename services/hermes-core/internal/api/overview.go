@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// Window the overview's execution counts cover
+const overviewWindow = 24 * time.Hour
+
+// Database totals behind the admin overview, implemented by *store.RelayStore
+type OverviewStore interface {
+	Overview(ctx context.Context, since time.Time) (*models.SystemOverview, error)
+}
+
+// Optionally implemented by the publisher: reads the workers' backlog from the broker
+type QueueLagReader interface {
+	QueueLag() (int, error)
+}
+
+func (h *Handler) SystemOverview(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	overview, err := h.overview.Overview(r.Context(), now.Add(-overviewWindow))
+	if err != nil {
+		h.logger.Error("failed to fetch overview", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch overview", "DB_ERROR")
+		return
+	}
+	workers, err := h.workers.ListWorkers(r.Context())
+	if err != nil {
+		h.logger.Error("failed to fetch workers", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch overview", "DB_ERROR")
+		return
+	}
+	// Every instance reads the same shared consumer, so the largest lag they
+	// reported stands in when the broker can't be asked
+	heartbeatLag := 0
+	for _, worker := range workers {
+		if worker.Status != store.WorkerStatusOnline && worker.Status != store.WorkerStatusDraining {
+			continue
+		}
+		overview.Workers++
+		heartbeatLag = max(heartbeatLag, worker.QueueLag)
+	}
+	overview.QueueLag = heartbeatLag
+	if reader, ok := h.publisher.(QueueLagReader); ok {
+		if lag, err := reader.QueueLag(); err == nil {
+			overview.QueueLag = lag
+		} else {
+			h.logger.Warn("failed to read queue lag from broker, using worker heartbeats",
+				slog.String("error", err.Error()))
+		}
+	}
+	if overview.Executions24h > 0 {
+		overview.FailureRate = float64(overview.FailedExecutions) / float64(overview.Executions24h)
+	}
+	overview.GeneratedAt = now.UTC()
+	h.respondSuccess(w, http.StatusOK, "", overview)
+}
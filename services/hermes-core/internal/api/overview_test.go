@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/migrate"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/canary"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeOverview struct{}
+
+func (fakeOverview) Overview(context.Context, time.Time) (*models.SystemOverview, error) {
+	return &models.SystemOverview{Relays: 5, ActiveRelays: 3, Executions24h: 40, FailedExecutions: 10, DLQDepth: 2}, nil
+}
+
+type fakeWorkers []models.WorkerInstance
+
+func (f fakeWorkers) ListWorkers(context.Context) ([]models.WorkerInstance, error) {
+	return f, nil
+}
+
+// A publisher that can also report the broker's backlog
+type lagPublisher struct {
+	fakePublisher
+	lag int
+	err error
+}
+
+func (p *lagPublisher) QueueLag() (int, error) { return p.lag, p.err }
+
+func getOverview(t *testing.T, pub EventPublisher) models.SystemOverview {
+	t.Helper()
+	h := NewHandler(Deps{
+		Overview:  &fakeOverview{},
+		Publisher: pub,
+		Workers: fakeWorkers{
+			{ID: "w1", Status: store.WorkerStatusOnline, QueueLag: 7},
+			{ID: "w2", Status: store.WorkerStatusDraining, QueueLag: 9},
+			{ID: "w3", Status: store.WorkerStatusOffline, QueueLag: 50},
+		},
+		Logger: logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.Get("/admin/overview", h.SystemOverview)
+	rr := serve(r, http.MethodGet, "/admin/overview")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data models.SystemOverview `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.Data
+}
+
+func TestSystemOverview(t *testing.T) {
+	got := getOverview(t, &lagPublisher{lag: 120})
+	if got.Relays != 5 || got.ActiveRelays != 3 || got.DLQDepth != 2 || got.Executions24h != 40 {
+		t.Errorf("Expected the store's counts, got %+v", got)
+	}
+	if got.FailureRate != 0.25 {
+		t.Errorf("Expected a failure rate of 0.25, got %v", got.FailureRate)
+	}
+	if got.Workers != 2 {
+		t.Errorf("Expected 2 live workers, got %d", got.Workers)
+	}
+	if got.QueueLag != 120 {
+		t.Errorf("Expected the broker's queue lag, got %d", got.QueueLag)
+	}
+}
+
+func TestSystemOverviewFallsBackToHeartbeatLag(t *testing.T) {
+	if got := getOverview(t, &lagPublisher{err: errors.New("nats: timeout")}); got.QueueLag != 9 {
+		t.Errorf("Expected the largest live heartbeat lag, got %d", got.QueueLag)
+	}
+	if got := getOverview(t, &fakePublisher{}); got.QueueLag != 9 {
+		t.Errorf("Expected heartbeat lag without a broker reader, got %d", got.QueueLag)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	fail := false
+	h := NewHandler(Deps{
+		Reload: func() error {
+			if fail {
+				return errors.New("EXECUTION_LOG_RETENTION must be 0 or at least 24h")
+			}
+			return nil
+		},
+		Logger: logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.Post("/admin/reload", h.ReloadConfig)
+	if rr := serve(r, http.MethodPost, "/admin/reload"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+	fail = true
+	rr := serve(r, http.MethodPost, "/admin/reload")
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "EXECUTION_LOG_RETENTION") {
+		t.Errorf("Expected 422 with the reason, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHealthReportsSchemaVersion(t *testing.T) {
+	h := NewHandler(Deps{
+		Schema: func(context.Context) (migrate.Status, error) {
+			return migrate.Status{Version: 30, Latest: 31}, nil
+		},
+		Logger: logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.Get("/health", h.HealthCheck)
+	rr := serve(r, http.MethodGet, "/health")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var resp struct {
+		Schema migrate.Status `json:"schema"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Schema.Version != 30 || resp.Schema.Latest != 31 || !resp.Schema.Pending() {
+		t.Errorf("Expected version 30 of 31, got %+v", resp.Schema)
+	}
+}
+
+func TestHealthDegradedWhileCanaryFails(t *testing.T) {
+	status := canary.Status{Runs: 4, Failures: 1, ConsecutiveFailures: 1, Error: "no worker ran the probe within 30s"}
+	h := NewHandler(Deps{
+		Canary: func() canary.Status { return status },
+		Logger: logger.New("hermes-core-test", "test", "error"),
+	})
+	r := chi.NewRouter()
+	r.Get("/health", h.HealthCheck)
+	rr := serve(r, http.MethodGet, "/health")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var resp struct {
+		Status string        `json:"status"`
+		Canary canary.Status `json:"canary"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Status != "degraded" || resp.Canary.Error == "" {
+		t.Errorf("Expected a degraded status with the canary's error, got %+v", resp)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxPluginBytes         = 10 << 20
+	defaultPluginMemoryMB  = 16
+	maxPluginMemoryMB      = 256
+	defaultPluginTimeoutMs = 5000
+	maxPluginTimeoutMs     = 60000
+)
+
+var (
+	pluginNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	wasmMagic         = []byte("\x00asm")
+)
+
+// Reads an optional positive int query param capped at limit. ok is false when the value is invalid
+func queryLimit(r *http.Request, key string, defaultValue, limit int) (int, bool) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return defaultValue, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 || value > limit {
+		return 0, false
+	}
+	return value, true
+}
+
+// Uploads or replaces a plugin. The body is the raw .wasm module, limits are
+// passed as ?memory_limit_mb= and ?timeout_ms=
+func (h *Handler) PutPlugin(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !pluginNamePattern.MatchString(name) {
+		h.respondError(w, http.StatusBadRequest,
+			"Plugin name must be lowercase letters, digits, - or _", "VALIDATION_ERROR")
+		return
+	}
+	memoryMB, ok := queryLimit(r, "memory_limit_mb", defaultPluginMemoryMB, maxPluginMemoryMB)
+	if !ok {
+		h.respondError(w, http.StatusBadRequest,
+			"memory_limit_mb must be between 1 and "+strconv.Itoa(maxPluginMemoryMB), "VALIDATION_ERROR")
+		return
+	}
+	timeoutMs, ok := queryLimit(r, "timeout_ms", defaultPluginTimeoutMs, maxPluginTimeoutMs)
+	if !ok {
+		h.respondError(w, http.StatusBadRequest,
+			"timeout_ms must be between 1 and "+strconv.Itoa(maxPluginTimeoutMs), "VALIDATION_ERROR")
+		return
+	}
+
+	module, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPluginBytes))
+	if err != nil {
+		h.respondError(w, http.StatusRequestEntityTooLarge, "Plugin module is too large", "PAYLOAD_TOO_LARGE")
+		return
+	}
+	if !bytes.HasPrefix(module, wasmMagic) {
+		h.respondError(w, http.StatusBadRequest, "Body must be a WASM module", "VALIDATION_ERROR")
+		return
+	}
+
+	plugin, err := h.plugins.Upsert(r.Context(), name, module, memoryMB, timeoutMs)
+	if err != nil {
+		h.logger.Error("failed to save plugin", slog.String("plugin", name),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to save plugin", "DB_ERROR")
+		return
+	}
+	h.logger.Info("plugin uploaded", slog.String("plugin", name),
+		slog.String("sha256", plugin.SHA256),
+		slog.Int("size_bytes", plugin.SizeBytes))
+	h.respondSuccess(w, http.StatusOK, "Plugin saved, workers load it within their sync interval", plugin)
+}
+
+func (h *Handler) ListPlugins(w http.ResponseWriter, r *http.Request) {
+	plugins, err := h.plugins.List(r.Context())
+	if err != nil {
+		h.logger.Error("failed to fetch plugins", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch plugins", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", plugins)
+}
+
+func (h *Handler) DeletePlugin(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := h.plugins.Delete(r.Context(), name); err != nil {
+		if errors.Is(err, store.ErrPluginNotFound) {
+			h.respondError(w, http.StatusNotFound, "Plugin not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete plugin", slog.String("plugin", name),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete plugin", "DB_ERROR")
+		return
+	}
+	h.logger.Info("plugin deleted", slog.String("plugin", name))
+	h.respondSuccess(w, http.StatusOK, "Plugin deleted", map[string]string{"deleted_name": name})
+}
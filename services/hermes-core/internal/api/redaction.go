@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/redact"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Redaction rule storage, implemented by *store.RedactionStore. An empty
+// userID stands for the global rules
+type RedactionStore interface {
+	CreateRedactionRule(ctx context.Context, req models.CreateRedactionRuleRequest) (*models.RedactionRule, error)
+	ListRedactionRules(ctx context.Context, userID string) ([]models.RedactionRule, error)
+	DeleteRedactionRule(ctx context.Context, userID, id string) error
+}
+
+func validateRedactionRule(req models.CreateRedactionRuleRequest) error {
+	rule := redact.Rule{Path: req.Path, Pattern: req.Pattern, Preset: req.Preset, Replacement: req.Replacement}
+	return rule.Validate()
+}
+
+// Adds a rule to the workspace, covering one relay when relay_id is set and
+// every relay otherwise
+func (h *Handler) CreateRedactionRule(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateRedactionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if uuid.Validate(req.UserID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	if err := validateRedactionRule(req); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	if req.RelayID != "" {
+		relay, err := h.store.GetRelay(r.Context(), req.RelayID)
+		if errors.Is(err, store.ErrRelayNotFound) || (err == nil && relay.UserID != req.UserID) {
+			h.respondError(w, http.StatusBadRequest, "Relay "+req.RelayID+" not found", "VALIDATION_ERROR")
+			return
+		}
+		if err != nil {
+			h.logger.Error("failed to fetch relay", logfields.RelayID(req.RelayID), slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to fetch relay", "DB_ERROR")
+			return
+		}
+	}
+	h.createRedactionRule(w, r, req)
+}
+
+func (h *Handler) ListRedactionRules(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	h.listRedactionRules(w, r, userID)
+}
+
+func (h *Handler) DeleteRedactionRule(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	h.deleteRedactionRule(w, r, userID)
+}
+
+// Adds a rule covering every workspace
+func (h *Handler) CreateGlobalRedactionRule(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateRedactionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	if req.UserID != "" || req.RelayID != "" {
+		h.respondError(w, http.StatusBadRequest, "Global rules can't name a user or relay", "VALIDATION_ERROR")
+		return
+	}
+	if err := validateRedactionRule(req); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	h.createRedactionRule(w, r, req)
+}
+
+func (h *Handler) ListGlobalRedactionRules(w http.ResponseWriter, r *http.Request) {
+	h.listRedactionRules(w, r, "")
+}
+
+func (h *Handler) DeleteGlobalRedactionRule(w http.ResponseWriter, r *http.Request) {
+	h.deleteRedactionRule(w, r, "")
+}
+
+func (h *Handler) createRedactionRule(w http.ResponseWriter, r *http.Request, req models.CreateRedactionRuleRequest) {
+	rule, err := h.redaction.CreateRedactionRule(r.Context(), req)
+	if err != nil {
+		h.logger.Error("failed to create redaction rule", logfields.UserID(req.UserID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to create redaction rule", "DB_ERROR")
+		return
+	}
+	h.logger.Info("redaction rule created", logfields.UserID(req.UserID),
+		logfields.RelayID(req.RelayID),
+		slog.String("rule_id", rule.ID))
+	h.respondSuccess(w, http.StatusCreated, "Redaction rule created", rule)
+}
+
+func (h *Handler) listRedactionRules(w http.ResponseWriter, r *http.Request, userID string) {
+	rules, err := h.redaction.ListRedactionRules(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch redaction rules", logfields.UserID(userID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch redaction rules", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", rules)
+}
+
+func (h *Handler) deleteRedactionRule(w http.ResponseWriter, r *http.Request, userID string) {
+	id := chi.URLParam(r, "id")
+	if err := h.redaction.DeleteRedactionRule(r.Context(), userID, id); err != nil {
+		if errors.Is(err, store.ErrRedactionRuleNotFound) {
+			h.respondError(w, http.StatusNotFound, "Redaction rule not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete redaction rule", logfields.UserID(userID),
+			slog.String("rule_id", id),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete redaction rule", "DB_ERROR")
+		return
+	}
+	h.logger.Info("redaction rule deleted", logfields.UserID(userID), slog.String("rule_id", id))
+	h.respondSuccess(w, http.StatusOK, "Redaction rule deleted", map[string]string{"deleted_id": id})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeRedactionRules struct {
+	created []models.CreateRedactionRuleRequest
+}
+
+func (f *fakeRedactionRules) CreateRedactionRule(_ context.Context, req models.CreateRedactionRuleRequest) (*models.RedactionRule, error) {
+	f.created = append(f.created, req)
+	return &models.RedactionRule{ID: "rule-1", UserID: req.UserID, Path: req.Path, Pattern: req.Pattern, Preset: req.Preset}, nil
+}
+
+func (f *fakeRedactionRules) ListRedactionRules(context.Context, string) ([]models.RedactionRule, error) {
+	return nil, nil
+}
+
+func (f *fakeRedactionRules) DeleteRedactionRule(context.Context, string, string) error {
+	return store.ErrRedactionRuleNotFound
+}
+
+func TestCreateRedactionRuleValidates(t *testing.T) {
+	rules := &fakeRedactionRules{}
+	h := NewHandler(Deps{Redaction: rules, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Post("/redaction-rules", h.CreateRedactionRule)
+	r.Post("/admin/redaction-rules", h.CreateGlobalRedactionRule)
+	r.Delete("/redaction-rules/{id}", h.DeleteRedactionRule)
+
+	user := `"user_id":"7b0d5f0e-8c1a-4c9e-9f3e-2f6d1b9a0c11"`
+	cases := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"preset", "/redaction-rules", `{` + user + `,"preset":"email"}`, http.StatusCreated},
+		{"path", "/redaction-rules", `{` + user + `,"path":"customer.card","replacement":"****"}`, http.StatusCreated},
+		{"global", "/admin/redaction-rules", `{"pattern":"tok_[a-z0-9]+"}`, http.StatusCreated},
+		{"two kinds", "/redaction-rules", `{` + user + `,"path":"a","preset":"card"}`, http.StatusBadRequest},
+		{"bad pattern", "/redaction-rules", `{` + user + `,"pattern":"(unclosed"}`, http.StatusBadRequest},
+		{"no user", "/redaction-rules", `{"preset":"email"}`, http.StatusBadRequest},
+		{"global naming a user", "/admin/redaction-rules", `{` + user + `,"preset":"email"}`, http.StatusBadRequest},
+		{"bad json", "/redaction-rules", `{`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, c.path, bytes.NewBufferString(c.body)))
+		if rr.Code != c.want {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.want, rr.Code, rr.Body.String())
+		}
+	}
+	if len(rules.created) != 3 || rules.created[2].UserID != "" {
+		t.Errorf("Expected 3 rules stored, the last global, got %+v", rules.created)
+	}
+
+	if rr := serve(r, http.MethodDelete, "/redaction-rules/nope?user_id=u"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown rule, got %d", rr.Code)
+	}
+}
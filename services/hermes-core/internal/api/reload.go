@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+)
+
+// Re-applies the reloadable settings, the same as sending the process SIGHUP
+func (h *Handler) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if h.reload == nil {
+		h.respondError(w, http.StatusNotFound, "Configuration reload is not available", "NOT_FOUND")
+		return
+	}
+	if err := h.reload(); err != nil {
+		h.respondError(w, http.StatusUnprocessableEntity, "Reload failed: "+err.Error(), "VALIDATION_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "Configuration reloaded", nil)
+}
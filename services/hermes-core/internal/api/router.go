@@ -1,6 +1,8 @@
 package api
 
 import (
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/profiling"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -12,25 +14,135 @@ func NewRouter(h *Handler) *chi.Mux {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	if h.metrics != nil {
+		r.Use(h.metrics.Middleware)
+	}
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"}, // Will change to frontend url
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", WorkspaceHeader},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	r.Get("/health", h.HealthCheck)
+	if h.metrics != nil {
+		r.Handle("/metrics", h.metrics.Handler())
+	}
+	if h.debugToken != "" {
+		r.Handle("/debug/*", profiling.Handler(h.debugToken))
+	}
 
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Post("/relays", h.CreateRelay)
-		r.Get("/relays", h.GetAllRelays)
-		r.Get("/relays/{id}", h.GetRelay)
-		r.Put("/relays/{id}", h.UpdateRelay)
-		r.Delete("/relays/{id}", h.DeleteRelay)
-		r.Get("/relays/{id}/logs", h.GetRelayLogs)
+		r.Get("/auth/oidc/login", h.StartLogin)
+		r.Get("/auth/oidc/callback", h.LoginCallback)
+		r.Post("/auth/logout", h.Logout)
+		r.Get("/connections/callback", h.ConnectionCallback)
+		r.Group(func(r chi.Router) {
+			r.Use(h.UserAuth)
+			r.Use(h.Workspace)
+			// Every member reads, changes need a role that writes. API keys
+			// only reach routes naming a scope they hold
+			read := h.Require(auth.PermRead, "")
+			write := h.Require(auth.PermWrite, "")
+			readRelays := h.Require(auth.PermRead, auth.ScopeRelaysRead)
+			writeRelays := h.Require(auth.PermWrite, auth.ScopeRelaysWrite)
+			readLogs := h.Require(auth.PermRead, auth.ScopeLogsRead)
+			r.With(writeRelays).Post("/relays", h.CreateRelay)
+			r.With(readRelays).Get("/relays", h.GetAllRelays)
+			r.Group(func(r chi.Router) {
+				r.Use(h.RelayOwner)
+				r.With(readRelays).Get("/relays/{id}", h.GetRelay)
+				r.With(writeRelays).Put("/relays/{id}", h.UpdateRelay)
+				r.With(writeRelays).Delete("/relays/{id}", h.DeleteRelay)
+				r.With(h.Require(auth.PermWrite, auth.ScopeRelaysTrigger)).Post("/relays/{id}/test", h.TestRelay)
+				r.With(readLogs).Get("/relays/{id}/logs", h.GetRelayLogs)
+				r.With(readLogs).Get("/relays/{id}/logs/search", h.SearchRelayLogs)
+				r.With(readLogs).Get("/relays/{id}/deliveries", h.ListRelayDeliveries)
+				r.With(writeRelays).Delete("/relays/{id}/executions/{executionID}", h.CancelExecution)
+				r.With(writeRelays).Post("/relays/{id}/alerts", h.CreateAlertRule)
+				r.With(readRelays).Get("/relays/{id}/alerts", h.ListAlertRules)
+				r.With(writeRelays).Delete("/relays/{id}/alerts/{ruleID}", h.DeleteAlertRule)
+				r.With(writeRelays).Post("/relays/{id}/signing-key", h.RotateSigningKey)
+				r.With(writeRelays).Delete("/relays/{id}/signing-key", h.DeleteSigningKey)
+			})
+
+			r.With(read).Get("/dead-letters", h.ListDeadLetters)
+			r.With(write).Delete("/dead-letters", h.PurgeDeadLetters)
+			r.With(read).Get("/dead-letters/{id}", h.GetDeadLetter)
+			r.With(write).Post("/dead-letters/{id}/requeue", h.RequeueDeadLetter)
+			r.With(write).Delete("/dead-letters/{id}", h.DeleteDeadLetter)
+
+			r.With(read).Get("/plugins", h.ListPlugins)
+
+			r.With(read).Get("/secrets", h.ListSecrets)
+			r.With(write).Post("/secrets", h.CreateSecret)
+			r.With(write).Put("/secrets/{name}", h.PutSecret)
+			r.With(write).Delete("/secrets/{name}", h.DeleteSecret)
+
+			r.With(read).Get("/connections", h.ListConnections)
+			r.With(write).Post("/connections/{provider}/authorize", h.AuthorizeConnection)
+			r.With(write).Delete("/connections/{id}", h.DeleteConnection)
+
+			r.With(read).Get("/redaction-rules", h.ListRedactionRules)
+			r.With(write).Post("/redaction-rules", h.CreateRedactionRule)
+			r.With(write).Delete("/redaction-rules/{id}", h.DeleteRedactionRule)
+
+			r.With(write).Post("/log-exports", h.CreateLogExport)
+			r.With(read).Get("/log-exports", h.ListLogExports)
+			r.With(write).Delete("/log-exports/{id}", h.DeleteLogExport)
+
+			r.With(read).Get("/flags", h.EvaluateFeatureFlags)
+			r.With(read).Get("/usage", h.GetUsage)
+
+			manage := h.Require(auth.PermManage, "")
+			r.With(read).Get("/workspace/members", h.ListWorkspaceMembers)
+			r.With(manage).Put("/workspace/members/{memberID}", h.PutWorkspaceMember)
+			r.With(manage).Delete("/workspace/members/{memberID}", h.DeleteWorkspaceMember)
+
+			r.With(manage).Post("/api-keys", h.CreateAPIKey)
+			r.With(manage).Get("/api-keys", h.ListAPIKeys)
+			r.With(manage).Delete("/api-keys/{id}", h.DeleteAPIKey)
+
+			r.With(manage).Post("/data-requests", h.CreateDataRequest)
+			r.With(manage).Get("/data-requests", h.ListDataRequests)
+			r.With(manage).Get("/data-requests/{id}", h.GetDataRequest)
+			r.With(manage).Get("/data-requests/{id}/export", h.DownloadDataExport)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.AdminAuth)
+			// Plugins run code inside every worker
+			r.Put("/plugins/{name}", h.PutPlugin)
+			r.Delete("/plugins/{name}", h.DeletePlugin)
+			r.Get("/agents", h.ListAgents)
+			r.Delete("/agents/{id}", h.RevokeAgent)
+			r.Post("/agents/enrollment-tokens", h.CreateEnrollmentToken)
+			r.Get("/admin/workers", h.ListWorkers)
+			r.Get("/admin/overview", h.SystemOverview)
+			r.Post("/admin/reload", h.ReloadConfig)
+			r.Get("/admin/flags", h.ListFeatureFlags)
+			r.Put("/admin/flags/{name}", h.PutFeatureFlag)
+			r.Delete("/admin/flags/{name}", h.DeleteFeatureFlag)
+			r.Put("/admin/flags/{name}/users/{userID}", h.PutFlagOverride)
+			r.Delete("/admin/flags/{name}/users/{userID}", h.DeleteFlagOverride)
+			r.Get("/admin/redaction-rules", h.ListGlobalRedactionRules)
+			r.Post("/admin/redaction-rules", h.CreateGlobalRedactionRule)
+			r.Delete("/admin/redaction-rules/{id}", h.DeleteGlobalRedactionRule)
+			r.Post("/admin/data-requests", h.CreateAdminDataRequest)
+			r.Get("/admin/data-requests", h.ListAdminDataRequests)
+			r.Get("/admin/data-requests/{id}", h.GetAdminDataRequest)
+			r.Get("/admin/data-requests/{id}/export", h.DownloadAdminDataExport)
+		})
+		r.Post("/agents/enroll", h.EnrollAgent)
+		r.Group(func(r chi.Router) {
+			r.Use(h.AgentAuth)
+			r.Post("/agents/heartbeat", h.AgentHeartbeat)
+			r.Post("/agents/poll", h.PollAgentJob)
+			r.Post("/agents/jobs/{id}/result", h.ReportAgentJobResult)
+		})
 	})
 	return r
 }
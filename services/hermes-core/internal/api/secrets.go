@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxSecretBytes = 16 * 1024
+
+// Creates a secret, 409 when the name is taken. Actions reference it as {{secret:NAME}}
+func (h *Handler) CreateSecret(w http.ResponseWriter, r *http.Request) {
+	var req models.PutSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	h.saveSecret(w, r, req, h.secrets.Create)
+}
+
+// Creates or replaces a secret. Actions reference it as {{secret:NAME}}
+func (h *Handler) PutSecret(w http.ResponseWriter, r *http.Request) {
+	var req models.PutSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	req.Name = chi.URLParam(r, "name")
+	h.saveSecret(w, r, req, h.secrets.Put)
+}
+
+func (h *Handler) saveSecret(w http.ResponseWriter, r *http.Request, req models.PutSecretRequest,
+	save func(ctx context.Context, req models.PutSecretRequest) (*models.Secret, error)) {
+	name := req.Name
+	if !secrets.NamePattern.MatchString(name) {
+		h.respondError(w, http.StatusBadRequest,
+			"Secret name must be upper case letters, digits and _, starting with a letter", "VALIDATION_ERROR")
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.callerID(w, r, req.UserID); !ok {
+		return
+	}
+	if strings.TrimSpace(req.UserID) == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	switch req.Backend {
+	case "", secrets.BackendHermes:
+		if req.Value == "" || len(req.Value) > maxSecretBytes {
+			h.respondError(w, http.StatusBadRequest, "value must be between 1 byte and 16KB", "VALIDATION_ERROR")
+			return
+		}
+	case secrets.BackendVault, secrets.BackendAWS:
+		if req.Value != "" || req.Reference == "" || len(req.Reference) > 1024 {
+			h.respondError(w, http.StatusBadRequest,
+				"Secrets kept in "+req.Backend+" take a reference of at most 1KB instead of a value", "VALIDATION_ERROR")
+			return
+		}
+	default:
+		h.respondError(w, http.StatusBadRequest, "backend must be hermes, vault or aws-secrets-manager", "VALIDATION_ERROR")
+		return
+	}
+	secret, err := save(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, secrets.ErrBackendNotConfigured) {
+			h.respondError(w, http.StatusBadRequest, "The "+req.Backend+" backend is not set up on this instance", "VALIDATION_ERROR")
+			return
+		}
+		if errors.Is(err, secrets.ErrNoKey) {
+			h.respondError(w, http.StatusServiceUnavailable, "Secrets are disabled, set SECRETS_KEY or SECRETS_KMS_KEY_ID", "SECRETS_DISABLED")
+			return
+		}
+		if errors.Is(err, store.ErrSecretExists) {
+			h.respondError(w, http.StatusConflict, "A secret with this name already exists", "SECRET_EXISTS")
+			return
+		}
+		h.logger.Error("failed to save secret", logfields.UserID(req.UserID),
+			slog.String("name", name),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to save secret", "DB_ERROR")
+		return
+	}
+	h.logger.Info("secret saved", logfields.UserID(req.UserID), slog.String("name", name))
+	h.respondSuccess(w, http.StatusOK, "Secret saved", secret)
+}
+
+func (h *Handler) ListSecrets(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	list, err := h.secrets.List(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to fetch secrets", logfields.UserID(userID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch secrets", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", list)
+}
+
+func (h *Handler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if userID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.secrets.Delete(r.Context(), userID, name); err != nil {
+		if errors.Is(err, secrets.ErrNotFound) {
+			h.respondError(w, http.StatusNotFound, "Secret not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to delete secret", logfields.UserID(userID),
+			slog.String("name", name),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete secret", "DB_ERROR")
+		return
+	}
+	h.logger.Info("secret deleted", logfields.UserID(userID), slog.String("name", name))
+	h.respondSuccess(w, http.StatusOK, "Secret deleted", map[string]string{"deleted_name": name})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/signing"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Gives the relay a new signing key, its actions sign their requests with it
+// from then on. The key is only shown in this response
+func (h *Handler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	key, err := h.store.RotateSigningKey(r.Context(), relayID)
+	switch {
+	case errors.Is(err, store.ErrRelayNotFound):
+		h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
+		return
+	case errors.Is(err, secrets.ErrNoKey):
+		h.respondError(w, http.StatusServiceUnavailable,
+			"Request signing is disabled, set SECRETS_KEY or SECRETS_KMS_KEY_ID", "SECRETS_DISABLED")
+		return
+	case err != nil:
+		h.logger.Error("failed to rotate signing key", logfields.RelayID(relayID), slog.String("err", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to rotate signing key", "DB_ERROR")
+		return
+	}
+	h.logger.Info("relay signing key rotated", logfields.RelayID(relayID))
+	h.respondSuccess(w, http.StatusOK, "Signing key rotated, it won't be shown again", map[string]string{
+		"signing_key":      key,
+		"signature_header": signing.SignatureHeader,
+		"timestamp_header": signing.TimestampHeader,
+	})
+}
+
+// Stops signing the relay's requests
+func (h *Handler) DeleteSigningKey(w http.ResponseWriter, r *http.Request) {
+	relayID := chi.URLParam(r, "id")
+	err := h.store.DeleteSigningKey(r.Context(), relayID)
+	if errors.Is(err, store.ErrRelayNotFound) {
+		h.respondError(w, http.StatusNotFound, "Relay not found", "NOT_FOUND")
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to delete signing key", logfields.RelayID(relayID), slog.String("err", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to delete signing key", "DB_ERROR")
+		return
+	}
+	h.logger.Info("relay signing key deleted", logfields.RelayID(relayID))
+	h.respondSuccess(w, http.StatusOK, "Signing key deleted", nil)
+}
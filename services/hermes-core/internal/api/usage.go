@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/google/uuid"
+)
+
+// Days GET /api/v1/usage covers by default, and at most
+const (
+	defaultUsageDays = 30
+	maxUsageDays     = 366
+)
+
+// Usage rollup reads, implemented by *store.UsageStore
+type UsageStore interface {
+	UserUsage(ctx context.Context, userID string, from, to time.Time) ([]models.DailyUsage, error)
+}
+
+// A user's billable usage per day with totals. from and to are inclusive
+// YYYY-MM-DD days, defaulting to the last 30 days. Days without usage are
+// left out, and the current day grows until it is closed
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if uuid.Validate(userID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		var err error
+		if to, err = time.Parse(store.UsageDayLayout, toStr); err != nil {
+			h.respondError(w, http.StatusBadRequest, "to must be a YYYY-MM-DD day", "VALIDATION_ERROR")
+			return
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultUsageDays)
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		var err error
+		if from, err = time.Parse(store.UsageDayLayout, fromStr); err != nil {
+			h.respondError(w, http.StatusBadRequest, "from must be a YYYY-MM-DD day", "VALIDATION_ERROR")
+			return
+		}
+	}
+	if from.After(to) || to.Sub(from) >= maxUsageDays*24*time.Hour {
+		h.respondError(w, http.StatusBadRequest, "from must be on or before to, at most 366 days apart", "VALIDATION_ERROR")
+		return
+	}
+	days, err := h.usage.UserUsage(r.Context(), userID, from, to)
+	if err != nil {
+		h.logger.Error("failed to fetch usage", logfields.UserID(userID), slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch usage", "DB_ERROR")
+		return
+	}
+	report := models.UsageReport{
+		UserID: userID,
+		From:   from.Format(store.UsageDayLayout),
+		To:     to.Format(store.UsageDayLayout),
+		Days:   days,
+	}
+	for _, day := range days {
+		report.Totals.Add(day.UsageCounts)
+	}
+	h.respondSuccess(w, http.StatusOK, "", report)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeUsageStore struct {
+	from, to time.Time
+}
+
+func (f *fakeUsageStore) UserUsage(_ context.Context, userID string, from, to time.Time) ([]models.DailyUsage, error) {
+	f.from, f.to = from, to
+	return []models.DailyUsage{
+		{UserID: userID, Day: "2026-10-14", UsageCounts: models.UsageCounts{EventsIngested: 10, BytesIngested: 2048, Executions: 9, ActionInvocations: 18}},
+		{UserID: userID, Day: "2026-10-15", UsageCounts: models.UsageCounts{EventsIngested: 5, BytesIngested: 1024, Executions: 5, ActionInvocations: 10}},
+	}, nil
+}
+
+func TestGetUsageTotalsDays(t *testing.T) {
+	const userID = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+	usage := &fakeUsageStore{}
+	h := NewHandler(Deps{Usage: usage, Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Get("/usage", h.GetUsage)
+
+	rr := serve(r, http.MethodGet, "/usage?user_id="+userID+"&to=2026-10-15")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data models.UsageReport `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := models.UsageCounts{EventsIngested: 15, BytesIngested: 3072, Executions: 14, ActionInvocations: 28}
+	if resp.Data.Totals != want || len(resp.Data.Days) != 2 {
+		t.Errorf("Expected totals %+v over 2 days, got %+v", want, resp.Data)
+	}
+	if resp.Data.From != "2026-09-16" || usage.to.Format("2006-01-02") != "2026-10-15" {
+		t.Errorf("Expected the 30 days through the 15th, got %s to %s", resp.Data.From, resp.Data.To)
+	}
+
+	for _, query := range []string{
+		"",
+		"?user_id=someone",
+		"?user_id=" + userID + "&from=2026-10-16&to=2026-10-15",
+		"?user_id=" + userID + "&from=2025-01-01&to=2026-10-15",
+		"?user_id=" + userID + "&from=15/10/2026",
+	} {
+		if rr := serve(r, http.MethodGet, "/usage"+query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
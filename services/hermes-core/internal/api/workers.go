@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+// Heartbeats written by worker instances
+type WorkerStore interface {
+	ListWorkers(ctx context.Context) ([]models.WorkerInstance, error)
+}
+
+func (h *Handler) ListWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := h.workers.ListWorkers(r.Context())
+	if err != nil {
+		h.logger.Error("failed to fetch workers", slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch workers", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", workers)
+}
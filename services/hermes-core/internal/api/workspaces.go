@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Names the workspace a request acts in, the caller's own when left out
+const WorkspaceHeader = "X-Workspace-ID"
+
+// Workspace membership storage, implemented by *store.WorkspaceStore
+type WorkspaceStore interface {
+	MemberRole(ctx context.Context, workspaceID, userID string) (auth.Role, error)
+	ListMembers(ctx context.Context, workspaceID string) ([]models.WorkspaceMember, error)
+	PutMember(ctx context.Context, workspaceID, userID string, role auth.Role) (*models.WorkspaceMember, error)
+	DeleteMember(ctx context.Context, workspaceID, userID string) error
+}
+
+// Resolves the workspace an authenticated caller acts in and their role
+// there. Callers outside it get 403
+func (h *Handler) Workspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, authenticated := auth.UserFrom(r.Context())
+		if !authenticated {
+			next.ServeHTTP(w, r)
+			return
+		}
+		workspaceID := r.Header.Get(WorkspaceHeader)
+		if workspaceID == "" || workspaceID == userID {
+			next.ServeHTTP(w, r.WithContext(auth.WithWorkspace(r.Context(), userID, auth.RoleOwner)))
+			return
+		}
+		// API keys stay in the workspace they were created in
+		if _, isKey := auth.KeyGrantFrom(r.Context()); isKey {
+			h.respondError(w, http.StatusForbidden, "API keys only act in their own workspace", "FORBIDDEN")
+			return
+		}
+		if h.workspaces == nil {
+			h.respondError(w, http.StatusForbidden, "Not a member of this workspace", "FORBIDDEN")
+			return
+		}
+		role, err := h.workspaces.MemberRole(r.Context(), workspaceID, userID)
+		if errors.Is(err, store.ErrNotMember) {
+			h.respondError(w, http.StatusForbidden, "Not a member of this workspace", "FORBIDDEN")
+			return
+		}
+		if err != nil {
+			h.logger.Error("failed to look up workspace role", logfields.UserID(userID),
+				slog.String("workspace_id", workspaceID),
+				slog.String("error", err.Error()))
+			h.respondError(w, http.StatusInternalServerError, "Failed to authorize", "DB_ERROR")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithWorkspace(r.Context(), workspaceID, role)))
+	})
+}
+
+// Lets the request through only when the caller's role grants perm, and for
+// API keys when the key holds scope. Keys are refused where scope is empty.
+// Without authentication there's nothing to check
+func (h *Handler) Require(perm auth.Permission, scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, role, ok := auth.WorkspaceFrom(r.Context()); ok && !role.Can(perm) {
+				h.respondError(w, http.StatusForbidden, "Your role in this workspace doesn't allow this", "FORBIDDEN")
+				return
+			}
+			if grant, ok := auth.KeyGrantFrom(r.Context()); ok && !grant.Has(scope) {
+				h.respondError(w, http.StatusForbidden, "API key lacks the scope for this", "FORBIDDEN")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h *Handler) ListWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if uuid.Validate(workspaceID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id must be a UUID", "VALIDATION_ERROR")
+		return
+	}
+	members, err := h.workspaces.ListMembers(r.Context(), workspaceID)
+	if err != nil {
+		h.logger.Error("failed to fetch workspace members", slog.String("workspace_id", workspaceID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to fetch members", "DB_ERROR")
+		return
+	}
+	h.respondSuccess(w, http.StatusOK, "", members)
+}
+
+// Adds a member or changes their role
+func (h *Handler) PutWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	memberID := chi.URLParam(r, "memberID")
+	var req models.PutWorkspaceMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid JSON body", "INVALID_JSON")
+		return
+	}
+	workspaceID, ok := h.callerID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	if uuid.Validate(workspaceID) != nil || uuid.Validate(memberID) != nil {
+		h.respondError(w, http.StatusBadRequest, "user_id and the member ID must be UUIDs", "VALIDATION_ERROR")
+		return
+	}
+	if memberID == workspaceID {
+		h.respondError(w, http.StatusBadRequest, "The owner can't be made a member of their own workspace", "VALIDATION_ERROR")
+		return
+	}
+	role := auth.Role(req.Role)
+	if !slices.Contains(auth.MemberRoles, role) {
+		h.respondError(w, http.StatusBadRequest, "role must be admin, editor or viewer", "VALIDATION_ERROR")
+		return
+	}
+	member, err := h.workspaces.PutMember(r.Context(), workspaceID, memberID, role)
+	if errors.Is(err, store.ErrUserNotFound) {
+		h.respondError(w, http.StatusNotFound, "User not found", "NOT_FOUND")
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to save workspace member", slog.String("workspace_id", workspaceID),
+			logfields.UserID(memberID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to save member", "DB_ERROR")
+		return
+	}
+	h.logger.Info("workspace member saved", slog.String("workspace_id", workspaceID),
+		logfields.UserID(memberID), slog.String("role", req.Role))
+	h.respondSuccess(w, http.StatusOK, "Member saved", member)
+}
+
+func (h *Handler) DeleteWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	memberID := chi.URLParam(r, "memberID")
+	workspaceID, ok := h.callerID(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	if workspaceID == "" {
+		h.respondError(w, http.StatusBadRequest, "user_id is required", "VALIDATION_ERROR")
+		return
+	}
+	if err := h.workspaces.DeleteMember(r.Context(), workspaceID, memberID); err != nil {
+		if errors.Is(err, store.ErrNotMember) {
+			h.respondError(w, http.StatusNotFound, "Member not found", "NOT_FOUND")
+			return
+		}
+		h.logger.Error("failed to remove workspace member", slog.String("workspace_id", workspaceID),
+			logfields.UserID(memberID),
+			slog.String("error", err.Error()))
+		h.respondError(w, http.StatusInternalServerError, "Failed to remove member", "DB_ERROR")
+		return
+	}
+	h.logger.Info("workspace member removed", slog.String("workspace_id", workspaceID), logfields.UserID(memberID))
+	h.respondSuccess(w, http.StatusOK, "Member removed", map[string]string{"deleted_user_id": memberID})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeWorkspaceStore struct {
+	// workspace ID, then member ID
+	roles map[string]map[string]auth.Role
+}
+
+func (f *fakeWorkspaceStore) MemberRole(_ context.Context, workspaceID, userID string) (auth.Role, error) {
+	if role, ok := f.roles[workspaceID][userID]; ok {
+		return role, nil
+	}
+	return "", store.ErrNotMember
+}
+
+func (f *fakeWorkspaceStore) ListMembers(context.Context, string) ([]models.WorkspaceMember, error) {
+	return nil, nil
+}
+
+func (f *fakeWorkspaceStore) PutMember(_ context.Context, workspaceID, userID string, role auth.Role) (*models.WorkspaceMember, error) {
+	f.roles[workspaceID][userID] = role
+	return &models.WorkspaceMember{WorkspaceID: workspaceID, UserID: userID, Role: string(role)}, nil
+}
+
+func (f *fakeWorkspaceStore) DeleteMember(_ context.Context, workspaceID, userID string) error {
+	delete(f.roles[workspaceID], userID)
+	return nil
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	const (
+		owner  = "5f0c6a4e-1b2d-4c3e-8f9a-0b1c2d3e4f5a"
+		admin  = "6a1d7b5f-2c3e-4d4f-9a0b-1c2d3e4f5a6b"
+		viewer = "7b2e8c6a-3d4f-4e5a-8b1c-2d3e4f5a6b7c"
+		editor = "8c3f9d7b-4e5a-4f6b-9c2d-3e4f5a6b7c8d"
+	)
+	verifier, issue := testVerifier(t)
+	workspaces := &fakeWorkspaceStore{roles: map[string]map[string]auth.Role{
+		owner: {admin: auth.RoleAdmin, viewer: auth.RoleViewer},
+	}}
+	h := NewHandler(Deps{Usage: &fakeUsageStore{}, Workspaces: workspaces, Auth: verifier,
+		Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Use(h.UserAuth, h.Workspace)
+	r.Get("/usage", h.GetUsage)
+	r.With(h.Require(auth.PermManage, "")).Put("/workspace/members/{memberID}", h.PutWorkspaceMember)
+
+	call := func(method, path, caller, workspace, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+issue(caller))
+		if workspace != "" {
+			req.Header.Set(WorkspaceHeader, workspace)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := call(http.MethodGet, "/usage", viewer, owner, ""); code != http.StatusOK {
+		t.Errorf("Expected a viewer to read the workspace, got %d", code)
+	}
+	if code := call(http.MethodGet, "/usage", editor, owner, ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 before being let in, got %d", code)
+	}
+	if code := call(http.MethodPut, "/workspace/members/"+editor, viewer, owner, `{"role":"editor"}`); code != http.StatusForbidden {
+		t.Errorf("Expected a viewer unable to manage members, got %d", code)
+	}
+	if code := call(http.MethodPut, "/workspace/members/"+editor, admin, owner, `{"role":"owner"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 granting ownership, got %d", code)
+	}
+	if code := call(http.MethodPut, "/workspace/members/"+editor, admin, owner, `{"role":"editor"}`); code != http.StatusOK {
+		t.Fatalf("Expected an admin to add members, got %d", code)
+	}
+	if code := call(http.MethodGet, "/usage", editor, owner, ""); code != http.StatusOK {
+		t.Errorf("Expected the new editor let in, got %d", code)
+	}
+	// Without the header everyone acts in their own workspace
+	if code := call(http.MethodGet, "/usage?user_id="+owner, viewer, "", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 naming another workspace only through user_id, got %d", code)
+	}
+}
+
+func TestRequireLetsViewersOnlyRead(t *testing.T) {
+	h := NewHandler(Deps{Logger: logger.New("hermes-core-test", "test", "error")})
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(auth.WithWorkspace(req.Context(), "w1", auth.RoleViewer)))
+		})
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.With(h.Require(auth.PermRead, "")).Get("/relays", ok)
+	r.With(h.Require(auth.PermWrite, "")).Post("/relays", ok)
+
+	if rr := serve(r, http.MethodGet, "/relays"); rr.Code != http.StatusOK {
+		t.Errorf("Expected a viewer to list relays, got %d", rr.Code)
+	}
+	if rr := serve(r, http.MethodPost, "/relays"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a viewer refused creating relays, got %d", rr.Code)
+	}
+}
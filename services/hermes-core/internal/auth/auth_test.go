@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(body)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(signature)
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		}})
+	}))
+	defer srv.Close()
+	v := NewVerifier(Config{Issuer: "https://id.example", JWKSURL: srv.URL, Audience: "hermes"})
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"iss": "https://id.example", "aud": []string{"hermes", "other"}, "sub": "u1",
+			"exp": time.Now().Add(time.Hour).Unix()}
+		for k, val := range overrides {
+			c[k] = val
+		}
+		return c
+	}
+	for _, token := range []string{
+		sign(t, "RS256", "rsa1", rsaKey, claims(nil)),
+		sign(t, "ES256", "ec1", ecKey, claims(map[string]any{"aud": "hermes"})),
+	} {
+		user, err := v.Verify(context.Background(), token)
+		if err != nil || user != "u1" {
+			t.Errorf("Expected u1, got %q (%v)", user, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the keys fetched once, got %d", fetches)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	valid := sign(t, "RS256", "rsa1", rsaKey, claims(nil))
+	for name, token := range map[string]string{
+		"expired":      sign(t, "RS256", "rsa1", rsaKey, claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"issuer":       sign(t, "RS256", "rsa1", rsaKey, claims(map[string]any{"iss": "https://evil.example"})),
+		"audience":     sign(t, "RS256", "rsa1", rsaKey, claims(map[string]any{"aud": "other"})),
+		"no subject":   sign(t, "RS256", "rsa1", rsaKey, claims(map[string]any{"sub": ""})),
+		"wrong key":    sign(t, "RS256", "rsa1", otherKey, claims(nil)),
+		"key mismatch": sign(t, "ES256", "rsa1", ecKey, claims(nil)),
+		"unknown kid":  sign(t, "RS256", "rsa2", rsaKey, claims(nil)),
+		"tampered":     valid[:strings.Index(valid, ".")+1] + b64([]byte(`{"sub":"u2"}`)) + valid[strings.LastIndex(valid, "."):],
+		"alg none":     b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"u1"}`)) + ".",
+		"malformed":    "not-a-jwt",
+	} {
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyReportsUnreachableIssuer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	v := NewVerifier(Config{Issuer: "https://id.example", JWKSURL: srv.URL})
+	token := sign(t, "RS256", "rsa1", key, map[string]any{"iss": "https://id.example", "sub": "u1", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := v.Verify(context.Background(), token); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a fetch error rather than a rejected token, got %v", err)
+	}
+}
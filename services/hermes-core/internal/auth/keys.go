@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// What an API key may reach
+type Scope string
+
+const (
+	ScopeRelaysRead  Scope = "relays:read"
+	ScopeRelaysWrite Scope = "relays:write"
+	// Send test events through a relay
+	ScopeRelaysTrigger Scope = "relays:trigger"
+	ScopeLogsRead      Scope = "logs:read"
+)
+
+var Scopes = []Scope{ScopeRelaysRead, ScopeRelaysWrite, ScopeRelaysTrigger, ScopeLogsRead}
+
+// What the API key a request came with was granted
+type KeyGrant struct {
+	Scopes []Scope
+	// The only relays the key reaches, any when empty
+	RelayIDs []string
+}
+
+func (g *KeyGrant) Has(scope Scope) bool {
+	return scope != "" && slices.Contains(g.Scopes, scope)
+}
+
+func (g *KeyGrant) AllowsRelay(relayID string) bool {
+	return len(g.RelayIDs) == 0 || slices.Contains(g.RelayIDs, relayID)
+}
+
+type keyGrantKey struct{}
+
+// Marks the request as made with an API key
+func WithKeyGrant(ctx context.Context, grant *KeyGrant) context.Context {
+	return context.WithValue(ctx, keyGrantKey{}, grant)
+}
+
+// The grant of the API key the request came with, ok is false for any other
+// kind of caller
+func KeyGrantFrom(ctx context.Context) (*KeyGrant, bool) {
+	grant, ok := ctx.Value(keyGrantKey{}).(*KeyGrant)
+	return grant, ok
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned when the identity provider refused the login or sent back
+// something that doesn't check out
+var ErrLoginFailed = errors.New("login failed")
+
+type OIDCConfig struct {
+	// Issuer URL, its discovery document is read from
+	// <Issuer>/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Our callback URL, registered with the identity provider
+	RedirectURL string
+	Scopes      []string
+}
+
+// Who signed in, as the identity provider reports it
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// preferred_username, or name when the provider doesn't send one
+	Username string
+}
+
+// Runs the authorization code flow with PKCE against one identity provider.
+// The discovery document is fetched on first use
+type Provider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	endpoint *discovery
+	idTokens *Verifier
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewProvider(cfg OIDCConfig) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// A fresh login: the state echoed back to the callback, the PKCE verifier
+// and the nonce expected in the ID token
+func NewLogin() (state, verifier, nonce string, err error) {
+	values := make([]string, 3)
+	for i := range values {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return "", "", "", fmt.Errorf("generate login secret: %w", err)
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// Where to send the browser to sign in
+func (p *Provider) AuthURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	d, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Trades the callback's code for an ID token and reads who signed in
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	d, idTokens, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var result struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode/100 == 4 {
+		return nil, fmt.Errorf("%w: %s %s", ErrLoginFailed, result.Error, result.Description)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("oidc token: %s", resp.Status)
+	}
+	if result.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in the token response", ErrLoginFailed)
+	}
+
+	claims, err := idTokens.Claims(ctx, result.IDToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return nil, fmt.Errorf("%w: %w", ErrLoginFailed, err)
+		}
+		return nil, err
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrLoginFailed)
+	}
+	id := &Identity{Issuer: d.Issuer}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Username, _ = claims["preferred_username"].(string)
+	if id.Username == "" {
+		id.Username, _ = claims["name"].(string)
+	}
+	if id.Subject == "" {
+		return nil, fmt.Errorf("%w: no sub claim", ErrLoginFailed)
+	}
+	return id, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, *Verifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoint != nil {
+		return p.endpoint, p.idTokens, nil
+	}
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("build discovery request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+	var d discovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d); err != nil {
+		return nil, nil, fmt.Errorf("decode oidc discovery: %w", err)
+	}
+	if d.Issuer != p.cfg.Issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("oidc discovery for %s is incomplete or names another issuer", p.cfg.Issuer)
+	}
+	p.endpoint = &d
+	p.idTokens = NewVerifier(Config{Issuer: d.Issuer, JWKSURL: d.JWKSURI, Audience: p.cfg.ClientID})
+	return p.endpoint, p.idTokens, nil
+}
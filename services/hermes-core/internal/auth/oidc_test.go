@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// An identity provider that signs ID tokens for whoever passes PKCE
+type fakeIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{}
+	idp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "idp", "n": b64(idp.key.N.Bytes()), "e": b64(big.NewInt(int64(idp.key.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good-code" || b64(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": sign(t, "RS256", "idp", idp.key, map[string]any{
+			"iss": idp.URL, "aud": "hermes", "sub": "idp-user-1", "nonce": idp.nonce,
+			"email": "ada@example.com", "email_verified": true, "preferred_username": "ada",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := NewProvider(OIDCConfig{Issuer: idp.URL, ClientID: "hermes", RedirectURL: "https://hermes.example/callback"})
+	state, verifier, nonce, err := NewLogin()
+	if err != nil {
+		t.Fatalf("NewLogin failed: %v", err)
+	}
+	target, err := p.AuthURL(context.Background(), state, verifier, nonce)
+	if err != nil {
+		t.Fatalf("AuthURL failed: %v", err)
+	}
+	u, _ := url.Parse(target)
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("state") != state || q.Get("code_challenge_method") != "S256" ||
+		q.Get("scope") != "openid email profile" || q.Get("code_challenge") == verifier {
+		t.Fatalf("Expected an S256 PKCE authorization request, got %s", target)
+	}
+	idp.challenge, idp.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	id, err := p.Exchange(context.Background(), "good-code", verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	want := Identity{Issuer: idp.URL, Subject: "idp-user-1", Email: "ada@example.com", EmailVerified: true, Username: "ada"}
+	if *id != want {
+		t.Errorf("Expected %+v, got %+v", want, *id)
+	}
+
+	if _, err := p.Exchange(context.Background(), "good-code", "someone-elses-verifier", nonce); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("Expected a wrong verifier refused, got %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), "good-code", verifier, "replayed-nonce"); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("Expected a nonce mismatch refused, got %v", err)
+	}
+}
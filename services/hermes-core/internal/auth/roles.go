@@ -0,0 +1,59 @@
+package auth
+
+import "context"
+
+// What a member may do in a workspace
+type Role string
+
+const (
+	// Implicit for the user whose workspace it is, never stored
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+type Permission int
+
+const (
+	// Read relays, logs and settings
+	PermRead Permission = iota
+	// Create, update and delete relays and what hangs off them
+	PermWrite
+	// Add and remove members
+	PermManage
+)
+
+// Roles a member can be given, the owner's isn't one of them
+var MemberRoles = []Role{RoleAdmin, RoleEditor, RoleViewer}
+
+func (r Role) Can(p Permission) bool {
+	switch r {
+	case RoleOwner, RoleAdmin:
+		return true
+	case RoleEditor:
+		return p <= PermWrite
+	case RoleViewer:
+		return p == PermRead
+	}
+	return false
+}
+
+type workspaceKey struct{}
+
+type workspace struct {
+	id   string
+	role Role
+}
+
+// Marks the request as acting in workspaceID with role
+func WithWorkspace(ctx context.Context, workspaceID string, role Role) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, workspace{id: workspaceID, role: role})
+}
+
+// The workspace the request acts in and the caller's role there, ok is false
+// when none was chosen
+func WorkspaceFrom(ctx context.Context) (string, Role, bool) {
+	ws, ok := ctx.Value(workspaceKey{}).(workspace)
+	return ws.id, ws.role, ok
+}
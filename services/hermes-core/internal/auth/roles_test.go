@@ -0,0 +1,21 @@
+package auth
+
+import "testing"
+
+func TestRoleCan(t *testing.T) {
+	for _, tc := range []struct {
+		role                Role
+		read, write, manage bool
+	}{
+		{RoleOwner, true, true, true},
+		{RoleAdmin, true, true, true},
+		{RoleEditor, true, true, false},
+		{RoleViewer, true, false, false},
+		{Role("intruder"), false, false, false},
+	} {
+		got := [3]bool{tc.role.Can(PermRead), tc.role.Can(PermWrite), tc.role.Can(PermManage)}
+		if want := [3]bool{tc.read, tc.write, tc.manage}; got != want {
+			t.Errorf("%s: expected read/write/manage %v, got %v", tc.role, want, got)
+		}
+	}
+}
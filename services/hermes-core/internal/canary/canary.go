@@ -0,0 +1,175 @@
+// Package canary probes the whole pipeline with a synthetic event: it posts
+// to the canary relay through hermes-hooks and waits for a worker to log the
+// run of its noop action. A broker or worker outage shows up here before real
+// events are lost
+package canary
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+	"github.com/google/uuid"
+)
+
+// How often the execution logs are checked for the probe's run
+const pollInterval = time.Second
+
+// Canary relay storage, implemented by *store.CanaryStore
+type Store interface {
+	EnsureCanaryRelay(ctx context.Context) error
+	ProbeOutcome(ctx context.Context, eventID string) (status string, found bool, err error)
+}
+
+// The latest probe, as served on /health and /metrics
+type Status struct {
+	OK      bool      `json:"ok"`
+	LastRun time.Time `json:"last_run"`
+	// When a probe last made it through, nil if none has yet
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// From posting the webhook to the worker's log of it, on the last success
+	LatencyMs           float64 `json:"latency_ms,omitempty"`
+	Error               string  `json:"error,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	Runs                int     `json:"runs"`
+	Failures            int     `json:"failures"`
+}
+
+type Prober struct {
+	store    Store
+	client   *http.Client
+	hooksURL string
+	logger   *slog.Logger
+	interval time.Duration
+	timeout  time.Duration
+	poll     time.Duration
+
+	mu      sync.Mutex
+	status  Status
+	ensured bool
+}
+
+// hooksURL is where hermes-hooks serves /hooks. Each probe gets timeout to
+// make it through
+func New(s Store, hooksURL string, logger *slog.Logger, interval, timeout time.Duration) *Prober {
+	return &Prober{
+		store:    s,
+		client:   &http.Client{Timeout: timeout},
+		hooksURL: strings.TrimSuffix(hooksURL, "/"),
+		logger:   logger,
+		interval: interval,
+		timeout:  timeout,
+		poll:     pollInterval,
+	}
+}
+
+// Probes now and then each interval until ctx is done
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prober) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Sends one synthetic event through the pipeline and records how it went
+func (p *Prober) Probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	start := time.Now()
+	err := p.probe(ctx)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.status
+	s.LastRun = start
+	s.Runs++
+	if err != nil {
+		if s.OK || s.Runs == 1 {
+			p.logger.Error("canary probe failed", slog.String("error", err.Error()))
+		}
+		s.OK = false
+		s.Error = err.Error()
+		s.ConsecutiveFailures++
+		s.Failures++
+		return
+	}
+	if !s.OK && s.Runs > 1 {
+		p.logger.Info("canary probe recovered", slog.Int("failed_probes", s.ConsecutiveFailures))
+	}
+	finished := time.Now()
+	s.OK = true
+	s.Error = ""
+	s.LastSuccess = &finished
+	s.LatencyMs = float64(latency.Microseconds()) / 1000
+	s.ConsecutiveFailures = 0
+}
+
+func (p *Prober) probe(ctx context.Context) error {
+	if !p.ensured {
+		if err := p.store.EnsureCanaryRelay(ctx); err != nil {
+			return err
+		}
+		p.ensured = true
+	}
+	eventID := uuid.NewString()
+	body := fmt.Sprintf(`{"canary":true,"probe_id":%q}`, eventID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.hooksURL+"/hooks/"+store.CanaryRelayID, bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("build webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", eventID)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post to hermes-hooks: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hermes-hooks answered %d", resp.StatusCode)
+	}
+
+	ticker := time.NewTicker(p.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no worker ran the probe within %s", p.timeout)
+		case <-ticker.C:
+		}
+		status, found, err := p.store.ProbeOutcome(ctx, eventID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("no worker ran the probe within %s", p.timeout)
+			}
+			return err
+		}
+		if !found {
+			continue
+		}
+		if status != "success" {
+			return fmt.Errorf("probe run ended %s", status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package canary
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// Stands in for hooks, broker, worker and the execution logs: events posted
+// to the canary relay are "run" with outcome
+type fakePipeline struct {
+	mu       sync.Mutex
+	outcome  string
+	logged   map[string]string
+	ensured  int
+	hooksErr int
+}
+
+func (f *fakePipeline) EnsureCanaryRelay(context.Context) error {
+	f.ensured++
+	return nil
+}
+
+func (f *fakePipeline) ProbeOutcome(_ context.Context, eventID string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, ok := f.logged[eventID]
+	return status, ok, nil
+}
+
+func (f *fakePipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.hooksErr != 0 {
+		w.WriteHeader(f.hooksErr)
+		return
+	}
+	if r.URL.Path != "/hooks/"+store.CanaryRelayID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.outcome != "" {
+		f.logged[r.Header.Get("X-Event-ID")] = f.outcome
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func newProber(t *testing.T, f *fakePipeline) *Prober {
+	t.Helper()
+	hooks := httptest.NewServer(f)
+	t.Cleanup(hooks.Close)
+	p := New(f, hooks.URL+"/", logger.New("hermes-core-test", "test", "error"), time.Minute, 200*time.Millisecond)
+	p.poll = 10 * time.Millisecond
+	return p
+}
+
+func TestProbeSucceedsEndToEnd(t *testing.T) {
+	f := &fakePipeline{outcome: "success", logged: map[string]string{}}
+	p := newProber(t, f)
+	p.Probe(context.Background())
+	p.Probe(context.Background())
+
+	s := p.Status()
+	if !s.OK || s.LastSuccess == nil || s.Runs != 2 || s.Failures != 0 {
+		t.Errorf("Expected two successful probes, got %+v", s)
+	}
+	if f.ensured != 1 {
+		t.Errorf("Expected the canary relay ensured once, got %d", f.ensured)
+	}
+}
+
+func TestProbeReportsEachStageFailing(t *testing.T) {
+	cases := []struct {
+		name string
+		f    *fakePipeline
+		want string
+	}{
+		{"hooks down", &fakePipeline{hooksErr: http.StatusServiceUnavailable}, "answered 503"},
+		{"no worker", &fakePipeline{}, "no worker ran the probe"},
+		{"action failed", &fakePipeline{outcome: "failed"}, "ended failed"},
+	}
+	for _, c := range cases {
+		c.f.logged = map[string]string{}
+		p := newProber(t, c.f)
+		p.Probe(context.Background())
+		s := p.Status()
+		if s.OK || s.ConsecutiveFailures != 1 || !strings.Contains(s.Error, c.want) {
+			t.Errorf("%s: expected a failure mentioning %q, got %+v", c.name, c.want, s)
+		}
+	}
+}
+
+func TestProbeRecovers(t *testing.T) {
+	f := &fakePipeline{logged: map[string]string{}}
+	p := newProber(t, f)
+	p.Probe(context.Background())
+	f.outcome = "success"
+	p.Probe(context.Background())
+	if s := p.Status(); !s.OK || s.Error != "" || s.ConsecutiveFailures != 0 || s.Failures != 1 {
+		t.Errorf("Expected the canary back up with one failure on record, got %+v", s)
+	}
+}
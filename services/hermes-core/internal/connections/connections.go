@@ -0,0 +1,311 @@
+// Package connections links users' accounts at Slack, Google, GitHub and
+// other OAuth providers to hermes. It runs the authorization code flow, keeps
+// the tokens it gets back and refreshes access tokens before they expire, so
+// actions can use a connection's token as {{connection:ID}}
+package connections
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+const (
+	// Access tokens expiring within this are refreshed
+	refreshAhead = 5 * time.Minute
+	// Connections refreshed per pass
+	refreshBatch = 100
+	// How long one pass may take
+	passTimeout  = 2 * time.Minute
+	tokenTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown or unconfigured provider")
+	// The provider refused the authorization or a refresh
+	ErrAuthorizationFailed = errors.New("authorization failed")
+)
+
+// An OAuth provider and our client registered with it
+type Provider struct {
+	Name     string
+	AuthURL  string
+	TokenURL string
+	// Requested when the user connects
+	Scopes []string
+	// How scopes are joined in the authorization URL, a space when empty
+	ScopeSeparator string
+	// Added to the authorization URL
+	AuthParams   map[string]string
+	ClientID     string
+	ClientSecret string
+}
+
+// Providers hermes knows, without client credentials
+var Builtin = map[string]Provider{
+	"slack": {
+		Name:           "slack",
+		AuthURL:        "https://slack.com/oauth/v2/authorize",
+		TokenURL:       "https://slack.com/api/oauth.v2.access",
+		Scopes:         []string{"chat:write", "channels:read"},
+		ScopeSeparator: ",",
+	},
+	"google": {
+		Name:     "google",
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		Scopes:   []string{"openid", "email", "https://www.googleapis.com/auth/gmail.send"},
+		// Without these Google only issues a refresh token on the first consent
+		AuthParams: map[string]string{"access_type": "offline", "prompt": "consent"},
+	},
+	"github": {
+		Name:     "github",
+		AuthURL:  "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token",
+		Scopes:   []string{"repo"},
+	},
+}
+
+// Connection storage, implemented by *store.ConnectionStore
+type Store interface {
+	SaveState(ctx context.Context, state, userID, provider, name string) error
+	TakeState(ctx context.Context, state string) (userID, provider, name string, err error)
+	CreateConnection(ctx context.Context, userID, provider, name string, tokens store.OAuthTokens) (*models.Connection, error)
+	Now(ctx context.Context) (time.Time, error)
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+	DueForRefresh(ctx context.Context, before time.Time, limit int) ([]store.DueConnection, error)
+	UpdateTokens(ctx context.Context, id string, tokens store.OAuthTokens) error
+	RecordFailure(ctx context.Context, id, message string) error
+}
+
+type Manager struct {
+	store     Store
+	providers map[string]Provider
+	// Our callback URL, registered with every provider
+	redirectURL string
+	client      *http.Client
+	logger      *slog.Logger
+	interval    time.Duration
+}
+
+func New(s Store, providers []Provider, redirectURL string, logger *slog.Logger, interval time.Duration) *Manager {
+	m := &Manager{
+		store:       s,
+		providers:   make(map[string]Provider, len(providers)),
+		redirectURL: redirectURL,
+		client:      &http.Client{Timeout: tokenTimeout},
+		logger:      logger,
+		interval:    interval,
+	}
+	for _, p := range providers {
+		m.providers[p.Name] = p
+	}
+	return m
+}
+
+// Names of the configured providers, sorted
+func (m *Manager) Providers() []string {
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Where to send the user's browser to connect an account at provider. The
+// connection is saved as name once the provider sends them back
+func (m *Manager) AuthURL(ctx context.Context, userID, provider, name string) (string, error) {
+	p, ok := m.providers[provider]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate state: %w", err)
+	}
+	state := base64.RawURLEncoding.EncodeToString(raw)
+	if err := m.store.SaveState(ctx, state, userID, provider, name); err != nil {
+		return "", err
+	}
+	sep := p.ScopeSeparator
+	if sep == "" {
+		sep = " "
+	}
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {m.redirectURL},
+		"scope":         {strings.Join(p.Scopes, sep)},
+		"state":         {state},
+	}
+	for k, v := range p.AuthParams {
+		q.Set(k, v)
+	}
+	return p.AuthURL + "?" + q.Encode(), nil
+}
+
+// Finishes an authorization from the provider's callback, trading the code
+// for tokens and saving the connection. Errors from an unknown state or a
+// refused code wrap ErrAuthorizationFailed
+func (m *Manager) Complete(ctx context.Context, state, code string) (*models.Connection, error) {
+	userID, provider, name, err := m.store.TakeState(ctx, state)
+	if errors.Is(err, store.ErrConnectionStateInvalid) {
+		return nil, fmt.Errorf("%w: %w", ErrAuthorizationFailed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, ok := m.providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+	}
+	tokens, err := m.token(ctx, p, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {m.redirectURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.store.CreateConnection(ctx, userID, provider, name, *tokens)
+}
+
+// Refreshes access tokens each interval until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.Refresh(ctx)
+	}
+}
+
+// One pass over the connections about to expire. It is skipped while another
+// core instance holds the refresh lock
+func (m *Manager) Refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, passTimeout)
+	defer cancel()
+	unlock, ok, err := m.store.TryLock(ctx)
+	if err != nil {
+		m.logger.Error("failed to take connection refresh lock", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	now, err := m.store.Now(ctx)
+	if err != nil {
+		m.logger.Error("failed to read database clock", slog.String("error", err.Error()))
+		return
+	}
+	due, err := m.store.DueForRefresh(ctx, now.Add(refreshAhead), refreshBatch)
+	if err != nil {
+		m.logger.Error("failed to load connections to refresh", slog.String("error", err.Error()))
+		return
+	}
+	for _, c := range due {
+		err := m.refresh(ctx, c)
+		if err == nil {
+			continue
+		}
+		m.logger.Warn("connection refresh failed", slog.String("connection_id", c.ID),
+			slog.String("provider", c.Provider),
+			slog.String("error", err.Error()))
+		// Anything else, the provider being down say, is tried again next pass
+		if errors.Is(err, ErrAuthorizationFailed) || errors.Is(err, ErrUnknownProvider) {
+			if err := m.store.RecordFailure(ctx, c.ID, err.Error()); err != nil {
+				m.logger.Error("failed to record connection failure", slog.String("connection_id", c.ID),
+					slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (m *Manager) refresh(ctx context.Context, c store.DueConnection) error {
+	p, ok := m.providers[c.Provider]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, c.Provider)
+	}
+	tokens, err := m.token(ctx, p, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {c.RefreshToken},
+	})
+	if err != nil {
+		return err
+	}
+	return m.store.UpdateTokens(ctx, c.ID, *tokens)
+}
+
+// Calls the provider's token endpoint
+func (m *Manager) token(ctx context.Context, p Provider, form url.Values) (*store.OAuthTokens, error) {
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub answers with a form unless asked for JSON
+	req.Header.Set("Accept", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s token: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		Scope        string `json:"scope"`
+		// Slack answers 200 with ok false, GitHub 200 with an error
+		OK          *bool  `json:"ok"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+		Team        struct {
+			Name string `json:"name"`
+		} `json:"team"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode/100 == 4 || result.Error != "" || (result.OK != nil && !*result.OK) {
+		return nil, fmt.Errorf("%w: %s %s %s", ErrAuthorizationFailed, p.Name, result.Error, result.Description)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s token: %s", p.Name, resp.Status)
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("%w: %s sent no access_token", ErrAuthorizationFailed, p.Name)
+	}
+	tokens := &store.OAuthTokens{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Account:      result.Team.Name,
+	}
+	if result.ExpiresIn > 0 {
+		tokens.ExpiresIn = time.Duration(result.ExpiresIn) * time.Second
+	}
+	if result.Scope != "" {
+		tokens.Scopes = strings.FieldsFunc(result.Scope, func(r rune) bool { return r == ' ' || r == ',' })
+	}
+	return tokens, nil
+}
@@ -0,0 +1,162 @@
+package connections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+type pending struct{ userID, provider, name string }
+
+type fakeStore struct {
+	now      time.Time
+	states   map[string]pending
+	created  []store.OAuthTokens
+	due      []store.DueConnection
+	updated  map[string]store.OAuthTokens
+	failures map[string]string
+	before   time.Time
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		now:      time.Now(),
+		states:   map[string]pending{},
+		updated:  map[string]store.OAuthTokens{},
+		failures: map[string]string{},
+	}
+}
+
+func (f *fakeStore) SaveState(_ context.Context, state, userID, provider, name string) error {
+	f.states[state] = pending{userID, provider, name}
+	return nil
+}
+
+func (f *fakeStore) TakeState(_ context.Context, state string) (string, string, string, error) {
+	p, ok := f.states[state]
+	if !ok {
+		return "", "", "", store.ErrConnectionStateInvalid
+	}
+	delete(f.states, state)
+	return p.userID, p.provider, p.name, nil
+}
+
+func (f *fakeStore) CreateConnection(_ context.Context, userID, provider, name string, tokens store.OAuthTokens) (*models.Connection, error) {
+	f.created = append(f.created, tokens)
+	return &models.Connection{ID: "c1", UserID: userID, Provider: provider, Name: name, Scopes: tokens.Scopes}, nil
+}
+
+func (f *fakeStore) Now(context.Context) (time.Time, error) { return f.now, nil }
+
+func (f *fakeStore) TryLock(context.Context) (func(), bool, error) { return func() {}, true, nil }
+
+func (f *fakeStore) DueForRefresh(_ context.Context, before time.Time, _ int) ([]store.DueConnection, error) {
+	f.before = before
+	return f.due, nil
+}
+
+func (f *fakeStore) UpdateTokens(_ context.Context, id string, tokens store.OAuthTokens) error {
+	f.updated[id] = tokens
+	return nil
+}
+
+func (f *fakeStore) RecordFailure(_ context.Context, id, message string) error {
+	f.failures[id] = message
+	return nil
+}
+
+// A provider issuing tokens for good-code and good-refresh
+func newFakeProvider(t *testing.T) Provider {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "hermes" || r.FormValue("client_secret") != "shh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.FormValue("grant_type") == "authorization_code" && r.FormValue("code") == "good-code":
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "at-1", "refresh_token": "rt-1",
+				"expires_in": 3600, "scope": "chat:write,channels:read"})
+		case r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == "good-refresh":
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "at-2", "expires_in": 3600})
+		case r.FormValue("refresh_token") == "flaky":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_grant"})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return Provider{Name: "slack", AuthURL: "https://provider.example/authorize", TokenURL: srv.URL,
+		Scopes: []string{"chat:write", "channels:read"}, ScopeSeparator: ",", ClientID: "hermes", ClientSecret: "shh"}
+}
+
+func TestConnect(t *testing.T) {
+	s := newFakeStore()
+	m := New(s, []Provider{newFakeProvider(t)}, "https://hermes.example/callback", logger.New("hermes-core-test", "test", "error"), time.Minute)
+
+	if _, err := m.AuthURL(context.Background(), "u1", "myspace", "work"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Expected ErrUnknownProvider, got %v", err)
+	}
+	target, err := m.AuthURL(context.Background(), "u1", "slack", "work")
+	if err != nil {
+		t.Fatalf("AuthURL failed: %v", err)
+	}
+	u, _ := url.Parse(target)
+	q := u.Query()
+	if q.Get("scope") != "chat:write,channels:read" || q.Get("client_id") != "hermes" || s.states[q.Get("state")].userID != "u1" {
+		t.Fatalf("Expected an authorization request for u1, got %s", target)
+	}
+
+	if _, err := m.Complete(context.Background(), q.Get("state"), "bad-code"); !errors.Is(err, ErrAuthorizationFailed) {
+		t.Errorf("Expected a refused code to fail, got %v", err)
+	}
+	if _, err := m.Complete(context.Background(), q.Get("state"), "good-code"); !errors.Is(err, ErrAuthorizationFailed) {
+		t.Errorf("Expected a used state refused, got %v", err)
+	}
+
+	target, _ = m.AuthURL(context.Background(), "u1", "slack", "work")
+	u, _ = url.Parse(target)
+	c, err := m.Complete(context.Background(), u.Query().Get("state"), "good-code")
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if c.UserID != "u1" || c.Name != "work" || len(c.Scopes) != 2 {
+		t.Errorf("Expected u1's work connection with two scopes, got %+v", c)
+	}
+	tokens := s.created[0]
+	if tokens.AccessToken != "at-1" || tokens.RefreshToken != "rt-1" || tokens.ExpiresIn != time.Hour {
+		t.Errorf("Expected the issued tokens saved, got %+v", tokens)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	s := newFakeStore()
+	s.due = []store.DueConnection{
+		{ID: "ok", Provider: "slack", RefreshToken: "good-refresh"},
+		{ID: "revoked", Provider: "slack", RefreshToken: "revoked"},
+		{ID: "flaky", Provider: "slack", RefreshToken: "flaky"},
+	}
+	m := New(s, []Provider{newFakeProvider(t)}, "https://hermes.example/callback", logger.New("hermes-core-test", "test", "error"), time.Minute)
+	m.Refresh(context.Background())
+
+	if !s.before.Equal(s.now.Add(refreshAhead)) {
+		t.Errorf("Expected tokens expiring within %s refreshed, got %s", refreshAhead, s.before.Sub(s.now))
+	}
+	if got := s.updated["ok"]; got.AccessToken != "at-2" || got.RefreshToken != "" {
+		t.Errorf("Expected a new access token keeping the refresh token, got %+v", got)
+	}
+	if _, ok := s.failures["revoked"]; !ok || len(s.updated) != 1 {
+		t.Errorf("Expected the revoked connection marked failed, got %v", s.failures)
+	}
+	if _, ok := s.failures["flaky"]; ok {
+		t.Error("Expected an unavailable provider retried rather than marked failed")
+	}
+}
@@ -6,17 +6,16 @@ import (
 	"log"
 	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/dbpool"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func New(connStr string) (*pgxpool.Pool, error) {
+func New(connStr string, opts dbpool.Options) (*pgxpool.Pool, error) {
 	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse db config: %w", err)
 	}
-	config.MaxConns = 25
-	config.MinConns = 2
-	config.MaxConnLifetime = 1 * time.Hour
+	opts.Apply(config)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,113 @@
+// Package deliveries keeps the webhook delivery log. hermes-hooks reports
+// every request it answers over NATS, and core writes them to the database in
+// batches and prunes the ones past retention
+package deliveries
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+const (
+	// How often buffered deliveries are written
+	flushInterval = 2 * time.Second
+	// Deliveries held while the database is slow or down. Later ones are dropped
+	maxBuffered = 10000
+	// How often deliveries past retention are deleted
+	pruneInterval = time.Hour
+	flushTimeout  = 30 * time.Second
+)
+
+// Delivery log storage, implemented by *store.DeliveryStore
+type Store interface {
+	SaveDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	PruneDeliveries(ctx context.Context, age time.Duration) (int64, error)
+}
+
+type Recorder struct {
+	store     Store
+	logger    *slog.Logger
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending []models.WebhookDelivery
+	dropped int
+}
+
+// A zero retention keeps every delivery
+func New(s Store, logger *slog.Logger, retention time.Duration) *Recorder {
+	return &Recorder{store: s, logger: logger, retention: retention}
+}
+
+// Buffers a delivery until the next flush
+func (r *Recorder) Add(d models.WebhookDelivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) >= maxBuffered {
+		r.dropped++
+		return
+	}
+	r.pending = append(r.pending, d)
+}
+
+// Flushes every flushInterval and prunes every pruneInterval until ctx is
+// done, then flushes what's left
+func (r *Recorder) Run(ctx context.Context) {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.Flush(context.WithoutCancel(ctx))
+			return
+		case <-flush.C:
+			r.Flush(ctx)
+		case <-prune.C:
+			r.Prune(ctx)
+		}
+	}
+}
+
+// Writes the buffered deliveries. A batch the database refuses is dropped
+// rather than retried, the log being best effort
+func (r *Recorder) Flush(ctx context.Context) {
+	r.mu.Lock()
+	batch, dropped := r.pending, r.dropped
+	r.pending, r.dropped = nil, 0
+	r.mu.Unlock()
+	if dropped > 0 {
+		r.logger.Warn("delivery log buffer full, deliveries dropped", slog.Int("dropped", dropped))
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+	if err := r.store.SaveDeliveries(ctx, batch); err != nil {
+		r.logger.Error("failed to save deliveries", slog.Int("count", len(batch)),
+			slog.String("error", err.Error()))
+	}
+}
+
+// Deletes deliveries older than the retention
+func (r *Recorder) Prune(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+	pruned, err := r.store.PruneDeliveries(ctx, r.retention)
+	if err != nil {
+		r.logger.Error("failed to prune deliveries", slog.String("error", err.Error()))
+		return
+	}
+	if pruned > 0 {
+		r.logger.Info("pruned webhook deliveries", slog.Int64("count", pruned))
+	}
+}
@@ -0,0 +1,70 @@
+package deliveries
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+type fakeStore struct {
+	saved  [][]models.WebhookDelivery
+	err    error
+	pruned []time.Duration
+}
+
+func (f *fakeStore) SaveDeliveries(_ context.Context, deliveries []models.WebhookDelivery) error {
+	f.saved = append(f.saved, deliveries)
+	return f.err
+}
+
+func (f *fakeStore) PruneDeliveries(_ context.Context, age time.Duration) (int64, error) {
+	f.pruned = append(f.pruned, age)
+	return 0, nil
+}
+
+func TestFlushWritesBufferedDeliveriesOnce(t *testing.T) {
+	f := &fakeStore{}
+	r := New(f, logger.New("hermes-core-test", "test", "error"), 0)
+	r.Add(models.WebhookDelivery{RelayID: "a", Status: 200})
+	r.Add(models.WebhookDelivery{RelayID: "a", Status: 413, Reason: "too_large"})
+
+	r.Flush(context.Background())
+	r.Flush(context.Background())
+	if len(f.saved) != 1 || len(f.saved[0]) != 2 {
+		t.Fatalf("Expected one batch of 2 deliveries, got %v", f.saved)
+	}
+
+	f.err = errors.New("connection refused")
+	r.Add(models.WebhookDelivery{RelayID: "a", Status: 200})
+	r.Flush(context.Background())
+	f.err = nil
+	r.Flush(context.Background())
+	if len(f.saved) != 2 {
+		t.Errorf("Expected a refused batch to be dropped, got %d batches", len(f.saved))
+	}
+}
+
+func TestAddDropsWhenBufferFull(t *testing.T) {
+	f := &fakeStore{}
+	r := New(f, logger.New("hermes-core-test", "test", "error"), 0)
+	for range maxBuffered + 5 {
+		r.Add(models.WebhookDelivery{RelayID: "a"})
+	}
+	r.Flush(context.Background())
+	if len(f.saved[0]) != maxBuffered {
+		t.Errorf("Expected %d deliveries kept, got %d", maxBuffered, len(f.saved[0]))
+	}
+}
+
+func TestPruneSkippedWithoutRetention(t *testing.T) {
+	f := &fakeStore{}
+	New(f, logger.New("hermes-core-test", "test", "error"), 0).Prune(context.Background())
+	New(f, logger.New("hermes-core-test", "test", "error"), 72*time.Hour).Prune(context.Background())
+	if len(f.pruned) != 1 || f.pruned[0] != 72*time.Hour {
+		t.Errorf("Expected one prune of 72h, got %v", f.pruned)
+	}
+}
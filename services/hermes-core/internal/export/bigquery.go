@@ -0,0 +1,250 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+const (
+	bigQueryAPI     = "https://bigquery.googleapis.com/bigquery/v2"
+	bigQueryScope   = "https://www.googleapis.com/auth/bigquery.insertdata"
+	googleTokenURL  = "https://oauth2.googleapis.com/token"
+	assertionExpiry = time.Hour
+)
+
+var (
+	bigQueryProject = regexp.MustCompile(`^[A-Za-z0-9.:-]+$`)
+	bigQueryName    = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// Streams each page into a table with tabledata.insertAll, using the log ID
+// as the insert ID so BigQuery drops rows sent twice. The table needs the
+// columns id, relay_id, event_id, trace_id, status, error_message (STRING),
+// executed_at (TIMESTAMP), and payload and steps (JSON or STRING)
+type bigQuerySink struct {
+	project     string
+	dataset     string
+	table       string
+	credentials string
+	baseURL     string
+	client      *http.Client
+	now         func() time.Time
+}
+
+type bigQueryRecord struct {
+	ID           string `json:"id"`
+	RelayID      string `json:"relay_id"`
+	EventID      string `json:"event_id,omitempty"`
+	TraceID      string `json:"trace_id,omitempty"`
+	Status       string `json:"status"`
+	Payload      string `json:"payload,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	ExecutedAt   string `json:"executed_at"`
+	Steps        string `json:"steps"`
+}
+
+// Reads project_id, dataset, table and credentials, a service account key in
+// the JSON form Google Cloud downloads it in
+func newBigQuerySink(config map[string]any, client *http.Client, now func() time.Time) (*bigQuerySink, error) {
+	s := &bigQuerySink{
+		project:     configString(config, "project_id"),
+		dataset:     configString(config, "dataset"),
+		table:       configString(config, "table"),
+		credentials: configString(config, "credentials"),
+		baseURL:     bigQueryAPI,
+		client:      client,
+		now:         now,
+	}
+	if !bigQueryProject.MatchString(s.project) {
+		return nil, errors.New("bigquery sink needs a project_id")
+	}
+	if !bigQueryName.MatchString(s.dataset) || !bigQueryName.MatchString(s.table) {
+		return nil, errors.New("bigquery sink needs a dataset and table of letters, digits and _")
+	}
+	if s.credentials == "" {
+		return nil, errors.New("bigquery sink needs service account credentials")
+	}
+	return s, nil
+}
+
+func (s *bigQuerySink) Send(ctx context.Context, logs []models.ExecutionLog) error {
+	token, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		InsertID string         `json:"insertId"`
+		JSON     bigQueryRecord `json:"json"`
+	}
+	rows := make([]row, 0, len(logs))
+	for _, log := range logs {
+		record := bigQueryRecord{
+			ID:           log.ID,
+			RelayID:      log.RelayID,
+			EventID:      log.EventID,
+			TraceID:      log.TraceID,
+			Status:       log.Status,
+			ErrorMessage: log.ErrorMessage,
+			ExecutedAt:   log.ExecutedAt.UTC().Format(time.RFC3339Nano),
+		}
+		// A JSON column takes a string holding the document
+		if log.Payload != nil {
+			payload, err := json.Marshal(log.Payload)
+			if err != nil {
+				return fmt.Errorf("encode log %s: %w", log.ID, err)
+			}
+			record.Payload = string(payload)
+		}
+		steps, err := json.Marshal(log.Steps)
+		if err != nil {
+			return fmt.Errorf("encode log %s: %w", log.ID, err)
+		}
+		record.Steps = string(steps)
+		rows = append(rows, row{InsertID: log.ID, JSON: record})
+	}
+	body, err := json.Marshal(map[string]any{"rows": rows})
+	if err != nil {
+		return fmt.Errorf("encode rows: %w", err)
+	}
+
+	target := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll",
+		s.baseURL, url.PathEscape(s.project), s.dataset, s.table)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build bigquery request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bigquery insert: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bigquery insert: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	// Rows can be rejected in a 200, and the rest of the request with them
+	var result struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("bigquery insert: read response: %w", err)
+	}
+	for _, rowErr := range result.InsertErrors {
+		for _, e := range rowErr.Errors {
+			// Rows that only failed because another row did say "stopped"
+			if e.Reason != "stopped" {
+				return fmt.Errorf("bigquery rejected row %d: %s: %s", rowErr.Index, e.Reason, e.Message)
+			}
+		}
+	}
+	if len(result.InsertErrors) > 0 {
+		return fmt.Errorf("bigquery rejected %d rows", len(result.InsertErrors))
+	}
+	return nil
+}
+
+// Trades a JWT signed with the service account's key for an access token
+func (s *bigQuerySink) token(ctx context.Context) (string, error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal([]byte(s.credentials), &key); err != nil || key.ClientEmail == "" || key.PrivateKey == "" {
+		return "", errors.New("bigquery credentials must be a service account key with client_email and private_key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = googleTokenURL
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New("bigquery credentials private_key is not a PEM key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("bigquery credentials private_key: %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("bigquery credentials private_key must be an RSA key")
+	}
+
+	now := s.now()
+	assertion, err := signJWT(map[string]any{
+		"iss":   key.ClientEmail,
+		"scope": bigQueryScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionExpiry).Unix(),
+	}, rsaKey)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("bigquery token: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode/100 != 2 || result.AccessToken == "" {
+		return "", fmt.Errorf("bigquery token: %s: %s %s", resp.Status, result.Error, result.Description)
+	}
+	return result.AccessToken, nil
+}
+
+// Signs claims as an RS256 JWT
+func signJWT(claims map[string]any, key *rsa.PrivateKey) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt claims: %w", err)
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(encoded)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,233 @@
+// Package export ships execution logs to external sinks, S3, BigQuery or a
+// webhook, so long-term analytics can run outside the operational database.
+// Each export pages through its user's logs in (executed_at, id) order and
+// moves its cursor past every page a sink accepted, so a failed page is sent
+// again on the next pass and sinks may see a page more than once
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+const (
+	// Logs per page, which is also the most BigQuery takes in one insert
+	batchSize = 500
+	// Pages one export ships per pass, so a backlog doesn't hold up the others
+	maxBatches = 20
+	// Workers write logs in batches stamped with when they finished, so logs
+	// younger than this may still be on their way and aren't shipped yet
+	settleDelay = 2 * time.Minute
+	// How long one pass may take
+	passTimeout = 5 * time.Minute
+	sendTimeout = 30 * time.Second
+)
+
+// Export storage, implemented by *store.ExportStore
+type Store interface {
+	Now(ctx context.Context) (time.Time, error)
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+	ActiveExports(ctx context.Context) ([]models.LogExport, error)
+	ExportLogs(ctx context.Context, userID string, after store.ExportCursor, until time.Time, limit int) ([]models.ExecutionLog, error)
+	Advance(ctx context.Context, id string, cursor store.ExportCursor) error
+	RecordFailure(ctx context.Context, id, message string) error
+}
+
+// Opens the user's secrets that sink configs reference, implemented by *store.SecretStore
+type Secrets interface {
+	Open(ctx context.Context, userID, name string) (string, error)
+}
+
+// Takes one page of logs, oldest first. An error leaves the page to be sent again
+type Sink interface {
+	Send(ctx context.Context, logs []models.ExecutionLog) error
+}
+
+type Shipper struct {
+	store    Store
+	secrets  Secrets
+	client   *http.Client
+	logger   *slog.Logger
+	interval time.Duration
+	now      func() time.Time
+}
+
+func New(s Store, sec Secrets, logger *slog.Logger, interval time.Duration) *Shipper {
+	return &Shipper{
+		store:    s,
+		secrets:  sec,
+		client:   &http.Client{Timeout: sendTimeout},
+		logger:   logger,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Ships new logs each interval until ctx is done
+func (s *Shipper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.Export(ctx)
+	}
+}
+
+// One pass over the active exports. It is skipped while another core
+// instance holds the export lock
+func (s *Shipper) Export(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, passTimeout)
+	defer cancel()
+	unlock, ok, err := s.store.TryLock(ctx)
+	if err != nil {
+		s.logger.Error("failed to take log export lock", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	now, err := s.store.Now(ctx)
+	if err != nil {
+		s.logger.Error("failed to read database clock", slog.String("error", err.Error()))
+		return
+	}
+	exports, err := s.store.ActiveExports(ctx)
+	if err != nil {
+		s.logger.Error("failed to load log exports", slog.String("error", err.Error()))
+		return
+	}
+	until := now.Add(-settleDelay)
+	for _, export := range exports {
+		shipped, err := s.ship(ctx, export, until)
+		if err != nil {
+			s.logger.Warn("log export failed", slog.String("export_id", export.ID),
+				logfields.UserID(export.UserID),
+				slog.String("sink", export.Sink),
+				slog.Int("shipped", shipped),
+				slog.String("error", err.Error()))
+			if err := s.store.RecordFailure(ctx, export.ID, err.Error()); err != nil {
+				s.logger.Error("failed to record log export failure", slog.String("export_id", export.ID),
+					slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if shipped > 0 {
+			s.logger.Info("logs exported", slog.String("export_id", export.ID),
+				slog.String("sink", export.Sink),
+				slog.Int("count", shipped))
+		}
+	}
+}
+
+// Sends the export's pages up to until, returning how many logs were shipped
+func (s *Shipper) ship(ctx context.Context, export models.LogExport, until time.Time) (int, error) {
+	config, err := secrets.Resolve(export.Config, func(name string) (string, error) {
+		return s.secrets.Open(ctx, export.UserID, name)
+	})
+	if err != nil {
+		return 0, err
+	}
+	sink, err := newSink(export.Sink, config, s.client, s.now)
+	if err != nil {
+		return 0, err
+	}
+	cursor := store.ExportCursor{At: export.ExportedThrough, ID: export.CursorID}
+	shipped := 0
+	for range maxBatches {
+		logs, err := s.store.ExportLogs(ctx, export.UserID, cursor, until, batchSize)
+		if err != nil {
+			return shipped, err
+		}
+		if len(logs) == 0 {
+			return shipped, nil
+		}
+		if err := sink.Send(ctx, logs); err != nil {
+			return shipped, err
+		}
+		last := logs[len(logs)-1]
+		cursor = store.ExportCursor{At: last.ExecutedAt, ID: last.ID}
+		if err := s.store.Advance(ctx, export.ID, cursor); err != nil {
+			return shipped, err
+		}
+		shipped += len(logs)
+		if len(logs) < batchSize {
+			return shipped, nil
+		}
+	}
+	return shipped, nil
+}
+
+// Checks a sink config as it is stored, before secrets are resolved.
+// Credentials must be secret references so they are never stored in the clear
+func Validate(sink string, config map[string]any) error {
+	var credentials []string
+	switch sink {
+	case store.ExportSinkS3:
+		credentials = []string{"secret_access_key"}
+	case store.ExportSinkBigQuery:
+		credentials = []string{"credentials"}
+	case store.ExportSinkWebhook:
+		if _, ok := config["secret"]; ok {
+			credentials = []string{"secret"}
+		}
+	default:
+		return errors.New("sink must be s3, bigquery or webhook")
+	}
+	for _, key := range credentials {
+		value, _ := config[key].(string)
+		if len(secrets.References(map[string]any{key: value})) == 0 {
+			return fmt.Errorf("%s must be a {{secret:NAME}} reference", key)
+		}
+	}
+	_, err := newSink(sink, config, http.DefaultClient, time.Now)
+	return err
+}
+
+func newSink(sink string, config map[string]any, client *http.Client, now func() time.Time) (Sink, error) {
+	switch sink {
+	case store.ExportSinkS3:
+		return newS3Sink(config, client, now)
+	case store.ExportSinkBigQuery:
+		return newBigQuerySink(config, client, now)
+	case store.ExportSinkWebhook:
+		return newWebhookSink(config, client)
+	}
+	return nil, fmt.Errorf("unknown sink %q", sink)
+}
+
+func configString(config map[string]any, key string) string {
+	value, _ := config[key].(string)
+	return strings.TrimSpace(value)
+}
+
+// Sends req and turns anything but a 2xx into an error carrying the start of the body
+func do(client *http.Client, req *http.Request, what string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", what, resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,199 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+type fakeStore struct {
+	now      time.Time
+	locked   bool
+	exports  []models.LogExport
+	logs     []models.ExecutionLog
+	cursors  map[string]store.ExportCursor
+	failures map[string]string
+	until    time.Time
+}
+
+func (f *fakeStore) Now(context.Context) (time.Time, error) { return f.now, nil }
+
+func (f *fakeStore) TryLock(context.Context) (func(), bool, error) {
+	return func() {}, !f.locked, nil
+}
+
+func (f *fakeStore) ActiveExports(context.Context) ([]models.LogExport, error) { return f.exports, nil }
+
+func (f *fakeStore) ExportLogs(_ context.Context, _ string, after store.ExportCursor, until time.Time, limit int) ([]models.ExecutionLog, error) {
+	f.until = until
+	var page []models.ExecutionLog
+	for _, log := range f.logs {
+		if log.ExecutedAt.After(after.At) || (log.ExecutedAt.Equal(after.At) && log.ID > after.ID) {
+			page = append(page, log)
+		}
+		if len(page) == limit {
+			break
+		}
+	}
+	return page, nil
+}
+
+func (f *fakeStore) Advance(_ context.Context, id string, cursor store.ExportCursor) error {
+	f.cursors[id] = cursor
+	return nil
+}
+
+func (f *fakeStore) RecordFailure(_ context.Context, id, message string) error {
+	f.failures[id] = message
+	return nil
+}
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) Open(_ context.Context, _, name string) (string, error) {
+	if value, ok := f[name]; ok {
+		return value, nil
+	}
+	return "", secrets.ErrNotFound
+}
+
+func newFakeStore(now time.Time, exports ...models.LogExport) *fakeStore {
+	return &fakeStore{
+		now:      now,
+		exports:  exports,
+		cursors:  map[string]store.ExportCursor{},
+		failures: map[string]string{},
+	}
+}
+
+func TestExportShipsPagesAndAdvances(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s := newFakeStore(now, models.LogExport{
+		ID:     "export-1",
+		UserID: "user-1",
+		Sink:   store.ExportSinkWebhook,
+		Config: map[string]any{"url": srv.URL},
+	})
+	for i := range batchSize + 1 {
+		s.logs = append(s.logs, models.ExecutionLog{
+			ID:         fmt.Sprintf("%04d", i),
+			ExecutedAt: now.Add(-time.Hour),
+		})
+	}
+
+	New(s, fakeSecrets{}, logger.New("hermes-core-test", "test", "error"), time.Minute).Export(context.Background())
+
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 pages, got %d", len(bodies))
+	}
+	if lines := strings.Count(bodies[0], "\n"); lines != batchSize {
+		t.Errorf("Expected a full first page, got %d lines", lines)
+	}
+	last := s.logs[len(s.logs)-1]
+	if got := s.cursors["export-1"]; got.ID != last.ID || !got.At.Equal(last.ExecutedAt) {
+		t.Errorf("Expected the cursor at the last log, got %+v", got)
+	}
+	if !s.until.Equal(now.Add(-settleDelay)) {
+		t.Errorf("Expected logs younger than the settle delay to wait, got until %v", s.until)
+	}
+}
+
+func TestExportRecordsFailureWithoutAdvancing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	s := newFakeStore(now,
+		models.LogExport{ID: "down", Sink: store.ExportSinkWebhook, Config: map[string]any{"url": srv.URL}},
+		models.LogExport{ID: "no-secret", Sink: store.ExportSinkWebhook,
+			Config: map[string]any{"url": srv.URL, "secret": "{{secret:MISSING}}"}},
+	)
+	s.logs = []models.ExecutionLog{{ID: "a", ExecutedAt: now.Add(-time.Hour)}}
+
+	New(s, fakeSecrets{}, logger.New("hermes-core-test", "test", "error"), time.Minute).Export(context.Background())
+
+	if len(s.cursors) != 0 {
+		t.Errorf("Expected no cursor to move, got %v", s.cursors)
+	}
+	if !strings.Contains(s.failures["down"], "503") {
+		t.Errorf("Expected the sink's status in the failure, got %q", s.failures["down"])
+	}
+	if !strings.Contains(s.failures["no-secret"], "MISSING") {
+		t.Errorf("Expected the missing secret in the failure, got %q", s.failures["no-secret"])
+	}
+}
+
+func TestExportSkipsWhenLocked(t *testing.T) {
+	s := newFakeStore(time.Now(), models.LogExport{ID: "e", Sink: "unknown"})
+	s.locked = true
+	New(s, fakeSecrets{}, logger.New("hermes-core-test", "test", "error"), time.Minute).Export(context.Background())
+	if len(s.failures) != 0 {
+		t.Error("Expected no export to run without the lock")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []struct {
+		sink   string
+		config map[string]any
+	}{
+		{"s3", map[string]any{"bucket": "logs", "region": "eu-west-1", "access_key_id": "AKID",
+			"secret_access_key": "{{secret:AWS_SECRET}}"}},
+		{"bigquery", map[string]any{"project_id": "acme-prod", "dataset": "hermes", "table": "executions",
+			"credentials": "{{secret:GCP_KEY}}"}},
+		{"webhook", map[string]any{"url": "https://logs.example.com/ingest"}},
+		{"webhook", map[string]any{"url": "https://logs.example.com/ingest", "secret": "{{secret:HOOK}}",
+			"headers": map[string]any{"X-Team": "ops"}}},
+	}
+	for _, c := range valid {
+		if err := Validate(c.sink, c.config); err != nil {
+			t.Errorf("Expected %s %v to be valid, got %v", c.sink, c.config, err)
+		}
+	}
+
+	invalid := []struct {
+		sink   string
+		config map[string]any
+	}{
+		{"ftp", map[string]any{}},
+		{"s3", map[string]any{"bucket": "logs", "region": "eu-west-1", "access_key_id": "AKID",
+			"secret_access_key": "plaintext"}},
+		{"s3", map[string]any{"bucket": "Logs!", "region": "eu-west-1", "access_key_id": "AKID",
+			"secret_access_key": "{{secret:AWS_SECRET}}"}},
+		{"s3", map[string]any{"bucket": "logs", "region": "eu-west-1", "access_key_id": "AKID",
+			"secret_access_key": "{{secret:AWS_SECRET}}", "prefix": "a b"}},
+		{"bigquery", map[string]any{"project_id": "acme", "dataset": "hermes", "table": "x;drop",
+			"credentials": "{{secret:GCP_KEY}}"}},
+		{"webhook", map[string]any{"url": "ftp://example.com"}},
+		{"webhook", map[string]any{"url": "https://example.com", "secret": "hunter2"}},
+		{"webhook", map[string]any{"url": "https://example.com", "headers": map[string]any{"X": 1}}},
+	}
+	for _, c := range invalid {
+		if err := Validate(c.sink, c.config); err == nil {
+			t.Errorf("Expected %s %v to be rejected", c.sink, c.config)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+const defaultS3Prefix = "hermes/"
+
+var (
+	s3Bucket = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	// Characters S3 keys can use without escaping
+	s3Prefix = regexp.MustCompile(`^[A-Za-z0-9!_.*'()/=-]*$`)
+)
+
+// Writes each page as a gzipped NDJSON object, one execution log with its
+// steps per line, under <prefix>dt=<day>/ so tools like Athena can prune by
+// day. An object is named after the page's first log, so a page sent again
+// replaces its earlier copy
+type s3Sink struct {
+	bucket   string
+	region   string
+	prefix   string
+	endpoint string
+	creds    sigv4.Credentials
+	client   *http.Client
+	now      func() time.Time
+}
+
+// Reads bucket, region, access_key_id, secret_access_key, the optional
+// session_token and prefix, and endpoint for S3-compatible stores, which are
+// addressed path-style
+func newS3Sink(config map[string]any, client *http.Client, now func() time.Time) (*s3Sink, error) {
+	s := &s3Sink{
+		bucket: configString(config, "bucket"),
+		region: configString(config, "region"),
+		prefix: configString(config, "prefix"),
+		creds: sigv4.Credentials{
+			AccessKeyID:     configString(config, "access_key_id"),
+			SecretAccessKey: configString(config, "secret_access_key"),
+			SessionToken:    configString(config, "session_token"),
+		},
+		client: client,
+		now:    now,
+	}
+	if !s3Bucket.MatchString(s.bucket) {
+		return nil, errors.New("s3 sink needs a valid bucket name")
+	}
+	if !sigv4.ValidRegion.MatchString(s.region) {
+		return nil, errors.New("s3 sink needs a region such as us-east-1")
+	}
+	if s.creds.AccessKeyID == "" || s.creds.SecretAccessKey == "" {
+		return nil, errors.New("s3 sink needs access_key_id and secret_access_key")
+	}
+	if !s3Prefix.MatchString(s.prefix) {
+		return nil, errors.New("s3 prefix may only use letters, digits and !_.*'()/=-")
+	}
+	if s.prefix == "" {
+		s.prefix = defaultS3Prefix
+	} else if !strings.HasSuffix(s.prefix, "/") {
+		s.prefix += "/"
+	}
+	if endpoint := configString(config, "endpoint"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, errors.New("s3 endpoint must be an http or https URL")
+		}
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	return s, nil
+}
+
+func (s *s3Sink) key(first models.ExecutionLog) string {
+	at := first.ExecutedAt.UTC()
+	return s.prefix + "dt=" + at.Format("2006-01-02") + "/" + at.Format("150405") + "-" + first.ID + ".ndjson.gz"
+}
+
+func (s *s3Sink) Send(ctx context.Context, logs []models.ExecutionLog) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, log := range logs {
+		if err := enc.Encode(log); err != nil {
+			return fmt.Errorf("encode log %s: %w", log.ID, err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compress logs: %w", err)
+	}
+	body := buf.Bytes()
+
+	target := "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com/" + s.key(logs[0])
+	if s.endpoint != "" {
+		target = s.endpoint + "/" + s.bucket + "/" + s.key(logs[0])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build s3 request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	sigv4.Sign(req, body, s.creds, s.region, "s3", s.now())
+	return do(s.client, req, "s3 put")
+}
@@ -0,0 +1,194 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+var testLogs = []models.ExecutionLog{
+	{
+		ID:         "9b2f6d1e-3c4a-4e5f-8a7b-1c2d3e4f5a6b",
+		RelayID:    "relay-1",
+		Status:     "success",
+		Payload:    map[string]any{"order_id": float64(1234)},
+		ExecutedAt: time.Date(2026, 10, 16, 9, 30, 5, 0, time.UTC),
+		Steps:      []models.ExecutionStep{{ActionType: "slack_send", Status: "success"}},
+	},
+	{ID: "c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f", RelayID: "relay-1", Status: "failed", ErrorMessage: "timeout"},
+}
+
+func TestS3SinkPutsGzippedNDJSON(t *testing.T) {
+	var path, auth, contentHash string
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth, contentHash = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Amz-Content-Sha256")
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != contentHash {
+			t.Error("Expected X-Amz-Content-Sha256 to match the body")
+		}
+		gz, err := gzip.NewReader(strings.NewReader(string(body)))
+		if err != nil {
+			t.Errorf("Expected a gzipped body: %v", err)
+			return
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newS3Sink(map[string]any{
+		"bucket": "hermes-logs", "region": "eu-west-1", "prefix": "prod",
+		"access_key_id": "AKID", "secret_access_key": "secret", "endpoint": srv.URL,
+	}, srv.Client(), time.Now)
+	if err != nil {
+		t.Fatalf("Unexpected config error: %v", err)
+	}
+	if err := sink.Send(context.Background(), testLogs); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if want := "/hermes-logs/prod/dt=2026-10-16/093005-" + testLogs[0].ID + ".ndjson.gz"; path != want {
+		t.Errorf("Expected %s, got %s", want, path)
+	}
+	if !strings.Contains(auth, "/eu-west-1/s3/aws4_request") || !strings.Contains(auth, "x-amz-content-sha256") {
+		t.Errorf("Expected an S3 SigV4 signature over the content hash, got %q", auth)
+	}
+	if len(lines) != 2 || !strings.Contains(lines[0], `"order_id":1234`) {
+		t.Errorf("Expected a line per log, got %v", lines)
+	}
+}
+
+func TestWebhookSinkSignsBody(t *testing.T) {
+	var signature, team, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, team = r.Header.Get(signatureHeader), r.Header.Get("X-Team")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	sink, err := newWebhookSink(map[string]any{
+		"url": srv.URL, "secret": "s3cret", "headers": map[string]any{"X-Team": "ops"},
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("Unexpected config error: %v", err)
+	}
+	if err := sink.Send(context.Background(), testLogs); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("Expected %s, got %s", want, signature)
+	}
+	if team != "ops" || strings.Count(body, "\n") != 2 {
+		t.Errorf("Expected the custom header and 2 lines, got %q and %q", team, body)
+	}
+}
+
+// A service account key whose tokens come from tokenURL
+func serviceAccount(t *testing.T, tokenURL string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"client_email": "hermes@acme.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURL,
+	})
+	return string(credentials)
+}
+
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.Form.Get("assertion"), ".") != 2 {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"access_token":"ya29.token","expires_in":3600}`))
+}
+
+func TestBigQuerySinkInsertsRowsWithToken(t *testing.T) {
+	var insert struct {
+		Rows []struct {
+			InsertID string         `json:"insertId"`
+			JSON     map[string]any `json:"json"`
+		} `json:"rows"`
+	}
+	var auth, path string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", tokenHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("Authorization"), r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&insert)
+		_, _ = w.Write([]byte(`{"kind":"bigquery#tableDataInsertAllResponse"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sink, err := newBigQuerySink(map[string]any{
+		"project_id": "acme-prod", "dataset": "hermes", "table": "executions",
+		"credentials": serviceAccount(t, srv.URL+"/token"),
+	}, srv.Client(), time.Now)
+	if err != nil {
+		t.Fatalf("Unexpected config error: %v", err)
+	}
+	sink.baseURL = srv.URL
+	if err := sink.Send(context.Background(), testLogs); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if auth != "Bearer ya29.token" || path != "/projects/acme-prod/datasets/hermes/tables/executions/insertAll" {
+		t.Errorf("Unexpected insert request %s %s", auth, path)
+	}
+	if len(insert.Rows) != 2 || insert.Rows[0].InsertID != testLogs[0].ID {
+		t.Fatalf("Expected a row per log keyed by its ID, got %+v", insert.Rows)
+	}
+	if insert.Rows[0].JSON["payload"] != `{"order_id":1234}` || insert.Rows[0].JSON["executed_at"] != "2026-10-16T09:30:05Z" {
+		t.Errorf("Unexpected row %v", insert.Rows[0].JSON)
+	}
+}
+
+func TestBigQuerySinkFailsOnInsertErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", tokenHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"insertErrors":[{"index":0,"errors":[{"reason":"stopped"}]},
+			{"index":1,"errors":[{"reason":"invalid","message":"no such field: steps"}]}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sink, _ := newBigQuerySink(map[string]any{
+		"project_id": "p", "dataset": "d", "table": "t", "credentials": serviceAccount(t, srv.URL+"/token"),
+	}, srv.Client(), time.Now)
+	sink.baseURL = srv.URL
+	err := sink.Send(context.Background(), testLogs)
+	if err == nil || !strings.Contains(err.Error(), "no such field: steps") {
+		t.Errorf("Expected the rejected row's error, got %v", err)
+	}
+}
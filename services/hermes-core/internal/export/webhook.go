@@ -0,0 +1,79 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+// Carries the body's HMAC-SHA256 under the export's secret, as sha256=<hex>
+const signatureHeader = "X-Hermes-Signature"
+
+// POSTs each page as NDJSON, one execution log with its steps per line
+type webhookSink struct {
+	url     string
+	secret  string
+	headers map[string]string
+	client  *http.Client
+}
+
+// Reads url, the optional secret to sign bodies with and headers to send
+func newWebhookSink(config map[string]any, client *http.Client) (*webhookSink, error) {
+	s := &webhookSink{
+		url:     configString(config, "url"),
+		secret:  configString(config, "secret"),
+		headers: map[string]string{},
+		client:  client,
+	}
+	u, err := url.Parse(s.url)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("webhook sink needs an http or https url")
+	}
+	if raw, ok := config["headers"]; ok {
+		headers, ok := raw.(map[string]any)
+		if !ok {
+			return nil, errors.New("webhook headers must be an object of strings")
+		}
+		for name, value := range headers {
+			str, ok := value.(string)
+			if !ok {
+				return nil, errors.New("webhook headers must be an object of strings")
+			}
+			s.headers[name] = str
+		}
+	}
+	return s, nil
+}
+
+func (s *webhookSink) Send(ctx context.Context, logs []models.ExecutionLog) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, log := range logs {
+		if err := enc.Encode(log); err != nil {
+			return fmt.Errorf("encode log %s: %w", log.ID, err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(buf.Bytes())
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return do(s.client, req, "webhook post")
+}
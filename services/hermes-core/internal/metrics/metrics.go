@@ -0,0 +1,199 @@
+// Package metrics exposes the API's Prometheus metrics: request counts and
+// latencies by route, database pool stats, relay counts and the canary's probes
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/canary"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hermes_core"
+
+// How long a scrape waits on the relay count query
+const countTimeout = 2 * time.Second
+
+// Counts relays by whether they are active, read at scrape time
+type RelayCounter interface {
+	CountRelays(ctx context.Context) (active, inactive int, err error)
+}
+
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// pool and relays may be nil, which leaves their metrics out
+func New(pool *pgxpool.Pool, relays RelayCounter) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "API requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "API request latency by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if pool != nil {
+		m.registry.MustRegister(&poolCollector{pool: pool})
+	}
+	if relays != nil {
+		m.registry.MustRegister(&relayCollector{relays: relays})
+	}
+	return m
+}
+
+// Reports the canary's latest probe from now on
+func (m *Metrics) RegisterCanary(status func() canary.Status) {
+	m.registry.MustRegister(&canaryCollector{status: status})
+}
+
+// Records every request under its route pattern, e.g. /api/v1/relays/{id},
+// so IDs don't turn into labels
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	poolConnsDesc = prometheus.NewDesc(namespace+"_db_pool_connections",
+		"Database pool connections by state.", []string{"state"}, nil)
+	poolMaxDesc = prometheus.NewDesc(namespace+"_db_pool_max_connections",
+		"Largest size the database pool may grow to.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc(namespace+"_db_pool_acquires_total",
+		"Connections acquired from the database pool.", nil, nil)
+	poolWaitsDesc = prometheus.NewDesc(namespace+"_db_pool_acquire_waits_total",
+		"Acquires that had to wait for a free connection.", nil, nil)
+	poolWaitDesc = prometheus.NewDesc(namespace+"_db_pool_acquire_wait_seconds_total",
+		"Time spent waiting for a free connection.", nil, nil)
+	poolCancelsDesc = prometheus.NewDesc(namespace+"_db_pool_acquire_cancels_total",
+		"Acquires given up before a connection was free, usually on a request timeout.", nil, nil)
+	relaysDesc = prometheus.NewDesc(namespace+"_relays",
+		"Relays by whether they are active.", []string{"state"}, nil)
+	canaryUpDesc = prometheus.NewDesc(namespace+"_canary_up",
+		"Whether the last canary event made it from hermes-hooks through a worker.", nil, nil)
+	canaryLatencyDesc = prometheus.NewDesc(namespace+"_canary_latency_seconds",
+		"End-to-end time of the last successful canary event.", nil, nil)
+	canarySuccessDesc = prometheus.NewDesc(namespace+"_canary_last_success_timestamp_seconds",
+		"When a canary event last made it through.", nil, nil)
+	canaryProbesDesc = prometheus.NewDesc(namespace+"_canary_probes_total",
+		"Canary events sent, by result.", []string{"result"}, nil)
+)
+
+// Reads pgxpool's stats at scrape time
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnsDesc
+	ch <- poolMaxDesc
+	ch <- poolAcquiresDesc
+	ch <- poolWaitsDesc
+	ch <- poolWaitDesc
+	ch <- poolCancelsDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitsDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolCancelsDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
+
+// Counts relays at scrape time. A failed query leaves the metric out of that
+// scrape rather than failing it
+type relayCollector struct {
+	relays RelayCounter
+}
+
+func (c *relayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- relaysDesc
+}
+
+func (c *relayCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	active, inactive, err := c.relays.CountRelays(ctx)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(relaysDesc, prometheus.GaugeValue, float64(active), "active")
+	ch <- prometheus.MustNewConstMetric(relaysDesc, prometheus.GaugeValue, float64(inactive), "inactive")
+}
+
+// Reads the canary's status at scrape time. Nothing is reported before its
+// first probe
+type canaryCollector struct {
+	status func() canary.Status
+}
+
+func (c *canaryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- canaryUpDesc
+	ch <- canaryLatencyDesc
+	ch <- canarySuccessDesc
+	ch <- canaryProbesDesc
+}
+
+func (c *canaryCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.status()
+	if s.Runs == 0 {
+		return
+	}
+	up := 0.0
+	if s.OK {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(canaryUpDesc, prometheus.GaugeValue, up)
+	if s.LastSuccess != nil {
+		ch <- prometheus.MustNewConstMetric(canaryLatencyDesc, prometheus.GaugeValue, s.LatencyMs/1000)
+		ch <- prometheus.MustNewConstMetric(canarySuccessDesc, prometheus.GaugeValue, float64(s.LastSuccess.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(canaryProbesDesc, prometheus.CounterValue, float64(s.Runs-s.Failures), "success")
+	ch <- prometheus.MustNewConstMetric(canaryProbesDesc, prometheus.CounterValue, float64(s.Failures), "failure")
+}
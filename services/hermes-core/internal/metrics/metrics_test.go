@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/canary"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeRelays struct {
+	err error
+}
+
+func (f fakeRelays) CountRelays(ctx context.Context) (int, int, error) {
+	return 3, 1, f.err
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestRecordsRequestsByRoutePattern(t *testing.T) {
+	m := New(nil, fakeRelays{})
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/api/v1/relays/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	for _, path := range []string{"/api/v1/relays/a", "/api/v1/relays/b", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`hermes_core_http_requests_total{method="GET",route="/api/v1/relays/{id}",status="404"} 2`,
+		`hermes_core_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`hermes_core_http_request_duration_seconds_count{method="GET",route="/api/v1/relays/{id}",status="404"} 2`,
+		`hermes_core_relays{state="active"} 3`,
+		`hermes_core_relays{state="inactive"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in the scrape", want)
+		}
+	}
+}
+
+func TestFailedRelayCountIsLeftOut(t *testing.T) {
+	out := scrape(t, New(nil, fakeRelays{err: errors.New("db down")}))
+	if strings.Contains(out, "hermes_core_relays{") {
+		t.Error("Expected no relay counts when the query fails")
+	}
+}
+
+func TestReportsCanary(t *testing.T) {
+	m := New(nil, nil)
+	var status canary.Status
+	m.RegisterCanary(func() canary.Status { return status })
+	if out := scrape(t, m); strings.Contains(out, "hermes_core_canary") {
+		t.Error("Expected no canary metrics before the first probe")
+	}
+
+	succeeded := time.Unix(1760000000, 0)
+	status = canary.Status{Runs: 3, Failures: 1, LastSuccess: &succeeded, LatencyMs: 250}
+	out := scrape(t, m)
+	for _, want := range []string{
+		`hermes_core_canary_up 0`,
+		`hermes_core_canary_latency_seconds 0.25`,
+		`hermes_core_canary_last_success_timestamp_seconds 1.76e+09`,
+		`hermes_core_canary_probes_total{result="failure"} 1`,
+		`hermes_core_canary_probes_total{result="success"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in the scrape", want)
+		}
+	}
+}
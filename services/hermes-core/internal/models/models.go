@@ -1,36 +1,114 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type CreateRelayRequest struct {
-	Name        string                   `json:"name"`
-	UserID      string                   `json:"user_id"`
-	Description string                   `json:"description"`
-	Actions     []CreateRelayActionInput `json:"actions"`
+	Name        string `json:"name"`
+	UserID      string `json:"user_id"`
+	Description string `json:"description"`
+	// high, normal or low. Defaults to normal
+	Priority string `json:"priority,omitempty"`
+	// Executions allowed to run at once, 0 for unlimited and 1 for strict ordering
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// Run only after this many quiet seconds, with the latest event
+	DebounceSeconds int `json:"debounce_seconds,omitempty"`
+	// Run at most once per interval. throttle_mode "drop" (default) skips the rest, "queue" delays them
+	ThrottleSeconds int    `json:"throttle_seconds,omitempty"`
+	ThrottleMode    string `json:"throttle_mode,omitempty"`
+	// Wall clock budget for all actions of one execution, 0 for none
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// How long a delivered event ID is remembered, 0 for the worker default (24h)
+	DedupeWindowSeconds int                      `json:"dedupe_window_seconds,omitempty"`
+	Actions             []CreateRelayActionInput `json:"actions"`
 }
 
+// Collects events and runs the actions after it once per batch.
+// Config: {"window": "5m", "max_events": 50}
+const ActionAggregate = "aggregate"
+
+// Reshapes the payload for the actions after it.
+// Config: {"mappings": [{"from": "pull_request.title", "to": "text"}], "keep_unmapped": false}
+const ActionMap = "map"
+
+// Runs another relay of the same user with this event, optionally reshaped.
+// Config: {"relay_id": "...", "wait": true, "timeout": "30s", "mappings": [...]}
+const ActionCallRelay = "call_relay"
+
+// Looks data up over HTTP and merges the JSON response into the payload.
+// Config: {"url": "https://api.example.com/users/{{sender.login}}", "key": "enrichment.user",
+// "method": "GET", "headers": {"Authorization": "Bearer {{secret:TOKEN}}"}, "timeout": "5s"}
+const ActionEnrich = "enrich"
+
 type CreateRelayActionInput struct {
 	ActionType string         `json:"action_type"`
 	Config     map[string]any `json:"config"`
 	OrderIndex int            `json:"order_index"`
+	AgentGroup string         `json:"agent_group,omitempty"`
+	// Labels the executing agent must carry, e.g. {"dc": "eu1"}
+	AgentLabels     map[string]string `json:"agent_labels,omitempty"`
+	MinAgentVersion string            `json:"min_agent_version,omitempty"`
+	// Runs only once the main sequence has failed for good, with the error in its payload
+	OnFailure bool `json:"on_failure,omitempty"`
+	// A failure is recorded but the actions after it still run
+	Optional bool `json:"optional,omitempty"`
 }
 
 type UpdateRelayRequest struct {
-	Name        *string `json:"name,omitempty"`
-	Description *string `json:"description,omitempty"`
-	IsActive    *bool   `json:"is_active,omitempty"`
+	Name            *string `json:"name,omitempty"`
+	Description     *string `json:"description,omitempty"`
+	IsActive        *bool   `json:"is_active,omitempty"`
+	Priority        *string `json:"priority,omitempty"`
+	MaxConcurrency  *int    `json:"max_concurrency,omitempty"`
+	DebounceSeconds *int    `json:"debounce_seconds,omitempty"`
+	ThrottleSeconds *int    `json:"throttle_seconds,omitempty"`
+	ThrottleMode    *string `json:"throttle_mode,omitempty"`
+	TimeoutSeconds  *int    `json:"timeout_seconds,omitempty"`
+
+	DedupeWindowSeconds *int `json:"dedupe_window_seconds,omitempty"`
 }
 
 type Relay struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"user_id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	WebhookPath string    `json:"webhook_path"`
-	WebhookURL  string    `json:"webhook_url"`
-	IsActive    bool      `json:"is_active"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID              string    `json:"id"`
+	UserID          string    `json:"user_id"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	WebhookPath     string    `json:"webhook_path"`
+	WebhookURL      string    `json:"webhook_url"`
+	IsActive        bool      `json:"is_active"`
+	Priority        string    `json:"priority"`
+	MaxConcurrency  int       `json:"max_concurrency"`
+	DebounceSeconds int       `json:"debounce_seconds"`
+	ThrottleSeconds int       `json:"throttle_seconds"`
+	ThrottleMode    string    `json:"throttle_mode"`
+	TimeoutSeconds  int       `json:"timeout_seconds"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	DedupeWindowSeconds int `json:"dedupe_window_seconds"`
+	// Requests its actions send carry a signature under its signing key
+	Signed bool `json:"signed"`
+}
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+func ValidPriority(p string) bool {
+	return p == PriorityHigh || p == PriorityNormal || p == PriorityLow
+}
+
+const (
+	ThrottleDrop  = "drop"
+	ThrottleQueue = "queue"
+)
+
+func ValidThrottleMode(m string) bool {
+	return m == ThrottleDrop || m == ThrottleQueue
 }
 
 type RelayWithActions struct {
@@ -39,22 +117,436 @@ type RelayWithActions struct {
 }
 
 type RelayAction struct {
-	ID         string         `json:"id"`
-	RelayID    string         `json:"relay_id"`
-	ActionType string         `json:"action_type"`
-	Config     map[string]any `json:"config"`
-	OrderIndex int            `json:"order_index"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
+	ID              string            `json:"id"`
+	RelayID         string            `json:"relay_id"`
+	ActionType      string            `json:"action_type"`
+	Config          map[string]any    `json:"config"`
+	OrderIndex      int               `json:"order_index"`
+	AgentGroup      string            `json:"agent_group,omitempty"`
+	AgentLabels     map[string]string `json:"agent_labels,omitempty"`
+	MinAgentVersion string            `json:"min_agent_version,omitempty"`
+	OnFailure       bool              `json:"on_failure"`
+	Optional        bool              `json:"optional"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
 }
 
 type ExecutionLog struct {
-	ID           string         `json:"id"`
-	RelayID      string         `json:"relay_id"`
-	Status       string         `json:"status"`
-	Payload      map[string]any `json:"payload,omitempty"`
-	ErrorMessage string         `json:"error_message,omitempty"`
-	ExecutedAt   time.Time      `json:"executed_at"`
+	ID      string         `json:"id"`
+	RelayID string         `json:"relay_id"`
+	EventID string         `json:"event_id,omitempty"`
+	TraceID string         `json:"trace_id,omitempty"`
+	Status  string         `json:"status"`
+	Payload map[string]any `json:"payload,omitempty"`
+	// The payload is sealed at rest and can't be opened, no secrets key being
+	// set or its workspace key having been deleted
+	PayloadUnavailable bool            `json:"payload_unavailable,omitempty"`
+	ErrorMessage       string          `json:"error_message,omitempty"`
+	ExecutedAt         time.Time       `json:"executed_at"`
+	Steps              []ExecutionStep `json:"steps"`
+}
+
+// Outcome of one action within an execution
+type ExecutionStep struct {
+	ID           string    `json:"id"`
+	ActionID     string    `json:"action_id,omitempty"`
+	ActionType   string    `json:"action_type"`
+	OrderIndex   int       `json:"order_index"`
+	Status       string    `json:"status"`
+	DurationMs   int64     `json:"duration_ms"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	Response     string    `json:"response,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// One request hermes-hooks received for a relay, whether or not it was queued
+type WebhookDelivery struct {
+	ID       string `json:"id,omitempty"`
+	RelayID  string `json:"relay_id"`
+	EventID  string `json:"event_id,omitempty"`
+	SourceIP string `json:"source_ip"`
+	// HTTP status hermes-hooks answered with
+	Status int `json:"status"`
+	// Why it was turned away, empty when it was queued
+	Reason     string    `json:"reason,omitempty"`
+	SizeBytes  int64     `json:"size_bytes"`
+	LatencyMs  float64   `json:"latency_ms"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Billable usage counts
+type UsageCounts struct {
+	// Webhooks hermes-hooks queued for the user's relays, and their bytes
+	EventsIngested int64 `json:"events_ingested"`
+	BytesIngested  int64 `json:"bytes_ingested"`
+	// Runs that reached an outcome, and the actions they ran
+	Executions        int64 `json:"executions"`
+	ActionInvocations int64 `json:"action_invocations"`
+}
+
+func (c *UsageCounts) Add(o UsageCounts) {
+	c.EventsIngested += o.EventsIngested
+	c.BytesIngested += o.BytesIngested
+	c.Executions += o.Executions
+	c.ActionInvocations += o.ActionInvocations
+}
+
+// One user's usage over one day (YYYY-MM-DD). The current day's counts grow
+// until the day is closed
+type DailyUsage struct {
+	UserID string `json:"user_id"`
+	Day    string `json:"day"`
+	UsageCounts
+}
+
+// A user's usage over a range of days, as served by GET /api/v1/usage
+type UsageReport struct {
+	UserID string       `json:"user_id"`
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Totals UsageCounts  `json:"totals"`
+	Days   []DailyUsage `json:"days"`
+}
+
+type DeadLetter struct {
+	ID      string          `json:"id"`
+	RelayID string          `json:"relay_id"`
+	EventID string          `json:"event_id"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Sealed at rest and can't be opened, so it can't be requeued either
+	PayloadUnavailable bool       `json:"payload_unavailable,omitempty"`
+	Reason             string     `json:"reason"`
+	Attempts           int        `json:"attempts"`
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	RequeuedAt         *time.Time `json:"requeued_at,omitempty"`
+	// Trace of the failed run, continued when the event is requeued
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// Work item handed to a self-hosted agent
+type AgentJob struct {
+	ID             string          `json:"id"`
+	RelayID        string          `json:"relay_id"`
+	EventID        string          `json:"event_id,omitempty"`
+	AgentGroup     string          `json:"agent_group"`
+	ActionType     string          `json:"action_type"`
+	Config         map[string]any  `json:"config"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	Traceparent    string          `json:"traceparent,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Status         string          `json:"status"`
+	AgentID        string          `json:"agent_id,omitempty"`
+	Output         string          `json:"output,omitempty"`
+	ErrorMessage   string          `json:"error_message,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ClaimedAt      *time.Time      `json:"claimed_at,omitempty"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
+
+type Agent struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	AgentGroup string            `json:"agent_group"`
+	Labels     map[string]string `json:"labels"`
+	Version    string            `json:"version"`
+	Status     string            `json:"status"`
+	CreatedAt  time.Time         `json:"created_at"`
+	LastSeenAt *time.Time        `json:"last_seen_at,omitempty"`
+	RevokedAt  *time.Time        `json:"revoked_at,omitempty"`
+}
+
+type CreateEnrollmentTokenRequest struct {
+	AgentGroup string            `json:"agent_group"`
+	Labels     map[string]string `json:"labels"`
+	TTLHours   int               `json:"ttl_hours"`
+}
+
+type EnrollmentToken struct {
+	Token      string            `json:"token"`
+	AgentGroup string            `json:"agent_group"`
+	Labels     map[string]string `json:"labels"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+}
+
+// A session issued after signing in through the identity provider. The
+// token is a bearer credential for /api/v1 and is only shown once
+type Session struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// A key for machines such as CI. Token is only set in the response creating it
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	RelayIDs   []string   `json:"relay_ids"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	UserID   string   `json:"user_id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	RelayIDs []string `json:"relay_ids"`
+	// Never expires when 0
+	TTLHours int `json:"ttl_hours"`
+}
+
+type EnrollAgentRequest struct {
+	Token   string `json:"token"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type EnrollAgentResponse struct {
+	Agent      Agent  `json:"agent"`
+	AgentToken string `json:"agent_token"`
+}
+
+type AgentHeartbeatRequest struct {
+	Version string `json:"version"`
+}
+
+type AgentPollRequest struct {
+	Version     string `json:"version"`
+	WaitSeconds int    `json:"wait_seconds"`
+}
+
+type AgentJobResultRequest struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+}
+
+// Actions of type "plugin:<name>" run the uploaded WASM module with this name
+const PluginActionPrefix = "plugin:"
+
+// A WASM module uploaded for custom actions. The module bytes are never returned
+type Plugin struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	ActionType    string    `json:"action_type"`
+	SHA256        string    `json:"sha256"`
+	SizeBytes     int       `json:"size_bytes"`
+	MemoryLimitMB int       `json:"memory_limit_mb"`
+	TimeoutMs     int       `json:"timeout_ms"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// A named secret. Only its metadata is ever returned, never the value
+type Secret struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// hermes when the value is sealed here, else vault or aws-secrets-manager
+	Backend string `json:"backend"`
+	// Where the backend keeps the value
+	Reference string    `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Either Value, or Backend and Reference for a secret kept elsewhere
+type PutSecretRequest struct {
+	UserID string `json:"user_id"`
+	// Only read on POST /secrets, PUT takes it from the path
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Backend   string `json:"backend"`
+	Reference string `json:"reference"`
+}
+
+// A user given access to another user's workspace
+type WorkspaceMember struct {
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type PutWorkspaceMemberRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// An account connected through OAuth. Actions use its access token as
+// {{connection:ID}}, the tokens themselves are never returned
+type Connection struct {
+	ID       string   `json:"id"`
+	UserID   string   `json:"user_id"`
+	Provider string   `json:"provider"`
+	Name     string   `json:"name"`
+	Account  string   `json:"account,omitempty"`
+	Scopes   []string `json:"scopes"`
+	// When the access token expires, it is refreshed ahead of that
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Why the last refresh was refused. The account must be connected again
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type AuthorizeConnectionRequest struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// Masks personal data in payloads before they're logged or handed to
+// actions. Exactly one of Path, Pattern and Preset is set
+type RedactionRule struct {
+	ID string `json:"id"`
+	// Empty on global rules, which cover every workspace
+	UserID string `json:"user_id,omitempty"`
+	// Empty on rules covering every relay of the workspace
+	RelayID     string    `json:"relay_id,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Pattern     string    `json:"pattern,omitempty"`
+	Preset      string    `json:"preset,omitempty"`
+	Replacement string    `json:"replacement,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateRedactionRuleRequest struct {
+	UserID      string `json:"user_id"`
+	RelayID     string `json:"relay_id,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// An export or deletion of everything stored for a user or workspace, carried
+// out in the background
+type DataRequest struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// export or delete
+	Kind string `json:"kind"`
+	// pending, running, completed or failed
+	Status string `json:"status"`
+	// Rows exported or deleted, by kind of data, once completed
+	Report map[string]int64 `json:"report,omitempty"`
+	Error  string           `json:"error,omitempty"`
+	// Until when a completed export can be downloaded
+	ExportExpiresAt *time.Time `json:"export_expires_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+type CreateDataRequestRequest struct {
+	UserID string `json:"user_id"`
+	Kind   string `json:"kind"`
+}
+
+// A worker instance as of its last heartbeat
+type WorkerInstance struct {
+	ID                       string     `json:"id"`
+	Hostname                 string     `json:"hostname"`
+	Version                  string     `json:"version"`
+	Status                   string     `json:"status"`
+	StartedAt                time.Time  `json:"started_at"`
+	LastSeenAt               time.Time  `json:"last_seen_at"`
+	HeartbeatIntervalSeconds int        `json:"heartbeat_interval_seconds"`
+	Workers                  int        `json:"workers"`
+	ActiveJobs               int        `json:"active_jobs"`
+	QueuedJobs               int        `json:"queued_jobs"`
+	ParkedJobs               int        `json:"parked_jobs"`
+	QueueLag                 int        `json:"queue_lag"`
+	Draining                 bool       `json:"-"`
+	StoppedAt                *time.Time `json:"stopped_at,omitempty"`
+}
+
+// A per-relay alert rule. Alerts are published as events to the channel
+// relays, which deliver them like any other payload
+type AlertRule struct {
+	ID        string `json:"id"`
+	RelayID   string `json:"relay_id"`
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	// Failure rate above which failure_rate fires, between 0 and 1
+	Threshold     float64 `json:"threshold,omitempty"`
+	WindowSeconds int     `json:"window_seconds"`
+	// failure_rate: executions in the window before the rate counts.
+	// no_executions: executions the relay normally has in the 7 windows before
+	MinExecutions int        `json:"min_executions"`
+	Channels      []string   `json:"channels"`
+	State         string     `json:"state"`
+	ChangedAt     *time.Time `json:"changed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CreateAlertRuleRequest struct {
+	Name          string   `json:"name"`
+	Condition     string   `json:"condition"`
+	Threshold     float64  `json:"threshold"`
+	WindowSeconds int      `json:"window_seconds"`
+	MinExecutions int      `json:"min_executions"`
+	Channels      []string `json:"channels"`
+}
+
+// Ships a user's execution logs to an external sink. Credentials in the
+// config are {{secret:NAME}} references to the user's secrets
+type LogExport struct {
+	ID       string         `json:"id"`
+	UserID   string         `json:"user_id"`
+	Name     string         `json:"name"`
+	Sink     string         `json:"sink"`
+	Config   map[string]any `json:"config"`
+	IsActive bool           `json:"is_active"`
+	// Logs executed up to this time have been shipped
+	ExportedThrough time.Time `json:"exported_through"`
+	// The last log shipped at ExportedThrough, for paging past ties
+	CursorID       string     `json:"-"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastExportedAt *time.Time `json:"last_exported_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type CreateLogExportRequest struct {
+	UserID string         `json:"user_id"`
+	Name   string         `json:"name"`
+	Sink   string         `json:"sink"`
+	Config map[string]any `json:"config"`
+}
+
+// Body of PUT /admin/flags/{name}. RolloutPercent defaults to 100
+type PutFeatureFlagRequest struct {
+	Description    string `json:"description"`
+	Enabled        bool   `json:"enabled"`
+	RolloutPercent *int   `json:"rollout_percent"`
+}
+
+// Body of PUT /admin/flags/{name}/users/{userID}
+type FlagOverrideRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// System-wide counts for the ops dashboard. Executions only count runs that
+// reached an outcome, not ones held, deferred or cancelled
+type SystemOverview struct {
+	Relays           int     `json:"relays"`
+	ActiveRelays     int     `json:"active_relays"`
+	Executions24h    int     `json:"executions_24h"`
+	FailedExecutions int     `json:"failed_executions_24h"`
+	FailureRate      float64 `json:"failure_rate"`
+	// Events the broker hasn't delivered to a worker yet
+	QueueLag int `json:"queue_lag"`
+	DLQDepth int `json:"dlq_depth"`
+	// Worker instances online or draining
+	Workers     int       `json:"workers"`
+	GeneratedAt time.Time `json:"generated_at"`
 }
 
 type APIResponse struct {
@@ -0,0 +1,128 @@
+// Package partitions maintains the daily partitions of the execution log
+// tables: the coming week is created ahead of time, and days past retention
+// are dropped whole
+package partitions
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+// Days created ahead of today
+const daysAhead = 7
+
+// How long one maintenance pass may take
+const maintainTimeout = 5 * time.Minute
+
+// Partition management, implemented by *store.PartitionStore
+type Store interface {
+	Now(ctx context.Context) (time.Time, error)
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+	ListPartitions(ctx context.Context, table string) ([]store.Partition, error)
+	CreatePartition(ctx context.Context, table string, day time.Time) (bool, error)
+	DropPartition(ctx context.Context, name string) error
+}
+
+type Maintainer struct {
+	store     Store
+	logger    *slog.Logger
+	interval  time.Duration
+	retention time.Duration
+}
+
+// A zero retention keeps every partition
+func New(s Store, logger *slog.Logger, interval, retention time.Duration) *Maintainer {
+	return &Maintainer{store: s, logger: logger, interval: interval, retention: retention}
+}
+
+// Maintains the partitions now and then each interval until ctx is done
+func (m *Maintainer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Maintain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// One pass, skipped when another instance is already at it
+func (m *Maintainer) Maintain(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, maintainTimeout)
+	defer cancel()
+	unlock, ok, err := m.store.TryLock(ctx)
+	if err != nil {
+		m.logger.Error("failed to lock partitions", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		m.logger.Debug("partitions are being maintained by another instance")
+		return
+	}
+	defer unlock()
+	now, err := m.store.Now(ctx)
+	if err != nil {
+		m.logger.Error("failed to maintain partitions", slog.String("error", err.Error()))
+		return
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, table := range store.PartitionedTables {
+		if err := m.maintain(ctx, table, today, now); err != nil {
+			m.logger.Error("failed to maintain partitions", slog.String("table", table),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (m *Maintainer) maintain(ctx context.Context, table string, today, now time.Time) error {
+	partitions, err := m.store.ListPartitions(ctx, table)
+	if err != nil {
+		return err
+	}
+	for i := range daysAhead + 1 {
+		day := today.AddDate(0, 0, i)
+		if covered(partitions, day) {
+			continue
+		}
+		created, err := m.store.CreatePartition(ctx, table, day)
+		if err != nil {
+			return err
+		}
+		if created {
+			m.logger.Info("partition created", slog.String("table", table), slog.Time("day", day))
+		}
+	}
+	if m.retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-m.retention)
+	for _, p := range partitions {
+		if p.To.After(cutoff) {
+			continue
+		}
+		if err := m.store.DropPartition(ctx, p.Name); err != nil {
+			return err
+		}
+		m.logger.Info("expired partition dropped", slog.String("table", table),
+			slog.String("partition", p.Name),
+			slog.Time("until", p.To))
+	}
+	return nil
+}
+
+// Whether an existing partition already holds the day, like the legacy one
+// does for the day of the cutover
+func covered(partitions []store.Partition, day time.Time) bool {
+	for _, p := range partitions {
+		if !day.Before(p.From) && day.Before(p.To) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package partitions
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+type fakeStore struct {
+	now        time.Time
+	locked     bool
+	partitions map[string][]store.Partition
+	created    []string
+	dropped    []string
+}
+
+func (f *fakeStore) Now(context.Context) (time.Time, error) { return f.now, nil }
+
+func (f *fakeStore) TryLock(context.Context) (func(), bool, error) {
+	if f.locked {
+		return nil, false, nil
+	}
+	return func() {}, true, nil
+}
+
+func (f *fakeStore) ListPartitions(_ context.Context, table string) ([]store.Partition, error) {
+	return f.partitions[table], nil
+}
+
+func (f *fakeStore) CreatePartition(_ context.Context, table string, day time.Time) (bool, error) {
+	f.created = append(f.created, table+"_p"+day.Format("20060102"))
+	return true, nil
+}
+
+func (f *fakeStore) DropPartition(_ context.Context, name string) error {
+	f.dropped = append(f.dropped, name)
+	return nil
+}
+
+func day(d int) time.Time {
+	return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMaintainCreatesAheadAndDropsExpired(t *testing.T) {
+	f := &fakeStore{
+		now: day(16).Add(13 * time.Hour),
+		partitions: map[string][]store.Partition{
+			"execution_logs": {
+				{Name: "execution_logs_legacy", To: day(10)},
+				{Name: "execution_logs_p20261010", From: day(10), To: day(11)},
+				{Name: "execution_logs_p20261016", From: day(16), To: day(17)},
+			},
+			"execution_steps": {
+				{Name: "execution_steps_legacy", To: day(10)},
+				{Name: "execution_steps_p20261010", From: day(10), To: day(11)},
+				{Name: "execution_steps_p20261016", From: day(16), To: day(17)},
+			},
+		},
+	}
+	New(f, logger.New("hermes-core-test", "test", "error"), time.Hour, 5*24*time.Hour).Maintain(context.Background())
+
+	if len(f.created) != 14 || slices.Contains(f.created, "execution_logs_p20261016") ||
+		!slices.Contains(f.created, "execution_logs_p20261023") || slices.Contains(f.created, "execution_logs_p20261024") {
+		t.Errorf("Expected the missing days up to a week ahead, got %v", f.created)
+	}
+	want := []string{"execution_steps_legacy", "execution_steps_p20261010", "execution_logs_legacy", "execution_logs_p20261010"}
+	if !slices.Equal(f.dropped, want) {
+		t.Errorf("Expected %v dropped, steps first, got %v", want, f.dropped)
+	}
+}
+
+func TestMaintainKeepsEverythingWithoutRetention(t *testing.T) {
+	f := &fakeStore{now: day(16), partitions: map[string][]store.Partition{
+		"execution_logs": {{Name: "execution_logs_legacy", To: day(1)}},
+	}}
+	New(f, logger.New("hermes-core-test", "test", "error"), time.Hour, 0).Maintain(context.Background())
+	if len(f.dropped) != 0 {
+		t.Errorf("Expected nothing dropped, got %v", f.dropped)
+	}
+}
+
+func TestMaintainSkipsWhenLocked(t *testing.T) {
+	f := &fakeStore{now: day(16), locked: true}
+	New(f, logger.New("hermes-core-test", "test", "error"), time.Hour, time.Hour).Maintain(context.Background())
+	if len(f.created) != 0 {
+		t.Errorf("Expected another instance's pass to be left alone, got %v", f.created)
+	}
+}
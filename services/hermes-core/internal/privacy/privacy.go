@@ -0,0 +1,138 @@
+// Package privacy carries out requests to export or delete everything stored
+// for a user or workspace, as data protection law asks of us. Requests queue
+// in data_requests and one core instance at a time works through them, oldest
+// first, recording a report of what was exported or deleted
+package privacy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+const (
+	// How long a finished export can be downloaded
+	ExportTTL = 7 * 24 * time.Hour
+	// How long one request may take
+	requestTimeout = 30 * time.Minute
+)
+
+// Data requests, implemented by *store.DataRequestStore
+type Store interface {
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+	NextDataRequest(ctx context.Context) (*models.DataRequest, error)
+	ExportUserData(ctx context.Context, userID string, w io.Writer) (map[string]int64, error)
+	DeleteUserData(ctx context.Context, userID string) (map[string]int64, error)
+	CompleteDataRequest(ctx context.Context, id string, report map[string]int64, export []byte, ttl time.Duration) error
+	FailDataRequest(ctx context.Context, id, reason string) error
+	DropExpiredExports(ctx context.Context) (int64, error)
+}
+
+type Processor struct {
+	store    Store
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+func New(s Store, logger *slog.Logger, interval time.Duration) *Processor {
+	return &Processor{store: s, logger: logger, interval: interval}
+}
+
+// Works through requests now and then each interval until ctx is done
+func (p *Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Process(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// One pass over every open request, skipped while another core instance
+// holds the lock
+func (p *Processor) Process(ctx context.Context) {
+	unlock, ok, err := p.store.TryLock(ctx)
+	if err != nil {
+		p.logger.Error("failed to take data request lock", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+	if dropped, err := p.store.DropExpiredExports(ctx); err != nil {
+		p.logger.Error("failed to drop expired exports", slog.String("error", err.Error()))
+	} else if dropped > 0 {
+		p.logger.Info("expired exports dropped", slog.Int64("count", dropped))
+	}
+	for ctx.Err() == nil {
+		req, err := p.store.NextDataRequest(ctx)
+		if err != nil {
+			p.logger.Error("failed to claim data request", slog.String("error", err.Error()))
+			return
+		}
+		if req == nil {
+			return
+		}
+		p.handle(ctx, req)
+	}
+}
+
+func (p *Processor) handle(ctx context.Context, req *models.DataRequest) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	logger := p.logger.With(slog.String("request_id", req.ID), logfields.UserID(req.UserID),
+		slog.String("kind", req.Kind))
+	var report map[string]int64
+	var export []byte
+	var err error
+	switch req.Kind {
+	case store.DataRequestExport:
+		report, export, err = p.export(ctx, req.UserID)
+	case store.DataRequestDelete:
+		report, err = p.store.DeleteUserData(ctx, req.UserID)
+	default:
+		err = fmt.Errorf("unknown data request kind %q", req.Kind)
+	}
+	// Recording the outcome shouldn't fail with the request's own deadline
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancelSave()
+	if err != nil {
+		logger.Error("data request failed", slog.String("error", err.Error()))
+		if failErr := p.store.FailDataRequest(saveCtx, req.ID, err.Error()); failErr != nil {
+			logger.Error("failed to record data request failure", slog.String("error", failErr.Error()))
+		}
+		return
+	}
+	if err := p.store.CompleteDataRequest(saveCtx, req.ID, report, export, ExportTTL); err != nil {
+		logger.Error("failed to complete data request", slog.String("error", err.Error()))
+		return
+	}
+	logger.Info("data request completed", slog.Any("report", report))
+}
+
+// The user's data as gzipped JSON
+func (p *Processor) export(ctx context.Context, userID string) (map[string]int64, []byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	report, err := p.store.ExportUserData(ctx, userID, gz)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+	return report, buf.Bytes(), nil
+}
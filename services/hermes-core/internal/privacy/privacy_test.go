@@ -0,0 +1,86 @@
+package privacy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+type fakeStore struct {
+	queue     []*models.DataRequest
+	completed map[string]map[string]int64
+	exports   map[string][]byte
+	failed    map[string]string
+}
+
+func (f *fakeStore) TryLock(context.Context) (func(), bool, error) { return func() {}, true, nil }
+
+func (f *fakeStore) NextDataRequest(context.Context) (*models.DataRequest, error) {
+	if len(f.queue) == 0 {
+		return nil, nil
+	}
+	req := f.queue[0]
+	f.queue = f.queue[1:]
+	return req, nil
+}
+
+func (f *fakeStore) ExportUserData(_ context.Context, userID string, w io.Writer) (map[string]int64, error) {
+	if userID == "gone" {
+		return nil, errors.New("user not found")
+	}
+	_, err := io.WriteString(w, `{"user":{"id":"`+userID+`"},"relays":[]}`)
+	return map[string]int64{"relays": 0}, err
+}
+
+func (f *fakeStore) DeleteUserData(context.Context, string) (map[string]int64, error) {
+	return map[string]int64{"relays": 2, "users": 1}, nil
+}
+
+func (f *fakeStore) CompleteDataRequest(_ context.Context, id string, report map[string]int64, export []byte, _ time.Duration) error {
+	f.completed[id] = report
+	f.exports[id] = export
+	return nil
+}
+
+func (f *fakeStore) FailDataRequest(_ context.Context, id, reason string) error {
+	f.failed[id] = reason
+	return nil
+}
+
+func (f *fakeStore) DropExpiredExports(context.Context) (int64, error) { return 0, nil }
+
+func TestProcessCompletesEveryRequest(t *testing.T) {
+	s := &fakeStore{
+		queue: []*models.DataRequest{
+			{ID: "e1", UserID: "u1", Kind: "export"},
+			{ID: "d1", UserID: "u1", Kind: "delete"},
+			{ID: "e2", UserID: "gone", Kind: "export"},
+		},
+		completed: map[string]map[string]int64{},
+		exports:   map[string][]byte{},
+		failed:    map[string]string{},
+	}
+	New(s, logger.New("hermes-core-test", "test", "error"), time.Minute).Process(context.Background())
+
+	gz, err := gzip.NewReader(bytes.NewReader(s.exports["e1"]))
+	if err != nil {
+		t.Fatalf("Expected a gzipped export, got %v", err)
+	}
+	body, _ := io.ReadAll(gz)
+	if string(body) != `{"user":{"id":"u1"},"relays":[]}` {
+		t.Errorf("Expected the exported JSON, got %s", body)
+	}
+	if report := s.completed["d1"]; report["users"] != 1 || s.exports["d1"] != nil {
+		t.Errorf("Expected the deletion reported without an export, got %v", report)
+	}
+	if s.failed["e2"] == "" {
+		t.Errorf("Expected the export of a missing user failed, got %v", s.failed)
+	}
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/api"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+// Subject workers listen on for cancelled executions. Outside the event stream
+const CancelSubject = "hermes.cancel"
+
+// Subject hermes-hooks reports each webhook request on, and the group that
+// has one core instance record each of them
+const (
+	DeliverySubject = "hermes.deliveries"
+	deliveryGroup   = "hermes-core"
+)
+
+const publishTimeout = 10 * time.Second
+
+// Publishes onto the broker BROKER selects
+type Publisher struct {
+	broker broker.Broker
+}
+
+var (
+	_ api.EventPublisher = (*Publisher)(nil)
+	_ api.QueueLagReader = (*Publisher)(nil)
+)
+
+// Same envelope hermes-hooks publishes, so the worker can't tell requeues apart
+type executionEvent struct {
+	EventID    string          `json:"event_id"`
+	RelayID    string          `json:"relay_id"`
+	Payload    json.RawMessage `json:"payload"`
+	ReceivedAt time.Time       `json:"received_at"`
+	// The worker continues this trace, or starts a new one when empty
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+func NewPublisher(b broker.Broker) *Publisher {
+	return &Publisher{broker: b}
+}
+
+func (p *Publisher) PublishEvent(relayID, eventID, traceparent string, payload json.RawMessage) error {
+	data, err := json.Marshal(executionEvent{
+		EventID:     eventID,
+		RelayID:     relayID,
+		Payload:     payload,
+		ReceivedAt:  time.Now(),
+		Traceparent: traceparent,
+	})
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	// No dedup ID: a requeue republishes an event ID the broker has seen
+	return p.broker.Publish(ctx, relayID, "", traceparent, data)
+}
+
+// Broadcast to every worker. Workers that miss it still see the cancellation
+// recorded in the database before running the event
+func (p *Publisher) PublishCancel(relayID, eventID string) error {
+	data, err := json.Marshal(map[string]string{"relay_id": relayID, "event_id": eventID})
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return p.broker.Broadcast(ctx, CancelSubject, data)
+}
+
+// Events in the stream not yet delivered to the workers
+func (p *Publisher) QueueLag() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return p.broker.Pending(ctx)
+}
+
+// Calls record for every webhook request hermes-hooks reports. A broadcast,
+// so deliveries reported while no core instance is connected aren't logged
+func (p *Publisher) SubscribeDeliveries(record func(models.WebhookDelivery)) error {
+	_, err := p.broker.Subscribe(DeliverySubject, deliveryGroup, func(data []byte) {
+		var d models.WebhookDelivery
+		if err := json.Unmarshal(data, &d); err != nil || d.RelayID == "" {
+			return
+		}
+		record(d)
+	})
+	return err
+}
+
+func (p *Publisher) Close() {
+	_ = p.broker.Close()
+}
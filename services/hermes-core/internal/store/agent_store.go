@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AgentStore struct {
+	db *pgxpool.Pool
+	// Opens job payloads sealed at rest. May be nil
+	payloads *secrets.Envelope
+}
+
+var (
+	ErrAgentJobNotFound  = errors.New("agent job not found or not claimed by this agent")
+	ErrAgentNotFound     = errors.New("agent not found")
+	ErrInvalidEnrollment = errors.New("enrollment token is invalid, expired or already used")
+	ErrInvalidAgentToken = errors.New("agent token is invalid or revoked")
+)
+
+const (
+	// Agents not seen for longer than this are reported offline
+	agentOnlineWindow = 60 * time.Second
+	// Pending jobs inspected per claim when looking for one the agent may run
+	claimCandidatesPerAttempt = 20
+)
+
+const (
+	AgentJobPending   = "pending"
+	AgentJobClaimed   = "claimed"
+	AgentJobSucceeded = "succeeded"
+	AgentJobFailed    = "failed"
+
+	AgentStatusOnline  = "online"
+	AgentStatusOffline = "offline"
+	AgentStatusRevoked = "revoked"
+)
+
+// Decides whether an agent at agentVersion may run a job of actionType that asks for minVersion
+type VersionPolicy func(actionType, minVersion, agentVersion string) bool
+
+// payloads may be nil, jobs with sealed payloads then fail when claimed
+func NewAgentStore(db *pgxpool.Pool, payloads *secrets.Envelope) *AgentStore {
+	return &AgentStore{db: db, payloads: payloads}
+}
+
+func newToken(prefix string) (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("generate token: %w", err)
+	}
+	token := prefix + hex.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func agentStatus(a *models.Agent) string {
+	switch {
+	case a.RevokedAt != nil:
+		return AgentStatusRevoked
+	case a.LastSeenAt != nil && time.Since(*a.LastSeenAt) < agentOnlineWindow:
+		return AgentStatusOnline
+	default:
+		return AgentStatusOffline
+	}
+}
+
+const agentColumns = `id, name, agent_group, labels, version, created_at, last_seen_at, revoked_at`
+
+func scanAgent(row pgx.Row) (*models.Agent, error) {
+	var a models.Agent
+	if err := row.Scan(&a.ID, &a.Name, &a.AgentGroup, &a.Labels, &a.Version, &a.CreatedAt, &a.LastSeenAt, &a.RevokedAt); err != nil {
+		return nil, err
+	}
+	a.Status = agentStatus(&a)
+	return &a, nil
+}
+
+// Creates a single-use enrollment token. The plaintext is only returned here
+func (s *AgentStore) CreateEnrollmentToken(ctx context.Context, group string, labels map[string]string, ttl time.Duration) (*models.EnrollmentToken, error) {
+	token, hash, err := newToken("hae_")
+	if err != nil {
+		return nil, err
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	expiresAt := time.Now().Add(ttl)
+	query := `INSERT INTO agent_enrollment_tokens (token_hash, agent_group, labels, expires_at) VALUES ($1,$2,$3,$4)`
+	if _, err := s.db.Exec(ctx, query, hash, group, labels, expiresAt); err != nil {
+		return nil, fmt.Errorf("insert enrollment token: %w", err)
+	}
+	return &models.EnrollmentToken{Token: token, AgentGroup: group, Labels: labels, ExpiresAt: expiresAt}, nil
+}
+
+// Consumes an enrollment token and registers the agent. Its labels come only
+// from the token, so an agent can't grant itself another group's targeting
+func (s *AgentStore) Enroll(ctx context.Context, req models.EnrollAgentRequest) (*models.EnrollAgentResponse, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var group string
+	var tokenLabels map[string]string
+	err = tx.QueryRow(ctx, `UPDATE agent_enrollment_tokens SET used_at = NOW()
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	RETURNING agent_group, labels`, hashToken(req.Token)).Scan(&group, &tokenLabels)
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvalidEnrollment
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume enrollment token: %w", err)
+	}
+
+	labels := tokenLabels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	token, hash, err := newToken("hat_")
+	if err != nil {
+		return nil, err
+	}
+	agent, err := scanAgent(tx.QueryRow(ctx, `INSERT INTO agents (name, agent_group, labels, version, token_hash, last_seen_at)
+	VALUES ($1,$2,$3,$4,$5,NOW())
+	RETURNING `+agentColumns, req.Name, group, labels, req.Version, hash))
+	if err != nil {
+		return nil, fmt.Errorf("insert agent: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return &models.EnrollAgentResponse{Agent: *agent, AgentToken: token}, nil
+}
+
+// Resolves an agent credential, rejecting revoked agents
+func (s *AgentStore) Authenticate(ctx context.Context, token string) (*models.Agent, error) {
+	query := `SELECT ` + agentColumns + ` FROM agents WHERE token_hash = $1 AND revoked_at IS NULL`
+	agent, err := scanAgent(s.db.QueryRow(ctx, query, hashToken(token)))
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvalidAgentToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query agent: %w", err)
+	}
+	return agent, nil
+}
+
+// Records that the agent is alive and which version it runs
+func (s *AgentStore) Heartbeat(ctx context.Context, agentID, version string) error {
+	query := `UPDATE agents SET last_seen_at = NOW(), version = COALESCE(NULLIF($2, ''), version) WHERE id = $1`
+	if _, err := s.db.Exec(ctx, query, agentID, version); err != nil {
+		return fmt.Errorf("agent heartbeat: %w", err)
+	}
+	return nil
+}
+
+func (s *AgentStore) ListAgents(ctx context.Context, group string) ([]models.Agent, error) {
+	query := `SELECT ` + agentColumns + ` FROM agents
+	WHERE ($1 = '' OR agent_group = $1)
+	ORDER BY agent_group, name`
+	rows, err := s.db.Query(ctx, query, group)
+	if err != nil {
+		return nil, fmt.Errorf("query agents: %w", err)
+	}
+	defer rows.Close()
+	agents := make([]models.Agent, 0)
+	for rows.Next() {
+		a, err := scanAgent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan agent: %w", err)
+		}
+		agents = append(agents, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return agents, nil
+}
+
+func (s *AgentStore) RevokeAgent(ctx context.Context, agentID string) error {
+	result, err := s.db.Exec(ctx, `UPDATE agents SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, agentID)
+	if err != nil {
+		return fmt.Errorf("revoke agent: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
+
+// Atomically hands the oldest pending job the agent is eligible for to it:
+// same group, carries every required label and passes the version policy.
+// Returns nil when nothing is waiting
+func (s *AgentStore) ClaimJob(ctx context.Context, agent *models.Agent, allowed VersionPolicy) (*models.AgentJob, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	labels := agent.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	rows, err := tx.Query(ctx, `SELECT id, action_type, COALESCE(min_agent_version, '')
+	FROM agent_jobs
+	WHERE agent_group = $1 AND status = $2 AND expires_at > NOW() AND agent_labels <@ $3::jsonb
+	ORDER BY created_at ASC
+	LIMIT $4
+	FOR UPDATE SKIP LOCKED`, agent.AgentGroup, AgentJobPending, labels, claimCandidatesPerAttempt)
+	if err != nil {
+		return nil, fmt.Errorf("query agent jobs: %w", err)
+	}
+	var chosen string
+	for rows.Next() {
+		var id, actionType, minVersion string
+		if err := rows.Scan(&id, &actionType, &minVersion); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan agent job: %w", err)
+		}
+		if allowed == nil || allowed(actionType, minVersion, agent.Version) {
+			chosen = id
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	if chosen == "" {
+		return nil, nil
+	}
+
+	var job models.AgentJob
+	var configBytes, payloadBytes, sealed, dataKey []byte
+	err = tx.QueryRow(ctx, `UPDATE agent_jobs SET status = $2, agent_id = $3, claimed_at = NOW()
+	WHERE id = $1
+	RETURNING id, relay_id, COALESCE(event_id, ''), agent_group, action_type, config, payload, payload_sealed,
+		(SELECT k.data_key FROM workspace_keys k WHERE k.id = payload_key),
+		COALESCE(traceparent, ''), COALESCE(idempotency_key, ''), status, agent_id, created_at, claimed_at, expires_at`,
+		chosen, AgentJobClaimed, agent.ID).Scan(
+		&job.ID,
+		&job.RelayID,
+		&job.EventID,
+		&job.AgentGroup,
+		&job.ActionType,
+		&configBytes,
+		&payloadBytes,
+		&sealed,
+		&dataKey,
+		&job.Traceparent,
+		&job.IdempotencyKey,
+		&job.Status,
+		&job.AgentID,
+		&job.CreatedAt,
+		&job.ClaimedAt,
+		&job.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("claim agent job: %w", err)
+	}
+	if sealed != nil {
+		opened, ok, openErr := openPayload(ctx, s.payloads, sealed, dataKey)
+		if openErr == nil && !ok {
+			openErr = ErrPayloadUnavailable
+		}
+		if openErr != nil {
+			// No agent could run it, failing it now spares the worker waiting out the expiry
+			if _, err := tx.Exec(ctx, `UPDATE agent_jobs SET status = $2, error_message = $3, completed_at = NOW() WHERE id = $1`,
+				chosen, AgentJobFailed, openErr.Error()); err != nil {
+				return nil, fmt.Errorf("fail agent job: %w", err)
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return nil, fmt.Errorf("commit transaction: %w", err)
+			}
+			return nil, fmt.Errorf("agent job %s: %w", chosen, openErr)
+		}
+		payloadBytes = opened
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	if err := json.Unmarshal(configBytes, &job.Config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if len(payloadBytes) > 0 {
+		job.Payload = payloadBytes
+	}
+	return &job, nil
+}
+
+// Records the outcome reported by the agent holding the claim
+func (s *AgentStore) CompleteJob(ctx context.Context, id, agentID string, res models.AgentJobResultRequest) error {
+	status := AgentJobFailed
+	if res.Success {
+		status = AgentJobSucceeded
+	}
+	query := `UPDATE agent_jobs
+	SET status = $1, output = $2, error_message = NULLIF($3, ''), completed_at = NOW()
+	WHERE id = $4 AND agent_id = $5 AND status = $6`
+	result, err := s.db.Exec(ctx, query, status, res.Output, res.Error, id, agentID, AgentJobClaimed)
+	if err != nil {
+		return fmt.Errorf("complete agent job: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAgentJobNotFound
+	}
+	return nil
+}
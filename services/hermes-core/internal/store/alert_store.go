@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AlertStore struct {
+	db *pgxpool.Pool
+}
+
+var (
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	// A channel names a relay that doesn't exist
+	ErrAlertChannelNotFound = errors.New("alert channel relay not found")
+)
+
+const (
+	AlertConditionFailureRate  = "failure_rate"
+	AlertConditionNoExecutions = "no_executions"
+
+	AlertStateOK     = "ok"
+	AlertStateFiring = "firing"
+)
+
+// Execution counts over an alert rule's window
+type AlertStats struct {
+	Executions int
+	Failures   int
+	// Executions in the lookback before the window
+	Baseline int
+}
+
+func NewAlertStore(db *pgxpool.Pool) *AlertStore {
+	return &AlertStore{db: db}
+}
+
+const alertRuleColumns = `id, relay_id, name, condition, threshold, window_seconds, min_executions, channels, state, changed_at, created_at`
+
+func scanAlertRule(row pgx.Row) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := row.Scan(&rule.ID, &rule.RelayID, &rule.Name, &rule.Condition, &rule.Threshold, &rule.WindowSeconds,
+		&rule.MinExecutions, &rule.Channels, &rule.State, &rule.ChangedAt, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (s *AlertStore) CreateAlertRule(ctx context.Context, relayID string, req models.CreateAlertRuleRequest) (*models.AlertRule, error) {
+	var found int
+	if err := s.db.QueryRow(ctx, `SELECT count(*) FROM relays WHERE id::text = ANY($1)`, req.Channels).Scan(&found); err != nil {
+		return nil, fmt.Errorf("check channels: %w", err)
+	}
+	if found != len(req.Channels) {
+		return nil, ErrAlertChannelNotFound
+	}
+	rule, err := scanAlertRule(s.db.QueryRow(ctx, `INSERT INTO alert_rules
+	(relay_id, name, condition, threshold, window_seconds, min_executions, channels)
+	SELECT id, $2, $3, $4, $5, $6, $7 FROM relays WHERE id = $1
+	RETURNING `+alertRuleColumns,
+		relayID, req.Name, req.Condition, req.Threshold, req.WindowSeconds, req.MinExecutions, req.Channels))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRelayNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("insert alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *AlertStore) ListAlertRules(ctx context.Context, relayID string) ([]models.AlertRule, error) {
+	return s.list(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE relay_id = $1 ORDER BY created_at`, relayID)
+}
+
+// Rules of active relays, for the evaluator
+func (s *AlertStore) ActiveRules(ctx context.Context) ([]models.AlertRule, error) {
+	return s.list(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules
+	WHERE relay_id IN (SELECT id FROM relays WHERE is_active)`)
+}
+
+func (s *AlertStore) list(ctx context.Context, query string, args ...any) ([]models.AlertRule, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query alert rules: %w", err)
+	}
+	defer rows.Close()
+	rules := make([]models.AlertRule, 0)
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan alert rule: %w", err)
+		}
+		rules = append(rules, *rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return rules, nil
+}
+
+func (s *AlertStore) DeleteAlertRule(ctx context.Context, relayID, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrAlertRuleNotFound
+	}
+	result, err := s.db.Exec(ctx, `DELETE FROM alert_rules WHERE id = $1 AND relay_id = $2`, id, relayID)
+	if err != nil {
+		return fmt.Errorf("delete alert rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+// Counts the relay's executions over the last window, and over the lookback
+// before it. Only runs that reached an outcome count, failures being failed
+// and timed out ones
+func (s *AlertStore) Stats(ctx context.Context, relayID string, window, lookback time.Duration) (AlertStats, error) {
+	var stats AlertStats
+	err := s.db.QueryRow(ctx, `SELECT
+		count(*) FILTER (WHERE executed_at > NOW() - make_interval(secs => $2)),
+		count(*) FILTER (WHERE executed_at > NOW() - make_interval(secs => $2) AND status IN ('failed', 'timeout')),
+		count(*) FILTER (WHERE executed_at <= NOW() - make_interval(secs => $2))
+	FROM execution_logs
+	WHERE relay_id = $1 AND executed_at > NOW() - make_interval(secs => $3)
+	AND status IN ('success', 'partial_success', 'failed', 'timeout')`,
+		relayID, window.Seconds(), (window+lookback).Seconds(),
+	).Scan(&stats.Executions, &stats.Failures, &stats.Baseline)
+	if err != nil {
+		return AlertStats{}, fmt.Errorf("count executions: %w", err)
+	}
+	return stats, nil
+}
+
+// Moves a rule from one state to another. Only one caller wins when several
+// core instances evaluate the same rule, and only the winner gets true
+func (s *AlertStore) Transition(ctx context.Context, id, from, to string) (bool, error) {
+	result, err := s.db.Exec(ctx, `UPDATE alert_rules SET state = $3, changed_at = NOW()
+	WHERE id = $1 AND state = $2`, id, from, to)
+	if err != nil {
+		return false, fmt.Errorf("update alert state: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("api key is invalid or expired")
+)
+
+// Prefix of API keys, telling them apart from sessions and JWTs
+const APIKeyPrefix = "hak_"
+
+type APIKeyStore struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyStore(db *pgxpool.Pool) *APIKeyStore {
+	return &APIKeyStore{db: db}
+}
+
+const apiKeyColumns = `id, user_id::text, name, scopes, relay_ids::text[], created_at, expires_at, last_used_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Scopes, &k.RelayIDs, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Creates a key. Its token is only returned here
+func (s *APIKeyStore) CreateAPIKey(ctx context.Context, req models.CreateAPIKeyRequest) (*models.APIKey, error) {
+	token, hash, err := newToken(APIKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var expiresAt *time.Time
+	if req.TTLHours > 0 {
+		at := time.Now().Add(time.Duration(req.TTLHours) * time.Hour)
+		expiresAt = &at
+	}
+	relayIDs := req.RelayIDs
+	if relayIDs == nil {
+		relayIDs = []string{}
+	}
+	query := `INSERT INTO api_keys (user_id, name, key_hash, scopes, relay_ids, expires_at)
+	VALUES ($1, $2, $3, $4, $5::uuid[], $6)
+	RETURNING ` + apiKeyColumns
+	key, err := scanAPIKey(s.db.QueryRow(ctx, query, req.UserID, req.Name, hash, req.Scopes, relayIDs, expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("insert api key: %w", err)
+	}
+	key.Token = token
+	return key, nil
+}
+
+func (s *APIKeyStore) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id::text = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *APIKeyStore) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM api_keys WHERE user_id::text = $1 AND id::text = $2`, userID, id)
+	if err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// The workspace a live key belongs to and what it was granted
+func (s *APIKeyStore) KeyGrant(ctx context.Context, token string) (string, *auth.KeyGrant, error) {
+	var userID string
+	var scopes []string
+	grant := &auth.KeyGrant{}
+	err := s.db.QueryRow(ctx, `UPDATE api_keys SET last_used_at = NOW()
+	WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	RETURNING user_id::text, scopes, relay_ids::text[]`, hashToken(token)).Scan(&userID, &scopes, &grant.RelayIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("find api key: %w", err)
+	}
+	for _, scope := range scopes {
+		grant.Scopes = append(grant.Scopes, auth.Scope(scope))
+	}
+	return userID, grant, nil
+}
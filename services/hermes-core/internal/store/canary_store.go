@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The relay the canary sends its probes to, owned by a system user. Fixed so
+// every core instance probes the same one
+const (
+	CanaryUserID  = "ca9a7000-0000-4000-8000-000000000001"
+	CanaryRelayID = "ca9a7000-0000-4000-8000-000000000002"
+)
+
+type CanaryStore struct {
+	db *pgxpool.Pool
+}
+
+func NewCanaryStore(db *pgxpool.Pool) *CanaryStore {
+	return &CanaryStore{db: db}
+}
+
+// Creates the canary relay with its single noop action unless it exists
+func (s *CanaryStore) EnsureCanaryRelay(ctx context.Context) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `INSERT INTO users (id, username, email)
+	VALUES ($1, 'hermes-canary', 'canary@hermes.internal') ON CONFLICT DO NOTHING`, CanaryUserID); err != nil {
+		return fmt.Errorf("insert canary user: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO relays (id, user_id, name, description, webhook_path)
+	VALUES ($1, $2, 'canary', 'Synthetic end-to-end probe run by hermes-core', $3)
+	ON CONFLICT DO NOTHING`, CanaryRelayID, CanaryUserID, "/hooks/"+CanaryRelayID); err != nil {
+		return fmt.Errorf("insert canary relay: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO relay_actions (relay_id, action_type, config, order_index)
+	VALUES ($1, 'noop', '{}', 0) ON CONFLICT DO NOTHING`, CanaryRelayID); err != nil {
+		return fmt.Errorf("insert canary action: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// The status the canary relay's run of eventID ended with. found is false
+// while no worker has logged it yet
+func (s *CanaryStore) ProbeOutcome(ctx context.Context, eventID string) (status string, found bool, err error) {
+	err = s.db.QueryRow(ctx, `SELECT status FROM execution_logs
+	WHERE relay_id = $1 AND event_id = $2 AND executed_at > NOW() - INTERVAL '1 hour'
+	ORDER BY executed_at DESC LIMIT 1`, CanaryRelayID, eventID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("query probe outcome: %w", err)
+	}
+	return status, true, nil
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrConnectionNotFound     = errors.New("connection not found")
+	ErrConnectionStateInvalid = errors.New("connection authorization is unknown or expired")
+)
+
+// Advisory lock held while tokens are refreshed, so one core instance refreshes at a time
+const connectionLockKey int64 = 0x6865726d6576
+
+// How long an authorization may take between leaving for the provider and coming back
+const connectionStateTTL = 10 * time.Minute
+
+// Tokens a provider issued for a connection
+type OAuthTokens struct {
+	AccessToken string
+	// Empty when the provider issued none, or kept the previous one on refresh
+	RefreshToken string
+	// How long the access token lasts, zero when it doesn't expire
+	ExpiresIn time.Duration
+	Scopes    []string
+	Account   string
+}
+
+// A connection whose access token is about to expire
+type DueConnection struct {
+	ID           string
+	Provider     string
+	RefreshToken string
+}
+
+// OAuth connections, their tokens sealed like secrets
+type ConnectionStore struct {
+	db       *pgxpool.Pool
+	envelope *secrets.Envelope
+}
+
+// envelope may be nil when no secrets key is configured; saving tokens then fails with secrets.ErrNoKey
+func NewConnectionStore(db *pgxpool.Pool, envelope *secrets.Envelope) *ConnectionStore {
+	return &ConnectionStore{db: db, envelope: envelope}
+}
+
+// Remembers an authorization until its callback. Ones that never came back are dropped
+func (s *ConnectionStore) SaveState(ctx context.Context, state, userID, provider, name string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM oauth_connection_states WHERE created_at < NOW() - $1 * INTERVAL '1 second'`,
+		connectionStateTTL.Seconds()); err != nil {
+		return fmt.Errorf("prune connection states: %w", err)
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO oauth_connection_states (state, user_id, provider, name) VALUES ($1, $2, $3, $4)`,
+		state, userID, provider, name)
+	if err != nil {
+		return fmt.Errorf("save connection state: %w", err)
+	}
+	return nil
+}
+
+// Consumes an authorization's state, so a callback can't be replayed
+func (s *ConnectionStore) TakeState(ctx context.Context, state string) (userID, provider, name string, err error) {
+	err = s.db.QueryRow(ctx, `DELETE FROM oauth_connection_states
+	WHERE state = $1 AND created_at >= NOW() - $2 * INTERVAL '1 second'
+	RETURNING user_id::text, provider, name`, state, connectionStateTTL.Seconds()).Scan(&userID, &provider, &name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", "", ErrConnectionStateInvalid
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("take connection state: %w", err)
+	}
+	return userID, provider, name, nil
+}
+
+const connectionColumns = `id, user_id::text, provider, name, account, scopes, expires_at, COALESCE(last_error, ''), created_at, updated_at`
+
+func scanConnection(row pgx.Row) (*models.Connection, error) {
+	var c models.Connection
+	if err := row.Scan(&c.ID, &c.UserID, &c.Provider, &c.Name, &c.Account, &c.Scopes, &c.ExpiresAt, &c.LastError,
+		&c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// seal returns nil ciphertext and key for an empty value
+func (s *ConnectionStore) seal(ctx context.Context, value string) ([]byte, []byte, error) {
+	if value == "" {
+		return nil, nil, nil
+	}
+	if s.envelope == nil {
+		return nil, nil, secrets.ErrNoKey
+	}
+	sealed, err := s.envelope.Seal(ctx, value)
+	return sealed.Ciphertext, sealed.DataKey, err
+}
+
+func (s *ConnectionStore) CreateConnection(ctx context.Context, userID, provider, name string, tokens OAuthTokens) (*models.Connection, error) {
+	access, accessKey, err := s.seal(ctx, tokens.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	refresh, refreshKey, err := s.seal(ctx, tokens.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	scopes := tokens.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	query := `INSERT INTO oauth_connections
+	(user_id, provider, name, account, scopes, access_token, access_key, refresh_token, refresh_key, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CASE WHEN $10::float8 > 0 THEN NOW() + $10 * INTERVAL '1 second' END)
+	RETURNING ` + connectionColumns
+	c, err := scanConnection(s.db.QueryRow(ctx, query, userID, provider, name, tokens.Account, scopes,
+		access, accessKey, refresh, refreshKey, tokens.ExpiresIn.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("insert connection: %w", err)
+	}
+	return c, nil
+}
+
+func (s *ConnectionStore) ListConnections(ctx context.Context, userID string) ([]models.Connection, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+connectionColumns+` FROM oauth_connections
+	WHERE user_id::text = $1 ORDER BY provider, name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query connections: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]models.Connection, 0)
+	for rows.Next() {
+		c, err := scanConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan connection: %w", err)
+		}
+		list = append(list, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return list, nil
+}
+
+func (s *ConnectionStore) DeleteConnection(ctx context.Context, userID, id string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM oauth_connections WHERE user_id::text = $1 AND id::text = $2`, userID, id)
+	if err != nil {
+		return fmt.Errorf("delete connection: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConnectionNotFound
+	}
+	return nil
+}
+
+func (s *ConnectionStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRow(ctx, `SELECT NOW()::timestamp`).Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("read clock: %w", err)
+	}
+	return now, nil
+}
+
+// Takes the refresh lock without waiting. ok is false when another instance holds it
+func (s *ConnectionStore) TryLock(ctx context.Context) (unlock func(), ok bool, err error) {
+	return tryAdvisoryLock(ctx, s.db, connectionLockKey)
+}
+
+// Connections with a refresh token whose access token expires before
+// before, soonest first. Ones whose refresh was refused are left alone
+func (s *ConnectionStore) DueForRefresh(ctx context.Context, before time.Time, limit int) ([]DueConnection, error) {
+	if s.envelope == nil {
+		return nil, secrets.ErrNoKey
+	}
+	rows, err := s.db.Query(ctx, `SELECT id, provider, refresh_token, refresh_key FROM oauth_connections
+	WHERE refresh_token IS NOT NULL AND expires_at < $1 AND last_error IS NULL
+	ORDER BY expires_at LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query due connections: %w", err)
+	}
+	defer rows.Close()
+
+	var due []DueConnection
+	var sealed []secrets.Sealed
+	for rows.Next() {
+		var c DueConnection
+		var s secrets.Sealed
+		if err := rows.Scan(&c.ID, &c.Provider, &s.Ciphertext, &s.DataKey); err != nil {
+			return nil, fmt.Errorf("scan due connection: %w", err)
+		}
+		due = append(due, c)
+		sealed = append(sealed, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	for i := range due {
+		if due[i].RefreshToken, err = s.envelope.Open(ctx, sealed[i]); err != nil {
+			return nil, fmt.Errorf("open refresh token of %s: %w", due[i].ID, err)
+		}
+	}
+	return due, nil
+}
+
+// Replaces a connection's tokens after a refresh, keeping its refresh token
+// when the provider didn't rotate it
+func (s *ConnectionStore) UpdateTokens(ctx context.Context, id string, tokens OAuthTokens) error {
+	access, accessKey, err := s.seal(ctx, tokens.AccessToken)
+	if err != nil {
+		return err
+	}
+	refresh, refreshKey, err := s.seal(ctx, tokens.RefreshToken)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, `UPDATE oauth_connections SET access_token = $2, access_key = $3,
+		refresh_token = COALESCE($4, refresh_token), refresh_key = CASE WHEN $4::bytea IS NULL THEN refresh_key ELSE $5 END,
+		expires_at = CASE WHEN $6::float8 > 0 THEN NOW() + $6 * INTERVAL '1 second' END, last_error = NULL, updated_at = NOW()
+	WHERE id = $1`, id, access, accessKey, refresh, refreshKey, tokens.ExpiresIn.Seconds())
+	if err != nil {
+		return fmt.Errorf("update connection tokens: %w", err)
+	}
+	return nil
+}
+
+// Marks a connection whose refresh the provider refused. It stays that way
+// until the account is connected again
+func (s *ConnectionStore) RecordFailure(ctx context.Context, id, message string) error {
+	if _, err := s.db.Exec(ctx, `UPDATE oauth_connections SET last_error = $2, updated_at = NOW() WHERE id = $1`,
+		id, message); err != nil {
+		return fmt.Errorf("record connection failure: %w", err)
+	}
+	return nil
+}
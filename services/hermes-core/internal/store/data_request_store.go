@@ -0,0 +1,447 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrDataRequestNotFound = errors.New("data request not found")
+	// The user already has a request of the same kind waiting or running
+	ErrDataRequestOpen = errors.New("a data request of this kind is already open")
+	// The request isn't a completed export, or its export has expired
+	ErrExportUnavailable = errors.New("export is not available")
+)
+
+const (
+	DataRequestExport = "export"
+	DataRequestDelete = "delete"
+
+	DataRequestPending   = "pending"
+	DataRequestRunning   = "running"
+	DataRequestCompleted = "completed"
+	DataRequestFailed    = "failed"
+)
+
+// Advisory lock held by the core instance working through data requests
+const dataRequestLockKey int64 = 0x6865726d6577
+
+// Execution logs read per query while exporting
+const exportLogBatch = 500
+
+// Exports and deletions of everything stored for a user or workspace
+type DataRequestStore struct {
+	db *pgxpool.Pool
+	// Seals exports and opens sealed payloads, may be nil
+	payloads *secrets.Envelope
+}
+
+func NewDataRequestStore(db *pgxpool.Pool, payloads *secrets.Envelope) *DataRequestStore {
+	return &DataRequestStore{db: db, payloads: payloads}
+}
+
+const dataRequestColumns = `id, user_id::text, kind, status, report, COALESCE(error, ''),
+	CASE WHEN export IS NOT NULL THEN export_expires_at END, created_at, started_at, completed_at`
+
+func scanDataRequest(row pgx.Row) (*models.DataRequest, error) {
+	var r models.DataRequest
+	var report []byte
+	if err := row.Scan(&r.ID, &r.UserID, &r.Kind, &r.Status, &report, &r.Error, &r.ExportExpiresAt,
+		&r.CreatedAt, &r.StartedAt, &r.CompletedAt); err != nil {
+		return nil, err
+	}
+	if report != nil {
+		if err := json.Unmarshal(report, &r.Report); err != nil {
+			return nil, fmt.Errorf("unmarshal report: %w", err)
+		}
+	}
+	return &r, nil
+}
+
+func (s *DataRequestStore) CreateDataRequest(ctx context.Context, userID, kind string) (*models.DataRequest, error) {
+	r, err := scanDataRequest(s.db.QueryRow(ctx, `INSERT INTO data_requests (user_id, kind) VALUES ($1, $2)
+	RETURNING `+dataRequestColumns, userID, kind))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrDataRequestOpen
+	}
+	if err != nil {
+		return nil, fmt.Errorf("insert data request: %w", err)
+	}
+	return r, nil
+}
+
+// The user's requests, newest first. An empty userID lists everyone's
+func (s *DataRequestStore) ListDataRequests(ctx context.Context, userID string) ([]models.DataRequest, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+dataRequestColumns+` FROM data_requests
+	WHERE $1 = '' OR user_id::text = $1 ORDER BY created_at DESC LIMIT 100`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query data requests: %w", err)
+	}
+	defer rows.Close()
+	list := make([]models.DataRequest, 0)
+	for rows.Next() {
+		r, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan data request: %w", err)
+		}
+		list = append(list, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return list, nil
+}
+
+// One of the user's requests. An empty userID finds anyone's
+func (s *DataRequestStore) GetDataRequest(ctx context.Context, userID, id string) (*models.DataRequest, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrDataRequestNotFound
+	}
+	r, err := scanDataRequest(s.db.QueryRow(ctx, `SELECT `+dataRequestColumns+` FROM data_requests
+	WHERE id = $1 AND ($2 = '' OR user_id::text = $2)`, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDataRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query data request: %w", err)
+	}
+	return r, nil
+}
+
+// A completed export as gzipped JSON. An empty userID finds anyone's
+func (s *DataRequestStore) DataRequestExport(ctx context.Context, userID, id string) ([]byte, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrDataRequestNotFound
+	}
+	var export, dataKey []byte
+	var live bool
+	err := s.db.QueryRow(ctx, `SELECT export, export_key, COALESCE(export_expires_at > NOW(), false) FROM data_requests
+	WHERE id = $1 AND ($2 = '' OR user_id::text = $2)`, id, userID).Scan(&export, &dataKey, &live)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDataRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query export: %w", err)
+	}
+	if export == nil || !live {
+		return nil, ErrExportUnavailable
+	}
+	if dataKey == nil {
+		return export, nil
+	}
+	if s.payloads == nil {
+		return nil, secrets.ErrNoKey
+	}
+	return s.payloads.OpenWith(ctx, dataKey, export)
+}
+
+// Takes the data request lock without waiting. ok is false when another
+// instance holds it; otherwise unlock must be called
+func (s *DataRequestStore) TryLock(ctx context.Context) (unlock func(), ok bool, err error) {
+	return tryAdvisoryLock(ctx, s.db, dataRequestLockKey)
+}
+
+// Marks the oldest open request running and returns it, nil when there is
+// none. Only the lock holder calls this, so a request still running was
+// interrupted and is picked up again
+func (s *DataRequestStore) NextDataRequest(ctx context.Context) (*models.DataRequest, error) {
+	r, err := scanDataRequest(s.db.QueryRow(ctx, `UPDATE data_requests SET status = 'running', started_at = NOW()
+	WHERE id = (SELECT id FROM data_requests WHERE status IN ('pending', 'running') ORDER BY created_at LIMIT 1)
+	RETURNING `+dataRequestColumns))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("claim data request: %w", err)
+	}
+	return r, nil
+}
+
+// Completes a request with its report and, for an export, the gzipped JSON
+// downloadable for ttl
+func (s *DataRequestStore) CompleteDataRequest(ctx context.Context, id string, report map[string]int64, export []byte, ttl time.Duration) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+	var dataKey []byte
+	if export != nil {
+		if s.payloads != nil {
+			if dataKey, err = s.payloads.NewDataKey(ctx); err != nil {
+				return err
+			}
+			if export, err = s.payloads.SealWith(ctx, dataKey, export); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = s.db.Exec(ctx, `UPDATE data_requests
+	SET status = 'completed', report = $2, export = $3, export_key = $4, export_expires_at = CASE WHEN $3::bytea IS NOT NULL THEN NOW() + $5 * INTERVAL '1 second' END,
+		completed_at = NOW()
+	WHERE id = $1`, id, body, export, dataKey, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("complete data request: %w", err)
+	}
+	return nil
+}
+
+func (s *DataRequestStore) FailDataRequest(ctx context.Context, id, reason string) error {
+	_, err := s.db.Exec(ctx, `UPDATE data_requests SET status = 'failed', error = $2, completed_at = NOW()
+	WHERE id = $1`, id, reason)
+	if err != nil {
+		return fmt.Errorf("fail data request: %w", err)
+	}
+	return nil
+}
+
+// Drops exports past their expiry, returning how many went
+func (s *DataRequestStore) DropExpiredExports(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx, `UPDATE data_requests SET export = NULL, export_key = NULL
+	WHERE export IS NOT NULL AND export_expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("drop expired exports: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// Writes everything stored for the user as one JSON object, returning how
+// many rows of each kind it holds. Secret values and tokens are left out
+func (s *DataRequestStore) ExportUserData(ctx context.Context, userID string, w io.Writer) (map[string]int64, error) {
+	var user struct {
+		ID        string    `json:"id"`
+		Username  string    `json:"username"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	err := s.db.QueryRow(ctx, `SELECT id::text, username, email, created_at FROM users WHERE id = $1`, userID).
+		Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	x := &exportWriter{w: w, report: map[string]int64{}}
+	x.write(`{"user":`)
+	x.item(user)
+
+	x.section("relays", func() error {
+		rows, err := s.db.Query(ctx, `SELECT id::text FROM relays WHERE user_id = $1 ORDER BY created_at`, userID)
+		if err != nil {
+			return fmt.Errorf("query relays: %w", err)
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("scan relays: %w", err)
+		}
+		for _, id := range ids {
+			relay, err := getRelay(ctx, s.db, id)
+			if err != nil {
+				return err
+			}
+			x.item(relay)
+		}
+		return nil
+	})
+	x.section("execution_logs", func() error { return s.exportLogs(ctx, x, userID) })
+	x.rows(ctx, s.db, "processed_events", `SELECT relay_id::text, event_id, received_at FROM processed_events
+	WHERE relay_id IN (SELECT id FROM relays WHERE user_id = $1) ORDER BY received_at`, func(row pgx.Row) (any, error) {
+		var e struct {
+			RelayID    string    `json:"relay_id"`
+			EventID    string    `json:"event_id"`
+			ReceivedAt time.Time `json:"received_at"`
+		}
+		err := row.Scan(&e.RelayID, &e.EventID, &e.ReceivedAt)
+		return e, err
+	}, userID)
+	x.rows(ctx, s.db, "dead_letters", `SELECT `+deadLetterColumns+` FROM dead_letters
+	WHERE relay_id IN (SELECT id FROM relays WHERE user_id = $1) ORDER BY created_at`, func(row pgx.Row) (any, error) {
+		return scanDeadLetter(ctx, row, s.payloads)
+	}, userID)
+	x.rows(ctx, s.db, "webhook_deliveries", `SELECT id, relay_id, event_id, source_ip, status, reason, size_bytes, latency_ms, received_at
+	FROM webhook_deliveries WHERE relay_id IN (SELECT id::text FROM relays WHERE user_id = $1) ORDER BY received_at`,
+		func(row pgx.Row) (any, error) {
+			var d models.WebhookDelivery
+			err := row.Scan(&d.ID, &d.RelayID, &d.EventID, &d.SourceIP, &d.Status, &d.Reason,
+				&d.SizeBytes, &d.LatencyMs, &d.ReceivedAt)
+			return d, err
+		}, userID)
+	x.rows(ctx, s.db, "secrets", `SELECT `+secretColumns+` FROM secrets WHERE user_id = $1 ORDER BY name`,
+		func(row pgx.Row) (any, error) { return scanSecret(row) }, userID)
+	x.rows(ctx, s.db, "connections", `SELECT `+connectionColumns+` FROM oauth_connections WHERE user_id = $1 ORDER BY created_at`,
+		func(row pgx.Row) (any, error) { return scanConnection(row) }, userID)
+	x.rows(ctx, s.db, "api_keys", `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at`,
+		func(row pgx.Row) (any, error) { return scanAPIKey(row) }, userID)
+	x.rows(ctx, s.db, "workspace_members", `SELECT `+memberColumns+` FROM workspace_members m JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = $1 OR m.user_id = $1 ORDER BY m.created_at`,
+		func(row pgx.Row) (any, error) { return scanMember(row) }, userID)
+	x.rows(ctx, s.db, "redaction_rules", `SELECT `+redactionRuleColumns+` FROM redaction_rules WHERE user_id = $1 ORDER BY created_at`,
+		func(row pgx.Row) (any, error) { return scanRedactionRule(row) }, userID)
+	x.rows(ctx, s.db, "usage", `SELECT user_id, day::text, events_ingested, bytes_ingested, executions, action_invocations
+	FROM usage_daily WHERE user_id = $1 ORDER BY day`, func(row pgx.Row) (any, error) {
+		var u models.DailyUsage
+		err := row.Scan(&u.UserID, &u.Day, &u.EventsIngested, &u.BytesIngested, &u.Executions, &u.ActionInvocations)
+		return u, err
+	}, userID)
+	x.write("}\n")
+	if x.err != nil {
+		return nil, x.err
+	}
+	return x.report, nil
+}
+
+// Pages through the user's execution logs oldest first, with their steps
+func (s *DataRequestStore) exportLogs(ctx context.Context, x *exportWriter, userID string) error {
+	after, afterID := time.Time{}, uuid.Nil.String()
+	for {
+		rows, err := s.db.Query(ctx, `SELECT `+logColumns+` FROM execution_logs
+		WHERE relay_id IN (SELECT id FROM relays WHERE user_id = $1) AND (executed_at, id) > ($2, $3::uuid)
+		ORDER BY executed_at, id LIMIT $4`, userID, after, afterID, exportLogBatch)
+		if err != nil {
+			return fmt.Errorf("query logs: %w", err)
+		}
+		logs, err := scanLogs(ctx, rows, s.payloads)
+		if err != nil {
+			return err
+		}
+		if err := attachSteps(ctx, s.db, logs); err != nil {
+			return err
+		}
+		for _, log := range logs {
+			x.item(log)
+		}
+		if len(logs) < exportLogBatch {
+			return nil
+		}
+		last := logs[len(logs)-1]
+		after, afterID = last.ExecutedAt, last.ID
+	}
+}
+
+// Streams the export's sections, keeping the first error and counting items
+type exportWriter struct {
+	w      io.Writer
+	report map[string]int64
+	// Section being written, its items are counted under it
+	current string
+	items   int64
+	err     error
+}
+
+func (x *exportWriter) write(s string) {
+	if x.err == nil {
+		_, x.err = io.WriteString(x.w, s)
+	}
+}
+
+func (x *exportWriter) item(v any) {
+	if x.err != nil {
+		return
+	}
+	if x.current != "" {
+		if x.items > 0 {
+			x.write(",")
+		}
+		x.items++
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		x.err = fmt.Errorf("marshal %s: %w", x.current, err)
+		return
+	}
+	if x.err == nil {
+		_, x.err = x.w.Write(data)
+	}
+}
+
+// Writes "name": [...] with the items fill adds
+func (x *exportWriter) section(name string, fill func() error) {
+	if x.err != nil {
+		return
+	}
+	x.write(`,"` + name + `":[`)
+	x.current, x.items = name, 0
+	if err := fill(); err != nil && x.err == nil {
+		x.err = err
+	}
+	x.write("]")
+	x.report[name] = x.items
+	x.current = ""
+}
+
+// A section of every row query returns
+func (x *exportWriter) rows(ctx context.Context, db querier, name, query string, scan func(pgx.Row) (any, error), args ...any) {
+	x.section(name, func() error {
+		rows, err := db.Query(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("query %s: %w", name, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			v, err := scan(rows)
+			if err != nil {
+				return fmt.Errorf("scan %s: %w", name, err)
+			}
+			x.item(v)
+		}
+		return rows.Err()
+	})
+}
+
+// Deletes the user, with everything stored for them and their workspace, in
+// one transaction. Returns the rows deleted by kind of data. Daily usage
+// counts are kept for billing, they hold nothing but totals
+func (s *DataRequestStore) DeleteUserData(ctx context.Context, userID string) (map[string]int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const ofRelays = `relay_id IN (SELECT id FROM relays WHERE user_id = $1)`
+	// Most of these would go with the user through ON DELETE CASCADE, deleting
+	// them one by one counts them for the report. Tables without a foreign key
+	// to the user go too
+	steps := []struct{ name, query string }{
+		{"webhook_deliveries", `DELETE FROM webhook_deliveries WHERE relay_id IN (SELECT id::text FROM relays WHERE user_id = $1)`},
+		{"processed_events", `DELETE FROM processed_events WHERE ` + ofRelays},
+		{"execution_logs", `DELETE FROM execution_logs WHERE ` + ofRelays},
+		{"dead_letters", `DELETE FROM dead_letters WHERE ` + ofRelays},
+		{"held_events", `DELETE FROM held_events WHERE ` + ofRelays},
+		{"aggregate_events", `DELETE FROM aggregate_events WHERE ` + ofRelays},
+		{"agent_jobs", `DELETE FROM agent_jobs WHERE ` + ofRelays},
+		{"relays", `DELETE FROM relays WHERE user_id = $1`},
+		{"secrets", `DELETE FROM secrets WHERE user_id = $1`},
+		{"connections", `DELETE FROM oauth_connections WHERE user_id = $1`},
+		{"api_keys", `DELETE FROM api_keys WHERE user_id = $1`},
+		{"sessions", `DELETE FROM sessions WHERE user_id = $1`},
+		{"workspace_members", `DELETE FROM workspace_members WHERE workspace_id = $1 OR user_id = $1`},
+		{"tenant_slots", `DELETE FROM tenant_slots WHERE user_id = $1`},
+		{"tenant_rate", `DELETE FROM tenant_rate WHERE user_id = $1`},
+		{"users", `DELETE FROM users WHERE id = $1`},
+	}
+	report := make(map[string]int64, len(steps))
+	for _, step := range steps {
+		result, err := tx.Exec(ctx, step.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("delete %s: %w", step.name, err)
+		}
+		report[step.name] = result.RowsAffected()
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return report, nil
+}
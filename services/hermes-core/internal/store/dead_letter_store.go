@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DeadLetterStore struct {
+	db *pgxpool.Pool
+	// Opens payloads sealed at rest. May be nil
+	payloads *secrets.Envelope
+}
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrAlreadyRequeued    = errors.New("dead letter was already requeued")
+)
+
+const (
+	DeadLetterStatusDead     = "dead"
+	DeadLetterStatusRequeued = "requeued"
+)
+
+// payloads may be nil, sealed payloads are then reported as unavailable
+func NewDeadLetterStore(db *pgxpool.Pool, payloads *secrets.Envelope) *DeadLetterStore {
+	return &DeadLetterStore{db: db, payloads: payloads}
+}
+
+// Columns scanDeadLetter reads, the payload followed by its sealed form and
+// the wrapped workspace key it was sealed with
+const deadLetterColumns = `id, relay_id, event_id, payload, payload_sealed,
+	(SELECT k.data_key FROM workspace_keys k WHERE k.id = payload_key),
+	reason, attempts, status, created_at, requeued_at, COALESCE(traceparent, '')`
+
+// Every query is limited to dead letters of relays the workspace owns, so
+// another workspace's IDs read as not found
+const ownedByWorkspace = `relay_id IN (SELECT id FROM relays WHERE user_id::text = $1)`
+
+// Reads a row of deadLetterColumns, opening a sealed payload with payloads
+func scanDeadLetter(ctx context.Context, row pgx.Row, payloads *secrets.Envelope) (*models.DeadLetter, error) {
+	var dl models.DeadLetter
+	var payload, sealed, dataKey []byte
+	err := row.Scan(
+		&dl.ID,
+		&dl.RelayID,
+		&dl.EventID,
+		&payload,
+		&sealed,
+		&dataKey,
+		&dl.Reason,
+		&dl.Attempts,
+		&dl.Status,
+		&dl.CreatedAt,
+		&dl.RequeuedAt,
+		&dl.Traceparent,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if sealed != nil {
+		opened, ok, err := openPayload(ctx, payloads, sealed, dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("dead letter %s: %w", dl.ID, err)
+		}
+		payload, dl.PayloadUnavailable = opened, !ok
+	}
+	if len(payload) > 0 {
+		dl.Payload = payload
+	}
+	return &dl, nil
+}
+
+// Lists the workspace's dead letters newest first, optionally filtered by relay and status
+func (s *DeadLetterStore) List(ctx context.Context, userID, relayID, status string, limit int) ([]models.DeadLetter, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	query := `SELECT ` + deadLetterColumns + `
+	FROM dead_letters
+	WHERE ` + ownedByWorkspace + `
+	AND ($2 = '' OR relay_id::text = $2)
+	AND ($3 = '' OR status = $3)
+	ORDER BY created_at DESC
+	LIMIT $4`
+
+	rows, err := s.db.Query(ctx, query, userID, relayID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := make([]models.DeadLetter, 0)
+	for rows.Next() {
+		dl, err := scanDeadLetter(ctx, rows, s.payloads)
+		if err != nil {
+			return nil, fmt.Errorf("scan dead letter: %w", err)
+		}
+		letters = append(letters, *dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return letters, nil
+}
+
+func (s *DeadLetterStore) Get(ctx context.Context, userID, id string) (*models.DeadLetter, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters WHERE ` + ownedByWorkspace + ` AND id = $2`
+	dl, err := scanDeadLetter(ctx, s.db.QueryRow(ctx, query, userID, id), s.payloads)
+	if err == pgx.ErrNoRows {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query dead letter: %w", err)
+	}
+	return dl, nil
+}
+
+// Marks a dead letter requeued and returns it, so only one caller gets to
+// publish it. Fails with ErrAlreadyRequeued if another request got there first
+func (s *DeadLetterStore) ClaimForRequeue(ctx context.Context, userID, id string) (*models.DeadLetter, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	query := `UPDATE dead_letters SET status = $2, requeued_at = $3
+	WHERE ` + ownedByWorkspace + ` AND id = $4 AND status = $5
+	RETURNING ` + deadLetterColumns
+	dl, err := scanDeadLetter(ctx, s.db.QueryRow(ctx, query, userID, DeadLetterStatusRequeued, time.Now(), id, DeadLetterStatusDead), s.payloads)
+	if err == pgx.ErrNoRows {
+		if _, getErr := s.Get(ctx, userID, id); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrAlreadyRequeued
+	}
+	if err != nil {
+		return nil, fmt.Errorf("claim dead letter: %w", err)
+	}
+	// A requeue is an explicit request to run the event again, even if it was cancelled before
+	if _, err := s.db.Exec(ctx, `DELETE FROM execution_cancellations WHERE relay_id = $1 AND event_id = $2`,
+		dl.RelayID, dl.EventID); err != nil {
+		return nil, fmt.Errorf("clear cancellation: %w", err)
+	}
+	return dl, nil
+}
+
+// Undoes ClaimForRequeue when the event couldn't be published. Only called
+// with an ID the caller just claimed, so it isn't scoped again
+func (s *DeadLetterStore) ReleaseClaim(ctx context.Context, id string) error {
+	query := `UPDATE dead_letters SET status = $1, requeued_at = NULL WHERE id = $2`
+	if _, err := s.db.Exec(ctx, query, DeadLetterStatusDead, id); err != nil {
+		return fmt.Errorf("release dead letter claim: %w", err)
+	}
+	return nil
+}
+
+func (s *DeadLetterStore) Delete(ctx context.Context, userID, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrDeadLetterNotFound
+	}
+	result, err := s.db.Exec(ctx, `DELETE FROM dead_letters WHERE `+ownedByWorkspace+` AND id = $2`, userID, id)
+	if err != nil {
+		return fmt.Errorf("delete dead letter: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// Removes every dead letter of the workspace, or only those for relayID when set. Returns the number removed
+func (s *DeadLetterStore) Purge(ctx context.Context, userID, relayID string) (int64, error) {
+	query := `DELETE FROM dead_letters WHERE ` + ownedByWorkspace + ` AND ($2 = '' OR relay_id::text = $2)`
+	result, err := s.db.Exec(ctx, query, userID, relayID)
+	if err != nil {
+		return 0, fmt.Errorf("purge dead letters: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
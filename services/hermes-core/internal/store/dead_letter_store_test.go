@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeadLetterStoreRejectsMalformedIDs(t *testing.T) {
+	// No pool: a malformed ID must be answered without a query
+	s := NewDeadLetterStore(nil, nil)
+	ctx := context.Background()
+	if _, err := s.Get(ctx, "u1", "not-a-uuid"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("Get: expected ErrDeadLetterNotFound, got %v", err)
+	}
+	if _, err := s.ClaimForRequeue(ctx, "u1", "not-a-uuid"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("ClaimForRequeue: expected ErrDeadLetterNotFound, got %v", err)
+	}
+	if err := s.Delete(ctx, "u1", "42"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("Delete: expected ErrDeadLetterNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The webhook delivery log hermes-hooks reports to
+type DeliveryStore struct {
+	db *pgxpool.Pool
+}
+
+func NewDeliveryStore(db *pgxpool.Pool) *DeliveryStore {
+	return &DeliveryStore{db: db}
+}
+
+// Writes a batch of deliveries in one round trip
+func (s *DeliveryStore) SaveDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	rows := make([][]any, len(deliveries))
+	for i, d := range deliveries {
+		rows[i] = []any{d.RelayID, d.EventID, d.SourceIP, d.Status, d.Reason, d.SizeBytes, d.LatencyMs, d.ReceivedAt.UTC()}
+	}
+	_, err := s.db.CopyFrom(ctx, pgx.Identifier{"webhook_deliveries"},
+		[]string{"relay_id", "event_id", "source_ip", "status", "reason", "size_bytes", "latency_ms", "received_at"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("insert deliveries: %w", err)
+	}
+	return nil
+}
+
+// The relay's latest deliveries, newest first. failedOnly keeps the ones
+// answered with an error status
+func (s *DeliveryStore) ListDeliveries(ctx context.Context, relayID string, failedOnly bool, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, relay_id, event_id, source_ip, status, reason, size_bytes, latency_ms, received_at
+	FROM webhook_deliveries
+	WHERE relay_id = $1 AND (NOT $2 OR status >= 400)
+	ORDER BY received_at DESC
+	LIMIT $3`, relayID, failedOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query deliveries: %w", err)
+	}
+	defer rows.Close()
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.RelayID, &d.EventID, &d.SourceIP, &d.Status, &d.Reason,
+			&d.SizeBytes, &d.LatencyMs, &d.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Deletes deliveries older than age, returning how many went
+func (s *DeliveryStore) PruneDeliveries(ctx context.Context, age time.Duration) (int64, error) {
+	result, err := s.db.Exec(ctx, `DELETE FROM webhook_deliveries
+	WHERE received_at < NOW() - make_interval(secs => $1)`, age.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("prune deliveries: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ExportStore struct {
+	db *pgxpool.Pool
+	// Opens payloads sealed at rest. May be nil
+	payloads *secrets.Envelope
+}
+
+var ErrLogExportNotFound = errors.New("log export not found")
+
+const (
+	ExportSinkS3       = "s3"
+	ExportSinkBigQuery = "bigquery"
+	ExportSinkWebhook  = "webhook"
+)
+
+// Advisory lock held while logs are shipped, so one core instance exports at a time
+const exportLockKey int64 = 0x6865726d6574
+
+// Where an export's next page starts: logs after this time and ID
+type ExportCursor struct {
+	At time.Time
+	ID string
+}
+
+func NewExportStore(db *pgxpool.Pool, payloads *secrets.Envelope) *ExportStore {
+	return &ExportStore{db: db, payloads: payloads}
+}
+
+const logExportColumns = `id, user_id, name, sink, config, is_active, cursor_at, cursor_id, last_error, last_exported_at, created_at`
+
+func scanLogExport(row pgx.Row) (*models.LogExport, error) {
+	var e models.LogExport
+	var config []byte
+	var lastError *string
+	err := row.Scan(&e.ID, &e.UserID, &e.Name, &e.Sink, &config, &e.IsActive, &e.ExportedThrough, &e.CursorID,
+		&lastError, &e.LastExportedAt, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(config, &e.Config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if lastError != nil {
+		e.LastError = *lastError
+	}
+	return &e, nil
+}
+
+// Creates an export that ships logs executed from now on
+func (s *ExportStore) CreateLogExport(ctx context.Context, req models.CreateLogExportRequest) (*models.LogExport, error) {
+	config, err := json.Marshal(req.Config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	query := `INSERT INTO log_exports (user_id, name, sink, config) VALUES ($1, $2, $3, $4)
+	RETURNING ` + logExportColumns
+	export, err := scanLogExport(s.db.QueryRow(ctx, query, req.UserID, req.Name, req.Sink, config))
+	if err != nil {
+		return nil, fmt.Errorf("insert log export: %w", err)
+	}
+	return export, nil
+}
+
+func (s *ExportStore) ListLogExports(ctx context.Context, userID string) ([]models.LogExport, error) {
+	return s.list(ctx, `SELECT `+logExportColumns+` FROM log_exports WHERE user_id::text = $1 ORDER BY created_at`, userID)
+}
+
+func (s *ExportStore) DeleteLogExport(ctx context.Context, userID, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrLogExportNotFound
+	}
+	tag, err := s.db.Exec(ctx, `DELETE FROM log_exports WHERE id = $1 AND user_id::text = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete log export: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLogExportNotFound
+	}
+	return nil
+}
+
+// Every export that is switched on, for the shipper
+func (s *ExportStore) ActiveExports(ctx context.Context) ([]models.LogExport, error) {
+	return s.list(ctx, `SELECT `+logExportColumns+` FROM log_exports WHERE is_active ORDER BY created_at`)
+}
+
+func (s *ExportStore) list(ctx context.Context, query string, args ...any) ([]models.LogExport, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query log exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := make([]models.LogExport, 0)
+	for rows.Next() {
+		export, err := scanLogExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan log export: %w", err)
+		}
+		exports = append(exports, *export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return exports, nil
+}
+
+// The next page of the user's logs after the cursor and before until, oldest
+// first, with their steps
+func (s *ExportStore) ExportLogs(ctx context.Context, userID string, after ExportCursor, until time.Time, limit int) ([]models.ExecutionLog, error) {
+	query := `
+		SELECT l.id, l.relay_id, COALESCE(l.event_id, ''), COALESCE(l.trace_id, ''), l.status, l.payload, l.payload_sealed,
+			(SELECT k.data_key FROM workspace_keys k WHERE k.id = l.payload_key), l.error_message, l.executed_at
+		FROM execution_logs l
+		JOIN relays r ON r.id = l.relay_id
+		WHERE r.user_id::text = $1
+		AND (l.executed_at, l.id) > ($2, $3::uuid)
+		AND l.executed_at < $4
+		ORDER BY l.executed_at, l.id
+		LIMIT $5
+	`
+	rows, err := s.db.Query(ctx, query, userID, after.At, after.ID, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query export logs: %w", err)
+	}
+	logs, err := scanLogs(ctx, rows, s.payloads)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachSteps(ctx, s.db, logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Moves the export's cursor past a page that was shipped, clearing any error
+func (s *ExportStore) Advance(ctx context.Context, id string, cursor ExportCursor) error {
+	_, err := s.db.Exec(ctx, `UPDATE log_exports
+	SET cursor_at = $2, cursor_id = $3, last_error = NULL, last_exported_at = NOW()
+	WHERE id = $1`, id, cursor.At, cursor.ID)
+	if err != nil {
+		return fmt.Errorf("advance log export: %w", err)
+	}
+	return nil
+}
+
+// Records why the export couldn't ship. It is retried from the same cursor
+func (s *ExportStore) RecordFailure(ctx context.Context, id, message string) error {
+	_, err := s.db.Exec(ctx, `UPDATE log_exports SET last_error = $2 WHERE id = $1`, id, message)
+	if err != nil {
+		return fmt.Errorf("record log export failure: %w", err)
+	}
+	return nil
+}
+
+// The database clock, as executed_at is stamped with it
+func (s *ExportStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRow(ctx, `SELECT NOW()::timestamp`).Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("read clock: %w", err)
+	}
+	return now, nil
+}
+
+// Takes the export lock without waiting. ok is false when another instance
+// holds it; otherwise unlock must be called
+func (s *ExportStore) TryLock(ctx context.Context) (unlock func(), ok bool, err error) {
+	return tryAdvisoryLock(ctx, s.db, exportLockKey)
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/flags"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FlagStore struct {
+	db *pgxpool.Pool
+}
+
+var (
+	ErrFeatureFlagNotFound = errors.New("feature flag not found")
+	ErrUserNotFound        = errors.New("user not found")
+)
+
+func NewFlagStore(db *pgxpool.Pool) *FlagStore {
+	return &FlagStore{db: db}
+}
+
+// Every stored flag with its overrides, ordered by name
+func (s *FlagStore) ListFeatureFlags(ctx context.Context) ([]flags.Flag, error) {
+	rows, err := s.db.Query(ctx, `SELECT f.name, f.description, f.enabled, f.rollout_percent, o.user_id::text, o.enabled
+	FROM feature_flags f
+	LEFT JOIN feature_flag_overrides o ON o.flag_name = f.name
+	ORDER BY f.name`)
+	if err != nil {
+		return nil, fmt.Errorf("query feature flags: %w", err)
+	}
+	defer rows.Close()
+	list := make([]flags.Flag, 0)
+	for rows.Next() {
+		var f flags.Flag
+		var userID *string
+		var override *bool
+		if err := rows.Scan(&f.Name, &f.Description, &f.Enabled, &f.RolloutPercent, &userID, &override); err != nil {
+			return nil, fmt.Errorf("scan feature flag: %w", err)
+		}
+		// One row per override, the flag's own columns repeat
+		if n := len(list); n == 0 || list[n-1].Name != f.Name {
+			list = append(list, f)
+		}
+		if userID != nil {
+			last := &list[len(list)-1]
+			if last.Overrides == nil {
+				last.Overrides = map[string]bool{}
+			}
+			last.Overrides[*userID] = *override
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return list, nil
+}
+
+// The stored flags as a set, for flags.Evaluator
+func (s *FlagStore) FeatureFlags(ctx context.Context) (flags.Set, error) {
+	list, err := s.ListFeatureFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := make(flags.Set, len(list))
+	for _, f := range list {
+		set[f.Name] = f
+	}
+	return set, nil
+}
+
+// Creates the flag or updates its rollout, keeping its overrides
+func (s *FlagStore) PutFeatureFlag(ctx context.Context, flag flags.Flag) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO feature_flags (name, description, enabled, rollout_percent)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, enabled = EXCLUDED.enabled,
+		rollout_percent = EXCLUDED.rollout_percent, updated_at = NOW()`,
+		flag.Name, flag.Description, flag.Enabled, flag.RolloutPercent)
+	if err != nil {
+		return fmt.Errorf("upsert feature flag: %w", err)
+	}
+	return nil
+}
+
+// Deletes the flag and its overrides. A default from config takes over again
+func (s *FlagStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM feature_flags WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("delete feature flag: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFeatureFlagNotFound
+	}
+	return nil
+}
+
+// Pins the flag on or off for one user, whatever the rollout says
+func (s *FlagStore) SetFlagOverride(ctx context.Context, name, userID string, enabled bool) error {
+	tag, err := s.db.Exec(ctx, `INSERT INTO feature_flag_overrides (flag_name, user_id, enabled)
+	SELECT f.name, u.id, $3 FROM feature_flags f, users u WHERE f.name = $1 AND u.id = $2
+	ON CONFLICT (flag_name, user_id) DO UPDATE SET enabled = EXCLUDED.enabled`, name, userID, enabled)
+	if err != nil {
+		return fmt.Errorf("upsert feature flag override: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM feature_flags WHERE name = $1)`, name).Scan(&exists); err != nil {
+		return fmt.Errorf("check feature flag: %w", err)
+	}
+	if !exists {
+		return ErrFeatureFlagNotFound
+	}
+	return ErrUserNotFound
+}
+
+func (s *FlagStore) DeleteFlagOverride(ctx context.Context, name, userID string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM feature_flag_overrides WHERE flag_name = $1 AND user_id = $2`, name, userID)
+	if err != nil {
+		return fmt.Errorf("delete feature flag override: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFeatureFlagNotFound
+	}
+	return nil
+}
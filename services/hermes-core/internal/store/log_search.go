@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+// Bounds on a search query, so one request can't build an unbounded jsonpath
+const (
+	maxSearchTerms  = 10
+	maxSearchLength = 1000
+)
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// A parsed log search. Path is a jsonpath predicate over the payload, served
+// by the payload's GIN index, and Text is matched against error messages with
+// full-text search. Either may be empty
+type LogSearch struct {
+	Path string
+	Text string
+}
+
+// Parses a search query of whitespace separated terms, all of which must match.
+// A field=value term matches payloads where that dotted path holds the value,
+// looking into arrays along the way, so items.sku=A1 finds A1 in any item. A
+// value that reads as a number, true, false or null also matches the same text
+// as a string, while a quoted value ("Ada Lovelace") is always a string. Any
+// other word is looked for in the error message
+func ParseLogSearch(q string) (LogSearch, error) {
+	if len(q) > maxSearchLength {
+		return LogSearch{}, fmt.Errorf("search can't be longer than %d characters", maxSearchLength)
+	}
+	terms, err := splitSearch(q)
+	if err != nil {
+		return LogSearch{}, err
+	}
+	if len(terms) == 0 {
+		return LogSearch{}, errors.New("search is empty")
+	}
+	if len(terms) > maxSearchTerms {
+		return LogSearch{}, fmt.Errorf("search can't have more than %d terms", maxSearchTerms)
+	}
+
+	var predicates, words []string
+	for _, term := range terms {
+		field, value, ok := strings.Cut(term, "=")
+		if !ok {
+			words = append(words, unquote(term))
+			continue
+		}
+		if field == "" {
+			return LogSearch{}, fmt.Errorf("%q has no field", term)
+		}
+		segments := strings.Split(field, ".")
+		var path strings.Builder
+		path.WriteString("$")
+		for _, segment := range segments {
+			if segment == "" {
+				return LogSearch{}, fmt.Errorf("%q isn't a valid field", field)
+			}
+			path.WriteString("." + jsonpathString(segment))
+		}
+		predicates = append(predicates, valuePredicate(path.String(), value))
+	}
+	return LogSearch{
+		Path: strings.Join(predicates, " && "),
+		Text: strings.Join(words, " "),
+	}, nil
+}
+
+// The jsonpath comparing path to a value as typed in a query
+func valuePredicate(path, value string) string {
+	if strings.HasPrefix(value, `"`) {
+		return path + " == " + jsonpathString(unquote(value))
+	}
+	asString := path + " == " + jsonpathString(value)
+	switch {
+	case jsonNumber.MatchString(value), value == "true", value == "false", value == "null":
+		return "(" + path + " == " + value + " || " + asString + ")"
+	}
+	return asString
+}
+
+// Splits on whitespace outside double quotes
+func splitSearch(q string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	quoted, escaped := false, false
+	for _, r := range q {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && unicode.IsSpace(r):
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(r)
+	}
+	if quoted {
+		return nil, errors.New("search has an unterminated quote")
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+// Strips the quotes from a quoted value, leaving anything else as it is
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// A jsonpath string literal, which takes the same escapes as JSON
+func jsonpathString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Lists the relay's runs that match a search newest first, only those at or
+// after since when it is set, which also limits the partitions scanned.
+// Payloads sealed at rest can't be looked into, so field terms never match them
+func (s *RelayStore) SearchLogs(ctx context.Context, relayID string, search LogSearch, since time.Time, limit int) ([]models.ExecutionLog, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	// The conditions are only added when used so the planner sees the indexed
+	// expressions as they are
+	args := []any{relayID}
+	query := `
+		SELECT ` + logColumns + `
+		FROM execution_logs
+		WHERE relay_id = $1`
+	if search.Path != "" {
+		args = append(args, search.Path)
+		query += fmt.Sprintf("\n\t\tAND payload @@ $%d::jsonpath", len(args))
+	}
+	if search.Text != "" {
+		args = append(args, search.Text)
+		query += fmt.Sprintf("\n\t\tAND to_tsvector('simple', COALESCE(error_message, '')) @@ plainto_tsquery('simple', $%d)", len(args))
+	}
+	if !since.IsZero() {
+		args = append(args, since.UTC())
+		query += fmt.Sprintf("\n\t\tAND executed_at >= $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf("\n\t\tORDER BY executed_at DESC\n\t\tLIMIT $%d", len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search logs: %w", err)
+	}
+	logs, err := scanLogs(ctx, rows, s.payloads)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachSteps(ctx, s.db, logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
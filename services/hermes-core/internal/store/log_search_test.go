@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogSearch(t *testing.T) {
+	cases := []struct {
+		q    string
+		path string
+		text string
+	}{
+		{`order_id=1234`, `($."order_id" == 1234 || $."order_id" == "1234")`, ""},
+		{`order.customer.name="Ada Lovelace"`, `$."order"."customer"."name" == "Ada Lovelace"`, ""},
+		{`status=shipped timeout`, `$."status" == "shipped"`, "timeout"},
+		{`paid=true  connection "refused"`, `($."paid" == true || $."paid" == "true")`, "connection refused"},
+		{`note="a\"b"`, `$."note" == "a\"b"`, ""},
+	}
+	for _, c := range cases {
+		search, err := ParseLogSearch(c.q)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.q, err)
+			continue
+		}
+		if search.Path != c.path || search.Text != c.text {
+			t.Errorf("%s: expected %q and %q, got %q and %q", c.q, c.path, c.text, search.Path, search.Text)
+		}
+	}
+
+	search, _ := ParseLogSearch(`a=1 b=x`)
+	if !strings.Contains(search.Path, ` && $."b" == "x"`) {
+		t.Errorf("Expected terms to be joined with &&, got %q", search.Path)
+	}
+
+	for _, q := range []string{"", "   ", "=1", "a..b=1", `name="open`, strings.Repeat("a=1 ", maxSearchTerms+1)} {
+		if _, err := ParseLogSearch(q); err == nil {
+			t.Errorf("Expected %q to be rejected", q)
+		}
+	}
+}
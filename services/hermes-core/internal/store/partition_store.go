@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Manages the daily partitions of the execution log tables
+type PartitionStore struct {
+	db *pgxpool.Pool
+}
+
+// Tables partitioned by day on executed_at, whose partitions line up. Steps
+// come first so they are dropped before the logs they belong to
+var PartitionedTables = []string{"execution_steps", "execution_logs"}
+
+// Advisory lock held while partitions are created or dropped, so only one
+// core instance changes them at a time
+const partitionLockKey int64 = 0x6865726d6573
+
+// How partition bounds are written and read back
+const partitionBoundLayout = "2006-01-02 15:04:05"
+
+// A range partition. From is zero for the legacy partition, which starts at MINVALUE
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+func NewPartitionStore(db *pgxpool.Pool) *PartitionStore {
+	return &PartitionStore{db: db}
+}
+
+// The database clock, as executed_at is stamped with it
+func (s *PartitionStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRow(ctx, `SELECT NOW()::timestamp`).Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("read clock: %w", err)
+	}
+	return now, nil
+}
+
+// Takes the partition lock without waiting. ok is false when another
+// instance holds it; otherwise unlock must be called
+func (s *PartitionStore) TryLock(ctx context.Context) (unlock func(), ok bool, err error) {
+	return tryAdvisoryLock(ctx, s.db, partitionLockKey)
+}
+
+// Takes a session advisory lock on a connection of its own, which is held
+// until unlock releases both
+func tryAdvisoryLock(ctx context.Context, db *pgxpool.Pool, key int64) (unlock func(), ok bool, err error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("acquire connection: %w", err)
+	}
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil || !ok {
+		conn.Release()
+		if err != nil {
+			return nil, false, fmt.Errorf("take advisory lock: %w", err)
+		}
+		return nil, false, nil
+	}
+	return func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		conn.Release()
+	}, true, nil
+}
+
+// The table's range partitions, leaving out the default one
+func (s *PartitionStore) ListPartitions(ctx context.Context, table string) ([]Partition, error) {
+	rows, err := s.db.Query(ctx, `SELECT c.relname, pg_get_expr(c.relpartbound, c.oid)
+	FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = $1::regclass ORDER BY c.relname`, table)
+	if err != nil {
+		return nil, fmt.Errorf("query partitions: %w", err)
+	}
+	defer rows.Close()
+	var partitions []Partition
+	for rows.Next() {
+		var name, bound string
+		if err := rows.Scan(&name, &bound); err != nil {
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		if p, ok := parsePartitionBound(name, bound); ok {
+			partitions = append(partitions, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return partitions, nil
+}
+
+var partitionBoundPattern = regexp.MustCompile(`^FOR VALUES FROM \((MINVALUE|'([^']+)')\) TO \('([^']+)'\)$`)
+
+func parsePartitionBound(name, bound string) (Partition, bool) {
+	m := partitionBoundPattern.FindStringSubmatch(bound)
+	if m == nil {
+		return Partition{}, false
+	}
+	p := Partition{Name: name}
+	var err error
+	if m[1] != "MINVALUE" {
+		if p.From, err = time.Parse(partitionBoundLayout, m[2]); err != nil {
+			return Partition{}, false
+		}
+	}
+	if p.To, err = time.Parse(partitionBoundLayout, m[3]); err != nil {
+		return Partition{}, false
+	}
+	return p, true
+}
+
+func partitionName(table string, day time.Time) string {
+	return table + "_p" + day.Format("20060102")
+}
+
+// Creates the partition for one day, moving in any of its rows that landed in
+// the default partition first. False when it already exists
+func (s *PartitionStore) CreatePartition(ctx context.Context, table string, day time.Time) (bool, error) {
+	name := partitionName(table, day)
+	from, to := day.Format(partitionBoundLayout), day.AddDate(0, 0, 1).Format(partitionBoundLayout)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists, hasDefault bool
+	if err := tx.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL`,
+		name, table+"_default").Scan(&exists, &hasDefault); err != nil {
+		return false, fmt.Errorf("check partition: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+	partition, parent := pgx.Identifier{name}.Sanitize(), pgx.Identifier{table}.Sanitize()
+	if _, err := tx.Exec(ctx, `CREATE TABLE `+partition+` (LIKE `+parent+` INCLUDING DEFAULTS)`); err != nil {
+		return false, fmt.Errorf("create partition: %w", err)
+	}
+	if hasDefault {
+		defaultPartition := pgx.Identifier{table + "_default"}.Sanitize()
+		if _, err := tx.Exec(ctx, `WITH moved AS (
+			DELETE FROM `+defaultPartition+` WHERE executed_at >= $1::timestamp AND executed_at < $2::timestamp
+			RETURNING *
+		) INSERT INTO `+partition+` SELECT * FROM moved`, from, to); err != nil {
+			return false, fmt.Errorf("move rows from default partition: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+		parent, partition, from, to)); err != nil {
+		return false, fmt.Errorf("attach partition: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+func (s *PartitionStore) DropPartition(ctx context.Context, name string) error {
+	if _, err := s.db.Exec(ctx, `DROP TABLE IF EXISTS `+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("drop partition: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePartitionBound(t *testing.T) {
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	p, ok := parsePartitionBound("execution_logs_p20261016",
+		"FOR VALUES FROM ('2026-10-16 00:00:00') TO ('2026-10-17 00:00:00')")
+	if !ok || !p.From.Equal(day) || !p.To.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("Expected one day's bounds, got %+v", p)
+	}
+	p, ok = parsePartitionBound("execution_logs_legacy", "FOR VALUES FROM (MINVALUE) TO ('2026-10-16 00:00:00')")
+	if !ok || !p.From.IsZero() || !p.To.Equal(day) {
+		t.Errorf("Expected the legacy partition to start at MINVALUE, got %+v", p)
+	}
+	if _, ok := parsePartitionBound("execution_logs_default", "DEFAULT"); ok {
+		t.Error("Expected the default partition to be left out")
+	}
+	if name := partitionName("execution_steps", day); name != "execution_steps_p20261016" {
+		t.Errorf("Unexpected partition name %s", name)
+	}
+}
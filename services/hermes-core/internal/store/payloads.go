@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+)
+
+// A sealed payload that can't be opened, see openPayload
+var ErrPayloadUnavailable = errors.New("payload is sealed and can't be opened without SECRETS_KEY or its deleted workspace key")
+
+// Opens a payload the worker sealed, read back as payload_sealed and the
+// wrapped key payload_key names. ok is false when it can't be opened, no
+// secrets key being set or the workspace key having been deleted
+func openPayload(ctx context.Context, payloads *secrets.Envelope, sealed, dataKey []byte) ([]byte, bool, error) {
+	if payloads == nil || dataKey == nil {
+		return nil, false, nil
+	}
+	payload, err := payloads.OpenWith(ctx, dataKey, sealed)
+	if err != nil {
+		return nil, false, fmt.Errorf("open payload: %w", err)
+	}
+	return payload, true, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+)
+
+// Hands fixed column values to Scan in order
+type valuesRow []any
+
+func (r valuesRow) Scan(dest ...any) error {
+	for i, d := range dest {
+		if r[i] != nil {
+			reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r[i]))
+		}
+	}
+	return nil
+}
+
+func testEnvelope(t *testing.T) *secrets.Envelope {
+	t.Helper()
+	kek, err := secrets.NewCipher(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secrets.NewEnvelope(kek, nil)
+}
+
+func deadLetterRow(payload, sealed, dataKey []byte) valuesRow {
+	return valuesRow{"dl-1", "relay-1", "evt-1", payload, sealed, dataKey,
+		"boom", 3, DeadLetterStatusDead, time.Now(), nil, ""}
+}
+
+func TestScanDeadLetterOpensSealedPayload(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t)
+	dataKey, err := envelope.NewDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.SealWith(ctx, dataKey, []byte(`{"card":"4242"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err := scanDeadLetter(ctx, deadLetterRow(nil, sealed, dataKey), envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dl.Payload) != `{"card":"4242"}` || dl.PayloadUnavailable {
+		t.Errorf("expected the opened payload, got %s (unavailable %v)", dl.Payload, dl.PayloadUnavailable)
+	}
+
+	// Without the key the row still lists, just without its payload
+	dl, err = scanDeadLetter(ctx, deadLetterRow(nil, sealed, nil), envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Payload != nil || !dl.PayloadUnavailable {
+		t.Errorf("expected an unavailable payload, got %s (unavailable %v)", dl.Payload, dl.PayloadUnavailable)
+	}
+	dl, err = scanDeadLetter(ctx, deadLetterRow(nil, sealed, dataKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dl.PayloadUnavailable {
+		t.Error("expected an unavailable payload without an envelope")
+	}
+
+	// Plain rows written before sealing was turned on read as before
+	dl, err = scanDeadLetter(ctx, deadLetterRow([]byte(`{"a":1}`), nil, nil), envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dl.Payload) != `{"a":1}` || dl.PayloadUnavailable {
+		t.Errorf("expected the plain payload, got %s", dl.Payload)
+	}
+}
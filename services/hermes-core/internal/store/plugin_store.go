@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PluginStore struct {
+	db *pgxpool.Pool
+}
+
+var ErrPluginNotFound = errors.New("plugin not found")
+
+func NewPluginStore(db *pgxpool.Pool) *PluginStore {
+	return &PluginStore{db: db}
+}
+
+const pluginColumns = `id, name, sha256, size_bytes, memory_limit_mb, timeout_ms, created_at, updated_at`
+
+func scanPlugin(row pgx.Row) (*models.Plugin, error) {
+	var p models.Plugin
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.SHA256,
+		&p.SizeBytes,
+		&p.MemoryLimitMB,
+		&p.TimeoutMs,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	p.ActionType = models.PluginActionPrefix + p.Name
+	return &p, nil
+}
+
+// Creates the plugin or replaces its module and limits. Workers pick up the
+// new module on their next sync
+func (s *PluginStore) Upsert(ctx context.Context, name string, module []byte, memoryLimitMB, timeoutMs int) (*models.Plugin, error) {
+	sum := sha256.Sum256(module)
+	query := `INSERT INTO plugins (name, module, sha256, size_bytes, memory_limit_mb, timeout_ms)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (name) DO UPDATE SET
+		module = EXCLUDED.module,
+		sha256 = EXCLUDED.sha256,
+		size_bytes = EXCLUDED.size_bytes,
+		memory_limit_mb = EXCLUDED.memory_limit_mb,
+		timeout_ms = EXCLUDED.timeout_ms,
+		updated_at = NOW()
+	RETURNING ` + pluginColumns
+
+	p, err := scanPlugin(s.db.QueryRow(ctx, query, name, module, hex.EncodeToString(sum[:]),
+		len(module), memoryLimitMB, timeoutMs))
+	if err != nil {
+		return nil, fmt.Errorf("upsert plugin: %w", err)
+	}
+	return p, nil
+}
+
+func (s *PluginStore) List(ctx context.Context) ([]models.Plugin, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+pluginColumns+` FROM plugins ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query plugins: %w", err)
+	}
+	defer rows.Close()
+
+	plugins := make([]models.Plugin, 0)
+	for rows.Next() {
+		p, err := scanPlugin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan plugin: %w", err)
+		}
+		plugins = append(plugins, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return plugins, nil
+}
+
+func (s *PluginStore) Get(ctx context.Context, name string) (*models.Plugin, error) {
+	p, err := scanPlugin(s.db.QueryRow(ctx, `SELECT `+pluginColumns+` FROM plugins WHERE name = $1`, name))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPluginNotFound
+		}
+		return nil, fmt.Errorf("get plugin: %w", err)
+	}
+	return p, nil
+}
+
+func (s *PluginStore) Delete(ctx context.Context, name string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM plugins WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("delete plugin: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPluginNotFound
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrRedactionRuleNotFound = errors.New("redaction rule not found")
+
+// Rules masking personal data in payloads. The worker reads them
+type RedactionStore struct {
+	db *pgxpool.Pool
+}
+
+func NewRedactionStore(db *pgxpool.Pool) *RedactionStore {
+	return &RedactionStore{db: db}
+}
+
+const redactionRuleColumns = `id, COALESCE(user_id::text, ''), COALESCE(relay_id::text, ''), path, pattern, preset, replacement, created_at`
+
+func scanRedactionRule(row pgx.Row) (*models.RedactionRule, error) {
+	var rule models.RedactionRule
+	err := row.Scan(&rule.ID, &rule.UserID, &rule.RelayID, &rule.Path, &rule.Pattern, &rule.Preset,
+		&rule.Replacement, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Stores a rule. An empty UserID makes it global, an empty RelayID covers
+// every relay of the workspace
+func (s *RedactionStore) CreateRedactionRule(ctx context.Context, req models.CreateRedactionRuleRequest) (*models.RedactionRule, error) {
+	rule, err := scanRedactionRule(s.db.QueryRow(ctx, `INSERT INTO redaction_rules
+	(user_id, relay_id, path, pattern, preset, replacement)
+	VALUES (NULLIF($1, '')::uuid, NULLIF($2, '')::uuid, $3, $4, $5, $6)
+	RETURNING `+redactionRuleColumns,
+		req.UserID, req.RelayID, req.Path, req.Pattern, req.Preset, req.Replacement))
+	if err != nil {
+		return nil, fmt.Errorf("insert redaction rule: %w", err)
+	}
+	return rule, nil
+}
+
+// The workspace's rules, or the global ones when userID is empty
+func (s *RedactionStore) ListRedactionRules(ctx context.Context, userID string) ([]models.RedactionRule, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+redactionRuleColumns+` FROM redaction_rules
+	WHERE user_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query redaction rules: %w", err)
+	}
+	defer rows.Close()
+	rules := make([]models.RedactionRule, 0)
+	for rows.Next() {
+		rule, err := scanRedactionRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan redaction rule: %w", err)
+		}
+		rules = append(rules, *rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return rules, nil
+}
+
+// Deletes one of the workspace's rules, or a global one when userID is empty
+func (s *RedactionStore) DeleteRedactionRule(ctx context.Context, userID, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrRedactionRuleNotFound
+	}
+	result, err := s.db.Exec(ctx, `DELETE FROM redaction_rules
+	WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete redaction rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRedactionRuleNotFound
+	}
+	return nil
+}
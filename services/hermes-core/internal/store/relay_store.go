@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/dbpool"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/signing"
 	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -15,12 +18,57 @@ import (
 
 type RelayStore struct {
 	db *pgxpool.Pool
+	// GetAllRelays, GetRelay and GetLogs read through this, from a replica when one is set
+	reads *dbpool.ReadRouter
+	// Opens payloads sealed at rest. May be nil
+	payloads *secrets.Envelope
 }
 
 var ErrRelayNotFound = errors.New("relay not found")
 
-func NewRelayStore(db *pgxpool.Pool) *RelayStore {
-	return &RelayStore{db: db}
+// reads may be nil, which keeps every read on db. payloads may be nil too,
+// sealed payloads are then reported as unavailable
+func NewRelayStore(db *pgxpool.Pool, reads *dbpool.ReadRouter, payloads *secrets.Envelope) *RelayStore {
+	if reads == nil {
+		reads = dbpool.NewReadRouter(db, nil, nil)
+	}
+	return &RelayStore{db: db, reads: reads, payloads: payloads}
+}
+
+// Either the primary pool or a ReadRouter
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+const relayColumns = `id, user_id, name, description, webhook_path, is_active, priority, max_concurrency,
+	debounce_seconds, throttle_seconds, throttle_mode, timeout_seconds, created_at, updated_at,
+	dedupe_window_seconds, signing_key IS NOT NULL`
+
+func scanRelay(row pgx.Row) (*models.Relay, error) {
+	var relay models.Relay
+	err := row.Scan(
+		&relay.ID,
+		&relay.UserID,
+		&relay.Name,
+		&relay.Description,
+		&relay.WebhookPath,
+		&relay.IsActive,
+		&relay.Priority,
+		&relay.MaxConcurrency,
+		&relay.DebounceSeconds,
+		&relay.ThrottleSeconds,
+		&relay.ThrottleMode,
+		&relay.TimeoutSeconds,
+		&relay.CreatedAt,
+		&relay.UpdatedAt,
+		&relay.DedupeWindowSeconds,
+		&relay.Signed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &relay, nil
 }
 
 func (s *RelayStore) CreateRelay(ctx context.Context, req models.CreateRelayRequest) (*models.RelayWithActions, error) {
@@ -32,13 +80,12 @@ func (s *RelayStore) CreateRelay(ctx context.Context, req models.CreateRelayRequ
 	relayID := uuid.New().String()
 	webhookPath := fmt.Sprintf("/hooks/%s", relayID)
 	now := time.Now()
-	queryRelay := `INSERT INTO relays (id, user_id, name,description,webhook_path,is_active, created_at, updated_at)
-	VALUES($1,$2,$3,$4,$5,$6,$7,$8)
-	RETURNING id, user_id, name, description, webhook_path, is_active, created_at, updated_at`
+	queryRelay := `INSERT INTO relays (id, user_id, name,description,webhook_path,is_active,priority,max_concurrency,
+	debounce_seconds,throttle_seconds,throttle_mode,timeout_seconds, created_at, updated_at, dedupe_window_seconds)
+	VALUES($1,$2,$3,$4,$5,$6,COALESCE(NULLIF($7,''),'normal'),$8,$9,$10,COALESCE(NULLIF($11,''),'drop'),$12,$13,$14,$15)
+	RETURNING ` + relayColumns
 
-	var relay models.Relay
-
-	err = tx.QueryRow(ctx,
+	relay, err := scanRelay(tx.QueryRow(ctx,
 		queryRelay,
 		relayID,
 		req.UserID,
@@ -46,24 +93,24 @@ func (s *RelayStore) CreateRelay(ctx context.Context, req models.CreateRelayRequ
 		req.Description,
 		webhookPath,
 		true,
+		req.Priority,
+		req.MaxConcurrency,
+		req.DebounceSeconds,
+		req.ThrottleSeconds,
+		req.ThrottleMode,
+		req.TimeoutSeconds,
 		now,
-		now).Scan(&relay.ID,
-		&relay.UserID,
-		&relay.Name,
-		&relay.Description,
-		&relay.WebhookPath,
-		&relay.IsActive,
-		&relay.CreatedAt,
-		&relay.UpdatedAt)
+		now,
+		req.DedupeWindowSeconds))
 	if err != nil {
 		return nil, fmt.Errorf("insert relay: %w", err)
 	}
 
 	actions := make([]models.RelayAction, 0, len(req.Actions))
 
-	queryAction := `INSERT INTO relay_actions(id,relay_id,action_type, config, order_index,created_at,updated_at)
-	VALUES ($1,$2,$3,$4,$5,$6,$7)
-	RETURNING id,relay_id,action_type,config,order_index,created_at,updated_at`
+	queryAction := `INSERT INTO relay_actions(id,relay_id,action_type, config, order_index,agent_group,agent_labels,min_agent_version,on_failure,optional,created_at,updated_at)
+	VALUES ($1,$2,$3,$4,$5,NULLIF($6,''),$7,NULLIF($8,''),$9,$10,$11,$12)
+	RETURNING id,relay_id,action_type,config,order_index,COALESCE(agent_group,''),agent_labels,COALESCE(min_agent_version,''),on_failure,optional,created_at,updated_at`
 
 	for _, actionReq := range req.Actions {
 		actionID := uuid.New().String()
@@ -71,10 +118,16 @@ func (s *RelayStore) CreateRelay(ctx context.Context, req models.CreateRelayRequ
 		if err != nil {
 			return nil, fmt.Errorf("marshal action config: %w", err)
 		}
+		labels := actionReq.AgentLabels
+		if labels == nil {
+			labels = map[string]string{}
+		}
 		var action models.RelayAction
 		var configBytes []byte
-		err = tx.QueryRow(ctx, queryAction, actionID, relayID, actionReq.ActionType, configJSON, actionReq.OrderIndex, now, now).Scan(
-			&action.ID, &action.RelayID, &action.ActionType, &configBytes, &action.OrderIndex, &action.CreatedAt, &action.UpdatedAt)
+		err = tx.QueryRow(ctx, queryAction, actionID, relayID, actionReq.ActionType, configJSON, actionReq.OrderIndex,
+			actionReq.AgentGroup, labels, actionReq.MinAgentVersion, actionReq.OnFailure, actionReq.Optional, now, now).Scan(
+			&action.ID, &action.RelayID, &action.ActionType, &configBytes, &action.OrderIndex,
+			&action.AgentGroup, &action.AgentLabels, &action.MinAgentVersion, &action.OnFailure, &action.Optional, &action.CreatedAt, &action.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("insert action: %w", err)
 		}
@@ -89,39 +142,29 @@ func (s *RelayStore) CreateRelay(ctx context.Context, req models.CreateRelayRequ
 	}
 
 	return &models.RelayWithActions{
-		Relay:   relay,
+		Relay:   *relay,
 		Actions: actions,
 	}, nil
 }
 
 func (s *RelayStore) GetAllRelays(ctx context.Context, userID string) ([]models.Relay, error) {
-	query := `SELECT id,user_id,name,description,webhook_path, is_active, created_at, updated_at
+	query := `SELECT ` + relayColumns + `
 	FROM relays
 	WHERE user_id = $1::uuid
 	ORDER BY created_at DESC`
 
-	rows, err := s.db.Query(ctx, query, userID)
+	rows, err := s.reads.Query(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("query relays: %w", err)
 	}
 	defer rows.Close()
 	relays := make([]models.Relay, 0)
 	for rows.Next() {
-		var relay models.Relay
-		err := rows.Scan(
-			&relay.ID,
-			&relay.UserID,
-			&relay.Name,
-			&relay.Description,
-			&relay.WebhookPath,
-			&relay.IsActive,
-			&relay.CreatedAt,
-			&relay.UpdatedAt,
-		)
+		relay, err := scanRelay(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan relay: %w", err)
 		}
-		relays = append(relays, relay)
+		relays = append(relays, *relay)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %w", err)
@@ -130,23 +173,22 @@ func (s *RelayStore) GetAllRelays(ctx context.Context, userID string) ([]models.
 }
 
 func (s *RelayStore) GetRelay(ctx context.Context, relayID string) (*models.RelayWithActions, error) {
+	relay, err := getRelay(ctx, s.reads, relayID)
+	// A relay created moments ago may not have reached the replica yet
+	if errors.Is(err, ErrRelayNotFound) && s.reads.Replicated() {
+		return getRelay(ctx, s.db, relayID)
+	}
+	return relay, err
+}
+
+func getRelay(ctx context.Context, db querier, relayID string) (*models.RelayWithActions, error) {
 	queryRelay := `
-		SELECT id, user_id, name, description, webhook_path, is_active, created_at, updated_at
+		SELECT ` + relayColumns + `
 		FROM relays
 		WHERE id = $1
 	`
 
-	var relay models.Relay
-	err := s.db.QueryRow(ctx, queryRelay, relayID).Scan(
-		&relay.ID,
-		&relay.UserID,
-		&relay.Name,
-		&relay.Description,
-		&relay.WebhookPath,
-		&relay.IsActive,
-		&relay.CreatedAt,
-		&relay.UpdatedAt,
-	)
+	relay, err := scanRelay(db.QueryRow(ctx, queryRelay, relayID))
 	if err == pgx.ErrNoRows {
 		return nil, ErrRelayNotFound
 	}
@@ -155,13 +197,14 @@ func (s *RelayStore) GetRelay(ctx context.Context, relayID string) (*models.Rela
 	}
 
 	queryActions := `
-		SELECT id, relay_id, action_type, config, order_index, created_at, updated_at
+		SELECT id, relay_id, action_type, config, order_index, COALESCE(agent_group, ''), agent_labels,
+			COALESCE(min_agent_version, ''), on_failure, optional, created_at, updated_at
 		FROM relay_actions
 		WHERE relay_id = $1
 		ORDER BY order_index ASC
 	`
 
-	rows, err := s.db.Query(ctx, queryActions, relayID)
+	rows, err := db.Query(ctx, queryActions, relayID)
 	if err != nil {
 		return nil, fmt.Errorf("query actions: %w", err)
 	}
@@ -177,6 +220,11 @@ func (s *RelayStore) GetRelay(ctx context.Context, relayID string) (*models.Rela
 			&action.ActionType,
 			&configBytes,
 			&action.OrderIndex,
+			&action.AgentGroup,
+			&action.AgentLabels,
+			&action.MinAgentVersion,
+			&action.OnFailure,
+			&action.Optional,
 			&action.CreatedAt,
 			&action.UpdatedAt,
 		)
@@ -196,7 +244,7 @@ func (s *RelayStore) GetRelay(ctx context.Context, relayID string) (*models.Rela
 	}
 
 	return &models.RelayWithActions{
-		Relay:   relay,
+		Relay:   *relay,
 		Actions: actions,
 	}, nil
 }
@@ -221,19 +269,44 @@ func (s *RelayStore) UpdateRelay(ctx context.Context, relayID string, req models
 		args = append(args, *req.IsActive)
 		argIdx++
 	}
-	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, user_id, name, description, webhook_path, is_active, created_at, updated_at", argIdx)
+	if req.Priority != nil {
+		query += fmt.Sprintf(", priority=$%d", argIdx)
+		args = append(args, *req.Priority)
+		argIdx++
+	}
+	if req.MaxConcurrency != nil {
+		query += fmt.Sprintf(", max_concurrency=$%d", argIdx)
+		args = append(args, *req.MaxConcurrency)
+		argIdx++
+	}
+	if req.DebounceSeconds != nil {
+		query += fmt.Sprintf(", debounce_seconds=$%d", argIdx)
+		args = append(args, *req.DebounceSeconds)
+		argIdx++
+	}
+	if req.ThrottleSeconds != nil {
+		query += fmt.Sprintf(", throttle_seconds=$%d", argIdx)
+		args = append(args, *req.ThrottleSeconds)
+		argIdx++
+	}
+	if req.ThrottleMode != nil {
+		query += fmt.Sprintf(", throttle_mode=$%d", argIdx)
+		args = append(args, *req.ThrottleMode)
+		argIdx++
+	}
+	if req.TimeoutSeconds != nil {
+		query += fmt.Sprintf(", timeout_seconds=$%d", argIdx)
+		args = append(args, *req.TimeoutSeconds)
+		argIdx++
+	}
+	if req.DedupeWindowSeconds != nil {
+		query += fmt.Sprintf(", dedupe_window_seconds=$%d", argIdx)
+		args = append(args, *req.DedupeWindowSeconds)
+		argIdx++
+	}
+	query += fmt.Sprintf(" WHERE id = $%d RETURNING "+relayColumns, argIdx)
 	args = append(args, relayID)
-	var relay models.Relay
-	err := s.db.QueryRow(ctx, query, args...).Scan(
-		&relay.ID,
-		&relay.UserID,
-		&relay.Name,
-		&relay.Description,
-		&relay.WebhookPath,
-		&relay.IsActive,
-		&relay.CreatedAt,
-		&relay.UpdatedAt,
-	)
+	relay, err := scanRelay(s.db.QueryRow(ctx, query, args...))
 	if err == pgx.ErrNoRows {
 		return nil, ErrRelayNotFound
 	}
@@ -241,7 +314,7 @@ func (s *RelayStore) UpdateRelay(ctx context.Context, relayID string, req models
 		return nil, fmt.Errorf("update relay: %w", err)
 	}
 
-	return &relay, nil
+	return relay, nil
 }
 
 func (s *RelayStore) DeleteRelay(ctx context.Context, relayID string) error {
@@ -258,36 +331,133 @@ func (s *RelayStore) DeleteRelay(ctx context.Context, relayID string) error {
 	return nil
 }
 
-func (s *RelayStore) GetLogs(ctx context.Context, relayID string, limit int) ([]models.ExecutionLog, error) {
+// Gives the relay a new signing key, replacing any it had. The key is only
+// returned here
+func (s *RelayStore) RotateSigningKey(ctx context.Context, relayID string) (string, error) {
+	if s.payloads == nil {
+		return "", secrets.ErrNoKey
+	}
+	key, err := signing.NewKey()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.payloads.Seal(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.db.Exec(ctx, `UPDATE relays SET signing_key = $2, signing_data_key = $3, updated_at = NOW()
+	WHERE id = $1`, relayID, sealed.Ciphertext, sealed.DataKey)
+	if err != nil {
+		return "", fmt.Errorf("save signing key: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return "", ErrRelayNotFound
+	}
+	return key, nil
+}
+
+// Stops signing the relay's requests
+func (s *RelayStore) DeleteSigningKey(ctx context.Context, relayID string) error {
+	result, err := s.db.Exec(ctx, `UPDATE relays SET signing_key = NULL, signing_data_key = NULL, updated_at = NOW()
+	WHERE id = $1`, relayID)
+	if err != nil {
+		return fmt.Errorf("delete signing key: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRelayNotFound
+	}
+	return nil
+}
+
+// Records that the event's execution is cancelled and drops it from held
+// events, where it would otherwise wait for a retry or its release time.
+// Returns how many held copies were dropped
+func (s *RelayStore) CancelExecution(ctx context.Context, relayID, eventID string) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `INSERT INTO execution_cancellations (relay_id, event_id)
+	SELECT id, $2 FROM relays WHERE id = $1
+	ON CONFLICT (relay_id, event_id) DO UPDATE SET requested_at = NOW()`, relayID, eventID)
+	if err != nil {
+		return 0, fmt.Errorf("insert cancellation: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return 0, ErrRelayNotFound
+	}
+	held, err := tx.Exec(ctx, `DELETE FROM held_events WHERE relay_id = $1 AND event_id = $2`, relayID, eventID)
+	if err != nil {
+		return 0, fmt.Errorf("drop held event: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return held.RowsAffected(), nil
+}
+
+// Lists the relay's runs newest first, optionally only those of one trace
+func (s *RelayStore) GetLogs(ctx context.Context, relayID, traceID string, limit int) ([]models.ExecutionLog, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
 	query := `
-		SELECT id, relay_id, status, payload, error_message, executed_at
+		SELECT ` + logColumns + `
 		FROM execution_logs
 		WHERE relay_id = $1
+		AND ($2 = '' OR trace_id = $2)
 		ORDER BY executed_at DESC
-		LIMIT $2
+		LIMIT $3
 	`
 
-	rows, err := s.db.Query(ctx, query, relayID, limit)
+	rows, err := s.reads.Query(ctx, query, relayID, traceID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query logs: %w", err)
 	}
+	logs, err := scanLogs(ctx, rows, s.payloads)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachSteps(ctx, s.reads, logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Columns of execution_logs scanLogs reads, the last but two being the
+// wrapped workspace key a sealed payload was sealed with
+const logColumns = `id, relay_id, COALESCE(event_id, ''), COALESCE(trace_id, ''), status, payload, payload_sealed,
+	(SELECT k.data_key FROM workspace_keys k WHERE k.id = payload_key), error_message, executed_at`
+
+// Reads rows of logColumns and closes them, opening sealed payloads with
+// payloads once the rows are done
+func scanLogs(ctx context.Context, rows pgx.Rows, payloads *secrets.Envelope) ([]models.ExecutionLog, error) {
 	defer rows.Close()
 
+	type sealedPayload struct {
+		log        int
+		ciphertext []byte
+		dataKey    []byte
+	}
 	logs := make([]models.ExecutionLog, 0)
+	var sealed []sealedPayload
 	for rows.Next() {
 		var log models.ExecutionLog
-		var payloadBytes []byte
+		var payloadBytes, ciphertext, dataKey []byte
 		var errorMsg *string
 
 		err := rows.Scan(
 			&log.ID,
 			&log.RelayID,
+			&log.EventID,
+			&log.TraceID,
 			&log.Status,
 			&payloadBytes,
+			&ciphertext,
+			&dataKey,
 			&errorMsg,
 			&log.ExecutedAt,
 		)
@@ -300,10 +470,14 @@ func (s *RelayStore) GetLogs(ctx context.Context, relayID string, limit int) ([]
 				return nil, fmt.Errorf("unmarshal payload: %w", err)
 			}
 		}
+		if ciphertext != nil {
+			sealed = append(sealed, sealedPayload{log: len(logs), ciphertext: ciphertext, dataKey: dataKey})
+		}
 
 		if errorMsg != nil {
 			log.ErrorMessage = *errorMsg
 		}
+		log.Steps = make([]models.ExecutionStep, 0)
 
 		logs = append(logs, log)
 	}
@@ -311,6 +485,102 @@ func (s *RelayStore) GetLogs(ctx context.Context, relayID string, limit int) ([]
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %w", err)
 	}
-
+	rows.Close()
+	for _, p := range sealed {
+		log := &logs[p.log]
+		if payloads == nil || p.dataKey == nil {
+			log.PayloadUnavailable = true
+			continue
+		}
+		payload, err := payloads.OpenWith(ctx, p.dataKey, p.ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("open payload of log %s: %w", log.ID, err)
+		}
+		if err := json.Unmarshal(payload, &log.Payload); err != nil {
+			return nil, fmt.Errorf("unmarshal payload: %w", err)
+		}
+	}
 	return logs, nil
 }
+
+// Loads the steps of every log in one query, from where the logs were read
+func attachSteps(ctx context.Context, db querier, logs []models.ExecutionLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(logs))
+	byID := make(map[string]int, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+		byID[l.ID] = i
+	}
+
+	query := `
+		SELECT id, execution_log_id, COALESCE(action_id::text, ''), action_type, order_index, status,
+			duration_ms, COALESCE(error_message, ''), COALESCE(response, ''), started_at
+		FROM execution_steps
+		WHERE execution_log_id = ANY($1::uuid[])
+		ORDER BY order_index ASC, started_at ASC
+	`
+	rows, err := db.Query(ctx, query, ids)
+	if err != nil {
+		return fmt.Errorf("query steps: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step models.ExecutionStep
+		var logID string
+		err := rows.Scan(
+			&step.ID,
+			&logID,
+			&step.ActionID,
+			&step.ActionType,
+			&step.OrderIndex,
+			&step.Status,
+			&step.DurationMs,
+			&step.ErrorMessage,
+			&step.Response,
+			&step.StartedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("scan step: %w", err)
+		}
+		if i, ok := byID[logID]; ok {
+			logs[i].Steps = append(logs[i].Steps, step)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %w", err)
+	}
+	return nil
+}
+
+// The database side of the admin overview: relay and dead letter totals, and
+// executions since the given time with how many of them failed
+func (s *RelayStore) Overview(ctx context.Context, since time.Time) (*models.SystemOverview, error) {
+	var o models.SystemOverview
+	err := s.db.QueryRow(ctx, `SELECT
+		(SELECT count(*) FROM relays),
+		(SELECT count(*) FROM relays WHERE is_active),
+		count(*) FILTER (WHERE status IN ('success', 'partial_success', 'failed', 'timeout')),
+		count(*) FILTER (WHERE status IN ('failed', 'timeout')),
+		(SELECT count(*) FROM dead_letters WHERE status = 'dead')
+	FROM execution_logs WHERE executed_at > $1`, since,
+	).Scan(&o.Relays, &o.ActiveRelays, &o.Executions24h, &o.FailedExecutions, &o.DLQDepth)
+	if err != nil {
+		return nil, fmt.Errorf("query overview: %w", err)
+	}
+	return &o, nil
+}
+
+// Relay totals for the metrics endpoint
+func (s *RelayStore) CountRelays(ctx context.Context) (active, inactive int, err error) {
+	err = s.db.QueryRow(ctx,
+		`SELECT count(*) FILTER (WHERE is_active), count(*) FILTER (WHERE NOT is_active) FROM relays`,
+	).Scan(&active, &inactive)
+	if err != nil {
+		return 0, 0, fmt.Errorf("count relays: %w", err)
+	}
+	return active, inactive, nil
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrSecretExists = errors.New("secret already exists")
+
+// Stores secret values sealed with their own data key, or references into
+// Vault or AWS Secrets Manager. Plaintext never reaches the database
+type SecretStore struct {
+	db       *pgxpool.Pool
+	envelope *secrets.Envelope
+	backends map[string]secrets.Backend
+}
+
+// envelope may be nil when no key is configured; sealing and opening values then
+// fail with secrets.ErrNoKey. backends holds the external stores set up
+func NewSecretStore(db *pgxpool.Pool, envelope *secrets.Envelope, backends map[string]secrets.Backend) *SecretStore {
+	return &SecretStore{db: db, envelope: envelope, backends: backends}
+}
+
+const secretColumns = `id, user_id, name, backend, COALESCE(reference, ''), created_at, updated_at`
+
+func scanSecret(row pgx.Row) (*models.Secret, error) {
+	var s models.Secret
+	if err := row.Scan(&s.ID, &s.UserID, &s.Name, &s.Backend, &s.Reference, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Creates or replaces the user's secret
+func (s *SecretStore) Put(ctx context.Context, req models.PutSecretRequest) (*models.Secret, error) {
+	return s.save(ctx, req, `ON CONFLICT (user_id, name) DO UPDATE
+	SET backend = EXCLUDED.backend, reference = EXCLUDED.reference,
+		ciphertext = EXCLUDED.ciphertext, data_key = EXCLUDED.data_key, updated_at = NOW()`)
+}
+
+// Creates the user's secret, ErrSecretExists when the name is taken
+func (s *SecretStore) Create(ctx context.Context, req models.PutSecretRequest) (*models.Secret, error) {
+	secret, err := s.save(ctx, req, `ON CONFLICT (user_id, name) DO NOTHING`)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSecretExists
+	}
+	return secret, err
+}
+
+func (s *SecretStore) save(ctx context.Context, req models.PutSecretRequest, onConflict string) (*models.Secret, error) {
+	backend := req.Backend
+	if backend == "" {
+		backend = secrets.BackendHermes
+	}
+	var sealed secrets.Sealed
+	var reference *string
+	if backend == secrets.BackendHermes {
+		if s.envelope == nil {
+			return nil, secrets.ErrNoKey
+		}
+		var err error
+		if sealed, err = s.envelope.Seal(ctx, req.Value); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, ok := s.backends[backend]; !ok {
+			return nil, fmt.Errorf("%w: %s", secrets.ErrBackendNotConfigured, backend)
+		}
+		reference = &req.Reference
+	}
+	query := `INSERT INTO secrets (user_id, name, backend, reference, ciphertext, data_key)
+	VALUES ($1, $2, $3, $4, $5, $6) ` + onConflict + `
+	RETURNING ` + secretColumns
+	secret, err := scanSecret(s.db.QueryRow(ctx, query, req.UserID, req.Name, backend, reference, sealed.Ciphertext, sealed.DataKey))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save secret: %w", err)
+	}
+	return secret, nil
+}
+
+func (s *SecretStore) List(ctx context.Context, userID string) ([]models.Secret, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+secretColumns+` FROM secrets WHERE user_id::text = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query secrets: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]models.Secret, 0)
+	for rows.Next() {
+		secret, err := scanSecret(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan secret: %w", err)
+		}
+		list = append(list, *secret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return list, nil
+}
+
+func (s *SecretStore) Delete(ctx context.Context, userID, name string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM secrets WHERE user_id::text = $1 AND name = $2`, userID, name)
+	if err != nil {
+		return fmt.Errorf("delete secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return secrets.ErrNotFound
+	}
+	return nil
+}
+
+// Returns the value of the user's secret, for values core uses itself
+func (s *SecretStore) Open(ctx context.Context, userID, name string) (string, error) {
+	var stored secrets.Stored
+	err := s.db.QueryRow(ctx, `SELECT backend, COALESCE(reference, ''), ciphertext, data_key
+	FROM secrets WHERE user_id::text = $1 AND name = $2`, userID, name).
+		Scan(&stored.Backend, &stored.Reference, &stored.Sealed.Ciphertext, &stored.Sealed.DataKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", secrets.ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("query secret: %w", err)
+	}
+	return secrets.Open(ctx, stored, s.envelope, s.backends)
+}
+
+// Returns which of names the user has no secret for
+func (s *SecretStore) Missing(ctx context.Context, userID string, names []string) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT DISTINCT n FROM unnest($2::text[]) AS n
+	WHERE NOT EXISTS (SELECT 1 FROM secrets WHERE user_id::text = $1 AND name = n)`, userID, names)
+	if err != nil {
+		return nil, fmt.Errorf("query missing secrets: %w", err)
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan secret name: %w", err)
+		}
+		missing = append(missing, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return missing, nil
+}
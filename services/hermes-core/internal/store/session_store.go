@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrLoginStateNotFound = errors.New("login state is unknown or expired")
+	ErrInvalidSession     = errors.New("session is invalid or expired")
+	// The identity provider's email belongs to a user it can't be linked to
+	ErrAccountConflict = errors.New("email is already registered to another account")
+)
+
+// How long a login may take between leaving for the identity provider and coming back
+const loginStateTTL = 10 * time.Minute
+
+// Prefix of session tokens, telling them apart from JWTs
+const SessionTokenPrefix = "hss_"
+
+// Logins through the identity provider and the sessions they issue
+type SessionStore struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionStore(db *pgxpool.Pool) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+// Remembers a login until its callback. Logins that never came back are dropped
+func (s *SessionStore) SaveLoginState(ctx context.Context, state, verifier, nonce string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM oidc_login_states WHERE created_at < NOW() - $1 * INTERVAL '1 second'`,
+		loginStateTTL.Seconds()); err != nil {
+		return fmt.Errorf("prune login states: %w", err)
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO oidc_login_states (state, code_verifier, nonce) VALUES ($1, $2, $3)`,
+		state, verifier, nonce)
+	if err != nil {
+		return fmt.Errorf("save login state: %w", err)
+	}
+	return nil
+}
+
+// Consumes a login's state, so a callback can't be replayed
+func (s *SessionStore) TakeLoginState(ctx context.Context, state string) (verifier, nonce string, err error) {
+	err = s.db.QueryRow(ctx, `DELETE FROM oidc_login_states
+	WHERE state = $1 AND created_at >= NOW() - $2 * INTERVAL '1 second'
+	RETURNING code_verifier, nonce`, state, loginStateTTL.Seconds()).Scan(&verifier, &nonce)
+	if err == pgx.ErrNoRows {
+		return "", "", ErrLoginStateNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("take login state: %w", err)
+	}
+	return verifier, nonce, nil
+}
+
+// The user signing in as id. An existing user with the same verified email is
+// linked to the provider account on first login, otherwise one is created
+func (s *SessionStore) UserForIdentity(ctx context.Context, id auth.Identity) (string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `SELECT id::text FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2`,
+		id.Issuer, id.Subject).Scan(&userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", fmt.Errorf("find user: %w", err)
+	}
+	if err == pgx.ErrNoRows {
+		if id.Email == "" {
+			return "", fmt.Errorf("%w: the identity provider sent no email", ErrAccountConflict)
+		}
+		var linked bool
+		err = tx.QueryRow(ctx, `SELECT id::text, oidc_subject IS NOT NULL FROM users WHERE lower(email) = lower($1)`,
+			id.Email).Scan(&userID, &linked)
+		switch {
+		case err == nil && (linked || !id.EmailVerified):
+			return "", ErrAccountConflict
+		case err == nil:
+			_, err = tx.Exec(ctx, `UPDATE users SET oidc_issuer = $2, oidc_subject = $3, updated_at = NOW() WHERE id = $1`,
+				userID, id.Issuer, id.Subject)
+			if err != nil {
+				return "", fmt.Errorf("link user: %w", err)
+			}
+		case err == pgx.ErrNoRows:
+			if userID, err = createOIDCUser(ctx, tx, id); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("find user by email: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// Usernames are unique, a taken one gets a suffix from the subject
+func createOIDCUser(ctx context.Context, tx pgx.Tx, id auth.Identity) (string, error) {
+	username := id.Username
+	if username == "" {
+		username, _, _ = strings.Cut(id.Email, "@")
+	}
+	var userID string
+	err := tx.QueryRow(ctx, `INSERT INTO users (username, email, oidc_issuer, oidc_subject)
+	VALUES (CASE WHEN EXISTS (SELECT 1 FROM users WHERE username = $1) THEN $1 || '-' || left(md5($4), 6) ELSE $1 END, $2, $3, $4)
+	RETURNING id::text`, username, id.Email, id.Issuer, id.Subject).Scan(&userID)
+	if err != nil {
+		return "", fmt.Errorf("create user: %w", err)
+	}
+	return userID, nil
+}
+
+// Issues a session for the user. The token is only returned here
+func (s *SessionStore) CreateSession(ctx context.Context, userID string, ttl time.Duration) (string, time.Time, error) {
+	token, hash, err := newToken(SessionTokenPrefix)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	if _, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1 AND expires_at <= NOW()`, userID); err != nil {
+		return "", time.Time{}, fmt.Errorf("prune sessions: %w", err)
+	}
+	_, err = s.db.Exec(ctx, `INSERT INTO sessions (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, hash, expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("create session: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// The user a live session token belongs to
+func (s *SessionStore) SessionUser(ctx context.Context, token string) (string, error) {
+	var userID string
+	err := s.db.QueryRow(ctx, `SELECT user_id::text FROM sessions WHERE token_hash = $1 AND expires_at > NOW()`,
+		hashToken(token)).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return "", ErrInvalidSession
+	}
+	if err != nil {
+		return "", fmt.Errorf("find session: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *SessionStore) DeleteSession(ctx context.Context, token string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, hashToken(token)); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Advisory lock held while usage is rolled up, so one core instance does it at a time
+const usageLockKey int64 = 0x6865726d6575
+
+// How usage days are written and read back
+const UsageDayLayout = "2006-01-02"
+
+type UsageStore struct {
+	db *pgxpool.Pool
+}
+
+func NewUsageStore(db *pgxpool.Pool) *UsageStore {
+	return &UsageStore{db: db}
+}
+
+// The database's clock, which the logs being counted are stamped with
+func (s *UsageStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRow(ctx, `SELECT NOW()::timestamp`).Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("read clock: %w", err)
+	}
+	return now, nil
+}
+
+// Takes the usage lock without waiting. ok is false when another instance
+// holds it; otherwise unlock must be called
+func (s *UsageStore) TryLock(ctx context.Context) (unlock func(), ok bool, err error) {
+	return tryAdvisoryLock(ctx, s.db, usageLockKey)
+}
+
+// The latest day whose usage is final. ok is false before any day was closed
+func (s *UsageStore) LastClosedDay(ctx context.Context) (day time.Time, ok bool, err error) {
+	var last *time.Time
+	if err := s.db.QueryRow(ctx, `SELECT max(day) FROM usage_closed_days`).Scan(&last); err != nil {
+		return time.Time{}, false, fmt.Errorf("read last closed day: %w", err)
+	}
+	if last == nil {
+		return time.Time{}, false, nil
+	}
+	return *last, true, nil
+}
+
+// Recounts every user's usage on day from the delivery log, execution logs
+// and their steps. Counts are replaced, so running it again is harmless
+func (s *UsageStore) RollUp(ctx context.Context, day time.Time) error {
+	_, err := s.db.Exec(ctx, `WITH deliveries AS (
+		SELECT relay_id, count(*) AS events, sum(size_bytes) AS bytes FROM webhook_deliveries
+		WHERE received_at >= $1::date AND received_at < $1::date + 1 AND status < 400
+		GROUP BY relay_id
+	), executions AS (
+		SELECT relay_id, count(*) AS executions FROM execution_logs
+		WHERE executed_at >= $1::date AND executed_at < $1::date + 1
+		AND status IN ('success', 'partial_success', 'failed', 'timeout')
+		GROUP BY relay_id
+	), invocations AS (
+		SELECT l.relay_id, count(*) AS invocations FROM execution_steps st
+		JOIN execution_logs l ON l.id = st.execution_log_id AND l.executed_at = st.executed_at
+		WHERE st.executed_at >= $1::date AND st.executed_at < $1::date + 1
+		GROUP BY l.relay_id
+	), totals AS (
+		SELECT r.user_id,
+			COALESCE(sum(d.events), 0) AS events, COALESCE(sum(d.bytes), 0) AS bytes,
+			COALESCE(sum(e.executions), 0) AS executions, COALESCE(sum(i.invocations), 0) AS invocations
+		FROM relays r
+		LEFT JOIN deliveries d ON d.relay_id = r.id::text
+		LEFT JOIN executions e ON e.relay_id = r.id
+		LEFT JOIN invocations i ON i.relay_id = r.id
+		GROUP BY r.user_id
+	)
+	INSERT INTO usage_daily (user_id, day, events_ingested, bytes_ingested, executions, action_invocations, updated_at)
+	SELECT user_id, $1::date, events, bytes, executions, invocations, NOW() FROM totals
+	WHERE events > 0 OR executions > 0 OR invocations > 0
+	ON CONFLICT (user_id, day) DO UPDATE SET
+		events_ingested = EXCLUDED.events_ingested,
+		bytes_ingested = EXCLUDED.bytes_ingested,
+		executions = EXCLUDED.executions,
+		action_invocations = EXCLUDED.action_invocations,
+		updated_at = NOW()`, day)
+	if err != nil {
+		return fmt.Errorf("roll up usage for %s: %w", day.Format(UsageDayLayout), err)
+	}
+	return nil
+}
+
+// Marks day's usage final. It isn't rolled up again after this
+func (s *UsageStore) CloseDay(ctx context.Context, day time.Time) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO usage_closed_days (day) VALUES ($1::date) ON CONFLICT DO NOTHING`, day)
+	if err != nil {
+		return fmt.Errorf("close usage day: %w", err)
+	}
+	return nil
+}
+
+// Closed days not yet handed to the usage export, oldest first
+func (s *UsageStore) UnexportedDays(ctx context.Context) ([]time.Time, error) {
+	rows, err := s.db.Query(ctx, `SELECT day FROM usage_closed_days WHERE exported_at IS NULL ORDER BY day`)
+	if err != nil {
+		return nil, fmt.Errorf("query unexported days: %w", err)
+	}
+	days, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		return nil, fmt.Errorf("scan unexported days: %w", err)
+	}
+	return days, nil
+}
+
+func (s *UsageStore) MarkExported(ctx context.Context, day time.Time) error {
+	_, err := s.db.Exec(ctx, `UPDATE usage_closed_days SET exported_at = NOW() WHERE day = $1::date`, day)
+	if err != nil {
+		return fmt.Errorf("mark usage exported: %w", err)
+	}
+	return nil
+}
+
+// Every user's usage on day
+func (s *UsageStore) DayUsage(ctx context.Context, day time.Time) ([]models.DailyUsage, error) {
+	return s.list(ctx, `WHERE day = $1::date ORDER BY user_id`, day)
+}
+
+// The user's usage from one day through another, oldest first
+func (s *UsageStore) UserUsage(ctx context.Context, userID string, from, to time.Time) ([]models.DailyUsage, error) {
+	return s.list(ctx, `WHERE user_id = $1 AND day BETWEEN $2::date AND $3::date ORDER BY day`,
+		userID, from, to)
+}
+
+func (s *UsageStore) list(ctx context.Context, where string, args ...any) ([]models.DailyUsage, error) {
+	rows, err := s.db.Query(ctx, `SELECT user_id, day::text, events_ingested, bytes_ingested, executions, action_invocations
+	FROM usage_daily `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query usage: %w", err)
+	}
+	defer rows.Close()
+	usage := make([]models.DailyUsage, 0)
+	for rows.Next() {
+		var u models.DailyUsage
+		if err := rows.Scan(&u.UserID, &u.Day, &u.EventsIngested, &u.BytesIngested, &u.Executions, &u.ActionInvocations); err != nil {
+			return nil, fmt.Errorf("scan usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return usage, nil
+}
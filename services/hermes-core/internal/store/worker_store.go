@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reads the heartbeats worker instances write
+type WorkerStore struct {
+	db *pgxpool.Pool
+}
+
+const (
+	WorkerStatusOnline   = "online"
+	WorkerStatusDraining = "draining"
+	WorkerStatusOffline  = "offline"
+	WorkerStatusStopped  = "stopped"
+
+	// An instance that missed this many heartbeats in a row is reported offline
+	missedHeartbeats = 3
+	// Instances gone for longer than this are left out of the listing
+	workerListWindow = 24 * time.Hour
+)
+
+func NewWorkerStore(db *pgxpool.Pool) *WorkerStore {
+	return &WorkerStore{db: db}
+}
+
+func workerStatus(w *models.WorkerInstance, now time.Time) string {
+	interval := time.Duration(max(w.HeartbeatIntervalSeconds, 1)) * time.Second
+	switch {
+	case w.StoppedAt != nil:
+		return WorkerStatusStopped
+	case now.Sub(w.LastSeenAt) > missedHeartbeats*interval:
+		return WorkerStatusOffline
+	case w.Draining:
+		return WorkerStatusDraining
+	default:
+		return WorkerStatusOnline
+	}
+}
+
+const workerColumns = `id, hostname, version, started_at, last_seen_at, heartbeat_interval_seconds,
+	workers, active_jobs, queued_jobs, parked_jobs, queue_lag, draining, stopped_at`
+
+// Instances seen in the last day, most recent first
+func (s *WorkerStore) ListWorkers(ctx context.Context) ([]models.WorkerInstance, error) {
+	query := `SELECT ` + workerColumns + `, NOW() FROM worker_instances
+	WHERE last_seen_at > NOW() - make_interval(secs => $1)
+	ORDER BY last_seen_at DESC`
+	rows, err := s.db.Query(ctx, query, workerListWindow.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("query workers: %w", err)
+	}
+	defer rows.Close()
+	workers := make([]models.WorkerInstance, 0)
+	for rows.Next() {
+		var w models.WorkerInstance
+		var now time.Time
+		if err := rows.Scan(&w.ID, &w.Hostname, &w.Version, &w.StartedAt, &w.LastSeenAt, &w.HeartbeatIntervalSeconds,
+			&w.Workers, &w.ActiveJobs, &w.QueuedJobs, &w.ParkedJobs, &w.QueueLag, &w.Draining, &w.StoppedAt, &now); err != nil {
+			return nil, fmt.Errorf("scan worker: %w", err)
+		}
+		// Compared against the database clock, which wrote last_seen_at
+		w.Status = workerStatus(&w, now)
+		workers = append(workers, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return workers, nil
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+func TestWorkerStatus(t *testing.T) {
+	now := time.Now()
+	stopped := now.Add(-time.Minute)
+	cases := []struct {
+		name string
+		w    models.WorkerInstance
+		want string
+	}{
+		{"fresh", models.WorkerInstance{LastSeenAt: now.Add(-10 * time.Second), HeartbeatIntervalSeconds: 15}, WorkerStatusOnline},
+		{"one missed beat", models.WorkerInstance{LastSeenAt: now.Add(-30 * time.Second), HeartbeatIntervalSeconds: 15}, WorkerStatusOnline},
+		{"silent", models.WorkerInstance{LastSeenAt: now.Add(-time.Minute), HeartbeatIntervalSeconds: 15}, WorkerStatusOffline},
+		{"draining", models.WorkerInstance{LastSeenAt: now, HeartbeatIntervalSeconds: 15, Draining: true}, WorkerStatusDraining},
+		{"stopped", models.WorkerInstance{LastSeenAt: stopped, HeartbeatIntervalSeconds: 15, StoppedAt: &stopped}, WorkerStatusStopped},
+	}
+	for _, c := range cases {
+		if got := workerStatus(&c.w, now); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/auth"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotMember = errors.New("not a member of the workspace")
+
+// Who besides its owner has access to a user's workspace, and with which role
+type WorkspaceStore struct {
+	db *pgxpool.Pool
+}
+
+func NewWorkspaceStore(db *pgxpool.Pool) *WorkspaceStore {
+	return &WorkspaceStore{db: db}
+}
+
+// The role userID holds in workspaceID, the owner's for their own
+func (s *WorkspaceStore) MemberRole(ctx context.Context, workspaceID, userID string) (auth.Role, error) {
+	if workspaceID == userID {
+		return auth.RoleOwner, nil
+	}
+	var role string
+	err := s.db.QueryRow(ctx, `SELECT role FROM workspace_members
+	WHERE workspace_id::text = $1 AND user_id::text = $2`, workspaceID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", fmt.Errorf("query member role: %w", err)
+	}
+	return auth.Role(role), nil
+}
+
+const memberColumns = `m.workspace_id::text, m.user_id::text, u.username, m.role, m.created_at, m.updated_at`
+
+func scanMember(row pgx.Row) (*models.WorkspaceMember, error) {
+	var m models.WorkspaceMember
+	if err := row.Scan(&m.WorkspaceID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *WorkspaceStore) ListMembers(ctx context.Context, workspaceID string) ([]models.WorkspaceMember, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+memberColumns+` FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id::text = $1 ORDER BY u.username`, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("query members: %w", err)
+	}
+	defer rows.Close()
+
+	members := make([]models.WorkspaceMember, 0)
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan member: %w", err)
+		}
+		members = append(members, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return members, nil
+}
+
+// Adds userID to the workspace or changes their role
+func (s *WorkspaceStore) PutMember(ctx context.Context, workspaceID, userID string, role auth.Role) (*models.WorkspaceMember, error) {
+	query := `WITH upserted AS (
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT w.id, u.id, $3 FROM users w, users u WHERE w.id::text = $1 AND u.id::text = $2
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role, updated_at = NOW()
+		RETURNING *
+	)
+	SELECT ` + memberColumns + ` FROM upserted m JOIN users u ON u.id = m.user_id`
+	member, err := scanMember(s.db.QueryRow(ctx, query, workspaceID, userID, string(role)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upsert member: %w", err)
+	}
+	return member, nil
+}
+
+func (s *WorkspaceStore) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM workspace_members WHERE workspace_id::text = $1 AND user_id::text = $2`,
+		workspaceID, userID)
+	if err != nil {
+		return fmt.Errorf("delete member: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package usage meters billable usage. Each pass recounts every user's usage
+// on the days still open into usage_daily, closes a day once its logs have
+// settled, and hands closed days to the usage export when one is configured
+package usage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/store"
+)
+
+const (
+	// Deliveries and execution logs land a little after the fact, so a day is
+	// only closed this long after it ended
+	settleDelay = 15 * time.Minute
+	// Days counted on the first pass, before any day was closed
+	backfillDays = 7
+	// How long one pass may take
+	passTimeout = 5 * time.Minute
+)
+
+// Usage rollups, implemented by *store.UsageStore
+type Store interface {
+	Now(ctx context.Context) (time.Time, error)
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+	LastClosedDay(ctx context.Context) (day time.Time, ok bool, err error)
+	RollUp(ctx context.Context, day time.Time) error
+	CloseDay(ctx context.Context, day time.Time) error
+	UnexportedDays(ctx context.Context) ([]time.Time, error)
+	DayUsage(ctx context.Context, day time.Time) ([]models.DailyUsage, error)
+	MarkExported(ctx context.Context, day time.Time) error
+}
+
+// Takes every user's usage for a closed day, once. An error leaves the day to
+// be sent again on the next pass
+type Exporter interface {
+	Export(ctx context.Context, day string, usage []models.DailyUsage) error
+}
+
+type Meter struct {
+	store    Store
+	exporter Exporter
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+// exporter may be nil, which keeps usage in the database only
+func New(s Store, exporter Exporter, logger *slog.Logger, interval time.Duration) *Meter {
+	return &Meter{store: s, exporter: exporter, logger: logger, interval: interval}
+}
+
+// Rolls usage up now and then each interval until ctx is done
+func (m *Meter) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.RollUp(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// One pass, skipped while another core instance holds the usage lock
+func (m *Meter) RollUp(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, passTimeout)
+	defer cancel()
+	unlock, ok, err := m.store.TryLock(ctx)
+	if err != nil {
+		m.logger.Error("failed to take usage lock", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := m.rollUp(ctx); err != nil {
+		m.logger.Error("failed to roll up usage", slog.String("error", err.Error()))
+		return
+	}
+	if m.exporter != nil {
+		m.export(ctx)
+	}
+}
+
+func (m *Meter) rollUp(ctx context.Context) error {
+	now, err := m.store.Now(ctx)
+	if err != nil {
+		return err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := today.AddDate(0, 0, -backfillDays)
+	last, ok, err := m.store.LastClosedDay(ctx)
+	if err != nil {
+		return err
+	}
+	if ok {
+		day = time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+	for ; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if err := m.store.RollUp(ctx, day); err != nil {
+			return err
+		}
+		if now.Before(day.AddDate(0, 0, 1).Add(settleDelay)) {
+			continue
+		}
+		if err := m.store.CloseDay(ctx, day); err != nil {
+			return err
+		}
+		m.logger.Info("usage day closed", slog.String("day", day.Format(store.UsageDayLayout)))
+	}
+	return nil
+}
+
+// Sends closed days oldest first, stopping at the first one the export refuses
+func (m *Meter) export(ctx context.Context) {
+	days, err := m.store.UnexportedDays(ctx)
+	if err != nil {
+		m.logger.Error("failed to list unexported usage", slog.String("error", err.Error()))
+		return
+	}
+	for _, day := range days {
+		label := day.Format(store.UsageDayLayout)
+		usage, err := m.store.DayUsage(ctx, day)
+		if err == nil {
+			err = m.exporter.Export(ctx, label, usage)
+		}
+		if err == nil {
+			err = m.store.MarkExported(ctx, day)
+		}
+		if err != nil {
+			m.logger.Warn("usage export failed", slog.String("day", label), slog.String("error", err.Error()))
+			return
+		}
+		m.logger.Info("usage exported", slog.String("day", label), slog.Int("users", len(usage)))
+	}
+}
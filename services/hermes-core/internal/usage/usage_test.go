@@ -0,0 +1,162 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+type fakeStore struct {
+	now      time.Time
+	locked   bool
+	closed   []string
+	exported []string
+	rolled   []string
+}
+
+func (f *fakeStore) Now(context.Context) (time.Time, error) { return f.now, nil }
+
+func (f *fakeStore) TryLock(context.Context) (func(), bool, error) {
+	if f.locked {
+		return nil, false, nil
+	}
+	return func() {}, true, nil
+}
+
+func (f *fakeStore) LastClosedDay(context.Context) (time.Time, bool, error) {
+	if len(f.closed) == 0 {
+		return time.Time{}, false, nil
+	}
+	last, _ := time.Parse("2006-01-02", f.closed[len(f.closed)-1])
+	return last, true, nil
+}
+
+func (f *fakeStore) RollUp(_ context.Context, day time.Time) error {
+	f.rolled = append(f.rolled, day.Format("2006-01-02"))
+	return nil
+}
+
+func (f *fakeStore) CloseDay(_ context.Context, day time.Time) error {
+	f.closed = append(f.closed, day.Format("2006-01-02"))
+	return nil
+}
+
+func (f *fakeStore) UnexportedDays(context.Context) ([]time.Time, error) {
+	var days []time.Time
+	for _, d := range f.closed {
+		if !slices.Contains(f.exported, d) {
+			day, _ := time.Parse("2006-01-02", d)
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+func (f *fakeStore) DayUsage(_ context.Context, day time.Time) ([]models.DailyUsage, error) {
+	return []models.DailyUsage{{UserID: "u1", Day: day.Format("2006-01-02"), UsageCounts: models.UsageCounts{Executions: 3}}}, nil
+}
+
+func (f *fakeStore) MarkExported(_ context.Context, day time.Time) error {
+	f.exported = append(f.exported, day.Format("2006-01-02"))
+	return nil
+}
+
+type fakeExporter struct {
+	days []string
+	err  error
+}
+
+func (e *fakeExporter) Export(_ context.Context, day string, _ []models.DailyUsage) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.days = append(e.days, day)
+	return nil
+}
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestRollUpClosesSettledDaysOnce(t *testing.T) {
+	f := &fakeStore{now: at(16, 0, 5)}
+	exp := &fakeExporter{}
+	m := New(f, exp, logger.New("hermes-core-test", "test", "error"), time.Minute)
+
+	m.RollUp(context.Background())
+	if len(f.rolled) != backfillDays+1 || f.rolled[len(f.rolled)-1] != "2026-10-16" {
+		t.Fatalf("Expected the last %d days and today rolled up, got %v", backfillDays, f.rolled)
+	}
+	// Yesterday's logs may still be landing five minutes past midnight
+	if slices.Contains(f.closed, "2026-10-15") || f.closed[len(f.closed)-1] != "2026-10-14" {
+		t.Errorf("Expected days through the 14th closed, got %v", f.closed)
+	}
+
+	f.now, f.rolled = at(16, 0, 30), nil
+	m.RollUp(context.Background())
+	if !slices.Equal(f.rolled, []string{"2026-10-15", "2026-10-16"}) {
+		t.Errorf("Expected only open days rolled up again, got %v", f.rolled)
+	}
+	if len(exp.days) != backfillDays || exp.days[len(exp.days)-1] != "2026-10-15" {
+		t.Errorf("Expected every closed day exported once, got %v", exp.days)
+	}
+}
+
+func TestExportRetriesRefusedDays(t *testing.T) {
+	f := &fakeStore{now: at(16, 12, 0), closed: []string{"2026-10-14"}}
+	exp := &fakeExporter{err: errors.New("billing is down")}
+	m := New(f, exp, logger.New("hermes-core-test", "test", "error"), time.Minute)
+	m.RollUp(context.Background())
+	if len(f.exported) != 0 {
+		t.Fatalf("Expected nothing marked exported, got %v", f.exported)
+	}
+	exp.err = nil
+	m.RollUp(context.Background())
+	if !slices.Equal(exp.days, []string{"2026-10-14", "2026-10-15"}) {
+		t.Errorf("Expected both closed days sent once billing is back, got %v", exp.days)
+	}
+}
+
+func TestRollUpSkippedWhileLocked(t *testing.T) {
+	f := &fakeStore{now: at(16, 12, 0), locked: true}
+	New(f, nil, logger.New("hermes-core-test", "test", "error"), time.Minute).RollUp(context.Background())
+	if len(f.rolled) != 0 {
+		t.Errorf("Expected no rollup while another instance holds the lock, got %v", f.rolled)
+	}
+}
+
+func TestWebhookExporterSigns(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(signatureHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	err := NewWebhookExporter(srv.URL, "s3cret").Export(context.Background(), "2026-10-15",
+		[]models.DailyUsage{{UserID: "u1", Day: "2026-10-15", UsageCounts: models.UsageCounts{EventsIngested: 2}}})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var sent struct {
+		Day   string              `json:"day"`
+		Usage []models.DailyUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &sent); err != nil || sent.Day != "2026-10-15" || sent.Usage[0].EventsIngested != 2 {
+		t.Errorf("Expected the day's usage in the body, got %s", body)
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("Expected a signature, got %q", signature)
+	}
+}
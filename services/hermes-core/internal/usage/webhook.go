@@ -0,0 +1,67 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-core/internal/models"
+)
+
+// Carries the body's HMAC-SHA256 under the export secret, as sha256=<hex>,
+// like log export webhooks
+const signatureHeader = "X-Hermes-Signature"
+
+const sendTimeout = 30 * time.Second
+
+// POSTs each closed day as {"day": "2026-10-15", "usage": [...]}, one entry
+// per user with usage that day
+type WebhookExporter struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// secret may be empty, which leaves bodies unsigned
+func NewWebhookExporter(url, secret string) *WebhookExporter {
+	return &WebhookExporter{url: url, secret: secret, client: &http.Client{Timeout: sendTimeout}}
+}
+
+func (e *WebhookExporter) Export(ctx context.Context, day string, usage []models.DailyUsage) error {
+	body, err := json.Marshal(struct {
+		Day   string              `json:"day"`
+		Usage []models.DailyUsage `json:"usage"`
+	}{day, usage})
+	if err != nil {
+		return fmt.Errorf("encode usage: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build usage request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.secret != "" {
+		mac := hmac.New(sha256.New, []byte(e.secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("usage post: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("usage post: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
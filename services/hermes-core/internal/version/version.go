@@ -0,0 +1,55 @@
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compares dotted versions like "1.4.2" or "v1.10". Missing parts count as zero,
+// and anything after a "-" (pre-release/build) is ignored. Returns -1, 0 or 1
+func Compare(a, b string) int {
+	pa, pb := parts(a), parts(b)
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Reports whether have satisfies the minimum want. An empty minimum is always met
+func AtLeast(have, want string) bool {
+	if want == "" {
+		return true
+	}
+	if have == "" {
+		return false
+	}
+	return Compare(have, want) >= 0
+}
+
+func parts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var out []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			n = 0
+		}
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2", 0},
+		{"v1.10.0", "1.9.9", 1},
+		{"1.2.3-rc1", "1.2.3", 0},
+		{"0.9", "1.0", -1},
+	}
+	for _, c := range cases {
+		if got := Compare(c.a, c.b); got != c.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	if !AtLeast("1.0.0", "") {
+		t.Error("empty minimum should always be met")
+	}
+	if AtLeast("", "1.0.0") {
+		t.Error("unknown version should not meet a minimum")
+	}
+	if !AtLeast("2.0.0", "1.5.0") {
+		t.Error("2.0.0 should meet 1.5.0")
+	}
+}
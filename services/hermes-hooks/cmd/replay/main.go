@@ -0,0 +1,100 @@
+// Replays recorded webhook fixtures against a running hermes-hooks instance
+//
+//	go run ./cmd/replay -relay <relay-id> [-target http://localhost:8080] [-fixtures testdata/fixtures] [-provider github]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/fixtures"
+)
+
+// Stamped at release time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "base URL of hermes-hooks")
+	relayID := flag.String("relay", "", "relay ID to deliver fixtures to (required)")
+	dir := flag.String("fixtures", "testdata/fixtures", "fixture file or directory")
+	provider := flag.String("provider", "", "only replay fixtures from this provider")
+	delay := flag.Duration("delay", 0, "pause between requests")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *relayID == "" {
+		fmt.Fprintln(os.Stderr, "-relay is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	corpus, err := loadCorpus(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	url := strings.TrimRight(*target, "/") + "/hooks/" + *relayID
+	failed := 0
+	sent := 0
+	for _, f := range corpus {
+		if *provider != "" && f.Provider != *provider {
+			continue
+		}
+		sent++
+		req, err := f.Request(url)
+		if err != nil {
+			fmt.Printf("FAIL %s/%s: %v\n", f.Provider, f.Name, err)
+			failed++
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Printf("FAIL %s/%s: %v\n", f.Provider, f.Name, err)
+			failed++
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		status := "OK  "
+		if resp.StatusCode >= 300 {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s/%s: %d %s\n", status, f.Provider, f.Name, resp.StatusCode, strings.TrimSpace(string(body)))
+		if *delay > 0 {
+			time.Sleep(*delay)
+		}
+	}
+
+	fmt.Printf("replayed %d fixtures, %d failed\n", sent, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func loadCorpus(path string) ([]fixtures.Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open fixtures: %w", err)
+	}
+	if !info.IsDir() {
+		f, err := fixtures.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		return []fixtures.Fixture{f}, nil
+	}
+	return fixtures.LoadDir(path)
+}
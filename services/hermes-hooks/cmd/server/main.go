@@ -1,40 +1,121 @@
 package main
 
 import (
+	"context"
+	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
 	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/reload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
 	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/api"
 	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/config"
+	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/metrics"
 	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/queue"
 	"github.com/joho/godotenv"
 )
 
+// Stamped at release time with -ldflags "-X main.version=..."
+var version = "1.0.0"
+
 func main() {
 	_ = godotenv.Load()
-	cfg := config.LoadConfig()
-	appLogger := logger.New("hermes-hooks", cfg.Environment, cfg.LogLevel)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	appLogger, logLevel := logger.NewLeveled("hermes-hooks", cfg.Environment, cfg.LogLevel)
 
 	appLogger.Info("starting Hermes Hooks",
-		slog.String("version", "1.0.0"),
+		slog.String("version", version),
 		slog.String("port", cfg.Port),
 	)
 
-	natsQueue, err := queue.NewNatsQueue(cfg.NatsUrl)
+	shutdownTracing, err := tracing.Setup(context.Background(), "hermes-hooks", version)
+	if err != nil {
+		appLogger.Error("tracing setup failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	events, err := broker.Open(cfg.BrokerConfig(), appLogger)
 	if err != nil {
-		appLogger.Error("NATS connection failed", slog.String("error", err.Error()))
+		appLogger.Error("broker connection failed", slog.String("broker", cfg.Broker), slog.String("error", err.Error()))
 		os.Exit(1)
 	}
-	appLogger.Info("connected to NATS", slog.String("url", cfg.NatsUrl))
+	producer := queue.NewProducer(events)
+	appLogger.Info("connected to broker", slog.String("broker", cfg.Broker))
 
-	handler := api.NewHandler(natsQueue, appLogger)
+	handler := api.NewHandler(producer, appLogger, api.PayloadLimits{
+		MaxPayloadBytes:  cfg.MaxPayloadBytes,
+		MaxInflightBytes: cfg.MaxInflightBytes,
+	})
+	if cfg.RecordFixturesDir != "" {
+		handler.RecordFixtures(cfg.RecordFixturesDir)
+		appLogger.Warn("fixture record mode enabled", slog.String("dir", cfg.RecordFixturesDir))
+	}
+	handler.UseMetrics(metrics.New())
+	handler.UseDeliveries(producer)
+	handler.UseDebug(cfg.DebugToken)
+	reloader := reload.New(appLogger, func() error {
+		if err := reload.Env(".env", config.Reloadable...); err != nil {
+			return err
+		}
+		next, err := config.Load()
+		if err != nil {
+			return err
+		}
+		handler.SetMaxPayload(next.MaxPayloadBytes)
+		logLevel.Set(logger.ParseLevel(next.LogLevel))
+		return nil
+	})
+	handler.UseReload(reloader)
+	go reloader.OnSignal(context.Background())
 	r := api.NewRouter(handler)
 
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
 	appLogger.Info("webhook server listening", slog.String("port", cfg.Port))
-	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
 		appLogger.Error("server failed", slog.String("error", err.Error()))
+		_ = shutdownTracing(context.Background())
 		os.Exit(1)
+	case <-sigChan:
+	}
+
+	// Providers often don't retry, so stop being picked by the load balancer
+	// before refusing connections: /health fails while webhooks are still taken
+	appLogger.Info("shutdown signal received, draining", slog.Duration("delay", cfg.DrainDelay))
+	handler.Drain()
+	srv.SetKeepAlivesEnabled(false)
+	time.Sleep(cfg.DrainDelay)
+
+	// Stops accepting and waits for in-flight webhooks, each of which returns
+	// only once its event is published
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		appLogger.Error("in-flight webhooks did not finish", slog.String("error", err.Error()))
+	}
+	cancelShutdown()
+	if err := producer.Close(); err != nil {
+		appLogger.Error("failed to flush broker", slog.String("error", err.Error()))
+	}
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		appLogger.Warn("failed to flush traces", slog.String("error", err.Error()))
 	}
+	cancelTracing()
+	appLogger.Info("Hermes Hooks stopped gracefully")
 }
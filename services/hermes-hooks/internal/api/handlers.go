@@ -2,14 +2,26 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/fixtures"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/reload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type ExecutionEvent struct {
@@ -17,40 +29,198 @@ type ExecutionEvent struct {
 	RelayID    string          `json:"relay_id"`
 	Payload    json.RawMessage `json:"payload"`
 	ReceivedAt time.Time       `json:"received_at"`
+	// W3C trace context, continued from the caller's traceparent when it sent one
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
 type EventProducer interface {
 	Publish(relayID string, event ExecutionEvent) error
 }
 
+// One webhook request as it was answered, for the delivery log hermes-core keeps
+type Delivery struct {
+	RelayID    string    `json:"relay_id"`
+	EventID    string    `json:"event_id,omitempty"`
+	SourceIP   string    `json:"source_ip"`
+	Status     int       `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	SizeBytes  int64     `json:"size_bytes"`
+	LatencyMs  float64   `json:"latency_ms"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Takes every delivery once it has been answered. It must not block
+type DeliveryRecorder interface {
+	RecordDelivery(d Delivery)
+}
+
+// Why a webhook got an error status, next to the metrics reasons
+const reasonPublishFailed = "publish_failed"
+
+// Caps on webhook bodies. Zero values fall back to the defaults below
+type PayloadLimits struct {
+	MaxPayloadBytes  int64
+	MaxInflightBytes int64
+}
+
+const (
+	defaultMaxPayloadBytes  = 512 * 1024
+	defaultMaxInflightBytes = 64 * 1024 * 1024
+)
+
 type Handler struct {
-	producer EventProducer
-	logger   *slog.Logger
+	producer   EventProducer
+	logger     *slog.Logger
+	maxPayload atomic.Int64
+	inflight   *payload.Budget
+	fixtureDir string
+	metrics    *metrics.Metrics
+	debugToken string
+	reloader   *reload.Reloader
+	draining   atomic.Bool
+	deliveries DeliveryRecorder
+}
+
+func NewHandler(p EventProducer, logger *slog.Logger, limits PayloadLimits) *Handler {
+	if limits.MaxPayloadBytes <= 0 {
+		limits.MaxPayloadBytes = defaultMaxPayloadBytes
+	}
+	if limits.MaxInflightBytes <= 0 {
+		limits.MaxInflightBytes = defaultMaxInflightBytes
+	}
+	h := &Handler{
+		producer: p,
+		logger:   logger,
+		inflight: payload.NewBudget(limits.MaxInflightBytes),
+	}
+	h.maxPayload.Store(limits.MaxPayloadBytes)
+	return h
+}
+
+// Changes the size limit for webhooks that arrive from now on. Zero or
+// less restores the default
+func (h *Handler) SetMaxPayload(maxBytes int64) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxPayloadBytes
+	}
+	h.maxPayload.Store(maxBytes)
+}
+
+// Turns on record mode: every accepted webhook is also written to dir as a
+// sanitized fixture for the replayer and test harness
+func (h *Handler) RecordFixtures(dir string) {
+	h.fixtureDir = dir
+}
+
+// Serves pprof and expvar under /debug on the router to requests bearing
+// token. Without it nothing is served under /debug
+func (h *Handler) UseDebug(token string) {
+	h.debugToken = token
+}
+
+// Serves POST /debug/reload on the router, behind the debug token
+func (h *Handler) UseReload(r *reload.Reloader) {
+	h.reloader = r
+}
+
+// Records ingestion metrics and serves them at /metrics on the router
+func (h *Handler) UseMetrics(m *metrics.Metrics) {
+	h.metrics = m
+}
+
+// Reports every webhook request, accepted or not, to rec
+func (h *Handler) UseDeliveries(rec DeliveryRecorder) {
+	h.deliveries = rec
 }
 
-func NewHandler(p EventProducer, logger *slog.Logger) *Handler {
-	return &Handler{producer: p, logger: logger}
+// Fails /health from now on so load balancers stop sending webhooks here
+// while the ones in flight finish
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
 }
 
 func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.deliveries == nil {
+		h.handleWebhook(w, r, &Delivery{})
+		return
+	}
+	d := Delivery{
+		RelayID:    chi.URLParam(r, "relayID"),
+		SourceIP:   sourceIP(r),
+		ReceivedAt: time.Now(),
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	h.handleWebhook(sw, r, &d)
+	d.Status = sw.status
+	d.LatencyMs = float64(time.Since(d.ReceivedAt).Microseconds()) / 1000
+	h.deliveries.RecordDelivery(d)
+}
+
+// Answers the webhook, filling in d's event, size and rejection reason as
+// they become known
+func (h *Handler) handleWebhook(w http.ResponseWriter, r *http.Request, d *Delivery) {
 	relayID := chi.URLParam(r, "relayID")
 	if relayID == "" {
 		h.logger.Warn("webhook request missing relay ID",
 			slog.String("path", r.URL.Path),
 		)
+		h.metrics.Rejected("", metrics.ReasonBadRequest)
+		d.Reason = metrics.ReasonBadRequest
 		http.Error(w, "Relay ID is required", http.StatusBadRequest)
 		return
 	}
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
+	logger := h.logger.With(logfields.RelayID(relayID))
+	maxPayload := h.maxPayload.Load()
+	if r.ContentLength > maxPayload {
+		h.rejectTooLarge(w, d, relayID, r.ContentLength)
+		return
+	}
+	// Reserve memory up front so a burst of max-size webhooks can't exhaust the process
+	reserve := maxPayload
+	if r.ContentLength >= 0 {
+		reserve = r.ContentLength
+	}
+	if !h.inflight.TryAcquire(reserve) {
+		payload.Metrics.Add("hooks_rejected_busy", 1)
+		h.metrics.Rejected(relayID, metrics.ReasonBusy)
+		d.Reason = metrics.ReasonBusy
+		logger.Warn("payload budget exhausted, rejecting webhook",
+			slog.Int64("in_use_bytes", h.inflight.InUse()),
+		)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server busy, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.inflight.Release(reserve)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, reserve))
 	if err != nil {
-		h.logger.Error("failed to read request body",
-			slog.String("relay_id", relayID),
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			h.rejectTooLarge(w, d, relayID, maxErr.Limit)
+			return
+		}
+		logger.Error("failed to read request body",
 			slog.String("error", err.Error()),
 		)
+		h.metrics.Rejected(relayID, metrics.ReasonReadError)
+		d.Reason = metrics.ReasonReadError
 		http.Error(w, "Failed to read body", http.StatusInternalServerError)
 		return
 	}
 	defer r.Body.Close()
+	h.metrics.Payload(len(body))
+	d.SizeBytes = int64(len(body))
 
 	eventID := r.Header.Get("X-Event-ID")
 	if eventID == "" {
@@ -59,34 +229,116 @@ func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	if eventID == "" {
 		eventID = uuid.New().String()
 	}
+	d.EventID = eventID
 
-	h.logger.Debug("webhook received",
-		slog.String("relay_id", relayID),
+	// The caller's trace is continued when it sent a traceparent
+	ctx := r.Context()
+	if parent, ok := tracing.Parse(r.Header.Get(tracing.Header)); ok {
+		ctx = tracing.WithContext(ctx, parent)
+	}
+	ctx, span := tracing.Start(ctx, "receive webhook", oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(
+			attribute.String("hermes.relay_id", relayID),
+			attribute.String("hermes.event_id", eventID),
+			attribute.Int("hermes.payload_bytes", len(body)),
+		))
+	defer span.End()
+	trace, ok := tracing.FromContext(ctx)
+	if !ok {
+		trace = tracing.New()
+	}
+	logger = logger.With(logfields.EventID(eventID), logfields.TraceID(trace.TraceID))
+
+	if h.fixtureDir != "" {
+		h.recordFixture(r, relayID, body)
+	}
+
+	logger.Debug("webhook received",
 		slog.Int("payload_size", len(body)),
 		slog.String("content_type", r.Header.Get("Content-Type")),
 	)
 
 	event := ExecutionEvent{
-		EventID:    eventID,
-		RelayID:    relayID,
-		Payload:    body,
-		ReceivedAt: time.Now(),
+		EventID:     eventID,
+		RelayID:     relayID,
+		Payload:     body,
+		ReceivedAt:  time.Now(),
+		Traceparent: trace.String(),
 	}
+	publishStart := time.Now()
 	if err := h.producer.Publish(relayID, event); err != nil {
-		h.logger.Error("failed to publish event",
-			slog.String("relay_id", relayID),
+		if errors.Is(err, payload.ErrTooLarge) {
+			h.rejectTooLarge(w, d, relayID, int64(len(body)))
+			return
+		}
+		h.metrics.Published(relayID, time.Since(publishStart), err)
+		d.Reason = reasonPublishFailed
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		logger.Error("failed to publish event",
 			slog.String("error", err.Error()),
 		)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	h.metrics.Published(relayID, time.Since(publishStart), nil)
 
-	h.logger.Info("webhook queued successfully",
-		slog.String("relay_id", relayID),
-		slog.String("event_id", eventID),
-	)
+	logger.Info("webhook queued successfully")
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(tracing.Header, trace.String())
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(fmt.Sprintf(`{"status":"queued", "event_id":"%s"}`, eventID)))
 }
+
+func (h *Handler) recordFixture(r *http.Request, relayID string, body []byte) {
+	path, err := fixtures.Capture(r, body).Save(h.fixtureDir)
+	if err != nil {
+		h.logger.Warn("failed to record fixture",
+			logfields.RelayID(relayID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	h.logger.Info("fixture recorded",
+		logfields.RelayID(relayID),
+		slog.String("path", path),
+	)
+}
+
+func (h *Handler) rejectTooLarge(w http.ResponseWriter, d *Delivery, relayID string, size int64) {
+	payload.Metrics.Add("hooks_rejected_too_large", 1)
+	h.metrics.Rejected(relayID, metrics.ReasonTooLarge)
+	d.Reason = metrics.ReasonTooLarge
+	d.SizeBytes = size
+	h.logger.Warn("webhook payload too large",
+		logfields.RelayID(relayID),
+		slog.Int64("size", size),
+		slog.Int64("max_bytes", h.maxPayload.Load()),
+	)
+	http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+}
+
+// The caller's address. Behind a proxy the router's RealIP middleware has
+// already put the forwarded client address here
+func sourceIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Remembers the status a handler answered with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
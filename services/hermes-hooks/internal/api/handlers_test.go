@@ -2,21 +2,31 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/fixtures"
 	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/reload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/metrics"
 	"github.com/go-chi/chi/v5"
 )
 
 // MockProducer satisfies the EventProducer interface
 type MockProducer struct {
-	LastRelayID string
+	LastRelayID     string
+	LastPayload     []byte
+	LastTraceparent string
 }
 
 func (m *MockProducer) Publish(zapID string, event ExecutionEvent) error {
 	m.LastRelayID = zapID
+	m.LastPayload = event.Payload
+	m.LastTraceparent = event.Traceparent
 	return nil
 }
 
@@ -24,7 +34,7 @@ func TestHandleWebhook(t *testing.T) {
 	mockQueue := &MockProducer{}
 	testLogger := logger.New("hermes-hooks-test", "test", "debug")
 
-	handler := NewHandler(mockQueue, testLogger)
+	handler := NewHandler(mockQueue, testLogger, PayloadLimits{})
 	// Router to ensure URLParams are passed correctly
 	r := chi.NewRouter()
 	r.Post("/hooks/{relayID}", handler.HandleWebhook)
@@ -45,3 +55,231 @@ func TestHandleWebhook(t *testing.T) {
 		t.Errorf("Expected RelayID 'test_zap_123', got '%s'", mockQueue.LastRelayID)
 	}
 }
+
+func TestHandleWebhookContinuesTrace(t *testing.T) {
+	mockQueue := &MockProducer{}
+	handler := NewHandler(mockQueue, logger.New("hermes-hooks-test", "test", "debug"), PayloadLimits{})
+	r := chi.NewRouter()
+	r.Post("/hooks/{relayID}", handler.HandleWebhook)
+
+	req, _ := http.NewRequest("POST", "/hooks/relay_1", bytes.NewBufferString(`{}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	sent, ok := tracing.Parse(mockQueue.LastTraceparent)
+	if !ok || sent.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the caller's trace to be continued, got %q", mockQueue.LastTraceparent)
+	}
+	if rr.Header().Get("traceparent") != mockQueue.LastTraceparent {
+		t.Error("Expected the response to echo the event's traceparent")
+	}
+
+	req, _ = http.NewRequest("POST", "/hooks/relay_1", bytes.NewBufferString(`{}`))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := tracing.Parse(mockQueue.LastTraceparent); !ok {
+		t.Errorf("Expected a new trace when none was sent, got %q", mockQueue.LastTraceparent)
+	}
+}
+
+func TestHandleWebhookRejectsOversizedPayload(t *testing.T) {
+	mockQueue := &MockProducer{}
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+
+	handler := NewHandler(mockQueue, testLogger, PayloadLimits{MaxPayloadBytes: 8})
+	r := chi.NewRouter()
+	r.Post("/hooks/{relayID}", handler.HandleWebhook)
+
+	body := []byte(`{"test":"this body is far too long"}`)
+	req, _ := http.NewRequest("POST", "/hooks/test_relay_123", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", rr.Code)
+	}
+	if mockQueue.LastRelayID != "" {
+		t.Errorf("Oversized payload should not be published, got relay '%s'", mockQueue.LastRelayID)
+	}
+}
+
+type failingProducer struct{}
+
+func (failingProducer) Publish(relayID string, event ExecutionEvent) error {
+	return errors.New("nats: timeout")
+}
+
+func TestHandleWebhookRecordsMetrics(t *testing.T) {
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+	handler := NewHandler(&MockProducer{}, testLogger, PayloadLimits{MaxPayloadBytes: 16})
+	handler.UseMetrics(metrics.New())
+	r := NewRouter(handler)
+	for _, body := range []string{`{"a":1}`, `{"a":2}`, `{"test":"this body is far too long"}`} {
+		req, _ := http.NewRequest("POST", "/hooks/relay_1", bytes.NewBufferString(body))
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	failing := NewHandler(failingProducer{}, testLogger, PayloadLimits{})
+	failing.metrics = handler.metrics
+	req, _ := http.NewRequest("POST", "/hooks/relay_2", bytes.NewBufferString(`{}`))
+	NewRouter(failing).ServeHTTP(httptest.NewRecorder(), req)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	out := rr.Body.String()
+	for _, want := range []string{
+		`hermes_hooks_events_received_total{relay_id="relay_1"} 2`,
+		`hermes_hooks_events_rejected_total{reason="too_large",relay_id="relay_1"} 1`,
+		`hermes_hooks_publish_failures_total{relay_id="relay_2"} 1`,
+		`hermes_hooks_payload_size_bytes_count 3`,
+		`hermes_hooks_publish_duration_seconds_count 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the scrape", want)
+		}
+	}
+}
+
+func TestRouterGatesDebugEndpoints(t *testing.T) {
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+	handler := NewHandler(&MockProducer{}, testLogger, PayloadLimits{})
+	rr := httptest.NewRecorder()
+	NewRouter(handler).ServeHTTP(rr, httptest.NewRequest("GET", "/debug/vars", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected no /debug/vars without a debug token, got %d", rr.Code)
+	}
+
+	handler.UseDebug("s3cret")
+	r := NewRouter(handler)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for pprof without the token, got %d", rr.Code)
+	}
+	req := httptest.NewRequest("GET", "/debug/pprof/heap?debug=1", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the heap profile with the token, got %d", rr.Code)
+	}
+}
+
+func TestDrainFailsHealthButTakesWebhooks(t *testing.T) {
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+	mockQueue := &MockProducer{}
+	handler := NewHandler(mockQueue, testLogger, PayloadLimits{})
+	r := NewRouter(handler)
+	handler.Drain()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 from /health while draining, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/hooks/relay_1", strings.NewReader(`{"a":1}`)))
+	if rr.Code != http.StatusOK || mockQueue.LastRelayID != "relay_1" {
+		t.Errorf("Expected webhooks to be published while draining, got %d", rr.Code)
+	}
+}
+
+type deliveryLog []Delivery
+
+func (l *deliveryLog) RecordDelivery(d Delivery) {
+	*l = append(*l, d)
+}
+
+func TestHandleWebhookRecordsDeliveries(t *testing.T) {
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+	handler := NewHandler(&MockProducer{}, testLogger, PayloadLimits{MaxPayloadBytes: 16})
+	log := &deliveryLog{}
+	handler.UseDeliveries(log)
+	r := NewRouter(handler)
+
+	req := httptest.NewRequest("POST", "/hooks/relay_1", strings.NewReader(`{"a":1}`))
+	req.Header.Set("X-Event-ID", "evt_1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hooks/relay_1",
+		strings.NewReader(`{"test":"this body is far too long"}`)))
+	failing := NewHandler(failingProducer{}, testLogger, PayloadLimits{})
+	failing.UseDeliveries(log)
+	NewRouter(failing).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hooks/relay_2", strings.NewReader(`{}`)))
+
+	if len(*log) != 3 {
+		t.Fatalf("Expected 3 deliveries, got %d", len(*log))
+	}
+	accepted, tooLarge, failed := (*log)[0], (*log)[1], (*log)[2]
+	if accepted.Status != http.StatusOK || accepted.Reason != "" || accepted.EventID != "evt_1" ||
+		accepted.SourceIP != "203.0.113.7" || accepted.SizeBytes != 7 {
+		t.Errorf("Expected the accepted webhook recorded as is, got %+v", accepted)
+	}
+	if tooLarge.Status != http.StatusRequestEntityTooLarge || tooLarge.Reason != metrics.ReasonTooLarge || tooLarge.RelayID != "relay_1" {
+		t.Errorf("Expected a too_large rejection, got %+v", tooLarge)
+	}
+	if failed.Status != http.StatusInternalServerError || failed.Reason != reasonPublishFailed {
+		t.Errorf("Expected a publish failure, got %+v", failed)
+	}
+}
+
+// Every fixture in the recorded corpus must be accepted and forwarded untouched
+func TestReloadChangesPayloadLimit(t *testing.T) {
+	testLogger := logger.New("hermes-hooks-test", "test", "debug")
+	handler := NewHandler(&MockProducer{}, testLogger, PayloadLimits{MaxPayloadBytes: 8})
+	handler.UseDebug("s3cret")
+	handler.UseReload(reload.New(testLogger, func() error {
+		handler.SetMaxPayload(1024)
+		return nil
+	}))
+	r := NewRouter(handler)
+	send := func() int {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/hooks/relay_1", strings.NewReader(`{"test":"longer than eight bytes"}`)))
+		return rr.Code
+	}
+	if code := send(); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 before the reload, got %d", code)
+	}
+
+	req := httptest.NewRequest("POST", "/debug/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 from the reload, got %d", rr.Code)
+	}
+	if code := send(); code != http.StatusOK {
+		t.Errorf("Expected the raised limit to apply, got %d", code)
+	}
+}
+
+func TestHandleWebhookReplaysFixtureCorpus(t *testing.T) {
+	corpus, err := fixtures.LoadDir("../../testdata/fixtures")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	if len(corpus) == 0 {
+		t.Fatal("Expected at least one fixture in the corpus")
+	}
+
+	mockQueue := &MockProducer{}
+	handler := NewHandler(mockQueue, logger.New("hermes-hooks-test", "test", "debug"), PayloadLimits{})
+	r := chi.NewRouter()
+	r.Post("/hooks/{relayID}", handler.HandleWebhook)
+
+	for _, f := range corpus {
+		req, err := f.Request("/hooks/fixture_relay")
+		if err != nil {
+			t.Fatalf("%s/%s: failed to build request: %v", f.Provider, f.Name, err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s/%s: expected 200, got %d", f.Provider, f.Name, rr.Code)
+		}
+		if !bytes.Equal(mockQueue.LastPayload, f.BodyBytes()) {
+			t.Errorf("%s/%s: payload was altered in transit", f.Provider, f.Name)
+		}
+	}
+}
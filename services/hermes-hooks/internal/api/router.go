@@ -1,8 +1,7 @@
 package api
 
 import (
-	"net/http"
-
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/profiling"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -13,12 +12,19 @@ func NewRouter(h *Handler) *chi.Mux {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
 
 	r.Post("/hooks/{relayID}", h.HandleWebhook)
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	r.Get("/health", h.Health)
+	if h.debugToken != "" {
+		r.Handle("/debug/*", profiling.Handler(h.debugToken))
+		if h.reloader != nil {
+			r.Handle("/debug/reload", h.reloader.Handler(h.debugToken))
+		}
+	}
+	if h.metrics != nil {
+		r.Handle("/metrics", h.metrics.Handler())
+	}
 	return r
 }
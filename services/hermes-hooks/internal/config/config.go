@@ -1,35 +1,88 @@
 package config
 
-import "os"
+import (
+	"strconv"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/settings"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
 
 type Config struct {
-	Port        string
-	NatsUrl     string
-	Environment string
-	LogLevel    string
+	Port    string
+	NatsUrl string
+	// The broker events are published to, NATS at NatsUrl unless set
+	Broker           string
+	BrokerURL        string
+	Environment      string
+	LogLevel         string
+	MaxPayloadBytes  int64
+	MaxInflightBytes int64
+	// When set, incoming webhooks are also saved here as sanitized fixtures
+	RecordFixturesDir string
+	// Bearer token for pprof and expvar under /debug. They aren't served when empty
+	DebugToken string
+	// On shutdown /health fails for DrainDelay while webhooks are still
+	// accepted, then in-flight ones get up to ShutdownGrace to be published
+	DrainDelay    time.Duration
+	ShutdownGrace time.Duration
+	// Credentials SQS requests are signed with
+	AWS sigv4.Credentials
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
+// Settings a running hooks server applies again, read from .env and
+// CONFIG_FILE, on SIGHUP or a POST to /debug/reload. The rest need a restart
+var Reloadable = []string{"LOG_LEVEL", "MAX_PAYLOAD_BYTES"}
 
-// Loads and Validates env variables
-func LoadConfig() *Config {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// Reads the config from the environment and the YAML file named by
+// CONFIG_FILE, reporting every missing or invalid setting at once
+func Load() (*Config, error) {
+	src, err := settings.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Port:              src.String("PORT", "8080"),
+		NatsUrl:           src.String("NATS_URL", "nats://localhost:4222"),
+		Broker:            src.OneOf("BROKER", broker.KindNATS, broker.Kinds...),
+		BrokerURL:         src.String("BROKER_URL", ""),
+		Environment:       src.OneOf("ENV", "development", settings.Environments...),
+		LogLevel:          src.OneOf("LOG_LEVEL", "INFO", settings.LogLevels...),
+		MaxPayloadBytes:   src.Int64("MAX_PAYLOAD_BYTES", 512*1024),
+		MaxInflightBytes:  src.Int64("MAX_INFLIGHT_PAYLOAD_BYTES", 64*1024*1024),
+		RecordFixturesDir: src.String("RECORD_FIXTURES_DIR", ""),
+		DebugToken:        src.String("DEBUG_TOKEN", ""),
+		DrainDelay:        src.Duration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		ShutdownGrace:     src.Duration("SHUTDOWN_GRACE_PERIOD", 25*time.Second),
+		AWS: sigv4.Credentials{
+			AccessKeyID:     src.String("AWS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: src.String("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    src.String("AWS_SESSION_TOKEN", ""),
+		},
 	}
-	natsUrl := os.Getenv("NATS_URL")
-	if natsUrl == "" {
-		natsUrl = "nats://localhost:4222"
+	if _, err := strconv.Atoi(cfg.Port); err != nil {
+		src.Failf("PORT must be a valid number")
 	}
-	return &Config{
-		Port:        port,
-		NatsUrl:     natsUrl,
-		Environment: getEnv("ENV", "development"),
-		LogLevel:    getEnv("LOG_LEVEL", "INFO"),
+	src.Check(cfg.MaxPayloadBytes > 0, "MAX_PAYLOAD_BYTES must be positive")
+	src.Check(cfg.MaxInflightBytes >= cfg.MaxPayloadBytes,
+		"MAX_INFLIGHT_PAYLOAD_BYTES must be at least MAX_PAYLOAD_BYTES")
+	if err := cfg.BrokerConfig().Validate(); err != nil {
+		src.Failf("BROKER settings are invalid: %v", err)
+	}
+	src.Check(cfg.DrainDelay >= 0, "SHUTDOWN_DRAIN_DELAY must not be negative")
+	src.Check(cfg.ShutdownGrace > 0, "SHUTDOWN_GRACE_PERIOD must be positive")
+	if err := src.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// BROKER_URL, or NATS_URL when the broker is NATS and BROKER_URL isn't set
+func (c *Config) BrokerConfig() broker.Config {
+	url := c.BrokerURL
+	if url == "" && c.Broker == broker.KindNATS {
+		url = c.NatsUrl
 	}
+	return broker.Config{Kind: c.Broker, URL: url, AWS: c.AWS}
 }
@@ -0,0 +1,150 @@
+// Package metrics exposes the ingestion Prometheus metrics: events received
+// and rejected per relay, broker publish failures and latency, payload sizes
+// and the shared payload counters
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The expvar map payload.Metrics is published under
+const payloadVar = "hermes_payload"
+
+const namespace = "hermes_hooks"
+
+// Relay IDs come straight from the URL, so only this many distinct ones get
+// their own series. Later ones are counted under otherRelay
+const maxRelays = 1000
+
+const (
+	otherRelay = "other"
+	// Longer IDs can't be real relays and aren't worth a series
+	maxRelayIDLen = 64
+)
+
+// Why a webhook was turned away
+const (
+	ReasonTooLarge   = "too_large"
+	ReasonBusy       = "busy"
+	ReasonBadRequest = "bad_request"
+	ReasonReadError  = "read_error"
+)
+
+// A nil *Metrics records nothing
+type Metrics struct {
+	registry      *prometheus.Registry
+	received      *prometheus.CounterVec
+	rejected      *prometheus.CounterVec
+	publishFailed *prometheus.CounterVec
+	publishTime   prometheus.Histogram
+	payloadBytes  prometheus.Histogram
+
+	mu     sync.Mutex
+	relays map[string]struct{}
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_received_total",
+			Help:      "Webhooks accepted and queued, by relay.",
+		}, []string{"relay_id"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_rejected_total",
+			Help:      "Webhooks turned away before reaching the broker, by relay and reason.",
+		}, []string{"relay_id", "reason"}),
+		publishFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "publish_failures_total",
+			Help:      "Webhooks the broker didn't accept, by relay.",
+		}, []string{"relay_id"}),
+		publishTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken to publish an event to the broker.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2.5, 10),
+		}),
+		payloadBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "payload_size_bytes",
+			Help:      "Size of webhook bodies that were read in full.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}),
+		relays: map[string]struct{}{},
+	}
+	m.registry.MustRegister(
+		m.received,
+		m.rejected,
+		m.publishFailed,
+		m.publishTime,
+		m.payloadBytes,
+		// payload.Metrics, one series per counter, so they're served here
+		// rather than through expvar on the public listener
+		collectors.NewExpvarCollector(map[string]*prometheus.Desc{
+			payloadVar: prometheus.NewDesc(namespace+"_payload_events", "Truncation and rejection counters from the payload package, by counter.", []string{"counter"}, nil),
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Records a webhook body that was read, before it is published
+func (m *Metrics) Payload(size int) {
+	if m == nil {
+		return
+	}
+	m.payloadBytes.Observe(float64(size))
+}
+
+// Records a publish attempt and whether the broker took the event
+func (m *Metrics) Published(relayID string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.publishTime.Observe(duration.Seconds())
+	if err != nil {
+		m.publishFailed.WithLabelValues(m.relayLabel(relayID)).Inc()
+		return
+	}
+	m.received.WithLabelValues(m.relayLabel(relayID)).Inc()
+}
+
+func (m *Metrics) Rejected(relayID, reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(m.relayLabel(relayID), reason).Inc()
+}
+
+// The relay_id label for an ID, which stays the same for the life of the
+// process once it has been handed out
+func (m *Metrics) relayLabel(relayID string) string {
+	if relayID == "" || len(relayID) > maxRelayIDLen {
+		return otherRelay
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.relays[relayID]; ok {
+		return relayID
+	}
+	if len(m.relays) >= maxRelays {
+		return otherRelay
+	}
+	m.relays[relayID] = struct{}{}
+	return relayID
+}
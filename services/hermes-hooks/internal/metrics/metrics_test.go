@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+)
+
+func TestCapsRelayLabels(t *testing.T) {
+	m := New()
+	for i := range maxRelays {
+		m.relayLabel(fmt.Sprintf("relay_%d", i))
+	}
+	if got := m.relayLabel("relay_0"); got != "relay_0" {
+		t.Errorf("Expected a known relay to keep its label, got %q", got)
+	}
+	if got := m.relayLabel("relay_new"); got != otherRelay {
+		t.Errorf("Expected relays past the cap to be counted as %q, got %q", otherRelay, got)
+	}
+	if got := New().relayLabel(strings.Repeat("x", maxRelayIDLen+1)); got != otherRelay {
+		t.Errorf("Expected an overlong ID to be counted as %q, got %q", otherRelay, got)
+	}
+}
+
+func TestServesPayloadCounters(t *testing.T) {
+	payload.Metrics.Add("hooks_rejected_too_large", 1)
+	rec := httptest.NewRecorder()
+	New().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `hermes_hooks_payload_events{counter="hooks_rejected_too_large"}`) {
+		t.Errorf("Expected the payload counters on /metrics, got:\n%s", body)
+	}
+}
@@ -1,51 +1,95 @@
 package queue
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"sync"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
 	"github.com/eulerbutcooler/hermes/services/hermes-hooks/internal/api"
+)
+
+// Publishes webhook events onto the broker BROKER selects
+type Producer struct {
+	broker broker.Broker
+	// Deliveries waiting to be reported, sent one at a time so a slow broker
+	// can't hold up webhook responses
+	deliveries chan []byte
+	sent       chan struct{}
+}
+
+// Reused encode buffers so each publish doesn't allocate a fresh copy of the payload
+var bufPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
 
-	"github.com/nats-io/nats.go"
+var (
+	_ api.EventProducer    = (*Producer)(nil)
+	_ api.DeliveryRecorder = (*Producer)(nil)
 )
 
-type NatsQueue struct {
-	js nats.JetStreamContext
+const (
+	// Subject hermes-core records the delivery log from. Outside the event stream
+	deliverySubject = "hermes.deliveries"
+	// Deliveries reported past this backlog are dropped
+	deliveryBacklog = 1024
+	publishTimeout  = 10 * time.Second
+)
+
+func NewProducer(b broker.Broker) *Producer {
+	p := &Producer{broker: b, deliveries: make(chan []byte, deliveryBacklog), sent: make(chan struct{})}
+	go p.sendDeliveries()
+	return p
 }
 
-var _ api.EventProducer = (*NatsQueue)(nil)
+func (p *Producer) Publish(relayID string, event api.ExecutionEvent) error {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
 
-func NewNatsQueue(url string) (*NatsQueue, error) {
-	nc, err := nats.Connect(url)
-	if err != nil {
-		return nil, fmt.Errorf("nats connect error: %w", err)
-	}
-	js, err := nc.JetStream()
-	if err != nil {
-		return nil, fmt.Errorf("jetsream init error: %w", err)
+	if err := json.NewEncoder(buf).Encode(event); err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
 	}
-	streamName := "EVENTS"
-	_, err = js.AddStream(&nats.StreamConfig{
-		Name:     streamName,
-		Subjects: []string{"events.*"},
-	})
-	if err != nil {
-		log.Printf("Stream %s might already exist: %v", streamName, err)
+	if max := p.broker.MaxPayload(); int64(buf.Len()) > max {
+		return fmt.Errorf("encoded event is %d bytes, broker max is %d: %w", buf.Len(), max, payload.ErrTooLarge)
 	}
-	return &NatsQueue{js: js}, nil
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	// Brokers that deduplicate drop a second publish with the same ID, so a
+	// sender retrying with the same X-Event-ID queues the event once
+	return p.broker.Publish(ctx, relayID, relayID+"/"+event.EventID, event.Traceparent, buf.Bytes())
 }
 
-func (q *NatsQueue) Publish(relayID string, event api.ExecutionEvent) error {
-	data, err := json.Marshal(event)
+// Reports a delivery without waiting for it to be sent. Ones that can't be
+// sent are dropped, the log being best effort
+func (p *Producer) RecordDelivery(d api.Delivery) {
+	data, err := json.Marshal(d)
 	if err != nil {
-		return fmt.Errorf("json marshal error: %w", err)
+		return
+	}
+	select {
+	case p.deliveries <- data:
+	default:
 	}
+}
 
-	subject := fmt.Sprintf("events.%s", relayID)
-	_, err = q.js.Publish(subject, data)
-	if err != nil {
-		return fmt.Errorf("nats publish error: %w", err)
+func (p *Producer) sendDeliveries() {
+	defer close(p.sent)
+	for data := range p.deliveries {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		_ = p.broker.Broadcast(ctx, deliverySubject, data)
+		cancel()
 	}
-	return nil
+}
+
+// Sends the deliveries still queued, then flushes and closes the broker.
+// Call once no more webhooks are being answered
+func (p *Producer) Close() error {
+	close(p.deliveries)
+	<-p.sent
+	return p.broker.Close()
 }
@@ -2,77 +2,373 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
 	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/migrate"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/profiling"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/reload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/config"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/airtable"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/amqp"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/aws"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/bridge"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/ci"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/debug"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/discord"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/email"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/file"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/github"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/gitlab"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/grafana"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/homeassistant"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/jenkins"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/jira"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/kafka"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/linear"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/mattermost"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/mysql"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/notion"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/postgres"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/push"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/pushgateway"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/redis"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/remote"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/rocketchat"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/salesforce"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/servicenow"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/shopify"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/slack"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/ssh"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/stripe"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/twilio"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/webex"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/whatsapp"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/plugins"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/queue"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
 	"github.com/joho/godotenv"
 )
 
+// Stamped at release time with -ldflags "-X main.version=..."
+var version = "1.0.0"
+
 func main() {
 	_ = godotenv.Load()
-	cfg := config.LoadConfig()
-	if err := cfg.Validate(); err != nil {
+	cfg, err := config.Load()
+	if err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	appLogger := logger.New("hermes-worker", cfg.Environment, cfg.LogLevel)
+	appLogger, logLevel := logger.NewLeveled("hermes-worker", cfg.Environment, cfg.LogLevel)
 	appLogger.Info("starting Hermes Worker",
-		slog.String("version", "1.0.0"),
+		slog.String("version", version),
 		slog.String("environment", cfg.Environment),
 	)
 
-	db, err := store.NewStore(cfg.DbURL)
+	db, err := store.NewStore(cfg.DbURL, cfg.DbReplicaURL, cfg.DBPool, appLogger)
 	if err != nil {
 		appLogger.Error("database initialization failed", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 	appLogger.Info("database connected")
 
+	migrator, err := migrate.New(db.Pool(), appLogger)
+	if err != nil {
+		appLogger.Error("invalid migrations", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// hermes-worker migrate [up | down [steps] | version | force <version>]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate.Command(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+			appLogger.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.MigrateOnStart {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			appLogger.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		appLogger.Info("schema up to date", slog.Int("applied", applied))
+	}
+
 	//Registry Pattern
 	// Registering integrations instead of hardcoding
+	allowedCIDRs, _ := cfg.OutboundCIDRs()
+	egress := &engine.Egress{
+		AllowPrivate: cfg.OutboundAllowPrivate,
+		AllowedCIDRs: allowedCIDRs,
+		AllowedHosts: cfg.OutboundHosts(),
+	}
+	if egress.AllowPrivate {
+		appLogger.Warn("outbound requests may reach private addresses")
+	}
 	reg := engine.NewRegistry()
 	reg.Register("debug_log", debug.New())
-	reg.Register("discord_send", discord.New())
-	reg.Register("slack_send", slack.New())
+	reg.Register("noop", debug.NewNoop())
+	discordSender := discord.New(egress)
+	reg.Register("discord", discordSender)
+	// Older relays use this name
+	reg.Register("discord_send", discordSender)
+	reg.Register("slack_send", slack.New(egress))
+	reg.Register("mattermost", mattermost.New(egress))
+	reg.Register("rocketchat", rocketchat.New(egress))
+	reg.Register("webex", webex.New(egress))
+	reg.Register("sendgrid_email", email.NewSendGrid(egress))
+	reg.Register("ses_email", email.NewSES(egress))
+	reg.Register("twilio_sms", twilio.New(egress))
+	reg.Register("whatsapp", whatsapp.New(egress))
+	reg.Register("ntfy", push.NewNtfy(egress))
+	reg.Register("pushover", push.NewPushover(egress))
+	reg.Register("github", github.New(egress))
+	reg.Register("gitlab", gitlab.New(egress))
+	reg.Register("jenkins", jenkins.New(egress))
+	reg.Register("circleci", ci.NewCircleCI(egress))
+	reg.Register("buildkite", ci.NewBuildkite(egress))
+	reg.Register("jira", jira.New(egress))
+	reg.Register("linear", linear.New(egress))
+	reg.Register("servicenow", servicenow.New(egress))
+	reg.Register("notion", notion.New(egress))
+	reg.Register("airtable", airtable.New(egress))
+	reg.Register("salesforce", salesforce.New(egress))
+	reg.Register("stripe", stripe.New(egress))
+	reg.Register("shopify", shopify.New(egress))
+	reg.Register("homeassistant", homeassistant.New(egress))
+	reg.Register("webhook_bridge", bridge.New(egress))
+	postgresInserter := postgres.New(egress)
+	defer postgresInserter.Close()
+	reg.Register("postgres", postgresInserter)
+	mysqlInserter := mysql.New(egress)
+	defer mysqlInserter.Close()
+	reg.Register("mysql", mysqlInserter)
+	redisClient := redis.New(egress)
+	defer redisClient.Close()
+	reg.Register("redis", redisClient)
+	kafkaProducer := kafka.New(egress)
+	defer kafkaProducer.Close()
+	reg.Register("kafka", kafkaProducer)
+	amqpPublisher := amqp.New(egress)
+	defer amqpPublisher.Close()
+	reg.Register("amqp", amqpPublisher)
+	reg.Register("sqs", aws.NewSQS(egress))
+	reg.Register("sns", aws.NewSNS(egress))
+	reg.Register("grafana", grafana.New(egress))
+	reg.Register("pushgateway", pushgateway.New(egress))
+	reg.Register("file", file.New(cfg.FileActionRoot))
+	reg.Register("ssh", ssh.New(egress, cfg.SSHActionEnabled, cfg.SSHCommands()))
+	if cfg.SSHActionEnabled {
+		appLogger.Warn("ssh action enabled", slog.Any("commands", cfg.SSHCommands()))
+	}
+	types := reg.Types()
 	appLogger.Info("integrations loaded",
-		slog.Int("count", 3),
-		slog.Any("types", []string{"debug_log", "discord_send", "slack_send"}),
+		slog.Int("count", len(types)),
+		slog.Any("types", types),
 	)
 
-	pool := engine.NewWorkerPool(10, db, reg, appLogger)
 	ctx, cancel := context.WithCancel(context.Background())
-	pool.Start(ctx)
+	shutdownTracing, err := tracing.Setup(ctx, "hermes-worker", version)
+	if err != nil {
+		appLogger.Error("tracing setup failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	externals, _ := cfg.ExternalExecutorAddrs()
+	for actionType, addr := range externals {
+		exec, err := remote.New(actionType, addr, cfg.ExternalExecutorTimeout, cfg.ExternalHealthInterval, appLogger)
+		if err != nil {
+			appLogger.Error("external executor setup failed", slog.String("action_type", actionType),
+				slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer exec.Close()
+		go exec.Watch(ctx)
+		reg.Register(actionType, exec)
+		appLogger.Info("external executor registered", slog.String("action_type", actionType),
+			slog.String("addr", addr))
+	}
+	// Uploaded WASM plugins register as "plugin:<name>" and are kept in sync while running
+	pluginManager := plugins.NewManager(db, reg, appLogger)
+	if err := pluginManager.Sync(ctx); err != nil {
+		appLogger.Warn("initial plugin sync failed", slog.String("error", err.Error()))
+	}
+	if cfg.PluginSyncInterval > 0 {
+		go pluginManager.Run(ctx, cfg.PluginSyncInterval)
+	}
 
-	consumer, err := queue.NewConsumer(cfg.NatsURL, pool.JobQueue, appLogger)
+	pool := engine.NewWorkerPool(cfg.MaxWorkers, db, reg, appLogger)
+	pool.MinWorkers = cfg.MinWorkers
+	pool.ScaleInterval = cfg.ScaleInterval
+	pool.LogPayloadMaxBytes = cfg.LogPayloadMaxBytes
+	if cfg.LogFlushInterval > 0 {
+		pool.Logs = engine.NewLogWriter(db.WriteExecutionLogs, cfg.LogBufferSize, cfg.LogBatchSize, cfg.LogFlushInterval, appLogger)
+	}
+	pool.AgentJobTimeout = cfg.AgentJobTimeout
+	pool.DedupeWindow = cfg.DedupeWindow
+	pool.DedupeCleanupInterval = cfg.DedupeCleanupInterval
+	if cfg.RetryBaseDelay > 0 {
+		pool.Retries = &engine.RetryPolicy{BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay}
+	}
+	if cfg.SecretsKey != "" || cfg.SecretsKMSKeyID != "" {
+		pool.Secrets, _ = secrets.Load(cfg.SecretKeys())
+		// Payloads sealed earlier still open with PAYLOAD_ENCRYPTION off
+		db.UsePayloadKeys(pool.Secrets, cfg.PayloadEncryption)
+	}
+	pool.SecretBackends, _ = secrets.LoadBackends(cfg.SecretBackends())
+	if cfg.BreakerThreshold > 0 {
+		pool.Breakers = engine.NewBreakerSet(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	if cfg.TenantQuotasEnabled {
+		pool.Quotas = engine.NewTenantQuotas(db.AdmitTenant, db.ReleaseTenantSlot, store.TenantLimits{
+			MaxConcurrency:         cfg.TenantMaxConcurrency,
+			MaxExecutionsPerMinute: cfg.TenantMaxExecutionsPerMinute,
+		}, cfg.TenantSlotLease, appLogger)
+	}
+	if cfg.ChaosEnabled {
+		pool.Chaos = &engine.Chaos{
+			FailurePercent:   cfg.ChaosFailurePercent,
+			LatencyPercent:   cfg.ChaosLatencyPercent,
+			MaxLatency:       cfg.ChaosMaxLatency,
+			RedeliverPercent: cfg.ChaosRedeliverPercent,
+			Relays:           cfg.ChaosRelays,
+		}
+		appLogger.Warn("chaos mode enabled, failures will be injected",
+			slog.Int("failure_percent", cfg.ChaosFailurePercent),
+			slog.Int("latency_percent", cfg.ChaosLatencyPercent),
+			slog.Duration("max_latency", cfg.ChaosMaxLatency),
+			slog.Int("redeliver_percent", cfg.ChaosRedeliverPercent),
+			slog.Any("relays", cfg.ChaosRelays))
+	}
+
+	inflight := payload.NewBudget(cfg.MaxInflightBytes)
+	settings := engine.NewSettingsResolver(db.GetRelaySettings, cfg.RelayCacheTTL, appLogger)
+	pool.Settings = settings
+	// Redaction rules are added through the API and take effect within the same TTL
+	pool.Redaction = engine.NewRedactionResolver(db.RedactionRules, cfg.RelayCacheTTL)
+	pool.ShutdownGrace = cfg.ShutdownGrace
+	// Heartbeats let operators see the fleet in GET /api/v1/admin/workers
+	if cfg.HeartbeatInterval > 0 {
+		hostname, _ := os.Hostname()
+		pool.InstanceID = cfg.WorkerID
+		if pool.InstanceID == "" {
+			pool.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		pool.Hostname = hostname
+		pool.Version = version
+		pool.HeartbeatInterval = cfg.HeartbeatInterval
+	}
+	if cfg.MetricsAddr != "" {
+		pool.Metrics = engine.NewMetrics(pool)
+		pool.Metrics.RegisterDBPool(db.Pool())
+	}
+	events, err := broker.Open(cfg.BrokerConfig(), appLogger)
 	if err != nil {
-		appLogger.Error("NATS consumer creation failed", slog.String("error", err.Error()))
+		appLogger.Error("broker connection failed", slog.String("broker", cfg.Broker), slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	appLogger.Info("connected to broker", slog.String("broker", cfg.Broker))
+	consumer := queue.NewConsumer(events, pool.Queues, settings, cfg.MaxPayloadBytes, cfg.MaxDeliver, inflight, appLogger)
+	consumer.DeadLetters = db.SaveDeadLetter
+	consumer.PauseAtPercent = cfg.QueuePausePercent
+	consumer.ResumeAtPercent = cfg.QueueResumePercent
+	consumer.Metrics = pool.Metrics
 	if err := consumer.Start(); err != nil {
 		appLogger.Error("failed to start consumer", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	if err := consumer.SubscribeCancellations(pool.CancelExecution); err != nil {
+		appLogger.Error("failed to subscribe to cancellations", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	reloader := reload.New(appLogger, func() error {
+		if err := reload.Env(".env", config.Reloadable...); err != nil {
+			return err
+		}
+		next, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if err := pool.Resize(next.MinWorkers, next.MaxWorkers); err != nil {
+			return err
+		}
+		logLevel.Set(logger.ParseLevel(next.LogLevel))
+		return nil
+	})
+	pool.Lookups = egress.Client(time.Minute)
+	pool.Backlog = consumer.Pending
+	pool.Republisher = consumer
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", pool.Metrics.Handler())
+		mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+			health := map[string]any{"status": "healthy", "service": "hermes-worker"}
+			if status, err := migrator.Status(r.Context()); err != nil {
+				health["schema"] = "unknown"
+			} else {
+				health["schema"] = status
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(health)
+		})
+		if cfg.DebugToken != "" {
+			mux.Handle("/debug/", profiling.Handler(cfg.DebugToken))
+			mux.Handle("/debug/reload", reloader.Handler(cfg.DebugToken))
+		}
+		metricsServer = &http.Server{Addr: cfg.MetricsAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				appLogger.Error("metrics endpoint failed", slog.String("addr", cfg.MetricsAddr),
+					slog.String("error", err.Error()))
+			}
+		}()
+		appLogger.Info("metrics endpoint listening", slog.String("addr", cfg.MetricsAddr))
+	}
+	pool.Start(ctx)
+	go reloader.OnSignal(ctx)
 	appLogger.Info("Hermes Worker is running", slog.String("status", "ready"))
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	appLogger.Info("shutdown signal received, initiating graceful shutdown")
+	// Stop consuming first so the pool drains a fixed set of jobs
 	if err := consumer.Stop(); err != nil {
 		appLogger.Error("error stopping consumer", slog.String("error", err.Error()))
 	}
-	cancel()
 	pool.Shutdown()
+	if err := consumer.Close(); err != nil {
+		appLogger.Error("error closing broker connection", slog.String("error", err.Error()))
+	}
+	if pool.Logs != nil {
+		pool.Logs.Close()
+	}
+	if metricsServer != nil {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = metricsServer.Shutdown(shutdownCtx)
+		cancelShutdown()
+	}
+	// Flushes the spans of the jobs that just finished
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		appLogger.Warn("failed to flush traces", slog.String("error", err.Error()))
+	}
+	cancelTracing()
+	cancel()
 	appLogger.Info("Worker stoppped gracefully")
 }
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/settings"
+)
+
+func TestLoadReadsConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worker.yaml")
+	body := "max_workers: 20\nmin_workers: 4\nssh_allowed_commands: [uptime, df -h]\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(settings.FileEnv, path)
+	t.Setenv("MIN_WORKERS", "")
+	t.Setenv("SSH_ALLOWED_COMMANDS", "")
+	t.Setenv("MAX_WORKERS", "16")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.MaxWorkers != 16 || cfg.MinWorkers != 4 {
+		t.Errorf("Expected MAX_WORKERS from the env and MIN_WORKERS from the file, got %d and %d", cfg.MaxWorkers, cfg.MinWorkers)
+	}
+	if got := cfg.SSHCommands(); len(got) != 2 || got[1] != "df -h" {
+		t.Errorf("Expected the file's commands, got %v", got)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv(settings.FileEnv, "")
+	t.Setenv("MAX_WORKERS", "lots")
+	t.Setenv("MIN_WORKERS", "50")
+	t.Setenv("RETRY_BASE_DELAY", "5")
+	t.Setenv("OUTBOUND_ALLOWED_CIDRS", "10.0.0.0/33")
+
+	_, err := Load()
+	var cfgErr *settings.Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected a *settings.Error, got %v", err)
+	}
+	want := []string{
+		`MAX_WORKERS must be a whole number, got "lots"`,
+		`RETRY_BASE_DELAY must be a duration such as 30s or 5m, got "5"`,
+		"MIN_WORKERS must be between 1 and MAX_WORKERS",
+		`OUTBOUND_ALLOWED_CIDRS entry "10.0.0.0/33" is not a CIDR`,
+	}
+	if len(cfgErr.Problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), cfgErr.Problems)
+	}
+	for i, problem := range want {
+		if cfgErr.Problems[i] != problem {
+			t.Errorf("Expected %q, got %q", problem, cfgErr.Problems[i])
+		}
+	}
+}
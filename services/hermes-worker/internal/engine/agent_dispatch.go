@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+const (
+	defaultAgentJobTimeout = 60 * time.Second
+	agentResultPollEvery   = 500 * time.Millisecond
+	agentTouchEvery        = 10 * time.Second
+)
+
+var ErrAgentTimeout = errors.New("agent did not complete the job in time")
+
+// Hands an action to the agent group it targets and waits for the agent's report,
+// returning the output it sent back
+func (wp *WorkerPool) dispatchToAgent(ctx context.Context, job Job, act store.RelayAction, logger *slog.Logger) (string, error) {
+	timeout := wp.AgentJobTimeout
+	if timeout <= 0 {
+		timeout = defaultAgentJobTimeout
+	}
+	key, _ := idempotency.FromContext(ctx)
+	jobID, err := wp.Store.CreateAgentJob(ctx, job.RelayID, job.EventID, stepTraceparent(ctx, job), key.Key, act, job.Payload, time.Now().Add(timeout))
+	if err != nil {
+		return "", err
+	}
+	logger.Debug("action dispatched to agent group",
+		slog.String("agent_group", act.AgentGroup),
+		slog.String("agent_job_id", jobID))
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(agentResultPollEvery)
+	defer ticker.Stop()
+	lastTouch := time.Now()
+
+	for {
+		select {
+		case <-waitCtx.Done():
+			expireCtx, expireCancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer expireCancel()
+			if expErr := wp.Store.ExpireAgentJob(expireCtx, jobID); expErr != nil {
+				logger.Error("failed to expire agent job", slog.String("agent_job_id", jobID),
+					slog.String("error", expErr.Error()))
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("agent group %s: %w", act.AgentGroup, ErrAgentTimeout)
+		case <-ticker.C:
+			if job.Touch != nil && time.Since(lastTouch) >= agentTouchEvery {
+				job.Touch()
+				lastTouch = time.Now()
+			}
+			res, err := wp.Store.GetAgentJobResult(waitCtx, jobID)
+			if err != nil {
+				if waitCtx.Err() != nil {
+					continue
+				}
+				return "", err
+			}
+			switch res.Status {
+			case "succeeded":
+				return res.Output, nil
+			case "failed":
+				return res.Output, fmt.Errorf("agent reported failure: %s", res.ErrorMessage)
+			}
+		}
+	}
+}
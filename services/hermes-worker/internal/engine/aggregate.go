@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Action type that collects events and runs the actions after it once per batch
+const ActionAggregate = "aggregate"
+
+const (
+	defaultAggregateWindow    = 60 * time.Second
+	defaultAggregateMaxEvents = 100
+	// Matches the limit hermes-core validates, for configs saved before it did
+	maxAggregateEvents = 1000
+)
+
+// Payload downstream actions receive for a batch
+type AggregatePayload struct {
+	Aggregate bool              `json:"aggregate"`
+	Count     int               `json:"count"`
+	EventIDs  []string          `json:"event_ids"`
+	Events    []json.RawMessage `json:"events"`
+}
+
+// Reads {"window": "5m", "max_events": 50}. A batch flushes at whichever comes first
+func aggregateConfig(config map[string]any) (int, time.Duration) {
+	maxEvents := defaultAggregateMaxEvents
+	if v, ok := config["max_events"].(float64); ok && v >= 1 {
+		maxEvents = min(int(v), maxAggregateEvents)
+	}
+	window := defaultAggregateWindow
+	if v, ok := config["window"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			window = d
+		}
+	}
+	return maxEvents, window
+}
+
+// Stable ID for a batch so a re-published flush is deduplicated downstream
+func batchEventID(eventIDs []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(eventIDs, "\n")))
+	return "batch-" + hex.EncodeToString(sum[:16])
+}
+
+func (wp *WorkerPool) bufferForAggregate(ctx context.Context, job Job, act store.RelayAction) error {
+	if job.EventID == "" {
+		return fmt.Errorf("aggregate needs an event ID to buffer the event")
+	}
+	maxEvents, window := aggregateConfig(act.Config)
+	return wp.Store.BufferAggregateEvent(ctx, job.RelayID, act, job.EventID, job.Payload, maxEvents, window)
+}
+
+// Flushes every batch that is full or whose window has elapsed
+func (wp *WorkerPool) flushDueAggregates(ctx context.Context) {
+	due, err := wp.Store.DueAggregates(ctx)
+	if err != nil {
+		wp.Logger.Error("failed to find due aggregates", slog.String("error", err.Error()))
+		return
+	}
+	for _, actionID := range due {
+		if err := wp.Store.FlushAggregate(ctx, actionID, wp.publishBatch); err != nil {
+			wp.Logger.Error("failed to flush aggregate", slog.String("action_id", actionID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// Publishes a flushed batch as a new event that resumes the relay after the
+// aggregate step, so downstream actions get the usual retries and DLQ. A batch
+// too big for the consumer may go out as several smaller ones
+func (wp *WorkerPool) publishBatch(batch *store.AggregateBatch) error {
+	parts, err := encodeBatch(batch.EventIDs, batch.Payloads, wp.Republisher.MaxBatchBytes())
+	if err != nil {
+		return err
+	}
+	resumeAfter := batch.OrderIndex
+	for _, part := range parts {
+		err := wp.Republisher.Republish(RepublishedEvent{
+			RelayID:     batch.RelayID,
+			EventID:     part.eventID,
+			Payload:     part.body,
+			ResumeAfter: &resumeAfter,
+		})
+		if err != nil {
+			return err
+		}
+		wp.Logger.Info("aggregate batch flushed", logfields.RelayID(batch.RelayID),
+			slog.String("action_id", batch.ActionID),
+			logfields.EventID(part.eventID),
+			slog.Int("count", part.count))
+	}
+	return nil
+}
+
+type encodedBatch struct {
+	eventID string
+	body    []byte
+	count   int
+}
+
+// Encodes events as one batch payload no larger than limit (0 means no limit).
+// An oversized batch first has each event shrunk to a preview; if it still
+// doesn't fit, e.g. because of many long event IDs, it is split in half
+func encodeBatch(eventIDs []string, payloads []json.RawMessage, limit int) ([]encodedBatch, error) {
+	agg := AggregatePayload{
+		Aggregate: true,
+		Count:     len(eventIDs),
+		EventIDs:  eventIDs,
+		Events:    payloads,
+	}
+	body, err := json.Marshal(agg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal batch: %w", err)
+	}
+	if limit > 0 && len(body) > limit {
+		payload.Metrics.Add("worker_batch_truncated", 1)
+		perEvent := max((limit-4096)/len(payloads)/2, 64)
+		agg.Events = make([]json.RawMessage, len(payloads))
+		for i, p := range payloads {
+			agg.Events[i] = previewJSON(p, perEvent)
+		}
+		if body, err = json.Marshal(agg); err != nil {
+			return nil, fmt.Errorf("marshal batch: %w", err)
+		}
+	}
+	// A single event is as small as it gets
+	if limit <= 0 || len(body) <= limit || len(eventIDs) == 1 {
+		return []encodedBatch{{eventID: batchEventID(eventIDs), body: body, count: len(eventIDs)}}, nil
+	}
+	payload.Metrics.Add("worker_batch_split", 1)
+	half := len(eventIDs) / 2
+	first, err := encodeBatch(eventIDs[:half], payloads[:half], limit)
+	if err != nil {
+		return nil, err
+	}
+	second, err := encodeBatch(eventIDs[half:], payloads[half:], limit)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// Same shape as truncated execution log payloads
+func previewJSON(body []byte, max int) json.RawMessage {
+	if len(body) <= max {
+		return body
+	}
+	preview, _ := payload.Truncate(body, max)
+	summary, err := json.Marshal(map[string]any{
+		"_truncated":    true,
+		"original_size": len(body),
+		"preview":       string(preview),
+	})
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return summary
+}
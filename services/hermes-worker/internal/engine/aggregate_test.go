@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+type fakeRepublisher struct {
+	max         int
+	eventID     string
+	body        []byte
+	resumeAfter int
+	published   int
+}
+
+func (f *fakeRepublisher) Republish(ev RepublishedEvent) error {
+	f.published++
+	f.eventID, f.body = ev.EventID, ev.Payload
+	if ev.ResumeAfter != nil {
+		f.resumeAfter = *ev.ResumeAfter
+	}
+	return nil
+}
+
+func (f *fakeRepublisher) MaxBatchBytes() int { return f.max }
+
+func TestAggregateConfigDefaults(t *testing.T) {
+	maxEvents, window := aggregateConfig(map[string]any{})
+	if maxEvents != defaultAggregateMaxEvents || window != defaultAggregateWindow {
+		t.Errorf("Expected defaults, got %d/%s", maxEvents, window)
+	}
+	maxEvents, window = aggregateConfig(map[string]any{"max_events": float64(50), "window": "5m"})
+	if maxEvents != 50 || window != 5*time.Minute {
+		t.Errorf("Expected 50/5m, got %d/%s", maxEvents, window)
+	}
+	if maxEvents, _ = aggregateConfig(map[string]any{"max_events": float64(1e9)}); maxEvents != maxAggregateEvents {
+		t.Errorf("Expected max_events capped at %d, got %d", maxAggregateEvents, maxEvents)
+	}
+}
+
+func TestPublishBatchShrinksOversizedEvents(t *testing.T) {
+	pub := &fakeRepublisher{max: 8 * 1024}
+	wp := NewWorkerPool(0, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	wp.Republisher = pub
+
+	big, _ := json.Marshal(map[string]string{"log": strings.Repeat("x", 4096)})
+	batch := &store.AggregateBatch{
+		RelayID:    "r1",
+		OrderIndex: 2,
+		EventIDs:   []string{"e1", "e2", "e3"},
+		Payloads:   []json.RawMessage{big, big, big},
+	}
+	if err := wp.publishBatch(batch); err != nil {
+		t.Fatalf("publishBatch failed: %v", err)
+	}
+	if len(pub.body) > pub.max {
+		t.Errorf("Expected batch under %d bytes, got %d", pub.max, len(pub.body))
+	}
+	var got AggregatePayload
+	if err := json.Unmarshal(pub.body, &got); err != nil {
+		t.Fatalf("batch is not valid JSON: %v", err)
+	}
+	if got.Count != 3 || len(got.Events) != 3 || pub.resumeAfter != 2 {
+		t.Errorf("Expected 3 events resuming after 2, got %d events resuming after %d", len(got.Events), pub.resumeAfter)
+	}
+	if pub.eventID != batchEventID([]string{"e1", "e2", "e3"}) {
+		t.Error("Expected a stable batch event ID")
+	}
+}
+
+func TestEncodeBatchSplitsWhenPreviewsDontFit(t *testing.T) {
+	const limit = 8 * 1024
+	var ids []string
+	var payloads []json.RawMessage
+	for i := range 200 {
+		// Event IDs aren't shrunk, so enough long ones overflow on their own
+		ids = append(ids, fmt.Sprintf("%03d-%s", i, strings.Repeat("e", 60)))
+		payloads = append(payloads, json.RawMessage(`{"n":1}`))
+	}
+	parts, err := encodeBatch(ids, payloads, limit)
+	if err != nil {
+		t.Fatalf("encodeBatch failed: %v", err)
+	}
+	if len(parts) < 2 {
+		t.Fatalf("Expected the batch to be split, got %d part(s)", len(parts))
+	}
+	total := 0
+	seen := map[string]bool{}
+	for _, part := range parts {
+		if len(part.body) > limit {
+			t.Errorf("Expected each part under %d bytes, got %d", limit, len(part.body))
+		}
+		if seen[part.eventID] {
+			t.Errorf("Expected distinct event IDs per part, %s repeated", part.eventID)
+		}
+		seen[part.eventID] = true
+		total += part.count
+	}
+	if total != len(ids) {
+		t.Errorf("Expected all %d events across the parts, got %d", len(ids), total)
+	}
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultScaleInterval = 5 * time.Second
+	// Quiet ticks in a row before the pool gives workers back
+	scaleDownAfterTicks = 3
+)
+
+// Snapshot the autoscaler bases its decision on
+type poolLoad struct {
+	Workers int
+	Busy    int
+	// Jobs waiting in the local lanes. Jobs parked behind a relay's
+	// concurrency limit are left out, more workers wouldn't help them
+	Queued int
+	// Messages still waiting in the broker for this consumer
+	Lag int
+	// Consecutive ticks with nothing waiting and most workers idle
+	IdleTicks int
+}
+
+// Worker count to move to for the given load, clamped to [minWorkers, maxWorkers].
+// Grows by half the pool (at least one) while work is waiting and every
+// worker is busy, shrinks by half the idle workers after a sustained lull
+func desiredWorkers(load poolLoad, minWorkers, maxWorkers int) int {
+	current := load.Workers
+	waiting := load.Queued + load.Lag
+	switch {
+	case waiting > 0 && load.Busy >= current:
+		step := current / 2
+		if step < 1 {
+			step = 1
+		}
+		step = min(step, waiting)
+		current += step
+	case waiting == 0 && load.IdleTicks >= scaleDownAfterTicks:
+		idle := current - load.Busy
+		step := idle / 2
+		if step < 1 && idle > 0 {
+			step = 1
+		}
+		current -= step
+	}
+	return max(minWorkers, min(current, maxWorkers))
+}
+
+// Periodically resizes the pool between its minimum and maximum
+func (wp *WorkerPool) autoscale() {
+	defer wp.wg.Done()
+	interval := wp.ScaleInterval
+	if interval <= 0 {
+		interval = defaultScaleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	idleTicks := 0
+
+	for {
+		select {
+		case <-wp.stopping:
+			return
+		case <-wp.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		load := poolLoad{
+			Workers: int(wp.workers.Load()),
+			Busy:    int(wp.busy.Load()),
+			Queued:  wp.Queues.Len(),
+		}
+		if wp.Backlog != nil {
+			load.Lag = wp.Backlog()
+		}
+		if load.Queued+load.Lag == 0 && load.Busy*2 <= load.Workers {
+			idleTicks++
+		} else {
+			idleTicks = 0
+		}
+		load.IdleTicks = idleTicks
+
+		target := desiredWorkers(load, int(wp.minLimit.Load()), int(wp.maxLimit.Load()))
+		switch {
+		case target > load.Workers:
+			for i := load.Workers; i < target; i++ {
+				wp.spawnWorker()
+			}
+			wp.Logger.Info("worker pool scaled up",
+				slog.Int("from", load.Workers),
+				slog.Int("to", target),
+				slog.Int("queued", load.Queued),
+				slog.Int("parked", wp.gate.parkedCount()),
+				slog.Int("lag", load.Lag))
+		case target < load.Workers:
+			removed := 0
+			for i := target; i < load.Workers; i++ {
+				// Only idle workers pick this up, busy ones finish their job first
+				select {
+				case wp.shrink <- struct{}{}:
+					removed++
+				default:
+				}
+			}
+			if removed > 0 {
+				idleTicks = 0
+				wp.Logger.Info("worker pool scaled down",
+					slog.Int("from", load.Workers),
+					slog.Int("to", load.Workers-removed))
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func TestDesiredWorkersGrowsUnderBacklog(t *testing.T) {
+	got := desiredWorkers(poolLoad{Workers: 4, Busy: 4, Queued: 30, Lag: 100}, 2, 10)
+	if got != 6 {
+		t.Errorf("Expected 6 workers, got %d", got)
+	}
+	got = desiredWorkers(poolLoad{Workers: 9, Busy: 9, Queued: 50}, 2, 10)
+	if got != 10 {
+		t.Errorf("Expected growth to stop at max, got %d", got)
+	}
+}
+
+func TestDesiredWorkersHoldsWhileWorkersAreFree(t *testing.T) {
+	got := desiredWorkers(poolLoad{Workers: 4, Busy: 2, Queued: 1}, 2, 10)
+	if got != 4 {
+		t.Errorf("Expected pool to hold at 4 while workers are free, got %d", got)
+	}
+}
+
+func TestDesiredWorkersShrinksAfterLull(t *testing.T) {
+	load := poolLoad{Workers: 10, Busy: 2, IdleTicks: 1}
+	if got := desiredWorkers(load, 2, 10); got != 10 {
+		t.Errorf("Expected no shrink before the lull is sustained, got %d", got)
+	}
+	load.IdleTicks = scaleDownAfterTicks
+	if got := desiredWorkers(load, 2, 10); got != 6 {
+		t.Errorf("Expected 6 workers, got %d", got)
+	}
+	if got := desiredWorkers(poolLoad{Workers: 2, IdleTicks: 10}, 2, 10); got != 2 {
+		t.Errorf("Expected shrink to stop at min, got %d", got)
+	}
+}
+
+func TestResizeMovesRunningPool(t *testing.T) {
+	wp := NewWorkerPool(2, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	wp.MinWorkers = 2
+	wp.ScaleInterval = 10 * time.Millisecond
+	wp.ShutdownGrace = time.Second
+	wp.Start(context.Background())
+	defer wp.Shutdown()
+
+	if err := wp.Resize(0, 4); err == nil {
+		t.Error("Expected a minimum below 1 to be rejected")
+	}
+	if err := wp.Resize(4, 6); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for wp.Workers() != 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := wp.Workers(); got != 4 {
+		t.Errorf("Expected the pool to grow to the new minimum, got %d", got)
+	}
+}
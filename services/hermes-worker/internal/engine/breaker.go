@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit open for destination")
+
+// Implemented by executors that call an external destination, so failures can
+// be tracked per destination (e.g. one Slack webhook) rather than per action type
+type Targeter interface {
+	Target(config map[string]any) string
+}
+
+// Breaker key for a URL config value: the host plus a short hash of the path.
+// Webhooks on a shared host (hooks.slack.com, discord.com) are separate
+// destinations, and the hash keeps the secret in the path out of logs
+func EndpointOf(config map[string]any, key string) string {
+	raw, _ := config[key].(string)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(u.EscapedPath() + "?" + u.RawQuery))
+	return u.Host + "/" + hex.EncodeToString(sum[:6])
+}
+
+// Error for a destination that answered with a failing HTTP status
+type StatusError struct {
+	StatusCode int
+	Msg        string
+}
+
+func (e *StatusError) Error() string {
+	return e.Msg
+}
+
+// Whether err says the destination itself is unhealthy. Transport errors, 5xx
+// and 429 count towards the breaker; other 4xx mean this request was bad and
+// the destination is fine
+func isDestinationFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+type breaker struct {
+	state    breakerState
+	failures int
+	// While open: when a probe may go through. While half-open: when a probe
+	// that never reported back is considered lost and another is allowed
+	until time.Time
+}
+
+// Circuit breakers keyed by destination. After threshold consecutive failures the
+// destination is skipped for cooldown, then a single probe decides whether to close again
+type BreakerSet struct {
+	mu        sync.Mutex
+	breakers  map[string]*breaker
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+func NewBreakerSet(threshold int, cooldown time.Duration) *BreakerSet {
+	return &BreakerSet{
+		breakers:  make(map[string]*breaker),
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Checks whether a call to key may proceed. When it may not, the returned
+// duration says how long until the breaker will allow a probe
+func (bs *BreakerSet) Allow(key string) (time.Duration, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[key]
+	if !ok {
+		return 0, nil
+	}
+	if b.state == stateClosed {
+		return 0, nil
+	}
+	if wait := b.until.Sub(bs.now()); wait > 0 {
+		return wait, fmt.Errorf("%w %s", ErrCircuitOpen, key)
+	}
+	// Let one probe through and hold everything else back until it reports
+	b.state = stateHalfOpen
+	b.until = bs.now().Add(bs.cooldown)
+	return 0, nil
+}
+
+func (bs *BreakerSet) Record(key string, err error) (opened bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[key]
+	if err == nil {
+		if ok {
+			delete(bs.breakers, key)
+		}
+		return false
+	}
+	if !ok {
+		b = &breaker{}
+		bs.breakers[key] = b
+	}
+	b.failures++
+	if b.state == stateHalfOpen || (b.state == stateClosed && b.failures >= bs.threshold) {
+		wasClosed := b.state == stateClosed
+		b.state = stateOpen
+		b.until = bs.now().Add(bs.cooldown)
+		return wasClosed
+	}
+	return false
+}
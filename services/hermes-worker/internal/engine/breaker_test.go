@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	bs := NewBreakerSet(3, time.Minute)
+	failure := errors.New("boom")
+	for i := 0; i < 3; i++ {
+		if _, err := bs.Allow("hooks.slack.com"); err != nil {
+			t.Fatalf("call %d should be allowed, got %v", i, err)
+		}
+		bs.Record("hooks.slack.com", failure)
+	}
+	if _, err := bs.Allow("hooks.slack.com"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected circuit to be open, got %v", err)
+	}
+	if _, err := bs.Allow("discord.com"); err != nil {
+		t.Errorf("Other destinations should be unaffected, got %v", err)
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	bs := NewBreakerSet(1, time.Minute)
+	bs.now = func() time.Time { return now }
+	bs.Record("host", errors.New("boom"))
+
+	now = now.Add(2 * time.Minute)
+	if _, err := bs.Allow("host"); err != nil {
+		t.Fatalf("Probe should be allowed after cooldown, got %v", err)
+	}
+	if _, err := bs.Allow("host"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Only one probe should be allowed while half-open, got %v", err)
+	}
+	bs.Record("host", nil)
+	if _, err := bs.Allow("host"); err != nil {
+		t.Errorf("Successful probe should close the circuit, got %v", err)
+	}
+}
+
+func TestEndpointOfSeparatesWebhooksOnOneHost(t *testing.T) {
+	a := EndpointOf(map[string]any{"url": "https://hooks.slack.com/services/T1/B1/secret-a"}, "url")
+	b := EndpointOf(map[string]any{"url": "https://hooks.slack.com/services/T1/B1/secret-b"}, "url")
+	if a == "" || a == b {
+		t.Fatalf("Expected distinct keys per webhook, got %q and %q", a, b)
+	}
+	if strings.Contains(a, "secret") {
+		t.Errorf("Key should not contain the webhook path, got %q", a)
+	}
+	if got := EndpointOf(map[string]any{}, "url"); got != "" {
+		t.Errorf("Expected no key without a URL, got %q", got)
+	}
+}
+
+func TestOnlyDestinationFailuresCount(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), true},
+		{context.Canceled, false},
+		{&StatusError{StatusCode: 500}, true},
+		{&StatusError{StatusCode: 429}, true},
+		{fmt.Errorf("after retries: %w", &StatusError{StatusCode: 503}), true},
+		{&StatusError{StatusCode: 404}, false},
+		{&StatusError{StatusCode: 400}, false},
+	}
+	for _, c := range cases {
+		if got := isDestinationFailure(c.err); got != c.want {
+			t.Errorf("isDestinationFailure(%v) = %v, expected %v", c.err, got, c.want)
+		}
+	}
+}
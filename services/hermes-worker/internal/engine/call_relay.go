@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Action type that runs another relay with this event, optionally reshaped,
+// and optionally waits for it to finish
+const ActionCallRelay = "call_relay"
+
+const (
+	// Longest chain of relays calling relays
+	maxCallDepth        = 8
+	defaultCallTimeout  = 60 * time.Second
+	maxCallTimeout      = 5 * time.Minute
+	callResultPollEvery = time.Second
+)
+
+var (
+	ErrRelayCycle  = errors.New("relay call cycle")
+	ErrCallTimeout = errors.New("called relay did not finish in time")
+)
+
+type callRelayConfig struct {
+	relayID string
+	spec    *mapping.Spec
+	wait    bool
+	timeout time.Duration
+}
+
+// Reads {"relay_id": "...", "wait": true, "timeout": "30s", "mappings": [...]}.
+// mappings and keep_unmapped work as on a map action
+func parseCallRelay(config map[string]any) (*callRelayConfig, error) {
+	cfg := &callRelayConfig{timeout: defaultCallTimeout}
+	cfg.relayID, _ = config["relay_id"].(string)
+	if cfg.relayID == "" {
+		return nil, errors.New("missing relay_id in call_relay config")
+	}
+	cfg.wait, _ = config["wait"].(bool)
+	if raw, ok := config["timeout"].(string); ok {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", raw)
+		}
+		cfg.timeout = min(d, maxCallTimeout)
+	}
+	if _, ok := config["mappings"]; ok {
+		spec, err := mapping.ParseSpec(config)
+		if err != nil {
+			return nil, err
+		}
+		cfg.spec = spec
+	}
+	return cfg, nil
+}
+
+// Event ID of the called relay's event. Stable across retries of the caller,
+// so the called relay's dedupe runs it only once
+func callEventID(job Job, act store.RelayAction) string {
+	seed := job.EventID
+	if seed == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		seed = hex.EncodeToString(b)
+	}
+	sum := sha256.Sum256([]byte(job.RelayID + "\n" + act.ID + "\n" + seed))
+	return "call-" + hex.EncodeToString(sum[:16])
+}
+
+// Relays job has passed through, ending with its own
+func callChain(job Job) []string {
+	return append(slices.Clone(job.CallChain), job.RelayID)
+}
+
+func (wp *WorkerPool) callRelay(ctx context.Context, job Job, act store.RelayAction, logger *slog.Logger) (string, error) {
+	cfg, err := parseCallRelay(act.Config)
+	if err != nil {
+		return "", err
+	}
+	chain := callChain(job)
+	if slices.Contains(chain, cfg.relayID) {
+		return "", fmt.Errorf("%w: relay %s is already running in this chain", ErrRelayCycle, cfg.relayID)
+	}
+	if len(chain) >= maxCallDepth {
+		return "", fmt.Errorf("relay calls nested deeper than %d", maxCallDepth)
+	}
+	if wp.Republisher == nil {
+		return "", errors.New("call_relay needs a republisher")
+	}
+	body := job.Payload
+	if cfg.spec != nil {
+		if body, err = mapping.Apply(cfg.spec, body); err != nil {
+			return "", err
+		}
+	}
+	eventID := callEventID(job, act)
+	err = wp.Republisher.Republish(RepublishedEvent{
+		RelayID:     cfg.relayID,
+		EventID:     eventID,
+		Payload:     body,
+		Traceparent: stepTraceparent(ctx, job),
+		CallChain:   chain,
+	})
+	if err != nil {
+		return "", fmt.Errorf("enqueue relay %s: %w", cfg.relayID, err)
+	}
+	logger.Debug("relay called", slog.String("called_relay_id", cfg.relayID),
+		slog.String("called_event_id", eventID),
+		slog.Bool("wait", cfg.wait))
+	if !cfg.wait {
+		return fmt.Sprintf("queued event %s for relay %s", eventID, cfg.relayID), nil
+	}
+	return wp.waitForCall(ctx, job, cfg, eventID)
+}
+
+// Polls until the called relay has succeeded, or given up on the event
+func (wp *WorkerPool) waitForCall(ctx context.Context, job Job, cfg *callRelayConfig, eventID string) (string, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	ticker := time.NewTicker(callResultPollEvery)
+	defer ticker.Stop()
+	lastTouch := time.Now()
+	for {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("relay %s: %w", cfg.relayID, ErrCallTimeout)
+		case <-ticker.C:
+			if job.Touch != nil && time.Since(lastTouch) >= agentTouchEvery {
+				job.Touch()
+				lastTouch = time.Now()
+			}
+			outcome, err := wp.Store.CallOutcome(waitCtx, cfg.relayID, eventID)
+			if err != nil {
+				if waitCtx.Err() != nil {
+					continue
+				}
+				return "", err
+			}
+			switch {
+			case outcome == nil:
+				continue
+			case outcome.DeadLettered:
+				return "", fmt.Errorf("relay %s failed: %s", cfg.relayID, outcome.Message)
+			case outcome.Status == "success":
+				return fmt.Sprintf("relay %s succeeded", cfg.relayID), nil
+			case outcome.Status == StatusPartialSuccess:
+				return fmt.Sprintf("relay %s succeeded, some optional actions failed", cfg.relayID), nil
+			case outcome.Status == "cancelled":
+				return "", fmt.Errorf("relay %s execution was cancelled", cfg.relayID)
+			case outcome.Status == "throttled":
+				return "", fmt.Errorf("relay %s dropped the event: throttled", cfg.relayID)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+)
+
+// Returned when an operator cancelled the execution through hermes-core
+var ErrExecutionCancelled = errors.New("execution cancelled")
+
+type runningExecution struct {
+	cancel context.CancelCauseFunc
+}
+
+func runningKey(relayID, eventID string) string {
+	return relayID + "/" + eventID
+}
+
+// Registers a running execution so CancelExecution can reach it. The returned
+// func unregisters it
+func (wp *WorkerPool) trackRunning(job Job, cancel context.CancelCauseFunc) func() {
+	if job.EventID == "" {
+		return func() {}
+	}
+	key := runningKey(job.RelayID, job.EventID)
+	run := &runningExecution{cancel: cancel}
+	wp.runningMu.Lock()
+	if wp.running == nil {
+		wp.running = make(map[string]*runningExecution)
+	}
+	wp.running[key] = run
+	wp.runningMu.Unlock()
+	return func() {
+		wp.runningMu.Lock()
+		if wp.running[key] == run {
+			delete(wp.running, key)
+		}
+		wp.runningMu.Unlock()
+	}
+}
+
+// Stops the event's execution if it is running on this instance. Queued
+// executions are skipped through the cancellation hermes-core records
+func (wp *WorkerPool) CancelExecution(relayID, eventID string) {
+	wp.runningMu.Lock()
+	run, ok := wp.running[runningKey(relayID, eventID)]
+	wp.runningMu.Unlock()
+	if !ok {
+		return
+	}
+	run.cancel(ErrExecutionCancelled)
+	wp.Logger.Info("cancelling running execution", logfields.RelayID(relayID),
+		logfields.EventID(eventID))
+}
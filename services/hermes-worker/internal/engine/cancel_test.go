@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func TestCancelExecutionStopsTrackedRun(t *testing.T) {
+	wp := NewWorkerPool(1, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	untrack := wp.trackRunning(Job{RelayID: "relay", EventID: "evt"}, cancel)
+
+	wp.CancelExecution("relay", "other")
+	if ctx.Err() != nil {
+		t.Fatal("Expected other events to be left running")
+	}
+	wp.CancelExecution("relay", "evt")
+	if !errors.Is(context.Cause(ctx), ErrExecutionCancelled) {
+		t.Fatalf("Expected the run to be cancelled with ErrExecutionCancelled, got %v", context.Cause(ctx))
+	}
+
+	untrack()
+	again, cancelAgain := context.WithCancelCause(context.Background())
+	defer cancelAgain(nil)
+	wp.trackRunning(Job{RelayID: "relay", EventID: "evt2"}, cancelAgain)
+	untrack()
+	wp.CancelExecution("relay", "evt")
+	if again.Err() != nil {
+		t.Error("Expected an untracked run to be out of reach")
+	}
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Failure injected by chaos mode. It is retried like any other failure, so an
+// event that keeps drawing it ends up dead-lettered
+var ErrChaosInjected = errors.New("chaos: injected failure")
+
+// Fault injection for exercising retries, dedupe and the DLQ in staging.
+// Percentages are chances per action, or per finished job for redeliveries.
+// A nil *Chaos injects nothing
+type Chaos struct {
+	// Actions that fail before reaching their destination
+	FailurePercent int
+	// Actions delayed by up to MaxLatency first
+	LatencyPercent int
+	MaxLatency     time.Duration
+	// Successful jobs whose ack is withheld so the broker delivers them again
+	RedeliverPercent int
+	// Relays faults are limited to, every relay when empty
+	Relays []string
+}
+
+func (c *Chaos) targets(relayID string) bool {
+	return c != nil && (len(c.Relays) == 0 || slices.Contains(c.Relays, relayID))
+}
+
+func roll(percent int) bool {
+	return percent > 0 && rand.IntN(100) < percent
+}
+
+// Called before each action runs. Sleeps and fails the action as drawn
+func (c *Chaos) beforeAction(ctx context.Context, job Job) error {
+	if !c.targets(job.RelayID) {
+		return nil
+	}
+	if c.MaxLatency > 0 && roll(c.LatencyPercent) {
+		timer := time.NewTimer(rand.N(c.MaxLatency) + 1)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if roll(c.FailurePercent) {
+		return ErrChaosInjected
+	}
+	return nil
+}
+
+// Reports whether a successful job should be handed back instead of acked
+func (c *Chaos) dropAck(job Job) bool {
+	return c.targets(job.RelayID) && roll(c.RedeliverPercent)
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChaosInjectsFaults(t *testing.T) {
+	c := &Chaos{FailurePercent: 100, LatencyPercent: 100, MaxLatency: 20 * time.Millisecond, RedeliverPercent: 100}
+	if err := c.beforeAction(context.Background(), Job{RelayID: "r1"}); !errors.Is(err, ErrChaosInjected) {
+		t.Errorf("Expected an injected failure, got %v", err)
+	}
+	if isPermanent(ErrChaosInjected) {
+		t.Error("Expected injected failures to be retried")
+	}
+	if !c.dropAck(Job{RelayID: "r1"}) {
+		t.Error("Expected the ack withheld")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = &Chaos{LatencyPercent: 100, MaxLatency: time.Hour}
+	if err := c.beforeAction(ctx, Job{RelayID: "r1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected injected latency to end with the context, got %v", err)
+	}
+}
+
+func TestChaosLimitedToRelays(t *testing.T) {
+	c := &Chaos{FailurePercent: 100, RedeliverPercent: 100, Relays: []string{"r1"}}
+	if err := c.beforeAction(context.Background(), Job{RelayID: "r2"}); err != nil {
+		t.Errorf("Expected other relays untouched, got %v", err)
+	}
+	if c.dropAck(Job{RelayID: "r2"}) {
+		t.Error("Expected other relays acked")
+	}
+	var off *Chaos
+	if err := off.beforeAction(context.Background(), Job{RelayID: "r1"}); err != nil || off.dropAck(Job{RelayID: "r1"}) {
+		t.Error("Expected nothing injected without chaos mode")
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+)
+
+const (
+	defaultDedupeWindow = 24 * time.Hour
+	// Rows deleted per statement, so a large backlog doesn't hold one long transaction
+	dedupePruneBatch = 5000
+)
+
+// Estimated rows in processed_events, refreshed by the janitor
+var dedupeRows = new(expvar.Int)
+
+func init() {
+	payload.Metrics.Set("worker_dedupe_rows", dedupeRows)
+}
+
+// How long the relay's event IDs are remembered
+func (wp *WorkerPool) dedupeWindow(relayID string) time.Duration {
+	if wp.Settings != nil {
+		if seconds := wp.Settings.Get(relayID).DedupeWindowSeconds; seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if wp.DedupeWindow > 0 {
+		return wp.DedupeWindow
+	}
+	return defaultDedupeWindow
+}
+
+// Prunes expired dedupe records every DedupeCleanupInterval until the pool stops
+func (wp *WorkerPool) runDedupeJanitor() {
+	defer wp.wg.Done()
+	ticker := time.NewTicker(wp.DedupeCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wp.stopping:
+			return
+		case <-wp.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		wp.pruneDedupe(wp.ctx)
+	}
+}
+
+func (wp *WorkerPool) pruneDedupe(ctx context.Context) {
+	start := time.Now()
+	var pruned int64
+	for ctx.Err() == nil {
+		batchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		n, err := wp.Store.PruneProcessedEvents(batchCtx, dedupePruneBatch)
+		cancel()
+		if err != nil {
+			wp.Logger.Warn("failed to prune dedupe records", slog.String("error", err.Error()))
+			break
+		}
+		pruned += n
+		if n < dedupePruneBatch {
+			break
+		}
+	}
+	payload.Metrics.Add("worker_dedupe_pruned", pruned)
+
+	statsCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	window := wp.DedupeWindow
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	if _, err := wp.Store.PruneExecutionCancellations(statsCtx, window); err != nil {
+		wp.Logger.Warn("failed to prune cancellations", slog.String("error", err.Error()))
+	}
+	rows, err := wp.Store.ProcessedEventsEstimate(statsCtx)
+	if err != nil {
+		wp.Logger.Debug("failed to estimate dedupe table size", slog.String("error", err.Error()))
+		return
+	}
+	dedupeRows.Set(rows)
+	wp.Logger.Info("pruned dedupe records", slog.Int64("pruned", pruned),
+		slog.Int64("rows", rows),
+		slog.Duration("duration", time.Since(start)))
+}
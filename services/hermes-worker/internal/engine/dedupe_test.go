@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+func TestDedupeWindowPrefersRelaySetting(t *testing.T) {
+	log := logger.New("hermes-worker-test", "test", "error")
+	wp := NewWorkerPool(1, nil, NewRegistry(), log)
+	if got := wp.dedupeWindow("relay"); got != defaultDedupeWindow {
+		t.Errorf("Expected the 24h default, got %v", got)
+	}
+	wp.DedupeWindow = time.Hour
+	wp.Settings = NewSettingsResolver(func(ctx context.Context, relayID string) (*store.RelaySettings, error) {
+		if relayID == "custom" {
+			return &store.RelaySettings{DedupeWindowSeconds: 60}, nil
+		}
+		return &store.RelaySettings{}, nil
+	}, time.Minute, log)
+	if got := wp.dedupeWindow("custom"); got != time.Minute {
+		t.Errorf("Expected the relay's window, got %v", got)
+	}
+	if got := wp.dedupeWindow("other"); got != time.Hour {
+		t.Errorf("Expected the configured default, got %v", got)
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/signing"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+)
+
+// Returned when a user-configured URL resolves to an address the egress policy blocks
+var ErrEgressDenied = errors.New("destination address is not allowed")
+
+const maxRedirects = 5
+
+// Loopback, private, link-local, CGNAT, multicast and reserved ranges. Link-local
+// covers cloud metadata endpoints like 169.254.169.254
+var blockedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// Decides which addresses actions calling user-configured URLs may connect to.
+// The zero value blocks every private range
+type Egress struct {
+	// Lets every address through, for trusted single-tenant deployments
+	AllowPrivate bool
+	// Private ranges that are still reachable, e.g. a self-hosted chat server's subnet
+	AllowedCIDRs []netip.Prefix
+	// Hostnames allowed to resolve to private addresses
+	AllowedHosts []string
+}
+
+// Reports whether a connection to addr is allowed
+func (e *Egress) Permits(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if e != nil {
+		if e.AllowPrivate {
+			return true
+		}
+		for _, prefix := range e.AllowedCIDRs {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	for _, prefix := range blockedRanges {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Egress) hostAllowed(host string) bool {
+	return e != nil && slices.Contains(e.AllowedHosts, strings.ToLower(host))
+}
+
+// Builds an HTTP client that applies the policy. The check runs on the address
+// actually dialed, after DNS resolution, so a hostname re-resolving to a private
+// address between validation and use doesn't get through. Requests made for a
+// relay with a signing key are signed
+func (e *Egress) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		// Environment proxies would dial on our behalf and skip the check
+		Proxy:                 nil,
+		DialContext:           e.dialContext(dialer),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     &tracing.Transport{Base: &signing.Transport{Base: transport}},
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// Dial function applying the policy, for clients that open their own
+// connections, e.g. database drivers
+func (e *Egress) DialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return e.dialContext(&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second})
+}
+
+func (e *Egress) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if e.hostAllowed(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		guarded := *dialer
+		guarded.Control = func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !e.Permits(ap.Addr()) {
+				return fmt.Errorf("%w: %s resolves to %s", ErrEgressDenied, host, ap.Addr())
+			}
+			return nil
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// Follows a few redirects, only to http(s) and never from https down to http
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect to %s URL", req.URL.Scheme)
+	}
+	if via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme == "http" {
+		return fmt.Errorf("refusing redirect from https to http")
+	}
+	return nil
+}
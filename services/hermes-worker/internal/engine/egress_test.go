@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEgressPermits(t *testing.T) {
+	var strict *Egress
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, c := range cases {
+		if got := strict.Permits(netip.MustParseAddr(c.addr)); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.addr, c.want, got)
+		}
+	}
+
+	selfHosted := &Egress{AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.5.0/24")}}
+	if !selfHosted.Permits(netip.MustParseAddr("10.0.5.7")) {
+		t.Error("Expected an allowed CIDR to be reachable")
+	}
+	if selfHosted.Permits(netip.MustParseAddr("10.0.6.7")) {
+		t.Error("Expected addresses outside the allowed CIDR to stay blocked")
+	}
+}
+
+func TestEgressClientBlocksLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := (&Egress{}).Client(time.Second).Get(srv.URL)
+	if !errors.Is(err, ErrEgressDenied) {
+		t.Fatalf("Expected ErrEgressDenied, got %v", err)
+	}
+
+	host := netip.MustParseAddrPort(srv.Listener.Addr().String()).Addr().String()
+	for name, policy := range map[string]*Egress{
+		"allow private": {AllowPrivate: true},
+		"allowed cidr":  {AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+		"allowed host":  {AllowedHosts: []string{host}},
+	} {
+		resp, err := policy.Client(time.Second).Get(srv.URL)
+		if err != nil {
+			t.Errorf("%s: expected the request to go through, got %v", name, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	req := func(raw string) *http.Request {
+		u, _ := url.Parse(raw)
+		return &http.Request{URL: u}
+	}
+	if err := checkRedirect(req("https://b.example"), []*http.Request{req("https://a.example")}); err != nil {
+		t.Errorf("Expected https to https to be followed, got %v", err)
+	}
+	if err := checkRedirect(req("http://b.example"), []*http.Request{req("https://a.example")}); err == nil {
+		t.Error("Expected a downgrade to http to be refused")
+	}
+	if err := checkRedirect(req("file:///etc/passwd"), []*http.Request{req("http://a.example")}); err == nil {
+		t.Error("Expected non-http schemes to be refused")
+	}
+	via := make([]*http.Request, maxRedirects)
+	for i := range via {
+		via[i] = req("https://a.example")
+	}
+	if err := checkRedirect(req("https://b.example"), via); err == nil {
+		t.Error("Expected redirects past the limit to be refused")
+	}
+}
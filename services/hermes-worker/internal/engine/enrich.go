@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Action type that looks data up over HTTP and merges it into the payload
+// for the actions after it
+const ActionEnrich = "enrich"
+
+const (
+	defaultEnrichTimeout = 10 * time.Second
+	maxEnrichTimeout     = time.Minute
+	// Larger lookup responses fail the action instead of bloating the payload
+	maxEnrichResponseBytes = 1 << 20
+)
+
+// Config of an enrich action:
+//
+//	{"url": "https://api.github.com/users/{{sender.login}}",
+//	 "method": "GET", "headers": {"Authorization": "Bearer {{secret:GH_TOKEN}}"},
+//	 "key": "enrichment.sender", "timeout": "5s"}
+//
+// url and headers are mapping templates filled from the payload. POST sends
+// the payload as the body
+type enrichConfig struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Key     string
+	Timeout time.Duration
+}
+
+func parseEnrichConfig(config map[string]any) (enrichConfig, error) {
+	cfg := enrichConfig{Method: http.MethodGet, Timeout: defaultEnrichTimeout}
+	cfg.URL, _ = config["url"].(string)
+	if cfg.URL == "" {
+		return cfg, fmt.Errorf("missing url in enrich action config")
+	}
+	cfg.Key, _ = config["key"].(string)
+	if cfg.Key == "" {
+		return cfg, fmt.Errorf("missing key in enrich action config")
+	}
+	if method, _ := config["method"].(string); method != "" {
+		cfg.Method = strings.ToUpper(method)
+	}
+	if raw, ok := config["headers"].(map[string]any); ok {
+		cfg.Headers = make(map[string]string, len(raw))
+		for name, value := range raw {
+			s, isString := value.(string)
+			if !isString {
+				return cfg, fmt.Errorf("header %s must be a string", name)
+			}
+			cfg.Headers[name] = s
+		}
+	}
+	if raw, _ := config["timeout"].(string); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > maxEnrichTimeout {
+			return cfg, fmt.Errorf("invalid timeout %q in enrich action config", raw)
+		}
+		cfg.Timeout = d
+	}
+	return cfg, nil
+}
+
+// Runs act's lookup and returns the payload with the response merged in at the
+// configured key, plus the response summary for the step log
+func (wp *WorkerPool) enrichPayload(ctx context.Context, job Job, act store.RelayAction) ([]byte, string, error) {
+	config, redact, err := wp.resolveSecrets(ctx, job.RelayID, act.Config)
+	if err != nil {
+		return nil, "", err
+	}
+	merged, response, err := wp.lookup(ctx, job, act, config)
+	return merged, redact(response), redactError(err, redact)
+}
+
+func (wp *WorkerPool) lookup(ctx context.Context, job Job, act store.RelayAction, config map[string]any) ([]byte, string, error) {
+	cfg, err := parseEnrichConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+	var current map[string]any
+	if err := json.Unmarshal(job.Payload, &current); err != nil || current == nil {
+		return nil, "", fmt.Errorf("enrich needs a JSON object payload")
+	}
+	target, err := mapping.Render(cfg.URL, job.Payload, url.PathEscape)
+	if err != nil {
+		return nil, "", fmt.Errorf("url: %w", err)
+	}
+	var body io.Reader
+	if cfg.Method == http.MethodPost {
+		body = bytes.NewReader(job.Payload)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, target, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(idempotency.Header, idempotency.Key(job.RelayID, job.EventID, act.ID))
+	for name, tmpl := range cfg.Headers {
+		value, err := mapping.Render(tmpl, job.Payload, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := wp.Lookups.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxEnrichResponseBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("read response: %w", err)
+	}
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, payload.TruncateString(string(data), maxStepResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, response, &StatusError{
+			StatusCode: resp.StatusCode,
+			Msg:        fmt.Sprintf("enrich lookup returned %d", resp.StatusCode),
+		}
+	}
+	if len(data) > maxEnrichResponseBytes {
+		return nil, response, fmt.Errorf("enrich response is larger than %d bytes", maxEnrichResponseBytes)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, response, fmt.Errorf("enrich response is not JSON: %w", err)
+	}
+	spec := &mapping.Spec{
+		Mappings:     []mapping.Rule{{To: cfg.Key, Value: value}},
+		KeepUnmapped: true,
+	}
+	merged, err := mapping.Apply(spec, job.Payload)
+	if err != nil {
+		return nil, response, err
+	}
+	return merged, response, nil
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+func TestEnrichMergesLookupResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/users/octo%20cat" || r.Header.Get("X-Repo") != "hermes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name": "The Octocat"}`))
+	}))
+	defer srv.Close()
+
+	wp := NewWorkerPool(1, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	wp.Lookups = (&Egress{AllowPrivate: true}).Client(time.Second)
+	act := store.RelayAction{ActionType: ActionEnrich, Config: map[string]any{
+		"url":     srv.URL + "/users/{{sender.login}}",
+		"headers": map[string]any{"X-Repo": "{{repo}}"},
+		"key":     "enrichment.sender",
+	}}
+	job := Job{RelayID: "relay", EventID: "evt", Payload: []byte(`{"sender": {"login": "octo cat"}, "repo": "hermes"}`)}
+
+	merged, response, err := wp.enrichPayload(context.Background(), job, act)
+	if err != nil {
+		t.Fatalf("Expected the lookup to succeed, got %v (%s)", err, response)
+	}
+	expected := `{"enrichment":{"sender":{"name":"The Octocat"}},"repo":"hermes","sender":{"login":"octo cat"}}`
+	if string(merged) != expected {
+		t.Errorf("Expected %s, got %s", expected, merged)
+	}
+
+	job.Payload = []byte(`{"sender": {"login": "someone"}, "repo": "hermes"}`)
+	if _, _, err := wp.enrichPayload(context.Background(), job, act); err == nil {
+		t.Error("Expected a failed lookup to fail the action")
+	}
+	job.Payload = []byte(`{"repo": "hermes"}`)
+	if _, _, err := wp.enrichPayload(context.Background(), job, act); err == nil {
+		t.Error("Expected a placeholder missing from the payload to fail the action")
+	}
+}
+
+func TestEnrichUsesEgressPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	wp := NewWorkerPool(1, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	act := store.RelayAction{ActionType: ActionEnrich, Config: map[string]any{"url": srv.URL, "key": "extra"}}
+	_, _, err := wp.enrichPayload(context.Background(), Job{Payload: []byte(`{}`)}, act)
+	if err == nil {
+		t.Error("Expected the default client to refuse a loopback lookup")
+	}
+}
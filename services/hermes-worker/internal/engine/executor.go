@@ -1,7 +1,47 @@
 package engine
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
 
 type ActionExecutor interface {
 	Execute(ctx context.Context, config map[string]interface{}, payload []byte) error
 }
+
+// Marks a failure retrying can't fix, e.g. a rejected recipient or a bad API
+// key. The event skips its remaining attempts and goes to the failure branch
+// and dead letter queue straight away
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func isPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// Optional extension for executors that can report what the destination
+// answered. The response is stored, truncated, on the execution step
+type Responder interface {
+	ExecuteWithResponse(ctx context.Context, config map[string]interface{}, payload []byte) (string, error)
+}
+
+// Summarises an HTTP response for a step log as "<status>: <body>" and closes
+// the body. Only the first few KB are read
+func ReadResponse(resp *http.Response) string {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStepResponseBytes))
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, text)
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Failure of one action in a relay's main sequence
+type ActionError struct {
+	ActionType string
+	OrderIndex int
+	Err        error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("action %s (order %d) failed: %v", e.ActionType, e.OrderIndex, e.Err)
+}
+
+func (e *ActionError) Unwrap() error { return e.Err }
+
+// Payload the failure branch's actions receive
+type FailurePayload struct {
+	Failed       bool            `json:"failed"`
+	RelayID      string          `json:"relay_id"`
+	EventID      string          `json:"event_id,omitempty"`
+	Error        string          `json:"error"`
+	FailedAction *FailedAction   `json:"failed_action,omitempty"`
+	Attempts     int             `json:"attempts"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+type FailedAction struct {
+	ActionType string `json:"action_type"`
+	OrderIndex int    `json:"order_index"`
+}
+
+func failurePayload(job Job, cause error) ([]byte, error) {
+	fp := FailurePayload{
+		Failed:   true,
+		RelayID:  job.RelayID,
+		EventID:  job.EventID,
+		Error:    payload.TruncateString(cause.Error(), 4096),
+		Attempts: job.Attempt,
+	}
+	var actionErr *ActionError
+	if errors.As(cause, &actionErr) {
+		fp.FailedAction = &FailedAction{ActionType: actionErr.ActionType, OrderIndex: actionErr.OrderIndex}
+	}
+	if json.Valid(job.Payload) {
+		fp.Payload = job.Payload
+	}
+	return json.Marshal(fp)
+}
+
+// Runs the relay's on_failure actions once its main sequence has failed for
+// good, with the error and the original event in their payload. Every handler
+// runs even if an earlier one fails, so one broken destination doesn't hide
+// the failure from the others
+func (wp *WorkerPool) runFailureBranch(job Job, cause error, logger *slog.Logger) {
+	ctx := wp.ctx
+	if job.Trace.Valid() {
+		ctx = tracing.WithContext(ctx, job.Trace)
+	}
+	actions, err := wp.Store.GetRelayActions(ctx, job.RelayID)
+	if err != nil {
+		logger.Error("failed to load failure branch", slog.String("error", err.Error()))
+		return
+	}
+	var handlers []store.RelayAction
+	for _, act := range actions {
+		if act.OnFailure {
+			handlers = append(handlers, act)
+		}
+	}
+	if len(handlers) == 0 {
+		return
+	}
+	// The job is as received, process redacted its own copy
+	if err := wp.redact(ctx, &job); err != nil {
+		logger.Error("failed to redact failure payload", slog.String("error", err.Error()))
+		return
+	}
+	body, err := failurePayload(job, cause)
+	if err != nil {
+		logger.Error("failed to build failure payload", slog.String("error", err.Error()))
+		return
+	}
+	failureJob := job
+	failureJob.Payload = body
+
+	status := "failure_handled"
+	var steps []store.ExecutionStep
+	for _, act := range handlers {
+		start := time.Now()
+		if act.ActionType == ActionMap {
+			mapped, mapErr := mapPayload(act, failureJob.Payload)
+			steps = append(steps, newStep(act, start, string(mapped), mapErr))
+			if mapErr != nil {
+				status = "failure_handler_failed"
+				continue
+			}
+			failureJob.Payload = mapped
+			continue
+		}
+		stepCtx, endStep := startStep(ctx, act)
+		response, execErr := wp.runAction(stepCtx, failureJob, act, logger)
+		endStep(execErr)
+		steps = append(steps, newStep(act, start, response, execErr))
+		if execErr != nil {
+			status = "failure_handler_failed"
+			logger.Error("failure handler failed", slog.String("action_type", act.ActionType),
+				slog.Int("order_index", act.OrderIndex),
+				slog.String("error", execErr.Error()))
+		}
+	}
+	logCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	details := payload.TruncateString(cause.Error(), 4096)
+	err = wp.saveLog(logCtx, store.ExecutionLog{
+		RelayID: job.RelayID,
+		EventID: job.EventID,
+		TraceID: job.Trace.TraceID,
+		Status:  status,
+		Details: details,
+		Payload: wp.logPayload(body),
+		Steps:   steps,
+	})
+	if err != nil {
+		logger.Error("failed to save execution log", slog.String("error", err.Error()))
+	}
+}
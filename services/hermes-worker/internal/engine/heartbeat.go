@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+const defaultHeartbeatInterval = 15 * time.Second
+
+// What this instance looks like right now
+func (wp *WorkerPool) heartbeat(draining bool) store.Heartbeat {
+	interval := wp.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	hb := store.Heartbeat{
+		InstanceID: wp.InstanceID,
+		Hostname:   wp.Hostname,
+		Version:    wp.Version,
+		StartedAt:  wp.startedAt,
+		Interval:   interval,
+		Workers:    int(wp.workers.Load()),
+		ActiveJobs: int(wp.busy.Load()),
+		QueuedJobs: wp.Queues.Len(),
+		ParkedJobs: wp.gate.parkedCount(),
+		Draining:   draining,
+	}
+	if wp.Backlog != nil {
+		hb.QueueLag = wp.Backlog()
+	}
+	return hb
+}
+
+func (wp *WorkerPool) sendHeartbeat(draining bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wp.Store.RecordHeartbeat(ctx, wp.heartbeat(draining)); err != nil {
+		wp.Logger.Warn("failed to record heartbeat", slog.String("error", err.Error()))
+	}
+}
+
+// Reports this instance's load until the pool stops. The last beat marks it
+// draining, Shutdown marks it stopped once in-flight jobs are done
+func (wp *WorkerPool) runHeartbeat() {
+	defer wp.wg.Done()
+	interval := wp.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	wp.sendHeartbeat(false)
+	for {
+		select {
+		case <-wp.stopping:
+			wp.sendHeartbeat(true)
+			return
+		case <-wp.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		wp.sendHeartbeat(false)
+	}
+}
+
+func (wp *WorkerPool) heartbeatsEnabled() bool {
+	return wp.InstanceID != "" && wp.Store != nil
+}
+
+func (wp *WorkerPool) markStopped() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wp.Store.MarkWorkerStopped(ctx, wp.InstanceID); err != nil {
+		wp.Logger.Warn("failed to mark worker stopped", slog.String("error", err.Error()))
+	}
+}
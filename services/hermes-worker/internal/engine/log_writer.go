@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+const (
+	defaultLogBatchSize     = 100
+	defaultLogFlushInterval = 200 * time.Millisecond
+	logFlushTimeout         = 10 * time.Second
+)
+
+// Buffers execution logs and writes them in batches, so a job doesn't wait on
+// its own INSERT. When the buffer is full the caller writes inline, which slows
+// the pool down to what the database keeps up with instead of dropping logs
+type LogWriter struct {
+	write     func(ctx context.Context, logs []store.ExecutionLog) error
+	entries   chan store.ExecutionLog
+	batchSize int
+	interval  time.Duration
+	logger    *slog.Logger
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+}
+
+func NewLogWriter(write func(ctx context.Context, logs []store.ExecutionLog) error, buffer, batchSize int, interval time.Duration, logger *slog.Logger) *LogWriter {
+	if batchSize <= 0 {
+		batchSize = defaultLogBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultLogFlushInterval
+	}
+	w := &LogWriter{
+		write:     write,
+		entries:   make(chan store.ExecutionLog, max(buffer, batchSize)),
+		batchSize: batchSize,
+		interval:  interval,
+		logger:    logger,
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Queues the log, or writes it right away once the buffer is full or closed
+func (w *LogWriter) Log(ctx context.Context, entry store.ExecutionLog) error {
+	if entry.FinishedAt.IsZero() {
+		entry.FinishedAt = time.Now()
+	}
+	w.mu.RLock()
+	if !w.closed {
+		select {
+		case w.entries <- entry:
+			w.mu.RUnlock()
+			return nil
+		default:
+		}
+	}
+	w.mu.RUnlock()
+	payload.Metrics.Add("worker_log_writes_inline", 1)
+	return w.write(ctx, []store.ExecutionLog{entry})
+}
+
+// Flushes what is buffered and stops. Later logs are written inline
+func (w *LogWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *LogWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	batch := make([]store.ExecutionLog, 0, w.batchSize)
+	for {
+		select {
+		case entry, ok := <-w.entries:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (w *LogWriter) flush(batch []store.ExecutionLog) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logFlushTimeout)
+	defer cancel()
+	err := w.write(ctx, batch)
+	if err == nil {
+		payload.Metrics.Add("worker_log_batches", 1)
+		return
+	}
+	w.logger.Warn("batched execution log write failed, writing one by one",
+		slog.Int("logs", len(batch)),
+		slog.String("error", err.Error()))
+	// One bad row shouldn't lose the rest of the batch
+	for _, entry := range batch {
+		if err := w.write(ctx, []store.ExecutionLog{entry}); err != nil {
+			w.logger.Error("failed to save execution log",
+				logfields.RelayID(entry.RelayID),
+				logfields.EventID(entry.EventID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
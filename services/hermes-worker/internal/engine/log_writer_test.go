@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+type recordedWrites struct {
+	mu      sync.Mutex
+	batches [][]string
+	fail    func(logs []store.ExecutionLog) error
+}
+
+func (r *recordedWrites) write(_ context.Context, logs []store.ExecutionLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		if err := r.fail(logs); err != nil {
+			return err
+		}
+	}
+	var ids []string
+	for _, l := range logs {
+		ids = append(ids, l.EventID)
+	}
+	r.batches = append(r.batches, ids)
+	return nil
+}
+
+func (r *recordedWrites) written() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.batches...)
+}
+
+func TestLogWriterBatchesBySize(t *testing.T) {
+	rec := &recordedWrites{}
+	w := NewLogWriter(rec.write, 10, 3, time.Hour, logger.New("hermes-worker-test", "test", "error"))
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := w.Log(context.Background(), store.ExecutionLog{EventID: id}); err != nil {
+			t.Fatalf("Expected Log to queue, got %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(rec.written()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	w.Close()
+	got := rec.written()
+	if len(got) != 2 || len(got[0]) != 3 || len(got[1]) != 1 || got[1][0] != "d" {
+		t.Errorf("Expected a full batch of 3 then the rest on close, got %v", got)
+	}
+}
+
+func TestLogWriterWritesInlineWhenClosed(t *testing.T) {
+	rec := &recordedWrites{}
+	w := NewLogWriter(rec.write, 1, 1, time.Hour, logger.New("hermes-worker-test", "test", "error"))
+	w.Close()
+	if err := w.Log(context.Background(), store.ExecutionLog{EventID: "late"}); err != nil {
+		t.Fatalf("Expected an inline write, got %v", err)
+	}
+	if got := rec.written(); len(got) != 1 || got[0][0] != "late" {
+		t.Errorf("Expected the late log written inline, got %v", got)
+	}
+}
+
+func TestLogWriterRetriesFailedBatchOneByOne(t *testing.T) {
+	rec := &recordedWrites{fail: func(logs []store.ExecutionLog) error {
+		if len(logs) > 1 {
+			return errors.New("batch failed")
+		}
+		if logs[0].EventID == "bad" {
+			return errors.New("bad row")
+		}
+		return nil
+	}}
+	w := NewLogWriter(rec.write, 10, 10, time.Hour, logger.New("hermes-worker-test", "test", "error"))
+	for _, id := range []string{"a", "bad", "b"} {
+		_ = w.Log(context.Background(), store.ExecutionLog{EventID: id})
+	}
+	w.Close()
+	got := rec.written()
+	if len(got) != 2 || got[0][0] != "a" || got[1][0] != "b" {
+		t.Errorf("Expected the good rows saved individually, got %v", got)
+	}
+}
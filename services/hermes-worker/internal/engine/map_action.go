@@ -0,0 +1,18 @@
+package engine
+
+import (
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Action type that reshapes the payload for the actions after it
+const ActionMap = "map"
+
+// Builds the payload the following actions receive from act's mappings
+func mapPayload(act store.RelayAction, body []byte) ([]byte, error) {
+	spec, err := mapping.ParseSpec(act.Config)
+	if err != nil {
+		return nil, err
+	}
+	return mapping.Apply(spec, body)
+}
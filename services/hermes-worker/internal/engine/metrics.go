@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "hermes_worker"
+
+// How long a scrape waits on the dead letter count
+const deadLetterCountTimeout = 2 * time.Second
+
+// Job outcomes as the worker settles them
+const (
+	outcomeSucceeded    = "succeeded"
+	outcomeRetried      = "retried"
+	outcomeDeferred     = "deferred"
+	outcomeDeadLettered = "dead_lettered"
+	outcomeCancelled    = "cancelled"
+	outcomeRequeued     = "requeued"
+)
+
+// Prometheus metrics for one worker instance. A nil *Metrics records nothing
+type Metrics struct {
+	registry      *prometheus.Registry
+	jobs          *prometheus.CounterVec
+	executions    *prometheus.CounterVec
+	executionTime *prometheus.HistogramVec
+	actions       *prometheus.CounterVec
+	actionTime    *prometheus.HistogramVec
+	dedupeChecks  *prometheus.CounterVec
+	quotaDefers   prometheus.Counter
+	// Backpressure: set and counted by the consumer as it pauses and resumes
+	consumerPaused     prometheus.Gauge
+	consumerPauses     prometheus.Counter
+	consumerPausedTime prometheus.Counter
+}
+
+// Registers the counters along with gauges read from wp at scrape time:
+// queue depth, worker counts and the dead letter table's size
+func NewMetrics(wp *WorkerPool) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "jobs_total",
+			Help:      "Jobs handled by outcome: succeeded, retried, deferred, dead_lettered, cancelled or requeued.",
+		}, []string{"outcome"}),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "executions_total",
+			Help:      "Relay executions by the status written to the execution log.",
+		}, []string{"status"}),
+		executionTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "execution_duration_seconds",
+			Help:      "Relay execution time by status.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2.5, 12),
+		}, []string{"status"}),
+		actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "actions_total",
+			Help:      "Action steps by action type and status.",
+		}, []string{"action_type", "status"}),
+		actionTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "action_duration_seconds",
+			Help:      "Action step time by action type.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2.5, 12),
+		}, []string{"action_type"}),
+		dedupeChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dedupe_checks_total",
+			Help:      "Event ID checks by result: hit for a duplicate that was skipped, miss for a new event.",
+		}, []string{"result"}),
+		quotaDefers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_quota_deferrals_total",
+			Help:      "Jobs deferred because their owner was over a tenant quota. These write no execution log.",
+		}),
+		consumerPaused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "consumer_paused",
+			Help:      "1 while consumption is paused because the job queue is nearly full.",
+		}),
+		consumerPauses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "consumer_pauses_total",
+			Help:      "Times consumption paused because the job queue was nearly full.",
+		}),
+		consumerPausedTime: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "consumer_paused_seconds_total",
+			Help:      "Time consumption spent paused for backpressure.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.jobs,
+		m.executions,
+		m.executionTime,
+		m.actions,
+		m.actionTime,
+		m.dedupeChecks,
+		m.quotaDefers,
+		m.consumerPaused,
+		m.consumerPauses,
+		m.consumerPausedTime,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if wp != nil {
+		m.registry.MustRegister(&poolCollector{wp: wp})
+	}
+	return m
+}
+
+// Adds the database pool's connection counts and acquire waits, read at scrape time
+func (m *Metrics) RegisterDBPool(db *pgxpool.Pool) {
+	m.registry.MustRegister(&dbPoolCollector{db: db})
+}
+
+// Serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) jobSettled(outcome string) {
+	if m == nil {
+		return
+	}
+	m.jobs.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) dedupeChecked(duplicate bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if duplicate {
+		result = "hit"
+	}
+	m.dedupeChecks.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) tenantQuotaDeferred() {
+	if m == nil {
+		return
+	}
+	m.quotaDefers.Inc()
+}
+
+// Called by the consumer when it stops taking messages for a nearly full queue
+func (m *Metrics) ConsumerPaused() {
+	if m == nil {
+		return
+	}
+	m.consumerPauses.Inc()
+	m.consumerPaused.Set(1)
+}
+
+// Called when the queue has drained and consumption resumes
+func (m *Metrics) ConsumerResumed(paused time.Duration) {
+	if m == nil {
+		return
+	}
+	m.consumerPaused.Set(0)
+	m.consumerPausedTime.Add(paused.Seconds())
+}
+
+// Records an execution and each step it ran
+func (m *Metrics) executionFinished(status string, duration time.Duration, steps []store.ExecutionStep) {
+	if m == nil {
+		return
+	}
+	m.executions.WithLabelValues(status).Inc()
+	m.executionTime.WithLabelValues(status).Observe(duration.Seconds())
+	for _, step := range steps {
+		m.actions.WithLabelValues(step.ActionType, step.Status).Inc()
+		m.actionTime.WithLabelValues(step.ActionType).Observe(step.Duration.Seconds())
+	}
+}
+
+var (
+	queueDepthDesc = prometheus.NewDesc(metricsNamespace+"_queue_depth",
+		"Jobs waiting in the in-memory job queue by priority lane.", []string{"priority"}, nil)
+	queueCapacityDesc = prometheus.NewDesc(metricsNamespace+"_queue_capacity",
+		"Jobs the in-memory job queue can hold across all lanes.", nil, nil)
+	queueSaturationDesc = prometheus.NewDesc(metricsNamespace+"_queue_saturation_ratio",
+		"Share of the in-memory job queue in use across all lanes, from 0 to 1.", nil, nil)
+	workersDesc = prometheus.NewDesc(metricsNamespace+"_workers",
+		"Running workers by whether they are busy with a job.", []string{"state"}, nil)
+	backlogDesc = prometheus.NewDesc(metricsNamespace+"_broker_pending",
+		"Messages still waiting in the broker for this consumer.", nil, nil)
+	deadLettersDesc = prometheus.NewDesc(metricsNamespace+"_dead_letters",
+		"Events waiting in the dead letter queue, across all workers.", nil, nil)
+	dbConnsDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_connections",
+		"Database pool connections by state.", []string{"state"}, nil)
+	dbMaxDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_max_connections",
+		"Largest size the database pool may grow to.", nil, nil)
+	dbAcquiresDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquires_total",
+		"Connections acquired from the database pool.", nil, nil)
+	dbWaitsDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquire_waits_total",
+		"Acquires that had to wait for a free connection.", nil, nil)
+	dbWaitDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquire_wait_seconds_total",
+		"Time spent waiting for a free connection.", nil, nil)
+	dbCancelsDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquire_cancels_total",
+		"Acquires given up before a connection was free, usually on a job timeout.", nil, nil)
+)
+
+// Reads the pool's state at scrape time. A failed dead letter count leaves
+// that metric out of the scrape rather than failing it
+type poolCollector struct {
+	wp *WorkerPool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueCapacityDesc
+	ch <- queueSaturationDesc
+	ch <- workersDesc
+	ch <- backlogDesc
+	ch <- deadLettersDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	wp := c.wp
+	for _, lane := range []string{PriorityHigh, PriorityNormal, PriorityLow} {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(len(wp.Queues.For(lane))), lane)
+	}
+	ch <- prometheus.MustNewConstMetric(queueCapacityDesc, prometheus.GaugeValue, float64(wp.Queues.Cap()))
+	if capacity := wp.Queues.Cap(); capacity > 0 {
+		ch <- prometheus.MustNewConstMetric(queueSaturationDesc, prometheus.GaugeValue, float64(wp.Queues.Len())/float64(capacity))
+	}
+	workers, busy := wp.workers.Load(), wp.busy.Load()
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(busy), "busy")
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(max(workers-busy, 0)), "idle")
+	if wp.Backlog != nil {
+		ch <- prometheus.MustNewConstMetric(backlogDesc, prometheus.GaugeValue, float64(wp.Backlog()))
+	}
+	if wp.Store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterCountTimeout)
+	defer cancel()
+	if n, err := wp.Store.CountDeadLetters(ctx); err == nil {
+		ch <- prometheus.MustNewConstMetric(deadLettersDesc, prometheus.GaugeValue, float64(n))
+	}
+}
+
+// Reads pgxpool's stats at scrape time
+type dbPoolCollector struct {
+	db *pgxpool.Pool
+}
+
+func (c *dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnsDesc
+	ch <- dbMaxDesc
+	ch <- dbAcquiresDesc
+	ch <- dbWaitsDesc
+	ch <- dbWaitDesc
+	ch <- dbCancelsDesc
+}
+
+func (c *dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(dbMaxDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(dbAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbWaitsDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(dbCancelsDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
@@ -0,0 +1,64 @@
+package engine
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// One buffered lane per priority
+type JobQueues struct {
+	High   chan Job
+	Normal chan Job
+	Low    chan Job
+}
+
+func NewJobQueues(size int) JobQueues {
+	return JobQueues{
+		High:   make(chan Job, size),
+		Normal: make(chan Job, size),
+		Low:    make(chan Job, size),
+	}
+}
+
+// Lane for a priority, unknown values go to normal
+func (q JobQueues) For(priority string) chan Job {
+	switch priority {
+	case PriorityHigh:
+		return q.High
+	case PriorityLow:
+		return q.Low
+	default:
+		return q.Normal
+	}
+}
+
+// Jobs waiting across all lanes
+func (q JobQueues) Len() int {
+	return len(q.High) + len(q.Normal) + len(q.Low)
+}
+
+func (q JobQueues) Cap() int {
+	return cap(q.High) + cap(q.Normal) + cap(q.Low)
+}
+
+// Which lane gets first pick on each turn: out of every 10 jobs high is
+// preferred 6 times, normal 3 and low once, so low still makes progress
+var laneSchedule = [10]string{
+	PriorityHigh, PriorityNormal, PriorityHigh, PriorityHigh, PriorityNormal,
+	PriorityHigh, PriorityLow, PriorityHigh, PriorityNormal, PriorityHigh,
+}
+
+// Takes a job without blocking, starting with the lane whose turn it is and
+// falling back to the others in priority order
+func (q JobQueues) tryTake(turn int) (Job, bool) {
+	first := q.For(laneSchedule[turn%len(laneSchedule)])
+	for _, lane := range [4]chan Job{first, q.High, q.Normal, q.Low} {
+		select {
+		case job := <-lane:
+			return job, true
+		default:
+		}
+	}
+	return Job{}, false
+}
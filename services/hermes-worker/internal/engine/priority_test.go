@@ -0,0 +1,35 @@
+package engine
+
+import "testing"
+
+func TestTryTakeWeightsLanes(t *testing.T) {
+	q := NewJobQueues(20)
+	for i := 0; i < 20; i++ {
+		q.High <- Job{RelayID: PriorityHigh}
+		q.Normal <- Job{RelayID: PriorityNormal}
+		q.Low <- Job{RelayID: PriorityLow}
+	}
+	counts := map[string]int{}
+	for turn := 0; turn < 10; turn++ {
+		job, ok := q.tryTake(turn)
+		if !ok {
+			t.Fatalf("Expected a job on turn %d", turn)
+		}
+		counts[job.RelayID]++
+	}
+	if counts[PriorityHigh] != 6 || counts[PriorityNormal] != 3 || counts[PriorityLow] != 1 {
+		t.Errorf("Expected 6/3/1 split, got %v", counts)
+	}
+}
+
+func TestTryTakeFallsBackWhenLaneEmpty(t *testing.T) {
+	q := NewJobQueues(5)
+	q.Low <- Job{RelayID: PriorityLow}
+	job, ok := q.tryTake(0)
+	if !ok || job.RelayID != PriorityLow {
+		t.Errorf("Expected low priority job when other lanes are empty, got %v", job.RelayID)
+	}
+	if _, ok := q.tryTake(1); ok {
+		t.Error("Expected no job from empty lanes")
+	}
+}
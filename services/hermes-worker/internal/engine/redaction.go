@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/redact"
+)
+
+// Caches each relay's compiled redaction rules so they aren't queried per
+// message. Unlike settings, a failed lookup isn't papered over: the job fails
+// and is retried rather than running with nothing redacted
+type RedactionResolver struct {
+	lookup  func(ctx context.Context, relayID string) ([]redact.Rule, error)
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]redactionEntry
+}
+
+type redactionEntry struct {
+	redactor *redact.Redactor
+	expires  time.Time
+}
+
+func NewRedactionResolver(lookup func(ctx context.Context, relayID string) ([]redact.Rule, error), ttl time.Duration) *RedactionResolver {
+	return &RedactionResolver{
+		lookup:  lookup,
+		ttl:     ttl,
+		entries: make(map[string]redactionEntry),
+	}
+}
+
+// The relay's rules, nil when it has none
+func (r *RedactionResolver) Get(ctx context.Context, relayID string) (*redact.Redactor, error) {
+	now := time.Now()
+	r.mu.Lock()
+	entry, ok := r.entries[relayID]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.redactor, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	rules, err := r.lookup(ctx, relayID)
+	if err != nil {
+		return nil, fmt.Errorf("look up redaction rules: %w", err)
+	}
+	redactor, err := redact.Compile(rules)
+	if err != nil {
+		return nil, fmt.Errorf("compile redaction rules: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) >= maxCachedSettings {
+		for id, e := range r.entries {
+			if now.After(e.expires) {
+				delete(r.entries, id)
+			}
+		}
+	}
+	r.entries[relayID] = redactionEntry{redactor: redactor, expires: now.Add(r.ttl)}
+	return redactor, nil
+}
+
+// Masks the job's payload with its relay's rules
+func (wp *WorkerPool) redact(ctx context.Context, job *Job) error {
+	if wp.Redaction == nil {
+		return nil
+	}
+	redactor, err := wp.Redaction.Get(ctx, job.RelayID)
+	if err != nil {
+		return err
+	}
+	job.Payload = redactor.Apply(job.Payload)
+	return nil
+}
@@ -1,8 +1,15 @@
 package engine
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
 
+// Executors by action type. Plugins are added and removed while workers run,
+// so access is guarded
 type Registry struct {
+	mu        sync.RWMutex
 	executors map[string]ActionExecutor
 }
 
@@ -13,13 +20,35 @@ func NewRegistry() *Registry {
 }
 
 func (r *Registry) Register(name string, executor ActionExecutor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.executors[name] = executor
 }
 
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.executors, name)
+}
+
 func (r *Registry) Get(name string) (ActionExecutor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	exec, exists := r.executors[name]
 	if !exists {
 		return nil, fmt.Errorf("Unknown action type: %s", name)
 	}
 	return exec, nil
 }
+
+// The registered action types, sorted
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.executors))
+	for name := range r.executors {
+		types = append(types, name)
+	}
+	slices.Sort(types)
+	return types
+}
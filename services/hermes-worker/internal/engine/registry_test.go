@@ -0,0 +1,17 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRegistryTypesFollowRegistrations(t *testing.T) {
+	r := NewRegistry()
+	r.Register("slack_send", nil)
+	r.Register("debug_log", nil)
+	r.Register("plugin:resize", nil)
+	r.Unregister("plugin:resize")
+	if got := r.Types(); !slices.Equal(got, []string{"debug_log", "slack_send"}) {
+		t.Errorf("Expected the registered types sorted, got %v", got)
+	}
+}
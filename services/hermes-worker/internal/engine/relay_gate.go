@@ -0,0 +1,88 @@
+package engine
+
+import "sync"
+
+// Enforces per-relay concurrency limits. Jobs over the limit are parked in a
+// per-relay FIFO instead of blocking a worker, and handed to whichever worker
+// finishes the relay's running job next, so a limit of 1 keeps events in order
+type relayGate struct {
+	mu      sync.Mutex
+	running map[string]int
+	parked  map[string][]Job
+}
+
+func newRelayGate() *relayGate {
+	return &relayGate{
+		running: make(map[string]int),
+		parked:  make(map[string][]Job),
+	}
+}
+
+// Reports whether the job may run now. Otherwise it is parked
+func (g *relayGate) admit(job Job, limit int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running[job.RelayID] < limit && len(g.parked[job.RelayID]) == 0 {
+		g.running[job.RelayID]++
+		return true
+	}
+	g.parked[job.RelayID] = append(g.parked[job.RelayID], job)
+	return false
+}
+
+// Releases a slot, returning the relay's next parked job when there is one.
+// The slot passes straight to that job so nothing can overtake it
+func (g *relayGate) done(relayID string) (Job, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if queue := g.parked[relayID]; len(queue) > 0 {
+		next := queue[0]
+		if len(queue) == 1 {
+			delete(g.parked, relayID)
+		} else {
+			g.parked[relayID] = queue[1:]
+		}
+		return next, true
+	}
+	g.running[relayID]--
+	if g.running[relayID] <= 0 {
+		delete(g.running, relayID)
+	}
+	return Job{}, false
+}
+
+// Parked jobs across all relays
+func (g *relayGate) parkedCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, queue := range g.parked {
+		n += len(queue)
+	}
+	return n
+}
+
+// Calls fn for every parked job, used to keep their messages alive
+func (g *relayGate) eachParked(fn func(Job)) {
+	g.mu.Lock()
+	jobs := make([]Job, 0)
+	for _, queue := range g.parked {
+		jobs = append(jobs, queue...)
+	}
+	g.mu.Unlock()
+	for _, job := range jobs {
+		fn(job)
+	}
+}
+
+// Removes and returns every parked job, used when shutting down
+func (g *relayGate) takeParked() []Job {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	jobs := make([]Job, 0)
+	for relayID, queue := range g.parked {
+		jobs = append(jobs, queue...)
+		delete(g.parked, relayID)
+	}
+	return jobs
+}
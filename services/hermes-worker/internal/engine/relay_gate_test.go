@@ -0,0 +1,44 @@
+package engine
+
+import "testing"
+
+func TestRelayGateKeepsOrderAtLimitOne(t *testing.T) {
+	g := newRelayGate()
+	if !g.admit(Job{RelayID: "r1", EventID: "e1"}, 1) {
+		t.Fatal("First job should be admitted")
+	}
+	if g.admit(Job{RelayID: "r1", EventID: "e2"}, 1) {
+		t.Fatal("Second job should be parked while the first runs")
+	}
+	g.admit(Job{RelayID: "r1", EventID: "e3"}, 1)
+	if !g.admit(Job{RelayID: "r2", EventID: "x1"}, 1) {
+		t.Error("Other relays should not be limited")
+	}
+
+	for _, want := range []string{"e2", "e3"} {
+		next, ok := g.done("r1")
+		if !ok || next.EventID != want {
+			t.Fatalf("Expected %s next, got %q (ok=%v)", want, next.EventID, ok)
+		}
+	}
+	if _, ok := g.done("r1"); ok {
+		t.Fatal("Expected no more parked jobs")
+	}
+	if !g.admit(Job{RelayID: "r1", EventID: "e4"}, 1) {
+		t.Error("Slot should be free once the relay is drained")
+	}
+}
+
+func TestRelayGateNoOvertaking(t *testing.T) {
+	g := newRelayGate()
+	g.admit(Job{RelayID: "r1", EventID: "e1"}, 2)
+	g.admit(Job{RelayID: "r1", EventID: "e2"}, 2)
+	g.admit(Job{RelayID: "r1", EventID: "e3"}, 2)
+	g.done("r1") // hands its slot to e3
+	if g.admit(Job{RelayID: "r1", EventID: "e4"}, 2) {
+		t.Error("A new job must not run ahead of parked ones")
+	}
+	if g.parkedCount() != 1 {
+		t.Errorf("Expected 1 parked job, got %d", g.parkedCount())
+	}
+}
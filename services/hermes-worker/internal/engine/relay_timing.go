@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Applies the relay's debounce/throttle settings before any action runs.
+// Returns a non-empty execution status when the event must not run now:
+// "debounced" and "held" events run later, "throttled" ones are dropped
+func (wp *WorkerPool) holdForTiming(ctx context.Context, job Job, logger *slog.Logger) (string, error) {
+	if wp.Settings == nil || job.Released || job.ResumeAfter != nil {
+		return "", nil
+	}
+	settings := wp.Settings.Get(job.RelayID)
+
+	if settings.DebounceSeconds > 0 {
+		quiet := time.Duration(settings.DebounceSeconds) * time.Second
+		held := store.HeldEvent{
+			RelayID:     job.RelayID,
+			EventID:     job.EventID,
+			Payload:     job.Payload,
+			Traceparent: job.Trace.String(),
+			CallChain:   job.CallChain,
+		}
+		if err := wp.Store.HoldDebounced(ctx, held, quiet); err != nil {
+			return "", err
+		}
+		logger.Debug("event debounced", slog.Duration("quiet", quiet))
+		return "debounced", wp.forget(ctx, job)
+	}
+
+	if settings.ThrottleSeconds <= 0 {
+		return "", nil
+	}
+	interval := time.Duration(settings.ThrottleSeconds) * time.Second
+	if settings.ThrottleMode == "queue" {
+		held := store.HeldEvent{
+			RelayID:     job.RelayID,
+			EventID:     job.EventID,
+			Payload:     job.Payload,
+			Traceparent: job.Trace.String(),
+			CallChain:   job.CallChain,
+		}
+		now, slotAt, err := wp.Store.ReserveThrottleSlot(ctx, held, interval)
+		if err != nil || now {
+			return "", err
+		}
+		logger.Debug("event queued by throttle", slog.Time("release_at", slotAt))
+		return "held", wp.forget(ctx, job)
+	}
+	allowed, err := wp.Store.TryThrottle(ctx, job.RelayID, interval)
+	if err != nil || allowed {
+		return "", err
+	}
+	logger.Info("event dropped by throttle")
+	return "throttled", nil
+}
+
+// Clears the dedupe record so the released copy of a held event isn't skipped
+func (wp *WorkerPool) forget(ctx context.Context, job Job) error {
+	if err := wp.Store.ReleaseEvent(ctx, job.RelayID, job.EventID); err != nil {
+		return fmt.Errorf("release held event for later run: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Exponential backoff for failed jobs, which wait in the database instead of
+// being redelivered by the broker straight away
+type RetryPolicy struct {
+	// Wait before the second attempt, doubled for each attempt after it
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Wait after the given failed attempt, with up to 20% jitter either way so
+// jobs that failed together don't all come back together
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(delay))
+	return delay + jitter
+}
+
+// Schedules the failed job's next attempt. Falls back to broker redelivery
+// when retries aren't scheduled or the job can't be held
+func (wp *WorkerPool) retryLater(job Job, cause error, logger *slog.Logger) {
+	// Held events are keyed by event ID, without one a retry could collide with another event
+	if wp.Retries == nil || wp.Republisher == nil || job.EventID == "" {
+		job.MsgAck(false)
+		return
+	}
+	delay := wp.Retries.Delay(job.Attempt)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	held := store.HeldEvent{
+		RelayID:     job.RelayID,
+		EventID:     job.EventID,
+		Payload:     job.Payload,
+		Reason:      store.HoldRetry,
+		Traceparent: job.Trace.String(),
+		Attempts:    job.Attempt,
+		ResumeAfter: job.ResumeAfter,
+		CallChain:   job.CallChain,
+		LastError:   payload.TruncateString(cause.Error(), 4096),
+	}
+	if err := wp.Store.HoldEvent(ctx, held, time.Now().Add(delay)); err != nil {
+		logger.Error("failed to schedule retry, leaving it to the broker", slog.String("error", err.Error()))
+		job.MsgAck(false)
+		return
+	}
+	logger.Info("retry scheduled", slog.Int("attempt", job.Attempt),
+		slog.Duration("delay", delay))
+	job.MsgAck(true)
+}
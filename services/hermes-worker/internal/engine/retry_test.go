@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelayBacksOffWithinJitter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, c := range cases {
+		for range 20 {
+			got := p.Delay(c.attempt)
+			lo, hi := c.want*8/10, c.want*12/10
+			if got < lo || got > hi {
+				t.Errorf("attempt %d: expected %v-%v, got %v", c.attempt, lo, hi, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+const (
+	schedulerTick = time.Second
+	// Held events released per tick, so one busy relay can't stall the loop
+	maxReleasesPerTick = 100
+)
+
+// Event the worker feeds back onto the broker for itself
+type RepublishedEvent struct {
+	RelayID     string
+	EventID     string
+	Payload     []byte
+	ResumeAfter *int
+	Released    bool
+	Traceparent string
+	// Attempts used before the event was republished, e.g. ahead of a deferral
+	PriorAttempts int
+	CallChain     []string
+}
+
+type Republisher interface {
+	Republish(ev RepublishedEvent) error
+	// Largest message the consumer will accept back
+	MaxBatchBytes() int
+}
+
+// Flushes aggregate batches and releases held events until the pool stops.
+// Every worker instance runs this, row locks keep them from doubling up
+func (wp *WorkerPool) runScheduler() {
+	defer wp.wg.Done()
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wp.stopping:
+			return
+		case <-wp.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(wp.ctx, 10*time.Second)
+		wp.flushDueAggregates(ctx)
+		wp.releaseHeldEvents(ctx)
+		cancel()
+	}
+}
+
+func (wp *WorkerPool) releaseHeldEvents(ctx context.Context) {
+	for range maxReleasesPerTick {
+		found, err := wp.Store.ReleaseHeldEvent(ctx, func(held *store.HeldEvent) error {
+			return wp.Republisher.Republish(RepublishedEvent{
+				RelayID:       held.RelayID,
+				EventID:       held.EventID,
+				Payload:       held.Payload,
+				ResumeAfter:   held.ResumeAfter,
+				Released:      true,
+				Traceparent:   held.Traceparent,
+				PriorAttempts: held.Attempts,
+				CallChain:     held.CallChain,
+			})
+		})
+		if err != nil {
+			wp.Logger.Error("failed to release held event", slog.String("error", err.Error()))
+			return
+		}
+		if !found {
+			return
+		}
+	}
+}
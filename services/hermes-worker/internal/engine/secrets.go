@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+)
+
+var (
+	ErrSecretsDisabled = errors.New("action references secrets but no secrets key or backend is set up")
+	// The connection's refresh was refused or hasn't run, it needs connecting again
+	ErrConnectionExpired = errors.New("connection's access token has expired")
+)
+
+// Returns config with its {{secret:NAME}} and {{connection:ID}} references
+// filled in for this run, and a function that masks the resolved values again
+// in anything logged. The resolved copy only lives for the execution,
+// act.Config keeps the references
+func (wp *WorkerPool) resolveSecrets(ctx context.Context, relayID string, config map[string]any) (map[string]any, func(string) string, error) {
+	hasSecrets := len(secrets.References(config)) > 0
+	hasConnections := len(secrets.ConnectionReferences(config)) > 0
+	if !hasSecrets && !hasConnections {
+		return config, func(s string) string { return s }, nil
+	}
+	if wp.Secrets == nil && (hasConnections || len(wp.SecretBackends) == 0) {
+		return nil, nil, ErrSecretsDisabled
+	}
+	var pairs []string
+	resolved, err := secrets.ResolveConnections(config, func(id string) (string, error) {
+		sealed, expired, err := wp.Store.GetConnectionToken(ctx, relayID, id)
+		if err != nil {
+			return "", err
+		}
+		if expired {
+			return "", ErrConnectionExpired
+		}
+		token, err := wp.Secrets.Open(ctx, sealed)
+		if err == nil && token != "" {
+			pairs = append(pairs, token, "[connection:"+id+"]")
+		}
+		return token, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved, err = secrets.Resolve(resolved, func(name string) (string, error) {
+		stored, err := wp.Store.GetSecret(ctx, relayID, name)
+		if err != nil {
+			return "", err
+		}
+		value, err := secrets.Open(ctx, stored, wp.Secrets, wp.SecretBackends)
+		if err == nil && value != "" {
+			pairs = append(pairs, value, "[secret:"+name+"]")
+		}
+		return value, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolved, strings.NewReplacer(pairs...).Replace, nil
+}
+
+// Keeps the wrapped error for errors.Is/As while masking secrets in its message
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func redactError(err error, redact func(string) string) error {
+	if err == nil {
+		return nil
+	}
+	if msg := redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+	return err
+}
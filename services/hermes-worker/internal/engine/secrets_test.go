@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+)
+
+func TestResolveSecretsPassesPlainConfigThrough(t *testing.T) {
+	wp := NewWorkerPool(0, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	config := map[string]any{"webhook_url": "https://hooks.example"}
+	got, redact, err := wp.resolveSecrets(context.Background(), "r1", config)
+	if err != nil || got["webhook_url"] != "https://hooks.example" || redact("x") != "x" {
+		t.Errorf("Expected config unchanged, got %v (%v)", got, err)
+	}
+	_, _, err = wp.resolveSecrets(context.Background(), "r1", map[string]any{"webhook_url": "{{secret:SLACK_URL}}"})
+	if !errors.Is(err, ErrSecretsDisabled) {
+		t.Errorf("Expected ErrSecretsDisabled without a key, got %v", err)
+	}
+}
+
+func TestRedactErrorMasksSecretsAndKeepsChain(t *testing.T) {
+	redact := strings.NewReplacer("https://hooks.example/T/B/X", "[secret:SLACK_URL]").Replace
+	inner := &DeferError{Err: fmt.Errorf(`Post "https://hooks.example/T/B/X": connection refused`)}
+	err := redactError(inner, redact)
+	if strings.Contains(err.Error(), "hooks.example") || !strings.Contains(err.Error(), "[secret:SLACK_URL]") {
+		t.Errorf("Expected the secret masked, got %q", err.Error())
+	}
+	var deferErr *DeferError
+	if !errors.As(err, &deferErr) {
+		t.Error("Expected the wrapped DeferError to stay reachable")
+	}
+}
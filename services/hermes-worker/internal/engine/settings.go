@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+const maxCachedSettings = 10000
+
+// Caches each relay's scheduling settings so they aren't queried per message
+type SettingsResolver struct {
+	lookup  func(ctx context.Context, relayID string) (*store.RelaySettings, error)
+	ttl     time.Duration
+	logger  *slog.Logger
+	mu      sync.Mutex
+	entries map[string]settingsEntry
+}
+
+type settingsEntry struct {
+	settings store.RelaySettings
+	// Why the settings couldn't be looked up, defaults being cached instead
+	err     error
+	expires time.Time
+}
+
+func NewSettingsResolver(lookup func(ctx context.Context, relayID string) (*store.RelaySettings, error), ttl time.Duration, logger *slog.Logger) *SettingsResolver {
+	return &SettingsResolver{
+		lookup:  lookup,
+		ttl:     ttl,
+		logger:  logger,
+		entries: make(map[string]settingsEntry),
+	}
+}
+
+// Settings of a relay, falling back to defaults (normal priority, unlimited
+// concurrency) when they can't be looked up
+func (r *SettingsResolver) Get(relayID string) store.RelaySettings {
+	settings, _ := r.Lookup(relayID)
+	return settings
+}
+
+// Same as Get, but also reports why the settings couldn't be looked up, for
+// callers that mustn't carry on with the defaults
+func (r *SettingsResolver) Lookup(relayID string) (store.RelaySettings, error) {
+	now := time.Now()
+	r.mu.Lock()
+	entry, ok := r.entries[relayID]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.settings, entry.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	settings := store.RelaySettings{Priority: PriorityNormal}
+	found, err := r.lookup(ctx, relayID)
+	if err != nil {
+		r.logger.Debug("failed to look up relay settings", logfields.RelayID(relayID),
+			slog.String("error", err.Error()))
+	} else {
+		settings = *found
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) >= maxCachedSettings {
+		for id, e := range r.entries {
+			if now.After(e.expires) {
+				delete(r.entries, id)
+			}
+		}
+	}
+	r.entries[relayID] = settingsEntry{settings: settings, err: err, expires: now.Add(r.ttl)}
+	return settings, err
+}
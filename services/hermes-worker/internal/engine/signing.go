@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/signing"
+)
+
+var ErrSigningDisabled = errors.New("relay has a signing key but no secrets key is set up to open it")
+
+// Puts the relay's signing key on ctx, so the HTTP requests the action sends
+// are signed. Unsigned relays get ctx back unchanged. The key comes with the
+// relay's cached settings, so a new key is used within RELAY_CACHE_TTL
+func (wp *WorkerPool) withSigningKey(ctx context.Context, relayID string) (context.Context, error) {
+	if wp.Settings == nil {
+		return ctx, nil
+	}
+	settings, err := wp.Settings.Lookup(relayID)
+	if err != nil {
+		return nil, fmt.Errorf("look up signing key: %w", err)
+	}
+	sealed := settings.SigningKey
+	if len(sealed.Ciphertext) == 0 {
+		return ctx, nil
+	}
+	// Sending the request unsigned would look like a forgery to the receiver
+	if wp.Secrets == nil {
+		return nil, ErrSigningDisabled
+	}
+	key, err := wp.Secrets.Open(ctx, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("open signing key: %w", err)
+	}
+	return signing.WithKey(ctx, key), nil
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+func TestSigningKeyComesFromCachedSettings(t *testing.T) {
+	log := logger.New("hermes-worker-test", "test", "error")
+	wp := NewWorkerPool(1, nil, NewRegistry(), log)
+	lookups := 0
+	wp.Settings = NewSettingsResolver(func(ctx context.Context, relayID string) (*store.RelaySettings, error) {
+		lookups++
+		switch relayID {
+		case "signed":
+			return &store.RelaySettings{SigningKey: secrets.Sealed{Ciphertext: []byte("sealed"), DataKey: []byte("key")}}, nil
+		case "missing":
+			return nil, store.ErrRelayNotFound
+		}
+		return &store.RelaySettings{}, nil
+	}, time.Minute, log)
+
+	ctx := context.Background()
+	for range 3 {
+		got, err := wp.withSigningKey(ctx, "unsigned")
+		if err != nil || got != ctx {
+			t.Fatalf("Expected an unsigned relay's context back unchanged, got %v", err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected the relay looked up once, got %d", lookups)
+	}
+	// No secrets key to open it with, so the request mustn't go out unsigned
+	if _, err := wp.withSigningKey(ctx, "signed"); !errors.Is(err, ErrSigningDisabled) {
+		t.Errorf("Expected ErrSigningDisabled, got %v", err)
+	}
+	if _, err := wp.withSigningKey(ctx, "missing"); !errors.Is(err, store.ErrRelayNotFound) {
+		t.Errorf("Expected a failed lookup to fail the action, got %v", err)
+	}
+}
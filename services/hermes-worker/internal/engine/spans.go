@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Starts the span covering one execution of a job, under the span that
+// published the event
+func startExecution(ctx context.Context, job Job) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "execute relay", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("hermes.relay_id", job.RelayID),
+			attribute.String("hermes.event_id", job.EventID),
+			attribute.Int("hermes.attempt", job.Attempt),
+		))
+}
+
+// Starts the span for one action step. The returned func ends it, marking it
+// failed when the step returned an error
+func startStep(ctx context.Context, act store.RelayAction) (context.Context, func(error)) {
+	ctx, span := tracing.Start(ctx, "action "+act.ActionType, trace.WithAttributes(
+		attribute.String("hermes.action_id", act.ID),
+		attribute.String("hermes.action_type", act.ActionType),
+		attribute.Int("hermes.order_index", act.OrderIndex),
+		attribute.Bool("hermes.optional", act.Optional),
+	))
+	return ctx, func(err error) {
+		endSpan(span, err)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// The traceparent for work handed on from a step, such as an agent job or a
+// called relay's event, so it continues under the step's span
+func stepTraceparent(ctx context.Context, job Job) string {
+	if c, ok := tracing.FromContext(ctx); ok {
+		return c.String()
+	}
+	return job.Trace.String()
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Returned when the relay's owner is at their concurrency or per-minute
+// limit. The job is deferred, not failed
+var ErrTenantQuota = errors.New("tenant quota exceeded")
+
+const (
+	// Wait before a job refused for concurrency is tried again
+	tenantBusyDelay = 5 * time.Second
+	// Spread over the wait so deferred jobs don't all come back at once
+	tenantDelayJitter = time.Second
+)
+
+// Caps each user's running executions and executions per minute across the
+// fleet, so one noisy tenant can't hold every worker. Counters live in the
+// database, shared by every instance
+type TenantQuotas struct {
+	admit    func(ctx context.Context, userID, relayID, eventID string, defaults store.TenantLimits, lease time.Duration) (store.TenantAdmission, error)
+	release  func(ctx context.Context, slotID string) error
+	defaults store.TenantLimits
+	lease    time.Duration
+	logger   *slog.Logger
+}
+
+// defaults apply to users without a tenant_quotas row. lease bounds how long a
+// slot outlives a worker that died holding it, keep it above relay timeouts
+func NewTenantQuotas(
+	admit func(ctx context.Context, userID, relayID, eventID string, defaults store.TenantLimits, lease time.Duration) (store.TenantAdmission, error),
+	release func(ctx context.Context, slotID string) error,
+	defaults store.TenantLimits, lease time.Duration, logger *slog.Logger,
+) *TenantQuotas {
+	return &TenantQuotas{admit: admit, release: release, defaults: defaults, lease: lease, logger: logger}
+}
+
+// Takes a slot for the job's execution. The returned func frees it and must be
+// called once the actions are done. A *DeferError means the user is over their
+// quota. The quota store being unreachable lets the job run
+func (q *TenantQuotas) Acquire(ctx context.Context, job Job, userID string) (func(), error) {
+	if userID == "" {
+		return func() {}, nil
+	}
+	admission, err := q.admit(ctx, userID, job.RelayID, job.EventID, q.defaults, q.lease)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		q.logger.Warn("tenant quota check failed, running job anyway", slog.String("user_id", userID),
+			slog.String("error", err.Error()))
+		return func() {}, nil
+	}
+	if !admission.Admitted {
+		reason := "concurrency"
+		delay := tenantBusyDelay
+		if admission.RetryAfter > 0 {
+			reason = "executions per minute"
+			delay = admission.RetryAfter
+		}
+		delay += rand.N(tenantDelayJitter)
+		return nil, &DeferError{Delay: delay, Err: fmt.Errorf("%w: %s limit for user %s", ErrTenantQuota, reason, userID)}
+	}
+	if admission.SlotID == "" {
+		return func() {}, nil
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := q.release(ctx, admission.SlotID); err != nil {
+			// It still frees itself when the lease runs out
+			q.logger.Error("failed to release tenant slot", slog.String("user_id", userID),
+				slog.String("error", err.Error()))
+		}
+	}, nil
+}
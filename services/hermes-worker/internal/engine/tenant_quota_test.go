@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+func newTestQuotas(admission store.TenantAdmission, admitErr error, released *[]string) *TenantQuotas {
+	admit := func(context.Context, string, string, string, store.TenantLimits, time.Duration) (store.TenantAdmission, error) {
+		return admission, admitErr
+	}
+	release := func(_ context.Context, slotID string) error {
+		*released = append(*released, slotID)
+		return nil
+	}
+	return NewTenantQuotas(admit, release, store.TenantLimits{MaxConcurrency: 2}, time.Minute,
+		logger.New("hermes-worker-test", "test", "error"))
+}
+
+func TestTenantQuotaReleasesSlot(t *testing.T) {
+	var released []string
+	q := newTestQuotas(store.TenantAdmission{Admitted: true, SlotID: "s1"}, nil, &released)
+	done, err := q.Acquire(context.Background(), Job{RelayID: "r1", EventID: "e1"}, "u1")
+	if err != nil {
+		t.Fatalf("Expected the job admitted, got %v", err)
+	}
+	done()
+	if len(released) != 1 || released[0] != "s1" {
+		t.Errorf("Expected slot s1 released, got %v", released)
+	}
+}
+
+func TestTenantQuotaDefersOverLimit(t *testing.T) {
+	var released []string
+	q := newTestQuotas(store.TenantAdmission{RetryAfter: 20 * time.Second}, nil, &released)
+	_, err := q.Acquire(context.Background(), Job{RelayID: "r1", EventID: "e1"}, "u1")
+	var deferErr *DeferError
+	if !errors.As(err, &deferErr) || !errors.Is(err, ErrTenantQuota) {
+		t.Fatalf("Expected a deferral for the quota, got %v", err)
+	}
+	if deferErr.Delay < 20*time.Second || deferErr.Delay > 20*time.Second+tenantDelayJitter {
+		t.Errorf("Expected the job back when the minute resets, got %v", deferErr.Delay)
+	}
+
+	q = newTestQuotas(store.TenantAdmission{}, nil, &released)
+	_, err = q.Acquire(context.Background(), Job{RelayID: "r1", EventID: "e2"}, "u1")
+	if !errors.As(err, &deferErr) || deferErr.Delay < tenantBusyDelay {
+		t.Errorf("Expected a short deferral at the concurrency limit, got %v", err)
+	}
+}
+
+func TestTenantQuotaFailsOpen(t *testing.T) {
+	var released []string
+	q := newTestQuotas(store.TenantAdmission{}, errors.New("connection refused"), &released)
+	done, err := q.Acquire(context.Background(), Job{RelayID: "r1", EventID: "e1"}, "u1")
+	if err != nil {
+		t.Fatalf("Expected the job to run while quotas can't be checked, got %v", err)
+	}
+	done()
+	if len(released) != 0 {
+		t.Errorf("Expected nothing to release, got %v", released)
+	}
+}
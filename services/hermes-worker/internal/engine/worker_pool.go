@@ -2,11 +2,20 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
 )
 
@@ -14,137 +23,773 @@ type Job struct {
 	RelayID string
 	EventID string
 	Payload []byte
-	MsgAck  func(bool)
+	// Delivery count reported by the broker, starting at 1
+	Attempt int
+	// Deliveries allowed before the job is dead-lettered. Zero disables dead-lettering
+	MaxAttempts int
+	MsgAck      func(bool)
+	// Tells the broker the job is still being worked on so it isn't redelivered. May be nil
+	Touch func()
+	// Hands the job back for redelivery after the given delay. May be nil
+	Defer func(time.Duration)
+	// Set on aggregate batches: actions up to and including this order index already ran
+	ResumeAfter *int
+	// Set once debounce/throttle let the event through, so it isn't held again
+	Released bool
+	// Trace the event belongs to, continued into logs and outgoing requests
+	Trace tracing.Context
+	// Relays that called this one through call_relay, outermost first
+	CallChain []string
 }
 
+// Returned when a job should be retried later rather than counted as a failure,
+// e.g. because its destination's circuit is open
+type DeferError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *DeferError) Error() string { return e.Err.Error() }
+func (e *DeferError) Unwrap() error { return e.Err }
+
+// Returned when a relay's actions ran past its timeout_seconds
+var ErrRelayTimeout = errors.New("relay execution exceeded its timeout")
+
+// Logged when every required action succeeded but an optional one failed
+const StatusPartialSuccess = "partial_success"
+
 type WorkerPool struct {
-	JobQueue   chan Job
+	// Per-priority lanes, drained with weighted preference for high
+	Queues JobQueues
+	// Upper bound on workers. The pool starts at MaxWorkers and stays there
+	// unless MinWorkers is set lower, which turns on autoscaling. Read by
+	// Start, use Resize to change them on a running pool
 	MaxWorkers int
-	Store      *store.Store
-	Registry   *Registry
-	Logger     *slog.Logger
-	wg         sync.WaitGroup
-	ctx        context.Context
-	cancel     context.CancelFunc
+	MinWorkers int
+	// How often the autoscaler looks at the backlog
+	ScaleInterval time.Duration
+	// Reports messages still waiting in the broker for this consumer. May be nil
+	Backlog func() int
+	// Per-relay settings such as max concurrency. Nil means no per-relay limits
+	Settings *SettingsResolver
+	// Masks personal data before payloads are logged or reach actions. Nil
+	// leaves them as received
+	Redaction *RedactionResolver
+	// How long Shutdown lets in-flight jobs finish before cancelling them
+	ShutdownGrace time.Duration
+	// Publishes aggregate batches and released debounce/throttle events.
+	// Nil disables the scheduler that flushes them
+	Republisher Republisher
+	Store       *store.Store
+	Registry    *Registry
+	Logger      *slog.Logger
+	// Payloads larger than this are stored as a truncated preview in execution logs
+	LogPayloadMaxBytes int
+	// How long an agent-targeted action may wait for an agent to finish it
+	AgentJobTimeout time.Duration
+	// Backoff for failed jobs, which then wait in the database for their next
+	// attempt. Nil hands them straight back to the broker
+	Retries *RetryPolicy
+	// Batches execution log writes. Nil writes each log as its job finishes
+	Logs *LogWriter
+	// Per-destination circuit breakers. Nil disables them
+	Breakers *BreakerSet
+	// Opens secrets referenced as {{secret:NAME}} in action configs. With
+	// neither it nor SecretBackends set, actions referencing one fail
+	Secrets *secrets.Envelope
+	// Fetches secrets kept in Vault or AWS Secrets Manager
+	SecretBackends map[string]secrets.Backend
+	// Makes enrich lookups. Defaults to the strictest egress policy
+	Lookups *http.Client
+	// Dedupe window for relays that don't set their own, 24h when zero
+	DedupeWindow time.Duration
+	// How often expired dedupe records are pruned. Zero turns the janitor off
+	DedupeCleanupInterval time.Duration
+	// Prometheus metrics for this instance. Nil records nothing
+	Metrics *Metrics
+	// Per-user limits shared across the fleet. Nil leaves tenants unlimited
+	Quotas *TenantQuotas
+	// Injected failures, latency and redeliveries for staging. Nil in production
+	Chaos *Chaos
+	// Identifies this instance in the heartbeats operators see. Empty turns them off
+	InstanceID        string
+	Hostname          string
+	Version           string
+	HeartbeatInterval time.Duration
+	startedAt         time.Time
+	// Executions running on this instance, by relay and event ID
+	runningMu sync.Mutex
+	running   map[string]*runningExecution
+
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers atomic.Int32
+	busy    atomic.Int32
+	nextID  atomic.Int32
+	shrink  chan struct{}
+	gate    *relayGate
+	// Closed when Shutdown begins, workers stop taking new jobs
+	stopping chan struct{}
+	// Bounds the autoscaler keeps the pool within, set by Start and Resize
+	minLimit atomic.Int32
+	maxLimit atomic.Int32
 }
 
+const (
+	defaultLogPayloadMaxBytes = 16 * 1024
+	defaultShutdownGrace      = 25 * time.Second
+	// Destination responses kept per execution step
+	maxStepResponseBytes = 2048
+)
+
 // Constructor with dependency injxtn
 func NewWorkerPool(maxWorkers int, db *store.Store, reg *Registry, logger *slog.Logger) *WorkerPool {
 	return &WorkerPool{
-		JobQueue:   make(chan Job, 100),
+		Queues:     NewJobQueues(100),
 		MaxWorkers: maxWorkers,
 		Store:      db,
 		Registry:   reg,
 		Logger:     logger,
+		shrink:     make(chan struct{}),
+		gate:       newRelayGate(),
+		stopping:   make(chan struct{}),
+		Lookups:    (&Egress{}).Client(maxEnrichTimeout),
 	}
 }
 
 // Spaws all worker goroutines
 func (wp *WorkerPool) Start(ctx context.Context) {
 	wp.ctx, wp.cancel = context.WithCancel(ctx)
+	wp.startedAt = time.Now()
+	autoscaling := wp.MinWorkers > 0 && wp.MinWorkers < wp.MaxWorkers
+	initial := wp.MaxWorkers
+	if autoscaling {
+		initial = wp.MinWorkers
+	}
+	wp.minLimit.Store(int32(initial))
+	wp.maxLimit.Store(int32(wp.MaxWorkers))
 	wp.Logger.Info("starting worker pool",
 		slog.Int("max_workers", wp.MaxWorkers),
-		slog.Int("queue_size", cap(wp.JobQueue)),
+		slog.Int("min_workers", wp.MinWorkers),
+		slog.Bool("autoscaling", autoscaling),
+		slog.Int("queue_size", wp.Queues.Cap()),
 	)
-	for i := 0; i < wp.MaxWorkers; i++ {
+	for i := 0; i < initial; i++ {
+		wp.spawnWorker()
+	}
+	// Runs for fixed pools too, so Resize can turn autoscaling on later
+	wp.wg.Add(1)
+	go wp.autoscale()
+	wp.wg.Add(1)
+	go wp.keepParkedAlive()
+	if wp.Republisher != nil {
+		wp.wg.Add(1)
+		go wp.runScheduler()
+	}
+	if wp.heartbeatsEnabled() {
+		wp.wg.Add(1)
+		go wp.runHeartbeat()
+	}
+	if wp.DedupeCleanupInterval > 0 && wp.Store != nil {
 		wp.wg.Add(1)
-		go wp.worker(ctx, i)
+		go wp.runDedupeJanitor()
 	}
 	wp.Logger.Info("worker pool started",
-		slog.Int("workers", wp.MaxWorkers))
+		slog.Int("workers", initial))
+}
+
+// Changes the worker bounds of a running pool. The autoscaler moves the
+// pool inside them on its next tick, busy workers finish their job first
+func (wp *WorkerPool) Resize(minWorkers, maxWorkers int) error {
+	if minWorkers < 1 || minWorkers > maxWorkers {
+		return fmt.Errorf("worker bounds must satisfy 1 <= min <= max, got %d and %d", minWorkers, maxWorkers)
+	}
+	wp.minLimit.Store(int32(minWorkers))
+	wp.maxLimit.Store(int32(maxWorkers))
+	wp.Logger.Info("worker pool resized",
+		slog.Int("min_workers", minWorkers),
+		slog.Int("max_workers", maxWorkers))
+	return nil
+}
+
+// Current number of running workers
+func (wp *WorkerPool) Workers() int {
+	return int(wp.workers.Load())
+}
+
+func (wp *WorkerPool) spawnWorker() {
+	wp.wg.Add(1)
+	wp.workers.Add(1)
+	go wp.worker(wp.ctx, int(wp.nextID.Add(1)-1))
 }
 
 // Each worker runs its own goroutine
 func (wp *WorkerPool) worker(_ context.Context, id int) {
 	defer wp.wg.Done()
+	defer wp.workers.Add(-1)
 	workerLogger := wp.Logger.With(slog.Int("worker_id", id))
 	workerLogger.Debug("worker started")
-	for {
-		select {
-		case <-wp.ctx.Done():
+	for turn := id; ; turn++ {
+		if wp.isStopping() {
 			workerLogger.Info("worker shutting down")
 			return
-		case job, ok := <-wp.JobQueue:
-			if !ok {
-				workerLogger.Info("job queue closed, worker exiting")
+		}
+		job, ok := wp.Queues.tryTake(turn)
+		if !ok {
+			// Nothing waiting, block until any lane has work
+			select {
+			case <-wp.stopping:
+				workerLogger.Info("worker shutting down")
 				return
+			case <-wp.ctx.Done():
+				workerLogger.Info("worker shutting down")
+				return
+			case <-wp.shrink:
+				workerLogger.Debug("worker retired by autoscaler")
+				return
+			case job = <-wp.Queues.High:
+			case job = <-wp.Queues.Normal:
+			case job = <-wp.Queues.Low:
 			}
-			start := time.Now()
-			workerLogger.Info("processing relay", slog.String("relay_id", job.RelayID), slog.String("event_id", job.EventID))
-			err := wp.process(wp.ctx, job, workerLogger)
-			duration := time.Since(start)
-			if err != nil {
-				workerLogger.Error("relay execution failed", slog.String("relay_id", job.RelayID),
-					slog.String("event_id", job.EventID),
-					slog.Duration("duration", duration),
-					slog.String("error", err.Error()))
-				job.MsgAck(false)
-			} else {
-				workerLogger.Info("relay execution succeeded", slog.String("relay_id", job.RelayID),
-					slog.String("event_id", job.EventID),
-					slog.Duration("duration", duration))
-				job.MsgAck(true)
+		}
+		limit := 0
+		if wp.Settings != nil {
+			limit = wp.Settings.Get(job.RelayID).MaxConcurrency
+		}
+		if limit > 0 && !wp.gate.admit(job, limit) {
+			workerLogger.Debug("relay at concurrency limit, job parked",
+				logfields.RelayID(job.RelayID),
+				logfields.EventID(job.EventID),
+				slog.Int("max_concurrency", limit))
+			continue
+		}
+		wp.busy.Add(1)
+		wp.handle(job, workerLogger)
+		// Run the relay's parked jobs in arrival order while we hold its slot
+		for limit > 0 {
+			next, ok := wp.gate.done(job.RelayID)
+			if !ok {
+				break
+			}
+			if wp.isStopping() {
+				wp.handBack(next)
+				continue
 			}
+			wp.handle(next, workerLogger)
 		}
+		wp.busy.Add(-1)
+	}
+}
+
+// Touches parked jobs so the broker doesn't redeliver them while they wait
+// for their relay, and hands them back on shutdown
+func (wp *WorkerPool) keepParkedAlive() {
+	defer wp.wg.Done()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wp.stopping:
+			return
+		case <-wp.ctx.Done():
+			return
+		case <-ticker.C:
+			wp.gate.eachParked(func(job Job) {
+				if job.Touch != nil {
+					job.Touch()
+				}
+			})
+		}
+	}
+}
+
+// Returns an unstarted job to the broker for immediate redelivery
+func (wp *WorkerPool) handBack(job Job) {
+	if job.Defer != nil {
+		job.Defer(0)
+		return
+	}
+	job.MsgAck(false)
+}
+
+func (wp *WorkerPool) isStopping() bool {
+	select {
+	case <-wp.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (wp *WorkerPool) handle(job Job, workerLogger *slog.Logger) {
+	start := time.Now()
+	ctx := wp.ctx
+	if job.Trace.Valid() {
+		ctx = tracing.WithContext(ctx, job.Trace)
+	}
+	// Every line logged for this job carries its relay, event and trace IDs
+	workerLogger = workerLogger.With(logfields.Event(job.RelayID, job.EventID, job.Trace.TraceID)...)
+	ctx, span := startExecution(ctx, job)
+	workerLogger.Info("processing relay")
+	err := wp.process(ctx, job, workerLogger)
+	endSpan(span, err)
+	duration := time.Since(start)
+	if err != nil && wp.ctx.Err() != nil {
+		// Cut off by shutdown, not a real failure: requeue without using up an attempt towards the DLQ
+		workerLogger.Warn("relay execution interrupted by shutdown, requeueing",
+			slog.Duration("duration", duration))
+		wp.Metrics.jobSettled(outcomeRequeued)
+		wp.handBack(job)
+		return
+	}
+	if errors.Is(err, ErrExecutionCancelled) {
+		// Neither retried nor dead-lettered
+		workerLogger.Info("relay execution cancelled", slog.Duration("duration", duration))
+		wp.Metrics.jobSettled(outcomeCancelled)
+		job.MsgAck(true)
+		return
+	}
+	if err != nil {
+		workerLogger.Error("relay execution failed", slog.Int("attempt", job.Attempt),
+			slog.Duration("duration", duration),
+			slog.String("error", err.Error()))
+		var deferErr *DeferError
+		if errors.As(err, &deferErr) {
+			wp.Metrics.jobSettled(outcomeDeferred)
+			wp.deferJob(job, deferErr.Delay, workerLogger)
+		} else if (job.MaxAttempts > 0 && job.Attempt >= job.MaxAttempts) || isPermanent(err) {
+			// Out of retries or not worth retrying, park it in the DLQ. The broker won't redeliver past
+			// MaxDeliver either way, so ack even when the row couldn't be written
+			wp.Metrics.jobSettled(outcomeDeadLettered)
+			wp.runFailureBranch(job, err, workerLogger)
+			wp.deadLetter(job, err, workerLogger)
+			job.MsgAck(true)
+		} else {
+			wp.Metrics.jobSettled(outcomeRetried)
+			wp.retryLater(job, err, workerLogger)
+		}
+	} else {
+		workerLogger.Info("relay execution succeeded", slog.Duration("duration", duration))
+		wp.Metrics.jobSettled(outcomeSucceeded)
+		if wp.Chaos.dropAck(job) {
+			// The copy the broker sends back should be skipped as a duplicate
+			workerLogger.Warn("chaos: withholding ack, event will be redelivered")
+			job.MsgAck(false)
+			return
+		}
+		job.MsgAck(true)
 	}
 }
 
 // Executes the actual workflow logic
 func (wp *WorkerPool) process(ctx context.Context, job Job, logger *slog.Logger) (err error) {
+	start := time.Now()
 	status := "success"
 	details := "Relay executed successfully"
 
+	if err := wp.redact(ctx, &job); err != nil {
+		return err
+	}
 	if job.EventID != "" {
-		isNew, dedupeErr := wp.Store.RegisterEvent(ctx, job.RelayID, job.EventID)
+		isNew, dedupeErr := wp.Store.RegisterEvent(ctx, job.RelayID, job.EventID, wp.dedupeWindow(job.RelayID))
 		if dedupeErr != nil {
 			return dedupeErr
 		}
+		wp.Metrics.dedupeChecked(!isNew)
 		if !isNew {
-			logger.Info("duplicate event skipped",
-				slog.String("relay_id", job.RelayID),
-				slog.String("event_id", job.EventID))
+			logger.Info("duplicate event skipped")
 			return nil
 		}
 	}
+	var steps []store.ExecutionStep
+	// Errors of optional actions, which don't stop the relay
+	var optionalFailures []string
+	// A map action replaces job.Payload, the log keeps what was received
+	received := job.Payload
+	// Lets CancelExecution stop this run
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	defer wp.trackRunning(job, cancelRun)()
+	// The relay's overall deadline applies to the actions, not to logging the outcome
+	parent, runCtx := ctx, ctx
 	defer func() {
 		logCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
+		if err == nil && len(optionalFailures) > 0 {
+			status = StatusPartialSuccess
+			details = payload.TruncateString(fmt.Sprintf("%d optional action(s) failed: %s",
+				len(optionalFailures), strings.Join(optionalFailures, "; ")), 4096)
+		}
+		cancelled := errors.Is(err, ErrExecutionCancelled) || errors.Is(context.Cause(parent), ErrExecutionCancelled)
 		if err != nil {
 			status = "failed"
-			details = err.Error()
+			if cancelled {
+				status = "cancelled"
+				if !errors.Is(err, ErrExecutionCancelled) {
+					err = fmt.Errorf("%w: %w", ErrExecutionCancelled, err)
+				}
+			} else if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrTenantQuota) {
+				status = "deferred"
+			} else if parent.Err() != nil {
+				status = "interrupted"
+			} else if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				status = "timeout"
+				err = fmt.Errorf("%w: %w", ErrRelayTimeout, err)
+			}
+			details = payload.TruncateString(err.Error(), 4096)
+			// Let the retry run the actions again instead of being skipped as a duplicate.
+			// A cancelled event stays processed so a redelivery doesn't run it
+			if !cancelled {
+				if releaseErr := wp.Store.ReleaseEvent(logCtx, job.RelayID, job.EventID); releaseErr != nil {
+					logger.Error("failed to release event for retry", slog.String("error", releaseErr.Error()))
+				}
+			}
+		}
+		wp.Metrics.executionFinished(status, time.Since(start), steps)
+		if errors.Is(err, ErrTenantQuota) {
+			// Counted, not logged: a tenant over quota would otherwise add
+			// a row per event every time its jobs come back
+			wp.Metrics.tenantQuotaDeferred()
+			return
 		}
-		logErr := wp.Store.LogExecution(logCtx, job.RelayID, status, details, job.EventID, job.Payload)
+		logErr := wp.saveLog(logCtx, store.ExecutionLog{
+			RelayID: job.RelayID,
+			EventID: job.EventID,
+			TraceID: job.Trace.TraceID,
+			Status:  status,
+			Details: details,
+			Payload: wp.logPayload(received),
+			Steps:   steps,
+		})
 		if logErr != nil {
 			logger.Error("failed to save execution log", slog.String("error", logErr.Error()))
 		}
 	}()
+	if job.EventID != "" {
+		cancelled, cancelErr := wp.Store.ExecutionCancelled(ctx, job.RelayID, job.EventID)
+		if cancelErr != nil {
+			return cancelErr
+		}
+		if cancelled {
+			return fmt.Errorf("%w before it started", ErrExecutionCancelled)
+		}
+	}
+	heldStatus, holdErr := wp.holdForTiming(ctx, job, logger)
+	if holdErr != nil {
+		return holdErr
+	}
+	if heldStatus != "" {
+		status = heldStatus
+		details = "Event " + heldStatus + " by relay timing settings"
+		return nil
+	}
+	if wp.Quotas != nil && wp.Settings != nil {
+		release, quotaErr := wp.Quotas.Acquire(ctx, job, wp.Settings.Get(job.RelayID).UserID)
+		if quotaErr != nil {
+			return quotaErr
+		}
+		defer release()
+	}
+	if wp.Settings != nil {
+		if timeout := wp.Settings.Get(job.RelayID).TimeoutSeconds; timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+			defer cancel()
+		}
+	}
+	ctx = runCtx
 	actions, fetchErr := wp.Store.GetRelayActions(ctx, job.RelayID)
 	if fetchErr != nil {
 		return fetchErr
 	}
 	for _, act := range actions {
+		// The failure branch only runs once the event is given up on
+		if act.OnFailure {
+			continue
+		}
+		if job.ResumeAfter != nil && act.OrderIndex <= *job.ResumeAfter {
+			continue
+		}
+		if act.ActionType == ActionAggregate {
+			start := time.Now()
+			stepCtx, endStep := startStep(ctx, act)
+			bufErr := wp.bufferForAggregate(stepCtx, job, act)
+			endStep(bufErr)
+			step := newStep(act, start, "", bufErr)
+			if bufErr == nil {
+				step.Status = "buffered"
+				details = "Event buffered for aggregation"
+			}
+			steps = append(steps, step)
+			if bufErr != nil {
+				return &ActionError{ActionType: act.ActionType, OrderIndex: act.OrderIndex, Err: bufErr}
+			}
+			// The rest of the relay runs when the batch is flushed
+			return nil
+		}
+		if act.ActionType == ActionMap {
+			start := time.Now()
+			_, endStep := startStep(ctx, act)
+			mapped, mapErr := mapPayload(act, job.Payload)
+			endStep(mapErr)
+			steps = append(steps, newStep(act, start, string(mapped), mapErr))
+			if mapErr != nil {
+				actErr := &ActionError{ActionType: act.ActionType, OrderIndex: act.OrderIndex, Err: mapErr}
+				if act.Optional {
+					// Later actions get the payload as it was
+					optionalFailures = append(optionalFailures, actErr.Error())
+					continue
+				}
+				return actErr
+			}
+			// Later actions, including an aggregate, see the mapped shape
+			job.Payload = mapped
+			continue
+		}
+		if act.ActionType == ActionEnrich {
+			start := time.Now()
+			stepCtx, endStep := startStep(ctx, act)
+			enriched, response, enrichErr := wp.enrichPayload(stepCtx, job, act)
+			endStep(enrichErr)
+			steps = append(steps, newStep(act, start, response, enrichErr))
+			if enrichErr != nil {
+				actErr := &ActionError{ActionType: act.ActionType, OrderIndex: act.OrderIndex, Err: enrichErr}
+				if act.Optional && ctx.Err() == nil {
+					optionalFailures = append(optionalFailures, actErr.Error())
+					continue
+				}
+				return actErr
+			}
+			job.Payload = enriched
+			continue
+		}
 		logger.Debug("executing action",
 			slog.String("action_type", act.ActionType),
-			slog.Int("order_index", act.OrderIndex),
-			slog.String("event_id", job.EventID))
-		executor, pluginErr := wp.Registry.Get(act.ActionType)
-		if pluginErr != nil {
-			return pluginErr
+			slog.Int("order_index", act.OrderIndex))
+		start := time.Now()
+		stepCtx, endStep := startStep(ctx, act)
+		response, execErr := wp.runAction(stepCtx, job, act, logger)
+		endStep(execErr)
+		steps = append(steps, newStep(act, start, response, execErr))
+		if execErr != nil && act.Optional && ctx.Err() == nil {
+			optionalFailures = append(optionalFailures, (&ActionError{ActionType: act.ActionType, OrderIndex: act.OrderIndex, Err: execErr}).Error())
+			logger.Warn("optional action failed, continuing", slog.String("action_type", act.ActionType),
+				slog.Int("order_index", act.OrderIndex),
+				slog.String("error", execErr.Error()))
+			continue
 		}
-		if execErr := executor.Execute(ctx, act.Config, job.Payload); execErr != nil {
-			return fmt.Errorf("action %s (order %d) failed: %w", act.ActionType, act.OrderIndex, execErr)
+		if execErr != nil {
+			var deferErr *DeferError
+			if errors.As(execErr, &deferErr) {
+				return execErr
+			}
+			return &ActionError{ActionType: act.ActionType, OrderIndex: act.OrderIndex, Err: execErr}
 		}
 	}
 	return nil
 }
 
+// Runs one action locally or on an agent, returning the destination's response when known
+func (wp *WorkerPool) runAction(ctx context.Context, job Job, act store.RelayAction, logger *slog.Logger) (string, error) {
+	// Every attempt at this action carries the same key, for destinations that dedupe on it
+	ctx = idempotency.WithContext(ctx, idempotency.Value{
+		Key:     idempotency.Key(job.RelayID, job.EventID, act.ID),
+		Attempt: job.Attempt,
+	})
+	if err := wp.Chaos.beforeAction(ctx, job); err != nil {
+		return "", err
+	}
+	if act.AgentGroup != "" {
+		return wp.dispatchToAgent(ctx, job, act, logger)
+	}
+	if act.ActionType == ActionCallRelay {
+		return wp.callRelay(ctx, job, act, logger)
+	}
+	executor, err := wp.Registry.Get(act.ActionType)
+	if err != nil {
+		return "", err
+	}
+	config, redact, err := wp.resolveSecrets(ctx, job.RelayID, act.Config)
+	if err != nil {
+		return "", err
+	}
+	if ctx, err = wp.withSigningKey(ctx, job.RelayID); err != nil {
+		return "", err
+	}
+	target := ""
+	if t, ok := executor.(Targeter); ok && wp.Breakers != nil {
+		target = t.Target(config)
+	}
+	if target != "" {
+		if wait, openErr := wp.Breakers.Allow(target); openErr != nil {
+			return "", &DeferError{Delay: wait, Err: openErr}
+		}
+	}
+	var response string
+	if r, ok := executor.(Responder); ok {
+		response, err = r.ExecuteWithResponse(ctx, config, job.Payload)
+	} else {
+		err = executor.Execute(ctx, config, job.Payload)
+	}
+	var outcome error
+	if isDestinationFailure(err) {
+		outcome = err
+	}
+	if target != "" && wp.Breakers.Record(target, outcome) {
+		logger.Warn("circuit opened for destination",
+			slog.String("target", target),
+			slog.String("action_type", act.ActionType))
+	}
+	return redact(response), redactError(err, redact)
+}
+
+func newStep(act store.RelayAction, start time.Time, response string, err error) store.ExecutionStep {
+	step := store.ExecutionStep{
+		ActionID:   act.ID,
+		ActionType: act.ActionType,
+		OrderIndex: act.OrderIndex,
+		Status:     "success",
+		Duration:   time.Since(start),
+		Response:   payload.TruncateString(response, maxStepResponseBytes),
+		StartedAt:  start,
+	}
+	if err != nil {
+		step.Status = "failed"
+		if errors.Is(err, ErrCircuitOpen) {
+			step.Status = "deferred"
+		}
+		step.ErrorMessage = payload.TruncateString(err.Error(), 4096)
+	}
+	return step
+}
+
+// Parks a job whose destination is unavailable until delay has passed, without
+// using up a delivery attempt: the held copy is republished by the scheduler
+// carrying the attempts it had before this one. Falls back to a delayed
+// redelivery from the broker when the job can't be held
+func (wp *WorkerPool) deferJob(job Job, delay time.Duration, logger *slog.Logger) {
+	if wp.Republisher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		held := store.HeldEvent{
+			RelayID:     job.RelayID,
+			EventID:     job.EventID,
+			Payload:     job.Payload,
+			Reason:      store.HoldDeferred,
+			Traceparent: job.Trace.String(),
+			Attempts:    max(job.Attempt-1, 0),
+			ResumeAfter: job.ResumeAfter,
+			CallChain:   job.CallChain,
+		}
+		err := wp.Store.HoldEvent(ctx, held, time.Now().Add(delay))
+		if err == nil {
+			job.MsgAck(true)
+			return
+		}
+		logger.Error("failed to hold deferred job", slog.String("error", err.Error()))
+	}
+	if job.Defer != nil {
+		job.Defer(delay)
+		return
+	}
+	job.MsgAck(false)
+}
+
+// Persists a job that exhausted its retries. When the row can't be written the
+// event is logged in full instead, as that is the last record of it
+func (wp *WorkerPool) deadLetter(job Job, cause error, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	reason := payload.TruncateString(cause.Error(), 4096)
+	if err := wp.Store.SaveDeadLetter(ctx, job.RelayID, job.EventID, job.Trace.String(), reason, job.Attempt, job.Payload); err != nil {
+		// The payload isn't redacted here, so only its size is logged; the
+		// logger already carries the relay and event IDs
+		logger.Error("failed to dead-letter job, event dropped", slog.String("reason", reason),
+			slog.Int("payload_bytes", len(job.Payload)),
+			slog.String("error", err.Error()))
+		return
+	}
+	logger.Warn("job moved to dead letter queue", slog.Int("attempts", job.Attempt))
+}
+
+// Hands the log to the batch writer, or writes it now when there is none
+func (wp *WorkerPool) saveLog(ctx context.Context, entry store.ExecutionLog) error {
+	if wp.Logs != nil {
+		return wp.Logs.Log(ctx, entry)
+	}
+	return wp.Store.WriteExecutionLogs(ctx, []store.ExecutionLog{entry})
+}
+
+// Replaces oversized payloads with a small JSON preview so the log table stays bounded
+func (wp *WorkerPool) logPayload(body []byte) []byte {
+	max := wp.LogPayloadMaxBytes
+	if max <= 0 {
+		max = defaultLogPayloadMaxBytes
+	}
+	if len(body) <= max {
+		return body
+	}
+	payload.Metrics.Add("worker_log_truncated", 1)
+	return previewJSON(body, max/2)
+}
+
+// Drains the pool: stops taking jobs, hands queued and parked jobs back to the
+// broker, gives in-flight jobs ShutdownGrace to finish and then cancels them so
+// they are requeued too. Stop the consumer first so nothing new arrives
 func (wp *WorkerPool) Shutdown() {
 	wp.Logger.Info("Initializing worker pool shutdown")
+	grace := wp.ShutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+	close(wp.stopping)
+	requeued := wp.requeueWaiting()
 
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+	wp.Logger.Info("draining in-flight jobs",
+		slog.Int("busy", int(wp.busy.Load())),
+		slog.Int("requeued", requeued),
+		slog.Duration("grace_period", grace))
+	select {
+	case <-done:
+	case <-time.After(grace):
+		wp.Logger.Warn("grace period elapsed, cancelling in-flight jobs",
+			slog.Int("busy", int(wp.busy.Load())))
+		if wp.cancel != nil {
+			wp.cancel()
+		}
+		<-done
+	}
 	if wp.cancel != nil {
 		wp.cancel()
 	}
-	close(wp.JobQueue)
-	wp.wg.Wait()
-	wp.Logger.Info("Worker pool shutdown complete")
+	// The consumer may have handed over a last job while we were waiting
+	requeued += wp.requeueWaiting()
+	if wp.heartbeatsEnabled() {
+		wp.markStopped()
+	}
+	wp.Logger.Info("Worker pool shutdown complete", slog.Int("requeued", requeued))
+}
+
+// Hands every queued or parked job back to the broker. Returns how many
+func (wp *WorkerPool) requeueWaiting() int {
+	n := 0
+	for {
+		job, ok := wp.Queues.tryTake(n)
+		if !ok {
+			break
+		}
+		wp.handBack(job)
+		n++
+	}
+	for _, job := range wp.gate.takeParked() {
+		wp.handBack(job)
+		n++
+	}
+	return n
 }
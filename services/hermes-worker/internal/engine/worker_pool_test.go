@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+func TestShutdownRequeuesWaitingJobs(t *testing.T) {
+	wp := NewWorkerPool(0, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	wp.ShutdownGrace = time.Second
+	wp.Start(context.Background())
+
+	handedBack := 0
+	job := Job{RelayID: "r1", Defer: func(time.Duration) { handedBack++ }}
+	wp.Queues.Normal <- job
+	wp.Queues.Low <- job
+	wp.gate.admit(Job{RelayID: "r2"}, 1)
+	wp.gate.admit(Job{RelayID: "r2", Defer: job.Defer}, 1)
+
+	wp.Shutdown()
+	if handedBack != 3 {
+		t.Errorf("Expected 3 jobs handed back, got %d", handedBack)
+	}
+	if wp.Queues.Len() != 0 {
+		t.Errorf("Expected empty lanes after shutdown, got %d", wp.Queues.Len())
+	}
+}
+
+func TestFailurePayloadCarriesErrorAndEvent(t *testing.T) {
+	job := Job{RelayID: "r1", EventID: "e1", Attempt: 3, Payload: []byte(`{"n":1}`)}
+	cause := &ActionError{ActionType: "slack_send", OrderIndex: 2, Err: errors.New("slack returned 500")}
+	body, err := failurePayload(job, cause)
+	if err != nil {
+		t.Fatalf("failurePayload failed: %v", err)
+	}
+	var got FailurePayload
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("failure payload is not valid JSON: %v", err)
+	}
+	if !got.Failed || got.Error != "action slack_send (order 2) failed: slack returned 500" || got.Attempts != 3 {
+		t.Errorf("Unexpected failure payload %s", body)
+	}
+	if got.FailedAction == nil || got.FailedAction.ActionType != "slack_send" || got.FailedAction.OrderIndex != 2 {
+		t.Errorf("Expected the failed action to be named, got %+v", got.FailedAction)
+	}
+	if string(got.Payload) != `{"n":1}` {
+		t.Errorf("Expected the original payload, got %s", got.Payload)
+	}
+}
+
+func TestCallRelayRejectsCycles(t *testing.T) {
+	wp := NewWorkerPool(0, nil, NewRegistry(), logger.New("hermes-worker-test", "test", "error"))
+	job := Job{RelayID: "b", EventID: "e1", CallChain: []string{"a"}}
+	act := store.RelayAction{ID: "act", ActionType: ActionCallRelay, Config: map[string]any{"relay_id": "a"}}
+	if _, err := wp.callRelay(context.Background(), job, act, wp.Logger); !errors.Is(err, ErrRelayCycle) {
+		t.Errorf("Expected a call back to a caller to be refused, got %v", err)
+	}
+	act.Config["relay_id"] = "b"
+	if _, err := wp.callRelay(context.Background(), job, act, wp.Logger); !errors.Is(err, ErrRelayCycle) {
+		t.Errorf("Expected a relay calling itself to be refused, got %v", err)
+	}
+}
+
+func TestCallRelayEventIDIsStable(t *testing.T) {
+	job := Job{RelayID: "a", EventID: "e1"}
+	act := store.RelayAction{ID: "act"}
+	if callEventID(job, act) != callEventID(job, act) {
+		t.Error("Expected retries of the same event to call with the same event ID")
+	}
+	other := act
+	other.ID = "act2"
+	if callEventID(job, act) == callEventID(job, other) {
+		t.Error("Expected separate call_relay actions to use separate event IDs")
+	}
+}
+
+func TestParseCallRelay(t *testing.T) {
+	if _, err := parseCallRelay(map[string]any{}); err == nil {
+		t.Error("Expected relay_id to be required")
+	}
+	cfg, err := parseCallRelay(map[string]any{"relay_id": "r", "wait": true, "timeout": "1h",
+		"mappings": []any{map[string]any{"from": "a", "to": "b"}}})
+	if err != nil {
+		t.Fatalf("parseCallRelay failed: %v", err)
+	}
+	if !cfg.wait || cfg.timeout != maxCallTimeout || cfg.spec == nil {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
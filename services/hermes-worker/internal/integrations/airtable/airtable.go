@@ -0,0 +1,187 @@
+// Package airtable creates and updates records in an Airtable table, often
+// used as a lightweight ops log
+package airtable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL = "https://api.airtable.com/v0"
+	// Airtable locks out a base for 30 seconds after it answers 429
+	rateLimitPause = 30 * time.Second
+)
+
+var (
+	validBaseID   = regexp.MustCompile(`^app[A-Za-z0-9]{14}$`)
+	validRecordID = regexp.MustCompile(`^rec[A-Za-z0-9]{14}$`)
+)
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), url: apiURL}
+}
+
+// Breaker key: Airtable rate limits per base
+func (c *Client) Target(config map[string]any) string {
+	base, _ := config["base_id"].(string)
+	if !validBaseID.MatchString(base) {
+		return ""
+	}
+	return "api.airtable.com/" + base
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:AIRTABLE_TOKEN}}", "base_id": "appXXXXXXXXXXXXXX", "table": "Deploys",
+//	 "fields": {"Service": "{{repository.name}}", "SHA": "{{after}}", "Count": "{{commits.length}}"},
+//	 "typecast": true}
+//
+// fields maps column names to mapping templates filled from the payload;
+// non-string values are sent as they are. Rendered values are text, so
+// number, date and select columns need typecast. record_id (a template)
+// updates that record instead of creating one, and merge_on upserts on the
+// listed columns
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in airtable action config")
+	}
+	base, _ := config["base_id"].(string)
+	if !validBaseID.MatchString(base) {
+		return "", fmt.Errorf("missing or invalid base_id in airtable action config")
+	}
+	table, _ := config["table"].(string)
+	if table == "" {
+		return "", fmt.Errorf("missing table in airtable action config")
+	}
+	fields, err := renderFields(config, body)
+	if err != nil {
+		return "", err
+	}
+	record := map[string]any{"fields": fields}
+	req := map[string]any{}
+	if typecast, _ := config["typecast"].(bool); typecast {
+		req["typecast"] = true
+	}
+	method := http.MethodPost
+	if tmpl, _ := config["record_id"].(string); tmpl != "" {
+		id, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("record_id: %w", err)
+		}
+		if id = strings.TrimSpace(id); !validRecordID.MatchString(id) {
+			return "", &engine.PermanentError{Err: fmt.Errorf("invalid record_id %q", id)}
+		}
+		method = http.MethodPatch
+		record["id"] = id
+	} else if raw, ok := config["merge_on"]; ok {
+		columns, ok := raw.([]any)
+		if !ok || len(columns) == 0 {
+			return "", fmt.Errorf("merge_on must list the columns to match records on")
+		}
+		method = http.MethodPatch
+		req["performUpsert"] = map[string]any{"fieldsToMergeOn": columns}
+	}
+	req["records"] = []any{record}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal airtable request: %w", err)
+	}
+	endpoint := c.url + "/" + base + "/" + url.PathEscape(table)
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		Records []struct {
+			ID string `json:"id"`
+		} `json:"records"`
+		// Airtable sends either an object or a bare string here
+		Error json.RawMessage `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		var ids []string
+		for _, r := range result.Records {
+			ids = append(ids, r.ID)
+		}
+		return fmt.Sprintf("%d: %s", resp.StatusCode, strings.Join(ids, ", ")), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("airtable returned %d: %s", resp.StatusCode, errorDetail(result.Error)),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "", &engine.DeferError{Delay: rateLimitPause, Err: statusErr}
+	case resp.StatusCode >= 500:
+		return "", statusErr
+	}
+	// Unknown fields, bad values and missing tables fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func renderFields(config map[string]any, body []byte) (map[string]any, error) {
+	specs, ok := config["fields"].(map[string]any)
+	if !ok || len(specs) == 0 {
+		return nil, fmt.Errorf("missing fields in airtable action config")
+	}
+	fields := make(map[string]any, len(specs))
+	for name, raw := range specs {
+		tmpl, ok := raw.(string)
+		if !ok {
+			fields[name] = raw
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("fields.%s: %w", name, err)
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+func errorDetail(raw json.RawMessage) string {
+	var e struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &e) == nil {
+		return strings.TrimSpace(e.Type + " " + e.Message)
+	}
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	return s
+}
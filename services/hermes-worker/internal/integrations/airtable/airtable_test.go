@@ -0,0 +1,98 @@
+package airtable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const testBase = "appABCDEFGHIJKLMN"
+
+func TestCreatesAndUpdatesRecords(t *testing.T) {
+	var method, path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"type": "AUTHENTICATION_REQUIRED", "message": "Authentication required"}}`))
+			return
+		}
+		method, path = r.Method, r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA"}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "pat", "base_id": testBase, "table": "Ops Log", "typecast": true,
+		"fields": map[string]any{"Service": "{{service}}", "Count": "{{count}}", "Done": true}}
+	body := []byte(`{"service": "api", "count": 3, "record": "recBBBBBBBBBBBBBB"}`)
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil {
+		t.Fatalf("Expected the record to be created, got %v", err)
+	}
+	if method != http.MethodPost || path != "/"+testBase+"/Ops%20Log" || response != "200: recAAAAAAAAAAAAAA" {
+		t.Errorf("Unexpected request %s %s, response %q", method, path, response)
+	}
+	encoded, _ := json.Marshal(got)
+	want := `{"records":[{"fields":{"Count":"3","Done":true,"Service":"api"}}],"typecast":true}`
+	if string(encoded) != want {
+		t.Errorf("Expected %s, got %s", want, encoded)
+	}
+
+	config["record_id"] = "{{record}}"
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the record to be updated, got %v", err)
+	}
+	records := got["records"].([]any)
+	if method != http.MethodPatch || records[0].(map[string]any)["id"] != "recBBBBBBBBBBBBBB" {
+		t.Errorf("Expected a PATCH of the record, got %s %v", method, got)
+	}
+
+	delete(config, "record_id")
+	config["merge_on"] = []any{"Service"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the upsert to succeed, got %v", err)
+	}
+	if method != http.MethodPatch || got["performUpsert"] == nil {
+		t.Errorf("Expected an upsert, got %s %v", method, got)
+	}
+
+	config["token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "AUTHENTICATION_REQUIRED") {
+		t.Errorf("Expected a permanent authentication error, got %v", err)
+	}
+}
+
+func TestRateLimitDefers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "pat", "base_id": testBase, "table": "Log", "fields": map[string]any{"A": "x"}}
+	_, err := c.ExecuteWithResponse(context.Background(), config, nil)
+	var d *engine.DeferError
+	if !errors.As(err, &d) || d.Delay != rateLimitPause {
+		t.Errorf("Expected the job to wait out the rate limit, got %v", err)
+	}
+}
+
+func TestRejectsInvalidBaseID(t *testing.T) {
+	c := New(nil)
+	config := map[string]any{"token": "t", "base_id": "../evil", "table": "Log", "fields": map[string]any{"A": "x"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+		t.Error("Expected an invalid base_id to be rejected")
+	}
+}
@@ -0,0 +1,195 @@
+// Package amqp publishes events to RabbitMQ and other AMQP 0-9-1 brokers
+package amqp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Covers the TCP connect and the TLS and AMQP handshakes
+	handshakeTimeout = 10 * time.Second
+	confirmTimeout   = 10 * time.Second
+)
+
+type Publisher struct {
+	egress *engine.Egress
+
+	mu    sync.Mutex
+	conns map[string]*amqp.Connection
+}
+
+// Brokers are user supplied, so connections go through the egress policy
+func New(egress *engine.Egress) *Publisher {
+	return &Publisher{egress: egress, conns: map[string]*amqp.Connection{}}
+}
+
+// Breaker key: the broker, vhost and exchange, never the URL with its password
+func (p *Publisher) Target(config map[string]any) string {
+	raw, _ := config["url"].(string)
+	exchange, _ := config["exchange"].(string)
+	uri, err := amqp.ParseURI(raw)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d/%s/%s", uri.Host, uri.Port, url.PathEscape(uri.Vhost), exchange)
+}
+
+func (p *Publisher) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := p.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"url": "{{secret:AMQP_URL}}", "exchange": "events",
+//	 "routing_key": "github.{{repository.name}}.{{action}}", "mandatory": true}
+//
+// routing_key is a mapping template filled from the payload. The payload is
+// published as persistent application/json, unless persistent is false, and
+// the broker confirms it before the step succeeds. mandatory fails the step
+// when no queue is bound for the routing key. The event's idempotency key is
+// the message ID
+func (p *Publisher) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	raw, _ := config["url"].(string)
+	if raw == "" {
+		return "", fmt.Errorf("missing url in amqp action config")
+	}
+	// An empty exchange is the default exchange, routing straight to a queue
+	exchange, _ := config["exchange"].(string)
+	tmpl, _ := config["routing_key"].(string)
+	routingKey, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("routing_key: %w", err)
+	}
+	if exchange == "" && routingKey == "" {
+		return "", fmt.Errorf("amqp action config needs an exchange or a routing_key")
+	}
+	mandatory, _ := config["mandatory"].(bool)
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         body,
+	}
+	if persistent, ok := config["persistent"].(bool); ok && !persistent {
+		msg.DeliveryMode = amqp.Transient
+	}
+	if key, ok := idempotency.FromContext(ctx); ok {
+		msg.MessageId = key.Key
+	}
+
+	conn, err := p.conn(raw)
+	if err != nil {
+		return "", err
+	}
+	// A channel per publish keeps confirms and returns from interleaving
+	// between concurrent jobs
+	ch, err := conn.Channel()
+	if err != nil {
+		return "", classify(err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return "", classify(err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, mandatory, false, msg)
+	if err != nil {
+		return "", classify(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		return "", fmt.Errorf("waiting for publisher confirm: %w", err)
+	}
+	if !acked {
+		return "", fmt.Errorf("broker nacked the message")
+	}
+	// The broker sends basic.return ahead of the ack, so it is here by now
+	select {
+	case ret := <-returns:
+		return "", &engine.PermanentError{Err: fmt.Errorf("message returned unroutable: %d %s", ret.ReplyCode, ret.ReplyText)}
+	default:
+	}
+	return fmt.Sprintf("published to %q with routing key %q", exchange, routingKey), nil
+}
+
+// One connection per URL, reopened when it drops. Connections are keyed by a
+// hash so the password doesn't sit in a map key
+func (p *Publisher) conn(raw string) (*amqp.Connection, error) {
+	sum := sha256.Sum256([]byte(raw))
+	key := hex.EncodeToString(sum[:])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.conns[key]; ok && !conn.IsClosed() {
+		return conn, nil
+	}
+	if _, err := amqp.ParseURI(raw); err != nil {
+		// The parse error can echo the URL, keep it out of step logs
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid url in amqp action config, want amqp:// or amqps://")}
+	}
+	dial := p.egress.DialContext()
+	conn, err := amqp.DialConfig(raw, amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Dial: func(network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			c, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			// Cleared by the client once the AMQP handshake completes
+			if err := c.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		},
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+	p.conns[key] = conn
+	return conn, nil
+}
+
+// Closes every open connection
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conn := range p.conns {
+		_ = conn.Close()
+		delete(p.conns, key)
+	}
+}
+
+// Refused logins and vhosts, denied or missing exchanges and malformed
+// commands fail the same way every time. Connection drops may pass on retry
+func classify(err error) error {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		return err
+	}
+	wrapped := fmt.Errorf("amqp %d: %s", amqpErr.Code, strings.ToLower(amqpErr.Reason))
+	switch amqpErr.Code {
+	case amqp.AccessRefused, amqp.NotFound, amqp.NotAllowed, amqp.PreconditionFailed,
+		amqp.CommandInvalid, amqp.SyntaxError, amqp.FrameError:
+		return &engine.PermanentError{Err: wrapped}
+	}
+	return wrapped
+}
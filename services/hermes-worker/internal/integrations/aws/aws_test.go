@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Example request from the AWS Signature Version 4 documentation
+func TestSignV4MatchesAWSExample(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	SignV4(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestSQSSendsFIFOMessage(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "AmazonSQS.SendMessage" ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/aws4_request") {
+			t.Errorf("Expected a signed SendMessage call, got %v", r.Header)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"MessageId": "m-1"}`))
+	}))
+	defer srv.Close()
+
+	s := NewSQS(&engine.Egress{AllowPrivate: true})
+	s.endpoint = func(string) string { return srv.URL }
+	config := map[string]any{"queue_url": "https://sqs.eu-west-1.amazonaws.com/123456789012/hooks.fifo",
+		"access_key_id": "id", "secret_access_key": "secret",
+		"attributes": map[string]any{"event_type": "{{action}}"}, "message_group_id": "{{repo}}"}
+	ctx := idempotency.WithContext(context.Background(), idempotency.Value{Key: "k1"})
+	response, err := s.ExecuteWithResponse(ctx, config, []byte(`{"action": "opened", "repo": "octo/app"}`))
+	if err != nil || !strings.Contains(response, "m-1") {
+		t.Fatalf("Expected the message to be sent, got %s (%v)", response, err)
+	}
+	if got["MessageGroupId"] != "octo/app" || got["MessageDeduplicationId"] != "k1" {
+		t.Errorf("Expected the group from the payload and the idempotency key, got %v", got)
+	}
+	attrs, _ := got["MessageAttributes"].(map[string]any)
+	if attr, _ := attrs["event_type"].(map[string]any); attr["StringValue"] != "opened" {
+		t.Errorf("Expected the event_type attribute, got %v", attrs)
+	}
+}
+
+func TestSQSMissingQueueIsPermanent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "com.amazonaws.sqs#QueueDoesNotExist", "message": "The specified queue does not exist."}`))
+	}))
+	defer srv.Close()
+
+	s := NewSQS(&engine.Egress{AllowPrivate: true})
+	s.endpoint = func(string) string { return srv.URL }
+	config := map[string]any{"queue_url": "https://sqs.eu-west-1.amazonaws.com/123456789012/hooks",
+		"access_key_id": "id", "secret_access_key": "secret"}
+	_, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "QueueDoesNotExist") {
+		t.Errorf("Expected a permanent QueueDoesNotExist error, got %v", err)
+	}
+	config["queue_url"] = "https://evil.example.com/123456789012/hooks"
+	if _, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+		t.Error("Expected a queue URL off AWS to be rejected")
+	}
+}
+
+func TestSNSPublishesForm(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		form, _ = url.ParseQuery(string(data))
+		w.Write([]byte(`<PublishResponse><PublishResult><MessageId>m-2</MessageId></PublishResult></PublishResponse>`))
+	}))
+	defer srv.Close()
+
+	s := NewSNS(&engine.Egress{AllowPrivate: true})
+	s.endpoint = func(string) string { return srv.URL }
+	config := map[string]any{"topic_arn": "arn:aws:sns:us-east-1:123456789012:alerts",
+		"access_key_id": "id", "secret_access_key": "secret",
+		"subject": "{{title}}", "attributes": map[string]any{"severity": "{{level}}"}}
+	response, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{"title": "Disk full", "level": "high"}`))
+	if err != nil || !strings.Contains(response, "m-2") {
+		t.Fatalf("Expected the message to be published, got %s (%v)", response, err)
+	}
+	if form.Get("Action") != "Publish" || form.Get("Subject") != "Disk full" ||
+		form.Get("MessageAttributes.entry.1.Name") != "severity" ||
+		form.Get("MessageAttributes.entry.1.Value.StringValue") != "high" || form.Get("MessageGroupId") != "" {
+		t.Errorf("Unexpected publish form %v", form)
+	}
+}
+
+func TestSNSThrottlingIsRetryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error></ErrorResponse>`))
+	}))
+	defer srv.Close()
+
+	s := NewSNS(&engine.Egress{AllowPrivate: true})
+	s.endpoint = func(string) string { return srv.URL }
+	config := map[string]any{"topic_arn": "arn:aws:sns:us-east-1:123456789012:alerts.fifo",
+		"access_key_id": "id", "secret_access_key": "secret"}
+	_, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if err == nil || errors.As(err, &p) {
+		t.Errorf("Expected a retryable throttling error, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	// SQS and SNS both cap a message, attributes included, at 256 KB
+	maxMessageBytes = 256 * 1024
+	// Both allow 10 attributes; one is kept for the idempotency key
+	maxAttributes = 9
+	// FIFO group used when message_group_id isn't set, so every event is
+	// delivered in order
+	defaultGroupID = "hermes"
+)
+
+var validAttributeName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,256}$`)
+
+// Fields shared by the SQS and SNS actions
+type message struct {
+	Body       string
+	Attributes map[string]string
+	// Set for FIFO queues and topics only
+	GroupID         string
+	DeduplicationID string
+}
+
+// Builds the message from config: the message template or the payload itself,
+// templated attributes, and for FIFO destinations the group and deduplication
+// IDs. The deduplication ID is the event's idempotency key, so a retried step
+// or a redelivered event is dropped by AWS inside its five minute window
+func buildMessage(ctx context.Context, config map[string]any, body []byte, fifo bool) (*message, error) {
+	msg := &message{Body: string(body), Attributes: map[string]string{}}
+	if tmpl, _ := config["message"].(string); tmpl != "" {
+		rendered, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("message: %w", err)
+		}
+		msg.Body = rendered
+	}
+	if msg.Body == "" {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("message is empty")}
+	}
+	if raw, ok := config["attributes"]; ok {
+		attrs, ok := raw.(map[string]any)
+		if !ok || len(attrs) > maxAttributes {
+			return nil, fmt.Errorf("attributes must map at most %d names to templates", maxAttributes)
+		}
+		for name, value := range attrs {
+			tmpl, ok := value.(string)
+			if !ok || !validAttributeName.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "aws.") {
+				return nil, fmt.Errorf("invalid attribute %q", name)
+			}
+			rendered, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s: %w", name, err)
+			}
+			// AWS rejects empty attribute values
+			if rendered != "" {
+				msg.Attributes[name] = rendered
+			}
+		}
+	}
+	key, hasKey := idempotency.FromContext(ctx)
+	if hasKey {
+		msg.Attributes[idempotency.Header] = key.Key
+	}
+	if len(msg.Body) > maxMessageBytes {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("message is %d bytes, over the %d byte limit", len(msg.Body), maxMessageBytes)}
+	}
+	if !fifo {
+		return msg, nil
+	}
+	msg.GroupID = defaultGroupID
+	if tmpl, _ := config["message_group_id"].(string); tmpl != "" {
+		rendered, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("message_group_id: %w", err)
+		}
+		if msg.GroupID = strings.TrimSpace(rendered); msg.GroupID == "" {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("message_group_id rendered empty")}
+		}
+	}
+	if hasKey {
+		msg.DeduplicationID = key.Key
+	} else {
+		// Without an event ID, identical payloads count as duplicates
+		sum := sha256.Sum256(body)
+		msg.DeduplicationID = hex.EncodeToString(sum[:16])
+	}
+	return msg, nil
+}
+
+// Attribute names in a stable order, so requests are reproducible
+func (m *message) attributeNames() []string {
+	names := make([]string, 0, len(m.Attributes))
+	for name := range m.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Throttling and server errors may pass on retry. Anything else AWS rejects,
+// like a missing queue, denied access or an invalid attribute, fails every time
+func classify(service string, status int, code, detail string) error {
+	msg := fmt.Sprintf("%s returned %d", service, status)
+	if code != "" {
+		msg += ": " + code
+	}
+	if detail != "" {
+		msg += ": " + detail
+	}
+	err := &engine.StatusError{StatusCode: status, Msg: msg}
+	if status == http.StatusTooManyRequests || status >= 500 || strings.Contains(code, "Throttl") {
+		return err
+	}
+	return &engine.PermanentError{Err: err}
+}
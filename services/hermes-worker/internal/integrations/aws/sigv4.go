@@ -0,0 +1,34 @@
+// Package aws sends events to AWS messaging services. Requests are signed with
+// Signature Version 4, which the SES action shares
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/sigv4"
+)
+
+// Regions are part of the endpoint host, so only plain region names pass
+var ValidRegion = sigv4.ValidRegion
+
+type Credentials = sigv4.Credentials
+
+// Reads access_key_id, secret_access_key and the optional session_token of
+// an action config
+func CredentialsFrom(config map[string]any, action string) (Credentials, error) {
+	creds := Credentials{}
+	creds.AccessKeyID, _ = config["access_key_id"].(string)
+	creds.SecretAccessKey, _ = config["secret_access_key"].(string)
+	creds.SessionToken, _ = config["session_token"].(string)
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("missing access_key_id or secret_access_key in %s action config", action)
+	}
+	return creds, nil
+}
+
+// Signs req with AWS Signature Version 4
+func SignV4(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time) {
+	sigv4.Sign(req, body, creds, region, service, now)
+}
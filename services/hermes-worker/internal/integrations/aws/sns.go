@@ -0,0 +1,138 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Topic ARNs name the region the request is signed for
+var validTopicARN = regexp.MustCompile(`^arn:aws:sns:([a-z]{2}(?:-[a-z]+)+-\d):\d{12}:[A-Za-z0-9_-]{1,256}(\.fifo)?$`)
+
+// SNS caps email subjects at 100 characters
+const maxSubjectLength = 100
+
+type SNS struct {
+	client *http.Client
+	// Endpoint for a region, overridden in tests
+	endpoint func(region string) string
+	now      func() time.Time
+}
+
+func NewSNS(egress *engine.Egress) *SNS {
+	return &SNS{
+		client: egress.Client(10 * time.Second),
+		endpoint: func(region string) string {
+			return "https://sns." + region + ".amazonaws.com"
+		},
+		now: time.Now,
+	}
+}
+
+// Breaker key: the topic
+func (s *SNS) Target(config map[string]any) string {
+	arn, _ := config["topic_arn"].(string)
+	if !validTopicARN.MatchString(arn) {
+		return ""
+	}
+	return arn
+}
+
+func (s *SNS) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"topic_arn": "arn:aws:sns:eu-west-1:123456789012:webhooks",
+//	 "access_key_id": "{{secret:AWS_KEY_ID}}", "secret_access_key": "{{secret:AWS_SECRET}}",
+//	 "subject": "{{alert.title}}", "attributes": {"severity": "{{alert.severity}}"}}
+//
+// message, subject and attributes work as on the sqs action, subject being
+// used by email subscriptions. FIFO topics get message_group_id and the
+// event's idempotency key as the deduplication ID
+func (s *SNS) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	arn, _ := config["topic_arn"].(string)
+	match := validTopicARN.FindStringSubmatch(arn)
+	if match == nil {
+		return "", fmt.Errorf("missing or invalid topic_arn in sns action config")
+	}
+	region, fifo := match[1], match[2] != ""
+	creds, err := CredentialsFrom(config, "sns")
+	if err != nil {
+		return "", err
+	}
+	msg, err := buildMessage(ctx, config, body, fifo)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {arn},
+		"Message":  {msg.Body},
+	}
+	if tmpl, _ := config["subject"].(string); tmpl != "" {
+		subject, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("subject: %w", err)
+		}
+		// Subjects are a single line of at most 100 characters
+		subject = strings.Join(strings.Fields(subject), " ")
+		if runes := []rune(subject); len(runes) > maxSubjectLength {
+			subject = string(runes[:maxSubjectLength])
+		}
+		if subject != "" {
+			form.Set("Subject", subject)
+		}
+	}
+	for n, name := range msg.attributeNames() {
+		prefix := "MessageAttributes.entry." + strconv.Itoa(n+1)
+		form.Set(prefix+".Name", name)
+		form.Set(prefix+".Value.DataType", "String")
+		form.Set(prefix+".Value.StringValue", msg.Attributes[name])
+	}
+	if fifo {
+		form.Set("MessageGroupId", msg.GroupID)
+		form.Set("MessageDeduplicationId", msg.DeduplicationID)
+	}
+
+	encoded := []byte(form.Encode())
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint(region)+"/", bytes.NewReader(encoded))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	SignV4(httpReq, encoded, creds, region, "sns", s.now())
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		var result struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		_ = xml.Unmarshal(data, &result)
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), classify("sns", resp.StatusCode, result.Code, result.Message)
+	}
+	var result struct {
+		MessageID string `xml:"PublishResult>MessageId"`
+	}
+	_ = xml.Unmarshal(data, &result)
+	return fmt.Sprintf("%d: message %s", resp.StatusCode, result.MessageID), nil
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Queue URLs name the region, account and queue; only AWS's own hosts pass,
+// since the request is signed with the action's credentials
+var validQueueURL = regexp.MustCompile(`^https://sqs\.([a-z]{2}(?:-[a-z]+)+-\d)\.amazonaws\.com/\d{12}/[A-Za-z0-9_-]{1,80}(\.fifo)?$`)
+
+type SQS struct {
+	client *http.Client
+	// Endpoint for a region, overridden in tests
+	endpoint func(region string) string
+	now      func() time.Time
+}
+
+func NewSQS(egress *engine.Egress) *SQS {
+	return &SQS{
+		client: egress.Client(10 * time.Second),
+		endpoint: func(region string) string {
+			return "https://sqs." + region + ".amazonaws.com"
+		},
+		now: time.Now,
+	}
+}
+
+// Breaker key: the queue
+func (s *SQS) Target(config map[string]any) string {
+	queueURL, _ := config["queue_url"].(string)
+	if !validQueueURL.MatchString(queueURL) {
+		return ""
+	}
+	return strings.TrimPrefix(queueURL, "https://")
+}
+
+func (s *SQS) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"queue_url": "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks.fifo",
+//	 "access_key_id": "{{secret:AWS_KEY_ID}}", "secret_access_key": "{{secret:AWS_SECRET}}",
+//	 "attributes": {"event_type": "{{action}}"}, "message_group_id": "{{repository.full_name}}"}
+//
+// message is an optional mapping template; without it the payload is sent.
+// attributes are string message attributes filled from the payload. FIFO
+// queues get message_group_id (one shared group by default) and the event's
+// idempotency key as the deduplication ID
+func (s *SQS) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	queueURL, _ := config["queue_url"].(string)
+	match := validQueueURL.FindStringSubmatch(queueURL)
+	if match == nil {
+		return "", fmt.Errorf("missing or invalid queue_url in sqs action config")
+	}
+	region, fifo := match[1], match[2] != ""
+	creds, err := CredentialsFrom(config, "sqs")
+	if err != nil {
+		return "", err
+	}
+	msg, err := buildMessage(ctx, config, body, fifo)
+	if err != nil {
+		return "", err
+	}
+	req := map[string]any{"QueueUrl": queueURL, "MessageBody": msg.Body}
+	if len(msg.Attributes) > 0 {
+		attrs := make(map[string]any, len(msg.Attributes))
+		for name, value := range msg.Attributes {
+			attrs[name] = map[string]string{"DataType": "String", "StringValue": value}
+		}
+		req["MessageAttributes"] = attrs
+	}
+	if fifo {
+		req["MessageGroupId"] = msg.GroupID
+		req["MessageDeduplicationId"] = msg.DeduplicationID
+	}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal sqs request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint(region)+"/", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	httpReq.Header.Set("X-Amz-Target", "AmazonSQS.SendMessage")
+	SignV4(httpReq, jsonBody, creds, region, "sqs", s.now())
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		MessageID string `json:"MessageId"`
+		Type      string `json:"__type"`
+		Message   string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 {
+		// "com.amazonaws.sqs#QueueDoesNotExist" names the error after the #
+		code := result.Type[strings.LastIndex(result.Type, "#")+1:]
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), classify("sqs", resp.StatusCode, code, result.Message)
+	}
+	return fmt.Sprintf("%d: message %s", resp.StatusCode, result.MessageID), nil
+}
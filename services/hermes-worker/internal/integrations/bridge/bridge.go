@@ -0,0 +1,183 @@
+// Package bridge hands events to IFTTT Webhooks applets and Zapier Catch
+// Hooks in the shapes those services expect
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	iftttURL  = "https://maker.ifttt.com"
+	zapierURL = "https://hooks.zapier.com"
+	// IFTTT drops ingredients much past this
+	maxValueBytes = 2000
+)
+
+var (
+	// Event names as IFTTT accepts them in the trigger URL
+	validEvent = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+	// Catch Hook paths: account ID, then hook ID
+	validZapierPath = regexp.MustCompile(`^/hooks/catch/\d+/[A-Za-z0-9]+/?$`)
+)
+
+type Client struct {
+	client *http.Client
+	// Service base URLs, overridden in tests
+	iftttURL  string
+	zapierURL string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), iftttURL: iftttURL, zapierURL: zapierURL}
+}
+
+// Breaker key: the applet event or the Zap's hook, never the IFTTT key
+func (c *Client) Target(config map[string]any) string {
+	switch service, _ := config["service"].(string); service {
+	case "ifttt":
+		if event, _ := config["event"].(string); validEvent.MatchString(event) {
+			return "maker.ifttt.com/" + event
+		}
+	case "zapier":
+		if path, err := zapierPath(config); err == nil {
+			return "hooks.zapier.com" + path
+		}
+	}
+	return ""
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"service": "ifttt", "event": "deploy_finished", "key": "{{secret:IFTTT_KEY}}",
+//	 "value1": "{{repository.name}}", "value2": "{{sha}}", "value3": "{{status}}"}
+//	{"service": "zapier", "hook_url": "https://hooks.zapier.com/hooks/catch/123456/abcdef/",
+//	 "mappings": [{"from": "pull_request.title", "to": "title"}]}
+//
+// IFTTT gets the value1-value3 ingredients, each a mapping template filled
+// from the payload; without any of them value1 is the payload itself. Zapier
+// gets the payload as it is, or reshaped first when mappings (and
+// keep_unmapped) are set as on a map action
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	var endpoint string
+	var jsonBody []byte
+	var err error
+	switch service, _ := config["service"].(string); service {
+	case "ifttt":
+		endpoint, jsonBody, err = c.iftttRequest(config, body)
+	case "zapier":
+		endpoint, jsonBody, err = c.zapierRequest(config, body)
+	default:
+		return "", fmt.Errorf("service must be ifttt or zapier in webhook_bridge action config, got %q", service)
+	}
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), nil
+	}
+	// IFTTT's error bodies quote the request URL, key included
+	detail := string(data)
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(data, &result) == nil && len(result.Errors) > 0 {
+		detail = result.Errors[0].Message
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("webhook_bridge returned %d: %s", resp.StatusCode, payload.TruncateString(detail, 200)),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Bad keys and deleted hooks fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func (c *Client) iftttRequest(config map[string]any, body []byte) (string, []byte, error) {
+	event, _ := config["event"].(string)
+	if !validEvent.MatchString(event) {
+		return "", nil, fmt.Errorf("missing or invalid event in webhook_bridge action config")
+	}
+	key, _ := config["key"].(string)
+	if key == "" {
+		return "", nil, fmt.Errorf("missing key in webhook_bridge action config")
+	}
+	values := map[string]string{}
+	for _, name := range []string{"value1", "value2", "value3"} {
+		tmpl, ok := config[name].(string)
+		if !ok {
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values[name] = payload.TruncateString(value, maxValueBytes)
+	}
+	if len(values) == 0 {
+		values["value1"] = payload.TruncateString(string(body), maxValueBytes)
+	}
+	jsonBody, err := json.Marshal(values)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshal ifttt values: %w", err)
+	}
+	return c.iftttURL + "/trigger/" + event + "/with/key/" + url.PathEscape(key), jsonBody, nil
+}
+
+func (c *Client) zapierRequest(config map[string]any, body []byte) (string, []byte, error) {
+	path, err := zapierPath(config)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, ok := config["mappings"]; ok {
+		spec, err := mapping.ParseSpec(config)
+		if err != nil {
+			return "", nil, err
+		}
+		if body, err = mapping.Apply(spec, body); err != nil {
+			return "", nil, &engine.PermanentError{Err: err}
+		}
+	}
+	return c.zapierURL + path, body, nil
+}
+
+// Only Catch Hook URLs are accepted, so the action can't post elsewhere
+func zapierPath(config map[string]any) (string, error) {
+	raw, _ := config["hook_url"].(string)
+	hook, err := url.Parse(raw)
+	if err != nil || hook.Scheme != "https" || hook.Host != "hooks.zapier.com" || !validZapierPath.MatchString(hook.Path) {
+		return "", fmt.Errorf("hook_url must be a https://hooks.zapier.com/hooks/catch/... URL")
+	}
+	return hook.Path, nil
+}
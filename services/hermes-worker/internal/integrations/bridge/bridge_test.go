@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestIFTTTValues(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if !strings.HasSuffix(path, "/with/key/k3y") {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors": [{"message": "You sent an invalid key."}]}`))
+			return
+		}
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`Congratulations! You've fired the deploy_finished event`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.iftttURL = srv.URL
+	body := []byte(`{"repo": "hermes", "sha": "abc"}`)
+	config := map[string]any{"service": "ifttt", "event": "deploy_finished", "key": "k3y",
+		"value1": "{{repo}}", "value2": "{{sha}}"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the applet to fire, got %v", err)
+	}
+	if path != "/trigger/deploy_finished/with/key/k3y" || got["value1"] != "hermes" || got["value2"] != "abc" || got["value3"] != nil {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+
+	delete(config, "value1")
+	delete(config, "value2")
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the applet to fire, got %v", err)
+	}
+	if got["value1"] != string(body) {
+		t.Errorf("Expected the payload as value1, got %v", got)
+	}
+
+	config["key"] = "wrong"
+	_, err := c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "invalid key") {
+		t.Errorf("Expected a bad key to fail permanently, got %v", err)
+	}
+	if target := c.Target(config); target != "maker.ifttt.com/deploy_finished" {
+		t.Errorf("Unexpected target %q", target)
+	}
+}
+
+func TestZapierMappings(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"status": "success", "id": "1"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.zapierURL = srv.URL
+	config := map[string]any{"service": "zapier", "hook_url": "https://hooks.zapier.com/hooks/catch/123456/abcdef/",
+		"mappings": []any{map[string]any{"from": "pull_request.title", "to": "title"}}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"pull_request": {"title": "Fix"}}`)); err != nil {
+		t.Fatalf("Expected the hook to be caught, got %v", err)
+	}
+	if path != "/hooks/catch/123456/abcdef/" || got["title"] != "Fix" || len(got) != 1 {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"service": "make", "hook_url": "https://hook.make.com/x"},
+		{"service": "ifttt", "event": "a/b", "key": "k"},
+		{"service": "ifttt", "event": "deploy"},
+		{"service": "zapier", "hook_url": "https://evil.example.com/hooks/catch/1/a/"},
+		{"service": "zapier", "hook_url": "http://hooks.zapier.com/hooks/catch/1/a/"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const buildkiteURL = "https://api.buildkite.com/v2"
+
+// Organization and pipeline slugs
+var validSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type Buildkite struct {
+	client *http.Client
+	url    string
+}
+
+func NewBuildkite(egress *engine.Egress) *Buildkite {
+	return &Buildkite{client: egress.Client(10 * time.Second), url: buildkiteURL}
+}
+
+// Breaker key: the pipeline
+func (b *Buildkite) Target(config map[string]any) string {
+	org, _ := config["organization"].(string)
+	pipeline, _ := config["pipeline"].(string)
+	if !validSlug.MatchString(org) || !validSlug.MatchString(pipeline) {
+		return ""
+	}
+	return "buildkite.com/" + org + "/" + pipeline
+}
+
+func (b *Buildkite) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := b.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:BUILDKITE_TOKEN}}", "organization": "acme", "pipeline": "deploy",
+//	 "branch": "{{ref_name}}", "commit": "{{after}}", "message": "Triggered by {{sender.login}}",
+//	 "env": {"DEPLOY_ENV": "prod"}, "meta_data": {"source": "hermes"}}
+//
+// branch, commit and message are mapping templates filled from the payload,
+// as are the values in env and meta_data. branch is required; commit
+// defaults to HEAD
+func (b *Buildkite) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in buildkite action config")
+	}
+	org, _ := config["organization"].(string)
+	pipeline, _ := config["pipeline"].(string)
+	if !validSlug.MatchString(org) || !validSlug.MatchString(pipeline) {
+		return "", fmt.Errorf("missing or invalid organization or pipeline in buildkite action config")
+	}
+	req := map[string]any{"commit": "HEAD"}
+	for _, key := range []string{"branch", "commit", "message"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			req[key] = value
+		}
+	}
+	if req["branch"] == nil {
+		return "", fmt.Errorf("missing branch in buildkite action config")
+	}
+	for _, key := range []string{"env", "meta_data"} {
+		fields, err := renderObject(config, key, body)
+		if err != nil {
+			return "", err
+		}
+		if len(fields) > 0 {
+			req[key] = fields
+		}
+	}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal buildkite request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		b.url+"/organizations/"+org+"/pipelines/"+pipeline+"/builds", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		Number  int    `json:"number"`
+		WebURL  string `json:"web_url"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 {
+		return "", classify("buildkite", resp.StatusCode, result.Message)
+	}
+	return fmt.Sprintf("%d: build %d %s", resp.StatusCode, result.Number, result.WebURL), nil
+}
@@ -0,0 +1,64 @@
+// Package ci starts pipelines on hosted CI services, CircleCI and Buildkite,
+// so events from elsewhere can kick off builds
+package ci
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Renders the templates in an object's string values, leaving numbers and
+// booleans as they are
+func renderObject(config map[string]any, key string, body []byte) (map[string]any, error) {
+	raw, ok := config[key]
+	if !ok {
+		return nil, nil
+	}
+	fields, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", key)
+	}
+	out := make(map[string]any, len(fields))
+	for name, value := range fields {
+		switch v := value.(type) {
+		case string:
+			rendered, err := mapping.Render(v, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", key, name, err)
+			}
+			out[name] = rendered
+		case float64, bool:
+			out[name] = v
+		default:
+			return nil, fmt.Errorf("%s.%s must be a string, number or boolean", key, name)
+		}
+	}
+	return out, nil
+}
+
+// Throttling and server errors are worth retrying; any other 4xx (bad token,
+// unknown project, undeclared parameter) fails the same way every time
+func classify(provider string, status int, detail string) error {
+	msg := fmt.Sprintf("%s returned %d", provider, status)
+	if detail != "" {
+		msg += ": " + detail
+	}
+	err := &engine.StatusError{StatusCode: status, Msg: msg}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return err
+	}
+	return &engine.PermanentError{Err: err}
+}
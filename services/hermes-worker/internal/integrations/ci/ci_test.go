@@ -0,0 +1,111 @@
+package ci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestCircleCIPipeline(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Circle-Token") != "cct" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Invalid token provided."}`))
+			return
+		}
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 42, "state": "created"}`))
+	}))
+	defer srv.Close()
+
+	c := NewCircleCI(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "cct", "project_slug": "gh/acme/app", "branch": "{{ref}}",
+		"parameters": map[string]any{"deploy_sha": "{{after}}", "run_e2e": true}}
+	response, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"ref": "main", "after": "abc"}`))
+	if err != nil {
+		t.Fatalf("Expected the pipeline to start, got %v", err)
+	}
+	params, _ := got["parameters"].(map[string]any)
+	if path != "/project/gh/acme/app/pipeline" || got["branch"] != "main" || params["deploy_sha"] != "abc" || params["run_e2e"] != true {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+	if response != "201: pipeline 42 created" {
+		t.Errorf("Unexpected response %q", response)
+	}
+
+	config["token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, []byte(`{"ref": "main", "after": "abc"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestBuildkiteBuild(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		if r.Header.Get("Authorization") != "Bearer bkt" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 7, "web_url": "https://buildkite.com/acme/deploy/builds/7"}`))
+	}))
+	defer srv.Close()
+
+	b := NewBuildkite(&engine.Egress{AllowPrivate: true})
+	b.url = srv.URL
+	config := map[string]any{"token": "bkt", "organization": "acme", "pipeline": "deploy", "branch": "main",
+		"message": "Triggered by {{user}}", "env": map[string]any{"DEPLOY_ENV": "prod"}}
+	if _, err := b.ExecuteWithResponse(context.Background(), config, []byte(`{"user": "ada"}`)); err != nil {
+		t.Fatalf("Expected the build to start, got %v", err)
+	}
+	env, _ := got["env"].(map[string]any)
+	if path != "/organizations/acme/pipelines/deploy/builds" || got["commit"] != "HEAD" ||
+		got["message"] != "Triggered by ada" || env["DEPLOY_ENV"] != "prod" {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+
+	config["token"] = "throttled"
+	_, err := b.ExecuteWithResponse(context.Background(), config, []byte(`{"user": "ada"}`))
+	var p *engine.PermanentError
+	if err == nil || errors.As(err, &p) {
+		t.Errorf("Expected throttling to be retryable, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := NewCircleCI(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"token": "t", "project_slug": "acme/app"},
+		{"project_slug": "gh/acme/app"},
+		{"token": "t", "project_slug": "gh/acme/app", "branch": "main", "tag": "v1"},
+		{"token": "t", "project_slug": "gh/acme/app", "parameters": map[string]any{"x": []any{}}},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+	b := NewBuildkite(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"token": "t", "organization": "Acme/..", "pipeline": "deploy", "branch": "main"},
+		{"token": "t", "organization": "acme", "pipeline": "deploy"},
+	} {
+		if _, err := b.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
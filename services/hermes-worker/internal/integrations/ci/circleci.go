@@ -0,0 +1,109 @@
+package ci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const circleCIURL = "https://circleci.com/api/v2"
+
+// Project slugs: gh/org/repo, bb/org/repo or circleci/<org id>/<project id>
+var validProjectSlug = regexp.MustCompile(`^(gh|github|bb|bitbucket|circleci)/[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+type CircleCI struct {
+	client *http.Client
+	url    string
+}
+
+func NewCircleCI(egress *engine.Egress) *CircleCI {
+	return &CircleCI{client: egress.Client(10 * time.Second), url: circleCIURL}
+}
+
+// Breaker key: the project
+func (c *CircleCI) Target(config map[string]any) string {
+	slug, _ := config["project_slug"].(string)
+	if !validProjectSlug.MatchString(slug) {
+		return ""
+	}
+	return "circleci.com/" + slug
+}
+
+func (c *CircleCI) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:CIRCLE_TOKEN}}", "project_slug": "gh/acme/app",
+//	 "branch": "{{ref_name}}", "parameters": {"deploy_sha": "{{after}}", "run_e2e": true}}
+//
+// branch and tag are mapping templates filled from the payload; set one or
+// neither for the default branch. parameters must be declared in the
+// project's config; string values are templates too
+func (c *CircleCI) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in circleci action config")
+	}
+	slug, _ := config["project_slug"].(string)
+	if !validProjectSlug.MatchString(slug) {
+		return "", fmt.Errorf("missing or invalid project_slug in circleci action config, want e.g. gh/acme/app")
+	}
+	req := map[string]any{}
+	for _, key := range []string{"branch", "tag"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			req[key] = value
+		}
+	}
+	if req["branch"] != nil && req["tag"] != nil {
+		return "", fmt.Errorf("circleci action config takes branch or tag, not both")
+	}
+	params, err := renderObject(config, "parameters", body)
+	if err != nil {
+		return "", err
+	}
+	if len(params) > 0 {
+		req["parameters"] = params
+	}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal circleci request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.url+"/project/"+slug+"/pipeline", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Circle-Token", token)
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		Number  int    `json:"number"`
+		State   string `json:"state"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 {
+		return "", classify("circleci", resp.StatusCode, result.Message)
+	}
+	return fmt.Sprintf("%d: pipeline %d %s", resp.StatusCode, result.Number, result.State), nil
+}
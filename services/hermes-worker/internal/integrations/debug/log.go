@@ -3,19 +3,23 @@ package debug
 import (
 	"context"
 	"log"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
 )
 
+const maxLoggedBytes = 4096
+
 type LogExecutor struct{}
 
 func New() *LogExecutor {
 	return &LogExecutor{}
 }
 
-func (l *LogExecutor) Execute(ctx context.Context, config map[string]any, payload []byte) error {
+func (l *LogExecutor) Execute(ctx context.Context, config map[string]any, body []byte) error {
 	prefix, _ := config["prefix"].(string)
 	if prefix == "" {
 		prefix = "DEBUG_LOG"
 	}
-	log.Printf("[%s] Payload Received: %s", prefix, string(payload))
+	log.Printf("[%s] Payload Received: %s", prefix, payload.TruncateString(string(body), maxLoggedBytes))
 	return nil
 }
@@ -0,0 +1,15 @@
+package debug
+
+import "context"
+
+// Succeeds without doing anything. The canary relay runs it to prove events
+// make it from hermes-hooks through the broker to a worker
+type NoopExecutor struct{}
+
+func NewNoop() *NoopExecutor {
+	return &NoopExecutor{}
+}
+
+func (n *NoopExecutor) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	return nil
+}
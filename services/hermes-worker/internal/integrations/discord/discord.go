@@ -5,41 +5,212 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	// Discord caps message content at 2000 characters
+	maxContentBytes = 1900
+	// Discord accepts at most 10 embeds per message
+	maxEmbeds   = 10
+	maxAttempts = 3
+	// Rate limits longer than this defer the job instead of holding a worker
+	maxRetryWait = 5 * time.Second
 )
 
 type DiscordSender struct {
 	client *http.Client
 }
 
-func New() *DiscordSender {
+// Webhook URLs are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *DiscordSender {
 	return &DiscordSender{
-		client: &http.Client{Timeout: 5 * time.Second},
+		client: egress.Client(5 * time.Second),
 	}
 }
 
-func (d *DiscordSender) Execute(ctx context.Context, config map[string]any, payload []byte) error {
-	url, ok := config["webhook_url"].(string)
-	if !ok || url == "" {
-		return fmt.Errorf("Missing webhook_url in relay config")
+// Breaker key: failures are tracked per webhook
+func (d *DiscordSender) Target(config map[string]any) string {
+	return engine.EndpointOf(config, "webhook_url")
+}
+
+func (d *DiscordSender) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := d.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"webhook_url": "https://discord.com/api/webhooks/...",
+//	 "content": "{{repository.name}} deployed", "username": "Hermes", "avatar_url": "https://...",
+//	 "embeds": [{"title": "{{head_commit.message}}", "color": 5814783}]}
+//
+// Strings in content and embeds are mapping templates filled from the payload.
+// Without content or embeds the payload itself is posted
+func (d *DiscordSender) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	webhookURL, ok := config["webhook_url"].(string)
+	if !ok || webhookURL == "" {
+		return "", fmt.Errorf("Missing webhook_url in relay config")
 	}
-	msg := map[string]string{
-		"content": fmt.Sprintf("Relay Trigerred\n```json\n%s\n```", string(payload)),
+	msg, err := buildMessage(config, body)
+	if err != nil {
+		return "", err
 	}
-	jsonBody, _ := json.Marshal(msg)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	jsonBody, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("marshal discord message: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := d.client.Do(req)
+	// wait=true makes Discord answer with the created message instead of 204
+	target, err := url.Parse(webhookURL)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("invalid webhook_url: %w", err)
+	}
+	query := target.Query()
+	query.Set("wait", "true")
+	target.RawQuery = query.Encode()
+
+	var response string
+	var lastErr error
+	for attempt := range maxAttempts {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(jsonBody))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := d.client.Do(req)
+		wait := backoff(attempt)
+		if err != nil {
+			lastErr = err
+		} else {
+			limit := retryAfter(resp)
+			response = engine.ReadResponse(resp)
+			switch {
+			case resp.StatusCode < 300:
+				return response, nil
+			case resp.StatusCode == http.StatusTooManyRequests:
+				lastErr = &engine.StatusError{StatusCode: resp.StatusCode, Msg: "Discord rate limited the webhook"}
+				if limit > maxRetryWait {
+					return response, &engine.DeferError{Delay: limit, Err: lastErr}
+				}
+				wait = limit
+			case resp.StatusCode >= 500:
+				lastErr = &engine.StatusError{StatusCode: resp.StatusCode, Msg: fmt.Sprintf("Discord API error: %d", resp.StatusCode)}
+			default:
+				return response, &engine.StatusError{
+					StatusCode: resp.StatusCode,
+					Msg:        fmt.Sprintf("Discord API error: %d", resp.StatusCode),
+				}
+			}
+		}
+		if attempt < maxAttempts-1 && !sleep(ctx, wait) {
+			return response, ctx.Err()
+		}
+	}
+	return response, fmt.Errorf("discord send failed after retries: %w", lastErr)
+}
+
+func buildMessage(config map[string]any, body []byte) (map[string]any, error) {
+	msg := map[string]any{}
+	for _, key := range []string{"username", "avatar_url"} {
+		if value, _ := config[key].(string); value != "" {
+			msg[key] = value
+		}
+	}
+	if tmpl, _ := config["content"].(string); tmpl != "" {
+		content, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("content: %w", err)
+		}
+		msg["content"] = payload.TruncateString(content, maxContentBytes)
+	}
+	if raw, ok := config["embeds"]; ok {
+		embeds, isList := raw.([]any)
+		if !isList || len(embeds) > maxEmbeds {
+			return nil, fmt.Errorf("embeds must be a list of at most %d objects", maxEmbeds)
+		}
+		rendered, err := renderStrings(embeds, body)
+		if err != nil {
+			return nil, fmt.Errorf("embeds: %w", err)
+		}
+		msg["embeds"] = rendered
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode > 400 {
-		return fmt.Errorf("Discord API error: %d", resp.StatusCode)
+	if msg["content"] == nil && msg["embeds"] == nil {
+		preview, _ := payload.Truncate(body, maxContentBytes)
+		msg["content"] = fmt.Sprintf("Relay Trigerred\n```json\n%s\n```", string(preview))
+	}
+	return msg, nil
+}
+
+// Fills the templates in every string of an embed, leaving other values alone
+func renderStrings(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// How long Discord asks us to wait, from the Retry-After header or the
+// retry_after field of a 429 body. Leaves the body readable
+func retryAfter(resp *http.Response) time.Duration {
+	if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	var limited struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if json.Unmarshal(data, &limited) == nil && limited.RetryAfter > 0 {
+		return time.Duration(limited.RetryAfter * float64(time.Second))
+	}
+	return time.Second
+}
+
+func backoff(attempt int) time.Duration {
+	return time.Duration(200*(attempt+1)) * time.Millisecond
+}
+
+// Waits for d unless ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	return nil
 }
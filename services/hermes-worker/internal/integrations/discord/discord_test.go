@@ -0,0 +1,73 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestBuildMessageRendersTemplates(t *testing.T) {
+	config := map[string]any{
+		"content":  "{{repo}} deployed",
+		"username": "Hermes",
+		"embeds":   []any{map[string]any{"title": "{{commit.message}}", "color": float64(5814783)}},
+	}
+	msg, err := buildMessage(config, []byte(`{"repo": "hermes", "commit": {"message": "Fix it"}}`))
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	got, _ := json.Marshal(msg)
+	expected := `{"content":"hermes deployed","embeds":[{"color":5814783,"title":"Fix it"}],"username":"Hermes"}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if _, err := buildMessage(map[string]any{"embeds": "nope"}, nil); err == nil {
+		t.Error("Expected embeds that aren't a list to be rejected")
+	}
+}
+
+func TestSendRetriesAfterRateLimit(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("wait") != "true" {
+			t.Error("Expected wait=true on the webhook call")
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.05}`))
+			return
+		}
+		w.Write([]byte(`{"id": "1"}`))
+	}))
+	defer srv.Close()
+
+	d := New(&engine.Egress{AllowPrivate: true})
+	if _, err := d.ExecuteWithResponse(context.Background(), map[string]any{"webhook_url": srv.URL}, []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls)
+	}
+}
+
+func TestSendDefersLongRateLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	d := New(&engine.Egress{AllowPrivate: true})
+	_, err := d.ExecuteWithResponse(context.Background(), map[string]any{"webhook_url": srv.URL}, []byte(`{}`))
+	var deferErr *engine.DeferError
+	if !errors.As(err, &deferErr) || deferErr.Delay != 30*time.Second {
+		t.Errorf("Expected the job deferred for 30s, got %v", err)
+	}
+}
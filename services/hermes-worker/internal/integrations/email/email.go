@@ -0,0 +1,132 @@
+// Package email sends mail through managed providers (SendGrid, Amazon SES)
+// for deployments that can't run raw SMTP
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Fields both providers share. subject, text and html are mapping templates
+// filled from the payload. With template set the provider's stored template
+// renders the mail from the payload instead
+type message struct {
+	From     string
+	FromName string
+	To       []string
+	Cc       []string
+	Bcc      []string
+	ReplyTo  string
+	Subject  string
+	Text     string
+	HTML     string
+	Template string
+}
+
+func parseMessage(config map[string]any, body []byte, templateKey string) (*message, error) {
+	msg := &message{}
+	msg.From, _ = config["from"].(string)
+	if msg.From == "" {
+		return nil, fmt.Errorf("missing from in email action config")
+	}
+	msg.FromName, _ = config["from_name"].(string)
+	msg.ReplyTo, _ = config["reply_to"].(string)
+	var err error
+	if msg.To, err = recipients(config, "to", body); err != nil {
+		return nil, err
+	}
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("missing to in email action config")
+	}
+	if msg.Cc, err = recipients(config, "cc", body); err != nil {
+		return nil, err
+	}
+	if msg.Bcc, err = recipients(config, "bcc", body); err != nil {
+		return nil, err
+	}
+	msg.Template, _ = config[templateKey].(string)
+	if msg.Template != "" {
+		return msg, nil
+	}
+	for _, field := range []struct {
+		key string
+		dst *string
+	}{{"subject", &msg.Subject}, {"text", &msg.Text}, {"html", &msg.HTML}} {
+		tmpl, _ := config[field.key].(string)
+		if *field.dst, err = mapping.Render(tmpl, body, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", field.key, err)
+		}
+	}
+	if msg.Subject == "" {
+		return nil, fmt.Errorf("missing subject in email action config")
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return nil, fmt.Errorf("email action config needs text or html")
+	}
+	return msg, nil
+}
+
+// Reads a recipient list given as one address, a comma separated string or a
+// list. Addresses may be templates, e.g. "{{pusher.email}}"
+func recipients(config map[string]any, key string, body []byte) ([]string, error) {
+	var raw []string
+	switch v := config[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		raw = strings.Split(v, ",")
+	case []any:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must list addresses as strings", key)
+			}
+			raw = append(raw, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be an address or a list of addresses", key)
+	}
+	var out []string
+	for _, tmpl := range raw {
+		addr, err := mapping.Render(strings.TrimSpace(tmpl), body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if addr = strings.TrimSpace(addr); addr != "" {
+			out = append(out, addr)
+		}
+	}
+	return out, nil
+}
+
+// The payload as template data. Providers want an object
+func templateData(body []byte) (map[string]any, error) {
+	data := map[string]any{}
+	if len(body) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("template data must be a JSON object: %w", err)}
+	}
+	return data, nil
+}
+
+// Classifies a provider's HTTP answer. Throttling and server errors are worth
+// retrying; any other 4xx (bad key, unverified sender, rejected recipient)
+// fails the same way every time
+func classify(provider string, status int, detail string) error {
+	msg := fmt.Sprintf("%s returned %d", provider, status)
+	if detail != "" {
+		msg += ": " + detail
+	}
+	err := &engine.StatusError{StatusCode: status, Msg: msg}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return err
+	}
+	return &engine.PermanentError{Err: err}
+}
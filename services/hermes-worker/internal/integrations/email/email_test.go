@@ -0,0 +1,105 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestParseMessageRendersTemplates(t *testing.T) {
+	config := map[string]any{
+		"from":    "alerts@example.com",
+		"to":      "oncall@example.com, {{pusher.email}}",
+		"subject": "Push to {{repo}}",
+		"text":    "{{count}} commits",
+	}
+	msg, err := parseMessage(config, []byte(`{"pusher": {"email": "dev@example.com"}, "repo": "hermes", "count": 3}`), "template")
+	if err != nil {
+		t.Fatalf("parseMessage failed: %v", err)
+	}
+	if strings.Join(msg.To, " ") != "oncall@example.com dev@example.com" {
+		t.Errorf("Unexpected recipients %v", msg.To)
+	}
+	if msg.Subject != "Push to hermes" || msg.Text != "3 commits" {
+		t.Errorf("Unexpected content %q / %q", msg.Subject, msg.Text)
+	}
+	delete(config, "subject")
+	if _, err := parseMessage(config, []byte(`{"pusher": {"email": "x"}}`), "template"); err == nil {
+		t.Error("Expected a missing subject to be rejected")
+	}
+}
+
+func TestClassifyBounceSafe(t *testing.T) {
+	for status, permanent := range map[int]bool{400: true, 401: true, 403: true, 429: false, 500: false, 503: false} {
+		var p *engine.PermanentError
+		if got := errors.As(classify("ses", status, ""), &p); got != permanent {
+			t.Errorf("%d: expected permanent=%v, got %v", status, permanent, got)
+		}
+	}
+}
+
+func TestSendGridTemplateRequest(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &got)
+		w.Header().Set("X-Message-Id", "abc")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	s := NewSendGrid(&engine.Egress{AllowPrivate: true})
+	s.url = srv.URL
+	config := map[string]any{"api_key": "key", "from": "a@example.com", "to": []any{"b@example.com"}, "template_id": "d-1"}
+	response, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{"name": "x"}`))
+	if err != nil {
+		t.Fatalf("Expected the send to succeed, got %v", err)
+	}
+	if response != "202: message abc" || got["template_id"] != "d-1" {
+		t.Errorf("Unexpected response %q for request %v", response, got)
+	}
+
+	config["api_key"] = "wrong"
+	_, err = s.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a rejected key to be permanent, got %v", err)
+	}
+}
+
+func TestSESReportsErrorType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") {
+			t.Error("Expected a signed request")
+		}
+		w.Header().Set("X-Amzn-ErrorType", "MessageRejected:http://internal.amazon.com/")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Email address is not verified."}`))
+	}))
+	defer srv.Close()
+
+	s := NewSES(&engine.Egress{AllowPrivate: true})
+	s.endpoint = func(string) string { return srv.URL }
+	config := map[string]any{"region": "eu-west-1", "access_key_id": "id", "secret_access_key": "secret",
+		"from": "a@example.com", "to": "b@example.com", "subject": "s", "text": "t"}
+	_, err := s.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "MessageRejected") {
+		t.Errorf("Expected a permanent MessageRejected error, got %v", err)
+	}
+	config["region"] = "evil.example.com/x"
+	if _, err := s.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+		t.Error("Expected a region that isn't a region name to be rejected")
+	}
+}
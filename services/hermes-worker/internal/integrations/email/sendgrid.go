@@ -0,0 +1,118 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+type SendGrid struct {
+	client *http.Client
+	url    string
+}
+
+func NewSendGrid(egress *engine.Egress) *SendGrid {
+	return &SendGrid{client: egress.Client(10 * time.Second), url: sendGridURL}
+}
+
+// Breaker key: one per account would need the key, so the API as a whole
+func (s *SendGrid) Target(config map[string]any) string {
+	return "api.sendgrid.com"
+}
+
+func (s *SendGrid) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"api_key": "{{secret:SENDGRID_KEY}}", "from": "alerts@example.com", "from_name": "Hermes",
+//	 "to": ["oncall@example.com"], "subject": "{{alert.title}}", "text": "...", "html": "...",
+//	 "template_id": "d-..."}
+//
+// With template_id the payload is the dynamic template data
+func (s *SendGrid) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	apiKey, _ := config["api_key"].(string)
+	if apiKey == "" {
+		return "", fmt.Errorf("missing api_key in sendgrid action config")
+	}
+	msg, err := parseMessage(config, body, "template_id")
+	if err != nil {
+		return "", err
+	}
+	personalization := map[string]any{"to": addresses(msg.To)}
+	if len(msg.Cc) > 0 {
+		personalization["cc"] = addresses(msg.Cc)
+	}
+	if len(msg.Bcc) > 0 {
+		personalization["bcc"] = addresses(msg.Bcc)
+	}
+	from := map[string]string{"email": msg.From}
+	if msg.FromName != "" {
+		from["name"] = msg.FromName
+	}
+	req := map[string]any{"from": from}
+	if msg.ReplyTo != "" {
+		req["reply_to"] = map[string]string{"email": msg.ReplyTo}
+	}
+	if msg.Template != "" {
+		data, err := templateData(body)
+		if err != nil {
+			return "", err
+		}
+		personalization["dynamic_template_data"] = data
+		req["template_id"] = msg.Template
+	} else {
+		req["subject"] = msg.Subject
+		var content []map[string]string
+		// SendGrid wants text/plain before text/html
+		if msg.Text != "" {
+			content = append(content, map[string]string{"type": "text/plain", "value": msg.Text})
+		}
+		if msg.HTML != "" {
+			content = append(content, map[string]string{"type": "text/html", "value": msg.HTML})
+		}
+		req["content"] = content
+	}
+	req["personalizations"] = []any{personalization}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal sendgrid request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	messageID := resp.Header.Get("X-Message-Id")
+	response := engine.ReadResponse(resp)
+	if resp.StatusCode >= 300 {
+		return response, classify("sendgrid", resp.StatusCode, "")
+	}
+	if messageID != "" {
+		response = fmt.Sprintf("%d: message %s", resp.StatusCode, messageID)
+	}
+	return response, nil
+}
+
+func addresses(emails []string) []map[string]string {
+	out := make([]map[string]string, len(emails))
+	for i, email := range emails {
+		out[i] = map[string]string{"email": email}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/integrations/aws"
+)
+
+type SES struct {
+	client *http.Client
+	// Endpoint for a region, overridden in tests
+	endpoint func(region string) string
+	now      func() time.Time
+}
+
+func NewSES(egress *engine.Egress) *SES {
+	return &SES{
+		client: egress.Client(10 * time.Second),
+		endpoint: func(region string) string {
+			return "https://email." + region + ".amazonaws.com"
+		},
+		now: time.Now,
+	}
+}
+
+// Breaker key: the region's endpoint
+func (s *SES) Target(config map[string]any) string {
+	region, _ := config["region"].(string)
+	if !aws.ValidRegion.MatchString(region) {
+		return ""
+	}
+	return "email." + region + ".amazonaws.com"
+}
+
+func (s *SES) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"region": "eu-west-1", "access_key_id": "{{secret:AWS_KEY_ID}}",
+//	 "secret_access_key": "{{secret:AWS_SECRET}}", "from": "alerts@example.com",
+//	 "to": "oncall@example.com", "subject": "{{alert.title}}", "text": "...", "html": "...",
+//	 "template": "AlertTemplate", "configuration_set": "hermes"}
+//
+// With template the payload is the stored SES template's data
+func (s *SES) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	region, _ := config["region"].(string)
+	if !aws.ValidRegion.MatchString(region) {
+		return "", fmt.Errorf("missing or invalid region in ses action config")
+	}
+	creds, err := aws.CredentialsFrom(config, "ses")
+	if err != nil {
+		return "", err
+	}
+	msg, err := parseMessage(config, body, "template")
+	if err != nil {
+		return "", err
+	}
+	from := msg.From
+	if msg.FromName != "" {
+		from = fmt.Sprintf("%q <%s>", msg.FromName, msg.From)
+	}
+	destination := map[string]any{"ToAddresses": msg.To}
+	if len(msg.Cc) > 0 {
+		destination["CcAddresses"] = msg.Cc
+	}
+	if len(msg.Bcc) > 0 {
+		destination["BccAddresses"] = msg.Bcc
+	}
+	req := map[string]any{"FromEmailAddress": from, "Destination": destination}
+	if msg.ReplyTo != "" {
+		req["ReplyToAddresses"] = []string{msg.ReplyTo}
+	}
+	if set, _ := config["configuration_set"].(string); set != "" {
+		req["ConfigurationSetName"] = set
+	}
+	if msg.Template != "" {
+		data, err := templateData(body)
+		if err != nil {
+			return "", err
+		}
+		encoded, _ := json.Marshal(data)
+		req["Content"] = map[string]any{"Template": map[string]any{
+			"TemplateName": msg.Template,
+			"TemplateData": string(encoded),
+		}}
+	} else {
+		mailBody := map[string]any{}
+		if msg.Text != "" {
+			mailBody["Text"] = map[string]string{"Data": msg.Text, "Charset": "UTF-8"}
+		}
+		if msg.HTML != "" {
+			mailBody["Html"] = map[string]string{"Data": msg.HTML, "Charset": "UTF-8"}
+		}
+		req["Content"] = map[string]any{"Simple": map[string]any{
+			"Subject": map[string]string{"Data": msg.Subject, "Charset": "UTF-8"},
+			"Body":    mailBody,
+		}}
+	}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal ses request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.endpoint(region)+"/v2/email/outbound-emails", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	aws.SignV4(httpReq, jsonBody, creds, region, "ses", s.now())
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, data)
+	if resp.StatusCode >= 300 {
+		return response, classify("ses", resp.StatusCode, sesErrorType(resp, data))
+	}
+	return response, nil
+}
+
+// SES names the error in a header, falling back to the body's type
+func sesErrorType(resp *http.Response, data []byte) string {
+	if kind := resp.Header.Get("X-Amzn-Errortype"); kind != "" {
+		kind, _, _ = strings.Cut(kind, ":")
+		return kind
+	}
+	var body struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &body)
+	if body.Type != "" {
+		return body.Type
+	}
+	return body.Message
+}
@@ -0,0 +1,183 @@
+// Package file appends or writes events to files under an operator-chosen
+// directory, e.g. a mounted volume kept as an audit trail
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	modeAppend = "append"
+	modeWrite  = "write"
+)
+
+var errDisabled = errors.New("file action is disabled, set FILE_ACTION_ROOT on the worker to enable it")
+
+type Writer struct {
+	// Directory every path is resolved in, empty when the action is disabled
+	root string
+	now  func() time.Time
+	// Serialise appends to one file so records never interleave, striped by
+	// path hash so the set doesn't grow with every dated file name
+	locks [64]sync.Mutex
+}
+
+func New(root string) *Writer {
+	return &Writer{root: root, now: time.Now}
+}
+
+// Breaker key: the volume as a whole, since a full or unmounted disk fails every path
+func (w *Writer) Target(config map[string]any) string {
+	return "file:" + w.root
+}
+
+func (w *Writer) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := w.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"path": "audit/{{repository.name}}/%Y-%m-%d.jsonl", "mode": "append"}
+//	{"path": "latest/{{deployment.id}}.json", "mode": "write", "content": "{{deployment}}"}
+//
+// path is relative to FILE_ACTION_ROOT and can't leave it. %Y, %m, %d and %H
+// are replaced with the current UTC time, so files rotate by name and nothing
+// has to move them. append (the default) adds one line per event; write
+// replaces the file atomically. content is a mapping template, the payload
+// itself when unset
+func (w *Writer) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	if w.root == "" {
+		return "", &engine.PermanentError{Err: errDisabled}
+	}
+	name, err := w.path(config, body)
+	if err != nil {
+		return "", err
+	}
+	content := body
+	if tmpl, ok := config["content"].(string); ok {
+		rendered, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("content: %w", err)
+		}
+		content = []byte(rendered)
+	}
+
+	root, err := os.OpenRoot(w.root)
+	if err != nil {
+		return "", fmt.Errorf("open FILE_ACTION_ROOT: %w", err)
+	}
+	defer root.Close()
+	if dir := path.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0o750); err != nil {
+			return "", classify(err)
+		}
+	}
+	switch mode, _ := config["mode"].(string); mode {
+	case "", modeAppend:
+		err = w.append(root, name, content)
+	case modeWrite:
+		err = writeAtomic(root, name, content)
+	default:
+		return "", fmt.Errorf("mode must be append or write in file action config, got %q", mode)
+	}
+	if err != nil {
+		return "", classify(err)
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(content), name), nil
+}
+
+// Expands the time placeholders, then renders the path and checks it stays relative
+func (w *Writer) path(config map[string]any, body []byte) (string, error) {
+	tmpl, _ := config["path"].(string)
+	if tmpl == "" {
+		return "", fmt.Errorf("missing path in file action config")
+	}
+	now := w.now().UTC()
+	tmpl = strings.NewReplacer(
+		"%Y", now.Format("2006"), "%m", now.Format("01"), "%d", now.Format("02"), "%H", now.Format("15"),
+	).Replace(tmpl)
+	name, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("path: %w", err)
+	}
+	// Checked before the root does, for a clearer error than "path escapes from parent"
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, "\\") {
+		return "", &engine.PermanentError{Err: fmt.Errorf("path %q must be relative, without . or .. segments", name)}
+	}
+	return name, nil
+}
+
+// One line per event. The file is opened per write, so a rotated or
+// removed file is recreated rather than written to after it's gone
+func (w *Writer) append(root *os.Root, name string, content []byte) error {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	lock := &w.locks[h.Sum32()%uint32(len(w.locks))]
+	lock.Lock()
+	defer lock.Unlock()
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 || content[len(content)-1] != '\n' {
+		// Copied rather than appended in place: content may be the job's
+		// payload, and its spare capacity is shared with the caller. One
+		// Write keeps the record whole under O_APPEND
+		line := make([]byte, len(content)+1)
+		copy(line, content)
+		line[len(content)] = '\n'
+		content = line
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes beside the target and renames over it, so readers never see half a file
+func writeAtomic(root *os.Root, name string, content []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", name, time.Now().UnixNano())
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = root.Rename(tmp, name)
+	}
+	if err != nil {
+		root.Remove(tmp)
+	}
+	return err
+}
+
+// A full disk may clear up; permissions and paths through files won't
+func classify(err error) error {
+	// os.Root doesn't export its error for symlinks leading out of the root
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR) ||
+		strings.Contains(err.Error(), "escapes from parent") {
+		return &engine.PermanentError{Err: err}
+	}
+	return err
+}
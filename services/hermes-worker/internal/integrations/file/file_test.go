@@ -0,0 +1,78 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestAppendsByDate(t *testing.T) {
+	dir := t.TempDir()
+	w := New(dir)
+	w.now = func() time.Time { return time.Date(2026, 3, 4, 5, 0, 0, 0, time.UTC) }
+	config := map[string]any{"path": "audit/{{repo}}/%Y-%m-%d.jsonl"}
+	for _, body := range []string{`{"repo": "hermes", "n": 1}`, `{"repo": "hermes", "n": 2}`} {
+		if _, err := w.ExecuteWithResponse(context.Background(), config, []byte(body)); err != nil {
+			t.Fatalf("Expected the append to succeed, got %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "audit", "hermes", "2026-03-04.jsonl"))
+	if err != nil || string(data) != "{\"repo\": \"hermes\", \"n\": 1}\n{\"repo\": \"hermes\", \"n\": 2}\n" {
+		t.Errorf("Unexpected file contents %q (%v)", data, err)
+	}
+}
+
+func TestAppendLeavesPayloadUntouched(t *testing.T) {
+	w := New(t.TempDir())
+	// Spare capacity after the payload, as a slice of a larger buffer has
+	buf := []byte(`{"n": 1}X`)
+	body := buf[:len(buf)-1]
+	if _, err := w.ExecuteWithResponse(context.Background(), map[string]any{"path": "out.jsonl"}, body); err != nil {
+		t.Fatalf("Expected the append to succeed, got %v", err)
+	}
+	if buf[len(buf)-1] != 'X' {
+		t.Errorf("Expected the newline to be written without touching the payload's backing array, got %q", buf)
+	}
+}
+
+func TestWriteReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	w := New(dir)
+	config := map[string]any{"path": "latest/{{id}}.txt", "mode": "write", "content": "status {{status}}"}
+	for _, status := range []string{"started", "done"} {
+		if _, err := w.ExecuteWithResponse(context.Background(), config, []byte(`{"id": 7, "status": "`+status+`"}`)); err != nil {
+			t.Fatalf("Expected the write to succeed, got %v", err)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "latest", "7.txt"))
+	if string(data) != "status done" {
+		t.Errorf("Expected the file to be replaced, got %q", data)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "latest"))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files left behind, got %v", entries)
+	}
+}
+
+func TestRejectsPathsOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	os.Symlink(os.TempDir(), filepath.Join(dir, "link"))
+	w := New(dir)
+	for _, name := range []string{"../escape", "/etc/passwd", "a/../../b", "{{name}}", "link/x"} {
+		_, err := w.ExecuteWithResponse(context.Background(), map[string]any{"path": name}, []byte(`{"name": "../x"}`))
+		var p *engine.PermanentError
+		if !errors.As(err, &p) {
+			t.Errorf("Expected %q to be rejected permanently, got %v", name, err)
+		}
+	}
+
+	_, err := New("").ExecuteWithResponse(context.Background(), map[string]any{"path": "x"}, nil)
+	if !errors.Is(err, errDisabled) {
+		t.Errorf("Expected the action to be disabled without a root, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+// Package github acts on a repository through the GitHub REST API: opening
+// issues, commenting on issues and pull requests, labelling and firing
+// repository_dispatch events
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL = "https://api.github.com"
+	// GitHub rejects issue and comment bodies over 65536 characters
+	maxBodyBytes = 60000
+	// repository_dispatch accepts at most 10 top level client_payload keys
+	maxClientPayloadKeys = 10
+)
+
+const (
+	opCreateIssue = "create_issue"
+	opComment     = "comment"
+	opAddLabels   = "add_labels"
+	opDispatch    = "repository_dispatch"
+)
+
+// Owner and repository names end up in the request path
+var validRepository = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), url: apiURL}
+}
+
+// Breaker key: failures are tracked per repository
+func (c *Client) Target(config map[string]any) string {
+	repo, _ := config["repository"].(string)
+	if !validRepository.MatchString(repo) {
+		return ""
+	}
+	return "api.github.com/" + repo
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:GITHUB_TOKEN}}", "repository": "octo/app", "operation": "create_issue",
+//	 "title": "{{alert.title}}", "body": "{{alert.summary}}", "labels": ["incident"]}
+//	{"token": "...", "repository": "octo/app", "operation": "comment",
+//	 "issue_number": "{{pull_request.number}}", "body": "Deployed {{sha}}"}
+//	{"token": "...", "repository": "octo/app", "operation": "add_labels",
+//	 "issue_number": "{{issue.number}}", "labels": ["triaged"]}
+//	{"token": "...", "repository": "octo/app", "operation": "repository_dispatch",
+//	 "event_type": "deploy"}
+//
+// title, body, issue_number and labels are mapping templates filled from the
+// payload. repository_dispatch sends the payload as client_payload
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in github action config")
+	}
+	repo, _ := config["repository"].(string)
+	if !validRepository.MatchString(repo) {
+		return "", fmt.Errorf("missing or invalid repository in github action config, want owner/name")
+	}
+	operation, _ := config["operation"].(string)
+	var path string
+	var req map[string]any
+	var err error
+	switch operation {
+	case opCreateIssue:
+		path = "/repos/" + repo + "/issues"
+		req, err = issueRequest(config, body)
+	case opComment:
+		var number int
+		if number, err = issueNumber(config, body); err == nil {
+			path = fmt.Sprintf("/repos/%s/issues/%d/comments", repo, number)
+			req, err = commentRequest(config, body)
+		}
+	case opAddLabels:
+		var number int
+		if number, err = issueNumber(config, body); err == nil {
+			path = fmt.Sprintf("/repos/%s/issues/%d/labels", repo, number)
+			var labels []string
+			if labels, err = renderLabels(config, body); err == nil && len(labels) == 0 {
+				err = fmt.Errorf("missing labels in github action config")
+			}
+			req = map[string]any{"labels": labels}
+		}
+	case opDispatch:
+		path = "/repos/" + repo + "/dispatches"
+		req, err = dispatchRequest(config, body)
+	default:
+		return "", fmt.Errorf("unknown operation %q in github action config", operation)
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.call(ctx, token, path, req)
+}
+
+func issueRequest(config map[string]any, body []byte) (map[string]any, error) {
+	title, err := render(config, "title", body)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		return nil, fmt.Errorf("missing title in github action config")
+	}
+	req := map[string]any{"title": title}
+	text, err := render(config, "body", body)
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		req["body"] = payload.TruncateString(text, maxBodyBytes)
+	}
+	labels, err := renderLabels(config, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) > 0 {
+		req["labels"] = labels
+	}
+	if raw, ok := config["assignees"].([]any); ok {
+		req["assignees"] = raw
+	}
+	return req, nil
+}
+
+func commentRequest(config map[string]any, body []byte) (map[string]any, error) {
+	text, err := render(config, "body", body)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, fmt.Errorf("missing body in github action config")
+	}
+	return map[string]any{"body": payload.TruncateString(text, maxBodyBytes)}, nil
+}
+
+func dispatchRequest(config map[string]any, body []byte) (map[string]any, error) {
+	eventType, _ := config["event_type"].(string)
+	if eventType == "" {
+		return nil, fmt.Errorf("missing event_type in github action config")
+	}
+	req := map[string]any{"event_type": eventType}
+	if len(body) > 0 {
+		var clientPayload map[string]any
+		if err := json.Unmarshal(body, &clientPayload); err != nil {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("client_payload must be a JSON object: %w", err)}
+		}
+		if len(clientPayload) > maxClientPayloadKeys {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("client_payload has %d top level keys, github allows %d", len(clientPayload), maxClientPayloadKeys)}
+		}
+		req["client_payload"] = clientPayload
+	}
+	return req, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
+
+// The issue or pull request number, given as a number or a template
+func issueNumber(config map[string]any, body []byte) (int, error) {
+	var raw string
+	switch v := config["issue_number"].(type) {
+	case float64:
+		raw = strconv.Itoa(int(v))
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return 0, fmt.Errorf("issue_number: %w", err)
+		}
+		raw = strings.TrimSpace(rendered)
+	}
+	number, err := strconv.Atoi(raw)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("missing or invalid issue_number in github action config")
+	}
+	return number, nil
+}
+
+func renderLabels(config map[string]any, body []byte) ([]string, error) {
+	raw, ok := config["labels"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("labels must be a list of strings")
+	}
+	var labels []string
+	for _, item := range list {
+		tmpl, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("labels must be a list of strings")
+		}
+		label, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("labels: %w", err)
+		}
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
+
+func (c *Client) call(ctx context.Context, token, path string, req map[string]any) (string, error) {
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal github request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		HTMLURL string `json:"html_url"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		if result.HTMLURL != "" {
+			return fmt.Sprintf("%d: %s", resp.StatusCode, result.HTMLURL), nil
+		}
+		return fmt.Sprintf("%d", resp.StatusCode), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("github returned %d: %s", resp.StatusCode, result.Message),
+	}
+	// Secondary rate limits come back as 403 with the remaining quota at zero
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 ||
+		(resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0") {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Until(time.Unix(reset, 0)); wait > 0 {
+				return "", &engine.DeferError{Delay: wait, Err: statusErr}
+			}
+		}
+		return "", statusErr
+	}
+	// Bad tokens, missing repositories and validation failures fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
@@ -0,0 +1,109 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestOperations(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ghp" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		path = r.URL.Path
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url": "https://github.com/octo/app/issues/7"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	body := []byte(`{"alert": "disk", "pr": {"number": 12}, "sha": "abc"}`)
+	tests := []struct {
+		config map[string]any
+		path   string
+		field  string
+		want   any
+	}{
+		{map[string]any{"operation": "create_issue", "title": "{{alert}} is firing", "labels": []any{"incident"}},
+			"/repos/octo/app/issues", "title", "disk is firing"},
+		{map[string]any{"operation": "comment", "issue_number": "{{pr.number}}", "body": "Deployed {{sha}}"},
+			"/repos/octo/app/issues/12/comments", "body", "Deployed abc"},
+		{map[string]any{"operation": "add_labels", "issue_number": float64(3), "labels": []any{"{{alert}}"}},
+			"/repos/octo/app/issues/3/labels", "labels", []any{"disk"}},
+		{map[string]any{"operation": "repository_dispatch", "event_type": "deploy"},
+			"/repos/octo/app/dispatches", "event_type", "deploy"},
+	}
+	for _, tt := range tests {
+		tt.config["token"] = "ghp"
+		tt.config["repository"] = "octo/app"
+		if _, err := c.ExecuteWithResponse(context.Background(), tt.config, body); err != nil {
+			t.Fatalf("%s: expected success, got %v", tt.config["operation"], err)
+		}
+		if path != tt.path {
+			t.Errorf("%s: expected path %s, got %s", tt.config["operation"], tt.path, path)
+		}
+		gotJSON, _ := json.Marshal(got[tt.field])
+		wantJSON, _ := json.Marshal(tt.want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s: expected %s %s, got %s", tt.config["operation"], tt.field, wantJSON, gotJSON)
+		}
+	}
+	if got["client_payload"].(map[string]any)["sha"] != "abc" {
+		t.Errorf("Expected the payload as client_payload, got %v", got)
+	}
+
+	_, err := c.ExecuteWithResponse(context.Background(),
+		map[string]any{"token": "bad", "repository": "octo/app", "operation": "repository_dispatch", "event_type": "x"}, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected bad credentials to fail permanently, got %v", err)
+	}
+}
+
+func TestRateLimitDefersUntilReset(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "ghp", "repository": "octo/app", "operation": "create_issue", "title": "x"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, nil)
+	var d *engine.DeferError
+	if !errors.As(err, &d) || d.Delay <= 0 || d.Delay > time.Minute {
+		t.Errorf("Expected a deferral until the reset, got %v", err)
+	}
+}
+
+func TestRejectsInvalidConfig(t *testing.T) {
+	c := New(nil)
+	for _, config := range []map[string]any{
+		{"token": "t", "repository": "octo/../evil", "operation": "create_issue", "title": "x"},
+		{"token": "t", "repository": "octo/app", "operation": "delete_repo"},
+		{"token": "t", "repository": "octo/app", "operation": "comment", "issue_number": "abc", "body": "x"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
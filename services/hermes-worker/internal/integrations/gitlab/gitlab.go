@@ -0,0 +1,315 @@
+// Package gitlab acts on a GitLab project: opening issues, adding notes to
+// issues and merge requests and triggering pipelines. Works against gitlab.com
+// and self-managed instances
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	defaultBaseURL = "https://gitlab.com"
+	// GitLab rejects descriptions and notes over 1,000,000 characters
+	maxBodyBytes = 100000
+)
+
+const (
+	opCreateIssue     = "create_issue"
+	opNote            = "note"
+	opTriggerPipeline = "trigger_pipeline"
+)
+
+// Project IDs or full paths, e.g. 42 or group/subgroup/project
+var validProject = regexp.MustCompile(`^(\d+|[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)+)$`)
+
+// Pipeline variable names as GitLab accepts them
+var validVariable = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type Client struct {
+	client *http.Client
+}
+
+// Self-managed base URLs are user supplied, so requests go through the
+// egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: failures are tracked per project
+func (c *Client) Target(config map[string]any) string {
+	base, err := baseURL(config)
+	project, _ := config["project"].(string)
+	if err != nil || !validProject.MatchString(project) {
+		return ""
+	}
+	return base.Host + "/" + project
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"base_url": "https://gitlab.example.com", "token": "{{secret:GITLAB_TOKEN}}",
+//	 "project": "infra/app", "operation": "create_issue",
+//	 "title": "{{alert.title}}", "description": "{{alert.summary}}", "labels": ["incident"]}
+//	{"token": "...", "project": "infra/app", "operation": "note",
+//	 "merge_request_iid": "{{object_attributes.iid}}", "body": "Deployed {{sha}}"}
+//	{"project": "42", "operation": "trigger_pipeline", "trigger_token": "{{secret:GITLAB_TRIGGER}}",
+//	 "ref": "main", "variables": {"DEPLOY_SHA": "{{sha}}"}}
+//
+// base_url defaults to gitlab.com. Notes go to issue_iid or merge_request_iid.
+// Strings other than the tokens are mapping templates filled from the payload
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	base, err := baseURL(config)
+	if err != nil {
+		return "", err
+	}
+	project, _ := config["project"].(string)
+	if !validProject.MatchString(project) {
+		return "", fmt.Errorf("missing or invalid project in gitlab action config")
+	}
+	projectPath := base.String() + "/api/v4/projects/" + url.PathEscape(project)
+
+	operation, _ := config["operation"].(string)
+	if operation == opTriggerPipeline {
+		return c.triggerPipeline(ctx, config, body, projectPath)
+	}
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in gitlab action config")
+	}
+	var endpoint string
+	var req map[string]any
+	switch operation {
+	case opCreateIssue:
+		endpoint = projectPath + "/issues"
+		req, err = issueRequest(config, body)
+	case opNote:
+		var resource string
+		if resource, err = noteTarget(config, body); err == nil {
+			endpoint = projectPath + resource + "/notes"
+			req, err = noteRequest(config, body)
+		}
+	default:
+		return "", fmt.Errorf("unknown operation %q in gitlab action config", operation)
+	}
+	if err != nil {
+		return "", err
+	}
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal gitlab request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("PRIVATE-TOKEN", token)
+	return c.do(httpReq)
+}
+
+func (c *Client) triggerPipeline(ctx context.Context, config map[string]any, body []byte, projectPath string) (string, error) {
+	token, _ := config["trigger_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing trigger_token in gitlab action config")
+	}
+	ref, err := render(config, "ref", body)
+	if err != nil {
+		return "", err
+	}
+	if ref == "" {
+		return "", fmt.Errorf("missing ref in gitlab action config")
+	}
+	form := url.Values{"token": {token}, "ref": {ref}}
+	if raw, ok := config["variables"]; ok {
+		variables, ok := raw.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("variables must be an object of templates")
+		}
+		for name, item := range variables {
+			tmpl, isString := item.(string)
+			if !validVariable.MatchString(name) || !isString {
+				return "", fmt.Errorf("invalid pipeline variable %q", name)
+			}
+			value, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return "", fmt.Errorf("variables.%s: %w", name, err)
+			}
+			form.Set("variables["+name+"]", value)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		projectPath+"/trigger/pipeline", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+func issueRequest(config map[string]any, body []byte) (map[string]any, error) {
+	title, err := render(config, "title", body)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		return nil, fmt.Errorf("missing title in gitlab action config")
+	}
+	req := map[string]any{"title": title}
+	description, err := render(config, "description", body)
+	if err != nil {
+		return nil, err
+	}
+	if description != "" {
+		req["description"] = payload.TruncateString(description, maxBodyBytes)
+	}
+	if raw, ok := config["labels"]; ok {
+		list, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("labels must be a list of strings")
+		}
+		var labels []string
+		for _, item := range list {
+			tmpl, _ := item.(string)
+			label, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("labels: %w", err)
+			}
+			// GitLab takes labels comma separated
+			if label = strings.TrimSpace(strings.ReplaceAll(label, ",", " ")); label != "" {
+				labels = append(labels, label)
+			}
+		}
+		if len(labels) > 0 {
+			req["labels"] = strings.Join(labels, ",")
+		}
+	}
+	if confidential, ok := config["confidential"].(bool); ok {
+		req["confidential"] = confidential
+	}
+	return req, nil
+}
+
+func noteRequest(config map[string]any, body []byte) (map[string]any, error) {
+	text, err := render(config, "body", body)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, fmt.Errorf("missing body in gitlab action config")
+	}
+	return map[string]any{"body": payload.TruncateString(text, maxBodyBytes)}, nil
+}
+
+// The path of the issue or merge request a note goes to
+func noteTarget(config map[string]any, body []byte) (string, error) {
+	for _, target := range []struct{ key, path string }{
+		{"issue_iid", "/issues/"},
+		{"merge_request_iid", "/merge_requests/"},
+	} {
+		if _, ok := config[target.key]; !ok {
+			continue
+		}
+		iid, err := renderIID(config[target.key], body)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", target.key, err)
+		}
+		return target.path + strconv.Itoa(iid), nil
+	}
+	return "", fmt.Errorf("gitlab note needs issue_iid or merge_request_iid")
+}
+
+func renderIID(raw any, body []byte) (int, error) {
+	var text string
+	switch v := raw.(type) {
+	case float64:
+		text = strconv.Itoa(int(v))
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return 0, err
+		}
+		text = strings.TrimSpace(rendered)
+	}
+	iid, err := strconv.Atoi(text)
+	if err != nil || iid <= 0 {
+		return 0, fmt.Errorf("want a positive number, got %q", text)
+	}
+	return iid, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
+
+func baseURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["base_url"].(string)
+	if raw == "" {
+		raw = defaultBaseURL
+	}
+	base, err := url.Parse(strings.TrimRight(raw, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid base_url in gitlab action config")
+	}
+	return base, nil
+}
+
+func (c *Client) do(req *http.Request) (string, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		WebURL  string `json:"web_url"`
+		ID      int    `json:"id"`
+		Message any    `json:"message"`
+		Error   string `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		if result.WebURL != "" {
+			return fmt.Sprintf("%d: %s", resp.StatusCode, result.WebURL), nil
+		}
+		return fmt.Sprintf("%d: id %d", resp.StatusCode, result.ID), nil
+	}
+	detail := result.Error
+	if result.Message != nil {
+		// GitLab reports validation failures as an object of field errors
+		encoded, _ := json.Marshal(result.Message)
+		detail = strings.Trim(string(encoded), `"`)
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("gitlab returned %d: %s", resp.StatusCode, detail),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Bad tokens, missing projects and validation failures fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
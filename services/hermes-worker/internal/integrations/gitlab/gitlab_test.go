@@ -0,0 +1,101 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestIssuesAndNotes(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "401 Unauthorized"}`))
+			return
+		}
+		path = r.URL.EscapedPath()
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1, "web_url": "https://gitlab.example.com/infra/app/-/issues/3"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"alert": "disk", "mr": {"iid": 9}}`)
+	config := map[string]any{"base_url": srv.URL, "token": "glpat", "project": "infra/app",
+		"operation": "create_issue", "title": "{{alert}} is firing", "labels": []any{"incident", "{{alert}}"}}
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil {
+		t.Fatalf("Expected the issue to be created, got %v", err)
+	}
+	if path != "/api/v4/projects/infra%2Fapp/issues" || got["title"] != "disk is firing" || got["labels"] != "incident,disk" {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+	if response != "201: https://gitlab.example.com/infra/app/-/issues/3" {
+		t.Errorf("Expected the issue URL in the response, got %q", response)
+	}
+
+	config = map[string]any{"base_url": srv.URL, "token": "glpat", "project": "42",
+		"operation": "note", "merge_request_iid": "{{mr.iid}}", "body": "{{alert}} fixed"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the note to be added, got %v", err)
+	}
+	if path != "/api/v4/projects/42/merge_requests/9/notes" || got["body"] != "disk fixed" {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+
+	config["token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestTriggersPipeline(t *testing.T) {
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{"path": r.URL.Path}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 77, "web_url": "https://gitlab.com/infra/app/-/pipelines/77"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"base_url": srv.URL, "project": "42", "operation": "trigger_pipeline",
+		"trigger_token": "glptt", "ref": "main", "variables": map[string]any{"DEPLOY_SHA": "{{sha}}"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"sha": "abc"}`)); err != nil {
+		t.Fatalf("Expected the trigger to succeed, got %v", err)
+	}
+	if form["path"] != "/api/v4/projects/42/trigger/pipeline" || form["token"] != "glptt" ||
+		form["ref"] != "main" || form["variables[DEPLOY_SHA]"] != "abc" {
+		t.Errorf("Unexpected trigger request %v", form)
+	}
+}
+
+func TestRejectsInvalidConfig(t *testing.T) {
+	c := New(nil)
+	for _, config := range []map[string]any{
+		{"token": "t", "project": "../evil", "operation": "create_issue", "title": "x"},
+		{"token": "t", "project": "42", "base_url": "ftp://gitlab", "operation": "create_issue", "title": "x"},
+		{"token": "t", "project": "42", "operation": "note", "body": "x"},
+		{"project": "42", "operation": "trigger_pipeline", "trigger_token": "t", "ref": "main",
+			"variables": map[string]any{"BAD NAME": "x"}},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package grafana creates annotations through the Grafana HTTP API, so deploys
+// and incidents show up on dashboards
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Annotation text shows in a tooltip, keep it readable
+const maxTextBytes = 10000
+
+type Client struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// Grafana instances are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), now: time.Now}
+}
+
+// Breaker key: the Grafana instance
+func (c *Client) Target(config map[string]any) string {
+	base, err := baseURL(config)
+	if err != nil {
+		return ""
+	}
+	return base.Host + base.Path
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"base_url": "https://grafana.example.com", "token": "{{secret:GRAFANA_TOKEN}}",
+//	 "dashboard_uid": "deploys", "panel_id": 4, "tags": ["deploy", "{{repository.name}}"],
+//	 "text": "Deployed {{after}} by {{pusher.name}}", "time": "{{head_commit.timestamp}}"}
+//
+// token is a service account token. Without dashboard_uid the annotation is
+// global and shows on every dashboard querying its tags. text, tags, time and
+// time_end are mapping templates filled from the payload; times are RFC 3339
+// or Unix seconds or milliseconds and default to now. time_end makes a region
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	base, err := baseURL(config)
+	if err != nil {
+		return "", err
+	}
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in grafana action config")
+	}
+	req, err := c.annotation(config, body)
+	if err != nil {
+		return "", err
+	}
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal grafana annotation: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, base.String()+"/api/annotations", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	if org, ok := config["org_id"].(float64); ok {
+		httpReq.Header.Set("X-Grafana-Org-Id", strconv.Itoa(int(org)))
+	}
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		ID      int    `json:"id"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: annotation %d", resp.StatusCode, result.ID), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("grafana returned %d: %s", resp.StatusCode, result.Message),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Bad tokens, missing dashboards and invalid annotations fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+// Builds the annotation request body
+func (c *Client) annotation(config map[string]any, body []byte) (map[string]any, error) {
+	text, err := render(config, "text", body)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, fmt.Errorf("missing text in grafana action config")
+	}
+	req := map[string]any{"text": payload.TruncateString(text, maxTextBytes)}
+	if uid, _ := config["dashboard_uid"].(string); uid != "" {
+		req["dashboardUID"] = uid
+	}
+	if panel, ok := config["panel_id"].(float64); ok {
+		if _, hasDashboard := req["dashboardUID"]; !hasDashboard {
+			return nil, fmt.Errorf("panel_id needs a dashboard_uid")
+		}
+		req["panelId"] = int(panel)
+	}
+	if raw, ok := config["tags"]; ok {
+		list, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("tags must be a list of strings")
+		}
+		tags := []string{}
+		for _, item := range list {
+			tmpl, _ := item.(string)
+			tag, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("tags: %w", err)
+			}
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		req["tags"] = tags
+	}
+	start := c.now()
+	if raw, err := render(config, "time", body); err != nil {
+		return nil, err
+	} else if raw != "" {
+		if start, err = parseTime(raw); err != nil {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("time: %w", err)}
+		}
+	}
+	req["time"] = start.UnixMilli()
+	if raw, err := render(config, "time_end", body); err != nil {
+		return nil, err
+	} else if raw != "" {
+		end, err := parseTime(raw)
+		if err != nil {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("time_end: %w", err)}
+		}
+		req["timeEnd"] = end.UnixMilli()
+	}
+	return req, nil
+}
+
+// Reads RFC 3339 timestamps and Unix times. Numbers past 1e12 are taken as
+// milliseconds, which every date since 2001 is
+func parseTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want an RFC 3339 or Unix time, got %q", raw)
+	}
+	return t, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
+
+// Grafana may be served under a sub path, e.g. https://example.com/grafana
+func baseURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["base_url"].(string)
+	base, err := url.Parse(strings.TrimRight(raw, "/"))
+	if raw == "" || err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("missing or invalid base_url in grafana action config")
+	}
+	return base, nil
+}
@@ -0,0 +1,76 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestCreatesAnnotation(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grafana/api/annotations" || r.Header.Get("Authorization") != "Bearer glsa" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "invalid API key"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"message": "Annotation added", "id": 7}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"repo": "app", "sha": "abc", "at": "2026-05-01T12:00:00Z"}`)
+	config := map[string]any{"base_url": srv.URL + "/grafana/", "token": "glsa", "dashboard_uid": "deploys",
+		"panel_id": float64(4), "tags": []any{"deploy", "{{repo}}"}, "text": "Deployed {{sha}}", "time": "{{at}}"}
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil || response != "200: annotation 7" {
+		t.Fatalf("Expected the annotation to be created, got %q (%v)", response, err)
+	}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC).UnixMilli()
+	tags, _ := got["tags"].([]any)
+	if got["dashboardUID"] != "deploys" || got["panelId"] != float64(4) || got["text"] != "Deployed abc" ||
+		got["time"] != float64(at) || len(tags) != 2 || tags[1] != "app" {
+		t.Errorf("Unexpected annotation %v", got)
+	}
+
+	config["token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, raw := range []string{"2026-05-01T12:00:00Z", "1777636800", "1777636800000"} {
+		if got, err := parseTime(raw); err != nil || !got.Equal(want) {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", raw, want, got, err)
+		}
+	}
+	if _, err := parseTime("yesterday"); err == nil {
+		t.Error("Expected an unparseable time to be rejected")
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"token": "t", "text": "x"},
+		{"base_url": "ftp://grafana.example.com", "token": "t", "text": "x"},
+		{"base_url": "https://grafana.example.com", "text": "x"},
+		{"base_url": "https://grafana.example.com", "token": "t"},
+		{"base_url": "https://grafana.example.com", "token": "t", "text": "x", "panel_id": float64(2)},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package homeassistant calls services and fires events on a Home Assistant
+// instance through its REST API
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	opCallService = "call_service"
+	opFireEvent   = "fire_event"
+)
+
+var (
+	// Services as domain.service, e.g. light.turn_on
+	validService = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_]+$`)
+	// Event types go into the URL path
+	validEventType = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+)
+
+type Client struct {
+	client *http.Client
+}
+
+// Instances are self-hosted and usually on the local network, so requests go
+// through the egress policy and need private addresses allowed there
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: the instance
+func (c *Client) Target(config map[string]any) string {
+	base, err := baseURL(config)
+	if err != nil {
+		return ""
+	}
+	return base.Host
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"base_url": "http://homeassistant.local:8123", "token": "{{secret:HA_TOKEN}}",
+//	 "operation": "call_service", "service": "light.turn_on", "entity_id": "light.office",
+//	 "data": {"brightness_pct": 100, "color_name": "{{alert.color}}"}}
+//	{"base_url": "http://homeassistant.local:8123", "token": "...",
+//	 "operation": "fire_event", "event_type": "hermes_deploy", "data": {"sha": "{{sha}}"}}
+//
+// token is a long-lived access token from the user's profile. entity_id is a
+// string or a list. Strings in data are mapping templates filled from the
+// payload; fire_event without data sends the payload itself as event data
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	base, err := baseURL(config)
+	if err != nil {
+		return "", err
+	}
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in homeassistant action config")
+	}
+	data := map[string]any{}
+	if raw, ok := config["data"]; ok {
+		template, ok := raw.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("data must be an object")
+		}
+		rendered, err := renderStrings(template, body)
+		if err != nil {
+			return "", fmt.Errorf("data: %w", err)
+		}
+		data = rendered.(map[string]any)
+	}
+
+	var endpoint string
+	switch operation, _ := config["operation"].(string); operation {
+	case opCallService:
+		service, _ := config["service"].(string)
+		if !validService.MatchString(service) {
+			return "", fmt.Errorf("missing or invalid service in homeassistant action config, want e.g. light.turn_on")
+		}
+		domain, name, _ := strings.Cut(service, ".")
+		endpoint = base.String() + "/api/services/" + domain + "/" + name
+		if raw, ok := config["entity_id"]; ok {
+			entities, err := renderStrings(raw, body)
+			if err != nil {
+				return "", fmt.Errorf("entity_id: %w", err)
+			}
+			data["entity_id"] = entities
+		}
+	case opFireEvent:
+		eventType, _ := config["event_type"].(string)
+		if !validEventType.MatchString(eventType) {
+			return "", fmt.Errorf("missing or invalid event_type in homeassistant action config")
+		}
+		endpoint = base.String() + "/api/events/" + eventType
+		if _, ok := config["data"]; !ok {
+			// Event data has to be an object, other payloads are wrapped
+			if err := json.Unmarshal(body, &data); err != nil {
+				data = map[string]any{"payload": string(body)}
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown operation %q in homeassistant action config", operation)
+	}
+
+	jsonBody, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("marshal homeassistant request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: %s", resp.StatusCode, respBody), nil
+	}
+	var result struct {
+		Message string `json:"message"`
+	}
+	detail := string(respBody)
+	if json.Unmarshal(respBody, &result) == nil && result.Message != "" {
+		detail = result.Message
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("homeassistant returned %d: %s", resp.StatusCode, detail),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Bad tokens, unknown services and invalid service data fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+// Renders every string in a config value, leaving numbers and booleans as is
+func renderStrings(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func baseURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["base_url"].(string)
+	base, err := url.Parse(strings.TrimRight(raw, "/"))
+	if raw == "" || err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("missing or invalid base_url in homeassistant action config")
+	}
+	return base, nil
+}
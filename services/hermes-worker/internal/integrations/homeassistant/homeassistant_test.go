@@ -0,0 +1,78 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestServicesAndEvents(t *testing.T) {
+	var path string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer llat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`401: Unauthorized`))
+			return
+		}
+		path = r.URL.Path
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		if path == "/api/services/light/turn_off" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Service light.turn_off called with invalid data"}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"alert": {"color": "red"}, "sha": "abc"}`)
+	config := map[string]any{"base_url": srv.URL, "token": "llat", "operation": "call_service",
+		"service": "light.turn_on", "entity_id": []any{"light.office"},
+		"data": map[string]any{"brightness_pct": float64(100), "color_name": "{{alert.color}}"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the service call to succeed, got %v", err)
+	}
+	entities, _ := got["entity_id"].([]any)
+	if path != "/api/services/light/turn_on" || got["color_name"] != "red" || got["brightness_pct"] != float64(100) ||
+		len(entities) != 1 || entities[0] != "light.office" {
+		t.Errorf("Unexpected request %s %v", path, got)
+	}
+
+	config = map[string]any{"base_url": srv.URL, "token": "llat", "operation": "fire_event", "event_type": "hermes_deploy"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the event to be fired, got %v", err)
+	}
+	if path != "/api/events/hermes_deploy" || got["sha"] != "abc" {
+		t.Errorf("Expected the payload as event data, got %s %v", path, got)
+	}
+
+	config = map[string]any{"base_url": srv.URL, "token": "llat", "operation": "call_service", "service": "light.turn_off"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected invalid service data to fail permanently, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"token": "t", "operation": "call_service", "service": "light.turn_on"},
+		{"base_url": "http://ha:8123", "operation": "call_service", "service": "light.turn_on"},
+		{"base_url": "http://ha:8123", "token": "t", "operation": "call_service", "service": "../config"},
+		{"base_url": "http://ha:8123", "token": "t", "operation": "fire_event", "event_type": "a/b"},
+		{"base_url": "http://ha:8123", "token": "t", "operation": "call_service", "service": "light.turn_on", "data": "x"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package jenkins triggers Jenkins jobs through the remote access API
+package jenkins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Job names, with folders separated by slashes, e.g. ops/deploy-app. A
+// segment can't be all dots
+var validJob = regexp.MustCompile(`^[A-Za-z0-9_.-]*[A-Za-z0-9_-][A-Za-z0-9_.-]*(/[A-Za-z0-9_.-]*[A-Za-z0-9_-][A-Za-z0-9_.-]*)*$`)
+
+type Client struct {
+	client *http.Client
+}
+
+// Base URLs are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(15 * time.Second)}
+}
+
+// Breaker key: the job on its controller
+func (c *Client) Target(config map[string]any) string {
+	base, err := baseURL(config)
+	job, _ := config["job"].(string)
+	if err != nil || !validJob.MatchString(job) {
+		return ""
+	}
+	return base.Host + "/" + job
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"base_url": "https://jenkins.example.com", "user": "hermes", "api_token": "{{secret:JENKINS_TOKEN}}",
+//	 "job": "ops/deploy-app", "parameters": {"SHA": "{{after}}", "BRANCH": "{{ref}}"}}
+//
+// job may name a job inside folders. parameters are mapping templates filled
+// from the payload; without them the job is built with its defaults.
+// build_token sets the job's "Trigger builds remotely" token when the job uses
+// one. A CSRF crumb is fetched first when the controller issues them
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	base, err := baseURL(config)
+	if err != nil {
+		return "", err
+	}
+	job, _ := config["job"].(string)
+	if !validJob.MatchString(job) {
+		return "", fmt.Errorf("missing or invalid job in jenkins action config")
+	}
+	user, _ := config["user"].(string)
+	token, _ := config["api_token"].(string)
+	if user == "" || token == "" {
+		return "", fmt.Errorf("jenkins action config needs user and api_token")
+	}
+
+	form := url.Values{}
+	if raw, ok := config["parameters"]; ok {
+		params, ok := raw.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("parameters must be an object of templates")
+		}
+		for name, item := range params {
+			tmpl, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("parameter %s must be a string", name)
+			}
+			value, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return "", fmt.Errorf("parameters.%s: %w", name, err)
+			}
+			form.Set(name, value)
+		}
+	}
+	endpoint := base.String() + "/job/" + strings.ReplaceAll(job, "/", "/job/")
+	if len(form) > 0 {
+		endpoint += "/buildWithParameters"
+	} else {
+		endpoint += "/build"
+	}
+	if buildToken, _ := config["build_token"].(string); buildToken != "" {
+		endpoint += "?token=" + url.QueryEscape(buildToken)
+	}
+
+	crumbField, crumb, cookies, err := c.crumb(ctx, base, user, token)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(user, token)
+	if crumb != "" {
+		req.Header.Set(crumbField, crumb)
+		// Crumbs are bound to the session they were issued in
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 300 {
+		// The Location header points at the queue item
+		return fmt.Sprintf("%d: queued %s", resp.StatusCode, resp.Header.Get("Location")), nil
+	}
+	return "", classify(resp.StatusCode, data)
+}
+
+// Fetches a CSRF crumb. Controllers with CSRF protection off answer 404,
+// and no crumb is sent then
+func (c *Client) crumb(ctx context.Context, base *url.URL, user, token string) (string, string, []*http.Cookie, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.String()+"/crumbIssuer/api/json", nil)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("build request: %w", err)
+	}
+	req.SetBasicAuth(user, token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound {
+		return "", "", nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		return "", "", nil, classify(resp.StatusCode, data)
+	}
+	var result struct {
+		Crumb             string `json:"crumb"`
+		CrumbRequestField string `json:"crumbRequestField"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Crumb == "" || result.CrumbRequestField == "" {
+		return "", "", nil, fmt.Errorf("unexpected jenkins crumb response: %s", data)
+	}
+	return result.CrumbRequestField, result.Crumb, resp.Cookies(), nil
+}
+
+func classify(status int, data []byte) error {
+	statusErr := &engine.StatusError{
+		StatusCode: status,
+		Msg:        fmt.Sprintf("jenkins returned %d: %s", status, firstLine(data)),
+	}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return statusErr
+	}
+	// Bad tokens, missing jobs and unknown parameters fail the same way every time
+	return &engine.PermanentError{Err: statusErr}
+}
+
+// Jenkins answers errors with whole HTML pages
+func firstLine(data []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return line
+}
+
+func baseURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["base_url"].(string)
+	base, err := url.Parse(strings.TrimRight(raw, "/"))
+	if raw == "" || err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("missing or invalid base_url in jenkins action config")
+	}
+	return base, nil
+}
@@ -0,0 +1,102 @@
+package jenkins
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestTriggersWithCrumb(t *testing.T) {
+	var path string
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "hermes" || pass != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/crumbIssuer/api/json" {
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "s1"})
+			w.Write([]byte(`{"crumb": "c1", "crumbRequestField": "Jenkins-Crumb"}`))
+			return
+		}
+		if cookie, _ := r.Cookie("JSESSIONID"); r.Header.Get("Jenkins-Crumb") != "c1" || cookie == nil || cookie.Value != "s1" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("No valid crumb was included in the request"))
+			return
+		}
+		path = r.URL.RequestURI()
+		r.ParseForm()
+		form = map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		w.Header().Set("Location", "https://jenkins.example.com/queue/item/12/")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"base_url": srv.URL, "user": "hermes", "api_token": "tok", "job": "ops/deploy-app",
+		"parameters": map[string]any{"SHA": "{{after}}"}, "build_token": "bt"}
+	response, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"after": "abc"}`))
+	if err != nil {
+		t.Fatalf("Expected the job to be triggered, got %v", err)
+	}
+	if path != "/job/ops/job/deploy-app/buildWithParameters?token=bt" || form["SHA"] != "abc" {
+		t.Errorf("Unexpected request %s %v", path, form)
+	}
+	if response != "201: queued https://jenkins.example.com/queue/item/12/" {
+		t.Errorf("Unexpected response %q", response)
+	}
+
+	config["api_token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, []byte(`{"after": "abc"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestTriggersWithoutCrumbIssuer(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/crumbIssuer/api/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		path = r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"base_url": srv.URL, "user": "hermes", "api_token": "tok", "job": "nightly"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err != nil {
+		t.Fatalf("Expected the job to be triggered, got %v", err)
+	}
+	if path != "/job/nightly/build" {
+		t.Errorf("Expected a build without parameters, got %s", path)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	if c.Target(map[string]any{"base_url": "https://jenkins", "job": "ops/.."}) != "" {
+		t.Error("Expected a dot segment to be rejected")
+	}
+	for _, config := range []map[string]any{
+		{"base_url": "ftp://jenkins", "user": "u", "api_token": "t", "job": "x"},
+		{"base_url": "https://jenkins", "user": "u", "api_token": "t", "job": "../script"},
+		{"base_url": "https://jenkins", "user": "u", "api_token": "t", "job": "ops/./x"},
+		{"base_url": "https://jenkins", "job": "x"},
+		{"base_url": "https://jenkins", "user": "u", "api_token": "t", "job": "x", "parameters": []any{"a"}},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+// Package jira creates and transitions issues on Jira Cloud and Jira
+// Server/Data Center
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	opCreateIssue = "create_issue"
+	opTransition  = "transition"
+	// Jira caps summaries at 255 characters and text fields at 32767
+	maxSummaryBytes     = 250
+	maxDescriptionBytes = 30000
+)
+
+var (
+	validProjectKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]+$`)
+	// Issue keys end up in the request path
+	validIssueKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]+-\d+$`)
+)
+
+type Client struct {
+	client *http.Client
+}
+
+// Server and Data Center sites are self hosted, so requests go through the
+// egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(15 * time.Second)}
+}
+
+// Breaker key: failures are tracked per site
+func (c *Client) Target(config map[string]any) string {
+	site, err := siteURL(config)
+	if err != nil {
+		return ""
+	}
+	return site.Host
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config for Jira Cloud, which authenticates with an account email and API token:
+//
+//	{"base_url": "https://acme.atlassian.net", "email": "bot@acme.com",
+//	 "api_token": "{{secret:JIRA_TOKEN}}", "operation": "create_issue",
+//	 "project": "OPS", "issue_type": "Incident", "summary": "{{alert.title}}",
+//	 "description": "{{alert.summary}}", "labels": ["hermes"],
+//	 "fields": {"customfield_10010": "{{alert.severity}}", "priority": {"name": "High"}}}
+//
+// and for Server/Data Center, which takes a personal access token:
+//
+//	{"base_url": "https://jira.acme.com", "deployment": "server", "token": "{{secret:JIRA_PAT}}",
+//	 "operation": "transition", "issue_key": "{{issue.key}}", "transition": "Done",
+//	 "comment": "Resolved by {{user}}"}
+//
+// deployment defaults to cloud for atlassian.net sites and server otherwise.
+// Strings in summary, description, comment and fields are mapping templates
+// filled from the payload. transition is a transition id or name
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	site, err := siteURL(config)
+	if err != nil {
+		return "", err
+	}
+	api, err := newAPI(c.client, site, config)
+	if err != nil {
+		return "", err
+	}
+	switch operation, _ := config["operation"].(string); operation {
+	case opCreateIssue:
+		return createIssue(ctx, api, config, body)
+	case opTransition:
+		return transition(ctx, api, config, body)
+	default:
+		return "", fmt.Errorf("unknown operation %q in jira action config", operation)
+	}
+}
+
+// One site's REST API, v3 on Cloud and v2 on Server
+type restAPI struct {
+	client *http.Client
+	base   string
+	cloud  bool
+	auth   func(*http.Request)
+}
+
+func newAPI(client *http.Client, site *url.URL, config map[string]any) (*restAPI, error) {
+	deployment, _ := config["deployment"].(string)
+	if deployment == "" {
+		deployment = "server"
+		if strings.HasSuffix(site.Hostname(), ".atlassian.net") {
+			deployment = "cloud"
+		}
+	}
+	api := &restAPI{client: client}
+	switch deployment {
+	case "cloud":
+		email, _ := config["email"].(string)
+		token, _ := config["api_token"].(string)
+		if email == "" || token == "" {
+			return nil, fmt.Errorf("jira cloud needs email and api_token in the action config")
+		}
+		api.cloud = true
+		api.base = site.String() + "/rest/api/3"
+		api.auth = func(r *http.Request) { r.SetBasicAuth(email, token) }
+	case "server":
+		token, _ := config["token"].(string)
+		if token == "" {
+			return nil, fmt.Errorf("jira server needs token in the action config")
+		}
+		api.base = site.String() + "/rest/api/2"
+		api.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	default:
+		return nil, fmt.Errorf("unknown deployment %q in jira action config", deployment)
+	}
+	return api, nil
+}
+
+// Cloud's v3 API only takes rich text as Atlassian Document Format
+func (a *restAPI) text(s string) any {
+	if !a.cloud {
+		return s
+	}
+	var paragraphs []any
+	for _, line := range strings.Split(s, "\n") {
+		paragraph := map[string]any{"type": "paragraph"}
+		if line != "" {
+			paragraph["content"] = []any{map[string]any{"type": "text", "text": line}}
+		}
+		paragraphs = append(paragraphs, paragraph)
+	}
+	return map[string]any{"type": "doc", "version": 1, "content": paragraphs}
+}
+
+func createIssue(ctx context.Context, api *restAPI, config map[string]any, body []byte) (string, error) {
+	project, _ := config["project"].(string)
+	if !validProjectKey.MatchString(project) {
+		return "", fmt.Errorf("missing or invalid project key in jira action config")
+	}
+	issueType, _ := config["issue_type"].(string)
+	if issueType == "" {
+		issueType = "Task"
+	}
+	summary, err := render(config, "summary", body)
+	if err != nil {
+		return "", err
+	}
+	// Summaries are single line
+	summary = strings.TrimSpace(strings.ReplaceAll(summary, "\n", " "))
+	if summary == "" {
+		return "", fmt.Errorf("missing summary in jira action config")
+	}
+	fields := map[string]any{
+		"project":   map[string]string{"key": project},
+		"issuetype": map[string]string{"name": issueType},
+		"summary":   payload.TruncateString(summary, maxSummaryBytes),
+	}
+	description, err := render(config, "description", body)
+	if err != nil {
+		return "", err
+	}
+	if description != "" {
+		fields["description"] = api.text(payload.TruncateString(description, maxDescriptionBytes))
+	}
+	if labels, ok := config["labels"].([]any); ok {
+		fields["labels"] = labels
+	}
+	if raw, ok := config["fields"]; ok {
+		custom, ok := raw.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("fields must be an object keyed by field id")
+		}
+		for id, value := range custom {
+			rendered, err := renderValue(value, body)
+			if err != nil {
+				return "", fmt.Errorf("fields.%s: %w", id, err)
+			}
+			fields[id] = rendered
+		}
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	status, err := api.call(ctx, http.MethodPost, "/issue", map[string]any{"fields": fields}, &created)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d: created %s", status, created.Key), nil
+}
+
+func transition(ctx context.Context, api *restAPI, config map[string]any, body []byte) (string, error) {
+	key, err := render(config, "issue_key", body)
+	if err != nil {
+		return "", err
+	}
+	key = strings.TrimSpace(key)
+	if !validIssueKey.MatchString(key) {
+		return "", &engine.PermanentError{Err: fmt.Errorf("invalid issue_key %q", key)}
+	}
+	wanted, _ := config["transition"].(string)
+	if wanted == "" {
+		return "", fmt.Errorf("missing transition in jira action config")
+	}
+	id, err := api.transitionID(ctx, key, wanted)
+	if err != nil {
+		return "", err
+	}
+	req := map[string]any{"transition": map[string]string{"id": id}}
+	comment, err := render(config, "comment", body)
+	if err != nil {
+		return "", err
+	}
+	if comment != "" {
+		req["update"] = map[string]any{"comment": []any{
+			map[string]any{"add": map[string]any{"body": api.text(payload.TruncateString(comment, maxDescriptionBytes))}},
+		}}
+	}
+	status, err := api.call(ctx, http.MethodPost, "/issue/"+key+"/transitions", req, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d: transitioned %s", status, key), nil
+}
+
+// Resolves a transition name to the id the issue's workflow uses for it. Ids
+// are passed through untouched
+func (a *restAPI) transitionID(ctx context.Context, key, wanted string) (string, error) {
+	if strings.Trim(wanted, "0123456789") == "" {
+		return wanted, nil
+	}
+	var available struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if _, err := a.call(ctx, http.MethodGet, "/issue/"+key+"/transitions", nil, &available); err != nil {
+		return "", err
+	}
+	var names []string
+	for _, t := range available.Transitions {
+		if strings.EqualFold(t.Name, wanted) {
+			return t.ID, nil
+		}
+		names = append(names, t.Name)
+	}
+	return "", &engine.PermanentError{Err: fmt.Errorf("%s has no transition %q, available: %s", key, wanted, strings.Join(names, ", "))}
+}
+
+func (a *restAPI) call(ctx context.Context, method, path string, req any, out any) (int, error) {
+	var reqBody io.Reader
+	if req != nil {
+		jsonBody, err := json.Marshal(req)
+		if err != nil {
+			return 0, fmt.Errorf("marshal jira request: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, a.base+path, reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	a.auth(httpReq)
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 300 {
+		if out != nil && len(data) > 0 {
+			if err := json.Unmarshal(data, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("decode jira response: %w", err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("jira returned %d: %s", resp.StatusCode, errorDetail(data)),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return resp.StatusCode, statusErr
+	}
+	// Field validation failures, missing issues and bad credentials fail the same way every time
+	return resp.StatusCode, &engine.PermanentError{Err: statusErr}
+}
+
+// Flattens Jira's error body. Field validation failures come keyed by field
+// id, e.g. {"errors": {"customfield_10010": "Severity is required"}}
+func errorDetail(data []byte) string {
+	var body struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return strings.TrimSpace(payload.TruncateString(string(data), 512))
+	}
+	parts := append([]string(nil), body.ErrorMessages...)
+	fields := make([]string, 0, len(body.Errors))
+	for field := range body.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		parts = append(parts, field+": "+body.Errors[field])
+	}
+	return strings.Join(parts, "; ")
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
+
+// Fills the templates in every string of a field value, leaving other values alone
+func renderValue(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderValue(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderValue(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func siteURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["base_url"].(string)
+	site, err := url.Parse(strings.TrimRight(raw, "/"))
+	if err != nil || (site.Scheme != "http" && site.Scheme != "https") || site.Host == "" {
+		return nil, fmt.Errorf("missing or invalid base_url in jira action config")
+	}
+	return site, nil
+}
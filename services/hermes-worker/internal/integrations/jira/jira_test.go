@@ -0,0 +1,107 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestCreatesIssueOnServer(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/2/issue" || r.Header.Get("Authorization") != "Bearer pat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fields := got["fields"].(map[string]any)
+		if fields["customfield_10010"] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errorMessages": [], "errors": {"customfield_10010": "Severity is required"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "10001", "key": "OPS-7"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"base_url": srv.URL, "token": "pat", "operation": "create_issue",
+		"project": "OPS", "issue_type": "Incident", "summary": "{{alert}} is firing", "description": "on {{host}}",
+		"fields": map[string]any{"customfield_10010": "{{severity}}", "priority": map[string]any{"name": "High"}}}
+	response, err := c.ExecuteWithResponse(context.Background(), config,
+		[]byte(`{"alert": "disk", "host": "db1", "severity": "sev2"}`))
+	if err != nil {
+		t.Fatalf("Expected the issue to be created, got %v", err)
+	}
+	if response != "201: created OPS-7" {
+		t.Errorf("Expected the issue key in the response, got %q", response)
+	}
+	fields := got["fields"].(map[string]any)
+	if fields["summary"] != "disk is firing" || fields["description"] != "on db1" || fields["customfield_10010"] != "sev2" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+
+	_, err = c.ExecuteWithResponse(context.Background(), config, []byte(`{"alert": "disk", "host": "db1", "severity": ""}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "customfield_10010: Severity is required") {
+		t.Errorf("Expected a permanent field validation error, got %v", err)
+	}
+}
+
+func TestTransitionsByNameOnCloud(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "bot@acme.com" || pass != "tok" || r.URL.Path != "/rest/api/3/issue/OPS-7/transitions" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"transitions": [{"id": "11", "name": "In Progress"}, {"id": "31", "name": "Done"}]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"base_url": srv.URL, "deployment": "cloud", "email": "bot@acme.com", "api_token": "tok",
+		"operation": "transition", "issue_key": "{{key}}", "transition": "done", "comment": "closed by {{user}}"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"key": "OPS-7", "user": "ada"}`)); err != nil {
+		t.Fatalf("Expected the transition to succeed, got %v", err)
+	}
+	encoded, _ := json.Marshal(got)
+	want := `{"transition":{"id":"31"},"update":{"comment":[{"add":{"body":{"content":[{"content":[{"text":"closed by ada","type":"text"}],"type":"paragraph"}],"type":"doc","version":1}}}]}}`
+	if string(encoded) != want {
+		t.Errorf("Expected %s, got %s", want, encoded)
+	}
+
+	config["transition"] = "Reopen"
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"key": "OPS-7", "user": "ada"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected an unknown transition to fail permanently, got %v", err)
+	}
+}
+
+func TestRejectsInvalidConfig(t *testing.T) {
+	c := New(nil)
+	for _, config := range []map[string]any{
+		{"base_url": "jira.acme.com", "token": "t", "operation": "create_issue", "project": "OPS", "summary": "x"},
+		{"base_url": "https://jira.acme.com", "operation": "create_issue", "project": "OPS", "summary": "x"},
+		{"base_url": "https://jira.acme.com", "token": "t", "operation": "create_issue", "project": "ops/../x", "summary": "x"},
+		{"base_url": "https://jira.acme.com", "token": "t", "operation": "transition", "issue_key": "../../x", "transition": "1"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
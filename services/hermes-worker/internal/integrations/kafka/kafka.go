@@ -0,0 +1,260 @@
+// Package kafka produces events to Kafka topics, bridging webhooks into
+// internal event streams
+package kafka
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// Writes are synchronous and carry one message, so there is no batch to wait for
+	batchTimeout = 5 * time.Millisecond
+)
+
+// Topic names as Kafka accepts them
+var validTopic = regexp.MustCompile(`^[A-Za-z0-9._-]{1,249}$`)
+
+var acks = map[string]kafka.RequiredAcks{
+	"all":    kafka.RequireAll,
+	"leader": kafka.RequireOne,
+	"none":   kafka.RequireNone,
+}
+
+type Producer struct {
+	egress *engine.Egress
+
+	mu      sync.Mutex
+	writers map[string]*kafka.Writer
+}
+
+// Brokers are user supplied, so connections go through the egress policy
+func New(egress *engine.Egress) *Producer {
+	return &Producer{egress: egress, writers: map[string]*kafka.Writer{}}
+}
+
+// Breaker key: the first broker and the topic
+func (p *Producer) Target(config map[string]any) string {
+	brokers, err := brokerList(config)
+	topic, _ := config["topic"].(string)
+	if err != nil || !validTopic.MatchString(topic) {
+		return ""
+	}
+	return brokers[0] + "/" + topic
+}
+
+func (p *Producer) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := p.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"brokers": ["kafka-1.example.com:9092"], "topic": "webhooks.github",
+//	 "key": "{{repository.full_name}}", "headers": {"event-type": "{{action}}"},
+//	 "acks": "all", "tls": true,
+//	 "sasl": {"mechanism": "scram-sha-512", "username": "hermes", "password": "{{secret:KAFKA_PASSWORD}}"}}
+//
+// key and header values are mapping templates filled from the payload. The
+// payload is sent as it is, or reshaped first when mappings (and keep_unmapped)
+// are set as on a map action. acks is all (the default), leader or none. The
+// event's idempotency key goes along as an Idempotency-Key header
+func (p *Producer) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	topic, _ := config["topic"].(string)
+	if !validTopic.MatchString(topic) {
+		return "", fmt.Errorf("missing or invalid topic in kafka action config")
+	}
+	msg, err := buildMessage(ctx, config, body)
+	if err != nil {
+		return "", err
+	}
+	msg.Topic = topic
+	writer, err := p.writer(config)
+	if err != nil {
+		return "", err
+	}
+	if err := writer.WriteMessages(ctx, msg); err != nil {
+		return "", classify(err)
+	}
+	return fmt.Sprintf("produced %d bytes to %s", len(msg.Value), topic), nil
+}
+
+// Renders the key and headers and shapes the value
+func buildMessage(ctx context.Context, config map[string]any, body []byte) (kafka.Message, error) {
+	var msg kafka.Message
+	if tmpl, _ := config["key"].(string); tmpl != "" {
+		key, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return msg, fmt.Errorf("key: %w", err)
+		}
+		msg.Key = []byte(key)
+	}
+	if raw, ok := config["headers"]; ok {
+		headers, ok := raw.(map[string]any)
+		if !ok {
+			return msg, fmt.Errorf("headers must map header names to templates")
+		}
+		names := make([]string, 0, len(headers))
+		for name := range headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tmpl, ok := headers[name].(string)
+			if !ok {
+				return msg, fmt.Errorf("header %s must be a string", name)
+			}
+			value, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return msg, fmt.Errorf("header %s: %w", name, err)
+			}
+			msg.Headers = append(msg.Headers, kafka.Header{Key: name, Value: []byte(value)})
+		}
+	}
+	if key, ok := idempotency.FromContext(ctx); ok {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: idempotency.Header, Value: []byte(key.Key)})
+	}
+	msg.Value = body
+	if _, ok := config["mappings"]; ok {
+		spec, err := mapping.ParseSpec(config)
+		if err != nil {
+			return msg, err
+		}
+		if msg.Value, err = mapping.Apply(spec, body); err != nil {
+			return msg, &engine.PermanentError{Err: err}
+		}
+	}
+	return msg, nil
+}
+
+func brokerList(config map[string]any) ([]string, error) {
+	raw, _ := config["brokers"].([]any)
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("missing brokers in kafka action config")
+	}
+	brokers := make([]string, len(raw))
+	for n, b := range raw {
+		broker, _ := b.(string)
+		if broker == "" || !strings.Contains(broker, ":") {
+			return nil, fmt.Errorf("broker %d must be a host:port string", n)
+		}
+		brokers[n] = broker
+	}
+	return brokers, nil
+}
+
+func mechanism(config map[string]any) (sasl.Mechanism, error) {
+	raw, ok := config["sasl"]
+	if !ok {
+		return nil, nil
+	}
+	auth, _ := raw.(map[string]any)
+	name, _ := auth["mechanism"].(string)
+	username, _ := auth["username"].(string)
+	password, _ := auth["password"].(string)
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("sasl needs a username and password")
+	}
+	switch strings.ToLower(name) {
+	case "plain", "":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("unknown sasl mechanism %q, want plain, scram-sha-256 or scram-sha-512", name)
+}
+
+// One writer per cluster, credentials and acks, opened on first use. Writers
+// are keyed by a hash so the password doesn't sit in a map key; the topic is
+// set per message
+func (p *Producer) writer(config map[string]any) (*kafka.Writer, error) {
+	brokers, err := brokerList(config)
+	if err != nil {
+		return nil, err
+	}
+	required := kafka.RequireAll
+	if name, _ := config["acks"].(string); name != "" {
+		var ok bool
+		if required, ok = acks[name]; !ok {
+			return nil, fmt.Errorf("unknown acks %q in kafka action config, want all, leader or none", name)
+		}
+	}
+	auth, err := mechanism(config)
+	if err != nil {
+		return nil, err
+	}
+	useTLS, _ := config["tls"].(bool)
+	sum := sha256.Sum256(fmt.Appendf(nil, "%q %v %d %v", brokers, useTLS, required, config["sasl"]))
+	key := hex.EncodeToString(sum[:])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if writer, ok := p.writers[key]; ok {
+		return writer, nil
+	}
+	transport := &kafka.Transport{
+		// Brokers named in cluster metadata are dialed the same way
+		Dial:        p.egress.DialContext(),
+		DialTimeout: dialTimeout,
+		ClientID:    "hermes-worker",
+		SASL:        auth,
+	}
+	if useTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: required,
+		BatchTimeout: batchTimeout,
+		// Failed steps go through the worker's retries, not the writer's
+		MaxAttempts: 1,
+		Transport:   transport,
+	}
+	p.writers[key] = writer
+	return writer, nil
+}
+
+// Closes every open writer
+func (p *Producer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, writer := range p.writers {
+		_ = writer.Close()
+		delete(p.writers, key)
+	}
+}
+
+// Broker errors Kafka doesn't flag as retriable, like a failed login, a denied
+// topic or an oversized message, fail the same way every time
+func classify(err error) error {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) && len(writeErrs) > 0 && writeErrs[0] != nil {
+		err = writeErrs[0]
+	}
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && !kafkaErr.Temporary() {
+		return &engine.PermanentError{Err: fmt.Errorf("kafka: %w", err)}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestBuildMessage(t *testing.T) {
+	ctx := idempotency.WithContext(context.Background(), idempotency.Value{Key: "k1"})
+	config := map[string]any{
+		"key":      "{{repo}}",
+		"headers":  map[string]any{"event-type": "{{action}}"},
+		"mappings": []any{map[string]any{"from": "action", "to": "type"}},
+	}
+	msg, err := buildMessage(ctx, config, []byte(`{"repo": "octo/app", "action": "opened"}`))
+	if err != nil {
+		t.Fatalf("Expected the message to build, got %v", err)
+	}
+	if string(msg.Key) != "octo/app" || string(msg.Value) != `{"type":"opened"}` {
+		t.Errorf("Unexpected key %s or value %s", msg.Key, msg.Value)
+	}
+	want := []kafka.Header{{Key: "event-type", Value: []byte("opened")}, {Key: idempotency.Header, Value: []byte("k1")}}
+	if len(msg.Headers) != len(want) {
+		t.Fatalf("Expected %d headers, got %v", len(want), msg.Headers)
+	}
+	for n, h := range want {
+		if msg.Headers[n].Key != h.Key || string(msg.Headers[n].Value) != string(h.Value) {
+			t.Errorf("Expected header %s=%s, got %s=%s", h.Key, h.Value, msg.Headers[n].Key, msg.Headers[n].Value)
+		}
+	}
+}
+
+func TestBuildMessageSendsPayloadAsIs(t *testing.T) {
+	body := []byte(`{"a": 1}`)
+	msg, err := buildMessage(context.Background(), map[string]any{}, body)
+	if err != nil || string(msg.Value) != string(body) || msg.Key != nil || len(msg.Headers) != 0 {
+		t.Errorf("Expected the bare payload, got %+v (%v)", msg, err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	p := New(&engine.Egress{})
+	defer p.Close()
+	for _, config := range []map[string]any{
+		{"brokers": []any{"kafka:9092"}},
+		{"brokers": []any{"kafka:9092"}, "topic": "bad topic"},
+		{"brokers": []any{}, "topic": "events"},
+		{"brokers": []any{"kafka"}, "topic": "events"},
+		{"brokers": []any{"kafka:9092"}, "topic": "events", "acks": "some"},
+		{"brokers": []any{"kafka:9092"}, "topic": "events", "sasl": map[string]any{"mechanism": "gssapi", "username": "u", "password": "p"}},
+		{"brokers": []any{"kafka:9092"}, "topic": "events", "sasl": map[string]any{"username": "u"}},
+	} {
+		if _, err := p.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	var p *engine.PermanentError
+	if err := classify(kafka.WriteErrors{kafka.TopicAuthorizationFailed}); !errors.As(err, &p) {
+		t.Errorf("Expected a denied topic to be permanent, got %v", err)
+	}
+	if err := classify(kafka.LeaderNotAvailable); errors.As(err, &p) {
+		t.Errorf("Expected a leader election to be retryable, got %v", err)
+	}
+}
+
+func TestEgressPolicyBlocksPrivateBrokers(t *testing.T) {
+	p := New(&engine.Egress{})
+	defer p.Close()
+	config := map[string]any{"brokers": []any{"127.0.0.1:9092"}, "topic": "events"}
+	_, err := p.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	if !errors.Is(err, engine.ErrEgressDenied) {
+		t.Errorf("Expected the egress policy to refuse loopback, got %v", err)
+	}
+}
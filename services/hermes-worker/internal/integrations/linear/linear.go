@@ -0,0 +1,289 @@
+// Package linear creates issues and comments through Linear's GraphQL API
+package linear
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL           = "https://api.linear.app/graphql"
+	opCreateIssue    = "create_issue"
+	opComment        = "comment"
+	maxTitleBytes    = 250
+	maxMarkdownBytes = 60000
+)
+
+// Linear's priority scale, 0 meaning no priority
+var priorities = map[string]int{"none": 0, "urgent": 1, "high": 2, "medium": 3, "normal": 3, "low": 4}
+
+const createIssueMutation = `mutation($input: IssueCreateInput!) {
+  issueCreate(input: $input) { success issue { identifier url } }
+}`
+
+const commentMutation = `mutation($input: CommentCreateInput!) {
+  commentCreate(input: $input) { success comment { url } }
+}`
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), url: apiURL}
+}
+
+// Breaker key: the API as a whole
+func (c *Client) Target(config map[string]any) string {
+	return "api.linear.app"
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"api_key": "{{secret:LINEAR_KEY}}", "operation": "create_issue",
+//	 "team_id": "{{team}}", "title": "{{alert.title}}", "description": "{{alert.summary}}",
+//	 "priority": "{{alert.severity}}", "label_ids": ["9a1e...", "{{label}}"]}
+//	{"api_key": "...", "operation": "comment", "issue_id": "{{issue.id}}", "body": "Deployed {{sha}}"}
+//
+// Every string is a mapping template filled from the payload. priority is
+// 0-4 or none, urgent, high, medium, low. issue_id takes an id or an
+// identifier such as ENG-123
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	apiKey, _ := config["api_key"].(string)
+	if apiKey == "" {
+		return "", fmt.Errorf("missing api_key in linear action config")
+	}
+	var query string
+	var input map[string]any
+	var err error
+	switch operation, _ := config["operation"].(string); operation {
+	case opCreateIssue:
+		query = createIssueMutation
+		input, err = issueInput(config, body)
+	case opComment:
+		query = commentMutation
+		input, err = commentInput(config, body)
+	default:
+		return "", fmt.Errorf("unknown operation %q in linear action config", operation)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		IssueCreate struct {
+			Success bool `json:"success"`
+			Issue   struct {
+				Identifier string `json:"identifier"`
+				URL        string `json:"url"`
+			} `json:"issue"`
+		} `json:"issueCreate"`
+		CommentCreate struct {
+			Success bool `json:"success"`
+			Comment struct {
+				URL string `json:"url"`
+			} `json:"comment"`
+		} `json:"commentCreate"`
+	}
+	if err := c.graphql(ctx, apiKey, query, map[string]any{"input": input}, &result); err != nil {
+		return "", err
+	}
+	if query == createIssueMutation {
+		if !result.IssueCreate.Success {
+			return "", &engine.PermanentError{Err: fmt.Errorf("linear did not create the issue")}
+		}
+		return fmt.Sprintf("created %s %s", result.IssueCreate.Issue.Identifier, result.IssueCreate.Issue.URL), nil
+	}
+	if !result.CommentCreate.Success {
+		return "", &engine.PermanentError{Err: fmt.Errorf("linear did not create the comment")}
+	}
+	return "commented " + result.CommentCreate.Comment.URL, nil
+}
+
+func issueInput(config map[string]any, body []byte) (map[string]any, error) {
+	teamID, err := render(config, "team_id", body)
+	if err != nil {
+		return nil, err
+	}
+	title, err := render(config, "title", body)
+	if err != nil {
+		return nil, err
+	}
+	if teamID == "" || title == "" {
+		return nil, fmt.Errorf("linear create_issue needs team_id and title")
+	}
+	input := map[string]any{"teamId": teamID, "title": payload.TruncateString(title, maxTitleBytes)}
+	description, err := render(config, "description", body)
+	if err != nil {
+		return nil, err
+	}
+	if description != "" {
+		input["description"] = payload.TruncateString(description, maxMarkdownBytes)
+	}
+	if _, ok := config["priority"]; ok {
+		priority, err := renderPriority(config["priority"], body)
+		if err != nil {
+			return nil, err
+		}
+		input["priority"] = priority
+	}
+	if raw, ok := config["label_ids"]; ok {
+		list, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("label_ids must be a list of strings")
+		}
+		var labels []string
+		for _, item := range list {
+			tmpl, _ := item.(string)
+			label, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("label_ids: %w", err)
+			}
+			if label = strings.TrimSpace(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
+		input["labelIds"] = labels
+	}
+	for key, field := range map[string]string{"project_id": "projectId", "assignee_id": "assigneeId", "state_id": "stateId"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			input[field] = value
+		}
+	}
+	return input, nil
+}
+
+func commentInput(config map[string]any, body []byte) (map[string]any, error) {
+	issueID, err := render(config, "issue_id", body)
+	if err != nil {
+		return nil, err
+	}
+	text, err := render(config, "body", body)
+	if err != nil {
+		return nil, err
+	}
+	if issueID == "" || text == "" {
+		return nil, fmt.Errorf("linear comment needs issue_id and body")
+	}
+	return map[string]any{"issueId": issueID, "body": payload.TruncateString(text, maxMarkdownBytes)}, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Accepts 0-4 or a priority name, directly or rendered from the payload
+func renderPriority(raw any, body []byte) (int, error) {
+	var text string
+	switch v := raw.(type) {
+	case float64:
+		text = strconv.Itoa(int(v))
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return 0, fmt.Errorf("priority: %w", err)
+		}
+		text = strings.ToLower(strings.TrimSpace(rendered))
+	}
+	if priority, ok := priorities[text]; ok {
+		return priority, nil
+	}
+	priority, err := strconv.Atoi(text)
+	if err != nil || priority < 0 || priority > 4 {
+		return 0, &engine.PermanentError{Err: fmt.Errorf("priority must be 0-4 or none, urgent, high, medium, low, got %q", text)}
+	}
+	return priority, nil
+}
+
+func (c *Client) graphql(ctx context.Context, apiKey, query string, variables map[string]any, out any) error {
+	jsonBody, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return fmt.Errorf("marshal linear request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Personal API keys go bare, OAuth tokens as bearer tokens
+	if strings.HasPrefix(apiKey, "lin_api_") {
+		req.Header.Set("Authorization", apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code        string `json:"code"`
+				UserMessage string `json:"userPresentableMessage"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 && len(result.Errors) == 0 {
+		return json.Unmarshal(result.Data, out)
+	}
+
+	status := resp.StatusCode
+	var messages []string
+	for _, e := range result.Errors {
+		msg := e.Message
+		if e.Extensions.UserMessage != "" {
+			msg = e.Extensions.UserMessage
+		}
+		messages = append(messages, msg)
+		switch e.Extensions.Code {
+		case "RATELIMITED":
+			status = http.StatusTooManyRequests
+		case "INTERNAL_SERVER_ERROR":
+			status = http.StatusInternalServerError
+		}
+	}
+	if status < 300 {
+		// GraphQL reports failures inside a 200
+		status = http.StatusBadRequest
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: status,
+		Msg:        fmt.Sprintf("linear returned %d: %s", status, strings.Join(messages, "; ")),
+	}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return statusErr
+	}
+	// Bad keys, unknown teams and invalid input fail the same way every time
+	return &engine.PermanentError{Err: statusErr}
+}
@@ -0,0 +1,81 @@
+package linear
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestCreatesIssue(t *testing.T) {
+	var got struct {
+		Variables struct {
+			Input map[string]any `json:"input"`
+		} `json:"variables"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "lin_api_key" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": [{"message": "Authentication required", "extensions": {"code": "AUTHENTICATION_ERROR"}}]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"data": {"issueCreate": {"success": true, "issue": {"identifier": "ENG-12", "url": "https://linear.app/acme/issue/ENG-12"}}}}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"api_key": "lin_api_key", "operation": "create_issue", "team_id": "{{team}}",
+		"title": "{{alert}} is firing", "priority": "{{severity}}", "label_ids": []any{"l1", "{{label}}"}}
+	body := []byte(`{"team": "t1", "alert": "disk", "severity": "High", "label": "l2"}`)
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil {
+		t.Fatalf("Expected the issue to be created, got %v", err)
+	}
+	if response != "created ENG-12 https://linear.app/acme/issue/ENG-12" {
+		t.Errorf("Unexpected response %q", response)
+	}
+	encoded, _ := json.Marshal(got.Variables.Input)
+	want := `{"labelIds":["l1","l2"],"priority":2,"teamId":"t1","title":"disk is firing"}`
+	if string(encoded) != want {
+		t.Errorf("Expected input %s, got %s", want, encoded)
+	}
+
+	config["api_key"] = "lin_api_wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected an authentication error to fail permanently, got %v", err)
+	}
+}
+
+func TestRateLimitIsRetryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": [{"message": "Rate limit exceeded", "extensions": {"code": "RATELIMITED"}}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"api_key": "lin_api_key", "operation": "comment", "issue_id": "ENG-1", "body": "hi"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	var statusErr *engine.StatusError
+	if errors.As(err, &p) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a retryable rate limit error, got %v", err)
+	}
+}
+
+func TestRejectsInvalidPriority(t *testing.T) {
+	c := New(nil)
+	config := map[string]any{"api_key": "k", "operation": "create_issue", "team_id": "t", "title": "x", "priority": "{{p}}"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"p": "critical"}`)); err == nil {
+		t.Error("Expected an unknown priority to be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+// Package mattermost posts to Mattermost incoming webhooks, for teams on
+// self-hosted chat
+package mattermost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Mattermost rejects posts over 16383 characters
+const maxTextBytes = 16000
+
+type Sender struct {
+	client *http.Client
+}
+
+// Mattermost servers are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Sender {
+	return &Sender{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: failures are tracked per webhook
+func (s *Sender) Target(config map[string]any) string {
+	return engine.EndpointOf(config, "webhook_url")
+}
+
+func (s *Sender) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"webhook_url": "https://chat.example.com/hooks/xxx", "channel": "deploys",
+//	 "text": "#### {{repository.name}} deployed", "username": "Hermes", "icon_emoji": "rocket",
+//	 "props": {"card": "Commit {{after}}"},
+//	 "attachments": [{"color": "#36a64f", "title": "{{head_commit.message}}", "title_link": "{{compare}}"}]}
+//
+// channel overrides the webhook's default channel, if the webhook allows it.
+// Strings in text, channel, props and attachments are mapping templates filled
+// from the payload. Without text or attachments the payload itself is posted
+func (s *Sender) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	webhookURL, _ := config["webhook_url"].(string)
+	if webhookURL == "" {
+		return "", fmt.Errorf("missing webhook_url in mattermost action config")
+	}
+	msg, err := buildMessage(config, body)
+	if err != nil {
+		return "", err
+	}
+	jsonBody, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("marshal mattermost message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, data)
+	if resp.StatusCode < 300 {
+		return response, nil
+	}
+	var result struct {
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("mattermost returned %d: %s", resp.StatusCode, result.Message),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return response, statusErr
+	}
+	// Unknown webhooks, disallowed channel overrides and bad attachments fail
+	// the same way every time
+	return response, &engine.PermanentError{Err: statusErr}
+}
+
+func buildMessage(config map[string]any, body []byte) (map[string]any, error) {
+	msg := map[string]any{}
+	for _, key := range []string{"username", "icon_url", "icon_emoji"} {
+		if value, _ := config[key].(string); value != "" {
+			msg[key] = value
+		}
+	}
+	for _, key := range []string{"text", "channel"} {
+		tmpl, _ := config[key].(string)
+		if tmpl == "" {
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		msg[key] = value
+	}
+	if text, ok := msg["text"].(string); ok {
+		msg["text"] = payload.TruncateString(text, maxTextBytes)
+	}
+	if raw, ok := config["props"]; ok {
+		if _, isObject := raw.(map[string]any); !isObject {
+			return nil, fmt.Errorf("props must be an object")
+		}
+		rendered, err := renderStrings(raw, body)
+		if err != nil {
+			return nil, fmt.Errorf("props: %w", err)
+		}
+		msg["props"] = rendered
+	}
+	if raw, ok := config["attachments"]; ok {
+		if _, isList := raw.([]any); !isList {
+			return nil, fmt.Errorf("attachments must be a list of objects")
+		}
+		rendered, err := renderStrings(raw, body)
+		if err != nil {
+			return nil, fmt.Errorf("attachments: %w", err)
+		}
+		msg["attachments"] = rendered
+	}
+	if msg["text"] == nil && msg["attachments"] == nil {
+		preview, _ := payload.Truncate(body, maxTextBytes-64)
+		msg["text"] = fmt.Sprintf("Payload:\n```json\n%s\n```", string(preview))
+	}
+	return msg, nil
+}
+
+// Fills the templates in every string, leaving other values alone
+func renderStrings(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
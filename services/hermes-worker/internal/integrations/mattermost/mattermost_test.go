@@ -0,0 +1,60 @@
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestPostsTemplatedMessage(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["channel"] == "locked" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"id": "web.incoming_webhook.channel_locked.app_error", "message": "This webhook is not permitted to post to the requested channel."}`))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"repo": "app", "sha": "abc", "team": "infra"}`)
+	config := map[string]any{"webhook_url": srv.URL + "/hooks/xyz", "channel": "{{team}}-deploys",
+		"text": "{{repo}} deployed", "icon_emoji": "rocket",
+		"props":       map[string]any{"card": "Commit {{sha}}"},
+		"attachments": []any{map[string]any{"title": "{{sha}}", "color": "#36a64f"}}}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the message to be posted, got %v", err)
+	}
+	props, _ := got["props"].(map[string]any)
+	attachments, _ := got["attachments"].([]any)
+	if got["channel"] != "infra-deploys" || got["text"] != "app deployed" || got["icon_emoji"] != "rocket" ||
+		props["card"] != "Commit abc" || len(attachments) != 1 || attachments[0].(map[string]any)["title"] != "abc" {
+		t.Errorf("Unexpected message %v", got)
+	}
+
+	config["channel"] = "locked"
+	_, err := s.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "not permitted") {
+		t.Errorf("Expected a locked channel to fail permanently, got %v", err)
+	}
+}
+
+func TestPostsPayloadWithoutText(t *testing.T) {
+	msg, err := buildMessage(map[string]any{}, []byte(`{"a": 1}`))
+	if err != nil || !strings.Contains(msg["text"].(string), `{"a": 1}`) {
+		t.Errorf("Expected the payload as text, got %v (%v)", msg, err)
+	}
+	if _, err := buildMessage(map[string]any{"props": "x"}, nil); err == nil {
+		t.Error("Expected props that aren't an object to be rejected")
+	}
+}
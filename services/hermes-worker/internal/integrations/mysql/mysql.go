@@ -0,0 +1,273 @@
+// Package mysql inserts events as rows into an external MySQL or MariaDB
+// database, the counterpart of the postgres action
+package mysql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// Each destination gets a small pool, the worker pool bounds concurrency
+	maxConnsPerDSN = 4
+	idleTimeout    = 5 * time.Minute
+	dialTimeout    = 5 * time.Second
+)
+
+// Table and column names are quoted, but only plain identifiers are accepted
+var validIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+type Inserter struct {
+	egress *engine.Egress
+
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+// Database hosts are user supplied, so connections go through the egress policy
+func New(egress *engine.Egress) *Inserter {
+	return &Inserter{egress: egress, dbs: map[string]*sql.DB{}}
+}
+
+// Breaker key: the database address and table, never the DSN with its password
+func (i *Inserter) Target(config map[string]any) string {
+	dsn, _ := config["dsn"].(string)
+	table, _ := config["table"].(string)
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s", cfg.Addr, cfg.DBName, table)
+}
+
+func (i *Inserter) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := i.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"dsn": "{{secret:WAREHOUSE_DSN}}", "table": "staging.github_pushes",
+//	 "columns": {"repo": "repository.full_name", "sha": "after",
+//	             "commits": {"from": "commits", "default": []},
+//	             "pushed_at": "head_commit.timestamp"},
+//	 "on_conflict": "ignore"}
+//
+// dsn uses the driver's format, e.g. "user:pass@tcp(db.example.com:3306)/warehouse".
+// columns maps each column to a payload path, or to a mapping rule with from,
+// value, default, type and required. Values are always sent as statement
+// parameters; objects and arrays go in as JSON text. on_conflict "ignore"
+// skips rows that collide with a unique key
+func (i *Inserter) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	dsn, _ := config["dsn"].(string)
+	if dsn == "" {
+		return "", fmt.Errorf("missing dsn in mysql action config")
+	}
+	query, spec, err := buildInsert(config)
+	if err != nil {
+		return "", err
+	}
+	row, err := mapping.Apply(spec, body)
+	if err != nil {
+		return "", &engine.PermanentError{Err: err}
+	}
+	var values map[string]any
+	if err := json.Unmarshal(row, &values); err != nil {
+		return "", fmt.Errorf("decode mapped row: %w", err)
+	}
+	args := make([]any, len(spec.Mappings))
+	for n, rule := range spec.Mappings {
+		args[n], err = toArg(values[rule.To])
+		if err != nil {
+			return "", fmt.Errorf("column %s: %w", rule.To, err)
+		}
+	}
+
+	db, err := i.db(dsn)
+	if err != nil {
+		return "", err
+	}
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return "", classify(err)
+	}
+	affected, _ := result.RowsAffected()
+	return fmt.Sprintf("inserted %d row(s)", affected), nil
+}
+
+// Builds the parameterized INSERT and the mapping that fills its parameters,
+// one rule per column in column order
+func buildInsert(config map[string]any) (string, *mapping.Spec, error) {
+	table, _ := config["table"].(string)
+	parts := strings.Split(table, ".")
+	if table == "" || len(parts) > 2 {
+		return "", nil, fmt.Errorf("missing or invalid table in mysql action config, want table or database.table")
+	}
+	for n, part := range parts {
+		if !validIdentifier.MatchString(part) {
+			return "", nil, fmt.Errorf("invalid table name %q", table)
+		}
+		parts[n] = quote(part)
+	}
+	columns, ok := config["columns"].(map[string]any)
+	if !ok || len(columns) == 0 {
+		return "", nil, fmt.Errorf("missing columns in mysql action config")
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		if !validIdentifier.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid column name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]any, len(names))
+	quoted := make([]string, len(names))
+	placeholders := make([]string, len(names))
+	for n, name := range names {
+		switch rule := columns[name].(type) {
+		case string:
+			rules[n] = map[string]any{"from": rule, "to": name}
+		case map[string]any:
+			withTarget := make(map[string]any, len(rule)+1)
+			for key, value := range rule {
+				withTarget[key] = value
+			}
+			withTarget["to"] = name
+			rules[n] = withTarget
+		default:
+			return "", nil, fmt.Errorf("column %s must map to a payload path or a rule", name)
+		}
+		quoted[n] = quote(name)
+		placeholders[n] = "?"
+	}
+	spec, err := mapping.ParseSpec(map[string]any{"mappings": rules})
+	if err != nil {
+		return "", nil, fmt.Errorf("columns: %w", err)
+	}
+
+	verb := "INSERT"
+	switch conflict, _ := config["on_conflict"].(string); conflict {
+	case "":
+	case "ignore":
+		verb = "INSERT IGNORE"
+	default:
+		return "", nil, fmt.Errorf("unknown on_conflict %q in mysql action config", conflict)
+	}
+	query := fmt.Sprintf("%s INTO %s (%s) VALUES (%s)",
+		verb, strings.Join(parts, "."), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	return query, spec, nil
+}
+
+// Names are already restricted to identifier characters, the backticks keep
+// reserved words like `order` usable
+func quote(name string) string {
+	return "`" + name + "`"
+}
+
+// The driver takes scalars only, objects and arrays are stored as JSON text
+func toArg(value any) (any, error) {
+	switch value.(type) {
+	case map[string]any, []any:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return value, nil
+}
+
+// One pool per DSN, opened on first use. Pools are keyed by a hash so the
+// password doesn't sit in a map key
+func (i *Inserter) db(dsn string) (*sql.DB, error) {
+	sum := sha256.Sum256([]byte(dsn))
+	key := hex.EncodeToString(sum[:])
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if db, ok := i.dbs[key]; ok {
+		return db, nil
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		// The parse error can echo the DSN, keep it out of step logs
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid dsn in mysql action config")}
+	}
+	// Unix sockets and named pipes would skip the egress check
+	if cfg.Net != "tcp" && cfg.Net != "tcp4" && cfg.Net != "tcp6" {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("mysql action only connects over tcp, got %q", cfg.Net)}
+	}
+	cfg.DialFunc = i.egress.DialContext()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = dialTimeout
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid dsn in mysql action config")}
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(maxConnsPerDSN)
+	db.SetMaxIdleConns(maxConnsPerDSN)
+	db.SetConnMaxIdleTime(idleTimeout)
+	i.dbs[key] = db
+	return db, nil
+}
+
+// Closes every open pool
+func (i *Inserter) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for key, db := range i.dbs {
+		_ = db.Close()
+		delete(i.dbs, key)
+	}
+}
+
+// Server errors that fail the same way every time: bad credentials, unknown
+// databases, tables or columns, syntax, and values or keys the table rejects.
+// Anything else, like a deadlock or too many connections, may pass on retry
+var permanentErrors = map[uint16]bool{
+	1044: true, // access denied to database
+	1045: true, // access denied for user
+	1048: true, // column cannot be null
+	1049: true, // unknown database
+	1054: true, // unknown column
+	1062: true, // duplicate entry
+	1064: true, // syntax error
+	1142: true, // command denied on table
+	1146: true, // table doesn't exist
+	1264: true, // out of range value
+	1292: true, // incorrect date/time value
+	1364: true, // field has no default value
+	1366: true, // incorrect value for column
+	1406: true, // data too long
+	1452: true, // foreign key constraint fails
+	3140: true, // invalid JSON text
+}
+
+func classify(err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		wrapped := fmt.Errorf("mysql %d: %s", myErr.Number, myErr.Message)
+		if permanentErrors[myErr.Number] {
+			return &engine.PermanentError{Err: wrapped}
+		}
+		return wrapped
+	}
+	return err
+}
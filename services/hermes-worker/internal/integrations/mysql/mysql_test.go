@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestBuildInsert(t *testing.T) {
+	config := map[string]any{"table": "staging.pushes", "on_conflict": "ignore", "columns": map[string]any{
+		"sha":   "after",
+		"order": "repository.full_name",
+		"size":  map[string]any{"from": "size", "type": "integer", "default": 0},
+	}}
+	query, spec, err := buildInsert(config)
+	if err != nil {
+		t.Fatalf("Expected the insert to build, got %v", err)
+	}
+	want := "INSERT IGNORE INTO `staging`.`pushes` (`order`, `sha`, `size`) VALUES (?, ?, ?)"
+	if query != want {
+		t.Errorf("Expected %s, got %s", want, query)
+	}
+	row, err := mapping.Apply(spec, []byte(`{"after": "abc", "repository": {"full_name": "octo/app"}}`))
+	if err != nil || string(row) != `{"order":"octo/app","sha":"abc","size":0}` {
+		t.Errorf("Unexpected row %s (%v)", row, err)
+	}
+}
+
+func TestBuildInsertRejectsUnsafeNames(t *testing.T) {
+	for _, config := range []map[string]any{
+		{"table": "pushes`; DROP TABLE users; --", "columns": map[string]any{"a": "a"}},
+		{"table": "a.b.c", "columns": map[string]any{"a": "a"}},
+		{"table": "pushes", "columns": map[string]any{"a b": "a"}},
+		{"table": "pushes", "columns": map[string]any{"a": "a"}, "on_conflict": "update"},
+	} {
+		if _, _, err := buildInsert(config); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestToArgEncodesObjects(t *testing.T) {
+	arg, err := toArg([]any{"a", 1.0})
+	if err != nil || arg != `["a",1]` {
+		t.Errorf("Expected arrays as JSON text, got %v (%v)", arg, err)
+	}
+	if arg, _ := toArg(2.5); arg != 2.5 {
+		t.Errorf("Expected scalars unchanged, got %v", arg)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	var p *engine.PermanentError
+	if err := classify(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}); !errors.As(err, &p) {
+		t.Errorf("Expected a duplicate entry to be permanent, got %v", err)
+	}
+	if err := classify(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}); errors.As(err, &p) {
+		t.Errorf("Expected a deadlock to be retryable, got %v", err)
+	}
+}
+
+func TestRejectsSocketDSN(t *testing.T) {
+	i := New(&engine.Egress{})
+	defer i.Close()
+	config := map[string]any{"dsn": "u:p@unix(/var/run/mysqld/mysqld.sock)/db", "table": "t",
+		"columns": map[string]any{"a": "a"}}
+	var p *engine.PermanentError
+	if _, err := i.ExecuteWithResponse(context.Background(), config, []byte(`{"a": 1}`)); !errors.As(err, &p) {
+		t.Errorf("Expected a unix socket DSN to be refused, got %v", err)
+	}
+}
+
+func TestEgressPolicyBlocksPrivateDatabases(t *testing.T) {
+	i := New(&engine.Egress{})
+	defer i.Close()
+	config := map[string]any{"dsn": "u:p@tcp(127.0.0.1:3306)/db?timeout=2s", "table": "t",
+		"columns": map[string]any{"a": "a"}}
+	_, err := i.ExecuteWithResponse(context.Background(), config, []byte(`{"a": 1}`))
+	if !errors.Is(err, engine.ErrEgressDenied) {
+		t.Errorf("Expected the egress policy to refuse loopback, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package notion adds pages to a Notion database, filling its properties from
+// payload fields
+package notion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL        = "https://api.notion.com/v1/pages"
+	notionVersion = "2022-06-28"
+	// Notion caps a rich text object at 2000 characters
+	maxTextBytes = 1900
+	maxAttempts  = 3
+	// Rate limits longer than this defer the job instead of holding a worker
+	maxRetryWait = 5 * time.Second
+)
+
+// Database ids with or without dashes
+var validDatabaseID = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second), url: apiURL}
+}
+
+// Breaker key: failures are tracked per database
+func (c *Client) Target(config map[string]any) string {
+	id, _ := config["database_id"].(string)
+	if !validDatabaseID.MatchString(id) {
+		return ""
+	}
+	return "api.notion.com/" + id
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:NOTION_TOKEN}}", "database_id": "0b3a...",
+//	 "properties": {
+//	   "Name": {"type": "title", "value": "{{alert.title}}"},
+//	   "Severity": {"type": "select", "value": "{{alert.severity}}"},
+//	   "Fired at": {"type": "date", "value": "{{alert.started_at}}"},
+//	   "Count": {"type": "number", "value": "{{alert.count}}"}}}
+//
+// Property types are title, rich_text, select, multi_select, date, number,
+// checkbox and url. Values are mapping templates filled from the payload;
+// dates take RFC 3339, YYYY-MM-DD or unix seconds
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in notion action config")
+	}
+	databaseID, _ := config["database_id"].(string)
+	if !validDatabaseID.MatchString(databaseID) {
+		return "", fmt.Errorf("missing or invalid database_id in notion action config")
+	}
+	specs, ok := config["properties"].(map[string]any)
+	if !ok || len(specs) == 0 {
+		return "", fmt.Errorf("missing properties in notion action config")
+	}
+	properties := make(map[string]any, len(specs))
+	for name, raw := range specs {
+		spec, _ := raw.(map[string]any)
+		value, err := property(spec, body)
+		if err != nil {
+			return "", fmt.Errorf("properties.%s: %w", name, err)
+		}
+		if value != nil {
+			properties[name] = value
+		}
+	}
+	jsonBody, err := json.Marshal(map[string]any{
+		"parent":     map[string]string{"database_id": databaseID},
+		"properties": properties,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal notion page: %w", err)
+	}
+
+	var lastErr error
+	for attempt := range maxAttempts {
+		response, wait, err := c.create(ctx, token, jsonBody)
+		if err == nil {
+			return response, nil
+		}
+		lastErr = err
+		if wait == 0 {
+			return response, err
+		}
+		if wait > maxRetryWait {
+			return response, &engine.DeferError{Delay: wait, Err: err}
+		}
+		if attempt < maxAttempts-1 && !sleep(ctx, wait) {
+			return response, ctx.Err()
+		}
+	}
+	return "", fmt.Errorf("notion page failed after retries: %w", lastErr)
+}
+
+// Creates the page. A non-zero wait means the failure is worth retrying
+func (c *Client) create(ctx context.Context, token string, jsonBody []byte) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", 0, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Notion-Version", notionVersion)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", time.Second, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		URL     string `json:"url"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: %s", resp.StatusCode, result.URL), 0, nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("notion returned %d: %s %s", resp.StatusCode, result.Code, result.Message),
+	}
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, data)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait := time.Second
+		if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && seconds > 0 {
+			wait = time.Duration(seconds * float64(time.Second))
+		}
+		return response, wait, statusErr
+	case resp.StatusCode >= 500:
+		return response, time.Second, statusErr
+	}
+	// Unknown properties, wrong types and unshared databases fail the same way every time
+	return response, 0, &engine.PermanentError{Err: statusErr}
+}
+
+// Builds one property value. Empty values are left out so Notion keeps its default
+func property(spec map[string]any, body []byte) (any, error) {
+	kind, _ := spec["type"].(string)
+	tmpl, _ := spec["value"].(string)
+	value, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" && kind != "checkbox" {
+		return nil, nil
+	}
+	switch kind {
+	case "title", "rich_text":
+		return map[string]any{kind: []any{map[string]any{
+			"type": "text",
+			"text": map[string]string{"content": payload.TruncateString(value, maxTextBytes)},
+		}}}, nil
+	case "select":
+		// Notion rejects option names containing commas
+		return map[string]any{"select": map[string]string{"name": strings.ReplaceAll(value, ",", " ")}}, nil
+	case "multi_select":
+		var options []any
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				options = append(options, map[string]string{"name": name})
+			}
+		}
+		return map[string]any{"multi_select": options}, nil
+	case "date":
+		start, err := date(value)
+		if err != nil {
+			return nil, &engine.PermanentError{Err: err}
+		}
+		return map[string]any{"date": map[string]string{"start": start}}, nil
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("%q is not a number", value)}
+		}
+		return map[string]any{"number": n}, nil
+	case "checkbox":
+		checked, _ := strconv.ParseBool(value)
+		return map[string]any{"checkbox": checked}, nil
+	case "url":
+		return map[string]any{"url": value}, nil
+	}
+	return nil, fmt.Errorf("unknown property type %q", kind)
+}
+
+func date(value string) (string, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Format(time.RFC3339), nil
+	}
+	if _, err := time.Parse(time.DateOnly, value); err == nil {
+		return value, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC().Format(time.RFC3339), nil
+	}
+	return "", fmt.Errorf("%q is not a date", value)
+}
+
+// Waits for d unless ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
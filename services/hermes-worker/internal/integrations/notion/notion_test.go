@@ -0,0 +1,101 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const testDatabase = "0b3a5f8e-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+
+func TestCreatesPageWithMappedProperties(t *testing.T) {
+	var got map[string]any
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// First attempt is throttled briefly
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"code": "rate_limited", "message": "slow down"}`))
+			return
+		}
+		if r.Header.Get("Notion-Version") == "" || r.Header.Get("Authorization") != "Bearer secret_x" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"url": "https://www.notion.so/page"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "secret_x", "database_id": testDatabase, "properties": map[string]any{
+		"Name":     map[string]any{"type": "title", "value": "{{alert}} is firing"},
+		"Severity": map[string]any{"type": "select", "value": "{{severity}}"},
+		"Fired at": map[string]any{"type": "date", "value": "{{at}}"},
+		"Count":    map[string]any{"type": "number", "value": "{{count}}"},
+		"Owner":    map[string]any{"type": "rich_text", "value": "{{owner}}"},
+	}}
+	body := []byte(`{"alert": "disk", "severity": "high", "at": 1700000000, "count": 3, "owner": ""}`)
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil {
+		t.Fatalf("Expected the page to be created, got %v", err)
+	}
+	if calls != 2 || response != "200: https://www.notion.so/page" {
+		t.Errorf("Expected a retried success, got %d calls and %q", calls, response)
+	}
+	encoded, _ := json.Marshal(got["properties"])
+	want := `{"Count":{"number":3},"Fired at":{"date":{"start":"2023-11-14T22:13:20Z"}},"Name":{"title":[{"text":{"content":"disk is firing"},"type":"text"}]},"Severity":{"select":{"name":"high"}}}`
+	if string(encoded) != want {
+		t.Errorf("Expected properties %s, got %s", want, encoded)
+	}
+}
+
+func TestLongRateLimitDefers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "t", "database_id": testDatabase,
+		"properties": map[string]any{"Name": map[string]any{"type": "title", "value": "x"}}}
+	_, err := c.ExecuteWithResponse(context.Background(), config, nil)
+	var d *engine.DeferError
+	if !errors.As(err, &d) || d.Delay != 30*time.Second {
+		t.Errorf("Expected a 30s deferral, got %v", err)
+	}
+}
+
+func TestValidationErrorsArePermanent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code": "validation_error", "message": "Severity is not a property that exists."}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"token": "t", "database_id": testDatabase,
+		"properties": map[string]any{"Severity": map[string]any{"type": "select", "value": "high"}}}
+	_, err := c.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a permanent validation error, got %v", err)
+	}
+
+	config["properties"] = map[string]any{"Count": map[string]any{"type": "number", "value": "many"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, nil); !errors.As(err, &p) {
+		t.Errorf("Expected a non-numeric number to fail permanently, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package postgres inserts events as rows into an external PostgreSQL
+// database, e.g. a warehouse staging table
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Each destination gets a small pool, the worker pool bounds concurrency
+	maxConnsPerDSN = 4
+	idleTimeout    = 5 * time.Minute
+)
+
+// Table and column names are quoted, but only plain identifiers are accepted
+var validIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+type Inserter struct {
+	egress *engine.Egress
+
+	mu    sync.Mutex
+	pools map[string]*pgxpool.Pool
+}
+
+// Database hosts are user supplied, so connections go through the egress policy
+func New(egress *engine.Egress) *Inserter {
+	return &Inserter{egress: egress, pools: map[string]*pgxpool.Pool{}}
+}
+
+// Breaker key: the database host and table, never the DSN with its password
+func (i *Inserter) Target(config map[string]any) string {
+	dsn, _ := config["dsn"].(string)
+	table, _ := config["table"].(string)
+	cfg, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d/%s/%s", cfg.Host, cfg.Port, cfg.Database, table)
+}
+
+func (i *Inserter) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := i.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"dsn": "{{secret:WAREHOUSE_DSN}}", "table": "staging.github_pushes",
+//	 "columns": {"repo": "repository.full_name", "sha": "after",
+//	             "commits": {"from": "commits", "default": []},
+//	             "pushed_at": "head_commit.timestamp"},
+//	 "on_conflict": "ignore"}
+//
+// columns maps each column to a payload path, or to a mapping rule with from,
+// value, default, type and required. Values are always sent as query
+// parameters; objects and arrays go in as JSON. on_conflict "ignore" skips
+// rows that violate a unique constraint
+func (i *Inserter) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	dsn, _ := config["dsn"].(string)
+	if dsn == "" {
+		return "", fmt.Errorf("missing dsn in postgres action config")
+	}
+	query, spec, err := buildInsert(config)
+	if err != nil {
+		return "", err
+	}
+	row, err := mapping.Apply(spec, body)
+	if err != nil {
+		return "", &engine.PermanentError{Err: err}
+	}
+	var values map[string]any
+	if err := json.Unmarshal(row, &values); err != nil {
+		return "", fmt.Errorf("decode mapped row: %w", err)
+	}
+	args := make([]any, len(spec.Mappings))
+	for n, rule := range spec.Mappings {
+		args[n] = values[rule.To]
+	}
+
+	pool, err := i.pool(ctx, dsn)
+	if err != nil {
+		return "", err
+	}
+	tag, err := pool.Exec(ctx, query, args...)
+	if err != nil {
+		return "", classify(err)
+	}
+	return fmt.Sprintf("inserted %d row(s)", tag.RowsAffected()), nil
+}
+
+// Builds the parameterized INSERT and the mapping that fills its parameters,
+// one rule per column in column order
+func buildInsert(config map[string]any) (string, *mapping.Spec, error) {
+	table, _ := config["table"].(string)
+	parts := strings.Split(table, ".")
+	if table == "" || len(parts) > 2 {
+		return "", nil, fmt.Errorf("missing or invalid table in postgres action config, want table or schema.table")
+	}
+	for _, part := range parts {
+		if !validIdentifier.MatchString(part) {
+			return "", nil, fmt.Errorf("invalid table name %q", table)
+		}
+	}
+	columns, ok := config["columns"].(map[string]any)
+	if !ok || len(columns) == 0 {
+		return "", nil, fmt.Errorf("missing columns in postgres action config")
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		if !validIdentifier.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid column name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]any, len(names))
+	quoted := make([]string, len(names))
+	placeholders := make([]string, len(names))
+	for n, name := range names {
+		switch rule := columns[name].(type) {
+		case string:
+			rules[n] = map[string]any{"from": rule, "to": name}
+		case map[string]any:
+			withTarget := make(map[string]any, len(rule)+1)
+			for key, value := range rule {
+				withTarget[key] = value
+			}
+			withTarget["to"] = name
+			rules[n] = withTarget
+		default:
+			return "", nil, fmt.Errorf("column %s must map to a payload path or a rule", name)
+		}
+		quoted[n] = pgx.Identifier{name}.Sanitize()
+		placeholders[n] = fmt.Sprintf("$%d", n+1)
+	}
+	spec, err := mapping.ParseSpec(map[string]any{"mappings": rules})
+	if err != nil {
+		return "", nil, fmt.Errorf("columns: %w", err)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		pgx.Identifier(parts).Sanitize(), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	switch conflict, _ := config["on_conflict"].(string); conflict {
+	case "":
+	case "ignore":
+		query += " ON CONFLICT DO NOTHING"
+	default:
+		return "", nil, fmt.Errorf("unknown on_conflict %q in postgres action config", conflict)
+	}
+	return query, spec, nil
+}
+
+// One pool per DSN, opened on first use. Pools are keyed by a hash so the
+// password doesn't sit in a map key
+func (i *Inserter) pool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	sum := sha256.Sum256([]byte(dsn))
+	key := hex.EncodeToString(sum[:])
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if pool, ok := i.pools[key]; ok {
+		return pool, nil
+	}
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		// The parse error can echo the DSN, keep it out of step logs
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid dsn in postgres action config")}
+	}
+	cfg.MaxConns = maxConnsPerDSN
+	cfg.MaxConnIdleTime = idleTimeout
+	cfg.ConnConfig.DialFunc = i.egress.DialContext()
+	// Hand the dialer the hostname so allowed hosts match before resolution
+	cfg.ConnConfig.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	i.pools[key] = pool
+	return pool, nil
+}
+
+// Closes every open pool
+func (i *Inserter) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for key, pool := range i.pools {
+		pool.Close()
+		delete(i.pools, key)
+	}
+}
+
+// Errors in the statement or the data (class 22 data exceptions, 23 constraint
+// violations, 42 missing tables or columns, 28 bad credentials) fail the
+// same way every time. Anything else, like a dropped connection, may pass on retry
+func classify(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "22", "23", "28", "42":
+			return &engine.PermanentError{Err: fmt.Errorf("postgres %s: %s", pgErr.Code, pgErr.Message)}
+		}
+		return fmt.Errorf("postgres %s: %s", pgErr.Code, pgErr.Message)
+	}
+	return err
+}
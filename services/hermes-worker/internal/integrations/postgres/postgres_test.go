@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestBuildInsert(t *testing.T) {
+	config := map[string]any{"table": "staging.pushes", "on_conflict": "ignore", "columns": map[string]any{
+		"sha":  "after",
+		"repo": "repository.full_name",
+		"size": map[string]any{"from": "size", "type": "integer", "default": 0},
+	}}
+	query, spec, err := buildInsert(config)
+	if err != nil {
+		t.Fatalf("Expected the insert to build, got %v", err)
+	}
+	want := `INSERT INTO "staging"."pushes" ("repo", "sha", "size") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	if query != want {
+		t.Errorf("Expected %s, got %s", want, query)
+	}
+	row, err := mapping.Apply(spec, []byte(`{"after": "abc", "repository": {"full_name": "octo/app"}}`))
+	if err != nil || string(row) != `{"repo":"octo/app","sha":"abc","size":0}` {
+		t.Errorf("Unexpected row %s (%v)", row, err)
+	}
+}
+
+func TestBuildInsertRejectsUnsafeNames(t *testing.T) {
+	for _, config := range []map[string]any{
+		{"table": `pushes"; DROP TABLE users; --`, "columns": map[string]any{"a": "a"}},
+		{"table": "a.b.c", "columns": map[string]any{"a": "a"}},
+		{"table": "pushes", "columns": map[string]any{"a b": "a"}},
+		{"table": "pushes", "columns": map[string]any{"a": "a"}, "on_conflict": "update"},
+	} {
+		if _, _, err := buildInsert(config); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	var p *engine.PermanentError
+	if err := classify(&pgconn.PgError{Code: "23505", Message: "duplicate key"}); !errors.As(err, &p) {
+		t.Errorf("Expected a unique violation to be permanent, got %v", err)
+	}
+	if err := classify(&pgconn.PgError{Code: "53300", Message: "too many connections"}); errors.As(err, &p) {
+		t.Errorf("Expected too many connections to be retryable, got %v", err)
+	}
+}
+
+func TestEgressPolicyBlocksPrivateDatabases(t *testing.T) {
+	i := New(&engine.Egress{})
+	defer i.Close()
+	config := map[string]any{"dsn": "postgres://u:p@127.0.0.1:5432/db?connect_timeout=2", "table": "t",
+		"columns": map[string]any{"a": "a"}}
+	_, err := i.ExecuteWithResponse(context.Background(), config, []byte(`{"a": 1}`))
+	if !errors.Is(err, engine.ErrEgressDenied) {
+		t.Errorf("Expected the egress policy to refuse loopback, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	ntfyServer = "https://ntfy.sh"
+	// ntfy turns longer messages into attachments
+	maxNtfyMessageBytes = 4000
+)
+
+// Topic names as ntfy accepts them
+var validTopic = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// ntfy's priority names, mapped to its 1-5 scale
+var ntfyPriorities = map[string]int{"min": 1, "low": 2, "default": 3, "high": 4, "urgent": 5, "max": 5}
+
+type Ntfy struct {
+	client *http.Client
+}
+
+// Servers are user supplied for self-hosted ntfy, so requests go through the
+// egress policy
+func NewNtfy(egress *engine.Egress) *Ntfy {
+	return &Ntfy{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: the server and topic
+func (n *Ntfy) Target(config map[string]any) string {
+	topic, _ := config["topic"].(string)
+	server, err := ntfyServerURL(config)
+	if err != nil || !validTopic.MatchString(topic) {
+		return ""
+	}
+	return server.Host + "/" + topic
+}
+
+func (n *Ntfy) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := n.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"server": "https://ntfy.example.com", "topic": "alerts", "token": "{{secret:NTFY_TOKEN}}",
+//	 "title": "{{alert.title}}", "message": "{{alert.summary}}", "priority": "high",
+//	 "tags": ["warning"], "click": "{{alert.url}}"}
+//
+// server defaults to ntfy.sh. title, message and click are mapping templates
+// filled from the payload; without message the payload itself is sent
+func (n *Ntfy) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	server, err := ntfyServerURL(config)
+	if err != nil {
+		return "", err
+	}
+	topic, _ := config["topic"].(string)
+	if !validTopic.MatchString(topic) {
+		return "", fmt.Errorf("missing or invalid topic in ntfy action config")
+	}
+	msg := map[string]any{"topic": topic}
+	message, err := render(config, "message", body)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = string(body)
+	}
+	msg["message"] = payload.TruncateString(message, maxNtfyMessageBytes)
+	for _, key := range []string{"title", "click"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			msg[key] = value
+		}
+	}
+	if raw, ok := config["priority"]; ok {
+		priority, err := ntfyPriority(raw)
+		if err != nil {
+			return "", err
+		}
+		msg["priority"] = priority
+	}
+	if raw, ok := config["tags"]; ok {
+		tags, ok := raw.([]any)
+		if !ok {
+			return "", fmt.Errorf("tags must be a list of strings")
+		}
+		msg["tags"] = tags
+	}
+
+	jsonBody, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("marshal ntfy message: %w", err)
+	}
+	// Publishing JSON to the server root keeps the topic out of the headers
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.String(), bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token, _ := config["token"].(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 {
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), classify("ntfy", resp.StatusCode, result.Error)
+	}
+	return fmt.Sprintf("%d: message %s", resp.StatusCode, result.ID), nil
+}
+
+func ntfyServerURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["server"].(string)
+	if raw == "" {
+		raw = ntfyServer
+	}
+	server, err := url.Parse(strings.TrimRight(raw, "/"))
+	if err != nil || (server.Scheme != "http" && server.Scheme != "https") || server.Host == "" {
+		return nil, fmt.Errorf("invalid server in ntfy action config")
+	}
+	return server, nil
+}
+
+// Accepts 1-5 or one of ntfy's priority names
+func ntfyPriority(raw any) (int, error) {
+	var priority int
+	switch v := raw.(type) {
+	case float64:
+		priority = int(v)
+	case string:
+		if p, ok := ntfyPriorities[strings.ToLower(v)]; ok {
+			priority = p
+		} else {
+			priority, _ = strconv.Atoi(v)
+		}
+	}
+	if priority < 1 || priority > 5 {
+		return 0, fmt.Errorf("priority must be 1-5 or min, low, default, high, urgent")
+	}
+	return priority, nil
+}
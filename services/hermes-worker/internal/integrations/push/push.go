@@ -0,0 +1,38 @@
+// Package push sends phone notifications through ntfy and Pushover, for
+// setups that want alerts without running a chat platform
+package push
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Renders the optional template under key
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	if tmpl == "" {
+		return "", nil
+	}
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
+
+// Throttling and server errors are worth retrying; any other 4xx (bad token,
+// unknown user, forbidden topic) fails the same way every time
+func classify(provider string, status int, detail string) error {
+	msg := fmt.Sprintf("%s returned %d", provider, status)
+	if detail != "" {
+		msg += ": " + detail
+	}
+	err := &engine.StatusError{StatusCode: status, Msg: msg}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return err
+	}
+	return &engine.PermanentError{Err: err}
+}
@@ -0,0 +1,93 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestNtfyPublishesJSON(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tk_1" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code": 40301, "error": "forbidden"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"id": "abc"}`))
+	}))
+	defer srv.Close()
+
+	n := NewNtfy(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"server": srv.URL, "topic": "alerts", "token": "tk_1",
+		"title": "{{alert}} is firing", "priority": "urgent", "click": "{{url}}", "tags": []any{"warning"}}
+	if _, err := n.ExecuteWithResponse(context.Background(), config, []byte(`{"alert": "disk", "url": "https://grafana"}`)); err != nil {
+		t.Fatalf("Expected the publish to succeed, got %v", err)
+	}
+	if got["topic"] != "alerts" || got["title"] != "disk is firing" || got["priority"] != float64(5) || got["click"] != "https://grafana" {
+		t.Errorf("Unexpected message %v", got)
+	}
+
+	config["token"] = "wrong"
+	_, err := n.ExecuteWithResponse(context.Background(), config, []byte(`{"alert": "disk", "url": "u"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a forbidden topic to fail permanently, got %v", err)
+	}
+}
+
+func TestNtfyRejectsBadTopicAndPriority(t *testing.T) {
+	n := NewNtfy(nil)
+	if _, err := n.ExecuteWithResponse(context.Background(), map[string]any{"topic": "a/b"}, nil); err == nil {
+		t.Error("Expected a topic with a slash to be rejected")
+	}
+	if _, err := n.ExecuteWithResponse(context.Background(), map[string]any{"topic": "a", "priority": "loud"}, nil); err == nil {
+		t.Error("Expected an unknown priority to be rejected")
+	}
+}
+
+func TestPushoverEmergencyPriority(t *testing.T) {
+	var form map[string]string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{}
+		for key := range r.Form {
+			form[key] = r.Form.Get(key)
+		}
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`{"status": 0, "errors": ["user identifier is invalid"]}`))
+			return
+		}
+		w.Write([]byte(`{"status": 1, "request": "r1", "receipt": "rc1"}`))
+	}))
+	defer srv.Close()
+
+	p := NewPushover(&engine.Egress{AllowPrivate: true})
+	p.url = srv.URL
+	config := map[string]any{"token": "app", "user": "u", "message": "{{alert}} down", "priority": float64(2)}
+	response, err := p.ExecuteWithResponse(context.Background(), config, []byte(`{"alert": "db"}`))
+	if err != nil {
+		t.Fatalf("Expected the send to succeed, got %v", err)
+	}
+	if form["message"] != "db down" || form["retry"] != "60" || form["expire"] != "3600" {
+		t.Errorf("Unexpected form %v", form)
+	}
+	if response != "200: request r1, receipt rc1" {
+		t.Errorf("Expected the receipt in the response, got %q", response)
+	}
+
+	status = http.StatusBadRequest
+	_, err = p.ExecuteWithResponse(context.Background(), config, []byte(`{"alert": "db"}`))
+	var perm *engine.PermanentError
+	if !errors.As(err, &perm) {
+		t.Errorf("Expected an invalid user to fail permanently, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	pushoverURL = "https://api.pushover.net/1/messages.json"
+	// Pushover caps messages at 1024 characters
+	maxPushoverMessageBytes = 1000
+	// Emergency priority repeats the alert until acknowledged
+	emergencyPriority = 2
+)
+
+type Pushover struct {
+	client *http.Client
+	url    string
+}
+
+func NewPushover(egress *engine.Egress) *Pushover {
+	return &Pushover{client: egress.Client(10 * time.Second), url: pushoverURL}
+}
+
+// Breaker key: the API as a whole
+func (p *Pushover) Target(config map[string]any) string {
+	return "api.pushover.net"
+}
+
+func (p *Pushover) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := p.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:PUSHOVER_APP_TOKEN}}", "user": "{{secret:PUSHOVER_USER}}",
+//	 "title": "{{alert.title}}", "message": "{{alert.summary}}", "priority": 1,
+//	 "url": "{{alert.url}}", "url_title": "Open", "sound": "siren", "device": "phone"}
+//
+// Priority runs from -2 to 2. Priority 2 repeats every retry seconds
+// (default 60) until acknowledged or expire seconds (default 3600) pass
+func (p *Pushover) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	user, _ := config["user"].(string)
+	if token == "" || user == "" {
+		return "", fmt.Errorf("missing token or user in pushover action config")
+	}
+	form := url.Values{"token": {token}, "user": {user}}
+	message, err := render(config, "message", body)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = string(body)
+	}
+	form.Set("message", payload.TruncateString(message, maxPushoverMessageBytes))
+	for _, key := range []string{"title", "url", "url_title"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	for _, key := range []string{"sound", "device"} {
+		if value, _ := config[key].(string); value != "" {
+			form.Set(key, value)
+		}
+	}
+	if raw, ok := config["priority"].(float64); ok {
+		priority := int(raw)
+		if priority < -2 || priority > emergencyPriority {
+			return "", fmt.Errorf("priority must be between -2 and 2")
+		}
+		form.Set("priority", strconv.Itoa(priority))
+		if priority == emergencyPriority {
+			form.Set("retry", strconv.Itoa(intOr(config["retry"], 60)))
+			form.Set("expire", strconv.Itoa(intOr(config["expire"], 3600)))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		Status  int      `json:"status"`
+		Request string   `json:"request"`
+		Receipt string   `json:"receipt"`
+		Errors  []string `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 || result.Status != 1 {
+		status := resp.StatusCode
+		if status < 300 {
+			status = http.StatusBadRequest
+		}
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), classify("pushover", status, strings.Join(result.Errors, "; "))
+	}
+	response := fmt.Sprintf("%d: request %s", resp.StatusCode, result.Request)
+	if result.Receipt != "" {
+		response += ", receipt " + result.Receipt
+	}
+	return response, nil
+}
+
+func intOr(raw any, fallback int) int {
+	if v, ok := raw.(float64); ok && v > 0 {
+		return int(v)
+	}
+	return fallback
+}
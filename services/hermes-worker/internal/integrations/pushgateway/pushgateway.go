@@ -0,0 +1,260 @@
+// Package pushgateway pushes metrics derived from events to a Prometheus
+// Pushgateway, letting webhook-only systems feed Prometheus alerting
+package pushgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+var (
+	validMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	validLabelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+var metricTypes = map[string]bool{"gauge": true, "counter": true, "untyped": true}
+
+type Client struct {
+	client *http.Client
+}
+
+// Pushgateways are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: the gateway and job
+func (c *Client) Target(config map[string]any) string {
+	base, err := baseURL(config)
+	job, _ := config["job"].(string)
+	if err != nil || job == "" {
+		return ""
+	}
+	return base.Host + base.Path + "/" + job
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"url": "https://pushgateway.example.com", "job": "github_webhooks",
+//	 "grouping": {"repository": "{{repository.full_name}}"},
+//	 "metrics": [{"name": "deploy_commits", "type": "gauge", "value": "{{commits.length}}",
+//	              "help": "Commits in the last deploy", "labels": {"branch": "{{ref}}"}}],
+//	 "username": "hermes", "password": "{{secret:PUSHGATEWAY_PASSWORD}}"}
+//
+// grouping labels pick the metric group next to job; pushing replaces the
+// group's metrics of the same name. value is a number or a mapping template
+// rendering one. type is gauge (the default), counter or untyped; the gateway
+// stores what it is sent, so counters should carry a running total. token
+// sends a bearer token instead of basic auth
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	base, err := baseURL(config)
+	if err != nil {
+		return "", err
+	}
+	job, _ := config["job"].(string)
+	if job == "" {
+		return "", fmt.Errorf("missing job in pushgateway action config")
+	}
+	path, err := groupPath(config, job, body)
+	if err != nil {
+		return "", err
+	}
+	exposition, count, err := render(config, body)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.String()+path, strings.NewReader(exposition))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	if token, _ := config["token"].(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username, _ := config["username"].(string); username != "" {
+		password, _ := config["password"].(string)
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: pushed %d metric(s)", resp.StatusCode, count), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("pushgateway returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data))),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Malformed or conflicting metrics and bad credentials fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+// The /metrics/job/<job>/<label>/<value>... path. Values that are empty or
+// hold a slash use the gateway's base64 form
+func groupPath(config map[string]any, job string, body []byte) (string, error) {
+	var path strings.Builder
+	path.WriteString("/metrics")
+	writeLabel(&path, "job", job)
+	raw, ok := config["grouping"]
+	if !ok {
+		return path.String(), nil
+	}
+	grouping, ok := raw.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("grouping must map label names to templates")
+	}
+	names := make([]string, 0, len(grouping))
+	for name := range grouping {
+		if !validLabelName.MatchString(name) || name == "job" {
+			return "", fmt.Errorf("invalid grouping label %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tmpl, _ := grouping[name].(string)
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("grouping.%s: %w", name, err)
+		}
+		writeLabel(&path, name, value)
+	}
+	return path.String(), nil
+}
+
+func writeLabel(path *strings.Builder, name, value string) {
+	if value == "" || strings.Contains(value, "/") {
+		path.WriteString("/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value)))
+		return
+	}
+	path.WriteString("/" + name + "/" + url.PathEscape(value))
+}
+
+// Renders the metrics in the Prometheus text format
+func render(config map[string]any, body []byte) (string, int, error) {
+	list, _ := config["metrics"].([]any)
+	if len(list) == 0 {
+		return "", 0, fmt.Errorf("missing metrics in pushgateway action config")
+	}
+	var out strings.Builder
+	seen := map[string]bool{}
+	for n, item := range list {
+		metric, _ := item.(map[string]any)
+		name, _ := metric["name"].(string)
+		if !validMetricName.MatchString(name) {
+			return "", 0, fmt.Errorf("metric %d: invalid name %q", n, name)
+		}
+		// The text format allows one family per name
+		if seen[name] {
+			return "", 0, fmt.Errorf("metric %d: %s is listed twice", n, name)
+		}
+		seen[name] = true
+		kind, _ := metric["type"].(string)
+		if kind == "" {
+			kind = "gauge"
+		}
+		if !metricTypes[kind] {
+			return "", 0, fmt.Errorf("metric %s: unknown type %q", name, kind)
+		}
+		value, err := metricValue(metric["value"], body)
+		if err != nil {
+			return "", 0, fmt.Errorf("metric %s: %w", name, err)
+		}
+		labels, err := renderLabels(metric["labels"], body)
+		if err != nil {
+			return "", 0, fmt.Errorf("metric %s: %w", name, err)
+		}
+		if help, _ := metric["help"].(string); help != "" {
+			help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+			fmt.Fprintf(&out, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&out, "# TYPE %s %s\n", name, kind)
+		fmt.Fprintf(&out, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return out.String(), len(list), nil
+}
+
+func metricValue(raw any, body []byte) (float64, error) {
+	switch v := raw.(type) {
+	case float64:
+		return v, nil
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return 0, fmt.Errorf("value: %w", err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rendered), 64)
+		if err != nil || math.IsNaN(value) {
+			// The payload didn't carry a number, retrying won't change that
+			return 0, &engine.PermanentError{Err: fmt.Errorf("value %q is not a number", rendered)}
+		}
+		return value, nil
+	}
+	return 0, fmt.Errorf("value must be a number or a template")
+}
+
+// {name="value",...} with values escaped as the text format wants, or nothing
+func renderLabels(raw any, body []byte) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+	labels, ok := raw.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("labels must map label names to templates")
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if !validLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return "", fmt.Errorf("invalid label %q", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for n, name := range names {
+		tmpl, _ := labels[name].(string)
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("labels.%s: %w", name, err)
+		}
+		parts[n] = name + `="` + escape.Replace(value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}", nil
+}
+
+func baseURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["url"].(string)
+	base, err := url.Parse(strings.TrimRight(raw, "/"))
+	if raw == "" || err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("missing or invalid url in pushgateway action config")
+	}
+	return base, nil
+}
@@ -0,0 +1,74 @@
+package pushgateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestPushesMetrics(t *testing.T) {
+	var path, got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "hermes" || pass != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path = r.URL.EscapedPath()
+		data, _ := io.ReadAll(r.Body)
+		got = string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"repo": "octo/app", "ref": "refs/heads/main", "count": 3}`)
+	config := map[string]any{"url": srv.URL, "job": "webhooks", "username": "hermes", "password": "pw",
+		"grouping": map[string]any{"repository": "{{repo}}", "env": "prod"},
+		"metrics": []any{
+			map[string]any{"name": "deploy_commits", "value": "{{count}}", "help": "Commits deployed",
+				"labels": map[string]any{"ref": "{{ref}}"}},
+			map[string]any{"name": "deploys_total", "type": "counter", "value": float64(1)},
+		}}
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil || response != "200: pushed 2 metric(s)" {
+		t.Fatalf("Expected the metrics to be pushed, got %q (%v)", response, err)
+	}
+	if want := "/metrics/job/webhooks/env/prod/repository@base64/b2N0by9hcHA"; path != want {
+		t.Errorf("Expected path %s, got %s", want, path)
+	}
+	want := "# HELP deploy_commits Commits deployed\n# TYPE deploy_commits gauge\n" +
+		"deploy_commits{ref=\"refs/heads/main\"} 3\n# TYPE deploys_total counter\ndeploys_total 1\n"
+	if got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+
+	config["password"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected bad credentials to fail permanently, got %v", err)
+	}
+}
+
+func TestRejectsBadMetrics(t *testing.T) {
+	c := New(&engine.Egress{})
+	metric := func(m map[string]any) map[string]any {
+		return map[string]any{"url": "https://pg.example.com", "job": "j", "metrics": []any{m}}
+	}
+	for _, config := range []map[string]any{
+		{"url": "https://pg.example.com", "job": "j"},
+		metric(map[string]any{"name": "bad-name", "value": float64(1)}),
+		metric(map[string]any{"name": "m", "type": "histogram", "value": float64(1)}),
+		metric(map[string]any{"name": "m", "value": "{{missing}}"}),
+		metric(map[string]any{"name": "m", "value": float64(1), "labels": map[string]any{"__name__": "x"}}),
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
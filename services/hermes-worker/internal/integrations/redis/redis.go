@@ -0,0 +1,183 @@
+// Package redis feeds events into Redis-based pipelines by publishing to a
+// channel, pushing onto a list or setting a key
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Each server gets a small pool, the worker pool bounds concurrency
+	maxConnsPerURL = 4
+	idleTimeout    = 5 * time.Minute
+	dialTimeout    = 5 * time.Second
+)
+
+// Replies that fail the same way every time: bad credentials, missing ACL
+// permissions or a key holding another type. LOADING, BUSY, READONLY and
+// plain ERR replies like "max number of clients reached" may clear up
+var permanentReplies = []string{"NOAUTH", "WRONGPASS", "NOPERM", "WRONGTYPE"}
+
+type Client struct {
+	egress *engine.Egress
+
+	mu      sync.Mutex
+	clients map[string]*redis.Client
+}
+
+// Servers are user supplied, so connections go through the egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{egress: egress, clients: map[string]*redis.Client{}}
+}
+
+// Breaker key: the server and database, never the URL with its password
+func (c *Client) Target(config map[string]any) string {
+	raw, _ := config["url"].(string)
+	opts, err := redis.ParseURL(raw)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", opts.Addr, opts.DB)
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"url": "{{secret:REDIS_URL}}", "command": "lpush",
+//	 "key": "events:{{repository.name}}", "value": "{{head_commit.id}}"}
+//
+// command is publish (key names the channel), lpush or set. key and value are
+// mapping templates filled from the payload; without value the payload itself
+// is sent. set takes an optional ttl like "1h"
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	raw, _ := config["url"].(string)
+	if raw == "" {
+		return "", fmt.Errorf("missing url in redis action config")
+	}
+	command, _ := config["command"].(string)
+	command = strings.ToLower(command)
+	if command != "publish" && command != "lpush" && command != "set" {
+		return "", fmt.Errorf("unknown command %q in redis action config, want publish, lpush or set", command)
+	}
+	var ttl time.Duration
+	if rawTTL, _ := config["ttl"].(string); rawTTL != "" {
+		if command != "set" {
+			return "", fmt.Errorf("ttl only applies to the set command")
+		}
+		d, err := time.ParseDuration(rawTTL)
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("invalid ttl %q in redis action config", rawTTL)
+		}
+		ttl = d
+	}
+	tmpl, _ := config["key"].(string)
+	if tmpl == "" {
+		return "", fmt.Errorf("missing key in redis action config")
+	}
+	key, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("key: %w", err)
+	}
+	if key = strings.TrimSpace(key); key == "" {
+		return "", &engine.PermanentError{Err: fmt.Errorf("key rendered empty")}
+	}
+	value := string(body)
+	if tmpl, _ := config["value"].(string); tmpl != "" {
+		if value, err = mapping.Render(tmpl, body, nil); err != nil {
+			return "", fmt.Errorf("value: %w", err)
+		}
+	}
+
+	client, err := c.client(raw)
+	if err != nil {
+		return "", err
+	}
+	switch command {
+	case "publish":
+		receivers, err := client.Publish(ctx, key, value).Result()
+		if err != nil {
+			return "", classify(err)
+		}
+		return fmt.Sprintf("published to %d subscriber(s)", receivers), nil
+	case "lpush":
+		length, err := client.LPush(ctx, key, value).Result()
+		if err != nil {
+			return "", classify(err)
+		}
+		return fmt.Sprintf("list length %d", length), nil
+	default:
+		if err := client.Set(ctx, key, value, ttl).Err(); err != nil {
+			return "", classify(err)
+		}
+		return "OK", nil
+	}
+}
+
+// One client per URL, opened on first use. Clients are keyed by a hash so the
+// password doesn't sit in a map key
+func (c *Client) client(raw string) (*redis.Client, error) {
+	sum := sha256.Sum256([]byte(raw))
+	key := hex.EncodeToString(sum[:])
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+	opts, err := redis.ParseURL(raw)
+	if err != nil {
+		// The parse error can echo the URL, keep it out of step logs
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid url in redis action config, want redis:// or rediss://")}
+	}
+	// Unix sockets would skip the egress check
+	if opts.Network != "tcp" {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("redis action only connects over tcp")}
+	}
+	opts.Dialer = c.egress.DialContext()
+	opts.DialTimeout = dialTimeout
+	opts.PoolSize = maxConnsPerURL
+	opts.ConnMaxIdleTime = idleTimeout
+	// Failed steps go through the worker's retries, not the client's
+	opts.MaxRetries = -1
+	client := redis.NewClient(opts)
+	c.clients[key] = client
+	return client, nil
+}
+
+// Closes every open client
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, client := range c.clients {
+		_ = client.Close()
+		delete(c.clients, key)
+	}
+}
+
+func classify(err error) error {
+	var replyErr redis.Error
+	if !errors.As(err, &replyErr) || errors.Is(err, redis.Nil) {
+		return err
+	}
+	prefix, _, _ := strings.Cut(replyErr.Error(), " ")
+	for _, permanent := range permanentReplies {
+		if prefix == permanent {
+			return &engine.PermanentError{Err: fmt.Errorf("redis: %w", err)}
+		}
+	}
+	return fmt.Errorf("redis: %w", err)
+}
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func newServer(t *testing.T) (*miniredis.Miniredis, *Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	c := New(&engine.Egress{AllowPrivate: true})
+	t.Cleanup(c.Close)
+	return server, c
+}
+
+func TestLPushRendersKeyAndValue(t *testing.T) {
+	server, c := newServer(t)
+	config := map[string]any{"url": "redis://" + server.Addr(), "command": "lpush",
+		"key": "events:{{repo}}", "value": "{{sha}}"}
+	for _, sha := range []string{"a1", "b2"} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"repo": "app", "sha": "`+sha+`"}`)); err != nil {
+			t.Fatalf("Expected the push to succeed, got %v", err)
+		}
+	}
+	list, err := server.List("events:app")
+	if err != nil || len(list) != 2 || list[0] != "b2" {
+		t.Errorf("Expected [b2 a1], got %v (%v)", list, err)
+	}
+}
+
+func TestSetSendsPayloadWithTTL(t *testing.T) {
+	server, c := newServer(t)
+	body := []byte(`{"id": "evt_1"}`)
+	config := map[string]any{"url": "redis://" + server.Addr(), "command": "set", "key": "last:{{id}}", "ttl": "1h"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the set to succeed, got %v", err)
+	}
+	if got, _ := server.Get("last:evt_1"); got != string(body) {
+		t.Errorf("Expected the payload, got %q", got)
+	}
+	if ttl := server.TTL("last:evt_1"); ttl != time.Hour {
+		t.Errorf("Expected a 1h ttl, got %v", ttl)
+	}
+}
+
+func TestWrongTypeIsPermanent(t *testing.T) {
+	server, c := newServer(t)
+	_ = server.Set("events", "not a list")
+	config := map[string]any{"url": "redis://" + server.Addr(), "command": "lpush", "key": "events"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected WRONGTYPE to be permanent, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	defer c.Close()
+	for _, config := range []map[string]any{
+		{"url": "redis://example.com", "command": "del", "key": "k"},
+		{"url": "redis://example.com", "command": "publish", "key": "k", "ttl": "1m"},
+		{"url": "redis://example.com", "command": "set", "key": "k", "ttl": "soon"},
+		{"url": "redis://example.com", "command": "set"},
+		{"url": "unix:///tmp/redis.sock", "command": "set", "key": "k"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestEgressPolicyBlocksPrivateServers(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := New(&engine.Egress{})
+	defer c.Close()
+	config := map[string]any{"url": "redis://" + server.Addr(), "command": "publish", "key": "events"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	if !errors.Is(err, engine.ErrEgressDenied) {
+		t.Errorf("Expected the egress policy to refuse loopback, got %v", err)
+	}
+}
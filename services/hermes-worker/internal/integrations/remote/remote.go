@@ -0,0 +1,147 @@
+// Package remote backs action types with out-of-process executors that speak
+// the hermes.executor.v1 gRPC protocol (see hermes-common/pkg/executorpb)
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/executorpb"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// Proxies Execute calls for one action type to a sidecar
+type Executor struct {
+	actionType     string
+	addr           string
+	timeout        time.Duration
+	healthInterval time.Duration
+	conn           *grpc.ClientConn
+	client         executorpb.ExecutorClient
+	health         healthpb.HealthClient
+	healthy        atomic.Bool
+	logger         *slog.Logger
+}
+
+// Connects lazily to addr. Calls are cut off after timeout and the sidecar's
+// health is polled every healthInterval once Watch runs
+func New(actionType, addr string, timeout, healthInterval time.Duration, logger *slog.Logger) (*Executor, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("grpc client for %s: %w", addr, err)
+	}
+	e := &Executor{
+		actionType:     actionType,
+		addr:           addr,
+		timeout:        timeout,
+		healthInterval: healthInterval,
+		conn:           conn,
+		client:         executorpb.NewExecutorClient(conn),
+		health:         healthpb.NewHealthClient(conn),
+		logger:         logger.With(slog.String("action_type", actionType), slog.String("addr", addr)),
+	}
+	// Assume healthy until the first check says otherwise
+	e.healthy.Store(true)
+	return e, nil
+}
+
+// Breaker key: failures are tracked per sidecar
+func (e *Executor) Target(config map[string]any) string {
+	return e.addr
+}
+
+func (e *Executor) Execute(ctx context.Context, config map[string]any, payload []byte) error {
+	_, err := e.ExecuteWithResponse(ctx, config, payload)
+	return err
+}
+
+func (e *Executor) ExecuteWithResponse(ctx context.Context, config map[string]any, payload []byte) (string, error) {
+	if !e.healthy.Load() {
+		return "", &engine.DeferError{
+			Delay: e.healthInterval,
+			Err:   fmt.Errorf("executor for %s at %s is not serving", e.actionType, e.addr),
+		}
+	}
+	cfg, err := structpb.NewStruct(config)
+	if err != nil {
+		return "", fmt.Errorf("encode config for %s: %w", e.actionType, err)
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	if traceparent, ok := tracing.Outgoing(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, tracing.Header, traceparent)
+	}
+	if key, ok := idempotency.FromContext(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, idempotency.MetadataKey, key.Key)
+	}
+	resp, err := e.client.Execute(ctx, &executorpb.ExecuteRequest{
+		ActionType: e.actionType,
+		Config:     cfg,
+		Payload:    payload,
+	})
+	if err != nil {
+		st := status.Convert(err)
+		switch st.Code() {
+		case codes.Unavailable, codes.ResourceExhausted:
+			return "", &engine.DeferError{
+				Delay: e.healthInterval,
+				Err:   fmt.Errorf("executor for %s unavailable: %s", e.actionType, st.Message()),
+			}
+		}
+		return "", fmt.Errorf("executor for %s failed (%s): %s", e.actionType, st.Code(), st.Message())
+	}
+	return resp.GetResponse(), nil
+}
+
+// Polls the sidecar's health service until ctx is done. A sidecar without a
+// health service is treated as healthy
+func (e *Executor) Watch(ctx context.Context) {
+	ticker := time.NewTicker(e.healthInterval)
+	defer ticker.Stop()
+	for {
+		e.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Executor) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	resp, err := e.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	healthy := err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	if status.Code(err) == codes.Unimplemented {
+		healthy = true
+	}
+	if previous := e.healthy.Swap(healthy); previous != healthy {
+		if healthy {
+			e.logger.Info("external executor is serving again")
+		} else {
+			e.logger.Warn("external executor is not serving", slog.Any("error", err))
+		}
+	}
+}
+
+func (e *Executor) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,109 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/executorpb"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type fakeSidecar struct {
+	executorpb.UnimplementedExecutorServer
+	err error
+}
+
+func (f *fakeSidecar) Execute(ctx context.Context, req *executorpb.ExecuteRequest) (*executorpb.ExecuteResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &executorpb.ExecuteResponse{
+		Response: req.GetActionType() + " " + req.GetConfig().GetFields()["channel"].GetStringValue() + " " + string(req.GetPayload()),
+	}, nil
+}
+
+func startSidecar(t *testing.T, sidecar *fakeSidecar) (string, *health.Server) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	srv := grpc.NewServer()
+	executorpb.RegisterExecutorServer(srv, sidecar)
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), hs
+}
+
+func newTestExecutor(t *testing.T, addr string) *Executor {
+	t.Helper()
+	exec, err := New("sms_send", addr, time.Second, time.Second, logger.New("hermes-worker-test", "test", "error"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { exec.Close() })
+	return exec
+}
+
+func TestExecuteProxiesToSidecar(t *testing.T) {
+	addr, _ := startSidecar(t, &fakeSidecar{})
+	exec := newTestExecutor(t, addr)
+	resp, err := exec.ExecuteWithResponse(context.Background(), map[string]any{"channel": "ops"}, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if resp != `sms_send ops {"a":1}` {
+		t.Errorf("Expected the sidecar's response, got %q", resp)
+	}
+}
+
+func TestExecuteDefersWhenSidecarUnavailable(t *testing.T) {
+	addr, _ := startSidecar(t, &fakeSidecar{err: status.Error(codes.Unavailable, "warming up")})
+	exec := newTestExecutor(t, addr)
+	err := exec.Execute(context.Background(), map[string]any{}, nil)
+	var deferErr *engine.DeferError
+	if !errors.As(err, &deferErr) {
+		t.Errorf("Expected a DeferError, got %v", err)
+	}
+}
+
+func TestExecuteFailsOnSidecarError(t *testing.T) {
+	addr, _ := startSidecar(t, &fakeSidecar{err: status.Error(codes.InvalidArgument, "missing phone")})
+	exec := newTestExecutor(t, addr)
+	err := exec.Execute(context.Background(), map[string]any{}, nil)
+	var deferErr *engine.DeferError
+	if err == nil || errors.As(err, &deferErr) || !strings.Contains(err.Error(), "missing phone") {
+		t.Errorf("Expected a plain failure with the sidecar's message, got %v", err)
+	}
+}
+
+func TestUnhealthySidecarIsSkipped(t *testing.T) {
+	addr, hs := startSidecar(t, &fakeSidecar{})
+	exec := newTestExecutor(t, addr)
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	exec.checkHealth(context.Background())
+
+	err := exec.Execute(context.Background(), map[string]any{}, nil)
+	var deferErr *engine.DeferError
+	if !errors.As(err, &deferErr) {
+		t.Errorf("Expected a DeferError while not serving, got %v", err)
+	}
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	exec.checkHealth(context.Background())
+	if err := exec.Execute(context.Background(), map[string]any{}, nil); err != nil {
+		t.Errorf("Expected success once serving again, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Package rocketchat posts to Rocket.Chat incoming webhook integrations
+package rocketchat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Rocket.Chat's default message size limit is 5000 characters
+const maxTextBytes = 4900
+
+type Sender struct {
+	client *http.Client
+}
+
+// Rocket.Chat servers are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Sender {
+	return &Sender{client: egress.Client(10 * time.Second)}
+}
+
+// Breaker key: failures are tracked per webhook
+func (s *Sender) Target(config map[string]any) string {
+	return engine.EndpointOf(config, "webhook_url")
+}
+
+func (s *Sender) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"webhook_url": "https://chat.example.com/hooks/xxx/yyy",
+//	 "text": "{{repository.name}} deployed", "alias": "{{pusher.name}}", "emoji": ":rocket:",
+//	 "attachments": [{"title": "{{head_commit.message}}", "title_link": "{{compare}}", "color": "#36a64f",
+//	                  "fields": [{"short": true, "title": "Branch", "value": "{{ref}}"}]}]}
+//
+// Strings in text, alias, emoji, avatar and attachments are mapping templates
+// filled from the payload. Without text or attachments the payload itself is
+// posted
+func (s *Sender) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	webhookURL, _ := config["webhook_url"].(string)
+	if webhookURL == "" {
+		return "", fmt.Errorf("missing webhook_url in rocketchat action config")
+	}
+	msg, err := buildMessage(config, body)
+	if err != nil {
+		return "", err
+	}
+	jsonBody, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("marshal rocketchat message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, data)
+	var result struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	// A failing integration script answers 200 with success false
+	if resp.StatusCode < 300 && (result.Success == nil || *result.Success) {
+		return response, nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("rocketchat returned %d: %s", resp.StatusCode, result.Error),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return response, statusErr
+	}
+	// Disabled or unknown integrations and rejected messages fail the same way every time
+	return response, &engine.PermanentError{Err: statusErr}
+}
+
+func buildMessage(config map[string]any, body []byte) (map[string]any, error) {
+	msg := map[string]any{}
+	for _, key := range []string{"text", "alias", "emoji", "avatar"} {
+		tmpl, _ := config[key].(string)
+		if tmpl == "" {
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if value != "" {
+			msg[key] = value
+		}
+	}
+	if text, ok := msg["text"].(string); ok {
+		msg["text"] = payload.TruncateString(text, maxTextBytes)
+	}
+	if raw, ok := config["attachments"]; ok {
+		if _, isList := raw.([]any); !isList {
+			return nil, fmt.Errorf("attachments must be a list of objects")
+		}
+		rendered, err := renderStrings(raw, body)
+		if err != nil {
+			return nil, fmt.Errorf("attachments: %w", err)
+		}
+		msg["attachments"] = rendered
+	}
+	if msg["text"] == nil && msg["attachments"] == nil {
+		preview, _ := payload.Truncate(body, maxTextBytes-64)
+		msg["text"] = fmt.Sprintf("Payload:\n```\n%s\n```", string(preview))
+	}
+	return msg, nil
+}
+
+// Fills the templates in every string, leaving other values alone
+func renderStrings(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package rocketchat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestPostsTemplatedMessage(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	body := []byte(`{"repo": "app", "user": "octocat", "ref": "main"}`)
+	config := map[string]any{"webhook_url": srv.URL + "/hooks/a/b", "text": "{{repo}} deployed",
+		"alias": "{{user}}", "emoji": ":rocket:",
+		"attachments": []any{map[string]any{"title": "Deploy", "fields": []any{
+			map[string]any{"short": true, "title": "Branch", "value": "{{ref}}"}}}}}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the message to be posted, got %v", err)
+	}
+	attachments, _ := got["attachments"].([]any)
+	fields, _ := attachments[0].(map[string]any)["fields"].([]any)
+	field, _ := fields[0].(map[string]any)
+	if got["text"] != "app deployed" || got["alias"] != "octocat" || got["emoji"] != ":rocket:" ||
+		field["value"] != "main" || field["short"] != true {
+		t.Errorf("Unexpected message %v", got)
+	}
+}
+
+func TestScriptFailureIsPermanent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": false, "error": "script error"}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	_, err := s.ExecuteWithResponse(context.Background(), map[string]any{"webhook_url": srv.URL}, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected success false to fail permanently, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package salesforce
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	// Salesforce doesn't say when a session ends; the shortest org timeout is
+	// 15 minutes, and a rejected session is renewed anyway
+	sessionTTL = 10 * time.Minute
+	// How long a JWT assertion is valid, Salesforce allows at most 3 minutes
+	assertionTTL = 3 * time.Minute
+)
+
+// How the action logs in to the org
+type grant struct {
+	tokenURL string
+	form     url.Values
+	// Set for the JWT bearer flow, signed fresh for every login
+	jwt *jwtClaims
+	key *rsa.PrivateKey
+	// Identifies the org and credentials in the session cache
+	cacheKey string
+}
+
+type jwtClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	Expires  int64  `json:"exp"`
+}
+
+type session struct {
+	accessToken string
+	instanceURL string
+	expires     time.Time
+}
+
+func parseGrant(config map[string]any, login *url.URL) (*grant, error) {
+	clientID, _ := config["client_id"].(string)
+	if clientID == "" {
+		return nil, fmt.Errorf("missing client_id in salesforce action config")
+	}
+	g := &grant{tokenURL: login.String() + "/services/oauth2/token"}
+	var secret string
+	switch auth, _ := config["auth"].(string); auth {
+	case "", "client_credentials":
+		secret, _ = config["client_secret"].(string)
+		if secret == "" {
+			return nil, fmt.Errorf("missing client_secret in salesforce action config")
+		}
+		g.form = url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {secret}}
+	case "jwt":
+		username, _ := config["username"].(string)
+		secret, _ = config["private_key"].(string)
+		if username == "" || secret == "" {
+			return nil, fmt.Errorf("salesforce jwt auth needs username and private_key in the action config")
+		}
+		key, err := parsePrivateKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		g.key = key
+		g.jwt = &jwtClaims{Issuer: clientID, Subject: username, Audience: login.String()}
+		g.form = url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}}
+		secret += "\n" + username
+	default:
+		return nil, fmt.Errorf("unknown auth %q in salesforce action config, want client_credentials or jwt", auth)
+	}
+	// Hashed so the secret doesn't sit in a map key
+	sum := sha256.Sum256([]byte(g.tokenURL + "\n" + clientID + "\n" + secret))
+	g.cacheKey = hex.EncodeToString(sum[:])
+	return g, nil
+}
+
+// Accepts PKCS#1 and PKCS#8 PEM keys, as openssl and the Salesforce docs produce
+func parsePrivateKey(raw string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		return nil, fmt.Errorf("private_key is not a PEM key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private_key must be an RSA key")
+	}
+	return key, nil
+}
+
+// The cached session for g, logging in when there is none or it has aged out
+func (c *Client) session(ctx context.Context, g *grant) (*session, error) {
+	c.mu.Lock()
+	sess, ok := c.sessions[g.cacheKey]
+	c.mu.Unlock()
+	if ok && c.now().Before(sess.expires) {
+		return sess, nil
+	}
+	sess, err := c.login(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.sessions[g.cacheKey] = sess
+	c.mu.Unlock()
+	return sess, nil
+}
+
+func (c *Client) forget(g *grant) {
+	c.mu.Lock()
+	delete(c.sessions, g.cacheKey)
+	c.mu.Unlock()
+}
+
+func (c *Client) login(ctx context.Context, g *grant) (*session, error) {
+	form := url.Values{}
+	for key, values := range g.form {
+		form[key] = values
+	}
+	if g.jwt != nil {
+		claims := *g.jwt
+		claims.Expires = c.now().Add(assertionTTL).Unix()
+		assertion, err := signJWT(claims, g.key)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("assertion", assertion)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		AccessToken string `json:"access_token"`
+		InstanceURL string `json:"instance_url"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode >= 300 || result.AccessToken == "" {
+		statusErr := &engine.StatusError{
+			StatusCode: resp.StatusCode,
+			Msg:        fmt.Sprintf("salesforce login returned %d: %s %s", resp.StatusCode, result.Error, result.Description),
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, statusErr
+		}
+		// Unknown clients, bad secrets and unapproved users fail the same way every time
+		return nil, &engine.PermanentError{Err: statusErr}
+	}
+	instance, err := url.Parse(result.InstanceURL)
+	if err != nil || instance.Scheme != "https" || instance.Host == "" {
+		return nil, fmt.Errorf("salesforce login returned an invalid instance_url %q", result.InstanceURL)
+	}
+	return &session{
+		accessToken: result.AccessToken,
+		instanceURL: strings.TrimRight(result.InstanceURL, "/"),
+		expires:     c.now().Add(sessionTTL),
+	}, nil
+}
+
+// Signs claims as an RS256 JWT
+func signJWT(claims jwtClaims, key *rsa.PrivateKey) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt claims: %w", err)
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(encoded)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
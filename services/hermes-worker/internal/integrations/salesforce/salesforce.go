@@ -0,0 +1,282 @@
+// Package salesforce creates, updates and upserts Salesforce records, custom
+// objects included
+package salesforce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	defaultAPIVersion = "v60.0"
+	opCreate          = "create"
+	opUpdate          = "update"
+	opUpsert          = "upsert"
+)
+
+var (
+	// Standard and custom objects and fields, e.g. Lead or Deploy__c
+	validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,79}$`)
+	// 15 or 18 character record IDs
+	validRecordID   = regexp.MustCompile(`^[A-Za-z0-9]{15}([A-Za-z0-9]{3})?$`)
+	validAPIVersion = regexp.MustCompile(`^v\d+\.\d$`)
+)
+
+// Error codes that clear up on their own: record locks, org limits and
+// maintenance
+var transientCodes = map[string]bool{
+	"UNABLE_TO_LOCK_ROW":     true,
+	"REQUEST_LIMIT_EXCEEDED": true,
+	"SERVER_UNAVAILABLE":     true,
+}
+
+type Client struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// My Domain and instance URLs are user supplied, so requests go through the
+// egress policy
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(15 * time.Second), now: time.Now, sessions: map[string]*session{}}
+}
+
+// Breaker key: the org's login host
+func (c *Client) Target(config map[string]any) string {
+	login, err := loginURL(config)
+	if err != nil {
+		return ""
+	}
+	return login.Host
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config with the OAuth client credentials flow:
+//
+//	{"login_url": "https://acme.my.salesforce.com", "client_id": "3MVG9...",
+//	 "client_secret": "{{secret:SF_CLIENT_SECRET}}", "sobject": "Deploy__c", "operation": "create",
+//	 "fields": {"Name": "{{repository.name}} {{after}}", "Commits__c": "{{commits.length}}"}}
+//
+// and with the JWT bearer flow, signing as username with the connected app's key:
+//
+//	{"login_url": "https://login.salesforce.com", "auth": "jwt", "client_id": "3MVG9...",
+//	 "username": "integration@acme.com", "private_key": "{{secret:SF_PRIVATE_KEY}}",
+//	 "sobject": "Contact", "operation": "upsert", "external_id_field": "Email",
+//	 "external_id": "{{customer.email}}", "fields": {"LastName": "{{customer.last_name}}"}}
+//
+// operation is create, update (record_id names the record) or upsert (on
+// external_id_field, matching external_id). fields maps field names to
+// mapping templates filled from the payload; other values are sent as they are
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	login, err := loginURL(config)
+	if err != nil {
+		return "", err
+	}
+	grant, err := parseGrant(config, login)
+	if err != nil {
+		return "", err
+	}
+	req, err := buildRequest(config, body)
+	if err != nil {
+		return "", err
+	}
+	sess, err := c.session(ctx, grant)
+	if err != nil {
+		return "", err
+	}
+	response, err := c.send(ctx, sess, req)
+	var expired *sessionExpiredError
+	if errors.As(err, &expired) {
+		// Sessions end early when an admin revokes them or the org's timeout is short
+		c.forget(grant)
+		if sess, err = c.session(ctx, grant); err != nil {
+			return "", err
+		}
+		response, err = c.send(ctx, sess, req)
+	}
+	return response, err
+}
+
+// A record write, relative to the org's data API
+type recordRequest struct {
+	method  string
+	path    string
+	version string
+	fields  map[string]any
+}
+
+func buildRequest(config map[string]any, body []byte) (*recordRequest, error) {
+	sobject, _ := config["sobject"].(string)
+	if !validName.MatchString(sobject) {
+		return nil, fmt.Errorf("missing or invalid sobject in salesforce action config")
+	}
+	req := &recordRequest{version: defaultAPIVersion}
+	if version, _ := config["api_version"].(string); version != "" {
+		if !validAPIVersion.MatchString(version) {
+			return nil, fmt.Errorf("invalid api_version %q in salesforce action config, want e.g. v60.0", version)
+		}
+		req.version = version
+	}
+	fields, err := renderFields(config, body)
+	if err != nil {
+		return nil, err
+	}
+	req.fields = fields
+	base := "/sobjects/" + sobject + "/"
+	switch operation, _ := config["operation"].(string); operation {
+	case opCreate:
+		req.method, req.path = http.MethodPost, base
+	case opUpdate:
+		id, err := render(config, "record_id", body)
+		if err != nil {
+			return nil, err
+		}
+		if !validRecordID.MatchString(id) {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("invalid record_id %q", id)}
+		}
+		req.method, req.path = http.MethodPatch, base+id
+	case opUpsert:
+		field, _ := config["external_id_field"].(string)
+		if !validName.MatchString(field) {
+			return nil, fmt.Errorf("missing or invalid external_id_field in salesforce action config")
+		}
+		value, err := render(config, "external_id", body)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("external_id rendered empty")}
+		}
+		req.method, req.path = http.MethodPatch, base+field+"/"+url.PathEscape(value)
+	default:
+		return nil, fmt.Errorf("unknown operation %q in salesforce action config", operation)
+	}
+	return req, nil
+}
+
+// Returned when Salesforce no longer accepts the cached session
+type sessionExpiredError struct{ err error }
+
+func (e *sessionExpiredError) Error() string { return e.err.Error() }
+
+func (c *Client) send(ctx context.Context, sess *session, rec *recordRequest) (string, error) {
+	jsonBody, err := json.Marshal(rec.fields)
+	if err != nil {
+		return "", fmt.Errorf("marshal salesforce record: %w", err)
+	}
+	endpoint := sess.instanceURL + "/services/data/" + rec.version + rec.path
+	req, err := http.NewRequestWithContext(ctx, rec.method, endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sess.accessToken)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	if resp.StatusCode < 300 {
+		var result struct {
+			ID      string `json:"id"`
+			Created *bool  `json:"created"`
+		}
+		_ = json.Unmarshal(data, &result)
+		switch {
+		case resp.StatusCode == http.StatusNoContent:
+			return fmt.Sprintf("%d: updated", resp.StatusCode), nil
+		case result.Created != nil && !*result.Created:
+			return fmt.Sprintf("%d: updated %s", resp.StatusCode, result.ID), nil
+		}
+		return fmt.Sprintf("%d: created %s", resp.StatusCode, result.ID), nil
+	}
+	var errs []struct {
+		Message   string   `json:"message"`
+		ErrorCode string   `json:"errorCode"`
+		Fields    []string `json:"fields"`
+	}
+	_ = json.Unmarshal(data, &errs)
+	var code, detail string
+	if len(errs) > 0 {
+		code, detail = errs[0].ErrorCode, errs[0].Message
+		if len(errs[0].Fields) > 0 {
+			detail += " (" + strings.Join(errs[0].Fields, ", ") + ")"
+		}
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("salesforce returned %d: %s %s", resp.StatusCode, code, detail),
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && code == "INVALID_SESSION_ID":
+		return "", &sessionExpiredError{err: statusErr}
+	case resp.StatusCode >= 500 || transientCodes[code]:
+		return "", statusErr
+	}
+	// Invalid fields, duplicate values, validation rules and missing records
+	// fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func renderFields(config map[string]any, body []byte) (map[string]any, error) {
+	specs, ok := config["fields"].(map[string]any)
+	if !ok || len(specs) == 0 {
+		return nil, fmt.Errorf("missing fields in salesforce action config")
+	}
+	fields := make(map[string]any, len(specs))
+	for name, raw := range specs {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("invalid field name %q", name)
+		}
+		tmpl, ok := raw.(string)
+		if !ok {
+			fields[name] = raw
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("fields.%s: %w", name, err)
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func loginURL(config map[string]any) (*url.URL, error) {
+	raw, _ := config["login_url"].(string)
+	login, err := url.Parse(strings.TrimRight(raw, "/"))
+	if raw == "" || err != nil || login.Scheme != "https" || login.Host == "" {
+		return nil, fmt.Errorf("missing or invalid login_url in salesforce action config, want an https URL")
+	}
+	return login, nil
+}
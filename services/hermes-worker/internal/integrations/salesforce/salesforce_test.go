@@ -0,0 +1,167 @@
+package salesforce
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// Fake org: a token endpoint and the sobjects API. Sessions issued before
+// revoke are rejected afterwards
+type fakeOrg struct {
+	srv     *httptest.Server
+	logins  int
+	token   string
+	form    map[string]string
+	path    string
+	method  string
+	record  map[string]any
+	revoked bool
+}
+
+func newFakeOrg(t *testing.T) *fakeOrg {
+	org := &fakeOrg{}
+	org.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			r.ParseForm()
+			org.form = map[string]string{}
+			for key := range r.PostForm {
+				org.form[key] = r.PostForm.Get(key)
+			}
+			if org.form["client_secret"] == "wrong" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_client", "error_description": "invalid client credentials"}`))
+				return
+			}
+			org.logins++
+			org.token = "token-" + string(rune('0'+org.logins))
+			json.NewEncoder(w).Encode(map[string]string{"access_token": org.token, "instance_url": org.srv.URL})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+org.token || org.revoked {
+			org.revoked = false
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`[{"message": "Session expired or invalid", "errorCode": "INVALID_SESSION_ID"}]`))
+			return
+		}
+		org.path, org.method = r.URL.EscapedPath(), r.Method
+		org.record = nil
+		json.NewDecoder(r.Body).Decode(&org.record)
+		if org.record["Name"] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`[{"message": "Required fields are missing: [Name]", "errorCode": "REQUIRED_FIELD_MISSING", "fields": ["Name"]}]`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "a01000000000001AAA", "success": true, "errors": []}`))
+	}))
+	t.Cleanup(org.srv.Close)
+	return org
+}
+
+func TestCreatesRecordAndRenewsSessions(t *testing.T) {
+	org := newFakeOrg(t)
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.client = org.srv.Client()
+	body := []byte(`{"repo": "app", "commits": 3}`)
+	config := map[string]any{"login_url": org.srv.URL, "client_id": "cid", "client_secret": "secret",
+		"sobject": "Deploy__c", "operation": "create",
+		"fields": map[string]any{"Name": "{{repo}}", "Commits__c": "{{commits}}", "Manual__c": false}}
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil || response != "201: created a01000000000001AAA" {
+		t.Fatalf("Expected the record to be created, got %q (%v)", response, err)
+	}
+	if org.method != http.MethodPost || org.path != "/services/data/v60.0/sobjects/Deploy__c/" ||
+		org.record["Name"] != "app" || org.record["Manual__c"] != false || org.form["grant_type"] != "client_credentials" {
+		t.Errorf("Unexpected request %s %s %v %v", org.method, org.path, org.record, org.form)
+	}
+
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil || org.logins != 1 {
+		t.Errorf("Expected the session to be reused, got %d logins (%v)", org.logins, err)
+	}
+	org.revoked = true
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil || org.logins != 2 {
+		t.Errorf("Expected a revoked session to be renewed, got %d logins (%v)", org.logins, err)
+	}
+
+	_, err = c.ExecuteWithResponse(context.Background(), config, []byte(`{"repo": "", "commits": 0}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "REQUIRED_FIELD_MISSING") {
+		t.Errorf("Expected a missing field to fail permanently, got %v", err)
+	}
+}
+
+func TestJWTBearerUpsert(t *testing.T) {
+	org := newFakeOrg(t)
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.client = org.srv.Client()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	config := map[string]any{"login_url": org.srv.URL, "auth": "jwt", "client_id": "cid",
+		"username": "bot@acme.com", "private_key": string(pemKey), "sobject": "Contact", "operation": "upsert",
+		"external_id_field": "Email", "external_id": "{{email}}", "fields": map[string]any{"Name": "{{name}}"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"email": "a b@x.com", "name": "Ada"}`)); err != nil {
+		t.Fatalf("Expected the upsert to succeed, got %v", err)
+	}
+	if org.method != http.MethodPatch || org.path != "/services/data/v60.0/sobjects/Contact/Email/a%20b@x.com" {
+		t.Errorf("Unexpected request %s %s", org.method, org.path)
+	}
+
+	parts := strings.Split(org.form["assertion"], ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a JWT assertion, got %q", org.form["assertion"])
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("Expected a valid RS256 signature, got %v", err)
+	}
+	var claims jwtClaims
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(raw, &claims)
+	if claims.Issuer != "cid" || claims.Subject != "bot@acme.com" || claims.Audience != org.srv.URL || claims.Expires == 0 {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func TestBadLoginIsPermanent(t *testing.T) {
+	org := newFakeOrg(t)
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.client = org.srv.Client()
+	config := map[string]any{"login_url": org.srv.URL, "client_id": "cid", "client_secret": "wrong",
+		"sobject": "Lead", "operation": "create", "fields": map[string]any{"Name": "x"}}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("Expected bad credentials to fail permanently, got %v", err)
+	}
+}
+
+func TestBuildRequestRejectsBadConfig(t *testing.T) {
+	fields := map[string]any{"Name": "x"}
+	for _, config := range []map[string]any{
+		{"sobject": "Lead; DROP", "operation": "create", "fields": fields},
+		{"sobject": "Lead", "operation": "delete", "fields": fields},
+		{"sobject": "Lead", "operation": "update", "record_id": "../../limits", "fields": fields},
+		{"sobject": "Lead", "operation": "upsert", "external_id_field": "Email", "fields": fields},
+		{"sobject": "Lead", "operation": "create", "api_version": "latest", "fields": fields},
+		{"sobject": "Lead", "operation": "create"},
+	} {
+		if _, err := buildRequest(config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package servicenow creates and updates incidents in a ServiceNow instance
+// through the Table API
+package servicenow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	// Ask for the two fields the step log shows, and take reference fields
+	// such as assignment_group by display name as well as sys_id
+	queryParams = "sysparm_input_display_value=true&sysparm_exclude_reference_link=true&sysparm_fields=sys_id,number"
+)
+
+var (
+	validInstance = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*\.service-now\.com$`)
+	validSysID    = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	validNumber   = regexp.MustCompile(`^[A-Z]+[0-9]+$`)
+	// Field names as ServiceNow stores them, e.g. u_custom_field
+	validField = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+type Client struct {
+	client *http.Client
+	// Instance base URL, overridden in tests
+	baseURL func(instance string) string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{
+		client:  egress.Client(15 * time.Second),
+		baseURL: func(instance string) string { return "https://" + instance },
+	}
+}
+
+// Breaker key: the instance
+func (c *Client) Target(config map[string]any) string {
+	instance, err := instanceHost(config)
+	if err != nil {
+		return ""
+	}
+	return instance
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"instance": "acme", "user": "hermes", "password": "{{secret:SNOW_PASSWORD}}",
+//	 "operation": "create", "assignment_group": "Network Operations",
+//	 "fields": {"short_description": "{{alert.title}}", "description": "{{alert.summary}}",
+//	  "urgency": "1", "impact": "2", "correlation_id": "{{alert.fingerprint}}"}}
+//	{"instance": "acme", "token": "{{secret:SNOW_TOKEN}}", "operation": "update",
+//	 "number": "{{incident.number}}", "fields": {"state": "6", "close_notes": "Resolved by {{user}}"}}
+//
+// Authenticates with user and password, or an OAuth token. update finds the
+// incident by sys_id or number. Field values are mapping templates filled
+// from the payload; reference fields like assignment_group and caller_id take
+// a sys_id or a display name
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	instance, err := instanceHost(config)
+	if err != nil {
+		return "", err
+	}
+	auth, err := authHeader(config)
+	if err != nil {
+		return "", err
+	}
+	fields, err := renderFields(config, body)
+	if err != nil {
+		return "", err
+	}
+	api := &tableAPI{client: c.client, base: c.baseURL(instance) + "/api/now/table/incident", auth: auth}
+
+	switch operation, _ := config["operation"].(string); operation {
+	case opCreate:
+		if fields["short_description"] == nil {
+			return "", fmt.Errorf("servicenow create needs fields.short_description")
+		}
+		result, err := api.do(ctx, http.MethodPost, "", fields)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("created %s %s", result.Number, result.SysID), nil
+	case opUpdate:
+		if len(fields) == 0 {
+			return "", fmt.Errorf("servicenow update needs fields")
+		}
+		sysID, err := api.resolve(ctx, config, body)
+		if err != nil {
+			return "", err
+		}
+		result, err := api.do(ctx, http.MethodPatch, "/"+sysID, fields)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("updated %s %s", result.Number, result.SysID), nil
+	default:
+		return "", fmt.Errorf("unknown operation %q in servicenow action config", operation)
+	}
+}
+
+// Renders fields, with assignment_group as a shorthand for the field of that name
+func renderFields(config map[string]any, body []byte) (map[string]any, error) {
+	fields := map[string]any{}
+	if raw, ok := config["fields"]; ok {
+		templates, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("fields must map field names to templates")
+		}
+		for name, item := range templates {
+			tmpl, ok := item.(string)
+			if !validField.MatchString(name) || !ok {
+				return nil, fmt.Errorf("invalid field %q, want a field name and a string template", name)
+			}
+			value, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("fields.%s: %w", name, err)
+			}
+			fields[name] = value
+		}
+	}
+	if tmpl, _ := config["assignment_group"].(string); tmpl != "" {
+		group, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("assignment_group: %w", err)
+		}
+		fields["assignment_group"] = strings.TrimSpace(group)
+	}
+	return fields, nil
+}
+
+// Takes the instance's service-now.com host or just its name
+func instanceHost(config map[string]any) (string, error) {
+	instance, _ := config["instance"].(string)
+	instance = strings.ToLower(strings.TrimSpace(instance))
+	if instance != "" && !strings.Contains(instance, ".") {
+		instance += ".service-now.com"
+	}
+	if !validInstance.MatchString(instance) {
+		return "", fmt.Errorf("missing or invalid instance in servicenow action config, want acme or acme.service-now.com")
+	}
+	return instance, nil
+}
+
+func authHeader(config map[string]any) (string, error) {
+	if token, _ := config["token"].(string); token != "" {
+		return "Bearer " + token, nil
+	}
+	user, _ := config["user"].(string)
+	password, _ := config["password"].(string)
+	if user == "" || password == "" {
+		return "", fmt.Errorf("servicenow action config needs user and password, or token")
+	}
+	req := http.Request{Header: http.Header{}}
+	req.SetBasicAuth(user, password)
+	return req.Header.Get("Authorization"), nil
+}
+
+type record struct {
+	SysID  string `json:"sys_id"`
+	Number string `json:"number"`
+}
+
+// The incident table of one instance
+type tableAPI struct {
+	client *http.Client
+	base   string
+	auth   string
+}
+
+// Finds the incident's sys_id from sys_id or number in the config
+func (a *tableAPI) resolve(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	for _, key := range []string{"sys_id", "number"} {
+		tmpl, _ := config[key].(string)
+		if tmpl == "" {
+			continue
+		}
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		value = strings.TrimSpace(value)
+		if key == "sys_id" {
+			if !validSysID.MatchString(value) {
+				return "", &engine.PermanentError{Err: fmt.Errorf("invalid sys_id %q", value)}
+			}
+			return value, nil
+		}
+		if !validNumber.MatchString(value) {
+			return "", &engine.PermanentError{Err: fmt.Errorf("invalid incident number %q", value)}
+		}
+		var results []record
+		query := "?sysparm_limit=1&sysparm_fields=sys_id,number&sysparm_query=" + url.QueryEscape("number="+value)
+		if err := a.request(ctx, http.MethodGet, query, nil, &results); err != nil {
+			return "", err
+		}
+		if len(results) == 0 {
+			return "", &engine.PermanentError{Err: fmt.Errorf("incident %s not found", value)}
+		}
+		return results[0].SysID, nil
+	}
+	return "", fmt.Errorf("servicenow update needs sys_id or number")
+}
+
+func (a *tableAPI) do(ctx context.Context, method, path string, fields map[string]any) (record, error) {
+	var result record
+	err := a.request(ctx, method, path+"?"+queryParams, fields, &result)
+	return result, err
+}
+
+// Sends one Table API request and decodes the result envelope into out
+func (a *tableAPI) request(ctx context.Context, method, pathAndQuery string, fields map[string]any, out any) error {
+	var reqBody io.Reader
+	if fields != nil {
+		jsonBody, err := json.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("marshal servicenow request: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.base+pathAndQuery, reqBody)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", a.auth)
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return json.Unmarshal(result.Result, out)
+	}
+	detail := result.Error.Message
+	if result.Error.Detail != "" {
+		detail += ": " + result.Error.Detail
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("servicenow returned %d: %s", resp.StatusCode, detail),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return statusErr
+	}
+	// Bad credentials, ACL denials and invalid fields fail the same way every time
+	return &engine.PermanentError{Err: statusErr}
+}
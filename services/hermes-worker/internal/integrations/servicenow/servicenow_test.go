@@ -0,0 +1,105 @@
+package servicenow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const sysID = "9d385017c611228701d22104cc95c371"
+
+func newInstance(t *testing.T) (*Client, *[]string, *map[string]any) {
+	var requests []string
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); (user != "hermes" || pass != "pw") && r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"message": "User Not Authenticated", "detail": "Required to provide Auth information"}, "status": "failure"}`))
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.Query().Get("sysparm_query"))
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("sysparm_query") == "number=INC0010001":
+			w.Write([]byte(`{"result": [{"sys_id": "` + sysID + `", "number": "INC0010001"}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"result": []}`))
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"result": {"sys_id": "` + sysID + `", "number": "INC0010002"}}`))
+		default:
+			w.Write([]byte(`{"result": {"sys_id": "` + sysID + `", "number": "INC0010001"}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.baseURL = func(string) string { return srv.URL }
+	return c, &requests, &got
+}
+
+func TestCreatesIncident(t *testing.T) {
+	c, requests, got := newInstance(t)
+	config := map[string]any{"instance": "acme", "user": "hermes", "password": "pw", "operation": "create",
+		"assignment_group": "{{team}}", "fields": map[string]any{"short_description": "{{title}}", "urgency": "1"}}
+	response, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"title": "Disk full", "team": "Network"}`))
+	if err != nil {
+		t.Fatalf("Expected the incident to be created, got %v", err)
+	}
+	if (*requests)[0] != "POST /api/now/table/incident?" || (*got)["short_description"] != "Disk full" ||
+		(*got)["assignment_group"] != "Network" || (*got)["urgency"] != "1" {
+		t.Errorf("Unexpected request %v %v", *requests, *got)
+	}
+	if response != "created INC0010002 "+sysID {
+		t.Errorf("Unexpected response %q", response)
+	}
+
+	config["password"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, []byte(`{"title": "Disk full", "team": "Network"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "User Not Authenticated") {
+		t.Errorf("Expected bad credentials to fail permanently, got %v", err)
+	}
+}
+
+func TestUpdatesIncidentByNumber(t *testing.T) {
+	c, requests, got := newInstance(t)
+	config := map[string]any{"instance": "acme.service-now.com", "token": "tok", "operation": "update",
+		"number": "{{incident}}", "fields": map[string]any{"state": "6", "close_notes": "Fixed by {{user}}"}}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"incident": "INC0010001", "user": "ada"}`)); err != nil {
+		t.Fatalf("Expected the incident to be updated, got %v", err)
+	}
+	want := []string{"GET /api/now/table/incident?number=INC0010001", "PATCH /api/now/table/incident/" + sysID + "?"}
+	if strings.Join(*requests, ",") != strings.Join(want, ",") || (*got)["close_notes"] != "Fixed by ada" {
+		t.Errorf("Unexpected requests %v %v", *requests, *got)
+	}
+
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"incident": "INC0099999", "user": "ada"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a missing incident to fail permanently, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"instance": "evil.example.com", "token": "t", "operation": "create", "fields": map[string]any{"short_description": "x"}},
+		{"instance": "acme", "operation": "create", "fields": map[string]any{"short_description": "x"}},
+		{"instance": "acme", "token": "t", "operation": "create", "fields": map[string]any{"urgency": "1"}},
+		{"instance": "acme", "token": "t", "operation": "create", "fields": map[string]any{"short_description": "x", "Bad Field": "y"}},
+		{"instance": "acme", "token": "t", "operation": "update", "fields": map[string]any{"state": "6"}},
+		{"instance": "acme", "token": "t", "operation": "update", "sys_id": "../x", "fields": map[string]any{"state": "6"}},
+		{"instance": "acme", "token": "t", "operation": "delete"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
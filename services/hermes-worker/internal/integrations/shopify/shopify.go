@@ -0,0 +1,381 @@
+// Package shopify writes back to a Shopify store through the Admin GraphQL
+// API: tagging orders, adding order notes and creating fulfillment events
+package shopify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	defaultAPIVersion = "2025-01"
+
+	opTagOrder         = "tag_order"
+	opAddNote          = "add_note"
+	opFulfillmentEvent = "fulfillment_event"
+	// Order notes are capped at 5000 characters
+	maxNoteBytes = 5000
+)
+
+var (
+	validShop       = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*\.myshopify\.com$`)
+	validAPIVersion = regexp.MustCompile(`^\d{4}-\d{2}$`)
+	// Global IDs of one resource type, e.g. gid://shopify/Order/450789469
+	validGID = regexp.MustCompile(`^gid://shopify/[A-Za-z]+/\d+$`)
+)
+
+// Statuses a fulfillment event can report
+var fulfillmentStatuses = map[string]bool{
+	"LABEL_PRINTED": true, "LABEL_PURCHASED": true, "ATTEMPTED_DELIVERY": true, "READY_FOR_PICKUP": true,
+	"PICKED_UP": true, "CONFIRMED": true, "IN_TRANSIT": true, "OUT_FOR_DELIVERY": true, "DELIVERED": true,
+	"FAILURE": true, "DELAYED": true, "CARRIER_PICKED_UP": true,
+}
+
+const tagsAddMutation = `mutation($id: ID!, $tags: [String!]!) {
+  tagsAdd(id: $id, tags: $tags) { node { id } userErrors { field message } }
+}`
+
+const orderNoteQuery = `query($id: ID!) { order(id: $id) { note } }`
+
+const orderUpdateMutation = `mutation($input: OrderInput!) {
+  orderUpdate(input: $input) { order { id } userErrors { field message } }
+}`
+
+const fulfillmentEventMutation = `mutation($event: FulfillmentEventInput!) {
+  fulfillmentEventCreate(fulfillmentEvent: $event) { fulfillmentEvent { id status } userErrors { field message } }
+}`
+
+type userError struct {
+	Field   []string `json:"field"`
+	Message string   `json:"message"`
+}
+
+type Client struct {
+	client *http.Client
+	// Endpoint for a shop, overridden in tests
+	endpoint func(shop, version string) string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{
+		client: egress.Client(15 * time.Second),
+		endpoint: func(shop, version string) string {
+			return "https://" + shop + "/admin/api/" + version + "/graphql.json"
+		},
+	}
+}
+
+// Breaker key: Shopify rate limits per store
+func (c *Client) Target(config map[string]any) string {
+	shop, err := shopDomain(config)
+	if err != nil {
+		return ""
+	}
+	return shop
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"shop": "acme.myshopify.com", "access_token": "{{secret:SHOPIFY_TOKEN}}",
+//	 "operation": "tag_order", "order_id": "{{order.id}}", "tags": ["hermes", "{{risk.level}}"]}
+//	{"shop": "acme", "access_token": "...", "operation": "add_note",
+//	 "order_id": "{{order.id}}", "note": "Refund requested via {{source}}"}
+//	{"shop": "acme", "access_token": "...", "operation": "fulfillment_event",
+//	 "fulfillment_id": "{{fulfillment.id}}", "status": "{{tracking.status}}", "message": "{{tracking.detail}}"}
+//
+// access_token is an Admin API token of a custom app. IDs are numeric IDs or
+// global IDs. add_note appends to the order's existing note. status is a
+// fulfillment event status such as IN_TRANSIT or DELIVERED. Strings are
+// mapping templates filled from the payload
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	shop, err := shopDomain(config)
+	if err != nil {
+		return "", err
+	}
+	token, _ := config["access_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing access_token in shopify action config")
+	}
+	version := defaultAPIVersion
+	if v, _ := config["api_version"].(string); v != "" {
+		if !validAPIVersion.MatchString(v) {
+			return "", fmt.Errorf("invalid api_version %q in shopify action config, want e.g. 2025-01", v)
+		}
+		version = v
+	}
+	api := &graphqlAPI{client: c.client, url: c.endpoint(shop, version), token: token}
+	switch operation, _ := config["operation"].(string); operation {
+	case opTagOrder:
+		return tagOrder(ctx, api, config, body)
+	case opAddNote:
+		return addNote(ctx, api, config, body)
+	case opFulfillmentEvent:
+		return fulfillmentEvent(ctx, api, config, body)
+	default:
+		return "", fmt.Errorf("unknown operation %q in shopify action config", operation)
+	}
+}
+
+func tagOrder(ctx context.Context, api *graphqlAPI, config map[string]any, body []byte) (string, error) {
+	id, err := globalID(config, "order_id", "Order", body)
+	if err != nil {
+		return "", err
+	}
+	list, _ := config["tags"].([]any)
+	var tags []string
+	for _, item := range list {
+		tmpl, _ := item.(string)
+		tag, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("tags: %w", err)
+		}
+		// Shopify splits tags on commas
+		if tag = strings.TrimSpace(strings.ReplaceAll(tag, ",", " ")); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("missing tags in shopify action config")
+	}
+	var result struct {
+		TagsAdd struct {
+			UserErrors []userError `json:"userErrors"`
+		} `json:"tagsAdd"`
+	}
+	if err := api.do(ctx, tagsAddMutation, map[string]any{"id": id, "tags": tags}, &result); err != nil {
+		return "", err
+	}
+	if err := userErrors(result.TagsAdd.UserErrors); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tagged %s with %s", id, strings.Join(tags, ", ")), nil
+}
+
+func addNote(ctx context.Context, api *graphqlAPI, config map[string]any, body []byte) (string, error) {
+	id, err := globalID(config, "order_id", "Order", body)
+	if err != nil {
+		return "", err
+	}
+	note, err := render(config, "note", body)
+	if err != nil {
+		return "", err
+	}
+	if note == "" {
+		return "", fmt.Errorf("missing note in shopify action config")
+	}
+	var current struct {
+		Order *struct {
+			Note string `json:"note"`
+		} `json:"order"`
+	}
+	if err := api.do(ctx, orderNoteQuery, map[string]any{"id": id}, &current); err != nil {
+		return "", err
+	}
+	if current.Order == nil {
+		return "", &engine.PermanentError{Err: fmt.Errorf("order %s not found", id)}
+	}
+	if current.Order.Note != "" {
+		note = current.Order.Note + "\n" + note
+	}
+	// Keep the newest lines when the note outgrows the limit
+	if len(note) > maxNoteBytes {
+		note = note[len(note)-maxNoteBytes:]
+	}
+	var result struct {
+		OrderUpdate struct {
+			UserErrors []userError `json:"userErrors"`
+		} `json:"orderUpdate"`
+	}
+	input := map[string]any{"id": id, "note": note}
+	if err := api.do(ctx, orderUpdateMutation, map[string]any{"input": input}, &result); err != nil {
+		return "", err
+	}
+	if err := userErrors(result.OrderUpdate.UserErrors); err != nil {
+		return "", err
+	}
+	return "noted on " + id, nil
+}
+
+func fulfillmentEvent(ctx context.Context, api *graphqlAPI, config map[string]any, body []byte) (string, error) {
+	id, err := globalID(config, "fulfillment_id", "Fulfillment", body)
+	if err != nil {
+		return "", err
+	}
+	status, err := render(config, "status", body)
+	if err != nil {
+		return "", err
+	}
+	status = strings.ToUpper(status)
+	if !fulfillmentStatuses[status] {
+		return "", &engine.PermanentError{Err: fmt.Errorf("unknown fulfillment event status %q", status)}
+	}
+	event := map[string]any{"fulfillmentId": id, "status": status}
+	message, err := render(config, "message", body)
+	if err != nil {
+		return "", err
+	}
+	if message != "" {
+		event["message"] = payload.TruncateString(message, 1000)
+	}
+	var result struct {
+		FulfillmentEventCreate struct {
+			FulfillmentEvent struct {
+				ID string `json:"id"`
+			} `json:"fulfillmentEvent"`
+			UserErrors []userError `json:"userErrors"`
+		} `json:"fulfillmentEventCreate"`
+	}
+	if err := api.do(ctx, fulfillmentEventMutation, map[string]any{"event": event}, &result); err != nil {
+		return "", err
+	}
+	if err := userErrors(result.FulfillmentEventCreate.UserErrors); err != nil {
+		return "", err
+	}
+	return "created " + result.FulfillmentEventCreate.FulfillmentEvent.ID, nil
+}
+
+// Reads a numeric ID or a global ID of kind from the rendered config value
+func globalID(config map[string]any, key, kind string, body []byte) (string, error) {
+	var id string
+	switch v := config[key].(type) {
+	case float64:
+		id = strconv.FormatInt(int64(v), 10)
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		id = strings.TrimSpace(rendered)
+	}
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil && n > 0 {
+		return "gid://shopify/" + kind + "/" + id, nil
+	}
+	if !validGID.MatchString(id) || !strings.HasPrefix(id, "gid://shopify/"+kind+"/") {
+		return "", &engine.PermanentError{Err: fmt.Errorf("invalid %s %q", key, id)}
+	}
+	return id, nil
+}
+
+// Validation failures reported inside a successful response
+func userErrors(errs []userError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, strings.TrimSpace(strings.Join(e.Field, ".")+" "+e.Message))
+	}
+	return &engine.PermanentError{Err: fmt.Errorf("shopify rejected the change: %s", strings.Join(messages, "; "))}
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Takes the store's myshopify.com domain or just its handle
+func shopDomain(config map[string]any) (string, error) {
+	shop, _ := config["shop"].(string)
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	if shop != "" && !strings.Contains(shop, ".") {
+		shop += ".myshopify.com"
+	}
+	if !validShop.MatchString(shop) {
+		return "", fmt.Errorf("missing or invalid shop in shopify action config, want acme or acme.myshopify.com")
+	}
+	return shop, nil
+}
+
+// One store's Admin GraphQL endpoint
+type graphqlAPI struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (a *graphqlAPI) do(ctx context.Context, query string, variables map[string]any, out any) error {
+	jsonBody, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return fmt.Errorf("marshal shopify request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Access-Token", a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors json.RawMessage `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &result)
+	// Top-level errors are a list of objects, or a bare string for auth failures
+	var errs []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	}
+	var bare string
+	if len(result.Errors) > 0 && json.Unmarshal(result.Errors, &errs) != nil {
+		_ = json.Unmarshal(result.Errors, &bare)
+	}
+	if resp.StatusCode < 300 && len(errs) == 0 && bare == "" {
+		return json.Unmarshal(result.Data, out)
+	}
+
+	status := resp.StatusCode
+	messages := []string{}
+	if bare != "" {
+		messages = append(messages, bare)
+	}
+	for _, e := range errs {
+		messages = append(messages, e.Message)
+		switch e.Extensions.Code {
+		case "THROTTLED":
+			status = http.StatusTooManyRequests
+		case "INTERNAL_SERVER_ERROR":
+			status = http.StatusInternalServerError
+		}
+	}
+	if status < 300 {
+		// GraphQL reports failures inside a 200
+		status = http.StatusBadRequest
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: status,
+		Msg:        fmt.Sprintf("shopify returned %d: %s", status, strings.Join(messages, "; ")),
+	}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return statusErr
+	}
+	// Bad tokens, missing scopes and invalid queries fail the same way every time
+	return &engine.PermanentError{Err: statusErr}
+}
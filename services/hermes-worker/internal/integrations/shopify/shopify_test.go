@@ -0,0 +1,125 @@
+package shopify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+func newServer(t *testing.T, reply func(req graphqlRequest) string) (*Client, *[]graphqlRequest) {
+	var requests []graphqlRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Shopify-Access-Token") != "shpat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors": "[API] Invalid API key or access token (unrecognized login or wrong password)"}`))
+			return
+		}
+		var req graphqlRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		w.Write([]byte(reply(req)))
+	}))
+	t.Cleanup(srv.Close)
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.endpoint = func(shop, version string) string { return srv.URL + "/" + shop + "/" + version }
+	return c, &requests
+}
+
+func TestTagOrder(t *testing.T) {
+	c, requests := newServer(t, func(graphqlRequest) string {
+		return `{"data": {"tagsAdd": {"node": {"id": "gid://shopify/Order/1"}, "userErrors": []}}}`
+	})
+	config := map[string]any{"shop": "acme", "access_token": "shpat", "operation": "tag_order",
+		"order_id": "{{order.id}}", "tags": []any{"hermes", "{{risk}}"}}
+	body := []byte(`{"order": {"id": 450789469}, "risk": "high, manual"}`)
+	if _, err := c.ExecuteWithResponse(context.Background(), config, body); err != nil {
+		t.Fatalf("Expected the order to be tagged, got %v", err)
+	}
+	vars := (*requests)[0].Variables
+	tags, _ := vars["tags"].([]any)
+	if vars["id"] != "gid://shopify/Order/450789469" || len(tags) != 2 || tags[1] != "high  manual" {
+		t.Errorf("Unexpected variables %v", vars)
+	}
+
+	config["access_token"] = "wrong"
+	_, err := c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestAddNoteAppends(t *testing.T) {
+	c, requests := newServer(t, func(req graphqlRequest) string {
+		if strings.HasPrefix(req.Query, "query") {
+			return `{"data": {"order": {"note": "Gift wrap"}}}`
+		}
+		return `{"data": {"orderUpdate": {"order": {"id": "gid://shopify/Order/1"}, "userErrors": []}}}`
+	})
+	config := map[string]any{"shop": "acme.myshopify.com", "access_token": "shpat", "operation": "add_note",
+		"order_id": "gid://shopify/Order/1", "note": "Refund via {{source}}"}
+	if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"source": "zendesk"}`)); err != nil {
+		t.Fatalf("Expected the note to be added, got %v", err)
+	}
+	input, _ := (*requests)[1].Variables["input"].(map[string]any)
+	if input["note"] != "Gift wrap\nRefund via zendesk" {
+		t.Errorf("Expected the note appended, got %v", input)
+	}
+}
+
+func TestFulfillmentEventUserErrorsArePermanent(t *testing.T) {
+	c, requests := newServer(t, func(graphqlRequest) string {
+		return `{"data": {"fulfillmentEventCreate": {"fulfillmentEvent": null,
+			"userErrors": [{"field": ["fulfillmentId"], "message": "Fulfillment does not exist"}]}}}`
+	})
+	config := map[string]any{"shop": "acme", "access_token": "shpat", "operation": "fulfillment_event",
+		"fulfillment_id": "{{id}}", "status": "{{status}}"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{"id": 255858046, "status": "in_transit"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected user errors to fail permanently, got %v", err)
+	}
+	event, _ := (*requests)[0].Variables["event"].(map[string]any)
+	if event["fulfillmentId"] != "gid://shopify/Fulfillment/255858046" || event["status"] != "IN_TRANSIT" {
+		t.Errorf("Unexpected event %v", event)
+	}
+}
+
+func TestThrottlingIsRetryable(t *testing.T) {
+	c, _ := newServer(t, func(graphqlRequest) string {
+		return `{"errors": [{"message": "Throttled", "extensions": {"code": "THROTTLED"}}]}`
+	})
+	config := map[string]any{"shop": "acme", "access_token": "shpat", "operation": "tag_order",
+		"order_id": float64(1), "tags": []any{"x"}}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if err == nil || errors.As(err, &p) {
+		t.Errorf("Expected a retryable error, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"shop": "evil.example.com", "access_token": "t", "operation": "tag_order"},
+		{"shop": "acme", "operation": "tag_order"},
+		{"shop": "acme", "access_token": "t", "operation": "cancel_order"},
+		{"shop": "acme", "access_token": "t", "operation": "tag_order", "order_id": "gid://shopify/Customer/1", "tags": []any{"x"}},
+		{"shop": "acme", "access_token": "t", "operation": "fulfillment_event", "fulfillment_id": "1", "status": "lost"},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
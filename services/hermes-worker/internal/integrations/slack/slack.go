@@ -4,11 +4,37 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	postMessageURL = "https://slack.com/api/chat.postMessage"
+	// Slack rejects message text longer than this
+	maxTextBytes = 40000
+	// Slack accepts at most 50 blocks per message
+	maxBlocks   = 50
+	maxAttempts = 3
+	// Rate limits longer than this defer the job instead of holding a worker
+	maxRetryWait = 5 * time.Second
 )
 
+// chat.postMessage errors that may clear up on a later attempt. Anything else
+// (invalid_auth, channel_not_found, invalid_blocks, ...) fails the same way
+// every time
+var retryableErrors = map[string]bool{
+	"ratelimited": true, "service_unavailable": true, "internal_error": true,
+	"fatal_error": true, "request_timeout": true,
+}
+
 type Config struct {
 	WebhookURL      string
 	MessageTemplate string
@@ -16,57 +42,254 @@ type Config struct {
 
 type Sender struct {
 	client *http.Client
+	// chat.postMessage endpoint, overridden in tests
+	apiURL string
 }
 
-func New() *Sender {
+// Webhook URLs are user supplied, so requests go through the egress policy
+func New(egress *engine.Egress) *Sender {
 	return &Sender{
-		client: &http.Client{Timeout: 5 * time.Second},
+		client: egress.Client(5 * time.Second),
+		apiURL: postMessageURL,
 	}
 }
 
-func (s *Sender) Execute(ctx context.Context, cfg map[string]any, payload []byte) error {
-	webhookURL, _ := cfg["webhook_url"].(string)
-	template, _ := cfg["message_template"].(string)
+// Breaker key: failures are tracked per webhook, or per channel for bot tokens
+func (s *Sender) Target(config map[string]any) string {
+	if token, _ := config["token"].(string); token != "" {
+		channel, _ := config["channel"].(string)
+		return "slack.com/" + channel
+	}
+	return engine.EndpointOf(config, "webhook_url")
+}
 
-	if webhookURL == "" {
-		return fmt.Errorf("missing webhook_url in slack action config")
+func (s *Sender) Execute(ctx context.Context, cfg map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, cfg, body)
+	return err
+}
+
+// Config:
+//
+//	{"webhook_url": "https://hooks.slack.com/services/...", "message_template": "New deploy"}
+//	{"token": "{{secret:SLACK_BOT_TOKEN}}", "channel": "#deploys-{{env}}", "thread_ts": "{{slack.ts}}",
+//	 "message_template": "Deploy finished",
+//	 "blocks": [{"type": "section", "text": {"type": "mrkdwn", "text": "*{{repository.name}}* is live"}}]}
+//
+// A bot token posts through chat.postMessage to channel, which is required
+// then; with a webhook, channel overrides the webhook's default where Slack
+// allows it. message_template is sent as written; strings in blocks,
+// thread_ts and channel are mapping templates filled from the payload, and a
+// template the payload can't fill fails the step permanently. With blocks the
+// text becomes the notification fallback. Without text or blocks the payload
+// itself is posted
+func (s *Sender) ExecuteWithResponse(ctx context.Context, cfg map[string]any, body []byte) (string, error) {
+	webhookURL, _ := cfg["webhook_url"].(string)
+	token, _ := cfg["token"].(string)
+	channel, _ := cfg["channel"].(string)
+	endpoint := webhookURL
+	switch {
+	case token != "":
+		if channel == "" {
+			return "", fmt.Errorf("missing channel in slack action config, required with token")
+		}
+		endpoint = s.apiURL
+	case webhookURL == "":
+		return "", fmt.Errorf("missing webhook_url or token in slack action config")
 	}
-	var text string
-	if template != "" {
-		text = template
-	} else {
-		text = fmt.Sprintf("Payload:\n```json\n%s\n```", string(payload))
+	msg, err := buildMessage(cfg, body)
+	if err != nil {
+		return "", &engine.PermanentError{Err: err}
 	}
-	bodyMap := map[string]any{
-		"text": text,
+	if channel != "" {
+		if channel, err = mapping.Render(channel, body, nil); err != nil {
+			return "", &engine.PermanentError{Err: fmt.Errorf("channel: %w", err)}
+		}
+		msg["channel"] = channel
 	}
-
-	bodyJSON, err := json.Marshal(bodyMap)
+	bodyJSON, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("marshal slack body: %w", err)
+		return "", fmt.Errorf("marshal slack body: %w", err)
 	}
 
 	var lastErr error
-	for attempt := range 3 {
-		req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewBuffer(bodyJSON))
+	var response string
+	for attempt := range maxAttempts {
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(bodyJSON))
 		if reqErr != nil {
-			return fmt.Errorf("build request: %w", reqErr)
+			return "", fmt.Errorf("build request: %w", reqErr)
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
 		}
-		req.Header.Set("Content-Type", "application/json")
+		wait := backoff(attempt)
 		resp, doErr := s.client.Do(req)
 		if doErr != nil {
 			lastErr = doErr
 		} else {
-			resp.Body.Close()
-			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
-				lastErr = fmt.Errorf("slack returned %d", resp.StatusCode)
-			} else if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-				return nil
-			} else {
-				return fmt.Errorf("slack returned non-retryable status %d", resp.StatusCode)
+			var limit time.Duration
+			response, limit, lastErr = readResult(resp, token != "")
+			if lastErr == nil {
+				return response, nil
 			}
+			if limit > maxRetryWait {
+				return response, &engine.DeferError{Delay: limit, Err: lastErr}
+			}
+			if limit > 0 {
+				wait = limit
+			}
+			if !retryable(lastErr) {
+				return response, lastErr
+			}
+		}
+		if attempt < maxAttempts-1 && !sleep(ctx, wait) {
+			return response, ctx.Err()
+		}
+	}
+	return response, fmt.Errorf("slack send failed after retries: %w", lastErr)
+}
+
+func buildMessage(cfg map[string]any, body []byte) (map[string]any, error) {
+	msg := map[string]any{}
+	// Literal, so existing messages with "{{" in them keep being sent as written
+	if text, _ := cfg["message_template"].(string); text != "" {
+		msg["text"] = payload.TruncateString(text, maxTextBytes)
+	}
+	if raw, ok := cfg["blocks"]; ok {
+		blocks, isList := raw.([]any)
+		if !isList || len(blocks) == 0 || len(blocks) > maxBlocks {
+			return nil, fmt.Errorf("blocks must be a list of 1 to %d objects", maxBlocks)
+		}
+		rendered, err := renderStrings(blocks, body)
+		if err != nil {
+			return nil, fmt.Errorf("blocks: %w", err)
+		}
+		msg["blocks"] = rendered
+	}
+	if tmpl, _ := cfg["thread_ts"].(string); tmpl != "" {
+		ts, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("thread_ts: %w", err)
+		}
+		// An event without a thread to follow up on starts a new one
+		if ts != "" {
+			msg["thread_ts"] = ts
+		}
+	}
+	for _, key := range []string{"username", "icon_emoji", "icon_url"} {
+		if value, _ := cfg[key].(string); value != "" {
+			msg[key] = value
 		}
-		time.Sleep(time.Duration(200*(attempt+1)) * time.Millisecond)
 	}
-	return fmt.Errorf("slack send failed after retries: %w", lastErr)
+	if msg["text"] == nil && msg["blocks"] == nil {
+		preview, _ := payload.Truncate(body, maxTextBytes-64)
+		msg["text"] = fmt.Sprintf("Payload:\n```json\n%s\n```", string(preview))
+	}
+	return msg, nil
+}
+
+// Turns a response into the step response, how long Slack asked us to back
+// off, and an error. Webhooks answer "ok" in plain text; chat.postMessage
+// answers 200 with "ok": false on failure
+func readResult(resp *http.Response, api bool) (string, time.Duration, error) {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	response := fmt.Sprintf("%d: %s", resp.StatusCode, data)
+	var limit time.Duration
+	if resp.StatusCode == http.StatusTooManyRequests {
+		limit = time.Second
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			limit = time.Duration(seconds) * time.Second
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return response, limit, &engine.StatusError{
+			StatusCode: resp.StatusCode,
+			Msg:        fmt.Sprintf("slack returned %d", resp.StatusCode),
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return response, 0, &engine.StatusError{
+			StatusCode: resp.StatusCode,
+			Msg:        fmt.Sprintf("slack returned non-retryable status %d: %s", resp.StatusCode, data),
+		}
+	}
+	if !api {
+		return response, 0, nil
+	}
+	var result struct {
+		OK      bool   `json:"ok"`
+		Error   string `json:"error"`
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return response, 0, fmt.Errorf("unexpected slack response: %s", data)
+	}
+	if !result.OK {
+		err := fmt.Errorf("slack returned %s", result.Error)
+		if retryableErrors[result.Error] {
+			return response, 0, err
+		}
+		return response, 0, &engine.PermanentError{Err: err}
+	}
+	// ts lets later actions thread replies under this message
+	return fmt.Sprintf("%d: ts %s in %s", resp.StatusCode, result.TS, result.Channel), 0, nil
+}
+
+func retryable(err error) bool {
+	var permanent *engine.PermanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var statusErr *engine.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// Fills the templates in every string of a block, leaving other values alone
+func renderStrings(v any, body []byte) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return mapping.Render(t, body, nil)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for key, item := range t {
+			rendered, err := renderStrings(item, body)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func backoff(attempt int) time.Duration {
+	return time.Duration(200*(attempt+1)) * time.Millisecond
+}
+
+// Waits for d unless ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
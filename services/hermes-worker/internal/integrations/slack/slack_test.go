@@ -0,0 +1,125 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestBotTokenPostsBlocksInThread(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-1" {
+			w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"ok": true, "channel": "C123", "ts": "1712.000200"}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	s.apiURL = srv.URL
+	config := map[string]any{"token": "xoxb-1", "channel": "#deploys-{{env}}", "thread_ts": "{{ts}}",
+		"message_template": "Deploy finished",
+		"blocks":           []any{map[string]any{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": "*{{repo}}* is live"}}}}
+	response, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{"repo": "hermes", "env": "prod", "ts": "1712.000100"}`))
+	if err != nil {
+		t.Fatalf("Expected the message to be posted, got %v", err)
+	}
+	blocks, _ := got["blocks"].([]any)
+	block, _ := blocks[0].(map[string]any)
+	text, _ := block["text"].(map[string]any)
+	if got["channel"] != "#deploys-prod" || got["thread_ts"] != "1712.000100" || got["text"] != "Deploy finished" || text["text"] != "*hermes* is live" {
+		t.Errorf("Unexpected message %v", got)
+	}
+	if response != "200: ts 1712.000200 in C123" {
+		t.Errorf("Unexpected response %q", response)
+	}
+
+	config["token"] = "xoxb-wrong"
+	_, err = s.ExecuteWithResponse(context.Background(), config, []byte(`{"repo": "hermes", "env": "prod", "ts": "1"}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected invalid_auth to fail permanently, got %v", err)
+	}
+}
+
+func TestMessageTemplateIsLiteral(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	config := map[string]any{"webhook_url": srv.URL, "message_template": "Use {{missing}} as written"}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the text sent as written, got %v", err)
+	}
+	if got["text"] != "Use {{missing}} as written" {
+		t.Errorf("Unexpected text %v", got["text"])
+	}
+
+	// Templated fields the payload can't fill won't fill on a retry either
+	config["blocks"] = []any{map[string]any{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": "{{missing}}"}}}
+	_, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a block that can't render to fail permanently, got %v", err)
+	}
+}
+
+func TestHonorsRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/long" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	start := time.Now()
+	if _, err := s.ExecuteWithResponse(context.Background(), map[string]any{"webhook_url": srv.URL}, []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if calls != 2 || time.Since(start) < time.Second {
+		t.Errorf("Expected one retry after a second, got %d calls in %v", calls, time.Since(start))
+	}
+
+	_, err := s.ExecuteWithResponse(context.Background(), map[string]any{"webhook_url": srv.URL + "/long"}, []byte(`{}`))
+	var deferErr *engine.DeferError
+	if !errors.As(err, &deferErr) || deferErr.Delay != 30*time.Second {
+		t.Errorf("Expected a long rate limit to defer the job, got %v", err)
+	}
+}
+
+func TestRejectsBadConfig(t *testing.T) {
+	s := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{},
+		{"token": "xoxb-1"},
+		{"webhook_url": "https://hooks.slack.com/x", "blocks": "not a list"},
+	} {
+		if _, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package ssh runs operator-approved commands on remote hosts over SSH
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+	maxTimeout     = 10 * time.Minute
+	// Step logs keep less than this, the rest of the output is dropped
+	maxOutputBytes = 4096
+)
+
+var errDisabled = errors.New("ssh action is disabled, set SSH_ACTION_ENABLED and SSH_ALLOWED_COMMANDS on the worker to enable it")
+
+type Runner struct {
+	enabled bool
+	// Exact command strings the operator allows relays to run
+	commands map[string]bool
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Connections go through the egress policy like every other outbound call
+func New(egress *engine.Egress, enabled bool, commands []string) *Runner {
+	r := &Runner{enabled: enabled, commands: make(map[string]bool), dial: egress.DialContext()}
+	for _, command := range commands {
+		r.commands[command] = true
+	}
+	return r
+}
+
+// Breaker key: the host
+func (r *Runner) Target(config map[string]any) string {
+	addr, err := hostAddr(config)
+	if err != nil {
+		return ""
+	}
+	return addr
+}
+
+func (r *Runner) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := r.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"host": "app-1.example.com", "user": "deploy", "private_key": "{{secret:DEPLOY_KEY}}",
+//	 "host_key": "ssh-ed25519 AAAAC3Nz...", "command": "/opt/app/bin/deploy",
+//	 "args": ["{{sha}}", "--env", "prod"], "timeout": "2m"}
+//
+// command must be one of SSH_ALLOWED_COMMANDS word for word; args are mapping
+// templates filled from the payload and passed shell quoted. host_key pins the
+// server's public key as in authorized_keys. The payload goes to the command's
+// stdin and its combined output becomes the step response. timeout defaults
+// to 60s, at most 10m
+func (r *Runner) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	if !r.enabled {
+		return "", &engine.PermanentError{Err: errDisabled}
+	}
+	addr, err := hostAddr(config)
+	if err != nil {
+		return "", err
+	}
+	command, err := r.commandLine(config, body)
+	if err != nil {
+		return "", err
+	}
+	clientConfig, err := clientConfig(config)
+	if err != nil {
+		return "", err
+	}
+	timeout := defaultTimeout
+	if raw, _ := config["timeout"].(string); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > maxTimeout {
+			return "", fmt.Errorf("invalid timeout %q in ssh action config", raw)
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := r.dial(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	// The handshake has no context of its own
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return "", classify(err)
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("open ssh session: %w", err)
+	}
+	defer session.Close()
+
+	out := &outputBuffer{limit: maxOutputBytes}
+	session.Stdin = bytes.NewReader(body)
+	session.Stdout = out
+	session.Stderr = out
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Closing the connection ends the remote side's session too
+		client.Close()
+		return out.String(), fmt.Errorf("ssh command did not finish: %w", ctx.Err())
+	}
+	if err != nil {
+		return out.String(), classify(err)
+	}
+	return out.String(), nil
+}
+
+// Checks the command against the allow list and appends the quoted arguments
+func (r *Runner) commandLine(config map[string]any, body []byte) (string, error) {
+	command, _ := config["command"].(string)
+	if command == "" {
+		return "", fmt.Errorf("missing command in ssh action config")
+	}
+	if !r.commands[command] {
+		return "", &engine.PermanentError{Err: fmt.Errorf("command %q is not in SSH_ALLOWED_COMMANDS", command)}
+	}
+	line := command
+	if raw, ok := config["args"]; ok {
+		args, ok := raw.([]any)
+		if !ok {
+			return "", fmt.Errorf("args must be a list of strings")
+		}
+		for i, item := range args {
+			tmpl, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("arg %d must be a string", i)
+			}
+			arg, err := mapping.Render(tmpl, body, nil)
+			if err != nil {
+				return "", fmt.Errorf("arg %d: %w", i, err)
+			}
+			line += " " + shellQuote(arg)
+		}
+	}
+	return line, nil
+}
+
+func clientConfig(config map[string]any) (*ssh.ClientConfig, error) {
+	user, _ := config["user"].(string)
+	if user == "" {
+		return nil, fmt.Errorf("missing user in ssh action config")
+	}
+	privateKey, _ := config["private_key"].(string)
+	if privateKey == "" {
+		return nil, fmt.Errorf("missing private_key in ssh action config")
+	}
+	var signer ssh.Signer
+	var err error
+	if passphrase, _ := config["passphrase"].(string); passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+	}
+	if err != nil {
+		return nil, &engine.PermanentError{Err: fmt.Errorf("invalid private_key in ssh action config: %w", err)}
+	}
+	// Without a pinned key anyone on the path could answer for the host
+	hostKey, _ := config["host_key"].(string)
+	if hostKey == "" {
+		return nil, fmt.Errorf("missing host_key in ssh action config")
+	}
+	pinned, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host_key in ssh action config: %w", err)
+	}
+	return &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback:   ssh.FixedHostKey(pinned),
+		HostKeyAlgorithms: []string{pinned.Type()},
+	}, nil
+}
+
+// Takes host or host:port, port 22 by default
+func hostAddr(config map[string]any) (string, error) {
+	host, _ := config["host"].(string)
+	if host == "" {
+		return "", fmt.Errorf("missing host in ssh action config")
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+	if strings.ContainsAny(host, "/@ ") {
+		return "", fmt.Errorf("invalid host in ssh action config")
+	}
+	return net.JoinHostPort(host, "22"), nil
+}
+
+// Single quotes everything, closing and reopening around embedded quotes
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Rejected keys and host key mismatches fail the same way every time, as do
+// commands the remote shell can't find or run. Other exit codes may be
+// transient and are retried
+func classify(err error) error {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("ssh command exited with status %d", exitErr.ExitStatus())
+		if exitErr.ExitStatus() == 126 || exitErr.ExitStatus() == 127 {
+			return &engine.PermanentError{Err: err}
+		}
+		return err
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "host key mismatch") {
+		return &engine.PermanentError{Err: err}
+	}
+	return err
+}
+
+// Keeps the first limit bytes of the output and discards the rest. Writes
+// always succeed so a long-winded command isn't cut off mid-run. stdout and
+// stderr are copied in from separate goroutines
+type outputBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// Drops a partial rune left behind by the cut
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := b.buf.Bytes()
+	for len(out) > 0 {
+		r, size := utf8.DecodeLastRune(out)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		out = out[:len(out)-1]
+	}
+	return string(out)
+}
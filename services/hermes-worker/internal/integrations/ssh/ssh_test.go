@@ -0,0 +1,159 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"golang.org/x/crypto/ssh"
+)
+
+// Starts a server that accepts clientKey and answers exec requests by
+// echoing the command line and stdin, exiting 127 for "missing"
+func startServer(t *testing.T, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPriv)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "deploy" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(hostSigner)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, config)
+		}
+	}()
+	return lis.Addr().String(), hostSigner.PublicKey()
+}
+
+func serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		ch, requests, _ := newChan.Accept()
+		go func() {
+			defer ch.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				command := string(req.Payload[4:])
+				stdin, _ := io.ReadAll(ch)
+				status := uint32(0)
+				if strings.HasPrefix(command, "missing") {
+					status = 127
+				}
+				io.WriteString(ch, "ran "+command+"\n")
+				io.WriteString(ch.Stderr(), "stdin "+string(stdin))
+				ch.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+				return
+			}
+		}()
+	}
+}
+
+func clientKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("marshal key failed: %v", err)
+	}
+	sshPub, _ := ssh.NewPublicKey(pub)
+	return string(pem.EncodeToMemory(block)), sshPub
+}
+
+func TestRunsAllowedCommand(t *testing.T) {
+	privateKey, pub := clientKey(t)
+	addr, hostKey := startServer(t, pub)
+	r := New(&engine.Egress{AllowPrivate: true}, true, []string{"/opt/deploy", "missing"})
+	config := map[string]any{"host": addr, "user": "deploy", "private_key": privateKey,
+		"host_key": string(ssh.MarshalAuthorizedKey(hostKey)), "command": "/opt/deploy", "args": []any{"{{sha}}", "it's"}}
+	response, err := r.ExecuteWithResponse(context.Background(), config, []byte(`{"sha": "abc"}`))
+	if err != nil {
+		t.Fatalf("Expected the command to run, got %v", err)
+	}
+	if !strings.Contains(response, `ran /opt/deploy 'abc' 'it'\''s'`) || !strings.Contains(response, `stdin {"sha": "abc"}`) {
+		t.Errorf("Unexpected output %q", response)
+	}
+
+	config["command"] = "missing"
+	delete(config, "args")
+	_, err = r.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "127") {
+		t.Errorf("Expected a missing command to fail permanently, got %v", err)
+	}
+
+	otherKey, _ := clientKey(t)
+	config["private_key"] = otherKey
+	if _, err = r.ExecuteWithResponse(context.Background(), config, nil); !errors.As(err, &p) {
+		t.Errorf("Expected a rejected key to fail permanently, got %v", err)
+	}
+}
+
+func TestRejectsWrongHostKey(t *testing.T) {
+	privateKey, pub := clientKey(t)
+	addr, _ := startServer(t, pub)
+	_, impostor, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(impostor)
+	r := New(&engine.Egress{AllowPrivate: true}, true, []string{"uptime"})
+	config := map[string]any{"host": addr, "user": "deploy", "private_key": privateKey,
+		"host_key": string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "command": "uptime"}
+	_, err := r.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a host key mismatch to fail permanently, got %v", err)
+	}
+}
+
+func TestGatedByOperator(t *testing.T) {
+	config := map[string]any{"host": "app-1", "user": "deploy", "private_key": "x", "host_key": "x", "command": "rm -rf /"}
+	_, err := New(&engine.Egress{}, false, []string{"rm -rf /"}).ExecuteWithResponse(context.Background(), config, nil)
+	if !errors.Is(err, errDisabled) {
+		t.Errorf("Expected the action to be disabled, got %v", err)
+	}
+	_, err = New(&engine.Egress{}, true, []string{"uptime"}).ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "SSH_ALLOWED_COMMANDS") {
+		t.Errorf("Expected a command outside the allow list to be rejected, got %v", err)
+	}
+}
+
+func TestEgressPolicyBlocksPrivateHosts(t *testing.T) {
+	privateKey, pub := clientKey(t)
+	addr, hostKey := startServer(t, pub)
+	r := New(&engine.Egress{}, true, []string{"uptime"})
+	config := map[string]any{"host": addr, "user": "deploy", "private_key": privateKey,
+		"host_key": string(ssh.MarshalAuthorizedKey(hostKey)), "command": "uptime"}
+	if _, err := r.ExecuteWithResponse(context.Background(), config, nil); !errors.Is(err, engine.ErrEgressDenied) {
+		t.Errorf("Expected loopback to be denied, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package stripe automates common billing steps off incoming events: creating
+// customers and invoice items and attaching metadata
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL = "https://api.stripe.com/v1"
+
+	opCreateCustomer    = "create_customer"
+	opCreateInvoiceItem = "create_invoice_item"
+	opUpdateMetadata    = "update_metadata"
+	// Stripe allows 50 metadata keys of at most 40 characters
+	maxMetadataKeys = 50
+)
+
+// Objects update_metadata can reach, by their API path
+var metadataObjects = map[string]string{
+	"customer":       "customers",
+	"invoice":        "invoices",
+	"subscription":   "subscriptions",
+	"payment_intent": "payment_intents",
+	"charge":         "charges",
+	"product":        "products",
+	"price":          "prices",
+}
+
+var (
+	// IDs like cus_NffrFeUfNV2Hib end up in the request path
+	validObjectID    = regexp.MustCompile(`^[a-z]+_[A-Za-z0-9]+$`)
+	validCurrency    = regexp.MustCompile(`^[a-z]{3}$`)
+	validMetadataKey = regexp.MustCompile(`^[^\[\]]{1,40}$`)
+)
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	return &Client{client: egress.Client(15 * time.Second), url: apiURL}
+}
+
+// Breaker key: one shared API
+func (c *Client) Target(config map[string]any) string {
+	return "api.stripe.com"
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"api_key": "{{secret:STRIPE_RESTRICTED_KEY}}", "operation": "create_customer",
+//	 "email": "{{user.email}}", "name": "{{user.name}}", "metadata": {"signup_source": "{{source}}"}}
+//	{"api_key": "...", "operation": "create_invoice_item", "customer": "{{account.stripe_id}}",
+//	 "amount": "{{usage.cents}}", "currency": "usd", "description": "{{usage.units}} builds"}
+//	{"api_key": "...", "operation": "update_metadata", "object": "subscription",
+//	 "id": "{{subscription_id}}", "metadata": {"plan_change": "{{event_id}}"}}
+//
+// Strings are mapping templates filled from the payload. A restricted key
+// limited to the resources used is recommended. Every request carries the
+// event's idempotency key, so a retried step isn't applied twice
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	key, _ := config["api_key"].(string)
+	if key == "" {
+		return "", fmt.Errorf("missing api_key in stripe action config")
+	}
+	var path string
+	var form url.Values
+	var err error
+	switch operation, _ := config["operation"].(string); operation {
+	case opCreateCustomer:
+		path = "/customers"
+		form, err = customerForm(config, body)
+	case opCreateInvoiceItem:
+		path = "/invoiceitems"
+		form, err = invoiceItemForm(config, body)
+	case opUpdateMetadata:
+		path, form, err = metadataUpdate(config, body)
+	default:
+		return "", fmt.Errorf("unknown operation %q in stripe action config", operation)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+key)
+	if idem, ok := idempotency.FromContext(ctx); ok {
+		req.Header.Set(idempotency.Header, idem.Key)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		ID     string `json:"id"`
+		Object string `json:"object"`
+		Error  struct {
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: %s %s", resp.StatusCode, result.Object, result.ID), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg: fmt.Sprintf("stripe returned %d: %s", resp.StatusCode,
+			strings.TrimSpace(result.Error.Type+" "+result.Error.Code+" "+result.Error.Message)),
+	}
+	// Stripe-Should-Retry says best whether the same request may pass later
+	if retry := resp.Header.Get("Stripe-Should-Retry"); retry != "" {
+		if retry == "true" {
+			return "", statusErr
+		}
+		return "", &engine.PermanentError{Err: statusErr}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Invalid parameters, missing objects and key permissions fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func customerForm(config map[string]any, body []byte) (url.Values, error) {
+	form := url.Values{}
+	for _, key := range []string{"email", "name", "description", "phone"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	if err := addMetadata(form, config, body); err != nil {
+		return nil, err
+	}
+	if len(form) == 0 {
+		return nil, fmt.Errorf("stripe create_customer needs at least one of email, name, description, phone and metadata")
+	}
+	return form, nil
+}
+
+func invoiceItemForm(config map[string]any, body []byte) (url.Values, error) {
+	customer, err := objectID(config, "customer", body)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := render(config, "currency", body)
+	if err != nil {
+		return nil, err
+	}
+	if currency = strings.ToLower(currency); !validCurrency.MatchString(currency) {
+		return nil, fmt.Errorf("missing or invalid currency in stripe action config, want an ISO code like usd")
+	}
+	amount, err := renderAmount(config["amount"], body)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"customer": {customer}, "currency": {currency}, "amount": {strconv.FormatInt(amount, 10)}}
+	for _, key := range []string{"description", "invoice"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	if err := addMetadata(form, config, body); err != nil {
+		return nil, err
+	}
+	return form, nil
+}
+
+func metadataUpdate(config map[string]any, body []byte) (string, url.Values, error) {
+	object, _ := config["object"].(string)
+	resource, ok := metadataObjects[object]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown object %q in stripe action config", object)
+	}
+	id, err := objectID(config, "id", body)
+	if err != nil {
+		return "", nil, err
+	}
+	form := url.Values{}
+	if err := addMetadata(form, config, body); err != nil {
+		return "", nil, err
+	}
+	if len(form) == 0 {
+		return "", nil, fmt.Errorf("missing metadata in stripe action config")
+	}
+	return "/" + resource + "/" + id, form, nil
+}
+
+// Adds metadata[key]=value for each templated metadata entry. An empty value
+// unsets the key on Stripe's side
+func addMetadata(form url.Values, config map[string]any, body []byte) error {
+	raw, ok := config["metadata"]
+	if !ok {
+		return nil
+	}
+	metadata, ok := raw.(map[string]any)
+	if !ok || len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata must map at most %d keys to templates", maxMetadataKeys)
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		if !validMetadataKey.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tmpl, _ := metadata[key].(string)
+		value, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return fmt.Errorf("metadata.%s: %w", key, err)
+		}
+		form.Set("metadata["+key+"]", value)
+	}
+	return nil
+}
+
+func objectID(config map[string]any, key string, body []byte) (string, error) {
+	id, err := render(config, key, body)
+	if err != nil {
+		return "", err
+	}
+	if !validObjectID.MatchString(id) {
+		return "", &engine.PermanentError{Err: fmt.Errorf("invalid %s %q", key, id)}
+	}
+	return id, nil
+}
+
+// Amounts are in the currency's smallest unit, e.g. cents
+func renderAmount(raw any, body []byte) (int64, error) {
+	var text string
+	switch v := raw.(type) {
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		rendered, err := mapping.Render(v, body, nil)
+		if err != nil {
+			return 0, fmt.Errorf("amount: %w", err)
+		}
+		text = strings.TrimSpace(rendered)
+	default:
+		return 0, fmt.Errorf("missing amount in stripe action config")
+	}
+	amount, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, &engine.PermanentError{Err: fmt.Errorf("amount %q is not a whole number of the smallest currency unit", text)}
+	}
+	return amount, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return strings.TrimSpace(out), nil
+}
@@ -0,0 +1,103 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/idempotency"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestOperations(t *testing.T) {
+	var path, idemKey string
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer rk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"type": "invalid_request_error", "message": "Invalid API Key provided"}}`))
+			return
+		}
+		r.ParseForm()
+		path, form, idemKey = r.URL.Path, r.PostForm, r.Header.Get("Idempotency-Key")
+		w.Write([]byte(`{"id": "cus_123", "object": "customer"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	ctx := idempotency.WithContext(context.Background(), idempotency.Value{Key: "k1"})
+	body := []byte(`{"user": {"email": "ada@example.com"}, "account": "cus_123", "cents": 1250, "sub": "sub_9"}`)
+
+	config := map[string]any{"api_key": "rk_test", "operation": "create_customer",
+		"email": "{{user.email}}", "metadata": map[string]any{"source": "hermes"}}
+	response, err := c.ExecuteWithResponse(ctx, config, body)
+	if err != nil || response != "200: customer cus_123" {
+		t.Fatalf("Expected the customer to be created, got %q (%v)", response, err)
+	}
+	if path != "/customers" || form.Get("email") != "ada@example.com" || form.Get("metadata[source]") != "hermes" || idemKey != "k1" {
+		t.Errorf("Unexpected request %s %v (key %q)", path, form, idemKey)
+	}
+
+	config = map[string]any{"api_key": "rk_test", "operation": "create_invoice_item",
+		"customer": "{{account}}", "amount": "{{cents}}", "currency": "USD"}
+	if _, err := c.ExecuteWithResponse(ctx, config, body); err != nil {
+		t.Fatalf("Expected the invoice item to be created, got %v", err)
+	}
+	if path != "/invoiceitems" || form.Get("customer") != "cus_123" || form.Get("amount") != "1250" || form.Get("currency") != "usd" {
+		t.Errorf("Unexpected request %s %v", path, form)
+	}
+
+	config = map[string]any{"api_key": "rk_test", "operation": "update_metadata", "object": "subscription",
+		"id": "{{sub}}", "metadata": map[string]any{"synced": "yes"}}
+	if _, err := c.ExecuteWithResponse(ctx, config, body); err != nil {
+		t.Fatalf("Expected the metadata to be updated, got %v", err)
+	}
+	if path != "/subscriptions/sub_9" || form.Get("metadata[synced]") != "yes" {
+		t.Errorf("Unexpected request %s %v", path, form)
+	}
+
+	config["api_key"] = "rk_wrong"
+	_, err = c.ExecuteWithResponse(ctx, config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad key to fail permanently, got %v", err)
+	}
+}
+
+func TestShouldRetryHeaderWins(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Stripe-Should-Retry", "true")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"type": "idempotency_error", "message": "request in progress"}}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	config := map[string]any{"api_key": "rk_test", "operation": "create_customer", "name": "x"}
+	_, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`))
+	var p *engine.PermanentError
+	if err == nil || errors.As(err, &p) {
+		t.Errorf("Expected a retryable error, got %v", err)
+	}
+}
+
+func TestRejectsBadInput(t *testing.T) {
+	c := New(&engine.Egress{})
+	for _, config := range []map[string]any{
+		{"api_key": "k", "operation": "refund"},
+		{"api_key": "k", "operation": "create_customer"},
+		{"api_key": "k", "operation": "create_invoice_item", "customer": "cus_1", "amount": "12.50", "currency": "usd"},
+		{"api_key": "k", "operation": "create_invoice_item", "customer": "cus_1", "amount": float64(5), "currency": "dollars"},
+		{"api_key": "k", "operation": "update_metadata", "object": "customer", "id": "../charges", "metadata": map[string]any{"a": "b"}},
+		{"api_key": "k", "operation": "update_metadata", "object": "account", "id": "acct_1", "metadata": map[string]any{"a": "b"}},
+	} {
+		if _, err := c.ExecuteWithResponse(context.Background(), config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
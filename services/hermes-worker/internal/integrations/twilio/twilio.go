@@ -0,0 +1,167 @@
+package twilio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL = "https://api.twilio.com"
+	// Twilio refuses message bodies longer than 1600 characters
+	maxBodyBytes = 1500
+)
+
+// Account SIDs end up in the request path
+var validSID = regexp.MustCompile(`^AC[0-9a-fA-F]{32}$`)
+
+type Sender struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Sender {
+	return &Sender{client: egress.Client(10 * time.Second), url: apiURL}
+}
+
+// Breaker key: failures are tracked per account
+func (s *Sender) Target(config map[string]any) string {
+	sid, _ := config["account_sid"].(string)
+	if !validSID.MatchString(sid) {
+		return ""
+	}
+	return "api.twilio.com/" + sid
+}
+
+func (s *Sender) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"account_sid": "AC...", "auth_token": "{{secret:TWILIO_TOKEN}}",
+//	 "from": "+15005550006", "to": ["+15551234567"], "body": "{{alert.title}} is firing"}
+//
+// to and body are mapping templates filled from the payload. Each recipient
+// gets its own message
+func (s *Sender) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	sid, _ := config["account_sid"].(string)
+	if !validSID.MatchString(sid) {
+		return "", fmt.Errorf("missing or invalid account_sid in twilio_sms action config")
+	}
+	token, _ := config["auth_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing auth_token in twilio_sms action config")
+	}
+	from, _ := config["from"].(string)
+	service, _ := config["messaging_service_sid"].(string)
+	if from == "" && service == "" {
+		return "", fmt.Errorf("twilio_sms action config needs from or messaging_service_sid")
+	}
+	recipients, err := recipients(config["to"], body)
+	if err != nil {
+		return "", err
+	}
+	tmpl, _ := config["body"].(string)
+	if tmpl == "" {
+		return "", fmt.Errorf("missing body in twilio_sms action config")
+	}
+	text, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("body: %w", err)
+	}
+	text = payload.TruncateString(text, maxBodyBytes)
+
+	var sent []string
+	for _, to := range recipients {
+		form := url.Values{"To": {to}, "Body": {text}}
+		if service != "" {
+			form.Set("MessagingServiceSid", service)
+		} else {
+			form.Set("From", from)
+		}
+		messageSID, err := s.send(ctx, sid, token, form)
+		if err != nil {
+			// Earlier recipients already have the message, say so in the step log
+			return fmt.Sprintf("sent: %s", strings.Join(sent, ", ")), fmt.Errorf("sms to %s: %w", to, err)
+		}
+		sent = append(sent, to+" ("+messageSID+")")
+	}
+	return fmt.Sprintf("sent: %s", strings.Join(sent, ", ")), nil
+}
+
+func (s *Sender) send(ctx context.Context, sid, token string, form url.Values) (string, error) {
+	endpoint := s.url + "/2010-04-01/Accounts/" + sid + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(sid, token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return result.SID, nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("twilio returned %d: %d %s", resp.StatusCode, result.Code, result.Message),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	// Invalid numbers, unverified senders and bad credentials fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+// Reads to as one number, a comma separated string or a list
+func recipients(raw any, body []byte) ([]string, error) {
+	var list []string
+	switch v := raw.(type) {
+	case string:
+		list = strings.Split(v, ",")
+	case []any:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("to must list numbers as strings")
+			}
+			list = append(list, s)
+		}
+	}
+	var out []string
+	for _, tmpl := range list {
+		number, err := mapping.Render(strings.TrimSpace(tmpl), body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		if number = strings.TrimSpace(number); number != "" {
+			out = append(out, number)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("missing to in twilio_sms action config")
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package twilio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const testSID = "AC00000000000000000000000000000000"
+
+func TestSendsOneMessagePerRecipient(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != testSID || pass != "token" || r.URL.Path != "/2010-04-01/Accounts/"+testSID+"/Messages.json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("To") == "+15550000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code": 21211, "message": "Invalid 'To' Phone Number", "status": 400}`))
+			return
+		}
+		bodies = append(bodies, r.Form.Get("To")+" "+r.Form.Get("Body"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM1"}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	s.url = srv.URL
+	config := map[string]any{"account_sid": testSID, "auth_token": "token", "from": "+15005550006",
+		"to": []any{"+15551111111", "{{oncall.phone}}"}, "body": "{{alert}} is firing"}
+	payload := []byte(`{"alert": "disk", "oncall": {"phone": "+15552222222"}}`)
+	if _, err := s.ExecuteWithResponse(context.Background(), config, payload); err != nil {
+		t.Fatalf("Expected the sends to succeed, got %v", err)
+	}
+	if strings.Join(bodies, "|") != "+15551111111 disk is firing|+15552222222 disk is firing" {
+		t.Errorf("Unexpected messages %v", bodies)
+	}
+
+	config["to"] = "+15550000000"
+	_, err := s.ExecuteWithResponse(context.Background(), config, payload)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) || !strings.Contains(err.Error(), "21211") {
+		t.Errorf("Expected a permanent invalid number error, got %v", err)
+	}
+}
+
+func TestRejectsInvalidAccountSID(t *testing.T) {
+	s := New(nil)
+	config := map[string]any{"account_sid": "AC../../evil", "auth_token": "t", "from": "+1", "to": "+2", "body": "x"}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+		t.Error("Expected an invalid account_sid to be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+// Package webex posts messages to Cisco Webex rooms and people as a bot
+package webex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	apiURL = "https://webexapis.com/v1"
+	// Webex rejects messages over 7439 bytes
+	maxTextBytes = 7000
+)
+
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(egress *engine.Egress) *Client {
+	// Webex fetches attached files itself, which can take a while
+	return &Client{client: egress.Client(30 * time.Second), url: apiURL}
+}
+
+// Breaker key: one shared API, tracked per destination room or person
+func (c *Client) Target(config map[string]any) string {
+	for _, key := range []string{"room_id", "to_person_email", "to_person_id"} {
+		if value, _ := config[key].(string); value != "" && !strings.Contains(value, "{{") {
+			return "webexapis.com/" + value
+		}
+	}
+	return "webexapis.com"
+}
+
+func (c *Client) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := c.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config:
+//
+//	{"token": "{{secret:WEBEX_BOT_TOKEN}}", "room_id": "Y2lzY29zcGFyazovL3VzL1JPT00v...",
+//	 "markdown": "**{{alert.title}}** is firing\n{{alert.summary}}", "file_url": "{{alert.graph_url}}"}
+//
+// The message goes to room_id, to_person_email or to_person_id. markdown or
+// text is the message, both mapping templates filled from the payload; without
+// either the payload itself is sent. file_url attaches the file Webex
+// downloads from that URL
+func (c *Client) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	token, _ := config["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing token in webex action config")
+	}
+	msg, err := buildMessage(config, body)
+	if err != nil {
+		return "", err
+	}
+	jsonBody, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("marshal webex message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/messages", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result struct {
+		ID         string `json:"id"`
+		Message    string `json:"message"`
+		TrackingID string `json:"trackingId"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: message %s", resp.StatusCode, result.ID), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("webex returned %d: %s (tracking id %s)", resp.StatusCode, result.Message, result.TrackingID),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return "", &engine.DeferError{Delay: time.Duration(seconds) * time.Second, Err: statusErr}
+		}
+		return "", statusErr
+	case resp.StatusCode >= 500:
+		return "", statusErr
+	}
+	// Bad tokens, rooms the bot isn't in and unreachable files fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func buildMessage(config map[string]any, body []byte) (map[string]any, error) {
+	msg := map[string]any{}
+	for _, dest := range []struct{ key, field string }{
+		{"room_id", "roomId"},
+		{"to_person_email", "toPersonEmail"},
+		{"to_person_id", "toPersonId"},
+	} {
+		value, err := render(config, dest.key, body)
+		if err != nil {
+			return nil, err
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			msg[dest.field] = value
+		}
+	}
+	if len(msg) != 1 {
+		return nil, fmt.Errorf("webex action config needs exactly one of room_id, to_person_email and to_person_id")
+	}
+	for _, key := range []string{"markdown", "text"} {
+		value, err := render(config, key, body)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			msg[key] = payload.TruncateString(value, maxTextBytes)
+		}
+	}
+	if msg["markdown"] == nil && msg["text"] == nil {
+		preview, _ := payload.Truncate(body, maxTextBytes-64)
+		msg["markdown"] = fmt.Sprintf("Payload:\n```json\n%s\n```", string(preview))
+	}
+	fileURL, err := render(config, "file_url", body)
+	if err != nil {
+		return nil, err
+	}
+	if fileURL = strings.TrimSpace(fileURL); fileURL != "" {
+		u, err := url.Parse(fileURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, &engine.PermanentError{Err: fmt.Errorf("file_url %q is not an http(s) URL", fileURL)}
+		}
+		// Webex takes a single file per message
+		msg["files"] = []string{fileURL}
+	}
+	return msg, nil
+}
+
+func render(config map[string]any, key string, body []byte) (string, error) {
+	tmpl, _ := config[key].(string)
+	out, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package webex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestPostsMarkdownWithFile(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" || r.Header.Get("Authorization") != "Bearer bot" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "The request requires a valid access token set in the Authorization request header.", "trackingId": "t1"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"id": "msg-1"}`))
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	body := []byte(`{"title": "CPU high", "graph": "https://grafana.example.com/render/1.png", "owner": "ops@example.com"}`)
+	config := map[string]any{"token": "bot", "to_person_email": "{{owner}}",
+		"markdown": "**{{title}}**", "file_url": "{{graph}}"}
+	response, err := c.ExecuteWithResponse(context.Background(), config, body)
+	if err != nil || response != "200: message msg-1" {
+		t.Fatalf("Expected the message to be sent, got %q (%v)", response, err)
+	}
+	files, _ := got["files"].([]any)
+	if got["toPersonEmail"] != "ops@example.com" || got["markdown"] != "**CPU high**" ||
+		len(files) != 1 || files[0] != "https://grafana.example.com/render/1.png" {
+		t.Errorf("Unexpected message %v", got)
+	}
+
+	config["token"] = "wrong"
+	_, err = c.ExecuteWithResponse(context.Background(), config, body)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected a bad token to fail permanently, got %v", err)
+	}
+}
+
+func TestRateLimitDefers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := New(&engine.Egress{AllowPrivate: true})
+	c.url = srv.URL
+	_, err := c.ExecuteWithResponse(context.Background(), map[string]any{"token": "bot", "room_id": "r", "text": "hi"}, nil)
+	var d *engine.DeferError
+	if !errors.As(err, &d) || d.Delay != 30*time.Second {
+		t.Errorf("Expected the job to be deferred 30s, got %v", err)
+	}
+}
+
+func TestBuildMessageNeedsOneDestination(t *testing.T) {
+	for _, config := range []map[string]any{
+		{"text": "hi"},
+		{"room_id": "r", "to_person_email": "a@example.com", "text": "hi"},
+		{"room_id": "r", "file_url": "file:///etc/passwd"},
+	} {
+		if _, err := buildMessage(config, []byte(`{}`)); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package whatsapp sends WhatsApp messages through the Meta Cloud API or
+// Twilio's WhatsApp channel. Outside the 24 hour customer service window
+// WhatsApp only delivers approved templates, so templates are the main path
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/mapping"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const (
+	metaURL        = "https://graph.facebook.com"
+	twilioURL      = "https://api.twilio.com"
+	defaultVersion = "v21.0"
+	// WhatsApp caps text bodies at 4096 characters
+	maxTextBytes = 4000
+)
+
+var (
+	// Phone number IDs, API versions and account SIDs end up in the request path
+	validPhoneNumberID = regexp.MustCompile(`^\d+$`)
+	validVersion       = regexp.MustCompile(`^v\d+\.\d+$`)
+	validSID           = regexp.MustCompile(`^AC[0-9a-fA-F]{32}$`)
+	validContentSID    = regexp.MustCompile(`^HX[0-9a-fA-F]{32}$`)
+)
+
+// Meta error codes that mean throttling rather than a bad request
+var metaRateLimitCodes = map[int]bool{4: true, 80007: true, 130429: true, 131056: true}
+
+type Sender struct {
+	client    *http.Client
+	metaURL   string
+	twilioURL string
+}
+
+func New(egress *engine.Egress) *Sender {
+	return &Sender{client: egress.Client(10 * time.Second), metaURL: metaURL, twilioURL: twilioURL}
+}
+
+// Breaker key: failures are tracked per sending number or account
+func (s *Sender) Target(config map[string]any) string {
+	if provider(config) == "twilio" {
+		sid, _ := config["account_sid"].(string)
+		if !validSID.MatchString(sid) {
+			return ""
+		}
+		return "api.twilio.com/" + sid
+	}
+	id, _ := config["phone_number_id"].(string)
+	if !validPhoneNumberID.MatchString(id) {
+		return ""
+	}
+	return "graph.facebook.com/" + id
+}
+
+func (s *Sender) Execute(ctx context.Context, config map[string]any, body []byte) error {
+	_, err := s.ExecuteWithResponse(ctx, config, body)
+	return err
+}
+
+// Config for the Meta Cloud API:
+//
+//	{"phone_number_id": "1234567890", "access_token": "{{secret:WHATSAPP_TOKEN}}",
+//	 "to": "{{customer.phone}}", "template": "order_shipped", "language": "en_US",
+//	 "parameters": ["{{customer.name}}", "{{order.id}}"], "header_parameters": ["{{order.id}}"]}
+//
+// and for Twilio:
+//
+//	{"provider": "twilio", "account_sid": "AC...", "auth_token": "{{secret:TWILIO_TOKEN}}",
+//	 "from": "+15005550006", "to": "{{customer.phone}}", "content_sid": "HX...",
+//	 "parameters": ["{{customer.name}}", "{{order.id}}"]}
+//
+// to and parameters are mapping templates filled from the payload, in the
+// order of the template's {{1}}, {{2}}... placeholders. Instead of a template
+// a "text" template sends a free-form message, which WhatsApp only delivers
+// inside an open conversation
+func (s *Sender) ExecuteWithResponse(ctx context.Context, config map[string]any, body []byte) (string, error) {
+	msg, err := parseMessage(config, body)
+	if err != nil {
+		return "", err
+	}
+	switch provider(config) {
+	case "meta":
+		return s.sendMeta(ctx, config, msg)
+	case "twilio":
+		return s.sendTwilio(ctx, config, msg)
+	}
+	return "", fmt.Errorf("unknown provider %q in whatsapp action config", config["provider"])
+}
+
+func provider(config map[string]any) string {
+	if p, _ := config["provider"].(string); p != "" {
+		return p
+	}
+	return "meta"
+}
+
+type message struct {
+	To         string
+	Text       string
+	Parameters []string
+	Header     []string
+}
+
+func parseMessage(config map[string]any, body []byte) (*message, error) {
+	msg := &message{}
+	tmpl, _ := config["to"].(string)
+	to, err := mapping.Render(tmpl, body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("to: %w", err)
+	}
+	// Twilio numbers carry a channel prefix, Meta wants bare digits
+	msg.To = strings.TrimPrefix(strings.TrimSpace(to), "whatsapp:")
+	if msg.To == "" {
+		return nil, fmt.Errorf("missing to in whatsapp action config")
+	}
+	if text, _ := config["text"].(string); text != "" {
+		if msg.Text, err = mapping.Render(text, body, nil); err != nil {
+			return nil, fmt.Errorf("text: %w", err)
+		}
+		msg.Text = payload.TruncateString(msg.Text, maxTextBytes)
+	}
+	if msg.Parameters, err = renderList(config, "parameters", body); err != nil {
+		return nil, err
+	}
+	if msg.Header, err = renderList(config, "header_parameters", body); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func renderList(config map[string]any, key string, body []byte) ([]string, error) {
+	raw, ok := config[key]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of templates", key)
+	}
+	out := make([]string, len(list))
+	for i, item := range list {
+		tmpl, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must list templates as strings", key)
+		}
+		rendered, err := mapping.Render(tmpl, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", key, i, err)
+		}
+		out[i] = rendered
+	}
+	return out, nil
+}
+
+func (s *Sender) sendMeta(ctx context.Context, config map[string]any, msg *message) (string, error) {
+	id, _ := config["phone_number_id"].(string)
+	if !validPhoneNumberID.MatchString(id) {
+		return "", fmt.Errorf("missing or invalid phone_number_id in whatsapp action config")
+	}
+	token, _ := config["access_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing access_token in whatsapp action config")
+	}
+	version, _ := config["api_version"].(string)
+	if version == "" {
+		version = defaultVersion
+	}
+	if !validVersion.MatchString(version) {
+		return "", fmt.Errorf("invalid api_version in whatsapp action config")
+	}
+	req := map[string]any{
+		"messaging_product": "whatsapp",
+		"recipient_type":    "individual",
+		"to":                strings.TrimPrefix(msg.To, "+"),
+	}
+	name, _ := config["template"].(string)
+	switch {
+	case name != "":
+		language, _ := config["language"].(string)
+		if language == "" {
+			language = "en_US"
+		}
+		template := map[string]any{"name": name, "language": map[string]string{"code": language}}
+		var components []map[string]any
+		if len(msg.Header) > 0 {
+			components = append(components, map[string]any{"type": "header", "parameters": textParameters(msg.Header)})
+		}
+		if len(msg.Parameters) > 0 {
+			components = append(components, map[string]any{"type": "body", "parameters": textParameters(msg.Parameters)})
+		}
+		if len(components) > 0 {
+			template["components"] = components
+		}
+		req["type"] = "template"
+		req["template"] = template
+	case msg.Text != "":
+		req["type"] = "text"
+		req["text"] = map[string]string{"body": msg.Text}
+	default:
+		return "", fmt.Errorf("whatsapp action config needs template or text")
+	}
+
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal whatsapp request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.metaURL+"/"+version+"/"+id+"/messages", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+		Error struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		if len(result.Messages) > 0 {
+			return fmt.Sprintf("%d: message %s", resp.StatusCode, result.Messages[0].ID), nil
+		}
+		return fmt.Sprintf("%d: %s", resp.StatusCode, data), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("whatsapp returned %d: %d %s", resp.StatusCode, result.Error.Code, result.Error.Message),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 || metaRateLimitCodes[result.Error.Code] {
+		if statusErr.StatusCode < 500 {
+			// Meta reports some throttling as 400, count it as the 429 it is
+			statusErr.StatusCode = http.StatusTooManyRequests
+		}
+		return "", statusErr
+	}
+	// Unapproved templates, parameter mismatches and bad tokens fail the same way every time
+	return "", &engine.PermanentError{Err: statusErr}
+}
+
+func textParameters(values []string) []map[string]string {
+	out := make([]map[string]string, len(values))
+	for i, value := range values {
+		out[i] = map[string]string{"type": "text", "text": value}
+	}
+	return out
+}
+
+func (s *Sender) sendTwilio(ctx context.Context, config map[string]any, msg *message) (string, error) {
+	sid, _ := config["account_sid"].(string)
+	if !validSID.MatchString(sid) {
+		return "", fmt.Errorf("missing or invalid account_sid in whatsapp action config")
+	}
+	token, _ := config["auth_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("missing auth_token in whatsapp action config")
+	}
+	from, _ := config["from"].(string)
+	service, _ := config["messaging_service_sid"].(string)
+	if from == "" && service == "" {
+		return "", fmt.Errorf("whatsapp action config needs from or messaging_service_sid")
+	}
+	form := url.Values{"To": {"whatsapp:" + msg.To}}
+	if service != "" {
+		form.Set("MessagingServiceSid", service)
+	} else {
+		form.Set("From", "whatsapp:"+strings.TrimPrefix(from, "whatsapp:"))
+	}
+	contentSID, _ := config["content_sid"].(string)
+	switch {
+	case contentSID != "":
+		if !validContentSID.MatchString(contentSID) {
+			return "", fmt.Errorf("invalid content_sid in whatsapp action config")
+		}
+		form.Set("ContentSid", contentSID)
+		if len(msg.Parameters) > 0 {
+			// Twilio numbers content variables from 1
+			variables := make(map[string]string, len(msg.Parameters))
+			for i, value := range msg.Parameters {
+				variables[strconv.Itoa(i+1)] = value
+			}
+			encoded, _ := json.Marshal(variables)
+			form.Set("ContentVariables", string(encoded))
+		}
+	case msg.Text != "":
+		form.Set("Body", msg.Text)
+	default:
+		return "", fmt.Errorf("whatsapp action config needs content_sid or text")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.twilioURL+"/2010-04-01/Accounts/"+sid+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(sid, token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	if resp.StatusCode < 300 {
+		return fmt.Sprintf("%d: message %s", resp.StatusCode, result.SID), nil
+	}
+	statusErr := &engine.StatusError{
+		StatusCode: resp.StatusCode,
+		Msg:        fmt.Sprintf("twilio returned %d: %d %s", resp.StatusCode, result.Code, result.Message),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", statusErr
+	}
+	return "", &engine.PermanentError{Err: statusErr}
+}
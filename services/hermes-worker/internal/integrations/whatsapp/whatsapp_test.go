@@ -0,0 +1,116 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+const testSID = "AC00000000000000000000000000000000"
+
+func TestSendsMetaTemplateWithParameters(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v21.0/1234/messages" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"messages": [{"id": "wamid.1"}]}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	s.metaURL = srv.URL
+	config := map[string]any{"phone_number_id": "1234", "access_token": "token", "to": "{{customer.phone}}",
+		"template": "order_shipped", "language": "de", "parameters": []any{"{{customer.name}}", "{{order.id}}"}}
+	payload := []byte(`{"customer": {"name": "Ada", "phone": "+4915112345678"}, "order": {"id": 42}}`)
+	response, err := s.ExecuteWithResponse(context.Background(), config, payload)
+	if err != nil {
+		t.Fatalf("Expected the send to succeed, got %v", err)
+	}
+	if !strings.Contains(response, "wamid.1") {
+		t.Errorf("Expected the message id in the response, got %q", response)
+	}
+	if got["to"] != "4915112345678" || got["type"] != "template" {
+		t.Errorf("Unexpected request %v", got)
+	}
+	encoded, _ := json.Marshal(got["template"])
+	want := `{"components":[{"parameters":[{"text":"Ada","type":"text"},{"text":"42","type":"text"}],"type":"body"}],"language":{"code":"de"},"name":"order_shipped"}`
+	if string(encoded) != want {
+		t.Errorf("Expected template %s, got %s", want, encoded)
+	}
+}
+
+func TestClassifiesMetaErrors(t *testing.T) {
+	code := 132001
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"message": "failed", "code": code}})
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	s.metaURL = srv.URL
+	config := map[string]any{"phone_number_id": "1234", "access_token": "token", "to": "+1555", "template": "missing"}
+	_, err := s.ExecuteWithResponse(context.Background(), config, nil)
+	var p *engine.PermanentError
+	if !errors.As(err, &p) {
+		t.Errorf("Expected an unknown template to fail permanently, got %v", err)
+	}
+
+	code = 131056
+	_, err = s.ExecuteWithResponse(context.Background(), config, nil)
+	var statusErr *engine.StatusError
+	if errors.As(err, &p) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a pair rate limit to be retryable, got %v", err)
+	}
+}
+
+func TestSendsTwilioContentTemplate(t *testing.T) {
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != testSID || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		form = map[string]string{}
+		for key := range r.Form {
+			form[key] = r.Form.Get(key)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM1"}`))
+	}))
+	defer srv.Close()
+
+	s := New(&engine.Egress{AllowPrivate: true})
+	s.twilioURL = srv.URL
+	config := map[string]any{"provider": "twilio", "account_sid": testSID, "auth_token": "token",
+		"from": "+15005550006", "to": "{{phone}}", "content_sid": "HX00000000000000000000000000000000",
+		"parameters": []any{"{{name}}"}}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, []byte(`{"phone": "+15551111111", "name": "Ada"}`)); err != nil {
+		t.Fatalf("Expected the send to succeed, got %v", err)
+	}
+	if form["To"] != "whatsapp:+15551111111" || form["From"] != "whatsapp:+15005550006" {
+		t.Errorf("Expected whatsapp: addresses, got %v", form)
+	}
+	if form["ContentVariables"] != `{"1":"Ada"}` {
+		t.Errorf("Expected numbered content variables, got %q", form["ContentVariables"])
+	}
+}
+
+func TestRejectsInvalidPhoneNumberID(t *testing.T) {
+	s := New(nil)
+	config := map[string]any{"phone_number_id": "../me", "access_token": "t", "to": "+1", "template": "x"}
+	if _, err := s.ExecuteWithResponse(context.Background(), config, nil); err == nil {
+		t.Error("Expected an invalid phone_number_id to be rejected")
+	}
+}
@@ -0,0 +1,143 @@
+// Package plugins runs user uploaded WASM modules as actions.
+//
+// A plugin is a WASI command module. For every execution it is instantiated
+// fresh and receives {"config": {...}, "payload": <event>} on stdin. Exit code
+// 0 means success and stdout is kept as the step response; any other exit
+// code fails the action with stderr as the error. The event's trace context is
+// in the TRACEPARENT environment variable. Modules get no filesystem
+// or network access, and run under the plugin's memory and time limits
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// Output kept from a single run, the rest is discarded
+	maxOutputBytes = 64 * 1024
+	wasmPageBytes  = 64 * 1024
+)
+
+// Runtime limits for one plugin
+type Limits struct {
+	MemoryLimitMB int
+	Timeout       time.Duration
+}
+
+// Runs one compiled plugin. Safe for concurrent use, each Execute gets its own instance
+type Executor struct {
+	name     string
+	limits   Limits
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// Compiles module under the given limits. Close releases the runtime
+func NewExecutor(ctx context.Context, name string, module []byte, limits Limits) (*Executor, error) {
+	pages := uint32(limits.MemoryLimitMB * 1024 * 1024 / wasmPageBytes)
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("instantiate wasi: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, module)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("compile plugin %s: %w", name, err)
+	}
+	return &Executor{name: name, limits: limits, runtime: runtime, compiled: compiled}, nil
+}
+
+func (e *Executor) Execute(ctx context.Context, config map[string]any, payload []byte) error {
+	_, err := e.ExecuteWithResponse(ctx, config, payload)
+	return err
+}
+
+func (e *Executor) ExecuteWithResponse(ctx context.Context, config map[string]any, payload []byte) (string, error) {
+	if len(payload) == 0 {
+		payload = []byte("null")
+	}
+	input, err := json.Marshal(struct {
+		Config  map[string]any  `json:"config"`
+		Payload json.RawMessage `json:"payload"`
+	}{config, payload})
+	if err != nil {
+		return "", fmt.Errorf("plugin input: %w", err)
+	}
+
+	if e.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.limits.Timeout)
+		defer cancel()
+	}
+	stdout := &cappedBuffer{limit: maxOutputBytes}
+	stderr := &cappedBuffer{limit: maxOutputBytes}
+	// An empty name lets instances of the same module run side by side
+	modConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(e.name).
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(stdout).
+		WithStderr(stderr)
+	if traceparent, ok := tracing.Outgoing(ctx); ok {
+		modConfig = modConfig.WithEnv("TRACEPARENT", traceparent)
+	}
+
+	mod, err := e.runtime.InstantiateModule(ctx, e.compiled, modConfig)
+	if mod != nil {
+		defer mod.Close(ctx)
+	}
+	response := strings.TrimSpace(stdout.String())
+	if err == nil {
+		return response, nil
+	}
+	var exitErr *sys.ExitError
+	if !errors.As(err, &exitErr) {
+		return response, fmt.Errorf("plugin %s: %w", e.name, err)
+	}
+	switch exitErr.ExitCode() {
+	case 0:
+		return response, nil
+	case sys.ExitCodeDeadlineExceeded:
+		return response, fmt.Errorf("plugin %s timed out after %s", e.name, e.limits.Timeout)
+	case sys.ExitCodeContextCanceled:
+		return response, fmt.Errorf("plugin %s: %w", e.name, context.Canceled)
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		msg = "no error output"
+	}
+	return response, fmt.Errorf("plugin %s exited with code %d: %s", e.name, exitErr.ExitCode(), msg)
+}
+
+// Releases the runtime. Runs still in progress are stopped
+func (e *Executor) Close(ctx context.Context) error {
+	return e.runtime.Close(ctx)
+}
+
+// Keeps the first limit bytes written and silently drops the rest, so a noisy
+// plugin can't grow worker memory
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
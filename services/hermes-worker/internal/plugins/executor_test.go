@@ -0,0 +1,106 @@
+package plugins
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Hand assembled WASI module whose _start runs body. Function 0 is the
+// imported proc_exit(i32), function 1 is _start
+func wasiModule(body ...byte) []byte { return wasiModuleWithMemory(1, body...) }
+
+func wasiModuleWithMemory(pages byte, body ...byte) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	name := func(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+
+	var imports []byte
+	imports = append(imports, 0x01)
+	imports = append(imports, name("wasi_snapshot_preview1")...)
+	imports = append(imports, name("proc_exit")...)
+	imports = append(imports, 0x00, 0x00)
+
+	var exports []byte
+	exports = append(exports, 0x02)
+	exports = append(exports, name("memory")...)
+	exports = append(exports, 0x02, 0x00)
+	exports = append(exports, name("_start")...)
+	exports = append(exports, 0x00, 0x01)
+
+	code := append([]byte{0x00}, body...)
+	code = append(code, 0x0b)
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(0x01, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00)...)
+	module = append(module, section(0x02, imports...)...)
+	module = append(module, section(0x03, 0x01, 0x01)...)
+	module = append(module, section(0x05, 0x01, 0x00, pages)...)
+	module = append(module, section(0x07, exports...)...)
+	module = append(module, section(0x0a, append([]byte{0x01, byte(len(code))}, code...)...)...)
+	return module
+}
+
+// i32.const code; call proc_exit
+func exitWith(code byte) []byte { return wasiModule(0x41, code, 0x10, 0x00) }
+
+func newTestExecutor(t *testing.T, module []byte, timeout time.Duration) *Executor {
+	t.Helper()
+	exec, err := NewExecutor(context.Background(), "test", module, Limits{MemoryLimitMB: 1, Timeout: timeout})
+	if err != nil {
+		t.Fatalf("NewExecutor failed: %v", err)
+	}
+	t.Cleanup(func() { exec.Close(context.Background()) })
+	return exec
+}
+
+func TestExecutorSucceedsOnExitZero(t *testing.T) {
+	exec := newTestExecutor(t, exitWith(0), time.Second)
+	if err := exec.Execute(context.Background(), map[string]any{"k": "v"}, []byte(`{"a":1}`)); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	// A module that returns from _start without exiting also succeeds
+	exec = newTestExecutor(t, wasiModule(), time.Second)
+	if err := exec.Execute(context.Background(), nil, nil); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+}
+
+func TestExecutorFailsOnNonZeroExit(t *testing.T) {
+	exec := newTestExecutor(t, exitWith(3), time.Second)
+	err := exec.Execute(context.Background(), nil, []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "exited with code 3") {
+		t.Errorf("Expected exit code 3 error, got %v", err)
+	}
+}
+
+func TestExecutorEnforcesTimeout(t *testing.T) {
+	// loop; br 0; end
+	exec := newTestExecutor(t, wasiModule(0x03, 0x40, 0x0c, 0x00, 0x0b), 50*time.Millisecond)
+	start := time.Now()
+	err := exec.Execute(context.Background(), nil, []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the run to stop near its timeout, took %s", elapsed)
+	}
+}
+
+func TestExecutorRejectsModuleOverMemoryLimit(t *testing.T) {
+	// Declares 64 pages (4MB) of memory against a 1MB limit
+	module := wasiModuleWithMemory(0x40)
+	if _, err := NewExecutor(context.Background(), "test", module, Limits{MemoryLimitMB: 1}); err == nil {
+		t.Error("Expected a module over the memory limit to be rejected")
+	}
+}
+
+func TestCappedBufferDropsOverflow(t *testing.T) {
+	b := &cappedBuffer{limit: 4}
+	n, _ := b.Write([]byte("abcdef"))
+	if n != 6 || b.String() != "abcd" {
+		t.Errorf("Expected 6 bytes accepted and abcd kept, got %d and %q", n, b.String())
+	}
+}
@@ -0,0 +1,143 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+// Action types of plugins are this prefix plus the plugin name
+const ActionPrefix = "plugin:"
+
+// Where plugin modules are read from, the worker store in production
+type Source interface {
+	ListPlugins(ctx context.Context) ([]store.PluginInfo, error)
+	GetPluginModule(ctx context.Context, name string) ([]byte, error)
+}
+
+type loadedPlugin struct {
+	sha256   string
+	limits   Limits
+	executor *Executor
+}
+
+// Keeps the registry in line with the uploaded plugins: new and changed
+// modules are compiled and registered, deleted ones are unregistered
+type Manager struct {
+	source   Source
+	registry *engine.Registry
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	loaded map[string]loadedPlugin
+}
+
+func NewManager(source Source, registry *engine.Registry, logger *slog.Logger) *Manager {
+	return &Manager{
+		source:   source,
+		registry: registry,
+		logger:   logger,
+		loaded:   make(map[string]loadedPlugin),
+	}
+}
+
+// Loads plugins added or changed since the last sync and drops deleted ones.
+// A module that fails to compile is skipped and retried on the next sync
+func (m *Manager) Sync(ctx context.Context) error {
+	plugins, err := m.source.ListPlugins(ctx)
+	if err != nil {
+		return fmt.Errorf("list plugins: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool, len(plugins))
+	for _, p := range plugins {
+		seen[p.Name] = true
+		if current, ok := m.loaded[p.Name]; ok && current.sha256 == p.SHA256 && current.limits == limitsOf(p) {
+			continue
+		}
+		if err := m.load(ctx, p); err != nil {
+			m.logger.Error("failed to load plugin", slog.String("plugin", p.Name),
+				slog.String("error", err.Error()))
+		}
+	}
+	for name, current := range m.loaded {
+		if !seen[name] {
+			m.registry.Unregister(ActionPrefix + name)
+			delete(m.loaded, name)
+			m.retire(current.executor)
+			m.logger.Info("plugin unloaded", slog.String("plugin", name))
+		}
+	}
+	return nil
+}
+
+func (m *Manager) load(ctx context.Context, p store.PluginInfo) error {
+	module, err := m.source.GetPluginModule(ctx, p.Name)
+	if err != nil {
+		return err
+	}
+	limits := limitsOf(p)
+	executor, err := NewExecutor(ctx, p.Name, module, limits)
+	if err != nil {
+		return err
+	}
+	m.registry.Register(ActionPrefix+p.Name, executor)
+	if previous, ok := m.loaded[p.Name]; ok {
+		m.retire(previous.executor)
+	}
+	m.loaded[p.Name] = loadedPlugin{sha256: p.SHA256, limits: limits, executor: executor}
+	m.logger.Info("plugin loaded", slog.String("plugin", p.Name),
+		slog.String("sha256", p.SHA256),
+		slog.Int("memory_limit_mb", p.MemoryLimitMB),
+		slog.Int("timeout_ms", p.TimeoutMs))
+	return nil
+}
+
+func limitsOf(p store.PluginInfo) Limits {
+	return Limits{
+		MemoryLimitMB: p.MemoryLimitMB,
+		Timeout:       time.Duration(p.TimeoutMs) * time.Millisecond,
+	}
+}
+
+// Closes a replaced executor once runs that already fetched it have had
+// their full timeout to finish
+func (m *Manager) retire(executor *Executor) {
+	time.AfterFunc(executor.limits.Timeout, func() {
+		executor.Close(context.Background())
+	})
+}
+
+// Syncs every interval until ctx is done, then closes all plugins
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.closeAll()
+			return
+		case <-ticker.C:
+			if err := m.Sync(ctx); err != nil {
+				m.logger.Warn("plugin sync failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (m *Manager) closeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, current := range m.loaded {
+		m.registry.Unregister(ActionPrefix + name)
+		current.executor.Close(context.Background())
+		delete(m.loaded, name)
+	}
+}
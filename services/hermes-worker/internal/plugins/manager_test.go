@@ -0,0 +1,55 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
+)
+
+type fakeSource struct {
+	plugins []store.PluginInfo
+	fetches int
+}
+
+func (f *fakeSource) ListPlugins(ctx context.Context) ([]store.PluginInfo, error) {
+	return f.plugins, nil
+}
+
+func (f *fakeSource) GetPluginModule(ctx context.Context, name string) ([]byte, error) {
+	f.fetches++
+	return exitWith(0), nil
+}
+
+func TestManagerReloadsWhenLimitsChange(t *testing.T) {
+	source := &fakeSource{plugins: []store.PluginInfo{{Name: "p", SHA256: "abc", MemoryLimitMB: 1, TimeoutMs: 1000}}}
+	registry := engine.NewRegistry()
+	m := NewManager(source, registry, logger.New("hermes-worker-test", "test", "error"))
+	ctx := context.Background()
+
+	for range 2 {
+		if err := m.Sync(ctx); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+	}
+	if source.fetches != 1 {
+		t.Fatalf("Expected an unchanged plugin to load once, got %d loads", source.fetches)
+	}
+
+	source.plugins[0].TimeoutMs = 2000
+	if err := m.Sync(ctx); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if source.fetches != 2 {
+		t.Errorf("Expected a timeout change to reload the plugin, got %d loads", source.fetches)
+	}
+	executor, err := registry.Get(ActionPrefix + "p")
+	if err != nil {
+		t.Fatalf("Expected the plugin to be registered: %v", err)
+	}
+	if got := executor.(*Executor).limits.Timeout.Milliseconds(); got != 2000 {
+		t.Errorf("Expected the new 2000ms timeout, got %dms", got)
+	}
+}
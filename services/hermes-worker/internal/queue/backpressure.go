@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+// How often a paused consumer checks whether the queue has drained
+const backpressurePoll = 50 * time.Millisecond
+
+// Percentage of the job queue in use
+func saturation(queues engine.JobQueues) int {
+	if queues.Cap() == 0 {
+		return 0
+	}
+	return queues.Len() * 100 / queues.Cap()
+}
+
+// Holds the subscription while the job queue is at PauseAtPercent or more,
+// until it drains to ResumeAtPercent. The broker doesn't hand the consumer the
+// next message until this returns, so consumption pauses instead of messages
+// piling up at a full lane and being handed back. Returns false when the
+// consumer stops first
+func (c *Consumer) waitForRoom(msg broker.Delivery) bool {
+	if c.PauseAtPercent <= 0 || saturation(c.queues) < c.PauseAtPercent {
+		return true
+	}
+	start := time.Now()
+	c.Metrics.ConsumerPaused()
+	c.logger.Warn("job queue nearly full, pausing consumption",
+		slog.Int("queued", c.queues.Len()),
+		slog.Int("capacity", c.queues.Cap()))
+
+	poll := time.NewTicker(backpressurePoll)
+	defer poll.Stop()
+	touch := time.NewTicker(10 * time.Second)
+	defer touch.Stop()
+	for saturation(c.queues) > c.resumeAt() {
+		select {
+		case <-c.done:
+			c.Metrics.ConsumerResumed(time.Since(start))
+			return false
+		case <-touch.C:
+			msg.InProgress()
+		case <-poll.C:
+		}
+	}
+	paused := time.Since(start)
+	c.Metrics.ConsumerResumed(paused)
+	c.logger.Info("job queue drained, resuming consumption", slog.Duration("paused", paused))
+	return true
+}
+
+func (c *Consumer) resumeAt() int {
+	if c.ResumeAtPercent <= 0 || c.ResumeAtPercent >= c.PauseAtPercent {
+		return c.PauseAtPercent * 3 / 4
+	}
+	return c.ResumeAtPercent
+}
@@ -1,113 +1,383 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/broker"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logfields"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/payload"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/tracing"
 	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
-	"github.com/nats-io/nats.go"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/store"
 )
 
+const (
+	// How long a delivery waits for payload budget before it is handed back
+	budgetWait       = 5 * time.Second
+	budgetRetryDelay = 5 * time.Second
+	// How long a delivery waits for room in a full normal/low lane before it
+	// is handed back, so the subscription isn't held up for long
+	laneWait       = 2 * time.Second
+	laneRetryDelay = 5 * time.Second
+	// Bytes of a rejected message kept in its dead letter
+	rejectedPreviewBytes = 16 * 1024
+	// How long a message may go without being settled or touched before the
+	// broker hands it to another worker
+	ackWait = 30 * time.Second
+)
+
+// Records messages the consumer gives up on before they become jobs.
+// Store.SaveDeadLetter fits
+type DeadLetterSink func(ctx context.Context, relayID, eventID, traceparent, reason string, attempts int, payload []byte) error
+
+// Subject hermes-core announces cancelled executions on
+const cancelSubject = "hermes.cancel"
+
 type Consumer struct {
-	js       nats.JetStream
-	sub      *nats.Subscription
-	jobQueue chan engine.Job
-	logger   *slog.Logger
+	broker   broker.Broker
+	sub      broker.Subscription
+	queues   engine.JobQueues
+	settings *engine.SettingsResolver
+	done     chan struct{}
+	// Cancelled by Stop so callbacks waiting on the payload budget give up
+	ctx        context.Context
+	cancel     context.CancelFunc
+	logger     *slog.Logger
+	maxPayload int
+	maxDeliver int
+	inflight   *payload.Budget
+	// Where oversized and unparseable messages are recorded. Nil only logs them
+	DeadLetters DeadLetterSink
+	// Consumption pauses once the job queue is this full, in percent, and
+	// resumes at ResumeAtPercent. Zero never pauses
+	PauseAtPercent  int
+	ResumeAtPercent int
+	// Where pauses for backpressure are counted. Nil records nothing;
+	// queue depth and saturation are read from the pool at scrape time
+	Metrics *engine.Metrics
+
+	cancelSub broker.Subscription
+}
+
+// Envelope published by hermes-hooks. The extra fields are only set on
+// events the worker republishes for itself
+type event struct {
+	EventID     string          `json:"event_id"`
+	RelayID     string          `json:"relay_id"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ResumeAfter *int            `json:"resume_after,omitempty"`
+	Released    bool            `json:"released,omitempty"`
+	Traceparent string          `json:"traceparent,omitempty"`
+	// Attempts used by earlier copies of a republished event
+	PriorAttempts int `json:"prior_attempts,omitempty"`
+	// Relays that called this one through call_relay
+	CallChain []string `json:"call_chain,omitempty"`
 }
 
 // Constructor pattern
-// Initializes the NATS connection but doesnt start consuming right off
-func NewConsumer(url string, jobQueue chan engine.Job, logger *slog.Logger) (*Consumer, error) {
-	nc, err := nats.Connect(
-		url,
-		nats.MaxReconnects(10),
-		nats.ReconnectWait(2*time.Second),
-		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
-			logger.Warn("NATS disconnected", slog.String("error", err.Error()))
-		}),
-		nats.ReconnectHandler(func(nc *nats.Conn) {
-			logger.Info("NATS reconnected")
-		}),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("nats connect error: %w", err)
-	}
-	js, err := nc.JetStream()
-	if err != nil {
-		return nil, fmt.Errorf("jetsream init error: %w", err)
-	}
-	logger.Info("connected to NATS JetStream")
+// Takes the broker events come from but doesnt start consuming right off.
+// inflight bounds the payload bytes held across the job queue and busy workers
+// maxDeliver is how many times a message is attempted before it is dead-lettered
+// settings routes each relay's jobs to its priority lane
+func NewConsumer(b broker.Broker, queues engine.JobQueues, settings *engine.SettingsResolver, maxPayload, maxDeliver int, inflight *payload.Budget, logger *slog.Logger) *Consumer {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Consumer{
-		js:       js,
-		jobQueue: jobQueue,
-		logger:   logger,
-	}, nil
+		ctx:        ctx,
+		cancel:     cancel,
+		broker:     b,
+		queues:     queues,
+		settings:   settings,
+		done:       make(chan struct{}),
+		logger:     logger,
+		maxPayload: maxPayload,
+		maxDeliver: maxDeliver,
+		inflight:   inflight,
+	}
 }
 
-// Consumes the messages by subscribing to NATS and processing messages async
+// Consumes the event stream, handing messages to the job queue as they arrive
 func (c *Consumer) Start() error {
-	c.logger.Info("starting NATS consumer",
-		slog.String("subject", "events.>"),
-		slog.String("consumer", "WORKER_CONSUMER"))
-	sub, err := c.js.Subscribe("events.>",
-		c.handleMessage,
-		nats.Durable("WORKER_CONSUMER"),
-		nats.ManualAck(),
-		nats.AckWait(30*time.Second))
+	c.logger.Info("starting consumer")
+	sub, err := c.broker.Consume(broker.ConsumeOptions{MaxDeliver: c.maxDeliver, AckWait: ackWait}, c.handleMessage)
 	if err != nil {
-		return fmt.Errorf("subscription failed: %w", err)
+		return err
 	}
 	c.sub = sub
 	c.logger.Info("Worker consumer started, listening for events...")
 	return nil
 }
 
-func (c *Consumer) handleMessage(msg *nats.Msg) {
-	type Event struct {
-		EventID    string          `json:"event_id"`
-		RelayID    string          `json:"relay_id"`
-		Payload    json.RawMessage `json:"payload"`
-		ReceivedAt string          `json:"received_at"`
+func (c *Consumer) handleMessage(msg broker.Delivery) {
+	select {
+	case <-c.done:
+		// Shutting down, leave it for another worker
+		msg.Nak(0)
+		return
+	default:
+	}
+	if !c.waitForRoom(msg) {
+		msg.Nak(0)
+		return
+	}
+	data := msg.Data()
+	if len(data) > c.maxPayload {
+		payload.Metrics.Add("worker_rejected_too_large", 1)
+		c.logger.Error("message exceeds max payload size, dropping",
+			logfields.RelayID(msg.RelayID()),
+			slog.Int("size", len(data)),
+			slog.Int("max_bytes", c.maxPayload))
+		// Redelivery can't make it smaller, so stop the broker retrying it
+		c.reject(msg, fmt.Sprintf("message of %d bytes exceeds MAX_PAYLOAD_BYTES (%d)", len(data), c.maxPayload))
+		return
 	}
-	var evt Event
-	if err := json.Unmarshal(msg.Data, &evt); err != nil {
+	size := int64(len(data))
+	// Waits for enough payload memory to be free, which also throttles consumption.
+	// The wait is bounded so the subscription can't stall past AckWait
+	acquireCtx, cancel := context.WithTimeout(c.ctx, budgetWait)
+	err := c.inflight.Acquire(acquireCtx, size)
+	cancel()
+	if err != nil {
+		payload.Metrics.Add("worker_budget_timeout", 1)
+		c.logger.Warn("payload budget exhausted, retrying message later",
+			logfields.RelayID(msg.RelayID()),
+			slog.Int("size", len(data)),
+			slog.String("error", err.Error()))
+		msg.Nak(budgetRetryDelay)
+		return
+	}
+	var evt event
+	if err := json.Unmarshal(data, &evt); err != nil {
 		c.logger.Error("failed to parse message",
 			slog.String("error", err.Error()))
-		msg.Nak()
+		c.inflight.Release(size)
+		// Won't parse on redelivery either
+		c.reject(msg, "message is not a valid event: "+err.Error())
 		return
 	}
-	c.logger.Debug("received event",
-		slog.String("relay_id", evt.RelayID),
-		slog.String("event_id", evt.EventID),
-		slog.Int("payload_size", len(evt.Payload)))
-	// Bridges NATS consumer to Worker Pool
+	trace := eventTrace(evt.Traceparent, msg)
+	logger := c.logger.With(logfields.Event(evt.RelayID, evt.EventID, trace.TraceID)...)
+	logger.Debug("received event", slog.Int("payload_size", len(evt.Payload)))
+	attempt := msg.Attempt() + evt.PriorAttempts
+	// Bridges the broker to Worker Pool
 	job := engine.Job{
-		RelayID: evt.RelayID,
-		EventID: evt.EventID,
-		Payload: evt.Payload,
+		RelayID:     evt.RelayID,
+		EventID:     evt.EventID,
+		Payload:     evt.Payload,
+		Attempt:     attempt,
+		MaxAttempts: c.maxDeliver,
+		ResumeAfter: evt.ResumeAfter,
+		Released:    evt.Released,
+		Trace:       trace,
+		CallChain:   evt.CallChain,
+		Touch:       msg.InProgress,
+		Defer: func(delay time.Duration) {
+			defer c.inflight.Release(size)
+			msg.Nak(delay)
+			logger.Info("deferred message", slog.Duration("delay", delay))
+		},
 		MsgAck: func(success bool) {
+			defer c.inflight.Release(size)
 			if success {
 				msg.Ack()
-				c.logger.Debug("acknowledged message", slog.String("relay_id", evt.RelayID),
-					slog.String("event_id", evt.EventID))
+				logger.Debug("acknowledged message")
 			} else {
-				msg.Nak()
-				c.logger.Warn("nacked message (will retry)", slog.String("relay_id", evt.RelayID),
-					slog.String("event_id", evt.EventID))
+				msg.Nak(0)
+				logger.Warn("nacked message (will retry)")
 			}
 		},
 	}
-	//Blocking send to channel - If the worker is full this will wait
-	c.jobQueue <- job
+	settings := store.RelaySettings{Priority: engine.PriorityNormal}
+	if c.settings != nil {
+		settings = c.settings.Get(evt.RelayID)
+	}
+	lane := c.queues.For(settings.Priority)
+	select {
+	case lane <- job:
+		return
+	default:
+	}
+	// Ordered relays must not be overtaken by a later message, so they wait in line too
+	if settings.Priority == engine.PriorityHigh || settings.MaxConcurrency == 1 {
+		//Blocking send to channel - If the worker is full this will wait
+		c.enqueue(lane, job, nil)
+		return
+	}
+	// Don't let a full normal/low lane hold up the subscription for long, or
+	// high priority messages behind it would wait too. Past laneWait the
+	// message goes back to the broker rather than queueing up in memory
+	timeout := time.NewTimer(laneWait)
+	defer timeout.Stop()
+	if !c.enqueue(lane, job, timeout.C) {
+		payload.Metrics.Add("worker_lane_overflow", 1)
+		logger.Warn("lane full, retrying message later",
+			slog.String("priority", settings.Priority))
+		job.Defer(laneRetryDelay)
+	}
+}
+
+// Blocks until the lane takes the job, keeping the message alive meanwhile.
+// Hands it back to the broker if the consumer stops first. Gives up when
+// timeout fires, leaving the job to the caller; a nil timeout waits for good
+func (c *Consumer) enqueue(lane chan engine.Job, job engine.Job, timeout <-chan time.Time) bool {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case lane <- job:
+			return true
+		case <-ticker.C:
+			job.Touch()
+		case <-timeout:
+			return false
+		case <-c.done:
+			job.Defer(0)
+			return true
+		}
+	}
 }
 
+// Terminates a message that can never run and dead-letters a preview of it,
+// falling back to logging the raw message when that isn't possible
+func (c *Consumer) reject(msg broker.Delivery, reason string) {
+	relayID := msg.RelayID()
+	var evt event
+	_ = json.Unmarshal(msg.Data(), &evt)
+	attempts := msg.Attempt()
+	preview := rawPreview(msg.Data(), rejectedPreviewBytes)
+	err := errors.New("no dead letter sink configured")
+	if c.DeadLetters != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = c.DeadLetters(ctx, relayID, evt.EventID, evt.Traceparent, reason, attempts, preview)
+		cancel()
+	}
+	if err != nil {
+		c.logger.Error("failed to dead-letter rejected message, dropping",
+			logfields.RelayID(relayID),
+			slog.String("reason", reason),
+			slog.String("message", string(preview)),
+			slog.String("error", err.Error()))
+	} else {
+		c.logger.Warn("rejected message moved to dead letter queue",
+			logfields.RelayID(relayID),
+			logfields.EventID(evt.EventID))
+	}
+	msg.Term()
+}
+
+// Wraps the start of a raw message as JSON so it fits the dead letter payload
+// column whether or not it parses
+func rawPreview(data []byte, max int) json.RawMessage {
+	preview, truncated := payload.Truncate(data, max)
+	body, err := json.Marshal(map[string]any{
+		"_raw":          true,
+		"_truncated":    truncated,
+		"original_size": len(data),
+		"preview":       string(preview),
+	})
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return body
+}
+
+var _ engine.Republisher = (*Consumer)(nil)
+
+// Publishes an event onto the relay's subject for the worker to pick up again
+func (c *Consumer) Republish(ev engine.RepublishedEvent) error {
+	data, err := json.Marshal(event{
+		EventID:       ev.EventID,
+		RelayID:       ev.RelayID,
+		Payload:       ev.Payload,
+		ReceivedAt:    time.Now(),
+		ResumeAfter:   ev.ResumeAfter,
+		Released:      ev.Released,
+		Traceparent:   ev.Traceparent,
+		PriorAttempts: ev.PriorAttempts,
+		CallChain:     ev.CallChain,
+	})
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.broker.Publish(ctx, ev.RelayID, "", ev.Traceparent, data)
+}
+
+func (c *Consumer) MaxBatchBytes() int {
+	return c.maxPayload
+}
+
+// Messages the broker has not yet delivered to this consumer, used as the lag
+// signal for pool autoscaling. Returns 0 when it can't be determined
+func (c *Consumer) Pending() int {
+	if c.sub == nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := c.broker.Pending(ctx)
+	if err != nil {
+		c.logger.Debug("failed to read consumer lag", slog.String("error", err.Error()))
+		return 0
+	}
+	return n
+}
+
+// Calls cancel for every execution hermes-core cancels. A broadcast rather
+// than the event stream: the announcement only matters to instances running
+// the event now
+func (c *Consumer) SubscribeCancellations(cancel func(relayID, eventID string)) error {
+	sub, err := c.broker.Subscribe(cancelSubject, "", func(data []byte) {
+		var req struct {
+			RelayID string `json:"relay_id"`
+			EventID string `json:"event_id"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil || req.RelayID == "" || req.EventID == "" {
+			c.logger.Warn("ignoring malformed cancellation", slog.String("data", string(data)))
+			return
+		}
+		cancel(req.RelayID, req.EventID)
+	})
+	if err != nil {
+		return err
+	}
+	c.cancelSub = sub
+	return nil
+}
+
+// Stops taking messages. Ones already queued can still be settled until Close
 func (c *Consumer) Stop() error {
-	c.logger.Info("stopping NATS consumer")
+	c.logger.Info("stopping consumer")
+	close(c.done)
+	c.cancel()
+	if c.cancelSub != nil {
+		_ = c.cancelSub.Stop()
+	}
 	if c.sub != nil {
-		// To process remaining messages and then close
-		return c.sub.Drain()
+		return c.sub.Stop()
 	}
 	return nil
 }
+
+// Disconnects from the broker, once the pool has settled its jobs
+func (c *Consumer) Close() error {
+	return c.broker.Close()
+}
+
+// The span that published the event, from the event or the message header.
+// Events without a trace (e.g. requeued dead letters) start a new one
+func eventTrace(traceparent string, msg broker.Delivery) tracing.Context {
+	if c, ok := tracing.Parse(traceparent); ok {
+		return c
+	}
+	if c, ok := tracing.Parse(msg.Traceparent()); ok {
+		return c
+	}
+	return tracing.New()
+}
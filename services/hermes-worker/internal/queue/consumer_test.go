@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/logger"
+	"github.com/eulerbutcooler/hermes/services/hermes-worker/internal/engine"
+)
+
+func TestRawPreviewIsAlwaysJSON(t *testing.T) {
+	for _, raw := range [][]byte{
+		[]byte(`{"not": "closed"`),
+		[]byte(`{"ok": true}`),
+		[]byte("\xff\xfe binary"),
+	} {
+		preview := rawPreview(raw, 8)
+		var decoded map[string]any
+		if err := json.Unmarshal(preview, &decoded); err != nil {
+			t.Fatalf("Expected valid JSON for %q, got %s: %v", raw, preview, err)
+		}
+		if decoded["original_size"] != float64(len(raw)) {
+			t.Errorf("Expected original_size %d, got %v", len(raw), decoded["original_size"])
+		}
+		if decoded["_truncated"] != (len(raw) > 8) {
+			t.Errorf("Unexpected _truncated for %q: %v", raw, decoded["_truncated"])
+		}
+	}
+}
+
+func TestEnqueueGivesUpOnTimeout(t *testing.T) {
+	c := &Consumer{done: make(chan struct{})}
+	lane := make(chan engine.Job)
+	deferred := false
+	job := engine.Job{Defer: func(time.Duration) { deferred = true }}
+
+	if c.enqueue(lane, job, time.After(10*time.Millisecond)) {
+		t.Fatal("Expected enqueue into a full lane to time out")
+	}
+	if deferred {
+		t.Error("Expected a timed out job to be left to the caller")
+	}
+
+	close(c.done)
+	if !c.enqueue(lane, job, nil) || !deferred {
+		t.Error("Expected shutdown to hand the job back to the broker")
+	}
+}
+
+func TestWaitForRoomPausesUntilDrained(t *testing.T) {
+	queues := engine.NewJobQueues(4)
+	c := &Consumer{done: make(chan struct{}), queues: queues, PauseAtPercent: 50, ResumeAtPercent: 25,
+		logger: logger.New("hermes-worker-test", "test", "error")}
+	if !c.waitForRoom(nil) {
+		t.Fatal("Expected an empty queue not to pause")
+	}
+	for range 4 {
+		queues.Normal <- engine.Job{}
+	}
+	queues.Low <- engine.Job{}
+	queues.Low <- engine.Job{}
+	resumed := make(chan bool, 1)
+	go func() { resumed <- c.waitForRoom(nil) }()
+	select {
+	case <-resumed:
+		t.Fatal("Expected a queue at half capacity to pause consumption")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-queues.Normal
+	<-queues.Normal
+	<-queues.Normal
+	select {
+	case ok := <-resumed:
+		if !ok {
+			t.Error("Expected consumption to resume")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected consumption to resume once the queue drained to 25%")
+	}
+
+	queues.Normal <- engine.Job{}
+	queues.Normal <- engine.Job{}
+	queues.Normal <- engine.Job{}
+	go func() { resumed <- c.waitForRoom(nil) }()
+	close(c.done)
+	if <-resumed {
+		t.Error("Expected shutdown to end the pause without taking the message")
+	}
+}
+
+type fakeDelivery struct {
+	relayID string
+	data    []byte
+	settled string
+}
+
+func (d *fakeDelivery) RelayID() string     { return d.relayID }
+func (d *fakeDelivery) Data() []byte        { return d.data }
+func (d *fakeDelivery) Traceparent() string { return "" }
+func (d *fakeDelivery) Attempt() int        { return 1 }
+func (d *fakeDelivery) Ack()                { d.settled = "ack" }
+func (d *fakeDelivery) Nak(time.Duration)   { d.settled = "nak" }
+func (d *fakeDelivery) InProgress()         {}
+func (d *fakeDelivery) Term()               { d.settled = "term" }
+
+func TestOversizedMessageIsDeadLettered(t *testing.T) {
+	var deadRelay string
+	c := &Consumer{done: make(chan struct{}), maxPayload: 8, logger: logger.New("hermes-worker-test", "test", "error"),
+		DeadLetters: func(_ context.Context, relayID, _, _, _ string, _ int, _ []byte) error {
+			deadRelay = relayID
+			return nil
+		}}
+	msg := &fakeDelivery{relayID: "relay-1", data: []byte(`{"event_id": "evt-1", "payload": {}}`)}
+	c.handleMessage(msg)
+	if msg.settled != "term" || deadRelay != "relay-1" {
+		t.Errorf("Expected the message terminated and dead-lettered, got %q and %q", msg.settled, deadRelay)
+	}
+}
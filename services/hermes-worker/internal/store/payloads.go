@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// How long a relay's workspace key is cached. A deleted key stops being
+	// used for new payloads within this
+	payloadKeyTTL = 5 * time.Minute
+	// Relays whose key is cached at once
+	maxCachedPayloadKeys = 4096
+)
+
+// The payload was sealed with a workspace key that has since been deleted
+var ErrPayloadKeyDeleted = errors.New("payload's workspace key has been deleted")
+
+// A workspace's data key, wrapped by the secrets key
+type payloadKey struct {
+	id       string
+	wrapped  []byte
+	cachedAt time.Time
+}
+
+// A payload as it is written: plain JSON, or sealed with the workspace's key
+type storedPayload struct {
+	plain  any
+	sealed []byte
+	key    any
+}
+
+// Opens sealed payloads with envelope, and seals the payloads written from now
+// on with their workspace's key when seal is set
+func (s *Store) UsePayloadKeys(envelope *secrets.Envelope, seal bool) {
+	s.payloads = envelope
+	s.sealPayloads = seal && envelope != nil
+}
+
+func (s *Store) sealPayload(ctx context.Context, relayID string, payload []byte) (storedPayload, error) {
+	if len(payload) == 0 {
+		return storedPayload{}, nil
+	}
+	if !s.sealPayloads {
+		return storedPayload{plain: json.RawMessage(payload)}, nil
+	}
+	key, err := s.workspaceKey(ctx, relayID)
+	if err != nil {
+		return storedPayload{}, err
+	}
+	sealed, err := s.payloads.SealWith(ctx, key.wrapped, payload)
+	if err != nil {
+		return storedPayload{}, fmt.Errorf("seal payload: %w", err)
+	}
+	return storedPayload{sealed: sealed, key: key.id}, nil
+}
+
+// Opens a payload read back as payload, payload_sealed and the wrapped key
+// payload_key names
+func (s *Store) openPayload(ctx context.Context, plain, sealed, dataKey []byte) ([]byte, error) {
+	if sealed == nil {
+		return plain, nil
+	}
+	if s.payloads == nil {
+		return nil, fmt.Errorf("open payload: %w", secrets.ErrNoKey)
+	}
+	if dataKey == nil {
+		return nil, ErrPayloadKeyDeleted
+	}
+	payload, err := s.payloads.OpenWith(ctx, dataKey, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("open payload: %w", err)
+	}
+	return payload, nil
+}
+
+// The key of the relay's workspace, created on its first sealed payload
+func (s *Store) workspaceKey(ctx context.Context, relayID string) (payloadKey, error) {
+	s.payloadKeysMu.Lock()
+	key, ok := s.payloadKeys[relayID]
+	s.payloadKeysMu.Unlock()
+	if ok && time.Since(key.cachedAt) < payloadKeyTTL {
+		return key, nil
+	}
+
+	query := `SELECT k.id::text, k.data_key FROM relays r
+	JOIN workspace_keys k ON k.user_id = r.user_id
+	WHERE r.id = $1`
+	err := s.db.QueryRow(ctx, query, relayID).Scan(&key.id, &key.wrapped)
+	if err == pgx.ErrNoRows {
+		wrapped, err := s.payloads.NewDataKey(ctx)
+		if err != nil {
+			return payloadKey{}, err
+		}
+		// Another worker may create it first, whichever key got in is used
+		if _, err := s.db.Exec(ctx, `INSERT INTO workspace_keys (user_id, data_key)
+		SELECT user_id, $2 FROM relays WHERE id = $1
+		ON CONFLICT (user_id) DO NOTHING`, relayID, wrapped); err != nil {
+			return payloadKey{}, fmt.Errorf("create workspace key: %w", err)
+		}
+		err = s.db.QueryRow(ctx, query, relayID).Scan(&key.id, &key.wrapped)
+		if err == pgx.ErrNoRows {
+			return payloadKey{}, ErrRelayNotFound
+		}
+	}
+	if err != nil {
+		return payloadKey{}, fmt.Errorf("query workspace key: %w", err)
+	}
+	key.cachedAt = time.Now()
+	s.payloadKeysMu.Lock()
+	if len(s.payloadKeys) >= maxCachedPayloadKeys {
+		clear(s.payloadKeys)
+	}
+	s.payloadKeys[relayID] = key
+	s.payloadKeysMu.Unlock()
+	return key, nil
+}
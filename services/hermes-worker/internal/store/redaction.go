@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/redact"
+)
+
+// Redaction rules covering a relay: its own, its workspace's and the global ones
+func (s *Store) RedactionRules(ctx context.Context, relayID string) ([]redact.Rule, error) {
+	rows, err := s.db.Query(ctx, `SELECT path, pattern, preset, replacement FROM redaction_rules
+	WHERE relay_id = $1
+	OR (relay_id IS NULL AND user_id = (SELECT user_id FROM relays WHERE id = $1))
+	OR user_id IS NULL
+	ORDER BY created_at`, relayID)
+	if err != nil {
+		return nil, fmt.Errorf("query redaction rules: %w", err)
+	}
+	defer rows.Close()
+	var rules []redact.Rule
+	for rows.Next() {
+		var rule redact.Rule
+		if err := rows.Scan(&rule.Path, &rule.Pattern, &rule.Preset, &rule.Replacement); err != nil {
+			return nil, fmt.Errorf("scan redaction rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return rules, nil
+}
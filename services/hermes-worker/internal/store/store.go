@@ -5,41 +5,100 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/dbpool"
+	"github.com/eulerbutcooler/hermes/packages/hermes-common/pkg/secrets"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type RelayAction struct {
+	ID         string
 	OrderIndex int
 	ActionType string
 	Config     map[string]any
+	// Set when the action must run on a self-hosted agent of this group
+	AgentGroup      string
+	AgentLabels     map[string]string
+	MinAgentVersion string
+	// Part of the failure branch rather than the main sequence
+	OnFailure bool
+	// Failures are recorded but don't stop the actions after it
+	Optional bool
 }
 
 type Store struct {
 	db *pgxpool.Pool
+	// Relay actions are read through this, from a replica when one is set
+	reads *dbpool.ReadRouter
+	// Opens payloads sealed at rest, and seals new ones when sealPayloads is set
+	payloads      *secrets.Envelope
+	sealPayloads  bool
+	payloadKeysMu sync.Mutex
+	// Workspace keys by relay ID
+	payloadKeys map[string]payloadKey
 }
 
 var (
 	ErrRelayNotFound = errors.New("relay not found")
 	ErrNoActions     = errors.New("no actions configured for relay")
+	// Also returned for connections of another user
+	ErrConnectionNotFound = errors.New("connection not found")
 )
 
-func NewStore(dbURL string) (*Store, error) {
-	pool, err := pgxpool.New(context.Background(), dbURL)
+// replicaURL may be empty, which keeps every read on dbURL
+func NewStore(dbURL, replicaURL string, opts dbpool.Options, logger *slog.Logger) (*Store, error) {
+	pool, err := dbpool.Open(dbURL, opts)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to connect to db: %w", err)
 	}
-	return &Store{db: pool}, nil
+	var replica *pgxpool.Pool
+	if replicaURL != "" {
+		if replica, err = dbpool.Open(replicaURL, opts); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("Unable to connect to read replica: %w", err)
+		}
+	}
+	return &Store{
+		db:          pool,
+		reads:       dbpool.NewReadRouter(pool, replica, logger),
+		payloadKeys: make(map[string]payloadKey),
+	}, nil
+}
+
+// The underlying pool, for schema migrations
+func (s *Store) Pool() *pgxpool.Pool {
+	return s.db
 }
 
 func (s *Store) GetRelayActions(ctx context.Context, relayID string) ([]RelayAction, error) {
-	query := `SELECT a.action_type, a.config, a.order_index
+	actions, err := relayActions(ctx, s.reads, relayID)
+	// A relay created moments ago may not have reached the replica yet
+	if errors.Is(err, ErrNoActions) && s.reads.Replicated() {
+		return relayActions(ctx, s.db, relayID)
+	}
+	return actions, err
+}
+
+// Either the primary pool or a ReadRouter
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func relayActions(ctx context.Context, db querier, relayID string) ([]RelayAction, error) {
+	query := `SELECT a.id, a.action_type, a.config, a.order_index, COALESCE(a.agent_group, ''),
+	a.agent_labels, COALESCE(a.min_agent_version, ''), a.on_failure, a.optional
 	FROM relays r
 	JOIN relay_actions a ON r.id=a.relay_id
 	WHERE r.id=$1 AND r.is_active=true
 	ORDER BY a.order_index ASC`
 
-	rows, err := s.db.Query(ctx, query, relayID)
+	rows, err := db.Query(ctx, query, relayID)
 	if err != nil {
 		return nil, fmt.Errorf("db error: %w", err)
 	}
@@ -49,7 +108,7 @@ func (s *Store) GetRelayActions(ctx context.Context, relayID string) ([]RelayAct
 	for rows.Next() {
 		var act RelayAction
 		var configBytes []byte
-		if err := rows.Scan(&act.ActionType, &configBytes, &act.OrderIndex); err != nil {
+		if err := rows.Scan(&act.ID, &act.ActionType, &configBytes, &act.OrderIndex, &act.AgentGroup, &act.AgentLabels, &act.MinAgentVersion, &act.OnFailure, &act.Optional); err != nil {
 			return nil, fmt.Errorf("scan action: %w", err)
 		}
 		if err := json.Unmarshal(configBytes, &act.Config); err != nil {
@@ -66,35 +125,814 @@ func (s *Store) GetRelayActions(ctx context.Context, relayID string) ([]RelayAct
 	return actions, nil
 }
 
-func (s *Store) RegisterEvent(ctx context.Context, relayID, eventID string) (bool, error) {
+// Records the event as processed for window. Reports false when it was already
+// recorded and that record hasn't expired
+func (s *Store) RegisterEvent(ctx context.Context, relayID, eventID string, window time.Duration) (bool, error) {
 	if eventID == "" {
 		return true, nil
 	}
-	query := `INSERT INTO processed_events (relay_id, event_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`
-	tag, err := s.db.Exec(ctx, query, relayID, eventID)
+	query := `INSERT INTO processed_events (relay_id, event_id, expires_at)
+	VALUES ($1,$2,NOW() + make_interval(secs => $3))
+	ON CONFLICT (relay_id, event_id) DO UPDATE SET received_at = NOW(), expires_at = EXCLUDED.expires_at
+	WHERE processed_events.expires_at <= NOW()`
+	tag, err := s.db.Exec(ctx, query, relayID, eventID, window.Seconds())
 	if err != nil {
 		return false, fmt.Errorf("dedupe insert failed: %w", err)
 	}
 	return tag.RowsAffected() > 0, nil
 }
 
-func (s *Store) LogExecution(ctx context.Context, relayID string, eventID string, status string, details string, payload []byte) error {
-	query := `INSERT INTO execution_logs(relay_id, event_id, status,payload,error_message,executed_at)
-	VALUES($1,$2,$3,$4,$5,NOW())`
+// Outcome of a single action within an execution
+type ExecutionStep struct {
+	ActionID     string
+	ActionType   string
+	OrderIndex   int
+	Status       string
+	Duration     time.Duration
+	ErrorMessage string
+	Response     string
+	StartedAt    time.Time
+}
 
-	var payloadJSON any
-	if len(payload) > 0 {
-		payloadJSON = json.RawMessage(payload)
-	}
+// One finished execution and its steps
+type ExecutionLog struct {
+	RelayID string
+	EventID string
+	TraceID string
+	Status  string
+	Details string
+	Payload []byte
+	Steps   []ExecutionStep
+	// Rows are stamped this long before the write, against the database clock
+	FinishedAt time.Time
+}
 
+// Inserts the log and its steps in one statement, so a batch needs no round trip per log.
+// Steps carry the log's executed_at, which puts them in the same day's partition
+const executionLogQuery = `WITH log AS (
+	INSERT INTO execution_logs (relay_id, event_id, trace_id, status, payload, payload_sealed, payload_key, error_message, executed_at)
+	VALUES ($1,$2,NULLIF($3,''),$4,$5,$16,$17,$6,NOW() - make_interval(secs => $7))
+	RETURNING id, executed_at
+)
+INSERT INTO execution_steps (execution_log_id, action_id, action_type, order_index, status, duration_ms, error_message, response, started_at, executed_at)
+SELECT log.id, NULLIF(s.action_id,'')::uuid, s.action_type, s.order_index, s.status, s.duration_ms,
+	NULLIF(s.error_message,''), NULLIF(s.response,''), s.started_at, log.executed_at
+FROM log, unnest($8::text[], $9::text[], $10::int[], $11::text[], $12::bigint[], $13::text[], $14::text[], $15::timestamp[])
+	AS s(action_id, action_type, order_index, status, duration_ms, error_message, response, started_at)`
+
+func executionLogArgs(l ExecutionLog, payload storedPayload) []any {
 	var errorMessage any
-	if status != "success" && details != "" {
-		errorMessage = details
+	if l.Status != "success" && l.Details != "" {
+		errorMessage = l.Details
+	}
+	age := 0.0
+	if !l.FinishedAt.IsZero() {
+		age = max(time.Since(l.FinishedAt).Seconds(), 0)
+	}
+	n := len(l.Steps)
+	actionIDs, types, statuses := make([]string, n), make([]string, n), make([]string, n)
+	errs, responses := make([]string, n), make([]string, n)
+	orders, durations := make([]int32, n), make([]int64, n)
+	started := make([]time.Time, n)
+	for i, step := range l.Steps {
+		actionIDs[i], types[i], statuses[i] = step.ActionID, step.ActionType, step.Status
+		errs[i], responses[i] = step.ErrorMessage, step.Response
+		orders[i], durations[i] = int32(step.OrderIndex), step.Duration.Milliseconds()
+		started[i] = step.StartedAt
+	}
+	return []any{l.RelayID, l.EventID, l.TraceID, l.Status, payload.plain, errorMessage, age,
+		actionIDs, types, orders, statuses, durations, errs, responses, started, payload.sealed, payload.key}
+}
+
+// Writes a batch of execution logs and their steps in one transaction
+func (s *Store) WriteExecutionLogs(ctx context.Context, logs []ExecutionLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	// Sealed first, a new workspace key is created outside the transaction
+	batch := &pgx.Batch{}
+	for _, l := range logs {
+		payload, err := s.sealPayload(ctx, l.RelayID, l.Payload)
+		if err != nil {
+			return err
+		}
+		batch.Queue(executionLogQuery, executionLogArgs(l, payload)...)
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to write execution logs: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Forgets a processed event so a redelivery or requeue of it is executed again
+func (s *Store) ReleaseEvent(ctx context.Context, relayID, eventID string) error {
+	if eventID == "" {
+		return nil
+	}
+	query := `DELETE FROM processed_events WHERE relay_id = $1 AND event_id = $2`
+	if _, err := s.db.Exec(ctx, query, relayID, eventID); err != nil {
+		return fmt.Errorf("dedupe release failed: %w", err)
+	}
+	return nil
+}
+
+// Deletes up to limit expired dedupe records. SKIP LOCKED lets several
+// instances prune at once without waiting on each other
+func (s *Store) PruneProcessedEvents(ctx context.Context, limit int) (int64, error) {
+	query := `DELETE FROM processed_events WHERE ctid IN (
+		SELECT ctid FROM processed_events WHERE expires_at <= NOW()
+		LIMIT $1 FOR UPDATE SKIP LOCKED)`
+	tag, err := s.db.Exec(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("dedupe prune failed: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Deletes cancellations older than age, by when a redelivery of the event
+// isn't expected any more
+func (s *Store) PruneExecutionCancellations(ctx context.Context, age time.Duration) (int64, error) {
+	query := `DELETE FROM execution_cancellations WHERE requested_at < NOW() - make_interval(secs => $1)`
+	tag, err := s.db.Exec(ctx, query, age.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("cancellation prune failed: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Planner estimate of the dedupe table's rows, cheap on a large table
+func (s *Store) ProcessedEventsEstimate(ctx context.Context) (int64, error) {
+	var rows float64
+	query := `SELECT GREATEST(reltuples, 0) FROM pg_class WHERE oid = 'processed_events'::regclass`
+	if err := s.db.QueryRow(ctx, query).Scan(&rows); err != nil {
+		return 0, fmt.Errorf("dedupe size lookup failed: %w", err)
+	}
+	return int64(rows), nil
+}
+
+// Reports whether hermes-core recorded a cancellation for the event
+func (s *Store) ExecutionCancelled(ctx context.Context, relayID, eventID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM execution_cancellations WHERE relay_id = $1 AND event_id = $2)`
+	var cancelled bool
+	if err := s.db.QueryRow(ctx, query, relayID, eventID).Scan(&cancelled); err != nil {
+		return false, fmt.Errorf("cancellation lookup failed: %w", err)
+	}
+	return cancelled, nil
+}
+
+func (s *Store) SaveDeadLetter(ctx context.Context, relayID, eventID, traceparent, reason string, attempts int, payload []byte) error {
+	query := `INSERT INTO dead_letters (relay_id, event_id, payload, payload_sealed, payload_key, reason, attempts, traceparent)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,NULLIF($8,''))`
+
+	stored, err := s.sealPayload(ctx, relayID, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(ctx, query, relayID, eventID, stored.plain, stored.sealed, stored.key, reason, attempts, traceparent); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// Events still parked in the dead letter queue, not yet requeued or discarded
+func (s *Store) CountDeadLetters(ctx context.Context) (int64, error) {
+	var n int64
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM dead_letters WHERE status = 'dead'`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("dead letter count failed: %w", err)
+	}
+	return n, nil
+}
+
+// What became of an event, as far as a caller waiting on it can tell
+type CallOutcome struct {
+	// Status of the event's latest execution log
+	Status string
+	// Set once the event was dead-lettered, Message then holds the reason
+	DeadLettered bool
+	Message      string
+}
+
+// Latest outcome of a relay's event, nil while it hasn't run yet
+func (s *Store) CallOutcome(ctx context.Context, relayID, eventID string) (*CallOutcome, error) {
+	var out CallOutcome
+	err := s.db.QueryRow(ctx, `SELECT reason FROM dead_letters WHERE relay_id = $1 AND event_id = $2
+	ORDER BY created_at DESC LIMIT 1`, relayID, eventID).Scan(&out.Message)
+	if err == nil {
+		out.DeadLettered = true
+		return &out, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("query dead letters: %w", err)
 	}
+	err = s.db.QueryRow(ctx, `SELECT status, COALESCE(error_message, '') FROM execution_logs
+	WHERE relay_id = $1 AND event_id = $2
+	ORDER BY executed_at DESC LIMIT 1`, relayID, eventID).Scan(&out.Status, &out.Message)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query execution logs: %w", err)
+	}
+	return &out, nil
+}
+
+// Outcome of an action run on an agent. Status stays pending/claimed until the agent reports
+type AgentJobResult struct {
+	Status       string
+	Output       string
+	ErrorMessage string
+}
+
+func (s *Store) CreateAgentJob(ctx context.Context, relayID, eventID, traceparent, idempotencyKey string, act RelayAction, payload []byte, expiresAt time.Time) (string, error) {
+	query := `INSERT INTO agent_jobs (relay_id, event_id, agent_group, action_type, config, payload, payload_sealed, payload_key,
+		expires_at, agent_labels, min_agent_version, traceparent, idempotency_key)
+	VALUES ($1,NULLIF($2,''),$3,$4,$5,$6,$7,$8,$9,$10,NULLIF($11,''),NULLIF($12,''),NULLIF($13,''))
+	RETURNING id`
+
+	configJSON, err := json.Marshal(act.Config)
+	if err != nil {
+		return "", fmt.Errorf("marshal action config: %w", err)
+	}
+	// hermes-core opens it again when an agent claims the job
+	stored, err := s.sealPayload(ctx, relayID, payload)
+	if err != nil {
+		return "", err
+	}
+	labels := act.AgentLabels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	var id string
+	err = s.db.QueryRow(ctx, query, relayID, eventID, act.AgentGroup, act.ActionType, configJSON, stored.plain, stored.sealed, stored.key,
+		expiresAt, labels, act.MinAgentVersion, traceparent, idempotencyKey).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("insert agent job: %w", err)
+	}
+	return id, nil
+}
+
+func (s *Store) GetAgentJobResult(ctx context.Context, id string) (*AgentJobResult, error) {
+	query := `SELECT status, COALESCE(output, ''), COALESCE(error_message, '') FROM agent_jobs WHERE id = $1`
+	var res AgentJobResult
+	if err := s.db.QueryRow(ctx, query, id).Scan(&res.Status, &res.Output, &res.ErrorMessage); err != nil {
+		return nil, fmt.Errorf("query agent job: %w", err)
+	}
+	return &res, nil
+}
+
+// Marks a job nobody finished in time so a late agent can't claim or complete it
+func (s *Store) ExpireAgentJob(ctx context.Context, id string) error {
+	query := `UPDATE agent_jobs SET status = 'expired', completed_at = NOW()
+	WHERE id = $1 AND status IN ('pending', 'claimed')`
+	if _, err := s.db.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("expire agent job: %w", err)
+	}
+	return nil
+}
+
+// Per-relay scheduling settings the worker enforces
+type RelaySettings struct {
+	Priority string
+	// 0 means unlimited, 1 means events run one at a time in order
+	MaxConcurrency int
+	// Zero disables debounce/throttle. ThrottleMode is "drop" or "queue"
+	DebounceSeconds int
+	ThrottleSeconds int
+	ThrottleMode    string
+	// Budget for all actions of one execution, 0 for none
+	TimeoutSeconds int
+	// How long event IDs are remembered, 0 for the worker default
+	DedupeWindowSeconds int
+	// Owner of the relay, whose tenant quota its executions count against
+	UserID string
+	// Key the HTTP requests its actions send are signed with, empty when they
+	// go out unsigned
+	SigningKey secrets.Sealed
+}
+
+func (s *Store) GetRelaySettings(ctx context.Context, relayID string) (*RelaySettings, error) {
+	var rs RelaySettings
+	query := `SELECT priority, max_concurrency, debounce_seconds, throttle_seconds, throttle_mode, timeout_seconds,
+	dedupe_window_seconds, user_id::text, signing_key, signing_data_key FROM relays WHERE id = $1`
+	err := s.db.QueryRow(ctx, query, relayID).Scan(&rs.Priority, &rs.MaxConcurrency,
+		&rs.DebounceSeconds, &rs.ThrottleSeconds, &rs.ThrottleMode, &rs.TimeoutSeconds, &rs.DedupeWindowSeconds, &rs.UserID,
+		&rs.SigningKey.Ciphertext, &rs.SigningKey.DataKey)
+	if err == pgx.ErrNoRows {
+		return nil, ErrRelayNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query relay settings: %w", err)
+	}
+	return &rs, nil
+}
+
+// An uploaded WASM plugin, without its module bytes
+type PluginInfo struct {
+	Name          string
+	SHA256        string
+	MemoryLimitMB int
+	TimeoutMs     int
+}
 
-	_, err := s.db.Exec(ctx, query, relayID, eventID, status, payloadJSON, errorMessage)
+func (s *Store) ListPlugins(ctx context.Context) ([]PluginInfo, error) {
+	rows, err := s.db.Query(ctx, `SELECT name, sha256, memory_limit_mb, timeout_ms FROM plugins`)
 	if err != nil {
-		return fmt.Errorf("failed to write execution log: %w", err)
+		return nil, fmt.Errorf("query plugins: %w", err)
+	}
+	defer rows.Close()
+
+	var plugins []PluginInfo
+	for rows.Next() {
+		var p PluginInfo
+		if err := rows.Scan(&p.Name, &p.SHA256, &p.MemoryLimitMB, &p.TimeoutMs); err != nil {
+			return nil, fmt.Errorf("scan plugin: %w", err)
+		}
+		plugins = append(plugins, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return plugins, nil
+}
+
+func (s *Store) GetPluginModule(ctx context.Context, name string) ([]byte, error) {
+	var module []byte
+	err := s.db.QueryRow(ctx, `SELECT module FROM plugins WHERE name = $1`, name).Scan(&module)
+	if err != nil {
+		return nil, fmt.Errorf("query plugin module: %w", err)
+	}
+	return module, nil
+}
+
+// Returns a secret owned by the relay's user, its sealed value or where a backend keeps it
+func (s *Store) GetSecret(ctx context.Context, relayID, name string) (secrets.Stored, error) {
+	var stored secrets.Stored
+	query := `SELECT s.backend, COALESCE(s.reference, ''), s.ciphertext, s.data_key FROM secrets s
+	JOIN relays r ON r.user_id = s.user_id
+	WHERE r.id = $1 AND s.name = $2`
+	err := s.db.QueryRow(ctx, query, relayID, name).
+		Scan(&stored.Backend, &stored.Reference, &stored.Sealed.Ciphertext, &stored.Sealed.DataKey)
+	if err == pgx.ErrNoRows {
+		return stored, secrets.ErrNotFound
+	}
+	if err != nil {
+		return stored, fmt.Errorf("query secret: %w", err)
+	}
+	return stored, nil
+}
+
+// Returns the sealed access token of an OAuth connection owned by the relay's
+// user, and whether it has expired
+func (s *Store) GetConnectionToken(ctx context.Context, relayID, id string) (secrets.Sealed, bool, error) {
+	var sealed secrets.Sealed
+	var expired bool
+	query := `SELECT c.access_token, c.access_key, COALESCE(c.expires_at <= NOW(), false) FROM oauth_connections c
+	JOIN relays r ON r.user_id = c.user_id
+	WHERE r.id = $1 AND c.id::text = $2`
+	err := s.db.QueryRow(ctx, query, relayID, id).Scan(&sealed.Ciphertext, &sealed.DataKey, &expired)
+	if err == pgx.ErrNoRows {
+		return sealed, false, ErrConnectionNotFound
+	}
+	if err != nil {
+		return sealed, false, fmt.Errorf("query connection: %w", err)
+	}
+	return sealed, expired, nil
+}
+
+// Events an aggregate action collected, flushed together
+type AggregateBatch struct {
+	RelayID    string
+	ActionID   string
+	OrderIndex int
+	EventIDs   []string
+	Payloads   []json.RawMessage
+}
+
+// Holds an event for an aggregate action. Redeliveries of the same event are ignored
+func (s *Store) BufferAggregateEvent(ctx context.Context, relayID string, act RelayAction, eventID string, payload []byte, maxEvents int, window time.Duration) error {
+	query := `INSERT INTO aggregate_events (relay_id, action_id, order_index, event_id, payload, payload_sealed, payload_key, max_events, window_ms)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	ON CONFLICT (action_id, event_id) DO NOTHING`
+
+	stored, err := s.sealPayload(ctx, relayID, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(ctx, query, relayID, act.ID, act.OrderIndex, eventID, stored.plain, stored.sealed, stored.key,
+		maxEvents, window.Milliseconds()); err != nil {
+		return fmt.Errorf("buffer aggregate event: %w", err)
+	}
+	return nil
+}
+
+// Aggregate actions whose batch is full or whose window has elapsed
+func (s *Store) DueAggregates(ctx context.Context) ([]string, error) {
+	query := `SELECT action_id FROM aggregate_events
+	GROUP BY action_id
+	HAVING COUNT(*) >= MAX(max_events)
+	OR MIN(created_at) + MAX(window_ms) * INTERVAL '1 millisecond' <= NOW()`
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query due aggregates: %w", err)
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan aggregate: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return ids, nil
+}
+
+// Claims up to max_events of an action's oldest buffered events and hands them
+// to flush. They are only removed if flush succeeds, so a failed flush is retried.
+// Returns nil without calling flush when another worker holds the events
+func (s *Store) FlushAggregate(ctx context.Context, actionID string, flush func(*AggregateBatch) error) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `DELETE FROM aggregate_events
+	WHERE id IN (
+		SELECT id FROM aggregate_events
+		WHERE action_id = $1
+		ORDER BY created_at ASC
+		LIMIT (SELECT MAX(max_events) FROM aggregate_events WHERE action_id = $1)
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING relay_id, order_index, event_id, payload, payload_sealed,
+		(SELECT k.data_key FROM workspace_keys k WHERE k.id = payload_key), created_at`, actionID)
+	if err != nil {
+		return fmt.Errorf("claim aggregate events: %w", err)
+	}
+	type buffered struct {
+		eventID   string
+		payload   []byte
+		sealed    []byte
+		dataKey   []byte
+		createdAt time.Time
+	}
+	batch := &AggregateBatch{ActionID: actionID}
+	events := make([]buffered, 0)
+	for rows.Next() {
+		var e buffered
+		if err := rows.Scan(&batch.RelayID, &batch.OrderIndex, &e.eventID, &e.payload, &e.sealed, &e.dataKey, &e.createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan aggregate event: %w", err)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %w", err)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	// RETURNING order isn't guaranteed, keep arrival order in the batch
+	sort.Slice(events, func(i, j int) bool { return events[i].createdAt.Before(events[j].createdAt) })
+	for _, e := range events {
+		opened, err := s.openPayload(ctx, e.payload, e.sealed, e.dataKey)
+		if err != nil {
+			return fmt.Errorf("aggregate event %s: %w", e.eventID, err)
+		}
+		batch.EventIDs = append(batch.EventIDs, e.eventID)
+		payload := json.RawMessage(opened)
+		if len(payload) == 0 {
+			payload = json.RawMessage("null")
+		}
+		batch.Payloads = append(batch.Payloads, payload)
+	}
+
+	if err := flush(batch); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+const (
+	HoldDebounce = "debounce"
+	HoldThrottle = "throttle"
+	HoldDeferred = "deferred"
+	HoldRetry    = "retry"
+)
+
+// Event parked by debounce, throttle, an open circuit or a failed attempt until its release time
+type HeldEvent struct {
+	RelayID     string
+	EventID     string
+	Payload     []byte
+	Reason      string
+	Traceparent string
+	// Delivery attempts already used, carried over so a deferral doesn't reset them
+	Attempts    int
+	ResumeAfter *int
+	CallChain   []string
+	// Why the last attempt failed, set on retries
+	LastError string
+}
+
+// Holds the relay's latest event until it has been quiet for the given time.
+// A newer event replaces the held one and restarts the wait
+func (s *Store) HoldDebounced(ctx context.Context, held HeldEvent, quiet time.Duration) error {
+	query := `INSERT INTO held_events (relay_id, event_id, traceparent, payload, payload_sealed, payload_key, reason, release_at, call_chain)
+	VALUES ($1,$2,NULLIF($3,''),$4,$5,$6,'debounce',NOW() + $7 * INTERVAL '1 millisecond',$8)
+	ON CONFLICT (relay_id) WHERE reason = 'debounce'
+	DO UPDATE SET event_id = EXCLUDED.event_id, traceparent = EXCLUDED.traceparent,
+		payload = EXCLUDED.payload, payload_sealed = EXCLUDED.payload_sealed, payload_key = EXCLUDED.payload_key,
+		release_at = EXCLUDED.release_at, call_chain = EXCLUDED.call_chain`
+
+	stored, err := s.sealPayload(ctx, held.RelayID, held.Payload)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(ctx, query, held.RelayID, held.EventID, held.Traceparent, stored.plain, stored.sealed, stored.key,
+		quiet.Milliseconds(), held.CallChain); err != nil {
+		return fmt.Errorf("hold debounced event: %w", err)
+	}
+	return nil
+}
+
+// Claims the relay's throttle slot if the interval since the last run has passed
+func (s *Store) TryThrottle(ctx context.Context, relayID string, interval time.Duration) (bool, error) {
+	query := `INSERT INTO relay_throttle (relay_id, next_at) VALUES ($1, NOW() + $2 * INTERVAL '1 millisecond')
+	ON CONFLICT (relay_id) DO UPDATE SET next_at = EXCLUDED.next_at
+	WHERE relay_throttle.next_at <= NOW()`
+	tag, err := s.db.Exec(ctx, query, relayID, interval.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("throttle relay: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Reserves the relay's next free throttle slot for held's event and, unless
+// the slot is now, holds the event until slotAt. A redelivered event gets the
+// slot it already reserved instead of taking another one
+func (s *Store) ReserveThrottleSlot(ctx context.Context, held HeldEvent, interval time.Duration) (bool, time.Time, error) {
+	stored, err := s.sealPayload(ctx, held.RelayID, held.Payload)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var slotAt time.Time
+	err = tx.QueryRow(ctx, `SELECT release_at FROM held_events
+	WHERE relay_id = $1 AND event_id = $2 AND reason = 'throttle'`, held.RelayID, held.EventID).Scan(&slotAt)
+	if err == nil {
+		return false, slotAt, nil
+	}
+	if err != pgx.ErrNoRows {
+		return false, time.Time{}, fmt.Errorf("find held event: %w", err)
+	}
+
+	query := `INSERT INTO relay_throttle AS t (relay_id, next_at, last_event_id)
+	VALUES ($1, NOW() + $2 * INTERVAL '1 millisecond', $3)
+	ON CONFLICT (relay_id) DO UPDATE SET last_event_id = EXCLUDED.last_event_id,
+		next_at = CASE WHEN t.last_event_id = EXCLUDED.last_event_id THEN t.next_at
+			ELSE GREATEST(t.next_at, NOW()) + $2 * INTERVAL '1 millisecond' END
+	RETURNING t.next_at - $2 * INTERVAL '1 millisecond', t.next_at - $2 * INTERVAL '1 millisecond' <= NOW()`
+	var now bool
+	if err := tx.QueryRow(ctx, query, held.RelayID, interval.Milliseconds(), held.EventID).Scan(&slotAt, &now); err != nil {
+		return false, time.Time{}, fmt.Errorf("reserve throttle slot: %w", err)
+	}
+	if !now {
+		held.Reason = HoldThrottle
+		if err := holdEvent(ctx, tx, held, stored, slotAt); err != nil {
+			return false, time.Time{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, time.Time{}, fmt.Errorf("commit transaction: %w", err)
+	}
+	return now, slotAt, nil
+}
+
+// Holds an event until releaseAt. Holding an event that is already held is a no-op
+func (s *Store) HoldEvent(ctx context.Context, held HeldEvent, releaseAt time.Time) error {
+	stored, err := s.sealPayload(ctx, held.RelayID, held.Payload)
+	if err != nil {
+		return err
+	}
+	return holdEvent(ctx, s.db, held, stored, releaseAt)
+}
+
+// Either the pool or a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// payload is held's payload as sealPayload stores it
+func holdEvent(ctx context.Context, db execer, held HeldEvent, payload storedPayload, releaseAt time.Time) error {
+	query := `INSERT INTO held_events (relay_id, event_id, traceparent, payload, payload_sealed, payload_key, reason, release_at,
+		attempts, resume_after, call_chain, last_error)
+	VALUES ($1,$2,NULLIF($3,''),$4,$5,$6,$7,$8,$9,$10,$11,NULLIF($12,''))
+	ON CONFLICT (relay_id, event_id) WHERE reason <> 'debounce' DO NOTHING`
+
+	if _, err := db.Exec(ctx, query, held.RelayID, held.EventID, held.Traceparent, payload.plain, payload.sealed, payload.key,
+		held.Reason, releaseAt, held.Attempts, held.ResumeAfter, held.CallChain, held.LastError); err != nil {
+		return fmt.Errorf("hold event: %w", err)
+	}
+	return nil
+}
+
+// Claims one held event that is due and hands it to release. It is only
+// removed if release succeeds. Reports whether an event was found
+func (s *Store) ReleaseHeldEvent(ctx context.Context, release func(*HeldEvent) error) (bool, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var held HeldEvent
+	var sealed, dataKey []byte
+	err = tx.QueryRow(ctx, `DELETE FROM held_events
+	WHERE id = (
+		SELECT id FROM held_events
+		WHERE release_at <= NOW()
+		ORDER BY release_at ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING relay_id, event_id, payload, payload_sealed, (SELECT k.data_key FROM workspace_keys k WHERE k.id = payload_key),
+		reason, COALESCE(traceparent, ''), attempts, resume_after, call_chain`).Scan(&held.RelayID, &held.EventID,
+		&held.Payload, &sealed, &dataKey, &held.Reason, &held.Traceparent, &held.Attempts, &held.ResumeAfter, &held.CallChain)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim held event: %w", err)
+	}
+	if held.Payload, err = s.openPayload(ctx, held.Payload, sealed, dataKey); err != nil {
+		return false, fmt.Errorf("held event %s: %w", held.EventID, err)
+	}
+	if err := release(&held); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// What a worker instance reports about itself on every heartbeat
+type Heartbeat struct {
+	InstanceID string
+	Hostname   string
+	Version    string
+	StartedAt  time.Time
+	Interval   time.Duration
+	Workers    int
+	ActiveJobs int
+	QueuedJobs int
+	ParkedJobs int
+	// Messages waiting in the broker for the shared consumer
+	QueueLag int
+	Draining bool
+}
+
+func (s *Store) RecordHeartbeat(ctx context.Context, hb Heartbeat) error {
+	query := `INSERT INTO worker_instances (id, hostname, version, started_at, last_seen_at, heartbeat_interval_seconds,
+		workers, active_jobs, queued_jobs, parked_jobs, queue_lag, draining)
+	VALUES ($1,$2,$3,$4,NOW(),$5,$6,$7,$8,$9,$10,$11)
+	ON CONFLICT (id) DO UPDATE SET hostname = EXCLUDED.hostname, version = EXCLUDED.version,
+		started_at = EXCLUDED.started_at, last_seen_at = NOW(),
+		heartbeat_interval_seconds = EXCLUDED.heartbeat_interval_seconds, workers = EXCLUDED.workers,
+		active_jobs = EXCLUDED.active_jobs, queued_jobs = EXCLUDED.queued_jobs, parked_jobs = EXCLUDED.parked_jobs,
+		queue_lag = EXCLUDED.queue_lag, draining = EXCLUDED.draining, stopped_at = NULL`
+	seconds := max(int(hb.Interval.Round(time.Second)/time.Second), 1)
+	if _, err := s.db.Exec(ctx, query, hb.InstanceID, hb.Hostname, hb.Version, hb.StartedAt, seconds,
+		hb.Workers, hb.ActiveJobs, hb.QueuedJobs, hb.ParkedJobs, hb.QueueLag, hb.Draining); err != nil {
+		return fmt.Errorf("record heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Marks a cleanly shut down instance so it isn't mistaken for a crashed one
+func (s *Store) MarkWorkerStopped(ctx context.Context, instanceID string) error {
+	query := `UPDATE worker_instances SET stopped_at = NOW(), last_seen_at = NOW(), draining = FALSE,
+		active_jobs = 0, queued_jobs = 0, parked_jobs = 0 WHERE id = $1`
+	if _, err := s.db.Exec(ctx, query, instanceID); err != nil {
+		return fmt.Errorf("mark worker stopped: %w", err)
+	}
+	return nil
+}
+
+// Limits on one user's executions across every worker instance. Zero means unlimited
+type TenantLimits struct {
+	MaxConcurrency         int
+	MaxExecutionsPerMinute int
+}
+
+// Outcome of asking for a tenant slot. SlotID is empty when the user has no
+// concurrency limit. RetryAfter is set when the per-minute limit refused the
+// execution, to when the next minute starts
+type TenantAdmission struct {
+	Admitted   bool
+	SlotID     string
+	RetryAfter time.Duration
+}
+
+// Advisory lock class serialising admissions per user, keyed by the user ID's hash
+const tenantLockClass int32 = 0x6865
+
+// Starts an execution for the user unless it would take them past their
+// limits: the tenant_quotas row when they have one, defaults otherwise. The
+// slot is held until ReleaseTenantSlot or lease, whichever comes first
+func (s *Store) AdmitTenant(ctx context.Context, userID, relayID, eventID string, defaults TenantLimits, lease time.Duration) (TenantAdmission, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return TenantAdmission{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, tenantLockClass, userID); err != nil {
+		return TenantAdmission{}, fmt.Errorf("lock tenant: %w", err)
+	}
+	limits := defaults
+	err = tx.QueryRow(ctx, `SELECT COALESCE(max_concurrency, $2), COALESCE(max_executions_per_minute, $3)
+	FROM tenant_quotas WHERE user_id = $1`, userID, defaults.MaxConcurrency, defaults.MaxExecutionsPerMinute).
+		Scan(&limits.MaxConcurrency, &limits.MaxExecutionsPerMinute)
+	if err != nil && err != pgx.ErrNoRows {
+		return TenantAdmission{}, fmt.Errorf("query tenant quota: %w", err)
+	}
+
+	if limits.MaxConcurrency > 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM tenant_slots WHERE user_id = $1 AND expires_at <= NOW()`, userID); err != nil {
+			return TenantAdmission{}, fmt.Errorf("expire tenant slots: %w", err)
+		}
+		var running int
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM tenant_slots WHERE user_id = $1`, userID).Scan(&running); err != nil {
+			return TenantAdmission{}, fmt.Errorf("count tenant slots: %w", err)
+		}
+		if running >= limits.MaxConcurrency {
+			return TenantAdmission{}, nil
+		}
+	}
+	if limits.MaxExecutionsPerMinute > 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM tenant_rate WHERE user_id = $1 AND minute < date_trunc('minute', NOW())`, userID); err != nil {
+			return TenantAdmission{}, fmt.Errorf("expire tenant rate: %w", err)
+		}
+		var started int
+		var untilNextMs int64
+		err := tx.QueryRow(ctx, `SELECT COALESCE(max(executions), 0),
+			(EXTRACT(EPOCH FROM date_trunc('minute', NOW()) + INTERVAL '1 minute' - NOW()) * 1000)::bigint
+		FROM tenant_rate WHERE user_id = $1`, userID).Scan(&started, &untilNextMs)
+		if err != nil {
+			return TenantAdmission{}, fmt.Errorf("read tenant rate: %w", err)
+		}
+		if started >= limits.MaxExecutionsPerMinute {
+			return TenantAdmission{RetryAfter: time.Duration(untilNextMs) * time.Millisecond}, nil
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO tenant_rate (user_id, minute, executions) VALUES ($1, date_trunc('minute', NOW()), 1)
+		ON CONFLICT (user_id, minute) DO UPDATE SET executions = tenant_rate.executions + 1`, userID); err != nil {
+			return TenantAdmission{}, fmt.Errorf("count tenant execution: %w", err)
+		}
+	}
+
+	admission := TenantAdmission{Admitted: true}
+	if limits.MaxConcurrency > 0 {
+		err := tx.QueryRow(ctx, `INSERT INTO tenant_slots (user_id, relay_id, event_id, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond') RETURNING id::text`,
+			userID, relayID, eventID, lease.Milliseconds()).Scan(&admission.SlotID)
+		if err != nil {
+			return TenantAdmission{}, fmt.Errorf("take tenant slot: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return TenantAdmission{}, fmt.Errorf("commit transaction: %w", err)
+	}
+	return admission, nil
+}
+
+func (s *Store) ReleaseTenantSlot(ctx context.Context, slotID string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM tenant_slots WHERE id = $1`, slotID); err != nil {
+		return fmt.Errorf("release tenant slot: %w", err)
 	}
 	return nil
 }